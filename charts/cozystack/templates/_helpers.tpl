@@ -1,12 +1,18 @@
 {{- define "talos.config" }}
 machine:
   type: {{ .MachineType }}
+  certSANs: {{ include "talm.cert_sans" . }}
+  {{- include "talm.node_metadata" . | nindent 2 }}
   kubelet:
     nodeIP:
       validSubnets:
-        {{- toYaml .Values.advertisedSubnets | nindent 8 }}
+        {{- include "talm.advertised_subnets" . | nindent 8 }}
     extraConfig:
+      {{- with include "talm.kubelet.resources" . }}
+      {{- . | nindent 6 }}
+      {{- else }}
       maxPods: 512
+      {{- end }}
   kernel:
     modules:
     - name: openvswitch
@@ -23,37 +29,34 @@ machine:
     path: /etc/cri/conf.d/20-customization.part
     op: create
   install:
-    {{- with .Values.image }}
+    {{- with include "talm.installer_image" . }}
     image: {{ . }}
     {{- end }}
     {{- (include "talm.discovered.disks_info" .) | nindent 4 }}
     disk: {{ include "talm.discovered.system_disk_name" . | quote }}
+  {{- with include "talm.secureboot.disk_encryption" . }}
+  {{- . | nindent 2 }}
+  {{- end }}
+  {{- with include "talm.static_pods" . }}
+  {{- . | nindent 2 }}
+  {{- end }}
   network:
     hostname: {{ include "talm.discovered.hostname" . | quote }}
     nameservers: {{ include "talm.discovered.default_resolvers" . }}
     {{- (include "talm.discovered.physical_links_info" .) | nindent 4 }}
     interfaces:
-    - deviceSelector:
-        {{- include "talm.discovered.default_link_selector_by_gateway" . | nindent 8 }}
-      addresses: {{ include "talm.discovered.default_addresses_by_gateway" . }}
-      routes:
-        - network: 0.0.0.0/0
-          gateway: {{ include "talm.discovered.default_gateway" . }}
-      {{- with .Values.floatingIP }}
-      vip:
-        ip: {{ . }}
-      {{- end }}
+    {{- include "talm.network.default_interface" . | nindent 4 }}
+    {{- include "talm.network.check_discovered" . }}
 
 
 cluster:
   network:
-    cni:
-      name: none
+    {{- include "talm.cni" . | nindent 4 }}
     dnsDomain: {{ .Values.clusterDomain }}
     podSubnets:
-      {{- toYaml .Values.podSubnets | nindent 6 }}
+      {{- .Values.podSubnets | assertCIDR "podSubnets" | toYaml | nindent 6 }}
     serviceSubnets:
-      {{- toYaml .Values.serviceSubnets | nindent 6 }}
+      {{- .Values.serviceSubnets | assertCIDR "serviceSubnets" | toYaml | nindent 6 }}
   clusterName: "{{ .Chart.Name }}"
   controlPlane:
     endpoint: "{{ .Values.endpoint }}"
@@ -66,14 +69,18 @@ cluster:
     extraArgs:
       bind-address: 0.0.0.0
   apiServer:
-    certSANs:
-    - 127.0.0.1
+    certSANs: {{ include "talm.cert_sans" . }}
+  {{- if include "talm.kube_proxy_disabled" . }}
   proxy:
     disabled: true
+  {{- end }}
   discovery:
     enabled: false
+  {{- with include "talm.manifests" . }}
+  {{- . | nindent 2 }}
+  {{- end }}
   etcd:
     advertisedSubnets:
-      {{- toYaml .Values.advertisedSubnets | nindent 6 }}
+      {{- include "talm.advertised_subnets" . | nindent 6 }}
   {{- end }}
 {{- end }}
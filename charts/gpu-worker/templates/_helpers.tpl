@@ -0,0 +1,113 @@
+{{- /*
+The installer image of the workers must include the NVIDIA extensions: the schematic of the
+values builds it with the image factory, without schematic image is a custom installer image.
+*/}}
+{{- define "gpu.check_extensions" }}
+{{- if eq .MachineType "worker" }}
+{{- if installerImage .Values }}
+{{- $extensions := (.Values.schematic | default dict).extensions | default list }}
+{{- range list "siderolabs/nonfree-kmod-nvidia" "siderolabs/nvidia-container-toolkit" }}
+{{- if not (has . $extensions) }}
+{{- fail (printf "schematic.extensions of the GPU workers must include %s" .) }}
+{{- end }}
+{{- end }}
+{{- else if not .Values.image }}
+{{- fail "the GPU workers need the NVIDIA extensions, add them to schematic.extensions or set image to an installer image including them" }}
+{{- end }}
+{{- end }}
+{{- end }}
+
+{{- /*
+Manifests of the values with the RuntimeClass of the nvidia runtime handler.
+*/}}
+{{- define "gpu.manifests" }}
+{{- $manifests := deepCopy (.Values.manifests | default dict) }}
+{{- with .Values.nvidia.runtimeClass }}
+{{- $runtimeClass := dict "apiVersion" "node.k8s.io/v1" "kind" "RuntimeClass" "metadata" (dict "name" .) "handler" "nvidia" }}
+{{- $_ := set $manifests "inline" (append ($manifests.inline | default list) (dict "name" "nvidia-runtime-class" "contents" (toYaml $runtimeClass))) }}
+{{- end }}
+{{- include "talm.manifests" (merge (dict "Values" (merge (dict "manifests" $manifests) .Values)) .) }}
+{{- end }}
+
+{{- define "talos.config" }}
+{{- include "gpu.check_extensions" . }}
+machine:
+  type: {{ .MachineType }}
+  certSANs: {{ include "talm.cert_sans" . }}
+  {{- include "talm.node_metadata" . | nindent 2 }}
+  kubelet:
+    nodeIP:
+      validSubnets:
+        {{- include "talm.advertised_subnets" . | nindent 8 }}
+    {{- $extraConfig := include "talm.kubelet.resources" . | fromYaml }}
+    {{- if eq .MachineType "worker" }}
+    {{- $extraConfig = merge (dict) (.Values.nvidia.kubeletExtraConfig | default dict) $extraConfig }}
+    {{- end }}
+    {{- with $extraConfig }}
+    extraConfig:
+      {{- toYaml . | nindent 6 }}
+    {{- end }}
+  {{- if eq .MachineType "worker" }}
+  kernel:
+    modules:
+    {{- range .Values.nvidia.kernelModules }}
+    - name: {{ . }}
+    {{- end }}
+  sysctls:
+    net.core.bpf_jit_harden: 1
+  {{- if .Values.nvidia.defaultRuntime }}
+  files:
+  - content: |
+      [plugins]
+        [plugins."io.containerd.grpc.v1.cri"]
+          [plugins."io.containerd.grpc.v1.cri".containerd]
+            default_runtime_name = "nvidia"
+    path: /etc/cri/conf.d/20-customization.part
+    op: create
+  {{- end }}
+  {{- end }}
+  install:
+    {{- with include "talm.installer_image" . }}
+    image: {{ . }}
+    {{- end }}
+    {{- (include "talm.discovered.disks_info" .) | nindent 4 }}
+    disk: {{ include "talm.discovered.system_disk_name" . | quote }}
+  {{- with include "talm.secureboot.disk_encryption" . }}
+  {{- . | nindent 2 }}
+  {{- end }}
+  {{- with include "talm.static_pods" . }}
+  {{- . | nindent 2 }}
+  {{- end }}
+  network:
+    hostname: {{ include "talm.discovered.hostname" . | quote }}
+    nameservers: {{ include "talm.discovered.default_resolvers" . }}
+    {{- (include "talm.discovered.physical_links_info" .) | nindent 4 }}
+    interfaces:
+    {{- include "talm.network.default_interface" . | nindent 4 }}
+    {{- include "talm.network.check_discovered" . }}
+
+cluster:
+  network:
+    {{- include "talm.cni" . | nindent 4 }}
+    podSubnets:
+      {{- .Values.podSubnets | assertCIDR "podSubnets" | toYaml | nindent 6 }}
+    serviceSubnets:
+      {{- .Values.serviceSubnets | assertCIDR "serviceSubnets" | toYaml | nindent 6 }}
+  clusterName: "{{ .Chart.Name }}"
+  controlPlane:
+    endpoint: "{{ .Values.endpoint }}"
+  {{- if eq .MachineType "controlplane" }}
+  apiServer:
+    certSANs: {{ include "talm.cert_sans" . }}
+  {{- if include "talm.kube_proxy_disabled" . }}
+  proxy:
+    disabled: true
+  {{- end }}
+  {{- with include "gpu.manifests" . }}
+  {{- . | nindent 2 }}
+  {{- end }}
+  etcd:
+    advertisedSubnets:
+      {{- include "talm.advertised_subnets" . | nindent 6 }}
+  {{- end }}
+{{- end }}
@@ -0,0 +1,42 @@
+{{- define "talm.cni" }}
+{{- $cni := .Values.cni | default dict }}
+{{- $name := $cni.name | default "flannel" }}
+{{- $urls := $cni.urls | default list }}
+{{- if eq $name "flannel" }}
+{{- if $urls }}
+{{- fail "cni.urls is set but cni.name is flannel, set cni.name to cilium, calico or custom" }}
+{{- end }}
+{{- else if $urls }}
+cni:
+  name: custom
+  urls:
+    {{- toYaml $urls | nindent 4 }}
+{{- else if eq $name "custom" }}
+{{- fail "cni.name is custom but cni.urls is empty, set the manifests of the CNI" }}
+{{- else if or (eq $name "cilium") (eq $name "calico") (eq $name "none") }}
+{{- if not $cni.external }}
+{{- if eq $name "none" }}
+{{- warn "cni.name is none and no CNI is configured, the nodes stay NotReady until one is installed: set cni.external to true if it is installed after bootstrap" }}
+{{- else }}
+{{- warn (printf "cni.name is %s but cni.urls is empty, Talos installs no CNI: install %s after bootstrap and set cni.external to true, or set its manifests in cni.urls" $name $name) }}
+{{- end }}
+{{- end }}
+cni:
+  name: none
+{{- else }}
+{{- fail (printf "unknown cni.name %s, use flannel, cilium, calico, custom or none" $name) }}
+{{- end }}
+{{- end }}
+
+{{- define "talm.kube_proxy_disabled" }}
+{{- $cni := .Values.cni | default dict }}
+{{- $name := $cni.name | default "flannel" }}
+{{- $kubeProxy := ne $name "cilium" }}
+{{- if hasKey $cni "kubeProxy" }}
+{{- $kubeProxy = $cni.kubeProxy }}
+{{- end }}
+{{- if and (not $kubeProxy) (eq $name "flannel") }}
+{{- warn "cni.kubeProxy is false but flannel relies on kube-proxy for services" }}
+{{- end }}
+{{- if not $kubeProxy }}true{{ end }}
+{{- end }}
@@ -60,10 +60,14 @@
 {{- $family = .spec.family }}
 {{- end }}
 {{- end }}
+{{- $vips := list }}
+{{- range include "talm.network.vips" . | fromJsonArray }}
+{{- $vips = append $vips .ip }}
+{{- end }}
 {{- $addresses := list }}
 {{- range (lookup "addresses" "" "").items }}
 {{- if and (eq .spec.linkName $linkName) (eq .spec.family $family) (not (eq .spec.scope "host")) }}
-{{- if not (hasPrefix (printf "%s/" $.Values.floatingIP) .spec.address) }}
+{{- if not (has (first (splitList "/" .spec.address)) $vips) }}
 {{- $addresses = append $addresses .spec.address }}
 {{- end }}
 {{- end }}
@@ -71,6 +75,31 @@
 {{- toJson $addresses }}
 {{- end }}
 
+{{- define "talm.cert_sans" }}
+{{- $sans := list "127.0.0.1" }}
+{{- with $.Values.endpoint }}
+{{- with (urlParse .).hostname }}
+{{- $sans = append $sans . }}
+{{- end }}
+{{- end }}
+{{- with $.Values.floatingIP }}
+{{- $sans = append $sans . }}
+{{- end }}
+{{- with (include "talm.discovered.default_addresses" $) }}
+{{- range fromJsonArray . }}
+{{- $sans = append $sans (first (splitList "/" .)) }}
+{{- end }}
+{{- end }}
+{{- range $.Values.certSANs }}
+{{- $sans = append $sans . }}
+{{- end }}
+{{- toJson (uniq $sans) }}
+{{- end }}
+
+{{- define "talm.installer_image" }}
+{{- installerImage $.Values | default ($.Values.image | default "") }}
+{{- end }}
+
 {{- define "talm.discovered.physical_links_info" }}
 # -- Discovered interfaces:
 {{- range (lookup "links" "" "").items }}
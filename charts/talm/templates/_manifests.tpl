@@ -0,0 +1,79 @@
+{{- /*
+Manifests applied by Talos on bootstrap, e.g. the cloud controller manager, from
+.Values.manifests: extra are URLs fetched by the nodes, given as strings or as {url, sha256},
+inline are {name, contents} or {name, file} with a file of the chart. When rendering online
+the URLs are checked with a HEAD request, or with sha256 that their content didn't change.
+*/}}
+{{- define "talm.manifests" }}
+{{- $manifests := .Values.manifests | default dict }}
+{{- $urls := list }}
+{{- range $manifests.extra }}
+{{- $manifest := . }}
+{{- if kindIs "string" . }}
+{{- $manifest = dict "url" . }}
+{{- end }}
+{{- if not (regexMatch "^https?://[^/]+" ($manifest.url | default "")) }}
+{{- fail (printf "manifests.extra URL %q is not an http(s) URL" ($manifest.url | default "")) }}
+{{- end }}
+{{- with $manifest.sha256 }}
+{{- if not (regexMatch "^[0-9a-fA-F]{64}$" .) }}
+{{- fail (printf "manifests.extra sha256 of %s is not a hex encoded sha256 checksum" $manifest.url) }}
+{{- end }}
+{{- end }}
+{{- checkManifestURL $manifest.url ($manifest.sha256 | default "") }}
+{{- $urls = append $urls $manifest.url }}
+{{- end }}
+{{- with $urls }}
+extraManifests:
+  {{- toYaml . | nindent 2 }}
+{{- end }}
+{{- $inline := list }}
+{{- $names := dict }}
+{{- range $manifests.inline }}
+{{- if not .name }}
+{{- fail "every manifest of manifests.inline needs a name" }}
+{{- end }}
+{{- if hasKey $names .name }}
+{{- fail (printf "manifests.inline has two manifests named %s" .name) }}
+{{- end }}
+{{- $_ := set $names .name true }}
+{{- $contents := .contents | default "" }}
+{{- if .file }}
+{{- $contents = $.Files.Get .file }}
+{{- if not $contents }}
+{{- fail (printf "file %s of manifests.inline %s is missing or empty" .file .name) }}
+{{- end }}
+{{- end }}
+{{- if not $contents }}
+{{- fail (printf "manifests.inline %s needs contents or a file" .name) }}
+{{- end }}
+{{- $inline = append $inline (dict "name" .name "contents" $contents) }}
+{{- end }}
+{{- with $inline }}
+inlineManifests:
+  {{- toYaml . | nindent 2 }}
+{{- end }}
+{{- end }}
+
+{{- /*
+Static pods run by the kubelet of the node from .Values.manifests.staticPods, Pod manifests
+defaulting to apiVersion v1 and kind Pod.
+*/}}
+{{- define "talm.static_pods" }}
+{{- $manifests := .Values.manifests | default dict }}
+{{- $pods := list }}
+{{- range $manifests.staticPods }}
+{{- $name := dig "metadata" "name" "" . }}
+{{- if not $name }}
+{{- fail "every pod of manifests.staticPods needs metadata.name" }}
+{{- end }}
+{{- if not (dig "spec" "containers" list .) }}
+{{- fail (printf "static pod %s has no containers" $name) }}
+{{- end }}
+{{- $pods = append $pods (merge (deepCopy .) (dict "apiVersion" "v1" "kind" "Pod")) }}
+{{- end }}
+{{- with $pods }}
+pods:
+  {{- toYaml . | nindent 2 }}
+{{- end }}
+{{- end }}
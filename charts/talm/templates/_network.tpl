@@ -0,0 +1,277 @@
+{{- define "talm.discovered.default_link_by_gateway" }}
+{{- range (lookup "routes" "" "").items }}
+{{- if and (eq .spec.dst "") (not (eq .spec.gateway "")) }}
+{{- toJson (lookup "links" "" .spec.outLinkName) }}
+{{- break }}
+{{- end }}
+{{- end }}
+{{- end }}
+
+{{- /*
+Device selectors of the bond members:
+- .Values.bond.members: MAC addresses or device selectors set explicitly
+- .Values.bond.memberDriver: physical links using the driver
+- otherwise links already enslaved to the default link if it is a bond,
+  or physical links using the same driver as the default link
+*/}}
+{{- define "talm.network.bond_member_selectors" }}
+{{- $bond := .Values.bond }}
+{{- $selectors := list }}
+{{- range $bond.members }}
+{{- if kindIs "string" . }}
+{{- $selectors = append $selectors (dict "hardwareAddr" (lower .)) }}
+{{- else }}
+{{- $selectors = append $selectors . }}
+{{- end }}
+{{- end }}
+{{- if not $selectors }}
+{{- $default := dict }}
+{{- with (include "talm.discovered.default_link_by_gateway" .) }}
+{{- $default = fromJson . }}
+{{- end }}
+{{- $driver := $bond.memberDriver | default (dig "spec" "driver" "" $default) }}
+{{- $bondIndex := "" }}
+{{- if and (not $bond.memberDriver) (eq (dig "spec" "kind" "" $default) "bond") }}
+{{- $bondIndex = dig "spec" "index" "" $default | toString }}
+{{- end }}
+{{- range (lookup "links" "" "").items }}
+{{- if .spec.busPath }}
+{{- if $bondIndex }}
+{{- if eq (toString (.spec.masterIndex | default "")) $bondIndex }}
+{{- $selectors = append $selectors (dict "busPath" .spec.busPath) }}
+{{- end }}
+{{- else if and $driver (eq .spec.driver $driver) }}
+{{- $selectors = append $selectors (dict "busPath" .spec.busPath) }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- toJson $selectors }}
+{{- end }}
+
+{{- /*
+VIPs of the node as a JSON list of {ip, interface, vlanId}: .Values.floatingIP on the default
+interface, the vip of .Values.vlans and .Values.vips, which are on the default interface unless
+they name another interface, on its VLAN if vlanId is set. Rendering fails when a VIP is not
+within .Values.advertisedSubnets, is claimed twice, or when an interface or a VLAN gets two
+VIPs, as Talos supports a single VIP per link.
+*/}}
+{{- define "talm.network.vips" }}
+{{- $defaultName := "" }}
+{{- if .Values.bond }}
+{{- $defaultName = .Values.bond.interface | default "bond0" }}
+{{- end }}
+{{- $vips := list }}
+{{- with .Values.floatingIP }}
+{{- $vips = append $vips (dict "ip" . "interface" "" "vlanId" 0) }}
+{{- end }}
+{{- range $vlan := .Values.vlans }}
+{{- with $vlan.vip }}
+{{- $vips = append $vips (dict "ip" . "interface" "" "vlanId" (int $vlan.vlanId)) }}
+{{- end }}
+{{- end }}
+{{- range .Values.vips }}
+{{- $interface := .interface | default "" }}
+{{- if eq $interface $defaultName }}
+{{- $interface = "" }}
+{{- end }}
+{{- $vips = append $vips (dict "ip" .ip "interface" $interface "vlanId" (int (.vlanId | default 0))) }}
+{{- end }}
+{{- $links := dict }}
+{{- $ips := dict }}
+{{- range $vip := $vips }}
+{{- $link := "the default interface" }}
+{{- if $vip.interface }}
+{{- $link = printf "interface %s" $vip.interface }}
+{{- end }}
+{{- if $vip.vlanId }}
+{{- $link = printf "VLAN %d of %s" $vip.vlanId $link }}
+{{- end }}
+{{- if hasKey $ips $vip.ip }}
+{{- fail (printf "VIP %s is claimed by both %s and %s" $vip.ip (get $ips $vip.ip) $link) }}
+{{- end }}
+{{- if hasKey $links $link }}
+{{- fail (printf "VIPs %s and %s both claim %s, Talos supports a single VIP per link" (get $links $link) $vip.ip $link) }}
+{{- end }}
+{{- $_ := set $links $link $vip.ip }}
+{{- $_ := set $ips $vip.ip $link }}
+{{- if $.Values.advertisedSubnets }}
+{{- $within := false }}
+{{- range $.Values.advertisedSubnets }}
+{{- if cidrContains . $vip.ip }}
+{{- $within = true }}
+{{- end }}
+{{- end }}
+{{- if not $within }}
+{{- fail (printf "VIP %s of %s is not within advertisedSubnets %s" $vip.ip $link (join ", " $.Values.advertisedSubnets)) }}
+{{- end }}
+{{- end }}
+{{- if and $vip.vlanId (not $vip.interface) }}
+{{- $configured := false }}
+{{- range $.Values.vlans }}
+{{- if eq (int .vlanId) $vip.vlanId }}
+{{- $configured = true }}
+{{- end }}
+{{- end }}
+{{- if not $configured }}
+{{- fail (printf "VIP %s claims VLAN %d which is not configured in vlans" $vip.ip $vip.vlanId) }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- toJson $vips }}
+{{- end }}
+
+{{- /*
+Interfaces other than the default one carrying VIPs from .Values.vips.
+*/}}
+{{- define "talm.network.vip_interfaces" }}
+{{- $vips := include "talm.network.vips" . | fromJsonArray }}
+{{- $names := list }}
+{{- range $vips }}
+{{- if .interface }}
+{{- $names = append $names .interface }}
+{{- end }}
+{{- end }}
+{{- range $name := uniq $names }}
+- interface: {{ $name }}
+  {{- range $vips }}
+  {{- if and (eq .interface $name) (not .vlanId) }}
+  vip:
+    ip: {{ .ip }}
+  {{- end }}
+  {{- end }}
+  {{- $vlans := list }}
+  {{- range $vips }}
+  {{- if and (eq .interface $name) .vlanId }}
+  {{- $vlans = append $vlans . }}
+  {{- end }}
+  {{- end }}
+  {{- with $vlans }}
+  vlans:
+    {{- range . }}
+    - vlanId: {{ .vlanId }}
+      vip:
+        ip: {{ .ip }}
+    {{- end }}
+  {{- end }}
+{{- end }}
+{{- end }}
+
+{{- /*
+Default network interface of the node: the link with the default gateway, or
+a bond of the uplinks when .Values.bond is set. VLANs from .Values.vlans are
+attached to it.
+*/}}
+{{- define "talm.network.default_interface" }}
+{{- if .Values.bond }}
+{{- $bond := .Values.bond }}
+- interface: {{ $bond.interface | default "bond0" }}
+  bond:
+    mode: {{ $bond.mode | default "802.3ad" }}
+    {{- if eq ($bond.mode | default "802.3ad") "802.3ad" }}
+    xmitHashPolicy: {{ $bond.xmitHashPolicy | default "layer3+4" }}
+    lacpRate: {{ $bond.lacpRate | default "fast" }}
+    {{- else if $bond.xmitHashPolicy }}
+    xmitHashPolicy: {{ $bond.xmitHashPolicy }}
+    {{- end }}
+    miimon: {{ $bond.miimon | default 100 }}
+    deviceSelectors: {{ include "talm.network.bond_member_selectors" . }}
+  {{- with $bond.mtu }}
+  mtu: {{ . }}
+  {{- end }}
+  addresses: {{ if $bond.addresses }}{{ toJson $bond.addresses }}{{ else }}{{ include "talm.discovered.default_addresses_by_gateway" . }}{{ end }}
+  routes:
+    - network: 0.0.0.0/0
+      gateway: {{ $bond.gateway | default (include "talm.discovered.default_gateway" .) }}
+{{- else }}
+- deviceSelector:
+    {{- include "talm.discovered.default_link_selector_by_gateway" . | nindent 4 }}
+  addresses: {{ include "talm.discovered.default_addresses_by_gateway" . }}
+  routes:
+    - network: 0.0.0.0/0
+      gateway: {{ include "talm.discovered.default_gateway" . }}
+{{- end }}
+{{- $vips := include "talm.network.vips" . | fromJsonArray }}
+{{- range $vips }}
+{{- if and (not .interface) (not .vlanId) }}
+  vip:
+    ip: {{ .ip }}
+{{- end }}
+{{- end }}
+{{- with .Values.vlans }}
+  vlans:
+    {{- range $vlan := . }}
+    - vlanId: {{ .vlanId }}
+      {{- with .addresses }}
+      addresses: {{ toJson . }}
+      {{- end }}
+      {{- if hasKey . "dhcp" }}
+      dhcp: {{ .dhcp }}
+      {{- end }}
+      {{- with .mtu }}
+      mtu: {{ . }}
+      {{- end }}
+      {{- with .routes }}
+      routes:
+        {{- toYaml . | nindent 8 }}
+      {{- end }}
+      {{- range $vips }}
+      {{- if and (not .interface) (eq (int .vlanId) (int $vlan.vlanId)) }}
+      vip:
+        ip: {{ .ip }}
+      {{- end }}
+      {{- end }}
+    {{- end }}
+{{- end }}
+{{- include "talm.network.vip_interfaces" . }}
+{{- end }}
+
+{{- /*
+Warnings about network values not matching the addresses discovered on the node, rendering
+offline discovers none and checks nothing: advertisedSubnets containing none of the node
+addresses, a default gateway outside the subnets of the addresses of the default interface,
+and a floatingIP which is an address of the node itself rather than its VIP.
+*/}}
+{{- define "talm.network.check_discovered" }}
+{{- $nodeAddresses := list }}
+{{- with (include "talm.discovered.default_addresses" .) }}
+{{- $nodeAddresses = fromJsonArray . }}
+{{- end }}
+{{- $subnets := include "talm.advertised_subnets" . | fromYamlArray }}
+{{- if and $nodeAddresses $subnets }}
+{{- $advertised := false }}
+{{- range $address := $nodeAddresses }}
+{{- range $subnets }}
+{{- if cidrContains . $address }}
+{{- $advertised = true }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- if not $advertised }}
+{{- warn (printf "advertisedSubnets %s contain none of the node addresses %s, the kubelet and etcd have no address to advertise" (join ", " $subnets) (join ", " $nodeAddresses)) }}
+{{- end }}
+{{- end }}
+{{- $bond := .Values.bond | default dict }}
+{{- $gateway := $bond.gateway | default (include "talm.discovered.default_gateway" .) }}
+{{- $addresses := $bond.addresses | default (include "talm.discovered.default_addresses_by_gateway" . | fromJsonArray) }}
+{{- if and $gateway $addresses (not (hasPrefix "fe80:" $gateway)) }}
+{{- $reachable := false }}
+{{- range $addresses }}
+{{- if cidrContains . $gateway }}
+{{- $reachable = true }}
+{{- end }}
+{{- end }}
+{{- if not $reachable }}
+{{- warn (printf "default gateway %s is not within the subnets of the addresses %s of the default interface, the node has no default route" $gateway (join ", " $addresses)) }}
+{{- end }}
+{{- end }}
+{{- with .Values.floatingIP }}
+{{- $floatingIP := . }}
+{{- range (lookup "addresses" "" "").items }}
+{{- $parts := splitList "/" .spec.address }}
+{{- if and (eq (first $parts) $floatingIP) (not (has (last $parts) (list "32" "128"))) }}
+{{- warn (printf "floatingIP %s is the address %s of %s on the node, set it to a free address of advertisedSubnets" $floatingIP .spec.address .spec.linkName) }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- end }}
@@ -0,0 +1,14 @@
+{{- define "talm.node_metadata" }}
+{{- $groups := .Values.nodeGroups | default dict }}
+{{- $group := get $groups .MachineType | default dict }}
+{{- $labels := merge (dict) (.Values.nodeLabels | default dict) ($group.nodeLabels | default dict) (include "talm.topology.labels" . | fromJson) }}
+{{- $taints := merge (dict) (.Values.nodeTaints | default dict) ($group.nodeTaints | default dict) }}
+{{- with $labels }}
+nodeLabels:
+  {{- toYaml . | nindent 2 }}
+{{- end }}
+{{- with $taints }}
+nodeTaints:
+  {{- toYaml . | nindent 2 }}
+{{- end }}
+{{- end }}
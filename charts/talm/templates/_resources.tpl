@@ -0,0 +1,47 @@
+{{- define "talm.resources.size_class" }}
+{{- if .Values.sizeClass }}
+{{- .Values.sizeClass }}
+{{- else if and .Hardware.cpus .Hardware.memoryMiB }}
+{{- if or (lt (int .Hardware.cpus) 8) (lt (int .Hardware.memoryMiB) 16384) }}
+{{- "small" }}
+{{- else if or (lt (int .Hardware.cpus) 32) (lt (int .Hardware.memoryMiB) 131072) }}
+{{- "medium" }}
+{{- else }}
+{{- "large" }}
+{{- end }}
+{{- end }}
+{{- end }}
+
+{{- define "talm.resources.sizing" }}
+{{- $classes := dict
+  "small" (dict
+    "maxPods" 110
+    "kubeReserved" (dict "cpu" "200m" "memory" "1Gi" "ephemeral-storage" "1Gi")
+    "systemReserved" (dict "cpu" "200m" "memory" "512Mi" "ephemeral-storage" "1Gi"))
+  "medium" (dict
+    "maxPods" 250
+    "kubeReserved" (dict "cpu" "500m" "memory" "2Gi" "ephemeral-storage" "2Gi")
+    "systemReserved" (dict "cpu" "500m" "memory" "1Gi" "ephemeral-storage" "2Gi"))
+  "large" (dict
+    "maxPods" 512
+    "kubeReserved" (dict "cpu" "1" "memory" "4Gi" "ephemeral-storage" "4Gi")
+    "systemReserved" (dict "cpu" "1" "memory" "2Gi" "ephemeral-storage" "4Gi")) }}
+{{- $class := include "talm.resources.size_class" . }}
+{{- if $class }}
+{{- if not (hasKey $classes $class) }}
+{{- fail (printf "unknown sizeClass %q, use small, medium or large" $class) }}
+{{- end }}
+{{- toJson (get $classes $class) }}
+{{- end }}
+{{- end }}
+
+{{- define "talm.kubelet.resources" }}
+{{- with include "talm.resources.sizing" . }}
+{{- $sizing := fromJson . }}
+maxPods: {{ $sizing.maxPods }}
+kubeReserved:
+  {{- toYaml $sizing.kubeReserved | nindent 2 }}
+systemReserved:
+  {{- toYaml $sizing.systemReserved | nindent 2 }}
+{{- end }}
+{{- end }}
@@ -0,0 +1,20 @@
+{{- define "talm.secureboot" }}
+{{- toJson (.Values.secureBoot | default dict) }}
+{{- end }}
+
+{{- define "talm.secureboot.disk_encryption" }}
+{{- $secureBoot := include "talm.secureboot" . | fromJson }}
+{{- if $secureBoot.tpmDiskEncryption }}
+{{- if not $secureBoot.enabled }}
+{{- fail "secureBoot.tpmDiskEncryption requires secureBoot.enabled, the TPM seals the keys to the SecureBoot state" }}
+{{- end }}
+systemDiskEncryption:
+  {{- range list "state" "ephemeral" }}
+  {{ . }}:
+    provider: luks2
+    keys:
+    - slot: 0
+      tpm: {}
+  {{- end }}
+{{- end }}
+{{- end }}
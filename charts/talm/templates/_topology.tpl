@@ -0,0 +1,26 @@
+{{- define "talm.topology" }}
+{{- $node := .Node | default dict }}
+{{- toJson ($node.Topology | default dict) }}
+{{- end }}
+
+{{- define "talm.topology.labels" }}
+{{- $topology := include "talm.topology" . | fromJson }}
+{{- $labels := dict }}
+{{- with $topology.region }}
+{{- $_ := set $labels "topology.kubernetes.io/region" . }}
+{{- end }}
+{{- with $topology.zone }}
+{{- $_ := set $labels "topology.kubernetes.io/zone" . }}
+{{- end }}
+{{- with $topology.rack }}
+{{- $_ := set $labels "topology.talm.dev/rack" . }}
+{{- end }}
+{{- toJson $labels }}
+{{- end }}
+
+{{- define "talm.advertised_subnets" }}
+{{- $topology := include "talm.topology" . | fromJson }}
+{{- $zones := .Values.zones | default dict }}
+{{- $zone := get $zones ($topology.zone | default "") | default dict }}
+{{- toYaml ($zone.advertisedSubnets | default .Values.advertisedSubnets) }}
+{{- end }}
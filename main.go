@@ -4,6 +4,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -21,6 +22,9 @@ import (
 
 var Version = "dev"
 
+// configLoaded is set once Chart.yaml is loaded, the help is printed without loading it.
+var configLoaded bool
+
 // rootCmd represents the base command when called without any subcommands.
 var rootCmd = &cobra.Command{
 	Use:               "talm",
@@ -34,6 +38,10 @@ var rootCmd = &cobra.Command{
 
 func main() {
 	if err := Execute(); err != nil {
+		var exitErr *commands.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.Code)
+		}
 		os.Exit(1)
 	}
 }
@@ -50,13 +58,39 @@ func Execute() error {
 		),
 	)
 	rootCmd.PersistentFlags().StringVar(&commands.Config.RootDir, "root", ".", "root directory of the project")
+	rootCmd.PersistentFlags().StringVarP(&commands.Config.Workspace, "workspace", "W", os.Getenv(commands.WorkspaceEnvVar), fmt.Sprintf("workspace (cluster directory in clusters/) to use. Defaults to '%s' env variable if set", commands.WorkspaceEnvVar))
+	rootCmd.PersistentFlags().StringVar(&commands.Environment, "environment", os.Getenv(commands.EnvironmentEnvVar), fmt.Sprintf("environment selecting the secrets when withSecrets is keyed by environment, defaults to '%s' env variable if set, otherwise to the workspace", commands.EnvironmentEnvVar))
 	rootCmd.PersistentFlags().StringVar(&commands.GlobalArgs.CmdContext, "context", "", "Context to be used in command")
 	rootCmd.PersistentFlags().StringSliceVarP(&commands.GlobalArgs.Nodes, "nodes", "n", []string{}, "target the specified nodes")
 	rootCmd.PersistentFlags().StringSliceVarP(&commands.GlobalArgs.Endpoints, "endpoints", "e", []string{}, "override default endpoints in Talos configuration")
 	rootCmd.PersistentFlags().StringVar(&commands.GlobalArgs.Cluster, "cluster", "", "Cluster to connect to if a proxy endpoint is used.")
+	rootCmd.PersistentFlags().DurationVar(&commands.RPCTimeout, "rpc-timeout", 0, "maximum time for the Talos API operations of a command, zero means no limit")
+	rootCmd.PersistentFlags().StringVar(&commands.ProxyURL, "proxy", "", "reach the Talos API through a SOCKS5 proxy or an SSH bastion host (socks5://host:port, ssh://user@host)")
+	rootCmd.PersistentFlags().BoolVar(&commands.RegenerateClientCert, "regenerate-client-cert", false, "issue a new client certificate in talosconfig from the secrets bundle before connecting")
+	rootCmd.PersistentFlags().StringVar(&commands.StateBackend, "state-backend", os.Getenv(commands.StateBackendEnvVar), fmt.Sprintf("URL of the backend storing the state directory: s3://bucket/prefix, gs://bucket/prefix, http(s)://host/path or file:///path. Defaults to '%s' env variable if set, otherwise to globalOptions.stateBackend", commands.StateBackendEnvVar))
+	rootCmd.PersistentFlags().BoolVar(&commands.IgnoreVersionCheck, "ignore-version-check", false, "warn instead of failing when talm is outside the versions required by talmVersion and talosClientVersion of Chart.yaml")
 	rootCmd.PersistentFlags().Bool("version", false, "Print the version number of the application")
+	cobra.CheckErr(rootCmd.RegisterFlagCompletionFunc("nodes", commands.CompleteNodesFlag))
+
+	// The commands the talosconfig context is not allowed to run are hidden from the help
+	help := rootCmd.HelpFunc()
+	rootCmd.SetHelpFunc(func(cmd *cobra.Command, args []string) {
+		if !configLoaded {
+			configLoaded = loadConfig(filepath.Join(commands.Config.RootDir, "Chart.yaml")) == nil
+		}
+		commands.HideDeniedCommands(cmd)
+		help(cmd, args)
+	})
 
 	cmd, err := rootCmd.ExecuteContextC(context.Background())
+	// The state is stored whether the command failed or not, e.g. the nodes applied before the failure
+	if pushErr := commands.PushState(); pushErr != nil {
+		if err == nil {
+			err = pushErr
+		} else {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", pushErr)
+		}
+	}
 	if err != nil && !common.SuppressErrors {
 		fmt.Fprintln(os.Stderr, err.Error())
 
@@ -73,6 +107,7 @@ func Execute() error {
 }
 
 func init() {
+	commands.Version = Version
 	cobra.OnInitialize(initConfig)
 
 	for _, cmd := range commands.Commands {
@@ -81,10 +116,15 @@ func init() {
 }
 
 func initConfig() {
+	commands.RemoveReplacedExecutable()
+
 	cmd, _, _ := rootCmd.Find(os.Args[1:])
 	if cmd == nil {
 		return
 	}
+	if cmd.Use == "self-update" {
+		return
+	}
 	if strings.HasPrefix(cmd.Use, "init") {
 		if strings.HasPrefix(Version, "v") {
 			commands.Config.InitOptions.Version = strings.TrimPrefix(Version, `v`)
@@ -97,6 +137,30 @@ func initConfig() {
 			fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
 			os.Exit(1)
 		}
+		configLoaded = true
+		// The version is printed whatever versions the project requires
+		if cmd.Use != "version" {
+			if err := commands.CheckRequiredVersions(); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if err := commands.PullState(cmd); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := commands.SelectIdentity(cmd); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
+			os.Exit(1)
+		}
+		if err := commands.CheckRoles(cmd); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := commands.CheckNodes(cmd); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
 	}
 }
 
@@ -109,14 +173,21 @@ func loadConfig(filename string) error {
 	if err := yaml.Unmarshal(data, &commands.Config); err != nil {
 		return fmt.Errorf("error unmarshalling configuration: %w", err)
 	}
+	if err := commands.ApplyWorkspace(); err != nil {
+		return err
+	}
 	if commands.GlobalArgs.Talosconfig == "" {
 		commands.GlobalArgs.Talosconfig = commands.Config.GlobalOptions.Talosconfig
 	}
+	if commands.ProxyURL == "" {
+		commands.ProxyURL = commands.Config.GlobalOptions.Proxy
+	}
 	if commands.Config.TemplateOptions.KubernetesVersion == "" {
 		commands.Config.TemplateOptions.KubernetesVersion = constants.DefaultKubernetesVersion
 	}
 	if commands.Config.ApplyOptions.Timeout == "" {
 		commands.Config.ApplyOptions.Timeout = constants.ConfigTryTimeout.String()
+		commands.Config.ApplyOptions.TimeoutDuration = constants.ConfigTryTimeout
 	} else {
 		var err error
 		commands.Config.ApplyOptions.TimeoutDuration, err = time.ParseDuration(commands.Config.ApplyOptions.Timeout)
@@ -0,0 +1,179 @@
+// Package age encrypts files with a passphrase in the age format (https://age-encryption.org/v1)
+// with filippo.io/age, using its scrypt recipient, so the files can also be decrypted with `age -d`.
+// Files encrypted to X25519 recipients are decrypted with the identities of an age identity file.
+//
+// Encrypted files are ASCII armored to be stored in git, both armored and binary files are decrypted.
+package age
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+const intro = "age-encryption.org/v1"
+
+// WorkFactor is the base-2 logarithm of the scrypt work factor of encrypted files, like in age.
+var WorkFactor = 18
+
+var (
+	// ErrWrongPassphrase is returned when the file can't be decrypted with the passphrase.
+	ErrWrongPassphrase = errors.New("wrong passphrase or corrupted file")
+	// ErrWrongIdentity is returned when none of the identities is a recipient of the file.
+	ErrWrongIdentity = errors.New("no identity matches the recipients of the file")
+)
+
+// Identity decrypts the files encrypted to its recipient.
+type Identity = age.Identity
+
+// Recipient encrypts the files for its identity.
+type Recipient = age.Recipient
+
+// IsEncrypted reports whether the data is an age encrypted file, armored or not.
+func IsEncrypted(data []byte) bool {
+	data = bytes.TrimLeft(data, " \t\r\n")
+	return bytes.HasPrefix(data, []byte(intro+"\n")) || bytes.HasPrefix(data, []byte(armor.Header))
+}
+
+// Encrypt encrypts the plaintext with the passphrase and returns an armored age file.
+func Encrypt(plaintext, passphrase []byte) ([]byte, error) {
+	recipient, err := ScryptRecipient(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return EncryptRecipients(plaintext, []Recipient{recipient})
+}
+
+// EncryptRecipients encrypts the plaintext to the recipients and returns an armored age file.
+func EncryptRecipients(plaintext []byte, recipients []Recipient) ([]byte, error) {
+	var out bytes.Buffer
+	armored := armor.NewWriter(&out)
+	w, err := age.Encrypt(armored, recipients...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	if err := armored.Close(); err != nil {
+		return nil, err
+	}
+	out.WriteString("\n")
+	return out.Bytes(), nil
+}
+
+// Decrypt decrypts an age file encrypted with the passphrase.
+func Decrypt(data, passphrase []byte) ([]byte, error) {
+	identity, err := ScryptIdentity(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := DecryptIdentities(data, []Identity{identity})
+	if errors.Is(err, ErrWrongIdentity) {
+		return nil, ErrWrongPassphrase
+	}
+	return plaintext, err
+}
+
+// EncryptWriter returns a writer encrypting the data written to it with the passphrase into a binary
+// age file written to w, for data too large to be kept in memory. Close must be called to write the
+// final chunk, it doesn't close w.
+func EncryptWriter(w io.Writer, passphrase []byte) (io.WriteCloser, error) {
+	recipient, err := ScryptRecipient(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return age.Encrypt(w, recipient)
+}
+
+// DecryptReader returns a reader decrypting the age file read from r with the passphrase. Reading
+// it fails if the file was truncated or changed.
+func DecryptReader(r io.Reader, passphrase []byte) (io.Reader, error) {
+	identity, err := ScryptIdentity(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	src := bufio.NewReader(r)
+	if header, _ := src.Peek(len(armor.Header)); string(header) == armor.Header {
+		r = armor.NewReader(src)
+	} else {
+		r = src
+	}
+	decrypted, err := age.Decrypt(r, identity)
+	var noMatch *age.NoIdentityMatchError
+	if errors.As(err, &noMatch) {
+		return nil, ErrWrongPassphrase
+	}
+	return decrypted, err
+}
+
+// DecryptIdentities decrypts an age file encrypted to one of the identities.
+func DecryptIdentities(data []byte, identities []Identity) ([]byte, error) {
+	var src io.Reader = bytes.NewReader(data)
+	if bytes.HasPrefix(bytes.TrimLeft(data, " \t\r\n"), []byte(armor.Header)) {
+		src = armor.NewReader(src)
+	}
+
+	r, err := age.Decrypt(src, identities...)
+	var noMatch *age.NoIdentityMatchError
+	if errors.As(err, &noMatch) {
+		return nil, ErrWrongIdentity
+	}
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// ScryptRecipient returns the recipient encrypting the files with the passphrase.
+func ScryptRecipient(passphrase []byte) (Recipient, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("passphrase is empty")
+	}
+	recipient, err := age.NewScryptRecipient(string(passphrase))
+	if err != nil {
+		return nil, err
+	}
+	recipient.SetWorkFactor(WorkFactor)
+	return recipient, nil
+}
+
+// ScryptIdentity returns the identity decrypting the files encrypted with the passphrase.
+func ScryptIdentity(passphrase []byte) (Identity, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("passphrase is empty")
+	}
+	return age.NewScryptIdentity(string(passphrase))
+}
+
+// ParseIdentities parses the AGE-SECRET-KEY-1 lines of an identity file, as written by age-keygen.
+func ParseIdentities(data []byte) ([]Identity, error) {
+	return age.ParseIdentities(bytes.NewReader(data))
+}
+
+// ParseRecipients parses the age1 recipients, one per line like in a recipients file.
+func ParseRecipients(data []byte) ([]Recipient, error) {
+	return age.ParseRecipients(bytes.NewReader(data))
+}
+
+// IdentityRecipients returns the recipients of the X25519 identities, which decrypt the files
+// encrypted to them.
+func IdentityRecipients(identities []Identity) ([]Recipient, error) {
+	recipients := make([]Recipient, 0, len(identities))
+	for _, identity := range identities {
+		x25519, ok := identity.(*age.X25519Identity)
+		if !ok {
+			return nil, errors.New("only the recipients of X25519 identities are known")
+		}
+		recipients = append(recipients, x25519.Recipient())
+	}
+	return recipients, nil
+}
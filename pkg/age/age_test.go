@@ -0,0 +1,223 @@
+package age
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	WorkFactor = 10
+
+	for _, size := range []int{0, 100, 64 * 1024, 128*1024 + 1} {
+		plaintext := bytes.Repeat([]byte("talosconfig"), size/11+1)[:size]
+
+		encrypted, err := Encrypt(plaintext, []byte("secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !IsEncrypted(encrypted) || !strings.HasPrefix(string(encrypted), armor.Header+"\n") {
+			t.Fatalf("expected armored age file, got %q", encrypted[:40])
+		}
+
+		decrypted, err := Decrypt(encrypted, []byte("secret"))
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Errorf("size %d: decrypted data differs", size)
+		}
+
+		// Binary files are decrypted too
+		binary, err := io.ReadAll(armor.NewReader(bytes.NewReader(encrypted)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !IsEncrypted(binary) {
+			t.Errorf("expected binary age file to be detected")
+		}
+		if decrypted, err := Decrypt(binary, []byte("secret")); err != nil || !bytes.Equal(decrypted, plaintext) {
+			t.Errorf("size %d: failed to decrypt binary file: %v", size, err)
+		}
+	}
+}
+
+func TestEncryptWriter(t *testing.T) {
+	WorkFactor = 10
+
+	var encrypted bytes.Buffer
+	w, err := EncryptWriter(&encrypted, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := bytes.Repeat([]byte("etcd"), 40*1024)
+	if _, err := w.Write(plaintext); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if strings.HasPrefix(encrypted.String(), armor.Header) || !IsEncrypted(encrypted.Bytes()) {
+		t.Fatalf("expected binary age file, got %q", encrypted.Bytes()[:40])
+	}
+	if decrypted, err := Decrypt(encrypted.Bytes(), []byte("secret")); err != nil || !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("failed to decrypt the streamed file: %v", err)
+	}
+
+	// Armored files are decrypted too
+	armored, err := Encrypt(plaintext, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range [][]byte{encrypted.Bytes(), armored} {
+		r, err := DecryptReader(bytes.NewReader(data), []byte("secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decrypted, err := io.ReadAll(r); err != nil || !bytes.Equal(decrypted, plaintext) {
+			t.Errorf("failed to decrypt the file as a stream: %v", err)
+		}
+	}
+
+	if _, err := DecryptReader(bytes.NewReader(encrypted.Bytes()), []byte("wrong")); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("expected wrong passphrase, got %v", err)
+	}
+	r, err := DecryptReader(bytes.NewReader(encrypted.Bytes()[:encrypted.Len()-20]), []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(r); err == nil {
+		t.Error("expected error on truncated file")
+	}
+}
+
+func TestDecryptErrors(t *testing.T) {
+	WorkFactor = 10
+
+	encrypted, err := Encrypt([]byte("context: default\n"), []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Decrypt(encrypted, []byte("wrong")); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("expected wrong passphrase, got %v", err)
+	}
+
+	binary, err := io.ReadAll(armor.NewReader(bytes.NewReader(encrypted)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupted := append([]byte(nil), binary...)
+	corrupted[len(corrupted)-1] ^= 1
+	if _, err := Decrypt(corrupted, []byte("secret")); err == nil {
+		t.Errorf("expected error on corrupted payload")
+	}
+	if _, err := Decrypt(binary[:len(binary)-20], []byte("secret")); err == nil {
+		t.Errorf("expected error on truncated payload")
+	}
+
+	if _, err := Decrypt([]byte("context: default\n"), []byte("secret")); err == nil || IsEncrypted([]byte("context: default\n")) {
+		t.Errorf("expected plaintext not to be decrypted")
+	}
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var x25519 bytes.Buffer
+	w, err := age.Encrypt(&x25519, identity.Recipient())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Decrypt(x25519.Bytes(), []byte("secret")); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("expected error on file encrypted to a key, got %v", err)
+	}
+}
+
+func TestDecryptIdentities(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	identities, err := ParseIdentities([]byte("# created: 2024-05-01\n" + identity.String() + "\n"))
+	if err != nil || len(identities) != 1 {
+		t.Fatalf("unexpected identities %v, %v", identities, err)
+	}
+	if _, err := ParseIdentities([]byte("AGE-SECRET-KEY-1INVALID\n")); err == nil {
+		t.Error("expected an error parsing an invalid identity")
+	}
+
+	var encrypted bytes.Buffer
+	armored := armor.NewWriter(&encrypted)
+	w, err := age.Encrypt(armored, identity.Recipient())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("hunter2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := armored.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	decrypted, err := DecryptIdentities(encrypted.Bytes(), append([]Identity{other}, identities...))
+	if err != nil || string(decrypted) != "hunter2" {
+		t.Errorf("unexpected %q, %v", decrypted, err)
+	}
+	if _, err := DecryptIdentities(encrypted.Bytes(), []Identity{other}); !errors.Is(err, ErrWrongIdentity) {
+		t.Errorf("expected wrong identity error, got %v", err)
+	}
+}
+
+func TestEncryptRecipients(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	identities := []Identity{identity}
+	recipients, err := IdentityRecipients(identities)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseRecipients([]byte("# team\n" + other.Recipient().String() + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, err := EncryptRecipients([]byte("state"), append(recipients, parsed...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, identity := range []Identity{identity, other} {
+		if decrypted, err := DecryptIdentities(encrypted, []Identity{identity}); err != nil || string(decrypted) != "state" {
+			t.Errorf("unexpected %q, %v", decrypted, err)
+		}
+	}
+
+	scrypt, err := ScryptIdentity([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := IdentityRecipients([]Identity{scrypt}); err == nil {
+		t.Error("expected an error for the recipient of a passphrase")
+	}
+}
@@ -0,0 +1,310 @@
+// Package audit checks machine configs against the Talos and CIS Kubernetes hardening
+// guidelines and produces a scored report with remediation hints.
+package audit
+
+import (
+	"strings"
+
+	"github.com/siderolabs/talos/pkg/machinery/config/config"
+	"github.com/siderolabs/talos/pkg/machinery/constants"
+)
+
+// Severity is the impact of a finding on the security of the cluster.
+type Severity int
+
+// Finding severities.
+const (
+	SeverityLow Severity = iota
+	SeverityMedium
+	SeverityHigh
+)
+
+// String implements fmt.Stringer.
+func (s Severity) String() string {
+	switch s {
+	case SeverityHigh:
+		return "high"
+	case SeverityMedium:
+		return "medium"
+	default:
+		return "low"
+	}
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// weight is the number of points a failed check of the severity takes from the score.
+func (s Severity) weight() int {
+	switch s {
+	case SeverityHigh:
+		return 20
+	case SeverityMedium:
+		return 10
+	default:
+		return 5
+	}
+}
+
+// Finding describes a failed check.
+type Finding struct {
+	ID          string   `json:"id"`
+	Severity    Severity `json:"severity"`
+	Title       string   `json:"title"`
+	Path        string   `json:"path"`
+	Remediation string   `json:"remediation"`
+}
+
+// Report is the result of the audit of a single machine config.
+type Report struct {
+	File     string    `json:"file"`
+	Score    int       `json:"score"`
+	Findings []Finding `json:"findings"`
+}
+
+// check is a single hardening rule, failed returns true if the config violates it.
+type check struct {
+	Finding
+	controlPlaneOnly bool
+	failed           func(cfg config.Config) bool
+}
+
+var checks = []check{
+	{
+		Finding: Finding{
+			ID:          "TALOS-001",
+			Severity:    SeverityHigh,
+			Title:       "Machine token looks predictable",
+			Path:        "machine.token",
+			Remediation: "do not set machine.token in templates or values, let talm take it from secrets.yaml generated by `talm init`",
+		},
+		failed: func(cfg config.Config) bool { return predictable(cfg.Machine().Security().Token()) },
+	},
+	{
+		Finding: Finding{
+			ID:          "TALOS-002",
+			Severity:    SeverityHigh,
+			Title:       "Cluster bootstrap token looks predictable",
+			Path:        "cluster.token",
+			Remediation: "do not set cluster.token in templates or values, let talm take it from secrets.yaml generated by `talm init`",
+		},
+		failed: func(cfg config.Config) bool {
+			return predictable(cfg.Cluster().Token().ID() + cfg.Cluster().Token().Secret())
+		},
+	},
+	{
+		Finding: Finding{
+			ID:          "TALOS-003",
+			Severity:    SeverityMedium,
+			Title:       "Debug mode is enabled",
+			Path:        "debug",
+			Remediation: "remove `debug: true` from the templates",
+		},
+		failed: func(cfg config.Config) bool { return cfg.Debug() },
+	},
+	{
+		Finding: Finding{
+			ID:          "TALOS-004",
+			Severity:    SeverityMedium,
+			Title:       "STATE partition is not encrypted",
+			Path:        "machine.systemDiskEncryption.state",
+			Remediation: "add machine.systemDiskEncryption.state with a nodeID or TPM key to the templates",
+		},
+		failed: func(cfg config.Config) bool { return !encrypted(cfg, constants.StatePartitionLabel) },
+	},
+	{
+		Finding: Finding{
+			ID:          "TALOS-005",
+			Severity:    SeverityMedium,
+			Title:       "EPHEMERAL partition is not encrypted",
+			Path:        "machine.systemDiskEncryption.ephemeral",
+			Remediation: "add machine.systemDiskEncryption.ephemeral with a nodeID or TPM key to the templates",
+		},
+		failed: func(cfg config.Config) bool { return !encrypted(cfg, constants.EphemeralPartitionLabel) },
+	},
+	{
+		Finding: Finding{
+			ID:          "TALOS-006",
+			Severity:    SeverityMedium,
+			Title:       "Talos API RBAC is disabled",
+			Path:        "machine.features.rbac",
+			Remediation: "set machine.features.rbac to true",
+		},
+		failed: func(cfg config.Config) bool { return !cfg.Machine().Features().RBACEnabled() },
+	},
+	{
+		Finding: Finding{
+			ID:          "CIS-4.2.1",
+			Severity:    SeverityHigh,
+			Title:       "Kubelet allows anonymous authentication",
+			Path:        "machine.kubelet.extraArgs.anonymous-auth",
+			Remediation: "remove anonymous-auth from machine.kubelet.extraArgs",
+		},
+		failed: func(cfg config.Config) bool {
+			return cfg.Machine().Kubelet().ExtraArgs()["anonymous-auth"] == "true"
+		},
+	},
+	{
+		Finding: Finding{
+			ID:          "CIS-1.2.1",
+			Severity:    SeverityHigh,
+			Title:       "API server allows anonymous authentication",
+			Path:        "cluster.apiServer.extraArgs.anonymous-auth",
+			Remediation: "remove anonymous-auth from cluster.apiServer.extraArgs",
+		},
+		controlPlaneOnly: true,
+		failed: func(cfg config.Config) bool {
+			return cfg.Cluster().APIServer().ExtraArgs()["anonymous-auth"] == "true"
+		},
+	},
+	{
+		Finding: Finding{
+			ID:          "CIS-5.2.1",
+			Severity:    SeverityHigh,
+			Title:       "Pod Security admission is disabled or not enforcing",
+			Path:        "cluster.apiServer.admissionControl",
+			Remediation: "configure PodSecurity plugin in cluster.apiServer.admissionControl with enforce level baseline or restricted",
+		},
+		controlPlaneOnly: true,
+		failed:           func(cfg config.Config) bool { return !podSecurityEnforced(cfg) },
+	},
+	{
+		Finding: Finding{
+			ID:          "CIS-1.2.18",
+			Severity:    SeverityLow,
+			Title:       "API server profiling is enabled",
+			Path:        "cluster.apiServer.extraArgs.profiling",
+			Remediation: "set cluster.apiServer.extraArgs.profiling to \"false\"",
+		},
+		controlPlaneOnly: true,
+		failed: func(cfg config.Config) bool {
+			return cfg.Cluster().APIServer().ExtraArgs()["profiling"] != "false"
+		},
+	},
+	{
+		Finding: Finding{
+			ID:          "CIS-1.3.7",
+			Severity:    SeverityMedium,
+			Title:       "Controller manager is bound to all interfaces",
+			Path:        "cluster.controllerManager.extraArgs.bind-address",
+			Remediation: "remove bind-address from cluster.controllerManager.extraArgs or bind it to 127.0.0.1",
+		},
+		controlPlaneOnly: true,
+		failed: func(cfg config.Config) bool {
+			return cfg.Cluster().ControllerManager().ExtraArgs()["bind-address"] == "0.0.0.0"
+		},
+	},
+	{
+		Finding: Finding{
+			ID:          "CIS-1.4.2",
+			Severity:    SeverityMedium,
+			Title:       "Scheduler is bound to all interfaces",
+			Path:        "cluster.scheduler.extraArgs.bind-address",
+			Remediation: "remove bind-address from cluster.scheduler.extraArgs or bind it to 127.0.0.1",
+		},
+		controlPlaneOnly: true,
+		failed: func(cfg config.Config) bool {
+			return cfg.Cluster().Scheduler().ExtraArgs()["bind-address"] == "0.0.0.0"
+		},
+	},
+	{
+		Finding: Finding{
+			ID:          "KUBE-PROXY-001",
+			Severity:    SeverityLow,
+			Title:       "kube-proxy metrics are exposed on all interfaces",
+			Path:        "cluster.proxy.extraArgs.metrics-bind-address",
+			Remediation: "bind kube-proxy metrics to 127.0.0.1 in cluster.proxy.extraArgs",
+		},
+		controlPlaneOnly: true,
+		failed: func(cfg config.Config) bool {
+			proxy := cfg.Cluster().Proxy()
+			return proxy.Enabled() && strings.HasPrefix(proxy.ExtraArgs()["metrics-bind-address"], "0.0.0.0")
+		},
+	},
+	{
+		Finding: Finding{
+			ID:          "KUBE-PROXY-002",
+			Severity:    SeverityMedium,
+			Title:       "kube-proxy is disabled while the default CNI is used",
+			Path:        "cluster.proxy.disabled",
+			Remediation: "enable kube-proxy or set cluster.network.cni.name to a CNI replacing it (e.g. Cilium with kube-proxy replacement)",
+		},
+		controlPlaneOnly: true,
+		failed: func(cfg config.Config) bool {
+			return !cfg.Cluster().Proxy().Enabled() && cfg.Cluster().Network().CNI().Name() != "none"
+		},
+	},
+}
+
+// Run audits the machine config.
+func Run(cfg config.Config) Report {
+	report := Report{Score: 100, Findings: []Finding{}}
+	controlPlane := cfg.Machine().Type().IsControlPlane()
+
+	for _, c := range checks {
+		if c.controlPlaneOnly && !controlPlane {
+			continue
+		}
+		if c.failed(cfg) {
+			report.Findings = append(report.Findings, c.Finding)
+			report.Score -= c.Severity.weight()
+		}
+	}
+
+	if report.Score < 0 {
+		report.Score = 0
+	}
+
+	return report
+}
+
+// predictable reports whether the secret is too short, has too few distinct
+// characters or contains the well-known example sequences.
+func predictable(secret string) bool {
+	if len(secret) < 16 {
+		return true
+	}
+
+	for _, example := range []string{"0123456789", "abcdef.", "123456", "password", "changeme"} {
+		if strings.Contains(strings.ToLower(secret), example) {
+			return true
+		}
+	}
+
+	distinct := map[rune]struct{}{}
+	for _, r := range secret {
+		distinct[r] = struct{}{}
+	}
+
+	return len(distinct) < len(secret)/4
+}
+
+func encrypted(cfg config.Config, label string) bool {
+	encryption := cfg.Machine().SystemDiskEncryption()
+	if encryption == nil {
+		return false
+	}
+
+	e := encryption.Get(label)
+	return e != nil && len(e.Keys()) > 0
+}
+
+func podSecurityEnforced(cfg config.Config) bool {
+	for _, plugin := range cfg.Cluster().APIServer().AdmissionControl() {
+		if plugin.Name() != "PodSecurity" {
+			continue
+		}
+
+		defaults, ok := plugin.Configuration()["defaults"].(map[string]interface{})
+		if !ok {
+			return false
+		}
+
+		enforce, _ := defaults["enforce"].(string)
+		return enforce != "" && enforce != "privileged"
+	}
+
+	return false
+}
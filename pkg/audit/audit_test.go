@@ -0,0 +1,98 @@
+package audit
+
+import (
+	"testing"
+
+	"github.com/siderolabs/talos/pkg/machinery/config/configloader"
+)
+
+const hardenedConfig = `version: v1alpha1
+machine:
+  type: controlplane
+  token: q8t4vz.1y9c3xkp7m2n6w5r
+  features:
+    rbac: true
+  systemDiskEncryption:
+    state:
+      provider: luks2
+      keys:
+        - nodeID: {}
+          slot: 0
+    ephemeral:
+      provider: luks2
+      keys:
+        - nodeID: {}
+          slot: 0
+cluster:
+  token: h7d2kq.z4m8x1c6v9b3n5p0
+  apiServer:
+    extraArgs:
+      profiling: "false"
+    admissionControl:
+      - name: PodSecurity
+        configuration:
+          defaults:
+            enforce: baseline
+`
+
+func TestRun(t *testing.T) {
+	testCases := []struct {
+		name      string
+		config    string
+		wantIDs   []string
+		wantScore int
+	}{
+		{
+			name:      "hardened config",
+			config:    hardenedConfig,
+			wantIDs:   []string{},
+			wantScore: 100,
+		},
+		{
+			name: "insecure worker",
+			config: `version: v1alpha1
+debug: true
+machine:
+  type: worker
+  token: abcdef.0123456789abcdef
+  kubelet:
+    extraArgs:
+      anonymous-auth: "true"
+cluster:
+  token: h7d2kq.z4m8x1c6v9b3n5p0
+  apiServer:
+    extraArgs:
+      anonymous-auth: "true"
+`,
+			wantIDs:   []string{"TALOS-001", "TALOS-003", "TALOS-004", "TALOS-005", "TALOS-006", "CIS-4.2.1"},
+			wantScore: 20,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg, err := configloader.NewFromBytes([]byte(tc.config))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			report := Run(cfg)
+
+			ids := []string{}
+			for _, finding := range report.Findings {
+				ids = append(ids, finding.ID)
+			}
+			if len(ids) != len(tc.wantIDs) {
+				t.Fatalf("Run() findings = %v, want %v", ids, tc.wantIDs)
+			}
+			for i := range ids {
+				if ids[i] != tc.wantIDs[i] {
+					t.Fatalf("Run() findings = %v, want %v", ids, tc.wantIDs)
+				}
+			}
+			if report.Score != tc.wantScore {
+				t.Errorf("Run() score = %d, want %d", report.Score, tc.wantScore)
+			}
+		})
+	}
+}
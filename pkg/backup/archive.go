@@ -0,0 +1,219 @@
+// Package backup writes and reads encrypted backup archives of a talm project and cluster state.
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ManifestName is the name of the manifest in the archive, it is written last.
+const ManifestName = "manifest.json"
+
+// Manifest describes the contents of a backup archive.
+type Manifest struct {
+	Version     int        `json:"version"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	TalmVersion string     `json:"talmVersion"`
+	Workspace   string     `json:"workspace,omitempty"`
+	Files       []FileInfo `json:"files"`
+}
+
+// FileInfo is a file in the archive.
+type FileInfo struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Mode   uint32 `json:"mode"`
+	SHA256 string `json:"sha256"`
+}
+
+// Writer writes a compressed tar archive of files followed by the manifest.
+type Writer struct {
+	gw       *gzip.Writer
+	tw       *tar.Writer
+	manifest Manifest
+}
+
+// NewWriter returns a writer of an archive with the manifest metadata.
+func NewWriter(w io.Writer, manifest Manifest) *Writer {
+	gw := gzip.NewWriter(w)
+	manifest.Version = 1
+	manifest.Files = nil
+
+	return &Writer{gw: gw, tw: tar.NewWriter(gw), manifest: manifest}
+}
+
+// Add adds a file with the contents read from r, which must be exactly size bytes long.
+func (w *Writer) Add(name string, mode os.FileMode, r io.Reader, size int64) error {
+	if err := validName(name); err != nil {
+		return err
+	}
+
+	if err := w.tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Mode:     int64(mode.Perm()),
+		Size:     size,
+		ModTime:  w.manifest.CreatedAt,
+		Typeflag: tar.TypeReg,
+	}); err != nil {
+		return err
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(w.tw, io.TeeReader(r, hash)); err != nil {
+		return fmt.Errorf("failed to add %s: %w", name, err)
+	}
+
+	w.manifest.Files = append(w.manifest.Files, FileInfo{
+		Path:   name,
+		Size:   size,
+		Mode:   uint32(mode.Perm()),
+		SHA256: hex.EncodeToString(hash.Sum(nil)),
+	})
+
+	return nil
+}
+
+// AddFile adds the file from the local path under the name.
+func (w *Writer) AddFile(name, localPath string) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close() //nolint:errcheck
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	return w.Add(name, info.Mode(), f, info.Size())
+}
+
+// Close writes the manifest and flushes the archive, it doesn't close the underlying writer.
+func (w *Writer) Close() error {
+	data, err := json.MarshalIndent(w.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := w.tw.WriteHeader(&tar.Header{
+		Name:     ManifestName,
+		Mode:     0o600,
+		Size:     int64(len(data)),
+		ModTime:  w.manifest.CreatedAt,
+		Typeflag: tar.TypeReg,
+	}); err != nil {
+		return err
+	}
+	if _, err := w.tw.Write(data); err != nil {
+		return err
+	}
+
+	if err := w.tw.Close(); err != nil {
+		return err
+	}
+
+	return w.gw.Close()
+}
+
+// Extract extracts the archive into dir and verifies the files against the manifest.
+func Extract(r io.Reader, dir string) (*Manifest, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	tr := tar.NewReader(gr)
+	hashes := map[string]string{}
+	var manifest *Manifest
+
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if header.Name == ManifestName {
+			manifest = &Manifest{}
+			if err := json.NewDecoder(tr).Decode(manifest); err != nil {
+				return nil, fmt.Errorf("failed to decode manifest: %w", err)
+			}
+			continue
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			return nil, fmt.Errorf("unexpected entry %s in archive", header.Name)
+		}
+		if err := validName(header.Name); err != nil {
+			return nil, err
+		}
+
+		target := filepath.Join(dir, filepath.FromSlash(header.Name))
+		if err := os.MkdirAll(filepath.Dir(target), 0o700); err != nil {
+			return nil, err
+		}
+
+		hash, err := extractFile(tr, target, os.FileMode(header.Mode).Perm())
+		if err != nil {
+			return nil, err
+		}
+		hashes[header.Name] = hash
+	}
+
+	if manifest == nil {
+		return nil, errors.New("manifest not found in archive")
+	}
+
+	for _, file := range manifest.Files {
+		hash, ok := hashes[file.Path]
+		if !ok {
+			return nil, fmt.Errorf("file %s listed in manifest is missing", file.Path)
+		}
+		if hash != file.SHA256 {
+			return nil, fmt.Errorf("checksum mismatch for %s", file.Path)
+		}
+		delete(hashes, file.Path)
+	}
+	for name := range hashes {
+		return nil, fmt.Errorf("file %s is not listed in manifest", name)
+	}
+
+	return manifest, nil
+}
+
+func extractFile(r io.Reader, target string, mode os.FileMode) (string, error) {
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close() //nolint:errcheck
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, hash), r); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), f.Close()
+}
+
+// validName rejects absolute paths and paths escaping the archive root.
+func validName(name string) error {
+	if name == "" || name == ManifestName || path.IsAbs(name) || strings.Contains(name, `\`) || path.Clean(name) != name || strings.HasPrefix(name, "../") || name == ".." {
+		return fmt.Errorf("invalid file name %q in archive", name)
+	}
+	return nil
+}
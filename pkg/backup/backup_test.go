@@ -0,0 +1,119 @@
+package backup
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aenix-io/talm/pkg/age"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	age.WorkFactor = 10
+	passphrase := []byte("correct horse battery staple")
+
+	// Sizes around the 64 KiB chunks of age
+	const chunkSize = 64 * 1024
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3*chunkSize + 7} {
+		plain := bytes.Repeat([]byte{'x'}, size)
+
+		var encrypted bytes.Buffer
+		w, err := Encrypt(&encrypted, passphrase)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(plain); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := Decrypt(bytes.NewReader(encrypted.Bytes()), passphrase)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("size %d: decrypted data differs", size)
+		}
+
+		// Dropping the final chunk must be detected
+		if size > chunkSize {
+			truncated := encrypted.Bytes()[:encrypted.Len()-(size%chunkSize)-16]
+			r, err := Decrypt(bytes.NewReader(truncated), passphrase)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.ReadAll(r); err == nil {
+				t.Errorf("size %d: truncated archive was decrypted", size)
+			}
+		}
+	}
+
+	var encrypted bytes.Buffer
+	w, err := Encrypt(&encrypted, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Decrypt(&encrypted, []byte("wrong")); !errors.Is(err, age.ErrWrongPassphrase) {
+		t.Errorf("expected wrong passphrase error, got %v", err)
+	}
+	if _, err := Decrypt(strings.NewReader("talm-backup-v1\n"), passphrase); err == nil {
+		t.Error("expected an error decrypting a file which is not an archive")
+	}
+}
+
+func TestArchive(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, Manifest{CreatedAt: time.Now(), TalmVersion: "dev"})
+	if err := w.Add("project/Chart.yaml", 0o644, strings.NewReader("name: test\n"), 11); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Add("secrets.yaml", 0o600, strings.NewReader("secret"), 6); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Add("../escape", 0o600, strings.NewReader(""), 0); err == nil {
+		t.Error("path escaping the archive was accepted")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	manifest, err := Extract(&buf, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(manifest.Files) != 2 || manifest.TalmVersion != "dev" {
+		t.Errorf("unexpected manifest: %+v", manifest)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "project", "Chart.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "name: test\n" {
+		t.Errorf("unexpected content: %q", data)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "secrets.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("unexpected mode %v", info.Mode())
+	}
+}
@@ -0,0 +1,28 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aenix-io/talm/pkg/age"
+)
+
+// The archive is encrypted with the scrypt recipient of age (https://age-encryption.org/v1),
+// so it can also be read with the age tool: age -d cluster.talmbackup | tar -tz
+
+// Encrypt returns a writer encrypting the data written to it with the passphrase.
+// Close must be called to write the final chunk, it doesn't close w.
+func Encrypt(w io.Writer, passphrase []byte) (io.WriteCloser, error) {
+	return age.EncryptWriter(w, passphrase)
+}
+
+// Decrypt returns a reader decrypting the data encrypted with Encrypt. Reading it fails if the
+// archive was truncated or changed.
+func Decrypt(r io.Reader, passphrase []byte) (io.Reader, error) {
+	decrypted, err := age.DecryptReader(r, passphrase)
+	if err != nil && !errors.Is(err, age.ErrWrongPassphrase) {
+		return nil, fmt.Errorf("not a talm backup archive: %w", err)
+	}
+	return decrypted, err
+}
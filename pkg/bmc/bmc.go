@@ -0,0 +1,90 @@
+// Package bmc controls the power of bare-metal machines through their baseboard management
+// controllers, with Redfish or IPMI.
+package bmc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+)
+
+// Action is a power action.
+type Action string
+
+const (
+	// On powers the machine on.
+	On Action = "on"
+	// Off powers the machine off immediately.
+	Off Action = "off"
+	// Cycle restarts the machine, or powers it on if it is off.
+	Cycle Action = "cycle"
+	// PXE boots the machine from the network once, it is restarted or powered on.
+	PXE Action = "pxe"
+)
+
+// Protocols of the BMCs.
+const (
+	Redfish = "redfish"
+	IPMI    = "ipmi"
+)
+
+// Config is the BMC of a node.
+type Config struct {
+	// Protocol is redfish or ipmi, it defaults to redfish.
+	Protocol string `yaml:"protocol,omitempty"`
+	// Address is the host of the BMC, optionally with a port, or the base URL for Redfish.
+	Address  string `yaml:"address"`
+	Username string `yaml:"username"`
+	// Password is stored in plain text, prefer PasswordEnv.
+	Password string `yaml:"password,omitempty"`
+	// PasswordEnv is the environment variable holding the password.
+	PasswordEnv string `yaml:"passwordEnv,omitempty"`
+	// Insecure skips the verification of the Redfish certificate, BMCs often have self-signed ones.
+	Insecure bool `yaml:"insecure,omitempty"`
+}
+
+// Controller controls the power of a machine.
+type Controller interface {
+	// PowerState returns the power state, on or off.
+	PowerState(ctx context.Context) (string, error)
+	// Power performs the action.
+	Power(ctx context.Context, action Action) error
+}
+
+// DialFunc dials the BMC, like net.Dialer.DialContext.
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// New returns the controller of the BMC. Redfish connections use dial if set.
+func New(cfg Config, dial DialFunc) (Controller, error) {
+	if cfg.Address == "" {
+		return nil, fmt.Errorf("BMC address is not set")
+	}
+
+	password := cfg.Password
+	if cfg.PasswordEnv != "" {
+		password = os.Getenv(cfg.PasswordEnv)
+		if password == "" {
+			return nil, fmt.Errorf("BMC password environment variable %s is not set", cfg.PasswordEnv)
+		}
+	}
+
+	switch cfg.Protocol {
+	case "", Redfish:
+		return newRedfish(cfg, password, dial), nil
+	case IPMI:
+		return newIPMI(cfg, password), nil
+	default:
+		return nil, fmt.Errorf("unknown BMC protocol %q, use %s or %s", cfg.Protocol, Redfish, IPMI)
+	}
+}
+
+// ParseAction parses a power action.
+func ParseAction(s string) (Action, error) {
+	switch action := Action(s); action {
+	case On, Off, Cycle, PXE:
+		return action, nil
+	default:
+		return "", fmt.Errorf("unknown power action %q", s)
+	}
+}
@@ -0,0 +1,168 @@
+package bmc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type redfishCall struct {
+	method string
+	path   string
+	body   string
+}
+
+func newRedfishServer(t *testing.T, powerState string) (*httptest.Server, *[]redfishCall) {
+	var calls []redfishCall
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, ok := r.BasicAuth(); !ok || username != "admin" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var body strings.Builder
+		if r.Body != nil {
+			var decoded interface{}
+			if err := json.NewDecoder(r.Body).Decode(&decoded); err == nil {
+				data, _ := json.Marshal(decoded) //nolint:errcheck
+				body.Write(data)
+			}
+		}
+		calls = append(calls, redfishCall{method: r.Method, path: r.URL.Path, body: body.String()})
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/redfish/v1/Systems":
+			w.Write([]byte(`{"Members":[{"@odata.id":"/redfish/v1/Systems/1"}]}`)) //nolint:errcheck
+		case r.Method == http.MethodGet && r.URL.Path == "/redfish/v1/Systems/1":
+			w.Write([]byte(`{"PowerState":"` + powerState + `"}`)) //nolint:errcheck
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+func TestRedfish(t *testing.T) {
+	tests := []struct {
+		action     Action
+		powerState string
+		expected   []redfishCall
+	}{
+		{
+			action: Off,
+			expected: []redfishCall{
+				{method: http.MethodPost, path: "/redfish/v1/Systems/1/Actions/ComputerSystem.Reset", body: `{"ResetType":"ForceOff"}`},
+			},
+		},
+		{
+			action:     Cycle,
+			powerState: "On",
+			expected: []redfishCall{
+				{method: http.MethodGet, path: "/redfish/v1/Systems/1"},
+				{method: http.MethodPost, path: "/redfish/v1/Systems/1/Actions/ComputerSystem.Reset", body: `{"ResetType":"ForceRestart"}`},
+			},
+		},
+		{
+			action:     PXE,
+			powerState: "Off",
+			expected: []redfishCall{
+				{method: http.MethodPatch, path: "/redfish/v1/Systems/1", body: `{"Boot":{"BootSourceOverrideEnabled":"Once","BootSourceOverrideTarget":"Pxe"}}`},
+				{method: http.MethodGet, path: "/redfish/v1/Systems/1"},
+				{method: http.MethodPost, path: "/redfish/v1/Systems/1/Actions/ComputerSystem.Reset", body: `{"ResetType":"On"}`},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.action), func(t *testing.T) {
+			server, calls := newRedfishServer(t, tt.powerState)
+			controller, err := New(Config{Address: server.URL, Username: "admin", Password: "secret"}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := controller.Power(context.Background(), tt.action); err != nil {
+				t.Fatal(err)
+			}
+
+			// The systems are listed first
+			expected := append([]redfishCall{{method: http.MethodGet, path: "/redfish/v1/Systems"}}, tt.expected...)
+			if !reflect.DeepEqual(*calls, expected) {
+				t.Errorf("expected calls:\n%+v\ngot:\n%+v", expected, *calls)
+			}
+		})
+	}
+}
+
+func TestRedfishPowerState(t *testing.T) {
+	server, _ := newRedfishServer(t, "On")
+
+	controller, err := New(Config{Address: server.URL, Username: "admin", Password: "secret"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := controller.PowerState(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != "on" {
+		t.Errorf("expected power state on, got %q", state)
+	}
+
+	controller, err = New(Config{Address: server.URL, Username: "admin", Password: "wrong"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := controller.PowerState(context.Background()); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("expected an authentication error, got %v", err)
+	}
+}
+
+func TestIPMI(t *testing.T) {
+	t.Setenv("BMC_PASSWORD", "secret")
+	controller, err := New(Config{Protocol: IPMI, Address: "10.0.0.5:6230", Username: "admin", PasswordEnv: "BMC_PASSWORD"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var calls []string
+	controller.(*ipmi).run = func(_ context.Context, args []string, env []string) ([]byte, error) {
+		if !reflect.DeepEqual(env, []string{"IPMI_PASSWORD=secret"}) {
+			t.Errorf("unexpected environment %q", env)
+		}
+		calls = append(calls, strings.Join(args, " "))
+		if strings.HasSuffix(calls[len(calls)-1], "power status") {
+			return []byte("Chassis Power is on\n"), nil
+		}
+		return nil, nil
+	}
+
+	if err := controller.Power(context.Background(), PXE); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"-I lanplus -H 10.0.0.5 -p 6230 -U admin -E chassis bootdev pxe",
+		"-I lanplus -H 10.0.0.5 -p 6230 -U admin -E chassis power status",
+		"-I lanplus -H 10.0.0.5 -p 6230 -U admin -E chassis power cycle",
+	}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("expected calls:\n%q\ngot:\n%q", expected, calls)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(Config{Username: "admin"}, nil); err == nil {
+		t.Error("expected an error without address")
+	}
+	if _, err := New(Config{Address: "10.0.0.5", Protocol: "amt"}, nil); err == nil {
+		t.Error("expected an error for an unknown protocol")
+	}
+	if _, err := New(Config{Address: "10.0.0.5", PasswordEnv: "TALM_TEST_UNSET_PASSWORD"}, nil); err == nil {
+		t.Error("expected an error for an unset password variable")
+	}
+}
@@ -0,0 +1,101 @@
+package bmc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// ipmi controls the machine with ipmitool over the IPMI v2.0 LAN interface.
+type ipmi struct {
+	host     string
+	port     string
+	username string
+	password string
+	run      func(ctx context.Context, args []string, env []string) ([]byte, error)
+}
+
+func newIPMI(cfg Config, password string) *ipmi {
+	host, port, err := net.SplitHostPort(cfg.Address)
+	if err != nil {
+		host, port = cfg.Address, ""
+	}
+
+	return &ipmi{
+		host:     host,
+		port:     port,
+		username: cfg.Username,
+		password: password,
+		run:      runIPMITool,
+	}
+}
+
+func runIPMITool(ctx context.Context, args []string, env []string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "ipmitool", args...)
+	cmd.Env = append(os.Environ(), env...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, fmt.Errorf("ipmitool: %s", message)
+		}
+		return nil, fmt.Errorf("ipmitool: %w", err)
+	}
+	return out, nil
+}
+
+// chassis runs an ipmitool chassis command, the password is passed in the environment
+// so it doesn't show up in the process list.
+func (i *ipmi) chassis(ctx context.Context, args ...string) ([]byte, error) {
+	cmd := []string{"-I", "lanplus", "-H", i.host}
+	if i.port != "" {
+		cmd = append(cmd, "-p", i.port)
+	}
+	cmd = append(cmd, "-U", i.username, "-E", "chassis")
+	return i.run(ctx, append(cmd, args...), []string{"IPMI_PASSWORD=" + i.password})
+}
+
+func (i *ipmi) PowerState(ctx context.Context) (string, error) {
+	out, err := i.chassis(ctx, "power", "status")
+	if err != nil {
+		return "", err
+	}
+	// Chassis Power is on
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("unexpected power status %q", out)
+	}
+	return strings.ToLower(fields[len(fields)-1]), nil
+}
+
+func (i *ipmi) Power(ctx context.Context, action Action) error {
+	switch action {
+	case On, Off:
+		_, err := i.chassis(ctx, "power", string(action))
+		return err
+	case PXE:
+		if _, err := i.chassis(ctx, "bootdev", "pxe"); err != nil {
+			return fmt.Errorf("error setting PXE boot: %w", err)
+		}
+		fallthrough
+	case Cycle:
+		state, err := i.PowerState(ctx)
+		if err != nil {
+			return err
+		}
+		// Power cycle fails on a machine which is off
+		if state == "off" {
+			_, err = i.chassis(ctx, "power", "on")
+			return err
+		}
+		_, err = i.chassis(ctx, "power", "cycle")
+		return err
+	default:
+		return fmt.Errorf("unknown power action %q", action)
+	}
+}
@@ -0,0 +1,154 @@
+package bmc
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// redfish controls the first system of a Redfish service.
+type redfish struct {
+	base     string
+	username string
+	password string
+	client   *http.Client
+}
+
+func newRedfish(cfg Config, password string, dial DialFunc) *redfish {
+	base := strings.TrimSuffix(cfg.Address, "/")
+	if !strings.Contains(base, "://") {
+		base = "https://" + base
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: cfg.Insecure} //nolint:gosec
+	if dial != nil {
+		transport.Proxy = nil
+		transport.DialContext = dial
+	}
+
+	return &redfish{
+		base:     base,
+		username: cfg.Username,
+		password: password,
+		client:   &http.Client{Transport: transport, Timeout: 30 * time.Second},
+	}
+}
+
+func (r *redfish) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, r.base+path, reader)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(r.username, r.password)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512)) //nolint:errcheck
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, bytes.TrimSpace(message))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// system returns the path of the first system, BMCs of single machines manage only one.
+func (r *redfish) system(ctx context.Context) (string, error) {
+	var systems struct {
+		Members []struct {
+			ID string `json:"@odata.id"`
+		} `json:"Members"`
+	}
+	if err := r.do(ctx, http.MethodGet, "/redfish/v1/Systems", nil, &systems); err != nil {
+		return "", err
+	}
+	if len(systems.Members) == 0 {
+		return "", fmt.Errorf("no systems managed by the BMC")
+	}
+	return systems.Members[0].ID, nil
+}
+
+func (r *redfish) PowerState(ctx context.Context) (string, error) {
+	system, err := r.system(ctx)
+	if err != nil {
+		return "", err
+	}
+	return r.powerState(ctx, system)
+}
+
+func (r *redfish) powerState(ctx context.Context, system string) (string, error) {
+	var state struct {
+		PowerState string `json:"PowerState"`
+	}
+	if err := r.do(ctx, http.MethodGet, system, nil, &state); err != nil {
+		return "", err
+	}
+	return strings.ToLower(state.PowerState), nil
+}
+
+func (r *redfish) reset(ctx context.Context, system, resetType string) error {
+	return r.do(ctx, http.MethodPost, system+"/Actions/ComputerSystem.Reset", map[string]string{"ResetType": resetType}, nil)
+}
+
+func (r *redfish) Power(ctx context.Context, action Action) error {
+	system, err := r.system(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case On:
+		return r.reset(ctx, system, "On")
+	case Off:
+		return r.reset(ctx, system, "ForceOff")
+	case PXE:
+		boot := map[string]interface{}{
+			"Boot": map[string]string{
+				"BootSourceOverrideTarget":  "Pxe",
+				"BootSourceOverrideEnabled": "Once",
+			},
+		}
+		if err := r.do(ctx, http.MethodPatch, system, boot, nil); err != nil {
+			return fmt.Errorf("error setting PXE boot: %w", err)
+		}
+		fallthrough
+	case Cycle:
+		state, err := r.powerState(ctx, system)
+		if err != nil {
+			return err
+		}
+		if state == "off" {
+			return r.reset(ctx, system, "On")
+		}
+		// ForceRestart is supported more widely than PowerCycle
+		return r.reset(ctx, system, "ForceRestart")
+	default:
+		return fmt.Errorf("unknown power action %q", action)
+	}
+}
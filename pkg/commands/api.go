@@ -0,0 +1,198 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/aenix-io/talm/pkg/engine"
+	"github.com/cosi-project/runtime/pkg/resource"
+	"github.com/cosi-project/runtime/pkg/state"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/metadata"
+	"gopkg.in/yaml.v3"
+
+	"github.com/siderolabs/talos/pkg/machinery/client"
+)
+
+var apiGetCmdFlags struct {
+	configFiles []string
+	namespace   string
+	output      string
+	watch       bool
+}
+
+var apiCmd = &cobra.Command{
+	Use:   "api",
+	Short: "Access the Talos API as the templates do",
+	Long:  ``,
+}
+
+var apiGetCmd = &cobra.Command{
+	Use:   "get <type> [<id>]",
+	Short: "Get or watch resources as the lookup function returns them to the templates",
+	Long: `Get the resources of the type, or the resource with the id, and print them as the lookup
+function returns them to the templates: the metadata and the spec of a resource, and for a list
+a List whose items are keyed _0, _1, ... This helps to write the expressions of the templates:
+
+  talm api get links --namespace network -o yaml
+  {{ (lookup "links" "network" "eth0").spec.hardwareAddr }}
+
+With --watch the resources are printed again on every change, with the event type.`,
+	Args: cobra.RangeArgs(1, 2),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if apiGetCmdFlags.output != "yaml" && apiGetCmdFlags.output != "json" {
+			return fmt.Errorf("unknown output %q, use yaml or json", apiGetCmdFlags.output)
+		}
+		nodesFromArgs := len(GlobalArgs.Nodes) > 0
+		endpointsFromArgs := len(GlobalArgs.Endpoints) > 0
+		for _, configFile := range apiGetCmdFlags.configFiles {
+			if err := processModelineAndUpdateGlobals(configFile, nodesFromArgs, endpointsFromArgs, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		kind := args[0]
+		var id string
+		if len(args) == 2 {
+			id = args[1]
+		}
+
+		return WithClient(func(ctx context.Context, c *client.Client) error {
+			md, _ := metadata.FromOutgoingContext(ctx)
+			nodes := md.Get("nodes")
+			if len(nodes) == 0 {
+				// use "current" node
+				nodes = []string{""}
+			}
+
+			if apiGetCmdFlags.watch {
+				return apiWatch(ctx, c, nodes, kind, id)
+			}
+
+			for _, node := range nodes {
+				nodeCtx := ctx
+				if node != "" {
+					nodeCtx = client.WithNode(ctx, node)
+				}
+				data, err := engine.NewNode(c).Lookup(nodeCtx, kind, apiGetCmdFlags.namespace, id)
+				if err != nil {
+					return err
+				}
+				if err := writeAPIResource(os.Stdout, node, "", data); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	},
+}
+
+// apiWatch prints the resources as they change on the nodes until interrupted.
+func apiWatch(ctx context.Context, c *client.Client, nodes []string, kind, id string) error {
+	// fetch the RD from the first node (it doesn't matter which one to use, so we'll use the first one)
+	namespace := apiGetCmdFlags.namespace
+	rd, err := c.ResolveResourceKind(client.WithNode(ctx, nodes[0]), &namespace, kind)
+	if err != nil {
+		return err
+	}
+	kind = rd.TypedSpec().Type
+
+	aggregatedCh := make(chan nodeAndEvent)
+	for _, node := range nodes {
+		nodeCtx := ctx
+		if node != "" {
+			nodeCtx = client.WithNode(ctx, node)
+		}
+
+		watchCh := make(chan state.Event)
+		if id == "" {
+			err = c.COSI.WatchKind(nodeCtx, resource.NewMetadata(namespace, kind, "", resource.VersionUndefined), watchCh,
+				state.WithBootstrapContents(true),
+				state.WithWatchKindUnmarshalOptions(state.WithSkipProtobufUnmarshal()),
+			)
+		} else {
+			err = c.COSI.Watch(nodeCtx, resource.NewMetadata(namespace, kind, id, resource.VersionUndefined), watchCh,
+				state.WithWatchUnmarshalOptions(state.WithSkipProtobufUnmarshal()),
+			)
+		}
+		if err != nil {
+			return fmt.Errorf("error setting up watch on node %s: %w", node, err)
+		}
+
+		go aggregateEvents(ctx, aggregatedCh, watchCh, node)
+	}
+
+	for {
+		var nev nodeAndEvent
+		select {
+		case nev = <-aggregatedCh:
+		case <-ctx.Done():
+			return nil
+		}
+
+		switch {
+		case nev.ev.Type == state.Errored:
+			return fmt.Errorf("error watching resource: %w", nev.ev.Error)
+		case nev.ev.Resource == nil:
+			// bootstrapped and new event types without resource
+			continue
+		}
+
+		data, err := engine.ResourceData(nev.ev.Resource)
+		if err != nil {
+			return err
+		}
+		if err := writeAPIResource(os.Stdout, nev.node, strings.ToLower(nev.ev.Type.String()), data); err != nil {
+			return err
+		}
+	}
+}
+
+// writeAPIResource prints the resource as a YAML document headed by a comment naming the node
+// and the event, or as a JSON line.
+func writeAPIResource(w io.Writer, node, event string, data map[string]interface{}) error {
+	if apiGetCmdFlags.output == "json" {
+		return json.NewEncoder(w).Encode(struct {
+			Node     string                 `json:"node,omitempty"`
+			Event    string                 `json:"event,omitempty"`
+			Resource map[string]interface{} `json:"resource"`
+		}{node, event, data})
+	}
+
+	var header []string
+	if node != "" {
+		header = append(header, "node="+node)
+	}
+	if event != "" {
+		header = append(header, "event="+event)
+	}
+	out, err := yaml.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if len(header) > 0 {
+		out = append([]byte("# "+strings.Join(header, ", ")+"\n"), out...)
+	}
+	_, err = fmt.Fprintf(w, "---\n%s", out)
+	return err
+}
+
+func init() {
+	apiGetCmd.Flags().StringSliceVarP(&apiGetCmdFlags.configFiles, "file", "f", nil, "specify config files or patches in a YAML file (can specify multiple)")
+	apiGetCmd.Flags().StringVar(&apiGetCmdFlags.namespace, "namespace", "", "resource namespace (default is to use default namespace per resource)")
+	apiGetCmd.Flags().StringVarP(&apiGetCmdFlags.output, "output", "o", "yaml", "output mode (yaml, json)")
+	apiGetCmd.Flags().BoolVarP(&apiGetCmdFlags.watch, "watch", "w", false, "watch resource changes")
+
+	apiCmd.AddCommand(apiGetCmd)
+	addCommand(apiCmd)
+}
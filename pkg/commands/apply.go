@@ -13,6 +13,10 @@ import (
 	"time"
 
 	"github.com/aenix-io/talm/pkg/engine"
+	"github.com/aenix-io/talm/pkg/hooks"
+	"github.com/aenix-io/talm/pkg/release"
+	"github.com/aenix-io/talm/pkg/sections"
+	"github.com/aenix-io/talm/pkg/validators"
 	"github.com/spf13/cobra"
 	"google.golang.org/protobuf/types/known/durationpb"
 
@@ -35,6 +39,13 @@ var applyCmdFlags struct {
 	stage             bool
 	force             bool
 	configTryTimeout  time.Duration
+	changedOnly       bool
+	forceConflicts    bool
+	ignoreWindows     bool
+	discover          []string
+	skipSecureBoot    bool
+	sections          []string
+	atomic            bool
 }
 
 var applyCmd = &cobra.Command{
@@ -61,9 +72,39 @@ var applyCmd = &cobra.Command{
 		if !cmd.Flags().Changed("force") {
 			applyCmdFlags.force = Config.UpgradeOptions.Force
 		}
+		if !cmd.Flags().Changed("cert-fingerprint") {
+			applyCmdFlags.certFingerprints = Config.ApplyOptions.CertFingerprints
+		}
+		if !cmd.Flags().Changed("timeout") && Config.ApplyOptions.TimeoutDuration != 0 {
+			applyCmdFlags.configTryTimeout = Config.ApplyOptions.TimeoutDuration
+		}
+		if len(applyCmdFlags.sections) > 0 {
+			if applyCmdFlags.insecure {
+				return errors.New("--sections applies parts of the config on top of the current one, nodes in maintenance mode have none")
+			}
+			if _, err := sections.Parse(applyCmdFlags.sections); err != nil {
+				return err
+			}
+		}
+		if applyCmdFlags.atomic {
+			switch {
+			case applyCmdFlags.insecure:
+				return errors.New("--atomic rolls back to the configs of the nodes, nodes in maintenance mode have none")
+			case len(applyCmdFlags.sections) > 0, applyCmdFlags.changedOnly:
+				return errors.New("--atomic applies the whole configs of all node files, it can't be combined with --sections or --changed-only")
+			case applyCmdFlags.Mode.Mode == machineapi.ApplyConfigurationRequest_TRY:
+				return errors.New("--atomic confirms the configs itself, it can't be combined with try mode")
+			}
+		}
+		if len(applyCmdFlags.discover) > 0 && !applyCmdFlags.insecure {
+			return errors.New("--discover finds nodes in maintenance mode, it requires --insecure")
+		}
 		return nil
 	},
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := checkOmniManaged(); err != nil {
+			return err
+		}
 		return WithClientNoNodes(apply(args))
 	},
 }
@@ -72,27 +113,87 @@ func apply(args []string) func(ctx context.Context, c *client.Client) error {
 	return func(ctx context.Context, c *client.Client) error {
 		nodesFromArgs := len(GlobalArgs.Nodes) > 0
 		endpointsFromArgs := len(GlobalArgs.Endpoints) > 0
-		for _, configFile := range applyCmdFlags.configFiles {
+		if err := Config.Hooks.Check(); err != nil {
+			return err
+		}
+
+		applyCmdFlags.configFiles = orderConfigFiles(applyCmdFlags.configFiles)
+		targets, err := applyTargets(applyCmdFlags.configFiles, nodesFromArgs, endpointsFromArgs)
+		if err != nil {
+			return err
+		}
+		if applyCmdFlags.atomic {
+			cache, err := loadAppliedCache()
+			if err != nil {
+				return err
+			}
+			return applyAtomic(ctx, c, cache, targets, nodesFromArgs, endpointsFromArgs)
+		}
+
+		// Node files split per machine type are processed once per machine type
+		files := make([]string, len(targets))
+		for i, target := range targets {
+			files[i] = target.file
+		}
+		// Files skipped because of the plan were processed too
+		completed, skipped := []string{}, []string{}
+		defer func() { printInterruptSummary(ctx, append(completed, skipped...), files) }()
+
+		cache, err := loadAppliedCache()
+		if err != nil {
+			return err
+		}
+
+		// Nodes in maintenance mode are checked as a group before the first config is applied
+		var (
+			plan     bootstrapPlan
+			released []release.File
+		)
+		if applyCmdFlags.insecure {
+			if plan, err = planBootstrap(ctx, applyCmdFlags.configFiles, nodesFromArgs, cache); err != nil {
+				return err
+			}
+		}
+
+		// The nodes and endpoints of the modeline of a file are not carried over to the next file
+		resetArgs := func() {
+			if !nodesFromArgs {
+				GlobalArgs.Nodes = []string{}
+			}
+			if !endpointsFromArgs {
+				GlobalArgs.Endpoints = []string{}
+			}
+		}
+		defer resetArgs()
+
+		for _, target := range targets {
+			resetArgs()
+			configFile := target.file
 			if err := processModelineAndUpdateGlobals(configFile, nodesFromArgs, endpointsFromArgs, true); err != nil {
 				return err
 			}
+			GlobalArgs.Nodes = target.nodes
 
-			opts := engine.Options{
-				TalosVersion:      applyCmdFlags.talosVersion,
-				WithSecrets:       applyCmdFlags.withSecrets,
-				KubernetesVersion: applyCmdFlags.kubernetesVersion,
+			if reason, ok := plan.skip[configFile]; ok {
+				fmt.Printf("- talm: file=%s, nodes=%s, %s, skipping\n", configFile, GlobalArgs.Nodes, reason)
+				if plan.provisioned[configFile] {
+					completed = append(completed, configFile)
+				} else {
+					skipped = append(skipped, configFile)
+				}
+				continue
 			}
 
-			patches := []string{"@" + configFile}
-			configBundle, err := engine.FullConfigProcess(ctx, opts, patches)
+			result, configBundle, err := renderApplyConfig(ctx, c, target)
 			if err != nil {
-				return fmt.Errorf("full config processing error: %s", err)
+				return err
 			}
 
-			machineType := configBundle.ControlPlaneCfg.Machine().Type()
-			result, err := engine.SerializeConfiguration(configBundle, machineType)
-			if err != nil {
-				return fmt.Errorf("error serializing configuration: %s", err)
+			hash := configHash(result)
+			if applyCmdFlags.changedOnly && cache.unchanged(GlobalArgs.Nodes, hash) {
+				fmt.Printf("- talm: file=%s, nodes=%s, unchanged, skipping\n", configFile, GlobalArgs.Nodes)
+				completed = append(completed, configFile)
+				continue
 			}
 
 			withClient := func(f func(ctx context.Context, c *client.Client) error) error {
@@ -116,9 +217,83 @@ func apply(args []string) func(ctx context.Context, c *client.Client) error {
 				})
 			}
 
+			// External validators can veto the config before it reaches the nodes
+			if err := validators.Run(ctx, Config.ApplyOptions.Validators, validators.Request{
+				File:   configFile,
+				Nodes:  GlobalArgs.Nodes,
+				DryRun: applyCmdFlags.dryRun,
+				Config: string(result),
+			}); err != nil {
+				return err
+			}
+
+			// The other sections of the node configs are kept, so there is nothing to conflict with
+			if len(applyCmdFlags.sections) > 0 {
+				err = withClient(func(ctx context.Context, c *client.Client) error {
+					return applySections(ctx, c, configFile, result, cache)
+				})
+				if err != nil {
+					return err
+				}
+				completed = append(completed, configFile)
+				continue
+			}
+
+			// A node booted in another mode than the installer image expects doesn't boot after the install
+			if !applyCmdFlags.skipSecureBoot {
+				image := configBundle.ControlPlaneCfg.Machine().Install().Image()
+				err = withClient(func(ctx context.Context, c *client.Client) error {
+					return checkSecureBoot(ctx, c, configFile, image)
+				})
+				if err != nil {
+					return err
+				}
+			}
+
+			normalized, err := comparableConfig(result)
+			if err != nil {
+				return fmt.Errorf("error encoding configuration: %s", err)
+			}
+
+			// Nodes in maintenance mode run no workloads to disrupt
+			if !applyCmdFlags.dryRun && !applyCmdFlags.insecure && !applyCmdFlags.ignoreWindows {
+				closed, next, err := maintenanceWindowClosed(GlobalArgs.Nodes, time.Now())
+				if err != nil {
+					return err
+				}
+				reboot := false
+				if closed {
+					err = withClient(func(ctx context.Context, c *client.Client) error {
+						reboot, err = applyRequiresReboot(ctx, c, result)
+						return err
+					})
+					if err != nil {
+						return err
+					}
+				}
+				if reboot {
+					reportPending(configFile, "reboot-requiring apply", next)
+					continue
+				}
+			}
+
+			// Hooks prepare the nodes for the change, they are not run in dry-run mode
+			if !applyCmdFlags.dryRun {
+				if err := runHooks(ctx, hooks.Pre, "apply", configFile, configBundle.ControlPlaneCfg); err != nil {
+					return err
+				}
+			}
+
 			err = withClient(func(ctx context.Context, c *client.Client) error {
 				fmt.Printf("- talm: file=%s, nodes=%s, endpoints=%s\n", configFile, GlobalArgs.Nodes, GlobalArgs.Endpoints)
 
+				// Nodes in maintenance mode have no config to conflict with
+				if !applyCmdFlags.insecure {
+					if err := checkConflicts(ctx, c, cache, configFile, normalized); err != nil {
+						return err
+					}
+				}
+
 				resp, err := c.ApplyConfiguration(ctx, &machineapi.ApplyConfigurationRequest{
 					Data:           result,
 					Mode:           applyCmdFlags.Mode.Mode,
@@ -131,24 +306,82 @@ func apply(args []string) func(ctx context.Context, c *client.Client) error {
 
 				helpers.PrintApplyResults(resp)
 
+				if !applyCmdFlags.dryRun && applyCmdFlags.Mode.Mode == machineapi.ApplyConfigurationRequest_TRY {
+					fmt.Fprintf(os.Stderr, "Config will be rolled back in %s unless confirmed with `talm confirm -f %s`\n", applyCmdFlags.configTryTimeout, configFile)
+				}
+
+				// Try mode is rolled back automatically, so it is not recorded as applied
+				if !applyCmdFlags.dryRun && applyCmdFlags.Mode.Mode != machineapi.ApplyConfigurationRequest_TRY {
+					cache.record(GlobalArgs.Nodes, configFile, hash, appliedConfigHash(applyCmdFlags.Mode.Mode, normalized))
+					if err := cache.save(); err != nil {
+						return fmt.Errorf("error saving applied config cache: %w", err)
+					}
+					file, err := releaseFile(configFile, GlobalArgs.Nodes, hash)
+					if err != nil {
+						return err
+					}
+					released = append(released, file)
+				}
+
 				return nil
 			})
 			if err != nil {
 				return err
 			}
-
-			// Reset args
-			if !nodesFromArgs {
-				GlobalArgs.Nodes = []string{}
+			if !applyCmdFlags.dryRun {
+				if err := runHooks(ctx, hooks.Post, "apply", configFile, configBundle.ControlPlaneCfg); err != nil {
+					return err
+				}
 			}
-			if !endpointsFromArgs {
-				GlobalArgs.Endpoints = []string{}
+			completed = append(completed, configFile)
+		}
+
+		if len(released) > 0 {
+			if err := recordRelease(ctx, released); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: the configs were applied, but the release was not recorded: %v\n", err)
 			}
 		}
+		if !applyCmdFlags.dryRun && len(completed) > 0 {
+			printNotes("apply")
+		}
+		if len(skipped) > 0 {
+			return fmt.Errorf("%d of %d files not applied, their nodes are unreachable or not in maintenance mode: %s", len(skipped), len(files), skipped)
+		}
 		return nil
 	}
 }
 
+// renderApplyConfig renders the node file of the target with the flags of apply, the Talos
+// version of configured nodes is resolved from the nodes.
+func renderApplyConfig(ctx context.Context, c *client.Client, target applyTarget) ([]byte, *engine.ConfigBundle, error) {
+	talosVersion := applyCmdFlags.talosVersion
+	if !applyCmdFlags.insecure {
+		talosVersion = engine.ResolveTalosVersion(client.WithNodes(ctx, GlobalArgs.Nodes...), c, talosVersion)
+	}
+
+	opts := engine.Options{
+		TalosVersion:      talosVersion,
+		WithSecrets:       applyCmdFlags.withSecrets,
+		KubernetesVersion: applyCmdFlags.kubernetesVersion,
+	}
+
+	patch, err := target.applyPatch()
+	if err != nil {
+		return nil, nil, err
+	}
+	configBundle, err := engine.FullConfigProcess(ctx, opts, []string{patch})
+	if err != nil {
+		return nil, nil, fmt.Errorf("full config processing error: %s", err)
+	}
+
+	machineType := configBundle.ControlPlaneCfg.Machine().Type()
+	result, err := engine.SerializeConfiguration(configBundle, machineType)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error serializing configuration: %s", err)
+	}
+	return result, configBundle, nil
+}
+
 // readFirstLine reads and returns the first line of the file specified by the filename.
 // It returns an error if opening or reading the file fails.
 func readFirstLine(filename string) (string, error) {
@@ -180,13 +413,20 @@ func init() {
 	applyCmd.Flags().BoolVarP(&applyCmdFlags.insecure, "insecure", "i", false, "apply using the insecure (encrypted with no auth) maintenance service")
 	applyCmd.Flags().StringSliceVarP(&applyCmdFlags.configFiles, "file", "f", nil, "specify config files or patches in a YAML file (can specify multiple)")
 	applyCmd.Flags().StringVar(&applyCmdFlags.talosVersion, "talos-version", "", "the desired Talos version to generate config for (backwards compatibility, e.g. v0.8)")
-	applyCmd.Flags().StringVar(&applyCmdFlags.withSecrets, "with-secrets", "", "use a secrets file generated using 'gen secrets'")
+	applyCmd.Flags().StringVar(&applyCmdFlags.withSecrets, "with-secrets", "", "use a secrets file generated using 'gen secrets', - reads it from stdin")
 
 	applyCmd.Flags().StringVar(&applyCmdFlags.kubernetesVersion, "kubernetes-version", constants.DefaultKubernetesVersion, "desired kubernetes version to run")
 	applyCmd.Flags().BoolVar(&applyCmdFlags.dryRun, "dry-run", false, "check how the config change will be applied in dry-run mode")
 	applyCmd.Flags().DurationVar(&applyCmdFlags.configTryTimeout, "timeout", constants.ConfigTryTimeout, "the config will be rolled back after specified timeout (if try mode is selected)")
-	applyCmd.Flags().StringSliceVar(&applyCmdFlags.certFingerprints, "cert-fingerprint", nil, "list of server certificate fingeprints to accept (defaults to no check)")
+	applyCmd.Flags().StringSliceVar(&applyCmdFlags.certFingerprints, "cert-fingerprint", nil, "list of server certificate fingeprints to accept (defaults to the fingerprints pinned on first use)")
 	applyCmd.Flags().BoolVar(&applyCmdFlags.force, "force", false, "will overwrite existing files")
+	applyCmd.Flags().BoolVar(&applyCmdFlags.changedOnly, "changed-only", false, fmt.Sprintf("skip nodes whose rendered config matches the last applied one (hashes are stored in %s)", appliedCacheFile))
+	applyCmd.Flags().BoolVar(&applyCmdFlags.forceConflicts, "force-conflicts", false, "apply even if the config of the node was changed outside talm since it was last applied")
+	applyCmd.Flags().BoolVar(&applyCmdFlags.ignoreWindows, "ignore-maintenance-windows", false, "apply configs requiring a reboot outside the maintenance windows of the nodes")
+	applyCmd.Flags().StringSliceVar(&applyCmdFlags.discover, "discover", nil, "addresses or networks (CIDR) to scan for nodes in maintenance mode missing from the node files (with --insecure)")
+	applyCmd.Flags().BoolVar(&applyCmdFlags.skipSecureBoot, "skip-secureboot-check", false, "apply even if the nodes booted in another SecureBoot mode than the installer image of the config expects")
+	applyCmd.Flags().StringSliceVar(&applyCmdFlags.sections, "sections", nil, "apply only these sections of the config on top of the current config of the nodes, e.g. machine.registries,machine.files")
+	applyCmd.Flags().BoolVar(&applyCmdFlags.atomic, "atomic", false, "dry-run the configs of all node files first and roll back the nodes already applied if one of them fails")
 	helpers.AddModeFlags(&applyCmdFlags.Mode, applyCmd)
 
 	addCommand(applyCmd)
@@ -0,0 +1,261 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aenix-io/talm/pkg/engine"
+	"github.com/aenix-io/talm/pkg/hooks"
+	"github.com/aenix-io/talm/pkg/release"
+	"github.com/aenix-io/talm/pkg/validators"
+	"github.com/cosi-project/runtime/pkg/safe"
+
+	"github.com/siderolabs/talos/cmd/talosctl/pkg/talos/helpers"
+	machineapi "github.com/siderolabs/talos/pkg/machinery/api/machine"
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	configres "github.com/siderolabs/talos/pkg/machinery/resources/config"
+)
+
+// groupMember is a node file of an atomic apply with the config rendered for its nodes.
+type groupMember struct {
+	file      string
+	nodes     []string
+	endpoints []string
+	config    []byte
+	bundle    *engine.ConfigBundle
+	previous  map[string][]byte
+}
+
+// withClient runs the action against the nodes and endpoints of the node file.
+func (m *groupMember) withClient(action func(ctx context.Context, c *client.Client) error) error {
+	GlobalArgs.Nodes = m.nodes
+	GlobalArgs.Endpoints = m.endpoints
+	return WithClientNoNodes(func(ctx context.Context, c *client.Client) error {
+		return action(client.WithNodes(ctx, m.nodes...), c)
+	})
+}
+
+// applyAtomic applies the node files as a group: every config is checked with a dry-run apply on
+// its nodes first, then they are applied in turn. If a node file fails to apply, the configs the
+// nodes ran before are applied again to the nodes of the node files applied so far, including the
+// failed one, as some of its nodes may already run the new config.
+func applyAtomic(ctx context.Context, c *client.Client, cache appliedCache, targets []applyTarget, nodesFromArgs, endpointsFromArgs bool) error {
+	var members []*groupMember
+	for _, target := range targets {
+		configFile := target.file
+		if err := processModelineAndUpdateGlobals(configFile, nodesFromArgs, endpointsFromArgs, true); err != nil {
+			return err
+		}
+		GlobalArgs.Nodes = target.nodes
+
+		result, configBundle, err := renderApplyConfig(ctx, c, target)
+		if err != nil {
+			return err
+		}
+		members = append(members, &groupMember{
+			file:      configFile,
+			nodes:     GlobalArgs.Nodes,
+			endpoints: GlobalArgs.Endpoints,
+			config:    result,
+			bundle:    configBundle,
+			previous:  map[string][]byte{},
+		})
+
+		if !nodesFromArgs {
+			GlobalArgs.Nodes = []string{}
+		}
+		if !endpointsFromArgs {
+			GlobalArgs.Endpoints = []string{}
+		}
+	}
+
+	// Nothing is applied until every node accepted its config
+	for _, member := range members {
+		if err := validateGroupMember(ctx, member, cache); err != nil {
+			return fmt.Errorf("%w, no config of the group was applied", err)
+		}
+	}
+	if applyCmdFlags.dryRun {
+		return nil
+	}
+
+	if err := applyGroup(ctx, members); err != nil {
+		return err
+	}
+
+	var released []release.File
+	for _, member := range members {
+		normalized, err := comparableConfig(member.config)
+		if err != nil {
+			return fmt.Errorf("error encoding configuration: %s", err)
+		}
+		hash := configHash(member.config)
+		cache.record(member.nodes, member.file, hash, appliedConfigHash(applyCmdFlags.Mode.Mode, normalized))
+
+		file, err := releaseFile(member.file, member.nodes, hash)
+		if err != nil {
+			return err
+		}
+		released = append(released, file)
+
+		GlobalArgs.Nodes = member.nodes
+		if err := runHooks(ctx, hooks.Post, "apply", member.file, member.bundle.ControlPlaneCfg); err != nil {
+			return err
+		}
+	}
+	if err := cache.save(); err != nil {
+		return fmt.Errorf("error saving applied config cache: %w", err)
+	}
+	if err := recordRelease(ctx, released); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: the configs were applied, but the release was not recorded: %v\n", err)
+	}
+	printNotes("apply")
+	return nil
+}
+
+// applyMember and rollbackNode change the nodes of the group, they are replaced in tests.
+var (
+	applyMember  = applyGroupMember
+	rollbackNode = rollbackGroupNode
+)
+
+// applyGroup applies the configs of the members in turn and rolls the group back if one fails.
+func applyGroup(ctx context.Context, members []*groupMember) error {
+	for i, member := range members {
+		if err := applyMember(ctx, member); err != nil {
+			fmt.Fprintf(os.Stderr, "Error applying %s: %s, rolling back the group\n", member.file, err)
+			if rollbackErr := rollbackGroup(members[:i+1]); rollbackErr != nil {
+				return fmt.Errorf("error applying %s: %w; rollback failed: %w", member.file, err, rollbackErr)
+			}
+			return fmt.Errorf("error applying %s: %w; the nodes of the group run their previous configs again", member.file, err)
+		}
+	}
+	return nil
+}
+
+// validateGroupMember checks the config of the node file as apply does, dry-runs it on the nodes
+// and reads the configs the nodes run to roll them back.
+func validateGroupMember(ctx context.Context, member *groupMember, cache appliedCache) error {
+	if err := validators.Run(ctx, Config.ApplyOptions.Validators, validators.Request{
+		File:   member.file,
+		Nodes:  member.nodes,
+		DryRun: true,
+		Config: string(member.config),
+	}); err != nil {
+		return err
+	}
+
+	normalized, err := comparableConfig(member.config)
+	if err != nil {
+		return fmt.Errorf("error encoding configuration: %s", err)
+	}
+
+	return member.withClient(func(ctx context.Context, c *client.Client) error {
+		if !applyCmdFlags.skipSecureBoot {
+			if err := checkSecureBoot(ctx, c, member.file, member.bundle.ControlPlaneCfg.Machine().Install().Image()); err != nil {
+				return err
+			}
+		}
+		if err := checkConflicts(ctx, c, cache, member.file, normalized); err != nil {
+			return err
+		}
+
+		// The whole group is applied at once, it can't wait for the maintenance windows of some nodes
+		if !applyCmdFlags.ignoreWindows {
+			closed, next, err := maintenanceWindowClosed(member.nodes, time.Now())
+			if err != nil {
+				return err
+			}
+			if closed {
+				reboot, err := applyRequiresReboot(ctx, c, member.config)
+				if err != nil {
+					return err
+				}
+				if reboot {
+					return fmt.Errorf("%s requires a reboot outside the maintenance windows of its nodes (next opening %s)", member.file, next.Format(time.RFC3339))
+				}
+			}
+		}
+
+		fmt.Printf("- talm: file=%s, nodes=%s, endpoints=%s, dry-run\n", member.file, member.nodes, member.endpoints)
+		resp, err := c.ApplyConfiguration(ctx, &machineapi.ApplyConfigurationRequest{
+			Data:   member.config,
+			Mode:   applyCmdFlags.Mode.Mode,
+			DryRun: true,
+		})
+		if err != nil {
+			return fmt.Errorf("dry-run of %s failed: %s", member.file, err)
+		}
+		if applyCmdFlags.dryRun {
+			helpers.PrintApplyResults(resp)
+		}
+
+		for _, node := range member.nodes {
+			machineConfig, err := safe.StateGetByID[*configres.MachineConfig](client.WithNode(ctx, node), c.COSI, configres.V1Alpha1ID)
+			if err != nil {
+				return fmt.Errorf("error reading current config of node %s: %w", node, err)
+			}
+			previous, err := machineConfig.Provider().Bytes()
+			if err != nil {
+				return fmt.Errorf("error encoding current config of node %s: %w", node, err)
+			}
+			member.previous[node] = previous
+		}
+		return nil
+	})
+}
+
+func applyGroupMember(ctx context.Context, member *groupMember) error {
+	GlobalArgs.Nodes = member.nodes
+	if err := runHooks(ctx, hooks.Pre, "apply", member.file, member.bundle.ControlPlaneCfg); err != nil {
+		return err
+	}
+
+	return member.withClient(func(ctx context.Context, c *client.Client) error {
+		fmt.Printf("- talm: file=%s, nodes=%s, endpoints=%s\n", member.file, member.nodes, member.endpoints)
+		resp, err := c.ApplyConfiguration(ctx, &machineapi.ApplyConfigurationRequest{
+			Data: member.config,
+			Mode: applyCmdFlags.Mode.Mode,
+		})
+		if err != nil {
+			return err
+		}
+		helpers.PrintApplyResults(resp)
+		return nil
+	})
+}
+
+// rollbackGroup applies the previous configs to the nodes of the members in reverse order, in
+// auto mode as the new configs may have required a reboot. Every node is tried, the errors are joined.
+func rollbackGroup(members []*groupMember) error {
+	var errs []error
+	for i := len(members) - 1; i >= 0; i-- {
+		member := members[i]
+		for _, node := range member.nodes {
+			if err := rollbackNode(member, node); err != nil {
+				errs = append(errs, fmt.Errorf("node %s: %w", node, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// rollbackGroupNode applies the config the node ran before the group was applied.
+func rollbackGroupNode(member *groupMember, node string) error {
+	target := &groupMember{file: member.file, nodes: []string{node}, endpoints: member.endpoints}
+	return target.withClient(func(ctx context.Context, c *client.Client) error {
+		fmt.Printf("- talm: file=%s, nodes=%s, rolling back\n", member.file, target.nodes)
+		_, err := c.ApplyConfiguration(ctx, &machineapi.ApplyConfigurationRequest{
+			Data: member.previous[node],
+			Mode: machineapi.ApplyConfigurationRequest_AUTO,
+		})
+		return err
+	})
+}
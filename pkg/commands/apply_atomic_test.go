@@ -0,0 +1,84 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestApplyGroupRollback(t *testing.T) {
+	apply, rollback := applyMember, rollbackNode
+	defer func() { applyMember, rollbackNode = apply, rollback }()
+
+	members := []*groupMember{
+		{file: "nodes/cp1.yaml", nodes: []string{"10.0.0.1"}},
+		{file: "nodes/cp2.yaml", nodes: []string{"10.0.0.2", "10.0.0.3"}},
+		{file: "nodes/cp3.yaml", nodes: []string{"10.0.0.4"}},
+		{file: "nodes/cp4.yaml", nodes: []string{"10.0.0.5"}},
+	}
+
+	for _, tt := range []struct {
+		failApply    string
+		failRollback string
+		applied      []string
+		rolledBack   []string
+		err          string
+	}{
+		{
+			applied: []string{"nodes/cp1.yaml", "nodes/cp2.yaml", "nodes/cp3.yaml", "nodes/cp4.yaml"},
+		},
+		// The failed node file is rolled back too, the node files are rolled back in reverse order
+		{
+			failApply:  "nodes/cp3.yaml",
+			applied:    []string{"nodes/cp1.yaml", "nodes/cp2.yaml", "nodes/cp3.yaml"},
+			rolledBack: []string{"10.0.0.4", "10.0.0.2", "10.0.0.3", "10.0.0.1"},
+			err:        "error applying nodes/cp3.yaml: refused; the nodes of the group run their previous configs again",
+		},
+		{
+			failApply:  "nodes/cp1.yaml",
+			applied:    []string{"nodes/cp1.yaml"},
+			rolledBack: []string{"10.0.0.1"},
+			err:        "error applying nodes/cp1.yaml: refused; the nodes",
+		},
+		// Every node is rolled back even if one fails
+		{
+			failApply:    "nodes/cp3.yaml",
+			failRollback: "10.0.0.2",
+			applied:      []string{"nodes/cp1.yaml", "nodes/cp2.yaml", "nodes/cp3.yaml"},
+			rolledBack:   []string{"10.0.0.4", "10.0.0.2", "10.0.0.3", "10.0.0.1"},
+			err:          "error applying nodes/cp3.yaml: refused; rollback failed: node 10.0.0.2: unreachable",
+		},
+	} {
+		var applied, rolledBack []string
+		applyMember = func(_ context.Context, member *groupMember) error {
+			applied = append(applied, member.file)
+			if member.file == tt.failApply {
+				return errors.New("refused")
+			}
+			return nil
+		}
+		rollbackNode = func(_ *groupMember, node string) error {
+			rolledBack = append(rolledBack, node)
+			if node == tt.failRollback {
+				return errors.New("unreachable")
+			}
+			return nil
+		}
+
+		err := applyGroup(context.Background(), members)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: %v", tt.failApply, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: expected error %q, got %v", tt.failApply, tt.err, err)
+		}
+		if !reflect.DeepEqual(applied, tt.applied) {
+			t.Errorf("%s: expected applied %v, got %v", tt.failApply, tt.applied, applied)
+		}
+		if !reflect.DeepEqual(rolledBack, tt.rolledBack) {
+			t.Errorf("%s: expected rolled back %v, got %v", tt.failApply, tt.rolledBack, rolledBack)
+		}
+	}
+}
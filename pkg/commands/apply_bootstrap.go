@@ -0,0 +1,278 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aenix-io/talm/pkg/modeline"
+	"github.com/cosi-project/runtime/pkg/safe"
+
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	"github.com/siderolabs/talos/pkg/machinery/constants"
+	"github.com/siderolabs/talos/pkg/machinery/resources/runtime"
+)
+
+const (
+	// discoverMaxAddresses limits the addresses scanned by --discover.
+	discoverMaxAddresses = 4096
+	// discoverConcurrency is the number of addresses probed at once.
+	discoverConcurrency = 64
+	// discoverDialTimeout limits the connection to an address scanned by --discover.
+	discoverDialTimeout = 2 * time.Second
+)
+
+// nodeState is the state of a node found before bootstrapping a group of nodes.
+type nodeState string
+
+const (
+	nodeMaintenance nodeState = "maintenance"
+	nodeConfigured  nodeState = "configured"
+	nodeUnreachable nodeState = "unreachable"
+)
+
+// bootstrapPlan is the outcome of the checks run before applying a group of node files
+// to nodes in maintenance mode.
+type bootstrapPlan struct {
+	// skip maps the node files not to apply to the reason
+	skip map[string]string
+	// provisioned are the files whose nodes already left maintenance mode with the config applied by talm
+	provisioned map[string]bool
+}
+
+// planBootstrap checks the nodes of the files before anything is applied: the nodes must be in
+// maintenance mode and present the pinned certificates. Files whose nodes have already been
+// provisioned are skipped, so an interrupted bootstrap continues where it stopped. Nodes in
+// maintenance mode found with --discover and not in any file are reported.
+func planBootstrap(ctx context.Context, files []string, nodesFromArgs bool, cache appliedCache) (bootstrapPlan, error) {
+	plan := bootstrapPlan{skip: map[string]string{}, provisioned: map[string]bool{}}
+
+	fileNodes := map[string][]string{}
+	var all []string
+	for _, configFile := range files {
+		nodes := GlobalArgs.Nodes
+		if !nodesFromArgs {
+			modelineConfig, err := modeline.ReadAndParseModeline(configFile)
+			if err != nil {
+				return plan, fmt.Errorf("%s: %w", configFile, err)
+			}
+			nodes = modelineConfig.Nodes
+		}
+		fileNodes[configFile] = nodes
+		all = append(all, nodes...)
+	}
+	slices.Sort(all)
+	all = slices.Compact(all)
+
+	states := probeNodeStates(ctx, all)
+
+	var maintenance []string
+	for _, configFile := range files {
+		var pending, provisioned, failed []string
+		for _, node := range fileNodes[configFile] {
+			switch states[node] {
+			case nodeMaintenance:
+				pending = append(pending, node)
+			case nodeConfigured:
+				if _, ok := cache[node]; ok {
+					provisioned = append(provisioned, node)
+				} else {
+					failed = append(failed, node+" is not in maintenance mode")
+				}
+			default:
+				failed = append(failed, node+" is unreachable")
+			}
+		}
+
+		switch {
+		case len(failed) > 0:
+			plan.skip[configFile] = strings.Join(failed, ", ")
+		case len(pending) == 0 && len(provisioned) > 0:
+			plan.skip[configFile] = "already provisioned"
+			plan.provisioned[configFile] = true
+		case len(provisioned) > 0:
+			plan.skip[configFile] = fmt.Sprintf("nodes %s are in maintenance mode, %s are provisioned: apply them separately", pending, provisioned)
+		default:
+			maintenance = append(maintenance, pending...)
+		}
+	}
+
+	if len(applyCmdFlags.discover) > 0 {
+		discovered, err := discoverMaintenanceNodes(ctx, applyCmdFlags.discover)
+		if err != nil {
+			return plan, err
+		}
+		for _, node := range discovered {
+			if !slices.Contains(all, node) {
+				fmt.Fprintf(os.Stderr, "Warning: node %s is in maintenance mode but in no node file, render its config with `talm -n %s -e %s template -t <template> -i > nodes/<name>.yaml`\n", node, node, node)
+			}
+		}
+	}
+
+	// Every certificate is checked before the first config is sent
+	if len(applyCmdFlags.certFingerprints) == 0 && len(maintenance) > 0 {
+		if _, err := pinnedFingerprints(ctx, maintenance); err != nil {
+			return plan, err
+		}
+	}
+
+	return plan, nil
+}
+
+// probeNodeStates finds out which of the nodes are in maintenance mode.
+func probeNodeStates(ctx context.Context, nodes []string) map[string]nodeState {
+	states := make(map[string]nodeState, len(nodes))
+
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, discoverConcurrency)
+	)
+	for _, node := range nodes {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(node string) {
+			defer func() { <-sem; wg.Done() }()
+
+			state := probeNodeState(ctx, node)
+			mu.Lock()
+			states[node] = state
+			mu.Unlock()
+		}(node)
+	}
+	wg.Wait()
+
+	return states
+}
+
+// probeNodeState reads the stage of the machine through the insecure maintenance service,
+// which refuses the request once the node is configured.
+func probeNodeState(ctx context.Context, node string) nodeState {
+	if _, err := probeFingerprint(ctx, node); err != nil {
+		return nodeUnreachable
+	}
+
+	dialOptions, err := proxyDialOptions()
+	if err != nil {
+		return nodeUnreachable
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, fingerprintProbeTimeout)
+	defer cancel()
+
+	c, err := client.New(ctx,
+		client.WithTLSConfig(&tls.Config{InsecureSkipVerify: true}),
+		client.WithEndpoints(node),
+		client.WithGRPCDialOptions(dialOptions...),
+	)
+	if err != nil {
+		return nodeUnreachable
+	}
+	defer c.Close() //nolint:errcheck
+
+	status, err := safe.StateGetByID[*runtime.MachineStatus](ctx, c.COSI, runtime.MachineStatusID)
+	if err != nil || status.TypedSpec().Stage != runtime.MachineStageMaintenance {
+		return nodeConfigured
+	}
+
+	return nodeMaintenance
+}
+
+// discoverMaintenanceNodes scans the addresses and networks for nodes in maintenance mode.
+func discoverMaintenanceNodes(ctx context.Context, targets []string) ([]string, error) {
+	addresses, err := expandTargets(targets, discoverMaxAddresses)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(os.Stderr, "Scanning %d address(es) for nodes in maintenance mode...\n", len(addresses))
+
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		sem       = make(chan struct{}, discoverConcurrency)
+		listening []string
+	)
+	for _, address := range addresses {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(address string) {
+			defer func() { <-sem; wg.Done() }()
+
+			conn, err := (&net.Dialer{Timeout: discoverDialTimeout}).DialContext(ctx, "tcp", net.JoinHostPort(address, strconv.Itoa(constants.ApidPort)))
+			if err != nil {
+				return
+			}
+			conn.Close() //nolint:errcheck
+
+			mu.Lock()
+			listening = append(listening, address)
+			mu.Unlock()
+		}(address)
+	}
+	wg.Wait()
+
+	var discovered []string
+	for node, state := range probeNodeStates(ctx, listening) {
+		if state == nodeMaintenance {
+			discovered = append(discovered, node)
+		}
+	}
+	sort.Slice(discovered, func(i, j int) bool {
+		return netip.MustParseAddr(discovered[i]).Less(netip.MustParseAddr(discovered[j]))
+	})
+
+	fmt.Fprintf(os.Stderr, "Found %d node(s) in maintenance mode: %s\n", len(discovered), discovered)
+
+	return discovered, nil
+}
+
+// expandTargets returns the addresses of the networks in CIDR notation and the plain addresses,
+// the network and broadcast addresses of IPv4 networks are left out.
+func expandTargets(targets []string, limit int) ([]string, error) {
+	var addresses []string
+	for _, target := range targets {
+		if !strings.Contains(target, "/") {
+			addr, err := netip.ParseAddr(target)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q: %w", target, err)
+			}
+			addresses = append(addresses, addr.String())
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(target)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q: %w", target, err)
+		}
+		prefix = prefix.Masked()
+		if prefix.Addr().BitLen()-prefix.Bits() > 16 {
+			return nil, fmt.Errorf("network %s is too large to scan, at most %d addresses are scanned", prefix, limit)
+		}
+
+		for addr := prefix.Addr(); prefix.Contains(addr); addr = addr.Next() {
+			if addr.Is4() && prefix.Bits() < 31 && (addr == prefix.Addr() || !prefix.Contains(addr.Next())) {
+				continue
+			}
+			addresses = append(addresses, addr.String())
+		}
+	}
+
+	if len(addresses) > limit {
+		return nil, fmt.Errorf("%d addresses to scan, at most %d are scanned", len(addresses), limit)
+	}
+	return addresses, nil
+}
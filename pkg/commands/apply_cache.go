@@ -0,0 +1,109 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	machineapi "github.com/siderolabs/talos/pkg/machinery/api/machine"
+)
+
+// appliedCacheFile is the path of the cache relative to the project root or the workspace.
+const appliedCacheFile = ".talm/applied.json"
+
+// appliedConfig records the config last applied to a node.
+//
+// Hash is the hash of the rendered config, ConfigHash is the hash of the config
+// encoded without comments, which is compared to the config read back from the node.
+type appliedConfig struct {
+	Hash       string    `json:"hash"`
+	ConfigHash string    `json:"configHash,omitempty"`
+	File       string    `json:"file"`
+	Applied    time.Time `json:"applied"`
+}
+
+// appliedCache maps node addresses to the last applied configs.
+type appliedCache map[string]appliedConfig
+
+func configHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// appliedConfigHash returns the hash of the normalized config applied in the mode, compared by
+// checkConflicts to the config running on the nodes. Staged configs only run after a reboot, the
+// nodes are not checked until a config is applied in another mode.
+func appliedConfigHash(mode machineapi.ApplyConfigurationRequest_Mode, normalized []byte) string {
+	if mode == machineapi.ApplyConfigurationRequest_STAGED {
+		return ""
+	}
+	return configHash(normalized)
+}
+
+func loadAppliedCache() (appliedCache, error) {
+	cache := appliedCache{}
+
+	data, err := os.ReadFile(filepath.Join(stateDir(), appliedCacheFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading applied config cache: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return nil, fmt.Errorf("error parsing applied config cache: %w", err)
+	}
+
+	return cache, nil
+}
+
+func (c appliedCache) save() error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	file := filepath.Join(stateDir(), appliedCacheFile)
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(file, data, 0o644)
+}
+
+// unchanged returns true if every node has the config with the hash applied.
+func (c appliedCache) unchanged(nodes []string, hash string) bool {
+	if len(nodes) == 0 {
+		return false
+	}
+
+	for _, node := range nodes {
+		if c[node].Hash != hash {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (c appliedCache) record(nodes []string, file, hash, configHash string) {
+	for _, node := range nodes {
+		c[node] = appliedConfig{
+			Hash:       hash,
+			ConfigHash: configHash,
+			File:       file,
+			Applied:    time.Now().UTC(),
+		}
+	}
+}
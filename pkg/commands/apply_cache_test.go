@@ -0,0 +1,110 @@
+package commands
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	machineapi "github.com/siderolabs/talos/pkg/machinery/api/machine"
+)
+
+func TestAppliedCache(t *testing.T) {
+	rootDir, workspace := Config.RootDir, Config.Workspace
+	defer func() { Config.RootDir, Config.Workspace = rootDir, workspace }()
+	Config.RootDir, Config.Workspace = t.TempDir(), ""
+
+	// A project without applied configs has an empty cache
+	cache, err := loadAppliedCache()
+	if err != nil || len(cache) != 0 {
+		t.Fatalf("expected an empty cache, got %v, %v", cache, err)
+	}
+
+	cache.record([]string{"10.0.0.1", "10.0.0.2"}, "nodes/cp.yaml", "cp", "cp-config")
+	cache.record([]string{"10.0.0.3"}, "nodes/worker.yaml", "worker", "")
+	if err := cache.save(); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadAppliedCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for node, record := range cache {
+		got := loaded[node]
+		if got.Hash != record.Hash || got.ConfigHash != record.ConfigHash || got.File != record.File || !got.Applied.Equal(record.Applied) {
+			t.Errorf("%s: expected %+v, got %+v", node, record, got)
+		}
+	}
+
+	// Every node of the file must have the config applied for the file to be skipped by --changed-only
+	for _, tt := range []struct {
+		nodes     []string
+		hash      string
+		unchanged bool
+	}{
+		{[]string{"10.0.0.1", "10.0.0.2"}, "cp", true},
+		{[]string{"10.0.0.2"}, "cp", true},
+		{[]string{"10.0.0.1", "10.0.0.2"}, "changed", false},
+		// A node added to the file has no config applied yet
+		{[]string{"10.0.0.1", "10.0.0.2", "10.0.0.4"}, "cp", false},
+		// The config of another file was applied to the node
+		{[]string{"10.0.0.1", "10.0.0.3"}, "cp", false},
+		{[]string{"10.0.0.3"}, "worker", true},
+		{nil, "cp", false},
+	} {
+		if unchanged := loaded.unchanged(tt.nodes, tt.hash); unchanged != tt.unchanged {
+			t.Errorf("nodes %v, hash %s: expected unchanged %t, got %t", tt.nodes, tt.hash, tt.unchanged, unchanged)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(Config.RootDir, appliedCacheFile), []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadAppliedCache(); err == nil {
+		t.Error("expected an error loading a corrupted cache")
+	}
+}
+
+func TestAppliedCacheWorkspace(t *testing.T) {
+	rootDir, workspace := Config.RootDir, Config.Workspace
+	defer func() { Config.RootDir, Config.Workspace = rootDir, workspace }()
+	Config.RootDir, Config.Workspace = t.TempDir(), "prod"
+
+	cache := appliedCache{}
+	cache.record([]string{"10.0.0.1"}, "nodes/cp.yaml", "cp", "")
+	if err := cache.save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(workspaceDir(), appliedCacheFile)); err != nil {
+		t.Errorf("expected the cache in the workspace: %v", err)
+	}
+
+	// The applied configs of a workspace are not seen by the others
+	Config.Workspace = "staging"
+	if cache, err := loadAppliedCache(); err != nil || len(cache) != 0 {
+		t.Errorf("expected an empty cache in another workspace, got %v, %v", cache, err)
+	}
+}
+
+func TestAppliedConfigHashStaged(t *testing.T) {
+	nodes := GlobalArgs.Nodes
+	defer func() { GlobalArgs.Nodes = nodes }()
+	GlobalArgs.Nodes = []string{"10.0.0.1"}
+
+	normalized := []byte("machine:\n  type: controlplane\n")
+	cache := appliedCache{}
+	cache.record(GlobalArgs.Nodes, "nodes/node1.yaml", "rendered", appliedConfigHash(machineapi.ApplyConfigurationRequest_AUTO, normalized))
+	if cache["10.0.0.1"].ConfigHash != configHash(normalized) {
+		t.Fatalf("expected the config hash to be recorded in auto mode, got %q", cache["10.0.0.1"].ConfigHash)
+	}
+
+	// The node keeps running the config applied before until it reboots, applying the node file
+	// again doesn't compare it to the staged config
+	cache.record(GlobalArgs.Nodes, "nodes/node1.yaml", "staged", appliedConfigHash(machineapi.ApplyConfigurationRequest_STAGED, normalized))
+	if record := cache["10.0.0.1"]; record.ConfigHash != "" || record.Hash != "staged" {
+		t.Fatalf("expected the staged config to be recorded without config hash, got %+v", record)
+	}
+	if err := checkConflicts(context.Background(), nil, cache, "nodes/node1.yaml", normalized); err != nil {
+		t.Errorf("expected no conflict after a staged apply, got %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aenix-io/talm/pkg/engine"
+	"github.com/aenix-io/talm/pkg/sections"
+	"github.com/cosi-project/runtime/pkg/safe"
+	"github.com/pmezard/go-difflib/difflib"
+
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	"github.com/siderolabs/talos/pkg/machinery/config/configloader"
+	"github.com/siderolabs/talos/pkg/machinery/config/encoder"
+	configres "github.com/siderolabs/talos/pkg/machinery/resources/config"
+)
+
+// normalizedConfig encodes the config without comments, so configs equal in content are equal in bytes.
+func normalizedConfig(data []byte) ([]byte, error) {
+	provider, err := configloader.NewFromBytes(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return provider.EncodeBytes(encoder.WithComments(encoder.CommentsDisabled))
+}
+
+// comparableConfig returns the normalized config as compared to the configs read back from the nodes.
+func comparableConfig(data []byte) ([]byte, error) {
+	normalized, err := normalizedConfig(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return withoutIgnoredPaths(normalized)
+}
+
+// renderComparableConfig renders the node file as apply does with the defaults of Chart.yaml,
+// normalized to be compared to the configs read back from the nodes.
+func renderComparableConfig(ctx context.Context, c *client.Client, configFile string) ([]byte, error) {
+	configBundle, err := engine.FullConfigProcess(ctx, engine.Options{
+		TalosVersion:      engine.ResolveTalosVersion(client.WithNodes(ctx, GlobalArgs.Nodes...), c, Config.TemplateOptions.TalosVersion),
+		WithSecrets:       Config.TemplateOptions.WithSecrets,
+		KubernetesVersion: Config.TemplateOptions.KubernetesVersion,
+	}, []string{"@" + configFile})
+	if err != nil {
+		return nil, fmt.Errorf("full config processing error: %s", err)
+	}
+
+	result, err := engine.SerializeConfiguration(configBundle, configBundle.ControlPlaneCfg.Machine().Type())
+	if err != nil {
+		return nil, fmt.Errorf("error serializing configuration: %s", err)
+	}
+	return comparableConfig(result)
+}
+
+// withoutIgnoredPaths removes diffOptions.ignorePaths from the normalized config, so the fields
+// changed on the nodes, e.g. by Talos itself, don't make the configs differ.
+func withoutIgnoredPaths(normalized []byte) ([]byte, error) {
+	if len(Config.DiffOptions.IgnorePaths) == 0 {
+		return normalized, nil
+	}
+
+	paths, err := sections.Parse(Config.DiffOptions.IgnorePaths)
+	if err != nil {
+		return nil, fmt.Errorf("diffOptions.ignorePaths: %w", err)
+	}
+
+	return sections.Remove(normalized, paths)
+}
+
+// currentConfig reads the config running on the node, normalized as comparableConfig.
+func currentConfig(ctx context.Context, c *client.Client, node string) ([]byte, error) {
+	machineConfig, err := safe.StateGetByID[*configres.MachineConfig](client.WithNode(ctx, node), c.COSI, configres.V1Alpha1ID)
+	if err != nil {
+		return nil, fmt.Errorf("error reading current config of node %s: %w", node, err)
+	}
+
+	current, err := machineConfig.Provider().EncodeBytes(encoder.WithComments(encoder.CommentsDisabled))
+	if err != nil {
+		return nil, fmt.Errorf("error encoding current config of node %s: %w", node, err)
+	}
+	return withoutIgnoredPaths(current)
+}
+
+// checkConflicts ensures the current config of every node is the one last applied by talm.
+//
+// Nodes without a record of the config hash are not checked, diffOptions.ignorePaths are not compared.
+// If the config of a node was changed outside talm, the difference to the normalized config about to be applied is printed and an error is returned.
+func checkConflicts(ctx context.Context, c *client.Client, cache appliedCache, configFile string, rendered []byte) error {
+	var conflicts []string
+	for _, node := range GlobalArgs.Nodes {
+		record, ok := cache[node]
+		if !ok || record.ConfigHash == "" {
+			continue
+		}
+
+		current, err := currentConfig(ctx, c, node)
+		if err != nil {
+			return err
+		}
+
+		if configHash(current) == record.ConfigHash {
+			continue
+		}
+
+		conflicts = append(conflicts, node)
+
+		diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        difflib.SplitLines(string(current)),
+			B:        difflib.SplitLines(string(rendered)),
+			FromFile: node + " (current)",
+			ToFile:   configFile + " (rendered)",
+			Context:  3,
+		})
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(os.Stderr, "Config of node %s was changed outside talm since it was applied from %s at %s:\n%s\n",
+			node, record.File, record.Applied.Format("2006-01-02 15:04:05"), diff)
+	}
+
+	if len(conflicts) > 0 && !applyCmdFlags.forceConflicts {
+		return fmt.Errorf("config of nodes %s was changed outside talm, update %s to keep the changes or use `--force-conflicts` to overwrite them", strings.Join(conflicts, ", "), configFile)
+	}
+
+	return nil
+}
@@ -0,0 +1,125 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/aenix-io/talm/pkg/modeline"
+	"github.com/cosi-project/runtime/pkg/safe"
+
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	"github.com/siderolabs/talos/pkg/machinery/config/machine"
+	configres "github.com/siderolabs/talos/pkg/machinery/resources/config"
+)
+
+// applyTarget is a node file with the nodes to apply it to. Node files with documents marked for
+// a machine type are split into a target per machine type of their nodes.
+type applyTarget struct {
+	file        string
+	machineType string
+	nodes       []string
+}
+
+// applyTargets resolves the nodes of the node files. The nodes of node files with documents marked
+// for a machine type are grouped by the machine type they run as, the documents marked for another
+// machine type are not applied to them.
+func applyTargets(files []string, nodesFromArgs, endpointsFromArgs bool) ([]applyTarget, error) {
+	var targets []applyTarget
+	for _, configFile := range files {
+		if err := processModelineAndUpdateGlobals(configFile, nodesFromArgs, endpointsFromArgs, true); err != nil {
+			return nil, err
+		}
+
+		fileTargets, err := machineTypeTargets(configFile)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, fileTargets...)
+
+		if !nodesFromArgs {
+			GlobalArgs.Nodes = []string{}
+		}
+		if !endpointsFromArgs {
+			GlobalArgs.Endpoints = []string{}
+		}
+	}
+	return targets, nil
+}
+
+// machineTypeTargets returns the targets of the node file for its nodes in GlobalArgs.
+func machineTypeTargets(configFile string) ([]applyTarget, error) {
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+	marked := modeline.MachineTypes(data)
+	if len(marked) == 0 {
+		return []applyTarget{{file: configFile, nodes: GlobalArgs.Nodes}}, nil
+	}
+	for _, machineType := range marked {
+		if _, err := machine.ParseType(machineType); err != nil {
+			return nil, fmt.Errorf("%s: invalid machine type marker %q: %w", configFile, machineType, err)
+		}
+	}
+	if applyCmdFlags.insecure {
+		return nil, fmt.Errorf("%s has documents per machine type, nodes in maintenance mode have no machine type to route them by", configFile)
+	}
+
+	// The nodes are reached through the endpoints of the node file
+	machineTypes := map[string]machine.Type{}
+	err = WithClientNoNodes(func(ctx context.Context, c *client.Client) error {
+		for _, node := range GlobalArgs.Nodes {
+			res, err := safe.StateGetByID[*configres.MachineType](client.WithNode(ctx, node), c.COSI, configres.MachineTypeID)
+			if err != nil {
+				return fmt.Errorf("failed to discover the machine type of node %s: %w", node, err)
+			}
+			machineTypes[node] = res.MachineType()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []applyTarget
+	for _, node := range GlobalArgs.Nodes {
+		machineType := machineTypes[node]
+		switch machineType {
+		case machine.TypeUnknown:
+			return nil, fmt.Errorf("node %s has no machine type to route the documents of %s by", node, configFile)
+		case machine.TypeInit:
+			machineType = machine.TypeControlPlane
+		}
+
+		i := 0
+		for i < len(targets) && targets[i].machineType != machineType.String() {
+			i++
+		}
+		if i == len(targets) {
+			targets = append(targets, applyTarget{file: configFile, machineType: machineType.String()})
+		}
+		targets[i].nodes = append(targets[i].nodes, node)
+	}
+
+	for _, target := range targets {
+		fmt.Printf("- talm: file=%s, machine-type=%s, nodes=%s\n", configFile, target.machineType, target.nodes)
+	}
+	return targets, nil
+}
+
+// applyPatch returns the node file as a patch for the nodes of the target.
+func (t applyTarget) applyPatch() (string, error) {
+	if t.machineType == "" {
+		return "@" + t.file, nil
+	}
+	data, err := os.ReadFile(t.file)
+	if err != nil {
+		return "", err
+	}
+	return string(modeline.FilterMachineType(data, t.machineType)), nil
+}
@@ -0,0 +1,117 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aenix-io/talm/pkg/sections"
+	"github.com/cosi-project/runtime/pkg/safe"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/siderolabs/talos/cmd/talosctl/pkg/talos/helpers"
+	machineapi "github.com/siderolabs/talos/pkg/machinery/api/machine"
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	"github.com/siderolabs/talos/pkg/machinery/config/encoder"
+	configres "github.com/siderolabs/talos/pkg/machinery/resources/config"
+)
+
+// applySections applies the sections of the rendered config on top of the config running on
+// every node, the rest of the config of the nodes is kept. A dry-run asks each node whether it
+// applies the change without a reboot, a change requiring one is only applied with --mode reboot
+// or staged.
+func applySections(ctx context.Context, c *client.Client, configFile string, rendered []byte, cache appliedCache) error {
+	paths, err := sections.Parse(applyCmdFlags.sections)
+	if err != nil {
+		return err
+	}
+
+	patch, removed, err := sections.Extract(rendered, paths)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("- talm: file=%s, nodes=%s, sections=%s\n", configFile, GlobalArgs.Nodes, strings.Join(applyCmdFlags.sections, ","))
+	os.Stdout.Write(patch) //nolint:errcheck
+	for _, section := range removed {
+		fmt.Printf("# %s: not set in %s, removed from the nodes\n", section, configFile)
+	}
+
+	for _, node := range GlobalArgs.Nodes {
+		nodeCtx := client.WithNode(ctx, node)
+
+		machineConfig, err := safe.StateGetByID[*configres.MachineConfig](nodeCtx, c.COSI, configres.V1Alpha1ID)
+		if err != nil {
+			return fmt.Errorf("error reading current config of node %s: %w", node, err)
+		}
+		current, err := machineConfig.Provider().EncodeBytes(encoder.WithComments(encoder.CommentsDisabled))
+		if err != nil {
+			return fmt.Errorf("error encoding current config of node %s: %w", node, err)
+		}
+		merged, err := sections.Replace(current, rendered, paths)
+		if err != nil {
+			return fmt.Errorf("node %s: %w", node, err)
+		}
+
+		resp, err := c.ApplyConfiguration(nodeCtx, &machineapi.ApplyConfigurationRequest{
+			Data:   merged,
+			Mode:   machineapi.ApplyConfigurationRequest_AUTO,
+			DryRun: true,
+		})
+		if err != nil {
+			return fmt.Errorf("error checking the sections on node %s: %w", node, err)
+		}
+		reboot := false
+		for _, message := range resp.GetMessages() {
+			reboot = reboot || message.GetMode() == machineapi.ApplyConfigurationRequest_REBOOT
+		}
+		if reboot {
+			fmt.Printf("- talm: node=%s, the change requires a reboot\n", node)
+		} else {
+			fmt.Printf("- talm: node=%s, the change is applied without a reboot\n", node)
+		}
+
+		if applyCmdFlags.dryRun {
+			helpers.PrintApplyResults(resp)
+			continue
+		}
+
+		mode := applyCmdFlags.Mode.Mode
+		switch {
+		case !reboot && mode == machineapi.ApplyConfigurationRequest_AUTO:
+			// Nothing the dry-run missed may reboot the node
+			mode = machineapi.ApplyConfigurationRequest_NO_REBOOT
+		case reboot && mode != machineapi.ApplyConfigurationRequest_REBOOT && mode != machineapi.ApplyConfigurationRequest_STAGED:
+			return fmt.Errorf("the sections of %s require a reboot of node %s, apply them with `--mode reboot` or `--mode staged`", configFile, node)
+		}
+
+		resp, err = c.ApplyConfiguration(nodeCtx, &machineapi.ApplyConfigurationRequest{
+			Data:           merged,
+			Mode:           mode,
+			TryModeTimeout: durationpb.New(applyCmdFlags.configTryTimeout),
+		})
+		if err != nil {
+			return fmt.Errorf("error applying the sections to node %s: %w", node, err)
+		}
+		helpers.PrintApplyResults(resp)
+
+		// Try mode is rolled back automatically, so it is not recorded as applied
+		if mode == machineapi.ApplyConfigurationRequest_TRY {
+			continue
+		}
+		normalized, err := comparableConfig(merged)
+		if err != nil {
+			return fmt.Errorf("error encoding configuration: %s", err)
+		}
+		cache.record([]string{node}, configFile, configHash(merged), appliedConfigHash(mode, normalized))
+		if err := cache.save(); err != nil {
+			return fmt.Errorf("error saving applied config cache: %w", err)
+		}
+	}
+
+	return nil
+}
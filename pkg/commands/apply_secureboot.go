@@ -0,0 +1,73 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/aenix-io/talm/pkg/schematic"
+	"github.com/cosi-project/runtime/pkg/safe"
+
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	"github.com/siderolabs/talos/pkg/machinery/resources/runtime"
+)
+
+// bootMode describes the SecureBoot state for the messages.
+func bootMode(secureBoot bool) string {
+	if secureBoot {
+		return "with SecureBoot"
+	}
+	return "without SecureBoot"
+}
+
+// checkSecureBoot ensures the nodes booted in the mode of the installer image of the config: the UKI
+// of a SecureBoot image doesn't boot on a node set up without SecureBoot, and a node enforcing
+// SecureBoot doesn't boot the kernel of a regular image. The UKI signing key of SecureBoot nodes is
+// checked against applyOptions.ukiSigningKeyFingerprints if set.
+//
+// Nodes whose security state can't be read, e.g. running an older Talos version, are skipped with a warning.
+// The maintenance service answers for the node it is connected to, so the first node is checked.
+func checkSecureBoot(ctx context.Context, c *client.Client, configFile, image string) error {
+	expected := schematic.SecureBootImage(image)
+
+	nodes := GlobalArgs.Nodes
+	if applyCmdFlags.insecure && len(nodes) > 1 {
+		nodes = nodes[:1]
+	}
+
+	var mismatched []string
+	for _, node := range nodes {
+		nodeCtx := ctx
+		if !applyCmdFlags.insecure {
+			nodeCtx = client.WithNode(ctx, node)
+		}
+
+		state, err := safe.StateGetByID[*runtime.SecurityState](nodeCtx, c.COSI, runtime.SecurityStateID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to check the boot mode of %s: %s\n", node, err)
+			continue
+		}
+		spec := state.TypedSpec()
+
+		if spec.SecureBoot != expected {
+			mismatched = append(mismatched, fmt.Sprintf("%s booted %s", node, bootMode(spec.SecureBoot)))
+			continue
+		}
+		if spec.SecureBoot && len(Config.ApplyOptions.UKISigningKeyFingerprints) > 0 &&
+			!slices.Contains(Config.ApplyOptions.UKISigningKeyFingerprints, spec.UKISigningKeyFingerprint) {
+			mismatched = append(mismatched, fmt.Sprintf("%s booted a UKI signed by the unknown key %s", node, spec.UKISigningKeyFingerprint))
+		}
+	}
+
+	if len(mismatched) > 0 {
+		return fmt.Errorf("%s installs %s %s, but %s; set secureBoot in the values to the boot mode of the nodes or use `--skip-secureboot-check`",
+			configFile, image, bootMode(expected), strings.Join(mismatched, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package commands
+
+import (
+	"testing"
+	"time"
+
+	"github.com/siderolabs/talos/pkg/machinery/constants"
+)
+
+func TestApplyTimeout(t *testing.T) {
+	flags, config := applyCmdFlags, Config.ApplyOptions
+	defer func() {
+		applyCmdFlags, Config.ApplyOptions = flags, config
+		applyCmd.Flags().Lookup("timeout").Changed = false
+	}()
+
+	for _, tt := range []struct {
+		config   time.Duration
+		flag     string
+		expected time.Duration
+	}{
+		// Chart.yaml without applyOptions.timeout
+		{expected: constants.ConfigTryTimeout},
+		{config: 5 * time.Minute, expected: 5 * time.Minute},
+		{config: 5 * time.Minute, flag: "2m", expected: 2 * time.Minute},
+	} {
+		applyCmdFlags.configTryTimeout = constants.ConfigTryTimeout
+		applyCmd.Flags().Lookup("timeout").Changed = false
+		Config.ApplyOptions.TimeoutDuration = tt.config
+		if tt.flag != "" {
+			if err := applyCmd.Flags().Set("timeout", tt.flag); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := applyCmd.PreRunE(applyCmd, nil); err != nil {
+			t.Fatal(err)
+		}
+		if applyCmdFlags.configTryTimeout != tt.expected {
+			t.Errorf("applyOptions.timeout %s, --timeout %q: expected %s, got %s", tt.config, tt.flag, tt.expected, applyCmdFlags.configTryTimeout)
+		}
+	}
+}
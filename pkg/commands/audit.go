@@ -0,0 +1,138 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/aenix-io/talm/pkg/audit"
+	"github.com/aenix-io/talm/pkg/engine"
+	"github.com/spf13/cobra"
+
+	"github.com/siderolabs/talos/pkg/machinery/config/configloader"
+	"github.com/siderolabs/talos/pkg/machinery/constants"
+)
+
+var auditCmdFlags struct {
+	configFiles       []string // -f/--files
+	talosVersion      string
+	withSecrets       string
+	kubernetesVersion string
+	output            string
+	minScore          int
+}
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Check rendered configs against Talos and CIS hardening guidelines",
+	Long:  ``,
+	Args:  cobra.NoArgs,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if !cmd.Flags().Changed("talos-version") {
+			auditCmdFlags.talosVersion = Config.TemplateOptions.TalosVersion
+		}
+		if !cmd.Flags().Changed("with-secrets") {
+			auditCmdFlags.withSecrets = Config.TemplateOptions.WithSecrets
+		}
+		if !cmd.Flags().Changed("kubernetes-version") {
+			auditCmdFlags.kubernetesVersion = Config.TemplateOptions.KubernetesVersion
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		reports := []audit.Report{}
+		for _, configFile := range auditCmdFlags.configFiles {
+			opts := engine.Options{
+				TalosVersion:      auditCmdFlags.talosVersion,
+				WithSecrets:       auditCmdFlags.withSecrets,
+				KubernetesVersion: auditCmdFlags.kubernetesVersion,
+			}
+
+			configBundle, err := engine.FullConfigProcess(cmd.Context(), opts, []string{"@" + configFile})
+			if err != nil {
+				return fmt.Errorf("full config processing error: %s", err)
+			}
+
+			machineType := configBundle.ControlPlaneCfg.Machine().Type()
+			result, err := engine.SerializeConfiguration(configBundle, machineType)
+			if err != nil {
+				return fmt.Errorf("error serializing configuration: %s", err)
+			}
+
+			cfg, err := configloader.NewFromBytes(result)
+			if err != nil {
+				return err
+			}
+
+			report := audit.Run(cfg)
+			report.File = configFile
+			reports = append(reports, report)
+		}
+
+		switch auditCmdFlags.output {
+		case "json":
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(reports); err != nil {
+				return err
+			}
+		case "table":
+			if err := printAuditReports(reports); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported output format: %s", auditCmdFlags.output)
+		}
+
+		for _, report := range reports {
+			if report.Score < auditCmdFlags.minScore {
+				return fmt.Errorf("%s: score %d is below the minimum %d", report.File, report.Score, auditCmdFlags.minScore)
+			}
+		}
+
+		return nil
+	},
+}
+
+func printAuditReports(reports []audit.Report) error {
+	for _, report := range reports {
+		fmt.Printf("%s: score %d/100\n", report.File, report.Score)
+		if len(report.Findings) == 0 {
+			fmt.Printf("  no issues found\n\n")
+			continue
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "  ID\tSEVERITY\tTITLE\tPATH")
+		for _, finding := range report.Findings {
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", finding.ID, finding.Severity, finding.Title, finding.Path)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		fmt.Println("  Remediation:")
+		for _, finding := range report.Findings {
+			fmt.Printf("  - %s: %s\n", finding.ID, finding.Remediation)
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+func init() {
+	auditCmd.Flags().StringSliceVarP(&auditCmdFlags.configFiles, "file", "f", nil, "specify node files to audit (can specify multiple)")
+	auditCmd.Flags().StringVar(&auditCmdFlags.talosVersion, "talos-version", "", "the desired Talos version to generate config for (backwards compatibility, e.g. v0.8)")
+	auditCmd.Flags().StringVar(&auditCmdFlags.withSecrets, "with-secrets", "", "use a secrets file generated using 'gen secrets', - reads it from stdin")
+	auditCmd.Flags().StringVar(&auditCmdFlags.kubernetesVersion, "kubernetes-version", constants.DefaultKubernetesVersion, "desired kubernetes version to run")
+	auditCmd.Flags().StringVarP(&auditCmdFlags.output, "output", "o", "table", "output format (table, json)")
+	auditCmd.Flags().IntVar(&auditCmdFlags.minScore, "min-score", 0, "exit with error if any file scores lower")
+	cobra.CheckErr(auditCmd.MarkFlagRequired("file"))
+
+	addCommand(auditCmd)
+}
@@ -0,0 +1,421 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aenix-io/talm/pkg/backup"
+	"github.com/aenix-io/talm/pkg/engine"
+	"github.com/aenix-io/talm/pkg/modeline"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+
+	machineapi "github.com/siderolabs/talos/pkg/machinery/api/machine"
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	"github.com/siderolabs/talos/pkg/machinery/constants"
+)
+
+// BackupPassphraseEnvVar is the environment variable with the passphrase of backup archives.
+const BackupPassphraseEnvVar = "TALM_BACKUP_PASSPHRASE"
+
+// backupExt is the extension of backup archives, they are never included into other backups.
+const backupExt = ".talmbackup"
+
+var backupCmdFlags struct {
+	configFiles       []string
+	output            string
+	passphraseFile    string
+	noEtcd            bool
+	talosVersion      string
+	withSecrets       string
+	kubernetesVersion string
+}
+
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Back up the project and cluster state into an encrypted archive",
+	Long: `Create an encrypted archive with the etcd snapshot, secrets.yaml, talosconfig,
+the rendered configs of the node files and the project sources (charts, templates,
+values and node files), described by a manifest.
+
+The etcd snapshot is taken from the first control plane node of the node files,
+which default to nodes/*.yaml. The passphrase is read from --passphrase-file,
+the TALM_BACKUP_PASSPHRASE environment variable or asked interactively.
+Use 'talm restore' to extract the archive.`,
+	Args: cobra.NoArgs,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if !cmd.Flags().Changed("talos-version") {
+			backupCmdFlags.talosVersion = Config.TemplateOptions.TalosVersion
+		}
+		if !cmd.Flags().Changed("with-secrets") {
+			backupCmdFlags.withSecrets = Config.TemplateOptions.WithSecrets
+		}
+		if !cmd.Flags().Changed("kubernetes-version") {
+			backupCmdFlags.kubernetesVersion = Config.TemplateOptions.KubernetesVersion
+		}
+		if backupCmdFlags.output == "" {
+			backupCmdFlags.output = "backup-" + time.Now().Format("20060102-150405") + backupExt
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		passphrase, err := backupPassphrase(backupCmdFlags.passphraseFile, true)
+		if err != nil {
+			return err
+		}
+
+		files := backupCmdFlags.configFiles
+		if len(files) == 0 {
+			files, err = defaultNodeFiles()
+			if err != nil {
+				return err
+			}
+		}
+
+		partPath := backupCmdFlags.output + ".part"
+		defer os.Remove(partPath) //nolint:errcheck
+
+		out, err := os.OpenFile(partPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			return err
+		}
+		defer out.Close() //nolint:errcheck
+
+		encrypted, err := backup.Encrypt(out, passphrase)
+		if err != nil {
+			return err
+		}
+
+		archive := backup.NewWriter(encrypted, backup.Manifest{
+			CreatedAt:   time.Now().UTC(),
+			TalmVersion: Version,
+			Workspace:   Config.Workspace,
+		})
+
+		if err := addProjectFiles(archive); err != nil {
+			return err
+		}
+
+		if backupCmdFlags.withSecrets != "" {
+			if err := archive.AddFile("secrets.yaml", backupCmdFlags.withSecrets); err != nil {
+				return fmt.Errorf("failed to add secrets: %w", err)
+			}
+		}
+		if GlobalArgs.Talosconfig != "" && fileExists(GlobalArgs.Talosconfig) {
+			if err := archive.AddFile("talosconfig", GlobalArgs.Talosconfig); err != nil {
+				return fmt.Errorf("failed to add talosconfig: %w", err)
+			}
+		}
+
+		var etcdNodes, etcdEndpoints []string
+		for _, configFile := range files {
+			if err := checkWorkspaceFile(configFile); err != nil {
+				return err
+			}
+
+			opts := engine.Options{
+				TalosVersion:      backupCmdFlags.talosVersion,
+				WithSecrets:       backupCmdFlags.withSecrets,
+				KubernetesVersion: backupCmdFlags.kubernetesVersion,
+			}
+			configBundle, err := engine.FullConfigProcess(cmd.Context(), opts, []string{"@" + configFile})
+			if err != nil {
+				return fmt.Errorf("full config processing error for %s: %s", configFile, err)
+			}
+
+			machineType := configBundle.ControlPlaneCfg.Machine().Type()
+			result, err := engine.SerializeConfiguration(configBundle, machineType)
+			if err != nil {
+				return fmt.Errorf("error serializing configuration: %s", err)
+			}
+
+			if err := archive.Add(filepath.ToSlash(filepath.Join("configs", relToRoot(configFile))), 0o600, bytes.NewReader(result), int64(len(result))); err != nil {
+				return err
+			}
+
+			if etcdNodes == nil && machineType.IsControlPlane() {
+				modelineConfig, err := modeline.ReadAndParseModeline(configFile)
+				if err != nil {
+					return fmt.Errorf("modeline parsing failed for %s: %w", configFile, err)
+				}
+				etcdNodes, etcdEndpoints = modelineConfig.Nodes, modelineConfig.Endpoints
+			}
+		}
+
+		if !backupCmdFlags.noEtcd {
+			if err := addEtcdSnapshot(archive, etcdNodes, etcdEndpoints); err != nil {
+				return err
+			}
+		}
+
+		if err := archive.Close(); err != nil {
+			return err
+		}
+		if err := encrypted.Close(); err != nil {
+			return err
+		}
+		if err := out.Close(); err != nil {
+			return err
+		}
+		if err := os.Rename(partPath, backupCmdFlags.output); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(os.Stderr, "Created %s\n", backupCmdFlags.output)
+		return nil
+	},
+}
+
+// addProjectFiles adds the project sources, skipping git metadata, backups and other workspaces.
+func addProjectFiles(archive *backup.Writer) error {
+	root := Config.RootDir
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			if Config.Workspace != "" && strings.HasPrefix(rel, workspacesDir+"/") && rel != workspacesDir+"/"+Config.Workspace {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if strings.HasSuffix(path, backupExt) || strings.HasSuffix(path, backupExt+".part") {
+			return nil
+		}
+
+		// Symlinks to files are stored as regular files, symlinks to directories are skipped
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			fmt.Fprintf(os.Stderr, "Warning: skipping %s: not a regular file\n", path)
+			return nil
+		}
+
+		return archive.AddFile("project/"+rel, path)
+	})
+}
+
+func addEtcdSnapshot(archive *backup.Writer, nodes, endpoints []string) error {
+	if len(GlobalArgs.Nodes) > 0 {
+		nodes = GlobalArgs.Nodes[:1]
+	}
+	if len(nodes) == 0 {
+		return errors.New("no control plane node found for etcd snapshot: please use `--nodes` flag or `--no-etcd` to skip it")
+	}
+	if len(GlobalArgs.Endpoints) == 0 {
+		GlobalArgs.Endpoints = endpoints
+	}
+
+	snapshot, err := os.CreateTemp("", "talm-etcd-*.snapshot")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(snapshot.Name()) //nolint:errcheck
+	defer snapshot.Close()           //nolint:errcheck
+
+	err = WithClientNoNodes(func(ctx context.Context, c *client.Client) error {
+		r, err := c.EtcdSnapshot(client.WithNode(ctx, nodes[0]), &machineapi.EtcdSnapshotRequest{})
+		if err != nil {
+			return fmt.Errorf("error taking etcd snapshot: %w", err)
+		}
+		defer r.Close() //nolint:errcheck
+
+		size, err := io.Copy(snapshot, r)
+		if err != nil {
+			return fmt.Errorf("error reading etcd snapshot: %w", err)
+		}
+
+		// this check is from https://github.com/etcd-io/etcd/blob/client/v3.5.0-alpha.0/client/v3/snapshot/v3_snapshot.go#L46
+		if (size % 512) != sha256.Size {
+			return fmt.Errorf("etcd snapshot sha256 checksum not found (size %d)", size)
+		}
+
+		fmt.Fprintf(os.Stderr, "Took etcd snapshot from %s (%d bytes)\n", nodes[0], size)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return archive.AddFile("etcd.snapshot", snapshot.Name())
+}
+
+var restoreCmdFlags struct {
+	output         string
+	passphraseFile string
+	force          bool
+}
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore <archive>",
+	Short: "Restore the project from an archive created with 'talm backup'",
+	Long: `Decrypt the archive, verify it against the manifest and extract the project
+sources into the output directory. The etcd snapshot, secrets, talosconfig and
+rendered configs are extracted into the backup-<date> subdirectory.
+
+Existing files are not overwritten unless --force is set. To recover the cluster
+from the snapshot, apply the configs and bootstrap the first control plane node
+with 'talm bootstrap --recover-from <snapshot>'.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		passphrase, err := backupPassphrase(restoreCmdFlags.passphraseFile, false)
+		if err != nil {
+			return err
+		}
+
+		in, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer in.Close() //nolint:errcheck
+
+		decrypted, err := backup.Decrypt(in, passphrase)
+		if err != nil {
+			return err
+		}
+
+		if err := os.MkdirAll(restoreCmdFlags.output, 0o755); err != nil {
+			return err
+		}
+
+		// Extract into a staging directory next to the target, so nothing is written if verification fails
+		staging, err := os.MkdirTemp(restoreCmdFlags.output, ".talm-restore-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(staging) //nolint:errcheck
+
+		manifest, err := backup.Extract(decrypted, staging)
+		if err != nil {
+			return fmt.Errorf("failed to extract archive: %w", err)
+		}
+
+		backupDir := "backup-" + manifest.CreatedAt.Format("20060102-150405")
+		moves := map[string]string{}
+		for _, file := range manifest.Files {
+			target := filepath.Join(backupDir, filepath.FromSlash(file.Path))
+			if rel, ok := strings.CutPrefix(file.Path, "project/"); ok {
+				target = filepath.FromSlash(rel)
+			}
+			target = filepath.Join(restoreCmdFlags.output, target)
+
+			if !restoreCmdFlags.force && fileExists(target) {
+				return fmt.Errorf("file %s already exists, use --force to overwrite", target)
+			}
+			moves[filepath.Join(staging, filepath.FromSlash(file.Path))] = target
+		}
+
+		for from, to := range moves {
+			if err := os.MkdirAll(filepath.Dir(to), 0o755); err != nil {
+				return err
+			}
+			if err := os.Rename(from, to); err != nil {
+				return err
+			}
+		}
+
+		fmt.Fprintf(os.Stderr, "Restored %d files from backup created at %s by talm %s\n", len(manifest.Files), manifest.CreatedAt.Format(time.RFC3339), manifest.TalmVersion)
+		if snapshot := filepath.Join(restoreCmdFlags.output, backupDir, "etcd.snapshot"); fileExists(snapshot) {
+			fmt.Fprintf(os.Stderr, "Recover etcd with: talm bootstrap -f <control plane node file> --recover-from %s\n", snapshot)
+		}
+
+		return nil
+	},
+}
+
+// backupPassphrase reads the passphrase from the file, the environment or the terminal.
+func backupPassphrase(file string, confirm bool) ([]byte, error) {
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read passphrase: %w", err)
+		}
+		return bytes.TrimRight(data, "\r\n"), nil
+	}
+
+	if passphrase := os.Getenv(BackupPassphraseEnvVar); passphrase != "" {
+		return []byte(passphrase), nil
+	}
+
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil, fmt.Errorf("passphrase is not set: please use `--passphrase-file` flag or %s env variable", BackupPassphraseEnvVar)
+	}
+
+	return promptPassphrase("Passphrase: ", confirm)
+}
+
+// promptPassphrase reads the passphrase from the terminal, twice if confirm is set.
+func promptPassphrase(prompt string, confirm bool) ([]byte, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	passphrase, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+
+	if confirm {
+		fmt.Fprint(os.Stderr, "Confirm passphrase: ")
+		again, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(passphrase, again) {
+			return nil, errors.New("passphrases do not match")
+		}
+	}
+
+	return passphrase, nil
+}
+
+// relToRoot returns the path relative to the project root, or the base name for files outside of it.
+func relToRoot(path string) string {
+	absRoot, err1 := filepath.Abs(Config.RootDir)
+	absPath, err2 := filepath.Abs(path)
+	if err1 == nil && err2 == nil {
+		if rel, err := filepath.Rel(absRoot, absPath); err == nil && !strings.HasPrefix(rel, "..") {
+			return rel
+		}
+	}
+	return filepath.Base(path)
+}
+
+func init() {
+	backupCmd.Flags().StringSliceVarP(&backupCmdFlags.configFiles, "file", "f", nil, "specify node files to render configs for (defaults to nodes/*.yaml)")
+	backupCmd.Flags().StringVarP(&backupCmdFlags.output, "output", "o", "", "path of the archive (defaults to backup-<date>"+backupExt+")")
+	backupCmd.Flags().StringVar(&backupCmdFlags.passphraseFile, "passphrase-file", "", "read the encryption passphrase from the file")
+	backupCmd.Flags().BoolVar(&backupCmdFlags.noEtcd, "no-etcd", false, "do not include etcd snapshot")
+	backupCmd.Flags().StringVar(&backupCmdFlags.talosVersion, "talos-version", "", "the desired Talos version to generate config for (backwards compatibility, e.g. v0.8)")
+	backupCmd.Flags().StringVar(&backupCmdFlags.withSecrets, "with-secrets", "", "use a secrets file generated using 'gen secrets'")
+	backupCmd.Flags().StringVar(&backupCmdFlags.kubernetesVersion, "kubernetes-version", constants.DefaultKubernetesVersion, "desired kubernetes version to run")
+
+	restoreCmd.Flags().StringVarP(&restoreCmdFlags.output, "output", "o", ".", "directory to restore the project into")
+	restoreCmd.Flags().StringVar(&restoreCmdFlags.passphraseFile, "passphrase-file", "", "read the encryption passphrase from the file")
+	restoreCmd.Flags().BoolVar(&restoreCmdFlags.force, "force", false, "overwrite existing files")
+
+	addCommand(backupCmd)
+	addCommand(restoreCmd)
+}
@@ -0,0 +1,292 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/aenix-io/talm/pkg/modeline"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/spf13/cobra"
+
+	"github.com/siderolabs/talos/pkg/machinery/client"
+)
+
+// driftExitCode is the exit code of `talm ci diff` when rendered files differ from the committed ones.
+const driftExitCode = 2
+
+// ExitError is an error which requests a specific exit code of the process.
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// ciCmd represents the `ci` command.
+var ciCmd = &cobra.Command{
+	Use:   "ci",
+	Short: "Helpers for running talm in CI pipelines",
+	Long:  ``,
+}
+
+var ciMatrixCmdFlags struct {
+	configFiles []string
+}
+
+// matrixEntry is a single job of the render matrix.
+type matrixEntry struct {
+	File      string   `json:"file"`
+	Workspace string   `json:"workspace"`
+	Nodes     []string `json:"nodes"`
+	Endpoints []string `json:"endpoints"`
+	Templates []string `json:"templates"`
+}
+
+var ciMatrixCmd = &cobra.Command{
+	Use:   "render-matrix",
+	Short: "Print a JSON matrix of node files for CI jobs",
+	Long: `Print a JSON matrix with one entry per node file, so CI pipelines can fan out
+render and diff jobs per node.
+
+By default node files of the project and of all workspaces are included, or
+of the selected workspace only. The output can be used as a GitHub Actions
+matrix directly (fromJSON), and in GitLab via a generated child pipeline.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		files, err := matrixFiles()
+		if err != nil {
+			return err
+		}
+
+		entries := []matrixEntry{}
+		for _, file := range files {
+			modelineConfig, err := modeline.ReadAndParseModeline(file.path)
+			if err != nil {
+				return fmt.Errorf("modeline parsing failed for %s: %w", file.path, err)
+			}
+			entries = append(entries, matrixEntry{
+				File:      filepath.ToSlash(file.path),
+				Workspace: file.workspace,
+				Nodes:     modelineConfig.Nodes,
+				Endpoints: modelineConfig.Endpoints,
+				Templates: modelineConfig.Templates,
+			})
+		}
+
+		encoder := json.NewEncoder(os.Stdout)
+		return encoder.Encode(map[string][]matrixEntry{"include": entries})
+	},
+}
+
+type matrixFile struct {
+	path      string
+	workspace string
+}
+
+func matrixFiles() ([]matrixFile, error) {
+	var files []matrixFile
+
+	if len(ciMatrixCmdFlags.configFiles) > 0 {
+		for _, path := range ciMatrixCmdFlags.configFiles {
+			if err := checkWorkspaceFile(path); err != nil {
+				return nil, err
+			}
+			files = append(files, matrixFile{path: path, workspace: Config.Workspace})
+		}
+		return files, nil
+	}
+
+	workspaces := []string{Config.Workspace}
+	if Config.Workspace == "" {
+		dirs, err := filepath.Glob(filepath.Join(Config.RootDir, workspacesDir, "*"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(dirs)
+		for _, dir := range dirs {
+			if info, err := os.Stat(dir); err == nil && info.IsDir() {
+				workspaces = append(workspaces, filepath.Base(dir))
+			}
+		}
+	}
+
+	for _, workspace := range workspaces {
+		dir := Config.RootDir
+		if workspace != "" {
+			dir = filepath.Join(Config.RootDir, workspacesDir, workspace)
+		}
+		paths, err := nodeFiles(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			files = append(files, matrixFile{path: path, workspace: workspace})
+		}
+	}
+
+	return files, nil
+}
+
+var ciDiffCmdFlags struct {
+	configFiles []string
+	output      string
+}
+
+// diffArtifact is the machine-readable result of `talm ci diff` for a single file.
+type diffArtifact struct {
+	File  string `json:"file"`
+	Drift bool   `json:"drift"`
+	Diff  string `json:"diff,omitempty"`
+}
+
+var ciDiffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Re-render node files and report drift from the committed ones",
+	Long: `Re-render every node file from its modeline and compare the result with the file.
+
+The unified diff is printed to stdout and, with --output, written as a JSON
+artifact for merge request annotations. The command exits with code 2 when
+any file has drifted.`,
+	Args: cobra.NoArgs,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		// Flags not defined for ci diff are never changed, so the defaults are taken from Chart.yaml
+		return templateCmd.PreRunE(cmd, args)
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		artifacts := []diffArtifact{}
+		drifted := 0
+		for _, configFile := range ciDiffCmdFlags.configFiles {
+			artifact, err := diffFile(args, configFile)
+			if err != nil {
+				return err
+			}
+			if artifact.Drift {
+				drifted++
+				fmt.Print(artifact.Diff)
+			}
+			artifacts = append(artifacts, artifact)
+		}
+
+		if ciDiffCmdFlags.output != "" {
+			data, err := json.MarshalIndent(artifacts, "", "  ")
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(ciDiffCmdFlags.output, append(data, '\n'), 0o644); err != nil {
+				return err
+			}
+		}
+
+		if drifted > 0 {
+			return &ExitError{Code: driftExitCode, Err: fmt.Errorf("%d of %d file(s) differ from the rendered config", drifted, len(artifacts))}
+		}
+
+		return nil
+	},
+}
+
+func diffFile(args []string, configFile string) (diffArtifact, error) {
+	rendered, err := renderNodeFile(args, configFile)
+	if err != nil {
+		return diffArtifact{}, err
+	}
+
+	diff, err := renderedDiff(configFile, rendered)
+	if err != nil {
+		return diffArtifact{}, err
+	}
+
+	return diffArtifact{File: configFile, Drift: diff != "", Diff: diff}, nil
+}
+
+// renderNodeFile renders the node file from its modeline with the template flags.
+func renderNodeFile(args []string, configFile string) ([]byte, error) {
+	if err := checkWorkspaceFile(configFile); err != nil {
+		return nil, err
+	}
+
+	modelineConfig, err := modeline.ReadAndParseModeline(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("modeline parsing failed for %s: %w", configFile, err)
+	}
+	if len(modelineConfig.Templates) == 0 {
+		return nil, fmt.Errorf("modeline of %s does not contain templates information", configFile)
+	}
+
+	templateCmdFlags.templateFiles = modelineConfig.Templates
+	GlobalArgs.Nodes = modelineConfig.Nodes
+	GlobalArgs.Endpoints = modelineConfig.Endpoints
+	templateCmdFlags.nodeValues = modelineConfig.Values
+	if len(GlobalArgs.Nodes) < 1 {
+		return nil, errors.New("nodes are not set for the command: please use `--nodes` flag or configuration file to set the nodes to run the command against")
+	}
+
+	var rendered bytes.Buffer
+	render := func(ctx context.Context, c *client.Client) error {
+		return generateOutput(ctx, c, args, &rendered)
+	}
+
+	if templateCmdFlags.offline {
+		err = render(context.Background(), nil)
+	} else if templateCmdFlags.insecure {
+		err = WithClientMaintenance(nil, render)
+	} else {
+		err = WithClient(render)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return rendered.Bytes(), nil
+}
+
+// renderedDiff returns the unified diff of the node file to the rendered config, empty if they are the same.
+func renderedDiff(configFile string, rendered []byte) (string, error) {
+	current, err := os.ReadFile(configFile)
+	if err != nil {
+		return "", err
+	}
+
+	if bytes.Equal(bytes.TrimSpace(current), bytes.TrimSpace(rendered)) {
+		return "", nil
+	}
+
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(current)),
+		B:        difflib.SplitLines(string(rendered)),
+		FromFile: configFile,
+		ToFile:   configFile + " (rendered)",
+		Context:  3,
+	})
+}
+
+func init() {
+	ciMatrixCmd.Flags().StringSliceVarP(&ciMatrixCmdFlags.configFiles, "file", "f", nil, "specify node files to include (defaults to nodes/*.yaml of the project and all workspaces)")
+
+	ciDiffCmd.Flags().StringSliceVarP(&ciDiffCmdFlags.configFiles, "file", "f", nil, "specify node files to check (can specify multiple)")
+	ciDiffCmd.Flags().StringVarP(&ciDiffCmdFlags.output, "output", "o", "", "write the results as a JSON artifact to the file")
+	ciDiffCmd.Flags().BoolVarP(&templateCmdFlags.insecure, "insecure", "i", false, "template using the insecure (encrypted with no auth) maintenance service")
+	ciDiffCmd.Flags().BoolVar(&templateCmdFlags.offline, "offline", false, "disable gathering information and lookup functions")
+	ciDiffCmd.Flags().BoolVar(&templateCmdFlags.checkDeterminism, "check-determinism", false, "render twice with the functions depending on the time or on randomness failing, and fail if the renders differ")
+	ciDiffCmd.Flags().BoolVar(&templateCmdFlags.withoutSecrets, "without-secrets", false, "render without the secrets bundle, for runners which must not access secrets")
+	cobra.CheckErr(ciDiffCmd.MarkFlagRequired("file"))
+
+	ciCmd.AddCommand(ciMatrixCmd, ciDiffCmd)
+	addCommand(ciCmd)
+}
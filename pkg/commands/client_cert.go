@@ -0,0 +1,154 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"bytes"
+	stdx509 "crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	clientconfig "github.com/siderolabs/talos/pkg/machinery/client/config"
+	"github.com/siderolabs/talos/pkg/machinery/config/generate/secrets"
+	"github.com/siderolabs/talos/pkg/machinery/role"
+)
+
+// RegenerateClientCert requests a new talosconfig client certificate to be issued from the secrets bundle before connecting.
+var RegenerateClientCert bool
+
+// clientCertMinValidity is the minimum remaining validity of the client certificate, unless --timeout is longer.
+const clientCertMinValidity = time.Hour
+
+// clientCertChecked is set once the certificate was checked, commands connect once per node file.
+var clientCertChecked bool
+
+const regenerateClientCertHint = "use --regenerate-client-cert to issue a new one from the secrets bundle"
+
+// checkClientCertificate verifies the talosconfig client certificate stays valid for the whole operation,
+// and regenerates it from the secrets bundle when requested.
+func checkClientCertificate() error {
+	if clientCertChecked {
+		return nil
+	}
+	clientCertChecked = true
+
+	cfg, err := openTalosconfig(GlobalArgs.Talosconfig)
+	if err != nil {
+		// Reported by the client itself
+		return nil
+	}
+
+	contextName := GlobalArgs.CmdContext
+	if contextName == "" {
+		contextName = cfg.Context
+	}
+	configContext, ok := cfg.Contexts[contextName]
+	if !ok || configContext.Crt == "" {
+		return nil
+	}
+
+	if RegenerateClientCert {
+		return regenerateClientCertificate(cfg, configContext)
+	}
+
+	cert, err := decodeClientCertificate(configContext.Crt)
+	if err != nil {
+		return fmt.Errorf("invalid client certificate in talosconfig context %q: %w", contextName, err)
+	}
+
+	// The roles of an encrypted talosconfig are only known now
+	if roles, ok := certificateRoles(cert.Subject.Organization); ok && runningCommand != nil {
+		if err := checkCommandRole(runningCommand, contextName, roles); err != nil {
+			return err
+		}
+	}
+
+	minValidity := clientCertMinValidity
+	if RPCTimeout > minValidity {
+		minValidity = RPCTimeout
+	}
+
+	remaining := time.Until(cert.NotAfter)
+	switch {
+	case remaining <= 0:
+		return fmt.Errorf("client certificate in talosconfig context %q expired at %s, %s", contextName, cert.NotAfter.Format(time.RFC3339), regenerateClientCertHint)
+	case remaining < minValidity:
+		fmt.Fprintf(os.Stderr, "Warning: client certificate in talosconfig context %q expires in %s, %s\n", contextName, remaining.Round(time.Second), regenerateClientCertHint)
+	}
+
+	return nil
+}
+
+func regenerateClientCertificate(cfg *clientconfig.Config, configContext *clientconfig.Context) error {
+	if Config.TemplateOptions.WithSecrets == "" {
+		return errors.New("secrets bundle is not set: please set templateOptions.withSecrets in Chart.yaml")
+	}
+
+	bundle, err := secrets.LoadBundle(Config.TemplateOptions.WithSecrets)
+	if err != nil {
+		return fmt.Errorf("failed to load secrets bundle: %w", err)
+	}
+
+	ca, err := base64.StdEncoding.DecodeString(configContext.CA)
+	if err != nil {
+		return fmt.Errorf("invalid CA in talosconfig: %w", err)
+	}
+	if !bytes.Equal(bytes.TrimSpace(ca), bytes.TrimSpace(bundle.Certs.OS.Crt)) {
+		return errors.New("talosconfig CA does not match the secrets bundle, refusing to regenerate the client certificate")
+	}
+
+	// Keep the roles of the current certificate
+	roles := role.MakeSet(role.Admin)
+	if cert, err := decodeClientCertificate(configContext.Crt); err == nil {
+		if parsed, _ := role.Parse(cert.Subject.Organization); len(parsed.Strings()) > 0 {
+			roles = parsed
+		}
+	}
+
+	clientCert, err := bundle.GenerateTalosAPIClientCertificate(roles)
+	if err != nil {
+		return fmt.Errorf("failed to generate client certificate: %w", err)
+	}
+
+	configContext.Crt = base64.StdEncoding.EncodeToString(clientCert.Crt)
+	configContext.Key = base64.StdEncoding.EncodeToString(clientCert.Key)
+
+	if err := saveTalosconfig(cfg, GlobalArgs.Talosconfig); err != nil {
+		return fmt.Errorf("failed to save talosconfig: %w", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Regenerated client certificate in %s\n", GlobalArgs.Talosconfig)
+
+	return nil
+}
+
+func decodeClientCertificate(crt string) (*stdx509.Certificate, error) {
+	data, err := base64.StdEncoding.DecodeString(crt)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseCertificate(data)
+}
+
+// wrapClientCertError explains TLS errors caused by the client certificate rejected by the node.
+func wrapClientCertError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	message := err.Error()
+	for _, pattern := range []string{"certificate has expired", "expired certificate", "bad certificate", "certificate required", "unknown certificate authority"} {
+		if strings.Contains(message, pattern) {
+			return fmt.Errorf("%w\nthe node rejected the client certificate from talosconfig, %s", err, regenerateClientCertHint)
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,20 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// configCmd represents the `config` command.
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Manage rendered machine configurations",
+	Long:  ``,
+}
+
+func init() {
+	addCommand(configCmd)
+}
@@ -0,0 +1,231 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/aenix-io/talm/pkg/engine"
+	"github.com/aenix-io/talm/pkg/modeline"
+	"github.com/spf13/cobra"
+
+	"github.com/siderolabs/talos/pkg/machinery/constants"
+)
+
+var applyScriptCmdFlags struct {
+	configFiles       []string // -f/--files
+	output            string
+	talosVersion      string
+	withSecrets       string
+	kubernetesVersion string
+	withTalosconfig   bool
+}
+
+var applyScriptCmd = &cobra.Command{
+	Use:   "generate-apply-script",
+	Short: "Generate a standalone shell script to rebuild the cluster from scratch",
+	Long: `Generate a POSIX shell script embedding full machine configs for the specified
+nodes and talosctl commands to apply them and bootstrap the cluster.
+
+The script does not require talm or the project repository, only talosctl,
+which makes it suitable for disaster recovery. It contains cluster secrets,
+so store it securely.`,
+	Args: cobra.NoArgs,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if !cmd.Flags().Changed("talos-version") {
+			applyScriptCmdFlags.talosVersion = Config.TemplateOptions.TalosVersion
+		}
+		if !cmd.Flags().Changed("with-secrets") {
+			applyScriptCmdFlags.withSecrets = Config.TemplateOptions.WithSecrets
+		}
+		if !cmd.Flags().Changed("kubernetes-version") {
+			applyScriptCmdFlags.kubernetesVersion = Config.TemplateOptions.KubernetesVersion
+		}
+		if len(applyScriptCmdFlags.configFiles) == 0 {
+			return fmt.Errorf("at least one node file must be specified with --file")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		script, err := generateApplyScript(cmd.Context())
+		if err != nil {
+			return err
+		}
+
+		if applyScriptCmdFlags.output == "" || applyScriptCmdFlags.output == "-" {
+			_, err = os.Stdout.Write(script)
+			return err
+		}
+
+		if err := os.WriteFile(applyScriptCmdFlags.output, script, 0o700); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Created %s\n", applyScriptCmdFlags.output)
+		return nil
+	},
+}
+
+type applyScriptNode struct {
+	File   string
+	Name   string
+	Nodes  []string
+	Config string
+}
+
+type applyScriptData struct {
+	Generated   string
+	Talosconfig string
+	Nodes       []applyScriptNode
+	Bootstrap   string
+}
+
+// heredocDelimiter ends the configs embedded in the script.
+const heredocDelimiter = "TALM_EOF"
+
+// shellQuote quotes the value as a single word of a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+var applyScriptTemplate = texttemplate.Must(texttemplate.New("script").Funcs(texttemplate.FuncMap{"shellQuote": shellQuote}).Parse(`#!/bin/sh
+# Generated by talm on {{ .Generated }}
+#
+# This script rebuilds the cluster from scratch: it applies the embedded
+# machine configs to the nodes booted in maintenance mode and bootstraps etcd.
+# It contains cluster secrets, keep it in a safe place.
+#
+# Requirements: talosctl
+set -eu
+
+WORKDIR=$(mktemp -d)
+trap 'rm -rf "$WORKDIR"' EXIT
+{{- if .Talosconfig }}
+TALOSCONFIG="$WORKDIR/talosconfig"
+
+cat > "$TALOSCONFIG" <<'TALM_EOF'
+{{ .Talosconfig }}
+TALM_EOF
+{{- else }}
+TALOSCONFIG="${TALOSCONFIG:-$HOME/.talos/config}"
+{{- end }}
+export TALOSCONFIG
+{{ range $f := .Nodes }}
+# {{ $f.File | shellQuote }}
+cat > "$WORKDIR"/{{ $f.Name | shellQuote }} <<'TALM_EOF'
+{{ $f.Config }}
+TALM_EOF
+{{- range $f.Nodes }}
+echo Applying {{ $f.File | shellQuote }} to {{ . | shellQuote }}
+talosctl apply-config --insecure --nodes {{ . | shellQuote }} --file "$WORKDIR"/{{ $f.Name | shellQuote }}
+{{- end }}
+{{ end }}
+{{- with .Bootstrap }}
+echo Waiting for {{ . | shellQuote }} to become ready
+until talosctl --nodes {{ . | shellQuote }} --endpoints {{ . | shellQuote }} version >/dev/null 2>&1; do
+  sleep 5
+done
+
+echo Bootstrapping etcd on {{ . | shellQuote }}
+talosctl --nodes {{ . | shellQuote }} --endpoints {{ . | shellQuote }} bootstrap
+{{- end }}
+`))
+
+func generateApplyScript(ctx context.Context) ([]byte, error) {
+	data := applyScriptData{
+		Generated: time.Now().UTC().Format(time.RFC3339),
+	}
+
+	for i, configFile := range applyScriptCmdFlags.configFiles {
+		modelineConfig, err := modeline.ReadAndParseModeline(configFile)
+		if err != nil {
+			return nil, fmt.Errorf("modeline parsing failed for %s: %w", configFile, err)
+		}
+		if len(modelineConfig.Nodes) == 0 {
+			return nil, fmt.Errorf("modeline of %s does not contain nodes", configFile)
+		}
+		// The values are quoted, but a line break would end the comment naming the file
+		for _, value := range append([]string{configFile}, modelineConfig.Nodes...) {
+			if strings.ContainsAny(value, "\r\n") {
+				return nil, fmt.Errorf("%q of %s contains a line break", value, configFile)
+			}
+		}
+
+		opts := engine.Options{
+			TalosVersion:      applyScriptCmdFlags.talosVersion,
+			WithSecrets:       applyScriptCmdFlags.withSecrets,
+			KubernetesVersion: applyScriptCmdFlags.kubernetesVersion,
+		}
+
+		configBundle, err := engine.FullConfigProcess(ctx, opts, []string{"@" + configFile})
+		if err != nil {
+			return nil, fmt.Errorf("full config processing error: %s", err)
+		}
+
+		machineType := configBundle.ControlPlaneCfg.Machine().Type()
+		result, err := engine.SerializeConfiguration(configBundle, machineType)
+		if err != nil {
+			return nil, fmt.Errorf("error serializing configuration: %s", err)
+		}
+
+		if machineType.IsControlPlane() && data.Bootstrap == "" {
+			data.Bootstrap = modelineConfig.Nodes[0]
+		}
+
+		if err := checkHeredoc(configFile, result); err != nil {
+			return nil, err
+		}
+		data.Nodes = append(data.Nodes, applyScriptNode{
+			File:   configFile,
+			Name:   fmt.Sprintf("%02d-%s", i, filepath.Base(configFile)),
+			Nodes:  modelineConfig.Nodes,
+			Config: strings.TrimSuffix(string(result), "\n"),
+		})
+	}
+
+	if applyScriptCmdFlags.withTalosconfig {
+		talosconfig, _, err := readTalosconfig(GlobalArgs.Talosconfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read talosconfig: %w", err)
+		}
+		if err := checkHeredoc("talosconfig", talosconfig); err != nil {
+			return nil, err
+		}
+		data.Talosconfig = strings.TrimSuffix(string(talosconfig), "\n")
+	}
+
+	var buf bytes.Buffer
+	if err := applyScriptTemplate.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// checkHeredoc rejects a file with a line ending the here-document embedding it in the script.
+func checkHeredoc(name string, data []byte) error {
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSuffix(line, "\r") == heredocDelimiter {
+			return fmt.Errorf("%s contains a %s line, which would end it early in the script", name, heredocDelimiter)
+		}
+	}
+	return nil
+}
+
+func init() {
+	applyScriptCmd.Flags().StringSliceVarP(&applyScriptCmdFlags.configFiles, "file", "f", nil, "specify node files to include in the script (can specify multiple)")
+	applyScriptCmd.Flags().StringVarP(&applyScriptCmdFlags.output, "output", "o", "", "write the script to the file instead of stdout")
+	applyScriptCmd.Flags().StringVar(&applyScriptCmdFlags.talosVersion, "talos-version", "", "the desired Talos version to generate config for (backwards compatibility, e.g. v0.8)")
+	applyScriptCmd.Flags().StringVar(&applyScriptCmdFlags.withSecrets, "with-secrets", "", "use a secrets file generated using 'gen secrets', - reads it from stdin")
+	applyScriptCmd.Flags().StringVar(&applyScriptCmdFlags.kubernetesVersion, "kubernetes-version", constants.DefaultKubernetesVersion, "desired kubernetes version to run")
+	applyScriptCmd.Flags().BoolVar(&applyScriptCmdFlags.withTalosconfig, "with-talosconfig", true, "embed talosconfig into the script")
+
+	configCmd.AddCommand(applyScriptCmd)
+}
@@ -0,0 +1,90 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApplyScriptTemplate(t *testing.T) {
+	data := applyScriptData{
+		Generated: "2024-01-01T00:00:00Z",
+		Nodes: []applyScriptNode{{
+			File:   "nodes/it's.yaml",
+			Name:   "00-it's.yaml",
+			Nodes:  []string{"$(touch pwned)"},
+			Config: "machine:\n  type: controlplane",
+		}},
+		Bootstrap: "$(touch pwned)",
+	}
+	var buf bytes.Buffer
+	if err := applyScriptTemplate.Execute(&buf, data); err != nil {
+		t.Fatal(err)
+	}
+	script := buf.String()
+
+	for _, expected := range []string{
+		`# 'nodes/it'\''s.yaml'`,
+		`cat > "$WORKDIR"/'00-it'\''s.yaml' <<'TALM_EOF'` + "\nmachine:\n  type: controlplane\nTALM_EOF\n",
+		`echo Applying 'nodes/it'\''s.yaml' to '$(touch pwned)'`,
+		`talosctl apply-config --insecure --nodes '$(touch pwned)' --file "$WORKDIR"/'00-it'\''s.yaml'`,
+		`talosctl --nodes '$(touch pwned)' --endpoints '$(touch pwned)' bootstrap`,
+		`TALOSCONFIG="${TALOSCONFIG:-$HOME/.talos/config}"`,
+	} {
+		if !strings.Contains(script, expected) {
+			t.Errorf("expected the script to contain %q, got:\n%s", expected, script)
+		}
+	}
+	if strings.Contains(script, " $(touch pwned)") {
+		t.Errorf("expected the node to be quoted, got:\n%s", script)
+	}
+}
+
+func TestCheckHeredoc(t *testing.T) {
+	for _, tt := range []struct {
+		data  string
+		valid bool
+	}{
+		{"machine:\n  type: worker\n", true},
+		{"machine:\nTALM_EOF\n  type: worker\n", false},
+		{"machine:\r\nTALM_EOF\r\n", false},
+		{"TALM_EOF", false},
+		// Only a line equal to the delimiter ends the here-document
+		{"  TALM_EOF\n", true},
+		{"key: TALM_EOF\n", true},
+		{"TALM_EOF2\n", true},
+	} {
+		err := checkHeredoc("nodes/node1.yaml", []byte(tt.data))
+		if valid := err == nil; valid != tt.valid {
+			t.Errorf("%q: expected valid %t, got %v", tt.data, tt.valid, err)
+		}
+	}
+}
+
+func TestGenerateApplyScriptLineBreak(t *testing.T) {
+	files := applyScriptCmdFlags.configFiles
+	defer func() { applyScriptCmdFlags.configFiles = files }()
+
+	dir := t.TempDir()
+	for _, tt := range []struct {
+		file     string
+		modeline string
+	}{
+		{"node.yaml", `# talm: nodes=["10.0.0.1\necho pwned"]`},
+		{"node\n.yaml", `# talm: nodes=["10.0.0.1"]`},
+	} {
+		file := filepath.Join(dir, tt.file)
+		if err := os.WriteFile(file, []byte(tt.modeline+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		applyScriptCmdFlags.configFiles = []string{file}
+
+		_, err := generateApplyScript(context.Background())
+		if err == nil || !strings.Contains(err.Error(), "contains a line break") {
+			t.Errorf("%q: expected a line break error, got %v", tt.file, err)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aenix-io/talm/pkg/engine"
+	"github.com/aenix-io/talm/pkg/iso9660"
+	"github.com/spf13/cobra"
+
+	"github.com/siderolabs/talos/pkg/machinery/constants"
+)
+
+var isoCmdFlags struct {
+	configFiles       []string // -f/--files
+	output            string
+	talosVersion      string
+	withSecrets       string
+	kubernetesVersion string
+}
+
+var isoCmd = &cobra.Command{
+	Use:   "generate-iso",
+	Short: "Generate per-node ISO images with embedded machine config",
+	Long: `Generate an ISO image for every node file with the full machine config embedded.
+
+The image is labeled metal-iso and contains config.yaml, attach it together with
+the Talos installation media booted with the talos.config=metal-iso kernel argument
+(e.g. an Image Factory ISO with this extra kernel argument). The node configures
+itself without network access to the Talos API. Images contain cluster secrets,
+so handle them securely.`,
+	Args: cobra.NoArgs,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if !cmd.Flags().Changed("talos-version") {
+			isoCmdFlags.talosVersion = Config.TemplateOptions.TalosVersion
+		}
+		if !cmd.Flags().Changed("with-secrets") {
+			isoCmdFlags.withSecrets = Config.TemplateOptions.WithSecrets
+		}
+		if !cmd.Flags().Changed("kubernetes-version") {
+			isoCmdFlags.kubernetesVersion = Config.TemplateOptions.KubernetesVersion
+		}
+		if len(isoCmdFlags.configFiles) == 0 {
+			return fmt.Errorf("at least one node file must be specified with --file")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := os.MkdirAll(isoCmdFlags.output, 0o755); err != nil {
+			return fmt.Errorf("failed to create output dir: %w", err)
+		}
+
+		for _, configFile := range isoCmdFlags.configFiles {
+			if err := checkWorkspaceFile(configFile); err != nil {
+				return err
+			}
+
+			opts := engine.Options{
+				TalosVersion:      isoCmdFlags.talosVersion,
+				WithSecrets:       isoCmdFlags.withSecrets,
+				KubernetesVersion: isoCmdFlags.kubernetesVersion,
+			}
+
+			configBundle, err := engine.FullConfigProcess(cmd.Context(), opts, []string{"@" + configFile})
+			if err != nil {
+				return fmt.Errorf("full config processing error: %s", err)
+			}
+
+			machineType := configBundle.ControlPlaneCfg.Machine().Type()
+			result, err := engine.SerializeConfiguration(configBundle, machineType)
+			if err != nil {
+				return fmt.Errorf("error serializing configuration: %s", err)
+			}
+
+			var buf bytes.Buffer
+			files := map[string][]byte{filepath.Base(constants.ConfigPath): result}
+			if err := iso9660.Write(&buf, constants.MetalConfigISOLabel, files, time.Now()); err != nil {
+				return fmt.Errorf("error generating iso for %s: %w", configFile, err)
+			}
+
+			name := strings.TrimSuffix(filepath.Base(configFile), filepath.Ext(configFile)) + ".iso"
+			output := filepath.Join(isoCmdFlags.output, name)
+			if err := os.WriteFile(output, buf.Bytes(), 0o600); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Created %s\n", output)
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	isoCmd.Flags().StringSliceVarP(&isoCmdFlags.configFiles, "file", "f", nil, "specify node files to generate images for (can specify multiple)")
+	isoCmd.Flags().StringVarP(&isoCmdFlags.output, "output", "o", ".", "directory to write the images to")
+	isoCmd.Flags().StringVar(&isoCmdFlags.talosVersion, "talos-version", "", "the desired Talos version to generate config for (backwards compatibility, e.g. v0.8)")
+	isoCmd.Flags().StringVar(&isoCmdFlags.withSecrets, "with-secrets", "", "use a secrets file generated using 'gen secrets', - reads it from stdin")
+	isoCmd.Flags().StringVar(&isoCmdFlags.kubernetesVersion, "kubernetes-version", constants.DefaultKubernetesVersion, "desired kubernetes version to run")
+
+	configCmd.AddCommand(isoCmd)
+}
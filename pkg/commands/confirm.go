@@ -0,0 +1,45 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"github.com/spf13/cobra"
+
+	machineapi "github.com/siderolabs/talos/pkg/machinery/api/machine"
+)
+
+var confirmCmdFlags struct {
+	configFiles []string
+}
+
+var confirmCmd = &cobra.Command{
+	Use:   "confirm",
+	Short: "Confirm the config applied in try mode",
+	Long: `Confirm the config applied with 'talm apply --mode try' before it is rolled back.
+
+The config is rendered from the same files and applied again without reboot,
+which cancels the pending rollback. If the node became unreachable after
+the change, just wait for the rollback timeout to expire.`,
+	Args: cobra.NoArgs,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		// Flags not defined for confirm are never changed, so the defaults are taken from Chart.yaml
+		return applyCmd.PreRunE(cmd, args)
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		applyCmdFlags.configFiles = confirmCmdFlags.configFiles
+		applyCmdFlags.Mode.Mode = machineapi.ApplyConfigurationRequest_NO_REBOOT
+		applyCmdFlags.dryRun = false
+		applyCmdFlags.changedOnly = false
+
+		return WithClientNoNodes(apply(args))
+	},
+}
+
+func init() {
+	confirmCmd.Flags().StringSliceVarP(&confirmCmdFlags.configFiles, "file", "f", nil, "specify config files applied in try mode (can specify multiple)")
+	cobra.CheckErr(confirmCmd.MarkFlagRequired("file"))
+
+	addCommand(confirmCmd)
+}
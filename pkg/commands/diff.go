@@ -0,0 +1,137 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aenix-io/talm/pkg/jsonpatch"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/spf13/cobra"
+
+	"github.com/siderolabs/talos/pkg/machinery/client"
+)
+
+var diffCmdFlags struct {
+	configFiles []string
+	output      string
+}
+
+// nodeDiff is the difference between the config of a node and its node file, printed by `talm diff -o jsonpatch`.
+type nodeDiff struct {
+	Node  string                `json:"node"`
+	File  string                `json:"file"`
+	Patch []jsonpatch.Operation `json:"patch"`
+}
+
+var diffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Show the difference between the configs of the nodes and their node files",
+	Long: `Render the node files as apply does and compare the result with the config running on every node.
+
+The differences are printed as unified diffs, or with --output jsonpatch as a JSON array with
+a JSON Patch (RFC 6902) per node, turning the current config into the rendered one. The config
+is patched as an array of its YAML documents, e.g. /0/machine/nodeLabels/zone is a label of the
+v1alpha1 document. Fields listed in diffOptions.ignorePaths are not compared. The command exits
+with code 2 when a config differs.`,
+	Args: cobra.NoArgs,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if diffCmdFlags.output != "text" && diffCmdFlags.output != "jsonpatch" {
+			return fmt.Errorf("unknown output format %q, use text or jsonpatch", diffCmdFlags.output)
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return WithClientNoNodes(func(ctx context.Context, c *client.Client) error {
+			diffs := []nodeDiff{}
+			nodesFromArgs := len(GlobalArgs.Nodes) > 0
+			endpointsFromArgs := len(GlobalArgs.Endpoints) > 0
+			for _, configFile := range diffCmdFlags.configFiles {
+				if err := processModelineAndUpdateGlobals(configFile, nodesFromArgs, endpointsFromArgs, false); err != nil {
+					return err
+				}
+
+				rendered, err := renderComparableConfig(ctx, c, configFile)
+				if err != nil {
+					return err
+				}
+				for _, node := range GlobalArgs.Nodes {
+					current, err := currentConfig(ctx, c, node)
+					if err != nil {
+						return err
+					}
+					if bytes.Equal(current, rendered) {
+						continue
+					}
+
+					diff, err := diffConfigs(node, configFile, current, rendered)
+					if err != nil {
+						return err
+					}
+					diffs = append(diffs, diff)
+				}
+
+				if !nodesFromArgs {
+					GlobalArgs.Nodes = []string{}
+				}
+				if !endpointsFromArgs {
+					GlobalArgs.Endpoints = []string{}
+				}
+			}
+
+			if diffCmdFlags.output == "jsonpatch" {
+				data, err := json.MarshalIndent(diffs, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(data))
+			}
+
+			if len(diffs) > 0 {
+				return &ExitError{Code: driftExitCode, Err: fmt.Errorf("config of %d node(s) differs from the rendered config", len(diffs))}
+			}
+			return nil
+		})
+	},
+}
+
+// diffConfigs prints the unified diff of the configs, or returns it as a JSON Patch with --output jsonpatch.
+func diffConfigs(node, configFile string, current, rendered []byte) (nodeDiff, error) {
+	result := nodeDiff{Node: node, File: configFile}
+
+	if diffCmdFlags.output == "text" {
+		diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        difflib.SplitLines(string(current)),
+			B:        difflib.SplitLines(string(rendered)),
+			FromFile: node + " (current)",
+			ToFile:   configFile + " (rendered)",
+			Context:  3,
+		})
+		fmt.Print(diff)
+		return result, err
+	}
+
+	from, err := jsonpatch.Documents(current)
+	if err != nil {
+		return result, fmt.Errorf("error decoding current config of node %s: %w", node, err)
+	}
+	to, err := jsonpatch.Documents(rendered)
+	if err != nil {
+		return result, fmt.Errorf("error decoding rendered config of %s: %w", configFile, err)
+	}
+	result.Patch = jsonpatch.Diff(from, to)
+	return result, nil
+}
+
+func init() {
+	diffCmd.Flags().StringSliceVarP(&diffCmdFlags.configFiles, "file", "f", nil, "specify node files to compare (can specify multiple)")
+	diffCmd.Flags().StringVarP(&diffCmdFlags.output, "output", "o", "text", "output format of the differences: text or jsonpatch")
+	cobra.CheckErr(diffCmd.MarkFlagRequired("file"))
+
+	addCommand(diffCmd)
+}
@@ -0,0 +1,136 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/aenix-io/talm/pkg/engine"
+	humanize "github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+
+	"github.com/siderolabs/talos/cmd/talosctl/pkg/talos/helpers"
+	machineapi "github.com/siderolabs/talos/pkg/machinery/api/machine"
+	"github.com/siderolabs/talos/pkg/machinery/client"
+)
+
+var disksWipeCmdFlags struct {
+	selector    string
+	dryRun      bool
+	yes         bool
+	configFiles []string
+}
+
+var disksWipeCmd = &cobra.Command{
+	Use:   "wipe",
+	Short: "Wipe the disks matching the selector",
+	Long: `Wipe the disks matching the selector on the target nodes.
+
+Disks are matched by the same discovery data available to templates in .Disks,
+e.g. --selector model=~Samsung,type=1. System disks are never wiped.
+The node is rebooted after wiping.`,
+	Args: cobra.NoArgs,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		nodesFromArgs := len(GlobalArgs.Nodes) > 0
+		endpointsFromArgs := len(GlobalArgs.Endpoints) > 0
+		for _, configFile := range disksWipeCmdFlags.configFiles {
+			if err := processModelineAndUpdateGlobals(configFile, nodesFromArgs, endpointsFromArgs, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		selector, err := engine.ParseDiskSelector(disksWipeCmdFlags.selector)
+		if err != nil {
+			return err
+		}
+
+		return WithClientNoNodes(func(ctx context.Context, c *client.Client) error {
+			if len(GlobalArgs.Nodes) < 1 {
+				configContext := c.GetConfigContext()
+				if configContext == nil {
+					return errors.New("failed to resolve config context")
+				}
+
+				GlobalArgs.Nodes = configContext.Nodes
+			}
+
+			for _, node := range GlobalArgs.Nodes {
+				if err := wipeNodeDisks(client.WithNode(ctx, node), c, node, selector); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+	},
+}
+
+func wipeNodeDisks(ctx context.Context, c *client.Client, node string, selector engine.DiskSelector) error {
+	response, err := c.Disks(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting disks from %s: %w", node, err)
+	}
+
+	var devices []string
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "NODE\tDEV\tMODEL\tSERIAL\tSIZE")
+	for _, m := range response.Messages {
+		for _, d := range m.Disks {
+			disk, err := engine.DiskToMap(d)
+			if err != nil {
+				return err
+			}
+			if d.SystemDisk || !selector.Matches(disk) {
+				continue
+			}
+			devices = append(devices, d.DeviceName)
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", node, d.DeviceName, d.Model, d.Serial, humanize.Bytes(d.Size))
+		}
+	}
+
+	if len(devices) == 0 {
+		fmt.Fprintf(os.Stderr, "%s: no disks match the selector\n", node)
+		return nil
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if disksWipeCmdFlags.dryRun {
+		return nil
+	}
+
+	if !disksWipeCmdFlags.yes && !helpers.Confirm(fmt.Sprintf("Wipe %d disk(s) on %s? All data will be lost.", len(devices), node)) {
+		return fmt.Errorf("aborted")
+	}
+
+	if err := c.ResetGeneric(ctx, &machineapi.ResetRequest{
+		Reboot:          true,
+		Mode:            machineapi.ResetRequest_USER_DISKS,
+		UserDisksToWipe: devices,
+	}); err != nil {
+		return fmt.Errorf("error wiping disks on %s: %w", node, err)
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: wipe of %v requested\n", node, devices)
+	return nil
+}
+
+func init() {
+	disksWipeCmd.Flags().StringVarP(&disksWipeCmdFlags.selector, "selector", "s", "", "disk selector, comma separated key=value or key=~regex requirements")
+	disksWipeCmd.Flags().BoolVar(&disksWipeCmdFlags.dryRun, "dry-run", false, "only list the disks which would be wiped")
+	disksWipeCmd.Flags().BoolVarP(&disksWipeCmdFlags.yes, "yes", "y", false, "do not ask for confirmation")
+	disksWipeCmd.Flags().StringSliceVarP(&disksWipeCmdFlags.configFiles, "file", "f", nil, "specify config files or patches in a YAML file (can specify multiple)")
+	cobra.CheckErr(disksWipeCmd.MarkFlagRequired("selector"))
+
+	disksCmd.AddCommand(disksWipeCmd)
+}
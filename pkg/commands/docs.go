@@ -0,0 +1,60 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/aenix-io/talm/pkg/engine"
+	"github.com/spf13/cobra"
+)
+
+var docsValuesCmdFlags struct {
+	output string
+}
+
+var docsCmd = &cobra.Command{
+	Use:   "docs",
+	Short: "Generate documentation of the project",
+	Long:  ``,
+}
+
+var docsValuesCmd = &cobra.Command{
+	Use:   "values",
+	Short: "Generate Markdown documentation of the chart values",
+	Long: `Generate a Markdown table of all values consumed by the chart, with their types, defaults,
+descriptions and the templates using them. Values are collected from values.yaml, including
+the commented out examples of optional values, from values.schema.json and from the templates.
+Descriptions are the comments preceding the values in values.yaml, or the schema descriptions.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		chrt, err := engine.LoadChart(Config.RootDir, Config.TemplateOptions.Extends)
+		if err != nil {
+			return err
+		}
+		docs, err := engine.ValuesDocs(chrt)
+		if err != nil {
+			return err
+		}
+		markdown := engine.ValuesMarkdown(chrt.Name(), docs)
+
+		if docsValuesCmdFlags.output == "" || docsValuesCmdFlags.output == "-" {
+			fmt.Print(markdown)
+			return nil
+		}
+		if err := os.WriteFile(docsValuesCmdFlags.output, []byte(markdown), 0o644); err != nil {
+			return fmt.Errorf("error writing values documentation: %w", err)
+		}
+		return nil
+	},
+}
+
+func init() {
+	docsValuesCmd.Flags().StringVarP(&docsValuesCmdFlags.output, "output", "o", "", "file to write the documentation to, stdout by default")
+
+	docsCmd.AddCommand(docsValuesCmd)
+	addCommand(docsCmd)
+}
@@ -0,0 +1,336 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aenix-io/talm/pkg/engine"
+	"github.com/aenix-io/talm/pkg/modeline"
+	"github.com/spf13/cobra"
+
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	"github.com/siderolabs/talos/pkg/machinery/config/generate/secrets"
+)
+
+// doctorNodeTimeout limits the time to reach a single node.
+const doctorNodeTimeout = 10 * time.Second
+
+// doctorCertWarning is the remaining validity of the client certificate reported as a warning.
+const doctorCertWarning = 30 * 24 * time.Hour
+
+var doctorCmdFlags struct {
+	configFiles []string
+	offline     bool
+}
+
+type doctorStatus string
+
+const (
+	doctorOK   doctorStatus = "OK"
+	doctorWarn doctorStatus = "WARN"
+	doctorFail doctorStatus = "FAIL"
+)
+
+// doctorResult is a single diagnosed problem or a passed check, fix is the suggested action.
+type doctorResult struct {
+	check  string
+	status doctorStatus
+	detail string
+	fix    string
+}
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Diagnose the environment and the project setup",
+	Long: `Check the project and the environment for common problems and suggest fixes:
+the chart and templates render, the secrets bundle loads, the talosconfig context
+is valid and its client certificate is not about to expire, the nodes of the node
+files are reachable and run a Talos version supported by talm and the chart.
+
+Node files default to nodes/*.yaml. Use --offline to skip the checks of the nodes.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		files := doctorCmdFlags.configFiles
+		if len(files) == 0 {
+			var err error
+			files, err = defaultNodeFiles()
+			if err != nil {
+				return err
+			}
+		}
+
+		results := doctorChart()
+		for _, file := range files {
+			results = append(results, doctorRender(cmd.Context(), file))
+		}
+
+		bundle, secretsResult := doctorSecrets()
+		results = append(results, secretsResult)
+
+		talosconfigResults := doctorTalosconfig(bundle)
+		results = append(results, talosconfigResults...)
+
+		switch {
+		case doctorCmdFlags.offline:
+		case hasFailures(talosconfigResults):
+			results = append(results, doctorResult{check: "nodes", status: doctorWarn, detail: "skipped, talosconfig is not usable"})
+		default:
+			for _, file := range files {
+				results = append(results, doctorNodes(file)...)
+			}
+		}
+
+		return printDoctorResults(os.Stdout, results)
+	},
+}
+
+func hasFailures(results []doctorResult) bool {
+	for _, result := range results {
+		if result.status == doctorFail {
+			return true
+		}
+	}
+	return false
+}
+
+func printDoctorResults(out io.Writer, results []doctorResult) error {
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "STATUS\tCHECK\tDETAILS")
+	for _, result := range results {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", result.status, result.check, result.detail)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	failed := 0
+	fixes := []string{}
+	for _, result := range results {
+		if result.status == doctorFail {
+			failed++
+		}
+		if result.status != doctorOK && result.fix != "" {
+			fixes = append(fixes, fmt.Sprintf("  %s: %s", result.check, result.fix))
+		}
+	}
+	if len(fixes) > 0 {
+		fmt.Fprintf(out, "\nSuggested fixes:\n%s\n", strings.Join(fixes, "\n"))
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d check(s) failed", failed)
+	}
+	return nil
+}
+
+// doctorChart loads the chart and compares the version of the talm library chart with talm.
+func doctorChart() []doctorResult {
+	chrt, err := engine.LoadChart(Config.RootDir, Config.TemplateOptions.Extends)
+	if err != nil {
+		return []doctorResult{{check: "chart", status: doctorFail, detail: err.Error(), fix: "fix the syntax of Chart.yaml and the templates"}}
+	}
+
+	results := []doctorResult{{check: "chart", status: doctorOK, detail: fmt.Sprintf("%s %s", chrt.Name(), chrt.Metadata.Version)}}
+
+	for _, dep := range chrt.Dependencies() {
+		if dep.Name() != "talm" {
+			continue
+		}
+
+		current := strings.TrimPrefix(Version, "v")
+		if dep.Metadata.Version == current || Version == "dev" {
+			results = append(results, doctorResult{check: "library chart", status: doctorOK, detail: "talm " + dep.Metadata.Version})
+			break
+		}
+
+		results = append(results, doctorResult{
+			check:  "library chart",
+			status: doctorWarn,
+			detail: fmt.Sprintf("charts/talm is from talm %s, running talm %s", dep.Metadata.Version, current),
+			fix:    "update charts/talm from `talm init` of this version, or use templateOptions.extends to follow the presets of talm",
+		})
+	}
+
+	return results
+}
+
+// doctorRender renders the node file offline to find template and values errors.
+func doctorRender(ctx context.Context, file string) doctorResult {
+	check := "render " + file
+	fix := fmt.Sprintf("run `talm template -f %s --offline` to see the error", file)
+
+	modelineConfig, err := modeline.ReadAndParseModeline(file)
+	if err != nil {
+		return doctorResult{check: check, status: doctorFail, detail: err.Error(), fix: "the first line must be a modeline: # talm: nodes=[...], endpoints=[...], templates=[...]"}
+	}
+	if len(modelineConfig.Templates) == 0 {
+		return doctorResult{check: check, status: doctorFail, detail: "modeline does not contain templates", fix: fix}
+	}
+
+	_, err = engine.Render(ctx, nil, engine.Options{
+		Offline:           true,
+		Root:              Config.RootDir,
+		Extends:           Config.TemplateOptions.Extends,
+		ValueFiles:        Config.TemplateOptions.ValueFiles,
+		Values:            Config.TemplateOptions.Values,
+		StringValues:      Config.TemplateOptions.StringValues,
+		FileValues:        Config.TemplateOptions.FileValues,
+		JsonValues:        Config.TemplateOptions.JsonValues,
+		LiteralValues:     Config.TemplateOptions.LiteralValues,
+		NodeValues:        modelineConfig.Values,
+		EnvAllowlist:      Config.TemplateOptions.EnvAllowlist,
+		Plugins:           Config.TemplateOptions.Plugins,
+		TalosVersion:      Config.TemplateOptions.TalosVersion,
+		WithSecrets:       Config.TemplateOptions.WithSecrets,
+		KubernetesVersion: Config.TemplateOptions.KubernetesVersion,
+		TemplateFiles:     modelineConfig.Templates,
+	})
+	if err != nil {
+		return doctorResult{check: check, status: doctorFail, detail: err.Error(), fix: fix}
+	}
+
+	return doctorResult{check: check, status: doctorOK, detail: strings.Join(modelineConfig.Templates, ", ")}
+}
+
+func doctorSecrets() (*secrets.Bundle, doctorResult) {
+	path := Config.TemplateOptions.WithSecrets
+	if path == "" {
+		return nil, doctorResult{check: "secrets", status: doctorFail, detail: "templateOptions.withSecrets is not set", fix: "set templateOptions.withSecrets in Chart.yaml, e.g. to secrets.yaml created by `talm init`"}
+	}
+
+	bundle, err := secrets.LoadBundle(path)
+	if err != nil {
+		return nil, doctorResult{check: "secrets", status: doctorFail, detail: err.Error(), fix: fmt.Sprintf("restore %s from a backup, it can't be regenerated for an existing cluster", path)}
+	}
+
+	return bundle, doctorResult{check: "secrets", status: doctorOK, detail: path}
+}
+
+// doctorTalosconfig checks the current context, its client certificate and, if the bundle is loaded, its CA.
+func doctorTalosconfig(bundle *secrets.Bundle) []doctorResult {
+	fail := func(detail, fix string) []doctorResult {
+		return []doctorResult{{check: "talosconfig", status: doctorFail, detail: detail, fix: fix}}
+	}
+
+	path := GlobalArgs.Talosconfig
+	if _, err := os.Stat(path); err != nil {
+		return fail(err.Error(), "create talosconfig with `talm init` or set globalOptions.talosconfig in Chart.yaml")
+	}
+
+	cfg, err := openTalosconfig(path)
+	if err != nil {
+		return fail(err.Error(), "fix the syntax of "+path)
+	}
+
+	contextName := GlobalArgs.CmdContext
+	if contextName == "" {
+		contextName = cfg.Context
+	}
+	configContext, ok := cfg.Contexts[contextName]
+	if !ok {
+		return fail(fmt.Sprintf("context %q is not defined", contextName), "set the current context with `talosctl config context` or use --context")
+	}
+
+	results := []doctorResult{{check: "talosconfig", status: doctorOK, detail: fmt.Sprintf("%s, context %q", path, contextName)}}
+
+	if len(configContext.Endpoints) == 0 {
+		results = append(results, doctorResult{check: "endpoints", status: doctorWarn, detail: "no endpoints in the context, they must be set in the modelines", fix: "add endpoints with `talosctl config endpoint`"})
+	}
+
+	cert, err := decodeClientCertificate(configContext.Crt)
+	switch {
+	case err != nil:
+		results = append(results, doctorResult{check: "client certificate", status: doctorFail, detail: err.Error(), fix: "use --regenerate-client-cert to issue a new one from the secrets bundle"})
+	case time.Until(cert.NotAfter) <= 0:
+		results = append(results, doctorResult{check: "client certificate", status: doctorFail, detail: "expired at " + cert.NotAfter.Format(time.RFC3339), fix: "use --regenerate-client-cert to issue a new one from the secrets bundle"})
+	case time.Until(cert.NotAfter) < doctorCertWarning:
+		results = append(results, doctorResult{check: "client certificate", status: doctorWarn, detail: "expires at " + cert.NotAfter.Format(time.RFC3339), fix: "use --regenerate-client-cert to issue a new one from the secrets bundle"})
+	default:
+		results = append(results, doctorResult{check: "client certificate", status: doctorOK, detail: "valid until " + cert.NotAfter.Format(time.RFC3339)})
+	}
+
+	if bundle != nil {
+		for _, result := range verifyTalosconfig(path, contextName, bundle) {
+			if result.err != nil {
+				results = append(results, doctorResult{check: "talosconfig " + result.check, status: doctorFail, detail: result.err.Error(), fix: "talosconfig belongs to another cluster, run `talm verify` for details"})
+			}
+		}
+	}
+
+	return results
+}
+
+// doctorNodes checks the nodes of the node file are reachable and run a supported Talos version.
+func doctorNodes(file string) []doctorResult {
+	modelineConfig, err := modeline.ReadAndParseModeline(file)
+	if err != nil || len(modelineConfig.Nodes) == 0 {
+		return nil
+	}
+
+	endpoints := GlobalArgs.Endpoints
+	if len(endpoints) == 0 {
+		GlobalArgs.Endpoints = modelineConfig.Endpoints
+		defer func() { GlobalArgs.Endpoints = endpoints }()
+	}
+
+	supported := getBuildInfo().Talos.Max
+
+	var results []doctorResult
+	err = WithClientNoNodes(func(ctx context.Context, c *client.Client) error {
+		for _, node := range modelineConfig.Nodes {
+			check := "node " + node
+
+			nodeCtx, cancel := context.WithTimeout(client.WithNode(ctx, node), doctorNodeTimeout)
+			resp, err := c.Version(nodeCtx)
+			cancel()
+			if err != nil {
+				results = append(results, doctorResult{
+					check:  check,
+					status: doctorFail,
+					detail: err.Error(),
+					fix:    fmt.Sprintf("check that the endpoints %s can reach the node on port 50000, use --proxy for nodes behind a bastion", strings.Join(GlobalArgs.Endpoints, ", ")),
+				})
+				continue
+			}
+
+			tag := resp.Messages[0].GetVersion().GetTag()
+			result := doctorResult{check: check, status: doctorOK, detail: "Talos " + tag}
+
+			switch {
+			case Config.TemplateOptions.TalosVersion != "" && engine.VersionSkew(Config.TemplateOptions.TalosVersion, tag):
+				result.status = doctorWarn
+				result.detail = fmt.Sprintf("Talos %s, chart renders for %s", tag, Config.TemplateOptions.TalosVersion)
+				result.fix = "update templateOptions.talosVersion in Chart.yaml after upgrading the nodes"
+			case isNewerVersion(supported, tag) && engine.VersionSkew(supported, tag):
+				result.status = doctorWarn
+				result.detail = fmt.Sprintf("Talos %s is newer than %s supported by talm %s", tag, supported, Version)
+				result.fix = "run `talm self-update`"
+			}
+
+			results = append(results, result)
+		}
+
+		return nil
+	})
+	if err != nil {
+		results = append(results, doctorResult{check: "nodes of " + file, status: doctorFail, detail: err.Error(), fix: "check talosconfig and the endpoints in the modeline"})
+	}
+
+	return results
+}
+
+func init() {
+	doctorCmd.Flags().StringSliceVarP(&doctorCmdFlags.configFiles, "file", "f", nil, "specify node files to check (defaults to nodes/*.yaml)")
+	doctorCmd.Flags().BoolVar(&doctorCmdFlags.offline, "offline", false, "do not connect to the nodes")
+
+	addCommand(doctorCmd)
+}
@@ -0,0 +1,174 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+
+	"github.com/aenix-io/talm/pkg/engine"
+	"github.com/cosi-project/runtime/pkg/safe"
+	"github.com/spf13/cobra"
+
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	"github.com/siderolabs/talos/pkg/machinery/config/machine"
+	"github.com/siderolabs/talos/pkg/machinery/resources/cluster"
+)
+
+var syncEndpointsCmdFlags struct {
+	dryRun bool
+}
+
+// valuesEndpointsCache holds the endpoints resolved from the values, they are resolved once.
+var valuesEndpointsCache struct {
+	resolved  bool
+	endpoints []string
+}
+
+// resolveEndpoints sets the endpoints from the values when neither --endpoints, the modeline
+// nor the talosconfig context set them: the floatingIP value, or the host of the endpoint value.
+func resolveEndpoints() {
+	if len(GlobalArgs.Endpoints) > 0 {
+		return
+	}
+
+	cfg, err := openTalosconfig(GlobalArgs.Talosconfig)
+	if err != nil {
+		// Reported by the client itself
+		return
+	}
+	contextName := GlobalArgs.CmdContext
+	if contextName == "" {
+		contextName = cfg.Context
+	}
+	if configContext, ok := cfg.Contexts[contextName]; !ok || len(configContext.Endpoints) > 0 {
+		return
+	}
+
+	if !valuesEndpointsCache.resolved {
+		valuesEndpointsCache.resolved = true
+		valuesEndpointsCache.endpoints = valuesEndpoints()
+		if len(valuesEndpointsCache.endpoints) > 0 {
+			fmt.Fprintf(os.Stderr, "- talm: no endpoints set, using %s from the values, run `talm config sync-endpoints` to use the control plane nodes\n", valuesEndpointsCache.endpoints)
+		}
+	}
+	GlobalArgs.Endpoints = valuesEndpointsCache.endpoints
+}
+
+// valuesEndpoints returns the floatingIP value, or the host of the endpoint value.
+func valuesEndpoints() []string {
+	values, err := engine.Values(engine.Options{
+		Root:          Config.RootDir,
+		Extends:       Config.TemplateOptions.Extends,
+		ValueFiles:    Config.TemplateOptions.ValueFiles,
+		Values:        Config.TemplateOptions.Values,
+		StringValues:  Config.TemplateOptions.StringValues,
+		FileValues:    Config.TemplateOptions.FileValues,
+		JsonValues:    Config.TemplateOptions.JsonValues,
+		LiteralValues: Config.TemplateOptions.LiteralValues,
+	})
+	if err != nil {
+		return nil
+	}
+
+	if floatingIP, ok := values["floatingIP"].(string); ok && floatingIP != "" {
+		return []string{floatingIP}
+	}
+	if endpoint, ok := values["endpoint"].(string); ok {
+		if u, err := url.Parse(endpoint); err == nil && u.Hostname() != "" {
+			return []string{u.Hostname()}
+		}
+	}
+	return nil
+}
+
+// controlPlaneEndpoints returns the addresses of the control plane members of the cluster,
+// the first address of every member is used.
+func controlPlaneEndpoints(ctx context.Context, c *client.Client) ([]string, error) {
+	members, err := safe.StateListAll[*cluster.Member](ctx, c.COSI)
+	if err != nil {
+		return nil, fmt.Errorf("error listing cluster members: %w", err)
+	}
+
+	var endpoints []string
+	for it := members.Iterator(); it.Next(); {
+		spec := it.Value().TypedSpec()
+		if spec.MachineType != machine.TypeControlPlane && spec.MachineType != machine.TypeInit {
+			continue
+		}
+		if len(spec.Addresses) == 0 {
+			continue
+		}
+		endpoints = append(endpoints, spec.Addresses[0].String())
+	}
+	sort.Strings(endpoints)
+	return endpoints, nil
+}
+
+var syncEndpointsCmd = &cobra.Command{
+	Use:   "sync-endpoints",
+	Short: "Set the endpoints of the talosconfig context to the control plane nodes",
+	Long: `Read the control plane members of the cluster from the discovery service and set their
+addresses as the endpoints of the talosconfig context, so the client keeps working when control
+plane nodes are replaced. The cluster is reached through the current endpoints, or the
+floatingIP or endpoint value if the context has none.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var endpoints []string
+		err := WithClientNoNodes(func(ctx context.Context, c *client.Client) error {
+			var err error
+			endpoints, err = controlPlaneEndpoints(ctx, c)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if len(endpoints) == 0 {
+			return errors.New("no control plane members found, is the discovery service enabled in the cluster?")
+		}
+
+		cfg, err := openTalosconfig(GlobalArgs.Talosconfig)
+		if err != nil {
+			return err
+		}
+		contextName := GlobalArgs.CmdContext
+		if contextName == "" {
+			contextName = cfg.Context
+		}
+		configContext, ok := cfg.Contexts[contextName]
+		if !ok {
+			return fmt.Errorf("talosconfig context %q not found", contextName)
+		}
+
+		current := append([]string(nil), configContext.Endpoints...)
+		sort.Strings(current)
+		if slices.Equal(current, endpoints) {
+			fmt.Printf("- talm: context=%s, endpoints=%s, unchanged\n", contextName, endpoints)
+			return nil
+		}
+		fmt.Printf("- talm: context=%s, endpoints=%s -> %s\n", contextName, configContext.Endpoints, endpoints)
+		if syncEndpointsCmdFlags.dryRun {
+			return nil
+		}
+
+		configContext.Endpoints = endpoints
+		if err := saveTalosconfig(cfg, GlobalArgs.Talosconfig); err != nil {
+			return fmt.Errorf("failed to save talosconfig: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "Updated.\n")
+		return nil
+	},
+}
+
+func init() {
+	syncEndpointsCmd.Flags().BoolVar(&syncEndpointsCmdFlags.dryRun, "dry-run", false, "print the endpoints without updating the talosconfig")
+
+	configCmd.AddCommand(syncEndpointsCmd)
+}
@@ -0,0 +1,72 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	clientconfig "github.com/siderolabs/talos/pkg/machinery/client/config"
+)
+
+func TestResolveEndpoints(t *testing.T) {
+	config, globalArgs, cache := Config, GlobalArgs, valuesEndpointsCache
+	defer func() { Config, GlobalArgs, valuesEndpointsCache = config, globalArgs, cache }()
+
+	Config.RootDir = t.TempDir()
+	Config.TemplateOptions.Values = nil
+	if err := os.WriteFile(filepath.Join(Config.RootDir, "Chart.yaml"), []byte("apiVersion: v2\nname: cluster\nversion: 0.1.0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(Config.RootDir, "values.yaml"), []byte("endpoint: https://192.168.100.10:6443\nfloatingIP: \"\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &clientconfig.Config{Context: "cluster", Contexts: map[string]*clientconfig.Context{
+		"cluster": {},
+		"other":   {Endpoints: []string{"10.0.0.1"}},
+	}}
+	GlobalArgs.Talosconfig = filepath.Join(Config.RootDir, "talosconfig")
+	if err := cfg.Save(GlobalArgs.Talosconfig); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		context   string
+		endpoints []string
+		values    []string
+		expected  []string
+	}{
+		// The host of the endpoint value
+		{expected: []string{"192.168.100.10"}},
+		{values: []string{"floatingIP=192.168.100.5"}, expected: []string{"192.168.100.5"}},
+		{values: []string{"endpoint=https://[fd00::10]:6443"}, expected: []string{"fd00::10"}},
+		{values: []string{"endpoint="}},
+		// --endpoints or the modeline set them
+		{endpoints: []string{"10.0.0.2"}, expected: []string{"10.0.0.2"}},
+		// The context has endpoints
+		{context: "other"},
+		{context: "missing"},
+	} {
+		valuesEndpointsCache.resolved = false
+		GlobalArgs.CmdContext, GlobalArgs.Endpoints = tt.context, tt.endpoints
+		Config.TemplateOptions.Values = tt.values
+
+		resolveEndpoints()
+		if (len(GlobalArgs.Endpoints) != 0 || len(tt.expected) != 0) && !reflect.DeepEqual(GlobalArgs.Endpoints, tt.expected) {
+			t.Errorf("context %q, values %v: expected endpoints %v, got %v", tt.context, tt.values, tt.expected, GlobalArgs.Endpoints)
+		}
+	}
+
+	// The values are resolved once per command
+	valuesEndpointsCache.resolved = false
+	GlobalArgs.CmdContext, GlobalArgs.Endpoints = "", nil
+	Config.TemplateOptions.Values = nil
+	resolveEndpoints()
+	Config.TemplateOptions.Values = []string{"floatingIP=192.168.100.5"}
+	GlobalArgs.Endpoints = nil
+	resolveEndpoints()
+	if expected := []string{"192.168.100.10"}; !reflect.DeepEqual(GlobalArgs.Endpoints, expected) {
+		t.Errorf("expected the cached endpoints %v, got %v", expected, GlobalArgs.Endpoints)
+	}
+}
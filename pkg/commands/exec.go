@@ -0,0 +1,199 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/aenix-io/talm/pkg/modeline"
+	shlex "github.com/flynn/go-shlex"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// execCommands are the commands for interacting with a node which exec and shell run.
+var execCommands = []string{"containers", "dmesg", "list", "logs", "memory", "mounts", "netstat", "pcap", "processes", "read", "service"}
+
+var execCmd = &cobra.Command{
+	Use:   "exec <node> <command> [<args>...]",
+	Short: "Run a command interacting with a node of the inventory",
+	Long: `Run a command interacting with a node, addressed like in the inventory of the project:
+by the name of its node file (nodes/<name>.yaml), the path of the node file, or an address
+of the modeline of a node file. The node is reached through the endpoints of its node file.
+
+The commands are ` + strings.Join(execCommands, ", ") + `, and their aliases:
+
+  talm exec cp1 ps
+  talm exec cp1 read /proc/meminfo
+  talm exec 10.0.0.1 netstat -l
+  talm exec cp1 pcap -i eth0 --duration 10s -o cp1.pcap`,
+	Args: cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		target, err := nodeTargetArgs(args[0])
+		if err != nil {
+			return err
+		}
+		return runNodeCommand(cmd, target, args[1:])
+	},
+}
+
+var shellCmd = &cobra.Command{
+	Use:   "shell <node>",
+	Short: "Interact with a node of the inventory in a shell",
+	Long: `Read commands interacting with the node from the terminal and run them against the node,
+addressed like in 'talm exec'. Arguments are split like a shell does, 'help' lists the commands
+and 'exit' or Ctrl-D leaves the shell. Ctrl-C stops the running command, e.g. a capture.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		target, err := nodeTargetArgs(args[0])
+		if err != nil {
+			return err
+		}
+
+		// The running command is interrupted, not the shell
+		interrupts := make(chan os.Signal, 1)
+		signal.Notify(interrupts, os.Interrupt)
+		defer signal.Stop(interrupts)
+		go func() {
+			for range interrupts {
+			}
+		}()
+
+		scanner := bufio.NewScanner(os.Stdin)
+		for {
+			fmt.Fprintf(os.Stderr, "%s> ", args[0])
+			if !scanner.Scan() {
+				fmt.Fprintln(os.Stderr)
+				return scanner.Err()
+			}
+
+			words, err := shlex.Split(scanner.Text())
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+				continue
+			}
+			switch {
+			case len(words) == 0:
+				continue
+			case words[0] == "exit" || words[0] == "quit":
+				return nil
+			case words[0] == "help":
+				fmt.Fprintf(os.Stderr, "Commands: %s, exit\n", strings.Join(execCommands, ", "))
+				continue
+			}
+
+			// The command reports its own errors
+			var exitErr *ExitError
+			if err := runNodeCommand(cmd, target, words); err != nil && !errors.As(err, &exitErr) {
+				fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			}
+		}
+	},
+}
+
+// nodeTargetArgs returns the arguments addressing the node: the node file with the name or path,
+// or the node file with the address in its modeline, together with the address.
+func nodeTargetArgs(node string) ([]string, error) {
+	if strings.HasSuffix(node, ".yaml") {
+		if err := checkWorkspaceFile(node); err != nil {
+			return nil, err
+		}
+		return []string{"--file", node}, nil
+	}
+
+	dir := filepath.Join(stateDir(), "nodes")
+	if file := filepath.Join(dir, node+".yaml"); fileExists(file) {
+		return []string{"--file", file}, nil
+	}
+
+	files, err := defaultNodeFiles()
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		modelineConfig, err := modeline.ReadAndParseModeline(file)
+		if err != nil {
+			continue
+		}
+		if slices.Contains(modelineConfig.Nodes, node) {
+			return []string{"--file", file, "--nodes", node}, nil
+		}
+	}
+
+	suggestion := ""
+	if closest := closestNode(node, declaredNodes()); closest != "" {
+		suggestion = fmt.Sprintf(", did you mean %s?", closest)
+	}
+	return nil, fmt.Errorf("node %s is neither a node file in %s nor in the modeline of one%s", node, dir, suggestion)
+}
+
+// runNodeCommand runs talm with the command and its arguments against the node addressed by target.
+func runNodeCommand(cmd *cobra.Command, target, args []string) error {
+	var command *cobra.Command
+	for _, c := range Commands {
+		if c.Name() == args[0] || c.HasAlias(args[0]) {
+			command = c
+			break
+		}
+	}
+	if command == nil || !slices.Contains(execCommands, command.Name()) {
+		return fmt.Errorf("%s is not available against a node, use one of %s", args[0], strings.Join(execCommands, ", "))
+	}
+
+	talm, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	talmArgs := append([]string{command.Name()}, target...)
+
+	// The global flags are passed on, the nodes are addressed by target
+	talmArgs = append(talmArgs, changedFlagArgs(cmd.Root().PersistentFlags(), "nodes")...)
+
+	run := exec.Command(talm, append(talmArgs, args[1:]...)...)
+	run.Stdin = os.Stdin
+	run.Stdout = os.Stdout
+	run.Stderr = os.Stderr
+	err = run.Run()
+
+	// The exit code of the command is kept
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return &ExitError{Code: exitErr.ExitCode(), Err: err}
+	}
+	return err
+}
+
+// changedFlagArgs returns the flags set on the command line as arguments for another run of talm,
+// except the skipped ones.
+func changedFlagArgs(flags *pflag.FlagSet, skip ...string) []string {
+	var args []string
+	flags.VisitAll(func(f *pflag.Flag) {
+		if !f.Changed || slices.Contains(skip, f.Name) {
+			return
+		}
+		value := f.Value.String()
+		if slice, ok := f.Value.(pflag.SliceValue); ok {
+			value = strings.Join(slice.GetSlice(), ",")
+		}
+		args = append(args, "--"+f.Name+"="+value)
+	})
+	return args
+}
+
+func init() {
+	// The flags following the node belong to the command
+	execCmd.Flags().SetInterspersed(false)
+
+	addCommand(execCmd)
+	addCommand(shellCmd)
+}
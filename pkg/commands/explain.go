@@ -0,0 +1,104 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/aenix-io/talm/pkg/engine"
+	"github.com/spf13/cobra"
+)
+
+var explainCmdFlags struct {
+	output string
+}
+
+var explainCmd = &cobra.Command{
+	Use:   "explain <value path>",
+	Short: "Show which templates and config fields use a value",
+	Long: `Show the template lines referencing a value of values.yaml and the rendered config fields
+it affects, e.g.:
+
+  talm explain endpoint
+  talm explain bond.mode
+
+The chart is rendered offline twice, with the value and with a sentinel in its place,
+the fields which differ are affected by the value.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if explainCmdFlags.output != "text" && explainCmdFlags.output != "json" {
+			return fmt.Errorf("invalid output format %q, must be text or json", explainCmdFlags.output)
+		}
+
+		explanation, err := engine.Explain(engine.Options{
+			Root:          Config.RootDir,
+			Extends:       Config.TemplateOptions.Extends,
+			ValueFiles:    Config.TemplateOptions.ValueFiles,
+			Values:        Config.TemplateOptions.Values,
+			StringValues:  Config.TemplateOptions.StringValues,
+			FileValues:    Config.TemplateOptions.FileValues,
+			JsonValues:    Config.TemplateOptions.JsonValues,
+			LiteralValues: Config.TemplateOptions.LiteralValues,
+			EnvAllowlist:  Config.TemplateOptions.EnvAllowlist,
+			Plugins:       Config.TemplateOptions.Plugins,
+		}, args[0])
+		if err != nil {
+			return err
+		}
+
+		if explainCmdFlags.output == "json" {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(explanation)
+		}
+
+		value, err := json.Marshal(explanation.Value)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Value: %s = %s\n", explanation.Path, value)
+
+		fmt.Println("\nReferenced by:")
+		if len(explanation.References) == 0 {
+			fmt.Println("  no template references the value")
+		}
+		for _, reference := range explanation.References {
+			fmt.Printf("  %s\n", reference)
+		}
+
+		fmt.Println("\nAffected config fields:")
+		if len(explanation.Fields) == 0 {
+			fmt.Println("  none when rendered offline, the value may be used only with discovered resources")
+		}
+		files := make([]string, 0, len(explanation.Fields))
+		for file := range explanation.Fields {
+			files = append(files, file)
+		}
+		sort.Strings(files)
+		for _, file := range files {
+			fmt.Printf("  %s:\n", file)
+			for _, field := range explanation.Fields[file] {
+				fmt.Printf("    %s\n", field)
+			}
+		}
+
+		if len(explanation.Changed) > 0 {
+			fmt.Println("\nChanged output:")
+			for _, file := range explanation.Changed {
+				fmt.Printf("  %s\n", file)
+			}
+		}
+		return nil
+	},
+}
+
+func init() {
+	explainCmd.Flags().StringVarP(&explainCmdFlags.output, "output", "o", "text", "output format: text or json")
+
+	addCommand(explainCmd)
+}
@@ -0,0 +1,123 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aenix-io/talm/pkg/yamltools"
+	"github.com/siderolabs/crypto/x509"
+	"gopkg.in/yaml.v3"
+
+	"github.com/siderolabs/talos/pkg/machinery/constants"
+)
+
+// fingerprintProbeTimeout limits the TLS handshake reading the certificate of a node.
+const fingerprintProbeTimeout = 10 * time.Second
+
+// pinnedFingerprints returns the fingerprints to enforce for the insecure connection to the nodes.
+//
+// The certificate of every node is read first: an unknown node is trusted on first use and
+// its fingerprint is recorded, a known node must present the recorded certificate.
+// The returned fingerprints are then enforced on the connection itself.
+func pinnedFingerprints(ctx context.Context, nodes []string) ([]string, error) {
+	known, err := loadKnownNodes()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		fingerprints []string
+		learned      []string
+	)
+	for _, node := range nodes {
+		fingerprint, err := probeFingerprint(ctx, node)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the certificate of node %s: %w", node, err)
+		}
+
+		record := known[node]
+		switch {
+		case record.CertFingerprint == "":
+			fmt.Fprintf(os.Stderr, "Warning: trusting node %s on first use, its certificate fingerprint %s is recorded in %s\n", node, fingerprint, knownNodesFile)
+			record.CertFingerprint, record.Learned = fingerprint.String(), time.Now().UTC()
+			known[node] = record
+			learned = append(learned, node)
+		case record.CertFingerprint != fingerprint.String():
+			return nil, fmt.Errorf("certificate fingerprint of node %s is %s, but %s was recorded at %s: the connection may be intercepted, "+
+				"if the node was rebooted into maintenance mode, verify the fingerprint on its console and remove it from %s",
+				node, fingerprint, record.CertFingerprint, record.Learned.Format(time.RFC3339), knownNodesFile)
+		}
+
+		fingerprints = append(fingerprints, fingerprint.String())
+	}
+
+	if len(learned) > 0 {
+		// The fingerprints are added to the tree of the file to keep the entries written by hand as is
+		err := editKnownNodes(func(root *yaml.Node) error {
+			for _, node := range learned {
+				yamltools.SetScalar(root, known[node].CertFingerprint, node, "certFingerprint")
+				yamltools.SetScalar(root, known[node].Learned.Format(time.RFC3339), node, "learned")
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return fingerprints, nil
+}
+
+// probeFingerprint reads the SPKI fingerprint of the certificate the node presents on the Talos API port.
+func probeFingerprint(ctx context.Context, node string) (x509.Fingerprint, error) {
+	addr := node
+	if _, _, err := net.SplitHostPort(node); err != nil {
+		addr = net.JoinHostPort(node, strconv.Itoa(constants.ApidPort))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, fingerprintProbeTimeout)
+	defer cancel()
+
+	if _, err := proxyDialOptions(); err != nil {
+		return nil, err
+	}
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	if proxyDial != nil {
+		conn, err = proxyDial(ctx, addr)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close() //nolint:errcheck
+
+	tlsConn := tls.Client(conn, &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         []string{"h2"},
+	})
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+
+	certs := tlsConn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, errors.New("no certificate presented")
+	}
+
+	return x509.SPKIFingerprint(certs[0]), nil
+}
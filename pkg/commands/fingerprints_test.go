@@ -0,0 +1,81 @@
+package commands
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/siderolabs/crypto/x509"
+)
+
+func TestPinnedFingerprints(t *testing.T) {
+	rootDir, workspace := Config.RootDir, Config.Workspace
+	defer func() { Config.RootDir, Config.Workspace = rootDir, workspace }()
+	Config.RootDir, Config.Workspace = t.TempDir(), ""
+
+	server := httptest.NewUnstartedServer(http.NotFoundHandler())
+	server.EnableHTTP2 = true
+	// The probe closes the connection after the handshake
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+	node := server.Listener.Addr().String()
+	expected := x509.SPKIFingerprint(server.Certificate()).String()
+
+	file := filepath.Join(Config.RootDir, knownNodesFile)
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte("# rack 1\n10.0.0.1:\n  bmc:\n    address: 10.1.0.1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The node is trusted on first use
+	fingerprints, err := pinnedFingerprints(context.Background(), []string{node})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fingerprints) != 1 || fingerprints[0] != expected {
+		t.Fatalf("expected fingerprint %s, got %v", expected, fingerprints)
+	}
+	known, err := loadKnownNodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if known[node].CertFingerprint != expected || known[node].Learned.IsZero() {
+		t.Errorf("expected the fingerprint to be recorded, got %+v", known[node])
+	}
+	if known["10.0.0.1"].BMC == nil {
+		t.Errorf("expected the other nodes to be kept, got %+v", known)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "# rack 1\n") {
+		t.Errorf("expected the comments to be kept, got:\n%s", data)
+	}
+	if info, err := os.Stat(file); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("expected the inventory to be readable by the owner only, got %v, %v", info.Mode(), err)
+	}
+
+	// The recorded certificate is presented again
+	if fingerprints, err := pinnedFingerprints(context.Background(), []string{node}); err != nil || fingerprints[0] != expected {
+		t.Errorf("expected fingerprint %s, got %v, %v", expected, fingerprints, err)
+	}
+
+	// Another certificate is refused
+	recorded := strings.Replace(string(data), expected, "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=", 1)
+	if err := os.WriteFile(file, []byte(recorded), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pinnedFingerprints(context.Background(), []string{node}); err == nil || !strings.Contains(err.Error(), "the connection may be intercepted") {
+		t.Errorf("expected a fingerprint mismatch, got %v", err)
+	}
+}
@@ -0,0 +1,202 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/aenix-io/talm/pkg/format"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/spf13/cobra"
+)
+
+var fmtCmdFlags struct {
+	check bool
+	diff  bool
+}
+
+var fmtCmd = &cobra.Command{
+	Use:   "fmt [<path>...]",
+	Short: "Format the templates and values files of the project",
+	Long: `Normalize the style of the chart templates and values files, by default of the whole project.
+
+The actions of the templates get a single space inside the delimiters and around pipes,
+e.g. {{-toYaml .|nindent 2}} becomes {{- toYaml . | nindent 2 }}; the text around the
+actions, string literals and comments are kept. Values files get their trailing blanks,
+line endings and final newline normalized. The parse tree of a template and the data of
+a values file are compared before and after formatting, a file whose meaning would
+change is reported and left as is.
+
+The files are formatted in place and listed. With --check nothing is written and the
+command exits with code 2 when any file needs formatting, for CI pipelines.
+
+Templates are the files in templates/ directories, values files are the values*.yaml
+files and the valueFiles of Chart.yaml. Symbolic links, like the library chart linked
+into the preset charts, and hidden directories are skipped.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		paths := args
+		if len(paths) == 0 {
+			paths = []string{Config.RootDir}
+		}
+
+		files, err := fmtFiles(paths)
+		if err != nil {
+			return err
+		}
+
+		unformatted := 0
+		var errs []string
+		for _, file := range files {
+			changed, err := fmtFile(file)
+			if err != nil {
+				errs = append(errs, err.Error())
+				continue
+			}
+			if changed {
+				unformatted++
+				fmt.Println(file.path)
+			}
+		}
+
+		if len(errs) > 0 {
+			return fmt.Errorf("failed to format %d file(s):\n%s", len(errs), strings.Join(errs, "\n"))
+		}
+		if fmtCmdFlags.check && unformatted > 0 {
+			return &ExitError{Code: driftExitCode, Err: fmt.Errorf("%d file(s) need formatting", unformatted)}
+		}
+
+		return nil
+	},
+}
+
+// fmtTarget is a file formatted by talm fmt.
+type fmtTarget struct {
+	path     string
+	template bool
+}
+
+// fmtFiles returns the templates and values files of the paths, directories are walked.
+func fmtFiles(paths []string) ([]fmtTarget, error) {
+	valueFiles := map[string]bool{}
+	for _, file := range Config.TemplateOptions.ValueFiles {
+		if abs, err := filepath.Abs(file); err == nil {
+			valueFiles[abs] = true
+		}
+	}
+
+	classify := func(path string, explicit bool) (fmtTarget, bool) {
+		ext := filepath.Ext(path)
+		dirs := strings.Split(filepath.ToSlash(filepath.Dir(path)), "/")
+		if slices.Contains(dirs, "templates") || ext == ".tpl" {
+			return fmtTarget{path: path, template: true}, true
+		}
+		if ext != ".yaml" && ext != ".yml" {
+			return fmtTarget{}, false
+		}
+		abs, _ := filepath.Abs(path)
+		if explicit || valueFiles[abs] || strings.HasPrefix(filepath.Base(path), "values") {
+			return fmtTarget{path: path}, true
+		}
+		return fmtTarget{}, false
+	}
+
+	var files []fmtTarget
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			target, ok := classify(path, true)
+			if !ok {
+				return nil, fmt.Errorf("%s is neither a template nor a values file", path)
+			}
+			files = append(files, target)
+			continue
+		}
+
+		err = filepath.WalkDir(path, func(file string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.Type()&fs.ModeSymlink != 0 {
+				return nil
+			}
+			if d.IsDir() {
+				if file != path && strings.HasPrefix(d.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if target, ok := classify(file, false); ok {
+				files = append(files, target)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
+
+// fmtFile formats the file, or only checks it with --check, and reports whether it changed.
+func fmtFile(file fmtTarget) (bool, error) {
+	src, err := os.ReadFile(file.path)
+	if err != nil {
+		return false, err
+	}
+
+	var out []byte
+	if file.template {
+		out, err = format.Template(file.path, src)
+	} else {
+		out, err = format.Values(file.path, src)
+	}
+	if err != nil {
+		return false, err
+	}
+	if bytes.Equal(src, out) {
+		return false, nil
+	}
+
+	if fmtCmdFlags.diff {
+		diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        difflib.SplitLines(string(src)),
+			B:        difflib.SplitLines(string(out)),
+			FromFile: file.path,
+			ToFile:   file.path + " (formatted)",
+			Context:  3,
+		})
+		if err != nil {
+			return false, err
+		}
+		fmt.Fprint(os.Stderr, diff)
+	}
+
+	if fmtCmdFlags.check {
+		return true, nil
+	}
+
+	info, err := os.Stat(file.path)
+	if err != nil {
+		return false, err
+	}
+	return true, os.WriteFile(file.path, out, info.Mode().Perm())
+}
+
+func init() {
+	fmtCmd.Flags().BoolVar(&fmtCmdFlags.check, "check", false, "only list the files which need formatting and exit with code 2 if any")
+	fmtCmd.Flags().BoolVar(&fmtCmdFlags.diff, "diff", false, "print the changes of the files to stderr")
+
+	addCommand(fmtCmd)
+}
@@ -0,0 +1,105 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aenix-io/talm/pkg/engine"
+	"github.com/aenix-io/talm/pkg/graph"
+	"github.com/aenix-io/talm/pkg/modeline"
+	"github.com/spf13/cobra"
+)
+
+var graphCmdFlags struct {
+	configFiles []string
+	format      string
+}
+
+var graphCmd = &cobra.Command{
+	Use:   "graph",
+	Short: "Print a graph of charts, templates, values and node assignments",
+	Long: `Print a graph of the chart structure: charts, templates, helper includes,
+value sources and the nodes each template is assigned to by node files.
+
+Templates not used by any node file and helpers never included are drawn
+dashed. Node files default to nodes/*.yaml. The output is in Graphviz DOT
+or Mermaid format, e.g. render it with 'talm graph | dot -Tsvg > graph.svg'.`,
+	Args: cobra.NoArgs,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		switch graphCmdFlags.format {
+		case "dot", "mermaid":
+			return nil
+		default:
+			return fmt.Errorf("unknown format %q, valid formats are: dot, mermaid", graphCmdFlags.format)
+		}
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		chrt, err := engine.LoadChart(Config.RootDir, Config.TemplateOptions.Extends)
+		if err != nil {
+			return fmt.Errorf("failed to load chart: %w", err)
+		}
+
+		g := graph.New()
+		g.AddChart(chrt)
+
+		chartID := "chart:" + chrt.Name()
+		g.AddNode("values:chart", "values.yaml (chart defaults)", graph.KindValues)
+		g.AddEdge("values:chart", chartID, "values")
+		for _, valueFile := range Config.TemplateOptions.ValueFiles {
+			id := "values:" + valueFile
+			g.AddNode(id, valueFile, graph.KindValues)
+			g.AddEdge(id, chartID, "values")
+		}
+
+		files := graphCmdFlags.configFiles
+		if len(files) == 0 {
+			files, err = defaultNodeFiles()
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, file := range files {
+			modelineConfig, err := modeline.ReadAndParseModeline(file)
+			if err != nil {
+				return fmt.Errorf("modeline parsing failed for %s: %w", file, err)
+			}
+
+			id := "node:" + file
+			label := file
+			if len(modelineConfig.Nodes) > 0 {
+				label = fmt.Sprintf("%s\n%s", file, strings.Join(modelineConfig.Nodes, ", "))
+			}
+			g.AddNode(id, label, graph.KindNode)
+			for _, template := range modelineConfig.Templates {
+				templateID := graph.TemplateID(filepath.ToSlash(filepath.Clean(template)))
+				g.AddNode(templateID, template, graph.KindTemplate)
+				g.AddEdge(id, templateID, "")
+			}
+			if len(modelineConfig.Values) > 0 {
+				g.AddNode(id+":values", "modeline values", graph.KindValues)
+				g.AddEdge(id+":values", id, "values")
+			}
+		}
+
+		g.MarkUnused()
+
+		if graphCmdFlags.format == "mermaid" {
+			return g.WriteMermaid(os.Stdout)
+		}
+		return g.WriteDOT(os.Stdout)
+	},
+}
+
+func init() {
+	graphCmd.Flags().StringSliceVarP(&graphCmdFlags.configFiles, "file", "f", nil, "specify node files to show assignments for (defaults to nodes/*.yaml)")
+	graphCmd.Flags().StringVarP(&graphCmdFlags.format, "format", "o", "dot", "output format: dot or mermaid")
+
+	addCommand(graphCmd)
+}
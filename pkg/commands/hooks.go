@@ -0,0 +1,61 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"os"
+
+	"github.com/aenix-io/talm/pkg/hooks"
+	"gopkg.in/yaml.v3"
+
+	"github.com/siderolabs/talos/pkg/machinery/config"
+)
+
+// orderConfigFiles orders the node files by the machine types of hooks.order in Chart.yaml.
+// The machine type is read from the node file, files without it are processed last.
+func orderConfigFiles(files []string) []string {
+	if len(Config.Hooks.Order) == 0 {
+		return files
+	}
+
+	machineTypes := map[string]string{}
+	for _, file := range files {
+		machineTypes[file] = nodeFileMachineType(file)
+	}
+
+	return Config.Hooks.SortFiles(files, machineTypes)
+}
+
+// nodeFileMachineType reads the machine type from the node file without rendering it.
+func nodeFileMachineType(file string) string {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return ""
+	}
+
+	var nodeFile struct {
+		Machine struct {
+			Type string `yaml:"type"`
+		} `yaml:"machine"`
+	}
+	// Only the first document of the node file holds the machine config
+	if err := yaml.NewDecoder(bytes.NewReader(data)).Decode(&nodeFile); err != nil {
+		return ""
+	}
+	return nodeFile.Machine.Type
+}
+
+// runHooks runs the hooks of the stage for the node file rendered to cfg.
+func runHooks(ctx context.Context, stage, command, configFile string, cfg config.Provider) error {
+	return Config.Hooks.Run(ctx, stage, hooks.Request{
+		Command:     command,
+		File:        configFile,
+		Nodes:       GlobalArgs.Nodes,
+		MachineType: cfg.Machine().Type().String(),
+		Hostname:    cfg.Machine().Network().Hostname(),
+	}, os.Stderr)
+}
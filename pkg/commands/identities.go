@@ -0,0 +1,200 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/siderolabs/talos/pkg/machinery/config/generate/secrets"
+	"github.com/siderolabs/talos/pkg/machinery/role"
+)
+
+// identityRoles are the roles an identity can be configured for, by increasing privileges.
+var identityRoles = []role.Role{role.Reader, role.Operator, role.Admin}
+
+// knownRoles are the roles of the identities and os:etcd:backup, which only takes etcd snapshots.
+var knownRoles = []role.Role{role.Reader, role.EtcdBackup, role.Operator, role.Admin}
+
+// grantingRoles returns the roles allowed to run the commands requiring the role, by increasing
+// privileges. The operator and admin roles can take etcd snapshots too.
+func grantingRoles(required role.Role) []role.Role {
+	if required == role.EtcdBackup {
+		return []role.Role{role.EtcdBackup, role.Operator, role.Admin}
+	}
+	return identityRoles[slices.Index(identityRoles, required):]
+}
+
+// commandRoles are the roles required by the commands changing the nodes, the other commands
+// only read from the nodes. Subcommands require the role of their parent command unless listed.
+var commandRoles = map[string]role.Role{
+	"apply":                   role.Admin,
+	"backup":                  role.EtcdBackup,
+	"bootstrap":               role.Admin,
+	"confirm":                 role.Admin,
+	"disks wipe":              role.Admin,
+	"kubeconfig":              role.Admin,
+	"migrate-disk":            role.Admin,
+	"reset":                   role.Admin,
+	"release rollback":        role.Admin,
+	"rollback":                role.Admin,
+	"upgrade":                 role.Admin,
+	"etcd snapshot":           role.EtcdBackup,
+	"etcd alarm disarm":       role.Operator,
+	"etcd defrag":             role.Operator,
+	"etcd forfeit-leadership": role.Operator,
+	"etcd leave":              role.Operator,
+	"etcd remove-member":      role.Operator,
+	"image pull":              role.Operator,
+	"pcap":                    role.Operator,
+	"reboot":                  role.Operator,
+	"restart":                 role.Operator,
+	"shutdown":                role.Operator,
+}
+
+// commandActionRoles are the roles required by the commands changing the nodes depending on
+// their action, the last argument, e.g. talm service kubelet restart.
+var commandActionRoles = map[string]map[string]role.Role{
+	"service": {"start": role.Operator, "stop": role.Operator, "restart": role.Operator},
+}
+
+// commandFlagRoles are the roles required by the commands only reading from the nodes unless
+// one of the flags is set.
+var commandFlagRoles = map[string]map[string]role.Role{
+	"nodes add": {"apply": role.Admin},
+	"prune":     {"reset": role.Admin},
+}
+
+// commandRole returns the role required by the command, globalOptions.commandRoles of
+// Chart.yaml override the defaults.
+func commandRole(cmd *cobra.Command) (role.Role, error) {
+	for c := cmd; c != nil && c.HasParent(); c = c.Parent() {
+		path := strings.TrimPrefix(c.CommandPath(), c.Root().Name()+" ")
+		if r, ok := Config.GlobalOptions.CommandRoles[path]; ok {
+			if !slices.Contains(knownRoles, role.Role(r)) {
+				return "", fmt.Errorf("globalOptions.commandRoles.%s: unknown role %q, use %s", path, r, knownRoles)
+			}
+			return role.Role(r), nil
+		}
+		if r, ok := commandRoles[path]; ok {
+			return r, nil
+		}
+		if args := c.Flags().Args(); len(args) > 1 {
+			if r, ok := commandActionRoles[path][args[len(args)-1]]; ok {
+				return r, nil
+			}
+		}
+		for name, r := range commandFlagRoles[path] {
+			if f := c.Flags().Lookup(name); f != nil && f.Value.String() == "true" {
+				return r, nil
+			}
+		}
+	}
+	return role.Reader, nil
+}
+
+// SelectIdentity selects the talosconfig context of the identity with the least privileges
+// allowed to run the command, from the identities of globalOptions.identities in Chart.yaml.
+// An explicit --context is kept.
+func SelectIdentity(cmd *cobra.Command) error {
+	if len(Config.GlobalOptions.Identities) == 0 {
+		return nil
+	}
+	for r := range Config.GlobalOptions.Identities {
+		if !slices.Contains(knownRoles, role.Role(r)) {
+			return fmt.Errorf("globalOptions.identities: unknown role %q, use %s", r, knownRoles)
+		}
+	}
+	if GlobalArgs.CmdContext != "" {
+		return nil
+	}
+
+	required, err := commandRole(cmd)
+	if err != nil {
+		return err
+	}
+	for _, r := range grantingRoles(required) {
+		if contextName, ok := Config.GlobalOptions.Identities[string(r)]; ok {
+			GlobalArgs.CmdContext = contextName
+			return nil
+		}
+	}
+
+	// The current context of the talosconfig is used
+	return nil
+}
+
+var talosconfigAddIdentityCmdFlags struct {
+	role string
+}
+
+var talosconfigAddIdentityCmd = &cobra.Command{
+	Use:   "add-identity <context>",
+	Short: "Add a talosconfig context with a client certificate for a role",
+	Long: `Issue a client certificate with the role from the secrets bundle and add it as a new context of
+the talosconfig, with the endpoints and nodes of the current context. List the context in
+globalOptions.identities of Chart.yaml to use it for the commands allowed to the role:
+
+  globalOptions:
+    identities:
+      os:reader: mycluster-reader
+      os:admin: mycluster`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		contextName := args[0]
+		r := role.Role(talosconfigAddIdentityCmdFlags.role)
+		if !slices.Contains(knownRoles, r) {
+			return fmt.Errorf("unknown role %q, use %s", r, knownRoles)
+		}
+		if Config.TemplateOptions.WithSecrets == "" {
+			return errors.New("secrets bundle is not set: please set templateOptions.withSecrets in Chart.yaml")
+		}
+
+		cfg, err := openTalosconfig(GlobalArgs.Talosconfig)
+		if err != nil {
+			return err
+		}
+		if _, ok := cfg.Contexts[contextName]; ok {
+			return fmt.Errorf("talosconfig context %q already exists", contextName)
+		}
+		current, ok := cfg.Contexts[cfg.Context]
+		if !ok {
+			return fmt.Errorf("talosconfig context %q not found", cfg.Context)
+		}
+
+		bundle, err := secrets.LoadBundle(Config.TemplateOptions.WithSecrets)
+		if err != nil {
+			return fmt.Errorf("failed to load secrets bundle: %w", err)
+		}
+		clientCert, err := bundle.GenerateTalosAPIClientCertificate(role.MakeSet(r))
+		if err != nil {
+			return fmt.Errorf("failed to generate client certificate: %w", err)
+		}
+
+		identity := *current
+		identity.Crt = base64.StdEncoding.EncodeToString(clientCert.Crt)
+		identity.Key = base64.StdEncoding.EncodeToString(clientCert.Key)
+		cfg.Contexts[contextName] = &identity
+
+		if err := saveTalosconfig(cfg, GlobalArgs.Talosconfig); err != nil {
+			return fmt.Errorf("failed to save talosconfig: %w", err)
+		}
+
+		fmt.Fprintf(os.Stderr, "Added context %q with role %s to %s\n", contextName, r, GlobalArgs.Talosconfig)
+		return nil
+	},
+}
+
+func init() {
+	talosconfigAddIdentityCmd.Flags().StringVar(&talosconfigAddIdentityCmdFlags.role, "role", string(role.Reader), fmt.Sprintf("role of the client certificate (%s)", knownRoles))
+
+	talosconfigCmd.AddCommand(talosconfigAddIdentityCmd)
+}
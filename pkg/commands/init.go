@@ -11,6 +11,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/aenix-io/talm/pkg/fileutil"
 	"github.com/aenix-io/talm/pkg/generated"
 	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v3"
@@ -25,6 +26,8 @@ var initCmdFlags struct {
 	force        bool
 	preset       string
 	talosVersion string
+	migrateFrom  string
+	vars         []string
 }
 
 // initCmd represents the `init` command.
@@ -53,11 +56,25 @@ var initCmd = &cobra.Command{
 			}
 		}
 
-		secretsBundle, err = secrets.NewBundle(secrets.NewFixedClock(time.Now()),
-			versionContract,
-		)
+		vars, err := parseInitVars(initCmdFlags.vars)
 		if err != nil {
-			return fmt.Errorf("failed to create secrets bundle: %w", err)
+			return err
+		}
+
+		var migration *talosctlMigration
+		if initCmdFlags.migrateFrom != "" {
+			migration, err = loadTalosctlMigration(initCmdFlags.migrateFrom, versionContract)
+			if err != nil {
+				return err
+			}
+			secretsBundle = migration.secrets
+		} else {
+			secretsBundle, err = secrets.NewBundle(secrets.NewFixedClock(time.Now()),
+				versionContract,
+			)
+			if err != nil {
+				return fmt.Errorf("failed to create secrets bundle: %w", err)
+			}
 		}
 		var genOptions []generate.Option //nolint:prealloc
 		if !isValidPreset(initCmdFlags.preset) {
@@ -86,9 +103,13 @@ var initCmd = &cobra.Command{
 			return err
 		}
 		clusterName := filepath.Base(absolutePath)
+		if migration != nil {
+			clusterName = migration.clusterName
+		}
 
 		configBundle, err := gen.GenerateConfigBundle(genOptions, clusterName, "https://192.168.0.1:6443", "", []string{}, []string{}, []string{})
-		configBundle.TalosConfig().Contexts[clusterName].Endpoints = []string{"127.0.0.1"}
+		// Endpoints are resolved from the values until `talm config sync-endpoints` sets them
+		configBundle.TalosConfig().Contexts[clusterName].Endpoints = nil
 		if err != nil {
 			return err
 		}
@@ -98,6 +119,10 @@ var initCmd = &cobra.Command{
 			return fmt.Errorf("failed to marshal config: %+v", err)
 		}
 
+		if migration != nil && migration.talosconfig != nil {
+			data = migration.talosconfig
+		}
+
 		talosconfigFile := filepath.Join(Config.RootDir, "talosconfig")
 		if err = writeToDestination(data, talosconfigFile, 0o644); err != nil {
 			return err
@@ -109,9 +134,20 @@ var initCmd = &cobra.Command{
 			// Write preset files
 			if chartName == initCmdFlags.preset {
 				file := filepath.Join(Config.RootDir, filepath.Join(parts[1:]...))
-				if parts[len(parts)-1] == "Chart.yaml" {
-					writeToDestination([]byte(fmt.Sprintf(content, clusterName, Config.InitOptions.Version)), file, 0o644)
-				} else {
+				switch {
+				case parts[len(parts)-1] == "Chart.yaml":
+					var chart []byte
+					chart, err = renderInitTemplate(path, []byte(fmt.Sprintf(content, clusterName, Config.InitOptions.Version)), vars)
+					if err == nil {
+						writeToDestination(chart, file, 0o644)
+					}
+				case parts[len(parts)-1] == "values.yaml":
+					var values []byte
+					values, err = scaffoldValues(path, []byte(content), vars, migration)
+					if err == nil {
+						err = writeToDestination(values, file, 0o644)
+					}
+				default:
 					err = writeToDestination([]byte(content), file, 0o644)
 				}
 				if err != nil {
@@ -132,11 +168,42 @@ var initCmd = &cobra.Command{
 			}
 		}
 
-		return nil
+		if migration != nil {
+			for path, content := range migration.templates() {
+				if err = writeToDestination(content, filepath.Join(Config.RootDir, path), 0o644); err != nil {
+					return err
+				}
+			}
+			fmt.Fprintf(os.Stderr, "Imported cluster %s from %s, review values.yaml (e.g. advertisedSubnets) and templates/migrated-*.yaml\n", clusterName, initCmdFlags.migrateFrom)
+		}
 
+		printNotes("init")
+		return nil
 	},
 }
 
+// scaffoldValues renders the values.yaml of the preset with the variables: placeholders are
+// substituted and variables named after top-level keys replace their values, also the ones
+// imported by the migration.
+func scaffoldValues(path string, content []byte, vars map[string]string, migration *talosctlMigration) ([]byte, error) {
+	content, err := renderInitTemplate(path, content, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	if migration != nil {
+		if content, err = migration.valuesFile(content); err != nil {
+			return nil, err
+		}
+	}
+
+	values, err := varValues(content, vars)
+	if err != nil {
+		return nil, err
+	}
+	return setTopLevelValues(content, values, false)
+}
+
 func writeSecretsBundleToFile(bundle *secrets.Bundle) error {
 	bundleBytes, err := yaml.Marshal(bundle)
 	if err != nil {
@@ -155,6 +222,8 @@ func init() {
 	initCmd.Flags().StringVar(&initCmdFlags.talosVersion, "talos-version", "", "the desired Talos version to generate config for (backwards compatibility, e.g. v0.8)")
 	initCmd.Flags().StringVarP(&initCmdFlags.preset, "preset", "p", "generic", "specify preset to generate files")
 	initCmd.Flags().BoolVar(&initCmdFlags.force, "force", false, "will overwrite existing files")
+	initCmd.Flags().StringArrayVar(&initCmdFlags.vars, "var", nil, "set a variable of the project template (can specify multiple: --var region=eu --var endpoint=https://10.0.0.1:6443)")
+	initCmd.Flags().StringVar(&initCmdFlags.migrateFrom, "migrate-from-talosctl", "", "import secrets, talosconfig, values and patches from the output directory of 'talosctl gen config'")
 
 	addCommand(initCmd)
 }
@@ -183,6 +252,11 @@ func writeToDestination(data []byte, destination string, permissions os.FileMode
 		return err
 	}
 
+	return createFile(data, destination, permissions)
+}
+
+// createFile writes the file, creating its parent directories.
+func createFile(data []byte, destination string, permissions os.FileMode) error {
 	parentDir := filepath.Dir(destination)
 
 	// Create dir path, ignoring "already exists" messages
@@ -190,7 +264,7 @@ func writeToDestination(data []byte, destination string, permissions os.FileMode
 		return fmt.Errorf("failed to create output dir: %w", err)
 	}
 
-	err := os.WriteFile(destination, data, permissions)
+	err := fileutil.WriteFile(destination, data, permissions)
 
 	fmt.Fprintf(os.Stderr, "Created %s\n", destination)
 
@@ -0,0 +1,174 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aenix-io/talm/pkg/yamltools"
+	"gopkg.in/yaml.v3"
+
+	"github.com/siderolabs/talos/pkg/machinery/config"
+	"github.com/siderolabs/talos/pkg/machinery/config/configloader"
+	"github.com/siderolabs/talos/pkg/machinery/config/encoder"
+	"github.com/siderolabs/talos/pkg/machinery/config/generate"
+	"github.com/siderolabs/talos/pkg/machinery/config/generate/secrets"
+	"github.com/siderolabs/talos/pkg/machinery/config/machine"
+)
+
+// migratedValuePaths are the config fields rendered by the presets from values.yaml,
+// they are dropped from the migrated patches to not be merged twice.
+var migratedValuePaths = [][]string{
+	{"cluster", "clusterName"},
+	{"cluster", "controlPlane", "endpoint"},
+	{"cluster", "network", "podSubnets"},
+	{"cluster", "network", "serviceSubnets"},
+	{"cluster", "etcd", "advertisedSubnets"},
+	{"machine", "kubelet", "nodeIP", "validSubnets"},
+}
+
+// talosctlMigration holds the project imported from the output of `talosctl gen config`.
+type talosctlMigration struct {
+	clusterName string
+	endpoint    string
+	secrets     *secrets.Bundle
+	talosconfig []byte
+	// patches are the customizations of the generated configs by machine type
+	patches map[string][]byte
+	values  map[string]interface{}
+}
+
+// loadTalosctlMigration reads controlplane.yaml, worker.yaml and talosconfig from dir.
+// The user's patches are the difference to the configs talosctl generates by default
+// with the same secrets, cluster name and endpoint.
+func loadTalosctlMigration(dir string, versionContract *config.VersionContract) (*talosctlMigration, error) {
+	controlplane, err := configloader.NewFromFile(filepath.Join(dir, "controlplane.yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load controlplane.yaml of talosctl gen config: %w", err)
+	}
+
+	m := &talosctlMigration{
+		clusterName: controlplane.Cluster().Name(),
+		endpoint:    controlplane.Cluster().Endpoint().String(),
+		secrets:     secrets.NewBundleFromConfig(secrets.NewFixedClock(time.Now()), controlplane),
+		patches:     map[string][]byte{},
+		values:      map[string]interface{}{},
+	}
+
+	m.values["endpoint"] = m.endpoint
+	if subnets := controlplane.Cluster().Network().PodCIDRs(); len(subnets) > 0 {
+		m.values["podSubnets"] = subnets
+	}
+	if subnets := controlplane.Cluster().Network().ServiceCIDRs(); len(subnets) > 0 {
+		m.values["serviceSubnets"] = subnets
+	}
+	if subnets := controlplane.Cluster().Etcd().AdvertisedSubnets(); len(subnets) > 0 {
+		m.values["advertisedSubnets"] = subnets
+	}
+
+	m.talosconfig, err = os.ReadFile(filepath.Join(dir, "talosconfig"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	kubernetesVersion := controlplane.Cluster().APIServer().Image()
+	kubernetesVersion = strings.TrimPrefix(kubernetesVersion[strings.LastIndex(kubernetesVersion, ":")+1:], "v")
+
+	genOptions := []generate.Option{generate.WithSecretsBundle(m.secrets)}
+	if versionContract != nil {
+		genOptions = append(genOptions, generate.WithVersionContract(versionContract))
+	}
+
+	input, err := generate.NewInput(m.clusterName, m.endpoint, kubernetesVersion, genOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, machineType := range []machine.Type{machine.TypeControlPlane, machine.TypeWorker} {
+		file := filepath.Join(dir, machineType.String()+".yaml")
+		current, err := os.ReadFile(file)
+		if os.IsNotExist(err) && machineType == machine.TypeWorker {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		current, err = normalizedConfig(current)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load %s: %w", file, err)
+		}
+
+		generated, err := input.Config(machineType)
+		if err != nil {
+			return nil, err
+		}
+		origin, err := generated.EncodeBytes(encoder.WithComments(encoder.CommentsDisabled))
+		if err != nil {
+			return nil, err
+		}
+
+		patch, err := yamltools.DiffYAMLs(origin, current)
+		if err != nil {
+			return nil, err
+		}
+
+		var node yaml.Node
+		if err := yaml.Unmarshal(patch, &node); err != nil {
+			return nil, err
+		}
+		for _, path := range migratedValuePaths {
+			yamltools.DeletePath(&node, path...)
+		}
+
+		if node.Kind == 0 || len(node.Content) == 0 || len(node.Content[0].Content) == 0 {
+			continue
+		}
+
+		patch, err = encodeYAML(&node)
+		if err != nil {
+			return nil, err
+		}
+		m.patches[machineType.String()] = patch
+	}
+
+	return m, nil
+}
+
+// valuesFile sets the values taken from the configs in the values.yaml of the preset.
+func (m *talosctlMigration) valuesFile(content []byte) ([]byte, error) {
+	return setTopLevelValues(content, m.values, true)
+}
+
+// templates returns the user's patches as templates, to be listed in the modelines
+// after the template of the preset.
+func (m *talosctlMigration) templates() map[string][]byte {
+	templates := map[string][]byte{}
+	for machineType, patch := range m.patches {
+		// Escape template actions in the patch, it is rendered as a template
+		content := strings.ReplaceAll(string(patch), "{{", `{{ "{{" }}`)
+		header := fmt.Sprintf("# Customizations of %s.yaml imported from talosctl gen config,\n# render them after templates/%s.yaml:\n#   talm template -t templates/%s.yaml -t templates/migrated-%s.yaml\n",
+			machineType, machineType, machineType, machineType)
+		templates[filepath.Join("templates", "migrated-"+machineType+".yaml")] = []byte(header + content)
+	}
+	return templates
+}
+
+func encodeYAML(node *yaml.Node) ([]byte, error) {
+	var buf strings.Builder
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(node); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return []byte(buf.String()), nil
+}
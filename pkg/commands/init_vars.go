@@ -0,0 +1,134 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/Masterminds/sprig/v3"
+	"gopkg.in/yaml.v3"
+)
+
+// rawValue is a value written to values.yaml as is, e.g. a number, a boolean or a flow list.
+type rawValue string
+
+// parseInitVars parses the key=value pairs of --var.
+func parseInitVars(pairs []string) (map[string]string, error) {
+	vars := map[string]string{}
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid variable %q, must be key=value", pair)
+		}
+		vars[key] = value
+	}
+	return vars, nil
+}
+
+// renderInitTemplate substitutes the variables into a scaffolded file of the preset.
+// Placeholders use [[ ]] delimiters to not clash with the templates of the chart,
+// e.g. [[ .region ]] or [[ .region | default "eu" ]], unset variables are empty.
+func renderInitTemplate(name string, content []byte, vars map[string]string) ([]byte, error) {
+	if !bytes.Contains(content, []byte("[[")) {
+		return content, nil
+	}
+
+	tmpl, err := texttemplate.New(name).
+		Delims("[[", "]]").
+		Funcs(sprig.TxtFuncMap()).
+		Option("missingkey=zero").
+		Parse(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", name, err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, vars); err != nil {
+		return nil, fmt.Errorf("failed to render %s: %w", name, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// varValues returns the variables overriding top-level keys of values.yaml.
+// Strings are quoted, other YAML values like numbers, booleans and flow lists are kept as is.
+func varValues(content []byte, vars map[string]string) (map[string]interface{}, error) {
+	var existing map[string]interface{}
+	if err := yaml.Unmarshal(content, &existing); err != nil {
+		return nil, fmt.Errorf("failed to parse values.yaml: %w", err)
+	}
+
+	values := map[string]interface{}{}
+	for key, value := range vars {
+		if _, ok := existing[key]; !ok {
+			continue
+		}
+		var parsed interface{}
+		if err := yaml.Unmarshal([]byte(value), &parsed); err == nil {
+			if _, ok := parsed.(string); !ok && parsed != nil {
+				values[key] = rawValue(value)
+				continue
+			}
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+// setTopLevelValues sets top-level keys of a values file.
+// Keys are replaced line by line to keep the layout and comments of the preset,
+// missing keys are prepended only if addMissing is set.
+func setTopLevelValues(content []byte, values map[string]interface{}, addMissing bool) ([]byte, error) {
+	lines := strings.Split(string(content), "\n")
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		var value []string
+		switch v := values[key].(type) {
+		case string:
+			value = []string{fmt.Sprintf("%s: %q", key, v)}
+		case rawValue:
+			value = []string{fmt.Sprintf("%s: %s", key, v)}
+		case []string:
+			value = []string{key + ":"}
+			for _, item := range v {
+				value = append(value, "- "+item)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported value %s", key)
+		}
+
+		start := -1
+		for i, line := range lines {
+			if strings.HasPrefix(line, key+":") {
+				start = i
+				break
+			}
+		}
+		if start < 0 {
+			if addMissing {
+				lines = append(value, lines...)
+			}
+			continue
+		}
+
+		// The block of the key ends with the next top-level key or comment
+		end := start + 1
+		for end < len(lines) && (strings.HasPrefix(lines[end], " ") || strings.HasPrefix(lines[end], "- ")) {
+			end++
+		}
+		lines = append(lines[:start], append(value, lines[end:]...)...)
+	}
+
+	return []byte(strings.Join(lines, "\n")), nil
+}
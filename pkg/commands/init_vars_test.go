@@ -0,0 +1,114 @@
+package commands
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseInitVars(t *testing.T) {
+	vars, err := parseInitVars([]string{"region=eu", "endpoint=https://10.0.0.1:6443", "empty=", "region=us"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"region": "us", "endpoint": "https://10.0.0.1:6443", "empty": ""}
+	if !reflect.DeepEqual(vars, expected) {
+		t.Errorf("expected %v, got %v", expected, vars)
+	}
+
+	for _, pair := range []string{"region", "=eu"} {
+		if _, err := parseInitVars([]string{pair}); err == nil {
+			t.Errorf("%s: expected an error", pair)
+		}
+	}
+}
+
+func TestRenderInitTemplate(t *testing.T) {
+	vars := map[string]string{"region": "eu"}
+	for _, tt := range []struct {
+		content string
+		expect  string
+		err     string
+	}{
+		{content: `region: [[ .region ]]`, expect: `region: eu`},
+		{content: `tier: [[ .tier | default "dev" ]]`, expect: `tier: dev`},
+		{content: `tier: "[[ .tier ]]"`, expect: `tier: ""`},
+		// The templates of the chart are kept
+		{content: `name: {{ .Chart.Name }}-[[ .region ]]`, expect: `name: {{ .Chart.Name }}-eu`},
+		{content: `name: {{ .Chart.Name }}`, expect: `name: {{ .Chart.Name }}`},
+		{content: `region: [[ .region `, err: "failed to parse values.yaml"},
+		{content: `region: [[ fail "region is required" ]]`, err: "region is required"},
+	} {
+		out, err := renderInitTemplate("values.yaml", []byte(tt.content), vars)
+		switch {
+		case tt.err != "":
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: expected error %q, got %v", tt.content, tt.err, err)
+			}
+		case err != nil:
+			t.Errorf("%s: %v", tt.content, err)
+		case string(out) != tt.expect:
+			t.Errorf("%s: expected %q, got %q", tt.content, tt.expect, out)
+		}
+	}
+}
+
+func TestSetInitVarValues(t *testing.T) {
+	content := []byte(`# Cluster endpoint
+endpoint: "https://192.168.100.10:6443"
+clusterDomain: cozy.local
+floatingIP: 192.168.100.10
+# Subnets of the pods
+podSubnets:
+- 10.244.0.0/16
+serviceSubnets:
+- 10.96.0.0/16
+replicas: 3
+`)
+	vars := map[string]string{
+		"endpoint":   "https://10.0.0.1:6443",
+		"podSubnets": "[10.0.0.0/16, fd00::/64]",
+		"replicas":   "5",
+		"region":     "eu",
+	}
+
+	values, err := varValues(content, vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Variables which are not values of the preset are only substituted into the templates
+	expected := map[string]interface{}{
+		"endpoint":   "https://10.0.0.1:6443",
+		"podSubnets": rawValue("[10.0.0.0/16, fd00::/64]"),
+		"replicas":   rawValue("5"),
+	}
+	if !reflect.DeepEqual(values, expected) {
+		t.Fatalf("expected %v, got %v", expected, values)
+	}
+
+	out, err := setTopLevelValues(content, values, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `# Cluster endpoint
+endpoint: "https://10.0.0.1:6443"
+clusterDomain: cozy.local
+floatingIP: 192.168.100.10
+# Subnets of the pods
+podSubnets: [10.0.0.0/16, fd00::/64]
+serviceSubnets:
+- 10.96.0.0/16
+replicas: 5
+`
+	if string(out) != expect {
+		t.Errorf("expected:\n%s\ngot:\n%s", expect, out)
+	}
+
+	out, err = setTopLevelValues([]byte("endpoint: \"\"\n"), map[string]interface{}{"nodes": []string{"10.0.0.1", "10.0.0.2"}}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := "nodes:\n- 10.0.0.1\n- 10.0.0.2\nendpoint: \"\"\n"; string(out) != expect {
+		t.Errorf("expected %q, got %q", expect, out)
+	}
+}
@@ -0,0 +1,119 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aenix-io/talm/pkg/bmc"
+	"github.com/aenix-io/talm/pkg/engine"
+	"github.com/aenix-io/talm/pkg/fileutil"
+	"github.com/aenix-io/talm/pkg/window"
+	"gopkg.in/yaml.v3"
+)
+
+// knownNodesFile is the path of the inventory of the nodes relative to the project root or the workspace.
+// It holds the pinned certificate fingerprints, so it is readable by the owner only.
+const knownNodesFile = ".talm/nodes.yaml"
+
+// knownNode is the server certificate of a node in maintenance mode learned on first contact,
+// the BMC controlling the power of the node, its maintenance windows and its failure domain.
+type knownNode struct {
+	CertFingerprint string      `yaml:"certFingerprint,omitempty"`
+	Learned         time.Time   `yaml:"learned,omitempty"`
+	BMC             *bmc.Config `yaml:"bmc,omitempty"`
+	// MaintenanceWindows override the maintenance windows of Chart.yaml for the node
+	MaintenanceWindows []window.Window `yaml:"maintenanceWindows,omitempty"`
+	// Topology is exposed to the templates as .Node.Topology
+	Topology *engine.Topology `yaml:"topology,omitempty"`
+}
+
+// knownNodes maps node addresses to what is known about them.
+type knownNodes map[string]knownNode
+
+func loadKnownNodes() (knownNodes, error) {
+	nodes := knownNodes{}
+
+	data, err := os.ReadFile(filepath.Join(stateDir(), knownNodesFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nodes, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading known nodes: %w", err)
+	}
+
+	if err := yaml.Unmarshal(data, &nodes); err != nil {
+		return nil, fmt.Errorf("error parsing known nodes: %w", err)
+	}
+
+	return nodes, nil
+}
+
+// nodeTopology returns the failure domain of the nodes rendered together, the nodes of a node
+// file must share it.
+func nodeTopology(nodes []string) (engine.Topology, error) {
+	known, err := loadKnownNodes()
+	if err != nil {
+		return engine.Topology{}, err
+	}
+
+	var topology *engine.Topology
+	for _, node := range nodes {
+		t := known[node].Topology
+		if t == nil {
+			t = &engine.Topology{}
+		}
+		if topology != nil && *t != *topology {
+			return engine.Topology{}, fmt.Errorf("nodes %s are in different failure domains, render them in separate node files", nodes)
+		}
+		topology = t
+	}
+	if topology == nil {
+		return engine.Topology{}, nil
+	}
+	return *topology, nil
+}
+
+// editKnownNodes edits .talm/nodes.yaml as a YAML tree rather than as known nodes, so the
+// comments and the order of the entries written by hand are kept.
+func editKnownNodes(edit func(root *yaml.Node) error) error {
+	file := filepath.Join(stateDir(), knownNodesFile)
+
+	doc := &yaml.Node{}
+	data, err := os.ReadFile(file)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("error reading known nodes: %w", err)
+	}
+	if err := yaml.Unmarshal(data, doc); err != nil {
+		return fmt.Errorf("error parsing known nodes: %w", err)
+	}
+	if len(doc.Content) == 0 {
+		doc = &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	if doc.Content[0].Kind != yaml.MappingNode {
+		return fmt.Errorf("error parsing known nodes: %s is not a mapping of node addresses", file)
+	}
+
+	if err := edit(doc.Content[0]); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	return fileutil.WriteFile(file, buf.Bytes(), 0o600)
+}
@@ -0,0 +1,306 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	criconstants "github.com/containerd/containerd/pkg/cri/constants"
+	"github.com/spf13/cobra"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"github.com/siderolabs/talos/cmd/talosctl/pkg/talos/helpers"
+	"github.com/siderolabs/talos/pkg/machinery/api/common"
+	"github.com/siderolabs/talos/pkg/machinery/api/machine"
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	"github.com/siderolabs/talos/pkg/machinery/constants"
+)
+
+// The generated logs and events commands are extended here to aggregate several services
+// of all nodes of the node files, filter them and print JSON lines for log pipelines.
+var logsAggregateFlags struct {
+	since  time.Duration
+	output string
+}
+
+var eventsAggregateFlags struct {
+	services []string
+	output   string
+}
+
+// logLine is a line of a service log in JSON output.
+type logLine struct {
+	Node    string     `json:"node"`
+	Service string     `json:"service"`
+	Time    *time.Time `json:"time,omitempty"`
+	Message string     `json:"message"`
+}
+
+// eventLine is a Talos event in JSON output.
+type eventLine struct {
+	Node    string          `json:"node"`
+	ID      string          `json:"id"`
+	Type    string          `json:"type"`
+	ActorID string          `json:"actorId,omitempty"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// streamLogs streams the logs of the services concurrently, prefixing every line with the node and the service.
+func streamLogs(cmd *cobra.Command, args []string) error {
+	if err := checkOutputFormat(logsAggregateFlags.output); err != nil {
+		return err
+	}
+
+	return WithClient(func(ctx context.Context, c *client.Client) error {
+		namespace := constants.SystemContainerdNamespace
+		driver := common.ContainerDriver_CONTAINERD
+		if kubernetesFlag {
+			namespace = criconstants.K8sContainerdNamespace
+			driver = common.ContainerDriver_CRI
+		}
+
+		lines := tailLines
+		var since time.Time
+		if logsAggregateFlags.since > 0 {
+			// Logs have no server-side time filter, read them all and filter by the timestamps of the lines
+			lines = -1
+			since = time.Now().Add(-logsAggregateFlags.since)
+		}
+
+		var (
+			mu        sync.Mutex
+			gotErrors bool
+		)
+
+		eg, ctx := errgroup.WithContext(ctx)
+		for _, service := range args {
+			service := service
+			eg.Go(func() error {
+				stream, err := c.Logs(ctx, namespace, driver, service, follow, lines)
+				if err != nil {
+					return fmt.Errorf("error fetching logs of %s: %s", service, err)
+				}
+
+				defaultNode := client.RemotePeer(stream.Context())
+				respCh, errCh := newLineSlicer(stream)
+
+				// Lines without a timestamp belong to the last line with one
+				lastSeen := map[string]time.Time{}
+
+				for data := range respCh {
+					if data.Metadata != nil && data.Metadata.Error != "" {
+						mu.Lock()
+						fmt.Fprintf(os.Stderr, "ERROR: %s: %s\n", service, data.Metadata.Error)
+						gotErrors = true
+						mu.Unlock()
+						continue
+					}
+
+					node := defaultNode
+					if data.Metadata != nil && data.Metadata.Hostname != "" {
+						node = data.Metadata.Hostname
+					}
+
+					ts, ok := logTimestamp(data.Bytes)
+					if ok {
+						lastSeen[node] = ts
+					} else {
+						ts = lastSeen[node]
+					}
+					if !since.IsZero() && ts.Before(since) {
+						continue
+					}
+
+					mu.Lock()
+					err := printLogLine(node, service, ts, data.Bytes)
+					mu.Unlock()
+					if err != nil {
+						return err
+					}
+				}
+
+				if err := <-errCh; err != nil {
+					return fmt.Errorf("error getting logs of %s: %v", service, err)
+				}
+				return nil
+			})
+		}
+
+		if err := eg.Wait(); err != nil {
+			return err
+		}
+		if gotErrors {
+			return &ExitError{Code: 1, Err: errors.New("some nodes failed to return logs")}
+		}
+		return nil
+	})
+}
+
+func printLogLine(node, service string, ts time.Time, message []byte) error {
+	if logsAggregateFlags.output == "json" {
+		line := logLine{Node: node, Service: service, Message: string(message)}
+		if !ts.IsZero() {
+			line.Time = &ts
+		}
+		return json.NewEncoder(os.Stdout).Encode(line)
+	}
+
+	_, err := fmt.Printf("%s %s: %s\n", node, service, message)
+	return err
+}
+
+// logTimestamp finds the time of a log line: an RFC 3339 prefix, or the "ts", "time"
+// or "timestamp" field of a JSON line (RFC 3339 or seconds since the epoch).
+func logTimestamp(line []byte) (time.Time, bool) {
+	line = bytes.TrimSpace(line)
+
+	if bytes.HasPrefix(line, []byte("{")) {
+		var fields map[string]interface{}
+		if err := json.Unmarshal(line, &fields); err != nil {
+			return time.Time{}, false
+		}
+		for _, key := range []string{"ts", "time", "timestamp"} {
+			switch v := fields[key].(type) {
+			case string:
+				if ts, err := time.Parse(time.RFC3339Nano, v); err == nil {
+					return ts, true
+				}
+			case float64:
+				sec := int64(v)
+				return time.Unix(sec, int64((v-float64(sec))*1e9)), true
+			}
+		}
+		return time.Time{}, false
+	}
+
+	prefix := line
+	if i := bytes.IndexByte(line, ' '); i > 0 {
+		prefix = line[:i]
+	}
+	if ts, err := time.Parse(time.RFC3339Nano, string(prefix)); err == nil {
+		return ts, true
+	}
+	if sec, err := strconv.ParseFloat(string(prefix), 64); err == nil && sec > 1e9 {
+		return time.Unix(int64(sec), 0), true
+	}
+	return time.Time{}, false
+}
+
+// streamEvents streams the events of all nodes filtered by the services as JSON lines or a table.
+func streamEvents(cmd *cobra.Command, args []string) error {
+	if err := checkOutputFormat(eventsAggregateFlags.output); err != nil {
+		return err
+	}
+
+	services := map[string]bool{}
+	for _, service := range eventsAggregateFlags.services {
+		services[service] = true
+	}
+
+	return WithClient(func(ctx context.Context, c *client.Client) error {
+		opts := []client.EventsOptionFunc{}
+		if eventsCmdFlags.tailEvents != 0 {
+			opts = append(opts, client.WithTailEvents(eventsCmdFlags.tailEvents))
+		}
+		if eventsCmdFlags.tailDuration != 0 {
+			opts = append(opts, client.WithTailDuration(eventsCmdFlags.tailDuration))
+		}
+		if eventsCmdFlags.tailID != "" {
+			opts = append(opts, client.WithTailID(eventsCmdFlags.tailID))
+		}
+		if eventsCmdFlags.actorID != "" {
+			opts = append(opts, client.WithActorID(eventsCmdFlags.actorID))
+		}
+
+		events, err := c.Events(ctx, opts...)
+		if err != nil {
+			return err
+		}
+
+		return helpers.ReadGRPCStream(events, func(ev *machine.Event, node string, multipleNodes bool) error {
+			event, err := client.UnmarshalEvent(ev)
+			if err != nil {
+				if errors.Is(err, client.ErrEventNotSupported) {
+					return nil
+				}
+				return err
+			}
+			if event.Node == "" {
+				event.Node = node
+			}
+
+			// With a service filter only the state changes of the services are shown
+			if len(services) > 0 {
+				msg, ok := event.Payload.(*machine.ServiceStateEvent)
+				if !ok || !services[msg.GetService()] {
+					return nil
+				}
+			}
+
+			if eventsAggregateFlags.output == "json" {
+				payload, err := protojson.Marshal(event.Payload)
+				if err != nil {
+					return err
+				}
+				return json.NewEncoder(os.Stdout).Encode(eventLine{
+					Node:    event.Node,
+					ID:      event.ID,
+					Type:    event.TypeURL,
+					ActorID: event.ActorID,
+					Payload: payload,
+				})
+			}
+
+			message := fmt.Sprint(event.Payload)
+			if msg, ok := event.Payload.(*machine.ServiceStateEvent); ok {
+				message = fmt.Sprintf("%s %s: %s", msg.GetService(), msg.GetAction(), msg.GetMessage())
+			}
+			_, err = fmt.Printf("%s %s %s: %s\n", event.Node, event.ID, event.TypeURL, message)
+			return err
+		})
+	})
+}
+
+func checkOutputFormat(output string) error {
+	if output != "text" && output != "json" {
+		return fmt.Errorf("invalid output format %q, must be text or json", output)
+	}
+	return nil
+}
+
+func init() {
+	logsCmd.Use = "logs <service name>..."
+	logsCmd.Short = "Retrieve logs for services of all nodes"
+	logsCmd.Args = cobra.MinimumNArgs(1)
+	generatedCompletion := logsCmd.ValidArgsFunction
+	logsCmd.ValidArgsFunction = func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return generatedCompletion(cmd, nil, toComplete)
+	}
+	logsCmd.RunE = streamLogs
+	logsCmd.Flags().DurationVar(&logsAggregateFlags.since, "since", 0, "show only the lines logged during the past duration, based on the timestamps of the lines")
+	logsCmd.Flags().StringVarP(&logsAggregateFlags.output, "output", "o", "text", "output format: text (lines prefixed with the node and the service) or json (JSON lines)")
+
+	generatedEvents := eventsCmd.RunE
+	eventsCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		if len(eventsAggregateFlags.services) == 0 && eventsAggregateFlags.output == "table" {
+			return generatedEvents(cmd, args)
+		}
+		if eventsAggregateFlags.output == "table" {
+			eventsAggregateFlags.output = "text"
+		}
+		return streamEvents(cmd, args)
+	}
+	eventsCmd.Flags().StringSliceVar(&eventsAggregateFlags.services, "service", nil, "show only the state changes of the services")
+	eventsCmd.Flags().StringVarP(&eventsAggregateFlags.output, "output", "o", "table", "output format: table, text (lines prefixed with the node) or json (JSON lines)")
+}
@@ -0,0 +1,88 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aenix-io/talm/pkg/window"
+
+	machineapi "github.com/siderolabs/talos/pkg/machinery/api/machine"
+	"github.com/siderolabs/talos/pkg/machinery/client"
+)
+
+// maintenanceWindowClosed reports whether disruptive operations on the nodes must wait, when
+// the maintenance windows of one of the nodes are closed, and the latest of the next openings
+// of their windows. The windows of a node in the known nodes override the windows of Chart.yaml.
+func maintenanceWindowClosed(nodes []string, now time.Time) (bool, time.Time, error) {
+	if err := window.Validate(Config.MaintenanceWindows); err != nil {
+		return false, time.Time{}, err
+	}
+
+	known, err := loadKnownNodes()
+	if err != nil {
+		return false, time.Time{}, err
+	}
+
+	closed := false
+	var next time.Time
+	for _, node := range nodes {
+		windows := Config.MaintenanceWindows
+		if record, ok := known[node]; ok && len(record.MaintenanceWindows) > 0 {
+			windows = record.MaintenanceWindows
+		}
+
+		open, nodeNext, err := window.Open(windows, now)
+		if err != nil {
+			return false, time.Time{}, fmt.Errorf("node %s: %w", node, err)
+		}
+		if open {
+			continue
+		}
+		closed = true
+		if nodeNext.After(next) {
+			next = nodeNext
+		}
+	}
+	return closed, next, nil
+}
+
+// reportPending reports the change of the file postponed to the next maintenance window.
+func reportPending(configFile, change string, next time.Time) {
+	if next.IsZero() {
+		fmt.Printf("- talm: file=%s, nodes=%s, outside maintenance windows, %s pending\n", configFile, GlobalArgs.Nodes, change)
+		return
+	}
+	fmt.Printf("- talm: file=%s, nodes=%s, outside maintenance windows, %s pending until %s\n", configFile, GlobalArgs.Nodes, change, next.Format(time.RFC3339))
+}
+
+// applyRequiresReboot reports whether applying the config in the mode of the flags reboots
+// one of the nodes. In auto mode the nodes are asked with a dry-run apply.
+func applyRequiresReboot(ctx context.Context, c *client.Client, data []byte) (bool, error) {
+	switch applyCmdFlags.Mode.Mode {
+	case machineapi.ApplyConfigurationRequest_REBOOT:
+		return true, nil
+	case machineapi.ApplyConfigurationRequest_AUTO:
+	default:
+		return false, nil
+	}
+
+	resp, err := c.ApplyConfiguration(ctx, &machineapi.ApplyConfigurationRequest{
+		Data:   data,
+		Mode:   machineapi.ApplyConfigurationRequest_AUTO,
+		DryRun: true,
+	})
+	if err != nil {
+		return false, fmt.Errorf("error checking whether the config requires a reboot: %w", err)
+	}
+	for _, message := range resp.GetMessages() {
+		if message.GetMode() == machineapi.ApplyConfigurationRequest_REBOOT {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,324 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aenix-io/talm/pkg/engine"
+	"github.com/aenix-io/talm/pkg/hooks"
+	"github.com/aenix-io/talm/pkg/yamltools"
+	humanize "github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/siderolabs/talos/cmd/talosctl/pkg/talos/helpers"
+	machineapi "github.com/siderolabs/talos/pkg/machinery/api/machine"
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	"github.com/siderolabs/talos/pkg/machinery/config"
+	"github.com/siderolabs/talos/pkg/machinery/config/configloader"
+)
+
+// migrateDiskPollInterval is the delay between the probes of a node rebooting into maintenance mode.
+const migrateDiskPollInterval = 5 * time.Second
+
+var migrateDiskCmdFlags struct {
+	configFiles    []string
+	yes            bool
+	dryRun         bool
+	graceful       bool
+	skipEtcdChecks bool
+	ignoreWindows  bool
+	timeout        time.Duration
+}
+
+var migrateDiskCmd = &cobra.Command{
+	Use:   "migrate-disk",
+	Short: "Move Talos to the install disk of the node files",
+	Long: `Reinstall Talos on the nodes whose system disk differs from machine.install.disk of their
+node file. Upgrades install Talos on the disk it booted from, so the install disk is only
+changed by a reinstall:
+
+  1. the install disk is checked to exist on the node and not to be the system disk
+  2. on control planes, etcd is checked to keep its quorum and the leadership is moved away
+  3. the system disk is wiped, the node leaves etcd and reboots into maintenance mode
+  4. the config rendered from the node file is applied, Talos installs on the new disk
+  5. talm waits for the node, and on control planes for etcd, to be ready again
+
+The node must boot into maintenance mode once its system disk is wiped, from the network or
+an ISO. Node files are migrated one by one, each with a single node.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := Config.Hooks.Check(); err != nil {
+			return err
+		}
+
+		nodesFromArgs := len(GlobalArgs.Nodes) > 0
+		endpointsFromArgs := len(GlobalArgs.Endpoints) > 0
+		for _, configFile := range orderConfigFiles(migrateDiskCmdFlags.configFiles) {
+			if err := processModelineAndUpdateGlobals(configFile, nodesFromArgs, endpointsFromArgs, true); err != nil {
+				return err
+			}
+			if len(GlobalArgs.Nodes) != 1 {
+				return fmt.Errorf("%s: migrate-disk reinstalls one node at a time, the file targets nodes %s", configFile, GlobalArgs.Nodes)
+			}
+
+			if err := migrateDisk(cmd.Context(), configFile, GlobalArgs.Nodes[0]); err != nil {
+				return err
+			}
+
+			if !nodesFromArgs {
+				GlobalArgs.Nodes = []string{}
+			}
+			if !endpointsFromArgs {
+				GlobalArgs.Endpoints = []string{}
+			}
+		}
+		return nil
+	},
+}
+
+// migrateDisk moves the node to the install disk of the node file.
+func migrateDisk(ctx context.Context, configFile, node string) error {
+	var (
+		result []byte
+		cfg    config.Provider
+		disk   string
+		wiped  bool
+	)
+	err := WithClientNoNodes(func(ctx context.Context, c *client.Client) error {
+		ctx = client.WithNode(ctx, node)
+
+		configBundle, err := engine.FullConfigProcess(ctx, engine.Options{
+			TalosVersion:      engine.ResolveTalosVersion(ctx, c, Config.TemplateOptions.TalosVersion),
+			WithSecrets:       Config.TemplateOptions.WithSecrets,
+			KubernetesVersion: Config.TemplateOptions.KubernetesVersion,
+		}, []string{"@" + configFile})
+		if err != nil {
+			return fmt.Errorf("full config processing error: %s", err)
+		}
+		if result, err = engine.SerializeConfiguration(configBundle, configBundle.ControlPlaneCfg.Machine().Type()); err != nil {
+			return fmt.Errorf("error serializing configuration: %s", err)
+		}
+		if cfg, err = configloader.NewFromBytes(result); err != nil {
+			return err
+		}
+
+		// A selector is resolved by Talos on the node, the disk it picks can't be checked ahead
+		if raw := cfg.RawV1Alpha1(); raw != nil && raw.MachineConfig != nil && raw.MachineConfig.MachineInstall != nil {
+			install := raw.MachineConfig.MachineInstall
+			if install.InstallDiskSelector != nil {
+				return fmt.Errorf("%s: machine.install.diskSelector is not supported, set machine.install.disk to the new disk", configFile)
+			}
+			disk = install.InstallDisk
+		}
+		if disk == "" {
+			return fmt.Errorf("%s: machine.install.disk is not set", configFile)
+		}
+
+		current, err := checkMigrationDisk(ctx, c, node, disk)
+		if err != nil || current == disk {
+			return err
+		}
+		fmt.Printf("- talm: file=%s, node=%s, system disk %s, new disk %s\n", configFile, node, current, disk)
+
+		if migrateDiskCmdFlags.dryRun {
+			return nil
+		}
+
+		if !migrateDiskCmdFlags.ignoreWindows {
+			closed, next, err := maintenanceWindowClosed([]string{node}, time.Now())
+			if err != nil {
+				return err
+			}
+			if closed {
+				reportPending(configFile, "disk migration to "+disk, next)
+				return nil
+			}
+		}
+
+		if cfg.Machine().Type().IsControlPlane() && !migrateDiskCmdFlags.skipEtcdChecks {
+			if err := checkEtcdQuorum(ctx, c, []string{node}); err != nil {
+				return err
+			}
+		}
+
+		if !migrateDiskCmdFlags.yes && !helpers.Confirm(fmt.Sprintf("Wipe the system disk %s of %s and reinstall Talos on %s? All data on both disks will be lost.", current, node, disk)) {
+			return fmt.Errorf("aborted")
+		}
+
+		if err := runHooks(ctx, hooks.Pre, "migrate-disk", configFile, cfg); err != nil {
+			return err
+		}
+
+		// The node leaves etcd and is drained, as talosctl reset does by default
+		if err := c.ResetGeneric(ctx, &machineapi.ResetRequest{
+			Graceful: migrateDiskCmdFlags.graceful,
+			Reboot:   true,
+			Mode:     machineapi.ResetRequest_SYSTEM_DISK,
+		}); err != nil {
+			return fmt.Errorf("error wiping the system disk of %s: %w", node, err)
+		}
+		wiped = true
+		fmt.Fprintf(os.Stderr, "%s: system disk %s wiped, waiting for the node to boot into maintenance mode\n", node, current)
+		return nil
+	})
+	if err != nil || !wiped {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, migrateDiskCmdFlags.timeout)
+	defer cancel()
+
+	if err := waitMaintenance(ctx, node); err != nil {
+		return err
+	}
+
+	// The node presents a new certificate in maintenance mode, it is pinned again on first use
+	if err := forgetFingerprint(node); err != nil {
+		return err
+	}
+	err = WithClientMaintenance(nil, func(ctx context.Context, c *client.Client) error {
+		resp, err := c.ApplyConfiguration(ctx, &machineapi.ApplyConfigurationRequest{
+			Data: result,
+			Mode: machineapi.ApplyConfigurationRequest_AUTO,
+		})
+		if err != nil {
+			return fmt.Errorf("error applying the config to %s: %w", node, err)
+		}
+		helpers.PrintApplyResults(resp)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// The applied config is recorded, so the next apply doesn't report it as changed outside talm
+	normalized, err := comparableConfig(result)
+	if err != nil {
+		return err
+	}
+	cache, err := loadAppliedCache()
+	if err != nil {
+		return err
+	}
+	cache.record([]string{node}, configFile, configHash(result), configHash(normalized))
+	if err := cache.save(); err != nil {
+		return fmt.Errorf("error saving applied config cache: %w", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: installing Talos on %s, waiting for the node to be ready\n", node, disk)
+	err = WithClientNoNodes(func(_ context.Context, c *client.Client) error {
+		if err := waitNodeReady(ctx, c, node, nil); err != nil {
+			return fmt.Errorf("node %s is not ready: %w", node, err)
+		}
+		if cfg.Machine().Type().IsControlPlane() {
+			if err := waitEtcdHealthy(ctx, c, node, nil); err != nil {
+				return fmt.Errorf("etcd of node %s is not healthy: %w", node, err)
+			}
+		}
+
+		current, err := checkMigrationDisk(client.WithNode(ctx, node), c, node, disk)
+		if err != nil {
+			return err
+		}
+		if current != disk {
+			return fmt.Errorf("node %s booted from %s instead of %s, check the boot order of its firmware", node, current, disk)
+		}
+		return runHooks(ctx, hooks.Post, "migrate-disk", configFile, cfg)
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: migrated to %s\n", node, disk)
+	return nil
+}
+
+// checkMigrationDisk ensures the disk exists on the node and returns the current system disk.
+func checkMigrationDisk(ctx context.Context, c *client.Client, node, disk string) (string, error) {
+	response, err := c.Disks(ctx)
+	if err != nil {
+		return "", fmt.Errorf("error getting disks from %s: %w", node, err)
+	}
+
+	var current string
+	found := false
+	for _, m := range response.Messages {
+		for _, d := range m.Disks {
+			if d.SystemDisk {
+				current = d.DeviceName
+			}
+			if d.DeviceName == disk {
+				found = true
+				if d.Size > 0 {
+					fmt.Fprintf(os.Stderr, "%s: %s is %s %s, serial %s\n", node, disk, humanize.Bytes(d.Size), d.Model, d.Serial)
+				}
+			}
+		}
+	}
+
+	switch {
+	case !found:
+		return "", fmt.Errorf("disk %s not found on node %s, see the discovered disks with `talm disks`", disk, node)
+	case current == "":
+		return "", fmt.Errorf("system disk of node %s not found", node)
+	case current == disk:
+		fmt.Fprintf(os.Stderr, "%s: Talos is installed on %s already\n", node, disk)
+	}
+	return current, nil
+}
+
+// waitMaintenance probes the node until it runs the maintenance service.
+func waitMaintenance(ctx context.Context, node string) error {
+	for {
+		if probeNodeState(ctx, node) == nodeMaintenance {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("node %s did not boot into maintenance mode in time, boot it from the network or an ISO and run migrate-disk again", node)
+			}
+			return ctx.Err()
+		case <-time.After(migrateDiskPollInterval):
+		}
+	}
+}
+
+// forgetFingerprint removes the pinned maintenance certificate of the node.
+func forgetFingerprint(node string) error {
+	known, err := loadKnownNodes()
+	if err != nil {
+		return err
+	}
+	record, ok := known[node]
+	if !ok || record.CertFingerprint == "" {
+		return nil
+	}
+	return editKnownNodes(func(root *yaml.Node) error {
+		yamltools.DeletePath(root, node, "certFingerprint")
+		yamltools.DeletePath(root, node, "learned")
+		return nil
+	})
+}
+
+func init() {
+	migrateDiskCmd.Flags().StringSliceVarP(&migrateDiskCmdFlags.configFiles, "file", "f", nil, "specify node files with the new install disk (can specify multiple)")
+	migrateDiskCmd.Flags().BoolVarP(&migrateDiskCmdFlags.yes, "yes", "y", false, "do not ask for confirmation")
+	migrateDiskCmd.Flags().BoolVar(&migrateDiskCmdFlags.dryRun, "dry-run", false, "only check the install disks and print the migrations")
+	migrateDiskCmd.Flags().BoolVar(&migrateDiskCmdFlags.graceful, "graceful", true, "leave etcd and drain the node before wiping its system disk")
+	migrateDiskCmd.Flags().BoolVar(&migrateDiskCmdFlags.skipEtcdChecks, "skip-etcd-checks", false, "do not check that etcd keeps its quorum")
+	migrateDiskCmd.Flags().BoolVar(&migrateDiskCmdFlags.ignoreWindows, "ignore-maintenance-windows", false, "migrate the nodes outside their maintenance windows")
+	migrateDiskCmd.Flags().DurationVar(&migrateDiskCmdFlags.timeout, "timeout", 30*time.Minute, "time to wait for the node to reboot, install Talos and be ready")
+	cobra.CheckErr(migrateDiskCmd.MarkFlagRequired("file"))
+
+	addCommand(migrateDiskCmd)
+}
@@ -0,0 +1,92 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/aenix-io/talm/pkg/engine"
+	"github.com/aenix-io/talm/pkg/fileutil"
+	"github.com/spf13/cobra"
+)
+
+var migrateValuesCmdFlags struct {
+	dryRun bool
+}
+
+var migrateValuesCmd = &cobra.Command{
+	Use:   "migrate-values [file...]",
+	Short: "Rewrite values files replacing the deprecated values",
+	Long: `Rewrite values files according to the deprecatedValues section of Chart.yaml
+and of the preset the chart extends: renamed values are moved to their new
+names and removed values are deleted. Comments are kept.
+
+Files default to values.yaml of the project and the values files from
+Chart.yaml, including values.yaml of the selected workspace.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		chrt, err := engine.LoadChart(Config.RootDir, Config.TemplateOptions.Extends)
+		if err != nil {
+			return fmt.Errorf("failed to load chart: %w", err)
+		}
+
+		deprecations, err := engine.ValueDeprecations(chrt, Config.TemplateOptions.Extends)
+		if err != nil {
+			return err
+		}
+
+		files := args
+		if len(files) == 0 {
+			files = []string{filepath.Join(Config.RootDir, "values.yaml")}
+			files = append(files, Config.TemplateOptions.ValueFiles...)
+		}
+
+		for _, file := range files {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				if len(args) == 0 && os.IsNotExist(err) {
+					continue
+				}
+				return err
+			}
+
+			migrated, warnings, err := engine.MigrateValuesFile(data, deprecations)
+			if err != nil {
+				return fmt.Errorf("failed to migrate %s: %w", file, err)
+			}
+			if len(warnings) == 0 {
+				fmt.Fprintf(os.Stderr, "%s: no deprecated values\n", file)
+				continue
+			}
+
+			for _, warning := range warnings {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", file, warning)
+			}
+
+			if migrateValuesCmdFlags.dryRun {
+				fmt.Printf("# %s\n%s", file, migrated)
+				continue
+			}
+
+			info, err := os.Stat(file)
+			if err != nil {
+				return err
+			}
+			if err := fileutil.WriteFile(file, migrated, info.Mode().Perm()); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Updated %s\n", file)
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	migrateValuesCmd.Flags().BoolVar(&migrateValuesCmdFlags.dryRun, "dry-run", false, "print the migrated files instead of writing them")
+
+	addCommand(migrateValuesCmd)
+}
@@ -0,0 +1,194 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/aenix-io/talm/pkg/engine"
+	"github.com/aenix-io/talm/pkg/modeline"
+	"github.com/aenix-io/talm/pkg/nodemeta"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var nodesSyncLabelsCmdFlags struct {
+	configFiles []string
+	kubeconfig  string
+	fix         bool
+}
+
+var nodesCmd = &cobra.Command{
+	Use:   "nodes",
+	Short: "Manage the inventory of nodes and the Kubernetes nodes of the cluster",
+	Long:  ``,
+}
+
+var nodesSyncLabelsCmd = &cobra.Command{
+	Use:   "sync-labels",
+	Short: "Report the drift of the labels, annotations and taints of the Kubernetes nodes from the values",
+	Long: `Compare the nodeLabels, nodeAnnotations and nodeTaints of the values of every node file,
+including the defaults of its machine type in nodeGroups and the values of its modeline, with
+the live Kubernetes nodes. The nodes are matched by the addresses of the modeline.
+
+Labels and taints are rendered in the machine config and applied by Talos, annotations are
+not part of the machine config. Keys removed from the values are reported if they are still
+owned on the node. With --fix the live nodes are updated, otherwise the command exits with
+code 2 when any node has drifted.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		files := nodesSyncLabelsCmdFlags.configFiles
+		if len(files) == 0 {
+			var err error
+			if files, err = defaultNodeFiles(); err != nil {
+				return err
+			}
+		}
+
+		clientset, err := kubernetesClient(nodesSyncLabelsCmdFlags.kubeconfig)
+		if err != nil {
+			return err
+		}
+		nodes, err := clientset.CoreV1().Nodes().List(cmd.Context(), metav1.ListOptions{})
+		if err != nil {
+			return fmt.Errorf("error listing the Kubernetes nodes: %w", err)
+		}
+
+		drifted := 0
+		for _, configFile := range files {
+			n, err := syncNodeLabels(cmd.Context(), clientset, nodes.Items, configFile)
+			if err != nil {
+				return fmt.Errorf("%s: %w", configFile, err)
+			}
+			drifted += n
+		}
+
+		if drifted > 0 && !nodesSyncLabelsCmdFlags.fix {
+			return &ExitError{Code: driftExitCode, Err: fmt.Errorf("%d node(s) differ from the values", drifted)}
+		}
+		return nil
+	},
+}
+
+// kubernetesClient connects to the Kubernetes API with the kubeconfig, the kubeconfig of the
+// project or workspace, or the default kubeconfig of kubectl.
+func kubernetesClient(kubeconfig string) (*kubernetes.Clientset, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if path := kubeconfig; path != "" {
+		rules.ExplicitPath = path
+	} else if path := filepath.Join(stateDir(), "kubeconfig"); fileExists(path) {
+		rules.ExplicitPath = path
+	}
+
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("error loading kubeconfig: %w", err)
+	}
+	return kubernetes.NewForConfig(config)
+}
+
+// syncNodeLabels reports, and fixes with --fix, the drift of the nodes of the node file.
+// It returns the number of drifted nodes.
+func syncNodeLabels(ctx context.Context, clientset *kubernetes.Clientset, nodes []corev1.Node, configFile string) (int, error) {
+	modelineConfig, err := modeline.ReadAndParseModeline(configFile)
+	if err != nil {
+		return 0, err
+	}
+
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return 0, err
+	}
+	var machine struct {
+		Machine struct {
+			Type string `yaml:"type"`
+		} `yaml:"machine"`
+	}
+	if err := yaml.Unmarshal(data, &machine); err != nil {
+		return 0, fmt.Errorf("error reading the machine type: %w", err)
+	}
+
+	values, err := engine.Values(engine.Options{
+		Root:          Config.RootDir,
+		Extends:       Config.TemplateOptions.Extends,
+		ValueFiles:    Config.TemplateOptions.ValueFiles,
+		Values:        Config.TemplateOptions.Values,
+		StringValues:  Config.TemplateOptions.StringValues,
+		FileValues:    Config.TemplateOptions.FileValues,
+		JsonValues:    Config.TemplateOptions.JsonValues,
+		LiteralValues: Config.TemplateOptions.LiteralValues,
+		NodeValues:    modelineConfig.Values,
+	})
+	if err != nil {
+		return 0, err
+	}
+	want, err := nodemeta.FromValues(values, machine.Machine.Type)
+	if err != nil {
+		return 0, err
+	}
+
+	drifted := 0
+	for _, address := range modelineConfig.Nodes {
+		node := kubernetesNode(nodes, address)
+		if node == nil {
+			fmt.Fprintf(os.Stderr, "Warning: no Kubernetes node has the address %s of %s\n", address, configFile)
+			continue
+		}
+
+		changes := nodemeta.Diff(want, node)
+		if len(changes) == 0 {
+			fmt.Printf("- talm: file=%s, node=%s (%s), in sync\n", configFile, address, node.Name)
+			continue
+		}
+		drifted++
+		fmt.Printf("- talm: file=%s, node=%s (%s), %d change(s)\n", configFile, address, node.Name, len(changes))
+		for _, change := range changes {
+			fmt.Printf("  %s\n", change)
+		}
+
+		if !nodesSyncLabelsCmdFlags.fix {
+			continue
+		}
+		if err := nodemeta.Apply(want, node); err != nil {
+			return drifted, err
+		}
+		if _, err := clientset.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{}); err != nil {
+			return drifted, fmt.Errorf("error updating node %s: %w", node.Name, err)
+		}
+		fmt.Fprintf(os.Stderr, "Updated.\n")
+	}
+	return drifted, nil
+}
+
+// kubernetesNode returns the node whose name or one of its addresses is the address.
+func kubernetesNode(nodes []corev1.Node, address string) *corev1.Node {
+	for i := range nodes {
+		if nodes[i].Name == address {
+			return &nodes[i]
+		}
+		for _, a := range nodes[i].Status.Addresses {
+			if a.Address == address {
+				return &nodes[i]
+			}
+		}
+	}
+	return nil
+}
+
+func init() {
+	nodesSyncLabelsCmd.Flags().StringSliceVarP(&nodesSyncLabelsCmdFlags.configFiles, "file", "f", nil, "node files to compare, all node files of the project or workspace by default")
+	nodesSyncLabelsCmd.Flags().StringVar(&nodesSyncLabelsCmdFlags.kubeconfig, "kubeconfig", "", "kubeconfig of the cluster, the kubeconfig of the project or the default one of kubectl by default")
+	nodesSyncLabelsCmd.Flags().BoolVar(&nodesSyncLabelsCmdFlags.fix, "fix", false, "update the labels, annotations and taints of the live nodes")
+
+	nodesCmd.AddCommand(nodesSyncLabelsCmd)
+	addCommand(nodesCmd)
+}
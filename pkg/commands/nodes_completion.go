@@ -0,0 +1,167 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aenix-io/talm/pkg/modeline"
+	"github.com/cosi-project/runtime/pkg/safe"
+	"github.com/spf13/cobra"
+
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	"github.com/siderolabs/talos/pkg/machinery/resources/cluster"
+)
+
+// memberQueryTimeout limits the query of the cluster members, completion must stay responsive.
+const memberQueryTimeout = 5 * time.Second
+
+// declaredNodes returns the nodes of the modelines of the node files of the project or workspace.
+func declaredNodes() []string {
+	files, _ := defaultNodeFiles()
+
+	var nodes []string
+	for _, file := range files {
+		modelineConfig, err := modeline.ReadAndParseModeline(file)
+		if err != nil {
+			continue
+		}
+		nodes = append(nodes, modelineConfig.Nodes...)
+	}
+	return nodes
+}
+
+// memberNodes returns the addresses and hostnames of the cluster members, as discovered by Talos
+// with the discovery service and the Kubernetes registry.
+func memberNodes() ([]string, error) {
+	var nodes []string
+	err := WithClientNoNodes(func(ctx context.Context, c *client.Client) error {
+		ctx, cancel := context.WithTimeout(ctx, memberQueryTimeout)
+		defer cancel()
+
+		members, err := safe.StateListAll[*cluster.Member](ctx, c.COSI)
+		if err != nil {
+			return fmt.Errorf("error listing cluster members: %w", err)
+		}
+		for it := members.Iterator(); it.Next(); {
+			spec := it.Value().TypedSpec()
+			nodes = append(nodes, spec.Hostname)
+			for _, address := range spec.Addresses {
+				nodes = append(nodes, address.String())
+			}
+		}
+		return nil
+	})
+	return nodes, err
+}
+
+// CompleteNodesFlag completes the --nodes flag with the nodes of the node files and the cluster members,
+// unlike CompleteNodes of talosctl it works without a cluster and completes comma separated lists.
+// The members are skipped if the cluster can't be reached.
+func CompleteNodesFlag(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	candidates := declaredNodes()
+	if members, err := memberNodes(); err == nil {
+		candidates = append(candidates, members...)
+	}
+	slices.Sort(candidates)
+	candidates = slices.Compact(candidates)
+
+	// --nodes takes a comma separated list, the last node is completed
+	prefix := ""
+	if i := strings.LastIndex(toComplete, ","); i >= 0 {
+		prefix = toComplete[:i+1]
+	}
+
+	var completions []string
+	for _, candidate := range candidates {
+		if candidate != "" && strings.HasPrefix(prefix+candidate, toComplete) {
+			completions = append(completions, prefix+candidate)
+		}
+	}
+	return completions, cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+}
+
+// CheckNodes ensures the nodes of --nodes are in the node files or cluster members when
+// globalOptions.validateNodes is set in Chart.yaml, so a typo fails before the command
+// connects to the nodes. Nodes in maintenance mode are no members yet, they are not checked
+// with --insecure, nor if the members can't be listed.
+func CheckNodes(cmd *cobra.Command) error {
+	if !Config.GlobalOptions.ValidateNodes || !cmd.Flags().Changed("nodes") {
+		return nil
+	}
+	if insecure := cmd.Flags().Lookup("insecure"); insecure != nil && insecure.Value.String() == "true" {
+		return nil
+	}
+
+	known := declaredNodes()
+	var unknown []string
+	for _, node := range GlobalArgs.Nodes {
+		if !slices.Contains(known, node) {
+			unknown = append(unknown, node)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+
+	members, err := memberNodes()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to check the nodes against the cluster members: %s\n", err)
+		return nil
+	}
+	known = append(known, members...)
+
+	var invalid []string
+	for _, node := range unknown {
+		if slices.Contains(members, node) {
+			continue
+		}
+		if suggestion := closestNode(node, known); suggestion != "" {
+			node = fmt.Sprintf("%s (did you mean %s?)", node, suggestion)
+		}
+		invalid = append(invalid, node)
+	}
+	if len(invalid) > 0 {
+		return fmt.Errorf("unknown nodes %s: they are neither in the node files nor cluster members", strings.Join(invalid, ", "))
+	}
+	return nil
+}
+
+// closestNode returns the candidate within two edits of the node, or an empty string.
+func closestNode(node string, candidates []string) string {
+	closest, best := "", 3
+	for _, candidate := range candidates {
+		if d := editDistance(node, candidate); d < best {
+			closest, best = candidate, d
+		}
+	}
+	return closest
+}
+
+// editDistance returns the Levenshtein distance of the strings.
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
@@ -0,0 +1,291 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aenix-io/talm/pkg/fileutil"
+	"github.com/aenix-io/talm/pkg/modeline"
+	"github.com/aenix-io/talm/pkg/yamltools"
+	"github.com/cosi-project/runtime/pkg/safe"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/siderolabs/talos/cmd/talosctl/pkg/talos/helpers"
+	machineapi "github.com/siderolabs/talos/pkg/machinery/api/machine"
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	"github.com/siderolabs/talos/pkg/machinery/config/machine"
+	configres "github.com/siderolabs/talos/pkg/machinery/resources/config"
+	"github.com/siderolabs/talos/pkg/machinery/resources/network"
+)
+
+// controlPlaneQuorum is the number of control plane nodes suggested before workers.
+const controlPlaneQuorum = 3
+
+var nodesAddCmdFlags struct {
+	name     string
+	template string
+	insecure bool
+	region   string
+	zone     string
+	rack     string
+	apply    bool
+}
+
+var nodesRemoveCmdFlags struct {
+	yes bool
+}
+
+var nodesAddCmd = &cobra.Command{
+	Use:   "add <address>",
+	Short: "Add a node to the inventory, rendering its node file from the discovered data",
+	Long: `Connect to the node, suggest the template from its machine type or, for nodes in
+maintenance mode, from the control plane nodes of the inventory, and render the node file
+nodes/<name>.yaml with the modeline of the node. The name is the discovered hostname unless
+--name is set.
+
+The failure domain of the node is recorded in .talm/nodes.yaml, keeping the comments of the
+file. With --apply the node file is applied right away.`,
+	Args: cobra.ExactArgs(1),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		// Flags not defined for nodes add are never changed, so the defaults are taken from Chart.yaml
+		if err := templateCmd.PreRunE(cmd, args); err != nil {
+			return err
+		}
+		return applyCmd.PreRunE(cmd, args)
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		address := args[0]
+		endpointsFromArgs := len(GlobalArgs.Endpoints) > 0
+		GlobalArgs.Nodes = []string{address}
+		if !endpointsFromArgs {
+			GlobalArgs.Endpoints = []string{address}
+		}
+		// The node file is rendered from the discovered data
+		templateCmdFlags.offline = false
+		templateCmdFlags.insecure = nodesAddCmdFlags.insecure
+
+		// The failure domain is exposed to the templates as .Node.Topology
+		if err := setNodeTopology(address); err != nil {
+			return err
+		}
+
+		var file string
+		render := func(ctx context.Context, c *client.Client) error {
+			var err error
+			file, err = addNodeFile(ctx, c, address, args)
+			return err
+		}
+
+		var err error
+		if nodesAddCmdFlags.insecure {
+			err = WithClientMaintenance(nil, render)
+		} else {
+			err = WithClient(render)
+		}
+		if err != nil {
+			return err
+		}
+
+		if !nodesAddCmdFlags.apply {
+			return nil
+		}
+		if err := checkOmniManaged(); err != nil {
+			return err
+		}
+
+		// The nodes and endpoints are taken from the modeline of the new node file
+		GlobalArgs.Nodes = []string{}
+		if !endpointsFromArgs {
+			GlobalArgs.Endpoints = []string{}
+		}
+		applyCmdFlags.configFiles = []string{file}
+		applyCmdFlags.insecure = nodesAddCmdFlags.insecure
+		applyCmdFlags.Mode.Mode = machineapi.ApplyConfigurationRequest_AUTO
+		applyCmdFlags.dryRun = false
+		applyCmdFlags.changedOnly = false
+
+		return WithClientNoNodes(apply(nil))
+	},
+}
+
+var nodesRemoveCmd = &cobra.Command{
+	Use:   "remove <name>",
+	Short: "Remove a node file and the records of its nodes from the inventory",
+	Long: `Remove nodes/<name>.yaml, or the node file given as path, and forget its nodes in
+.talm/nodes.yaml, keeping the comments of the file, and in the applied configs of .talm/applied.json.
+
+The nodes themselves are not changed, reset them with 'talm reset -f <file>' before, or find
+them later with 'talm prune'.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		file := args[0]
+		if !strings.HasSuffix(file, ".yaml") {
+			file = filepath.Join(stateDir(), "nodes", file+".yaml")
+		}
+		if err := checkWorkspaceFile(file); err != nil {
+			return err
+		}
+
+		modelineConfig, err := modeline.ReadAndParseModeline(file)
+		if err != nil {
+			return fmt.Errorf("modeline parsing failed for %s: %w", file, err)
+		}
+
+		if !nodesRemoveCmdFlags.yes && !helpers.Confirm(fmt.Sprintf("Remove %s and forget nodes %s?", file, strings.Join(modelineConfig.Nodes, ", "))) {
+			return errors.New("aborted")
+		}
+
+		err = editKnownNodes(func(root *yaml.Node) error {
+			for _, node := range modelineConfig.Nodes {
+				// The comment heading the file belongs to its first key, it is kept when that node is removed
+				head := ""
+				if len(root.Content) > 0 && root.Content[0].Value == node {
+					head = root.Content[0].HeadComment
+				}
+				yamltools.DeletePath(root, node)
+				if len(root.Content) > 0 && root.Content[0].HeadComment == "" {
+					root.Content[0].HeadComment = head
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		cache, err := loadAppliedCache()
+		if err != nil {
+			return err
+		}
+		for _, node := range modelineConfig.Nodes {
+			delete(cache, node)
+		}
+		if err := cache.save(); err != nil {
+			return fmt.Errorf("error saving applied config cache: %w", err)
+		}
+
+		if err := os.Remove(file); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Removed %s.\n", file)
+		return nil
+	},
+}
+
+// addNodeFile renders the node file of the node from the suggested template and returns its path.
+func addNodeFile(ctx context.Context, c *client.Client, address string, args []string) (string, error) {
+	nodeCtx := ctx
+	if !nodesAddCmdFlags.insecure {
+		nodeCtx = client.WithNode(ctx, address)
+	}
+
+	name := nodesAddCmdFlags.name
+	if name == "" {
+		hostname, err := safe.StateGetByID[*network.HostnameStatus](nodeCtx, c.COSI, network.HostnameID)
+		if err != nil {
+			return "", fmt.Errorf("error discovering the hostname of %s, set the name with --name: %w", address, err)
+		}
+		name = hostname.TypedSpec().Hostname
+	}
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return "", fmt.Errorf("invalid node name %q, set the name with --name", name)
+	}
+
+	file := filepath.Join(stateDir(), "nodes", name+".yaml")
+	if fileExists(file) {
+		return "", fmt.Errorf("node file %s already exists, set another name with --name", file)
+	}
+
+	templateFile := nodesAddCmdFlags.template
+	if templateFile == "" {
+		var reason string
+		templateFile, reason = suggestTemplate(nodeCtx, c)
+		if !fileExists(filepath.Join(Config.RootDir, templateFile)) {
+			return "", fmt.Errorf("suggested template %s doesn't exist, set the template with --template", templateFile)
+		}
+		fmt.Fprintf(os.Stderr, "Suggested template %s: %s\n", templateFile, reason)
+	}
+	templateCmdFlags.templateFiles = []string{templateFile}
+
+	// Keep the output in memory, so no file is left if rendering fails
+	var buf bytes.Buffer
+	if err := generateOutput(ctx, c, args, &buf); err != nil {
+		return "", err
+	}
+
+	fmt.Printf("- talm: file=%s, nodes=%s, endpoints=%s, templates=%s\n", file, GlobalArgs.Nodes, GlobalArgs.Endpoints, templateCmdFlags.templateFiles)
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return "", err
+	}
+	if err := fileutil.WriteFile(file, buf.Bytes(), 0o644); err != nil {
+		return "", err
+	}
+	fmt.Fprintf(os.Stderr, "Created.\n")
+	return file, nil
+}
+
+// suggestTemplate returns the template of the machine type of a configured node. Nodes in maintenance
+// mode have none, they are suggested as control plane nodes until the inventory has a quorum of them.
+func suggestTemplate(ctx context.Context, c *client.Client) (string, string) {
+	machineType, err := safe.StateGetByID[*configres.MachineType](ctx, c.COSI, configres.MachineTypeID)
+	if err == nil && machineType.MachineType() != machine.TypeUnknown {
+		typ := machineType.MachineType()
+		if typ == machine.TypeInit {
+			typ = machine.TypeControlPlane
+		}
+		return filepath.Join("templates", typ.String()+".yaml"), fmt.Sprintf("the node runs as %s", typ)
+	}
+
+	files, _ := defaultNodeFiles()
+	controlPlanes := 0
+	for _, file := range files {
+		if nodeFileMachineType(file) == machine.TypeControlPlane.String() {
+			controlPlanes++
+		}
+	}
+	if controlPlanes < controlPlaneQuorum {
+		return filepath.Join("templates", "controlplane.yaml"), fmt.Sprintf("the inventory has %d of %d control plane nodes", controlPlanes, controlPlaneQuorum)
+	}
+	return filepath.Join("templates", "worker.yaml"), fmt.Sprintf("the inventory has %d control plane nodes", controlPlanes)
+}
+
+// setNodeTopology records the failure domain of the node set by the flags in .talm/nodes.yaml.
+func setNodeTopology(address string) error {
+	levels := map[string]string{"region": nodesAddCmdFlags.region, "zone": nodesAddCmdFlags.zone, "rack": nodesAddCmdFlags.rack}
+	if nodesAddCmdFlags.region == "" && nodesAddCmdFlags.zone == "" && nodesAddCmdFlags.rack == "" {
+		return nil
+	}
+
+	return editKnownNodes(func(root *yaml.Node) error {
+		for _, level := range []string{"region", "zone", "rack"} {
+			if levels[level] != "" {
+				yamltools.SetScalar(root, levels[level], address, "topology", level)
+			}
+		}
+		return nil
+	})
+}
+
+func init() {
+	nodesAddCmd.Flags().StringVar(&nodesAddCmdFlags.name, "name", "", "name of the node file, the discovered hostname by default")
+	nodesAddCmd.Flags().StringVarP(&nodesAddCmdFlags.template, "template", "t", "", "template of the node, suggested from the machine type of the node or the inventory by default")
+	nodesAddCmd.Flags().BoolVarP(&nodesAddCmdFlags.insecure, "insecure", "i", false, "connect to the node in maintenance mode using the insecure (encrypted with no auth) maintenance service")
+	nodesAddCmd.Flags().StringVar(&nodesAddCmdFlags.region, "region", "", "region of the failure domain of the node")
+	nodesAddCmd.Flags().StringVar(&nodesAddCmdFlags.zone, "zone", "", "zone of the failure domain of the node")
+	nodesAddCmd.Flags().StringVar(&nodesAddCmdFlags.rack, "rack", "", "rack of the failure domain of the node")
+	nodesAddCmd.Flags().BoolVar(&nodesAddCmdFlags.apply, "apply", false, "apply the node file after it is created")
+
+	nodesRemoveCmd.Flags().BoolVarP(&nodesRemoveCmdFlags.yes, "yes", "y", false, "don't ask for confirmation")
+
+	nodesCmd.AddCommand(nodesAddCmd, nodesRemoveCmd)
+}
@@ -0,0 +1,38 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/aenix-io/talm/pkg/engine"
+)
+
+// printNotes prints templates/NOTES.txt of the chart after the command succeeded.
+// The notes are a hint, so a broken template is reported as a warning only.
+func printNotes(command string) {
+	notes, err := engine.RenderNotes(engine.Options{
+		Root:          Config.RootDir,
+		Extends:       Config.TemplateOptions.Extends,
+		ValueFiles:    Config.TemplateOptions.ValueFiles,
+		Values:        Config.TemplateOptions.Values,
+		StringValues:  Config.TemplateOptions.StringValues,
+		FileValues:    Config.TemplateOptions.FileValues,
+		JsonValues:    Config.TemplateOptions.JsonValues,
+		LiteralValues: Config.TemplateOptions.LiteralValues,
+		EnvAllowlist:  Config.TemplateOptions.EnvAllowlist,
+		Plugins:       Config.TemplateOptions.Plugins,
+	}, command)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to render NOTES.txt: %v\n", err)
+		return
+	}
+	if notes == "" {
+		return
+	}
+
+	fmt.Printf("\n%s\n", notes)
+}
@@ -0,0 +1,195 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/aenix-io/talm/pkg/engine"
+	"github.com/aenix-io/talm/pkg/modeline"
+	"github.com/aenix-io/talm/pkg/omni"
+	"github.com/spf13/cobra"
+)
+
+var omniTemplateCmdFlags struct {
+	configFiles []string
+	output      string
+	sync        bool
+	dryRun      bool
+}
+
+var omniCmd = &cobra.Command{
+	Use:   "omni",
+	Short: "Manage clusters whose machines are managed by Sidero Omni",
+	Long: `Omni manages the machine configs of its clusters and doesn't accept configs applied through
+the Talos API it proxies. The node files are submitted to Omni as a cluster template instead:
+every node file becomes the config patch of its machines.`,
+}
+
+var omniTemplateCmd = &cobra.Command{
+	Use:   "template",
+	Short: "Write the node files as an Omni cluster template",
+	Long: `Write an Omni cluster template with the node files as the config patches of their machines,
+to be synced with omnictl cluster template sync, or synced directly with --sync.
+
+The machines are the nodes of the modelines, which are the Omni machine IDs with the talosconfig
+of Omni, or mapped to machine IDs by omniOptions.machines in Chart.yaml. The control plane and
+workers are taken from machine.type of the node files. The fields set by Omni, the machine type
+and the cluster name and endpoint, are left out of the patches.
+
+  omniOptions:
+    cluster: prod
+    talosVersion: v1.7.1
+    kubernetesVersion: v1.30.0
+    machines:
+      10.0.0.1: 4c4c4544-0039-3010-8048-b7c04f4b4432`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		files := omniTemplateCmdFlags.configFiles
+		if len(files) == 0 {
+			var err error
+			files, err = defaultNodeFiles()
+			if err != nil {
+				return err
+			}
+		}
+		if len(files) == 0 {
+			return errors.New("no node files found: please use `--file` flag to set the node files of the cluster")
+		}
+
+		cluster := omni.Cluster{
+			Name:              Config.OmniOptions.Cluster,
+			TalosVersion:      Config.OmniOptions.TalosVersion,
+			KubernetesVersion: Config.OmniOptions.KubernetesVersion,
+		}
+		if cluster.TalosVersion == "" {
+			cluster.TalosVersion = Config.TemplateOptions.TalosVersion
+		}
+		if cluster.KubernetesVersion == "" {
+			cluster.KubernetesVersion = Config.TemplateOptions.KubernetesVersion
+		}
+		if cluster.TalosVersion == "" || cluster.KubernetesVersion == "" {
+			return errors.New("the Talos and Kubernetes versions are required by Omni: please set omniOptions.talosVersion and omniOptions.kubernetesVersion in Chart.yaml")
+		}
+		if cluster.Name == "" {
+			chrt, err := engine.LoadChart(Config.RootDir, Config.TemplateOptions.Extends)
+			if err != nil {
+				return err
+			}
+			cluster.Name = chrt.Name()
+		}
+
+		var machines []omni.Machine
+		for _, file := range files {
+			if err := checkWorkspaceFile(file); err != nil {
+				return err
+			}
+			modelineConfig, err := modeline.ReadAndParseModeline(file)
+			if err != nil {
+				return fmt.Errorf("modeline parsing failed for %s: %w", file, err)
+			}
+			if len(modelineConfig.Nodes) == 0 {
+				return fmt.Errorf("modeline of %s does not contain nodes", file)
+			}
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			patch, controlPlane, err := omni.ParsePatch(data)
+			if err != nil {
+				return fmt.Errorf("%s: %w", file, err)
+			}
+
+			name := "talm-" + strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+			for _, node := range modelineConfig.Nodes {
+				id := node
+				if mapped, ok := Config.OmniOptions.Machines[node]; ok {
+					id = mapped
+				}
+				machines = append(machines, omni.Machine{ID: id, ControlPlane: controlPlane, PatchName: name, Patch: patch})
+			}
+		}
+
+		data, err := omni.Template(cluster, machines)
+		if err != nil {
+			return err
+		}
+
+		output := omniTemplateCmdFlags.output
+		if !omniTemplateCmdFlags.sync {
+			if output == "" {
+				_, err := os.Stdout.Write(data)
+				return err
+			}
+			return os.WriteFile(output, data, 0o644)
+		}
+
+		omnictl, err := exec.LookPath("omnictl")
+		if err != nil {
+			return fmt.Errorf("omnictl is required to sync the template: %w", err)
+		}
+		if output == "" {
+			f, err := os.CreateTemp("", "talm-omni-*.yaml")
+			if err != nil {
+				return err
+			}
+			defer os.Remove(f.Name())
+			if err := f.Close(); err != nil {
+				return err
+			}
+			output = f.Name()
+		}
+		if err := os.WriteFile(output, data, 0o644); err != nil {
+			return err
+		}
+
+		syncArgs := []string{"cluster", "template", "sync", "--file", output}
+		if omniTemplateCmdFlags.dryRun {
+			syncArgs = append(syncArgs, "--dry-run")
+		}
+		sync := exec.CommandContext(cmd.Context(), omnictl, syncArgs...)
+		sync.Stdout, sync.Stderr = os.Stdout, os.Stderr
+		if err := sync.Run(); err != nil {
+			return fmt.Errorf("omnictl cluster template sync failed: %w", err)
+		}
+		return nil
+	},
+}
+
+// checkOmniManaged fails for the contexts of Omni talosconfigs, whose machine configs are managed
+// by Omni. Encrypted talosconfigs are never issued by Omni and are not opened twice.
+func checkOmniManaged() error {
+	if isTalosconfigEncrypted(GlobalArgs.Talosconfig) {
+		return nil
+	}
+	cfg, err := openTalosconfig(GlobalArgs.Talosconfig)
+	if err != nil {
+		// Reported when the client is created
+		return nil
+	}
+	contextName := cfg.Context
+	if GlobalArgs.CmdContext != "" {
+		contextName = GlobalArgs.CmdContext
+	}
+	if configContext, ok := cfg.Contexts[contextName]; ok && configContext.Auth.SideroV1 != nil {
+		return fmt.Errorf("talosconfig context %q is issued by Omni, which manages the machine configs: use `talm omni template --sync` instead", contextName)
+	}
+	return nil
+}
+
+func init() {
+	omniTemplateCmd.Flags().StringSliceVarP(&omniTemplateCmdFlags.configFiles, "file", "f", nil, "specify node files of the cluster, all the files in nodes/ by default")
+	omniTemplateCmd.Flags().StringVarP(&omniTemplateCmdFlags.output, "output", "o", "", "write the template to the file instead of stdout")
+	omniTemplateCmd.Flags().BoolVar(&omniTemplateCmdFlags.sync, "sync", false, "sync the template to Omni with omnictl")
+	omniTemplateCmd.Flags().BoolVar(&omniTemplateCmdFlags.dryRun, "dry-run", false, "only show the changes of the sync")
+
+	omniCmd.AddCommand(omniTemplateCmd)
+	addCommand(omniCmd)
+}
@@ -0,0 +1,124 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"text/tabwriter"
+
+	"github.com/aenix-io/talm/pkg/bmc"
+	"github.com/spf13/cobra"
+
+	"github.com/siderolabs/talos/cmd/talosctl/pkg/talos/helpers"
+)
+
+var powerCmdFlags struct {
+	configFiles []string
+	yes         bool
+}
+
+var powerCmd = &cobra.Command{
+	Use:   "power on|off|cycle|pxe|status",
+	Short: "Control the power of the nodes through their BMCs",
+	Long: `Control the power of bare-metal nodes through their baseboard management controllers,
+with Redfish or IPMI (requires ipmitool). The BMC of every node is declared in ` + knownNodesFile + `:
+
+  1.2.3.4:
+    bmc:
+      protocol: redfish
+      address: 10.0.100.4
+      username: admin
+      passwordEnv: NODE1_BMC_PASSWORD
+
+The pxe action boots the node from the network once, to reinstall it:
+
+  talm reset -f nodes/node1.yaml --graceful=false --reboot=false
+  talm power pxe -f nodes/node1.yaml
+  talm apply -f nodes/node1.yaml -i`,
+	ValidArgs: []string{string(bmc.On), string(bmc.Off), string(bmc.Cycle), string(bmc.PXE), "status"},
+	Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		nodesFromArgs := len(GlobalArgs.Nodes) > 0
+		endpointsFromArgs := len(GlobalArgs.Endpoints) > 0
+		for _, configFile := range powerCmdFlags.configFiles {
+			if err := processModelineAndUpdateGlobals(configFile, nodesFromArgs, endpointsFromArgs, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(GlobalArgs.Nodes) == 0 {
+			return fmt.Errorf("nodes are not set, use --nodes or --file")
+		}
+
+		known, err := loadKnownNodes()
+		if err != nil {
+			return err
+		}
+
+		var dial bmc.DialFunc
+		if _, err := proxyDialOptions(); err != nil {
+			return err
+		}
+		if proxyDial != nil {
+			dial = func(ctx context.Context, _, addr string) (net.Conn, error) {
+				return proxyDial(ctx, addr)
+			}
+		}
+
+		controllers := make([]bmc.Controller, len(GlobalArgs.Nodes))
+		for i, node := range GlobalArgs.Nodes {
+			record, ok := known[node]
+			if !ok || record.BMC == nil {
+				return fmt.Errorf("BMC of node %s is not declared in %s", node, knownNodesFile)
+			}
+			if controllers[i], err = bmc.New(*record.BMC, dial); err != nil {
+				return fmt.Errorf("node %s: %w", node, err)
+			}
+		}
+
+		ctx := cmd.Context()
+		if args[0] == "status" {
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+			fmt.Fprintln(w, "NODE\tBMC\tPOWER")
+			for i, node := range GlobalArgs.Nodes {
+				state, err := controllers[i].PowerState(ctx)
+				if err != nil {
+					state = "error: " + err.Error()
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\n", node, known[node].BMC.Address, state)
+			}
+			return w.Flush()
+		}
+
+		action, err := bmc.ParseAction(args[0])
+		if err != nil {
+			return err
+		}
+		if action != bmc.On && !powerCmdFlags.yes &&
+			!helpers.Confirm(fmt.Sprintf("Power %s %d node(s) %v? Running workloads are interrupted.", action, len(GlobalArgs.Nodes), GlobalArgs.Nodes)) {
+			return nil
+		}
+
+		for i, node := range GlobalArgs.Nodes {
+			if err := controllers[i].Power(ctx, action); err != nil {
+				return fmt.Errorf("error powering %s node %s: %w", action, node, err)
+			}
+			fmt.Fprintf(os.Stderr, "- talm: node=%s, power %s\n", node, action)
+		}
+		return nil
+	},
+}
+
+func init() {
+	powerCmd.Flags().StringSliceVarP(&powerCmdFlags.configFiles, "file", "f", nil, "specify node files to take the nodes from (can specify multiple)")
+	powerCmd.Flags().BoolVarP(&powerCmdFlags.yes, "yes", "y", false, "do not ask for confirmation")
+
+	addCommand(powerCmd)
+}
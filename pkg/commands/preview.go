@@ -0,0 +1,239 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aenix-io/talm/pkg/engine"
+	"github.com/aenix-io/talm/pkg/fileutil"
+	"github.com/aenix-io/talm/pkg/modeline"
+	"github.com/aenix-io/talm/pkg/preview"
+	"github.com/spf13/cobra"
+)
+
+var previewCmdFlags struct {
+	configFiles []string
+	listen      string
+	format      string
+	output      string
+}
+
+var previewCmd = &cobra.Command{
+	Use:   "preview",
+	Short: "Serve the rendered configs, diffs and value sources of the node files",
+	Long: `Render every node file from its modeline and serve a local web page showing, per node
+file, the rendered config, its diff from the committed file and the values the templates saw
+with the file, flag or modeline which set them. The page can be searched, reloading it renders
+the node files again.
+
+By default the node files of the project and of all workspaces are rendered, or of the selected
+workspace only. Secret values are shown as secret and are never decrypted.
+
+With --output the page is written to the file instead, e.g. to publish it from CI:
+
+  talm preview --offline --without-secrets --output preview.html`,
+	Args: cobra.NoArgs,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if previewCmdFlags.format != "html" && previewCmdFlags.format != "json" {
+			return fmt.Errorf("unknown preview format %q, use html or json", previewCmdFlags.format)
+		}
+		// Flags not defined for preview are never changed, so the defaults are taken from Chart.yaml
+		return templateCmd.PreRunE(cmd, args)
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// The node files are rendered with the shared template flags, one page at a time
+		var mu sync.Mutex
+		load := func() ([]preview.Environment, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			return previewEnvironments(cmd, args)
+		}
+
+		if previewCmdFlags.output != "" {
+			environments, err := load()
+			if err != nil {
+				return err
+			}
+			var page bytes.Buffer
+			if previewCmdFlags.format == "json" {
+				encoder := json.NewEncoder(&page)
+				encoder.SetIndent("", "  ")
+				err = encoder.Encode(environments)
+			} else {
+				err = preview.WriteHTML(&page, environments, time.Now())
+			}
+			if err != nil {
+				return err
+			}
+			if previewCmdFlags.output == "-" {
+				_, err = os.Stdout.Write(page.Bytes())
+				return err
+			}
+			return os.WriteFile(previewCmdFlags.output, page.Bytes(), 0o644)
+		}
+
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt)
+		defer stop()
+
+		server := &http.Server{Addr: previewCmdFlags.listen, Handler: preview.Handler(load), ReadHeaderTimeout: 10 * time.Second}
+		errs := make(chan error, 1)
+		go func() {
+			errs <- server.ListenAndServe()
+		}()
+		fmt.Fprintf(os.Stderr, "Serving the preview on http://%s, press Ctrl+C to stop\n", previewCmdFlags.listen)
+
+		select {
+		case err := <-errs:
+			return err
+		case <-ctx.Done():
+		}
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return server.Shutdown(shutdown)
+	},
+}
+
+// previewEnvironments renders the node files of the project, or the selected workspace, and of
+// the workspaces. Every workspace is rendered by another run of talm, since its talosconfig,
+// secrets and values replace the ones of the project.
+func previewEnvironments(cmd *cobra.Command, args []string) ([]preview.Environment, error) {
+	files := previewCmdFlags.configFiles
+	if len(files) == 0 {
+		var err error
+		files, err = defaultNodeFiles()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	environment := preview.Environment{Name: Config.Workspace, Nodes: []preview.Node{}}
+	for _, file := range files {
+		environment.Nodes = append(environment.Nodes, previewNode(args, file))
+	}
+	environments := []preview.Environment{environment}
+	if Config.Workspace != "" || len(previewCmdFlags.configFiles) > 0 {
+		return environments, nil
+	}
+
+	dirs, err := filepath.Glob(filepath.Join(Config.RootDir, workspacesDir, "*"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			environments = append(environments, previewWorkspace(cmd, filepath.Base(dir)))
+		}
+	}
+
+	return environments, nil
+}
+
+// previewNode renders the node file, the failures are shown on the page.
+func previewNode(args []string, configFile string) preview.Node {
+	node := preview.Node{File: fileutil.TrimRoot(Config.RootDir, configFile)}
+
+	modelineConfig, err := modeline.ReadAndParseModeline(configFile)
+	if err != nil {
+		node.Error = fmt.Sprintf("modeline parsing failed: %s", err)
+		return node
+	}
+	node.Nodes = modelineConfig.Nodes
+	node.Templates = modelineConfig.Templates
+
+	// The values of the nodes of this file override the --set values, like in generateOutput
+	values := append([]string{}, templateCmdFlags.values...)
+	for _, address := range modelineConfig.Nodes {
+		values = append(values, templateCmdFlags.nodeSetValues[address]...)
+	}
+	node.Values, err = engine.ValueSources(engine.Options{
+		Root:          Config.RootDir,
+		Extends:       Config.TemplateOptions.Extends,
+		ValueFiles:    templateCmdFlags.valueFiles,
+		Values:        values,
+		StringValues:  templateCmdFlags.stringValues,
+		FileValues:    templateCmdFlags.fileValues,
+		JsonValues:    templateCmdFlags.jsonValues,
+		LiteralValues: templateCmdFlags.literalValues,
+		EnvValues:     templateCmdFlags.envValues,
+		NodeValues:    modelineConfig.Values,
+	})
+	if err != nil {
+		node.Error = err.Error()
+		return node
+	}
+
+	rendered, err := renderNodeFile(args, configFile)
+	if err != nil {
+		node.Error = err.Error()
+		return node
+	}
+	node.Config = string(rendered)
+	node.Diff, err = renderedDiff(configFile, rendered)
+	if err != nil {
+		node.Error = err.Error()
+	}
+
+	return node
+}
+
+// previewWorkspace renders the node files of the workspace with talm preview --workspace.
+func previewWorkspace(cmd *cobra.Command, workspace string) preview.Environment {
+	environment := preview.Environment{Name: workspace}
+
+	talm, err := os.Executable()
+	if err != nil {
+		environment.Error = err.Error()
+		return environment
+	}
+	talmArgs := []string{"--workspace=" + workspace, "preview", "--format=json", "--output=-"}
+	talmArgs = append(talmArgs, changedFlagArgs(cmd.Flags(), "workspace", "file", "listen", "format", "output")...)
+
+	var stdout, stderr bytes.Buffer
+	run := exec.Command(talm, talmArgs...)
+	run.Stdout = &stdout
+	run.Stderr = &stderr
+	if err := run.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && strings.TrimSpace(stderr.String()) != "" {
+			err = errors.New(strings.TrimSpace(stderr.String()))
+		}
+		environment.Error = err.Error()
+		return environment
+	}
+
+	var environments []preview.Environment
+	if err := json.Unmarshal(stdout.Bytes(), &environments); err != nil || len(environments) != 1 {
+		environment.Error = fmt.Sprintf("unexpected preview of workspace %s: %v", workspace, err)
+		return environment
+	}
+	return environments[0]
+}
+
+func init() {
+	previewCmd.Flags().StringSliceVarP(&previewCmdFlags.configFiles, "file", "f", nil, "specify node files to preview (defaults to nodes/*.yaml of the project and all workspaces)")
+	previewCmd.Flags().StringVar(&previewCmdFlags.listen, "listen", "127.0.0.1:8080", "address to serve the preview on")
+	previewCmd.Flags().StringVar(&previewCmdFlags.format, "format", "html", "format of --output: html or json")
+	previewCmd.Flags().StringVarP(&previewCmdFlags.output, "output", "o", "", "write the preview to the file instead of serving it, - for stdout")
+	previewCmd.Flags().BoolVarP(&templateCmdFlags.insecure, "insecure", "i", false, "template using the insecure (encrypted with no auth) maintenance service")
+	previewCmd.Flags().BoolVar(&templateCmdFlags.offline, "offline", false, "disable gathering information and lookup functions")
+	previewCmd.Flags().BoolVar(&templateCmdFlags.withoutSecrets, "without-secrets", false, "render without the secrets bundle")
+
+	addCommand(previewCmd)
+}
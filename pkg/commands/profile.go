@@ -0,0 +1,173 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aenix-io/talm/pkg/engine"
+	helmEngine "github.com/aenix-io/talm/pkg/engine/helm"
+	"github.com/aenix-io/talm/pkg/modeline"
+	"github.com/spf13/cobra"
+)
+
+var profileRenderCmdFlags struct {
+	configFile    string   // -f/--file
+	templateFiles []string // -t/--template
+	iterations    int
+	cpuProfile    string
+	memProfile    string
+}
+
+var profileCmd = &cobra.Command{
+	Use:    "profile",
+	Short:  "Profile talm internals",
+	Hidden: true,
+}
+
+var profileRenderCmd = &cobra.Command{
+	Use:   "render",
+	Short: "Write CPU and memory profiles of rendering the chart",
+	Long: `Render the templates offline a number of times and write pprof profiles of the renders,
+to find the slow helpers of template-heavy charts:
+
+  talm profile render -f nodes/node1.yaml --iterations 50
+  go tool pprof -top cpu.pprof
+
+The memory profile records the allocations of all renders.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if profileRenderCmdFlags.iterations < 1 {
+			return fmt.Errorf("--iterations must be at least 1")
+		}
+
+		opts := engine.Options{
+			Offline:           true,
+			Root:              Config.RootDir,
+			Extends:           Config.TemplateOptions.Extends,
+			ValueFiles:        Config.TemplateOptions.ValueFiles,
+			Values:            Config.TemplateOptions.Values,
+			StringValues:      Config.TemplateOptions.StringValues,
+			FileValues:        Config.TemplateOptions.FileValues,
+			JsonValues:        Config.TemplateOptions.JsonValues,
+			LiteralValues:     Config.TemplateOptions.LiteralValues,
+			EnvAllowlist:      Config.TemplateOptions.EnvAllowlist,
+			Plugins:           Config.TemplateOptions.Plugins,
+			TalosVersion:      Config.TemplateOptions.TalosVersion,
+			WithSecrets:       Config.TemplateOptions.WithSecrets,
+			KubernetesVersion: Config.TemplateOptions.KubernetesVersion,
+			TemplateFiles:     profileRenderCmdFlags.templateFiles,
+		}
+		if profileRenderCmdFlags.configFile != "" {
+			modelineConfig, err := modeline.ReadAndParseModeline(profileRenderCmdFlags.configFile)
+			if err != nil {
+				return err
+			}
+			opts.NodeValues = modelineConfig.Values
+			if opts.Topology, err = nodeTopology(modelineConfig.Nodes); err != nil {
+				return err
+			}
+			if len(opts.TemplateFiles) == 0 {
+				opts.TemplateFiles = modelineConfig.Templates
+			}
+		}
+		if len(opts.TemplateFiles) == 0 {
+			return fmt.Errorf("templates are not set, use --file or --template")
+		}
+
+		// The first render is not profiled, it fails fast on template errors
+		ctx := context.Background()
+		if err := engine.RenderTo(ctx, nil, opts, io.Discard); err != nil {
+			return err
+		}
+
+		cpu, err := os.Create(profileRenderCmdFlags.cpuProfile)
+		if err != nil {
+			return err
+		}
+		//nolint:errcheck
+		defer cpu.Close()
+
+		runtime.GC()
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+
+		if err := pprof.StartCPUProfile(cpu); err != nil {
+			return err
+		}
+		start := time.Now()
+		for i := 0; i < profileRenderCmdFlags.iterations; i++ {
+			if err := engine.RenderTo(ctx, nil, opts, io.Discard); err != nil {
+				pprof.StopCPUProfile()
+				return err
+			}
+		}
+		elapsed := time.Since(start)
+		pprof.StopCPUProfile()
+
+		runtime.ReadMemStats(&after)
+
+		mem, err := os.Create(profileRenderCmdFlags.memProfile)
+		if err != nil {
+			return err
+		}
+		//nolint:errcheck
+		defer mem.Close()
+		if err := pprof.Lookup("allocs").WriteTo(mem, 0); err != nil {
+			return err
+		}
+
+		n := uint64(profileRenderCmdFlags.iterations)
+		fmt.Fprintf(os.Stderr, "Rendered %s %d times in %s: %s, %d allocations and %s allocated per render\n",
+			opts.TemplateFiles, n, elapsed.Round(time.Millisecond), (elapsed / time.Duration(n)).Round(time.Microsecond),
+			(after.Mallocs-before.Mallocs)/n, humanBytes((after.TotalAlloc-before.TotalAlloc)/n))
+		fmt.Fprintf(os.Stderr, "Wrote %s and %s, inspect them with `go tool pprof`\n", profileRenderCmdFlags.cpuProfile, profileRenderCmdFlags.memProfile)
+
+		return nil
+	},
+}
+
+// humanBytes formats a size in bytes with a binary unit.
+func humanBytes(size uint64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := uint64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// printTemplateProfile writes the time spent in the templates, the slowest first. Self time
+// excludes the named templates included from the template.
+func printTemplateProfile(w io.Writer, profile *helmEngine.Profile) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(tw, "TEMPLATE\tCALLS\tTOTAL\tSELF")
+	for _, entry := range profile.Entries() {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", entry.Name, entry.Calls, entry.Total.Round(time.Microsecond), entry.Self.Round(time.Microsecond))
+	}
+	return tw.Flush()
+}
+
+func init() {
+	profileRenderCmd.Flags().StringVarP(&profileRenderCmdFlags.configFile, "file", "f", "", "node file to take the templates and values from")
+	profileRenderCmd.Flags().StringSliceVarP(&profileRenderCmdFlags.templateFiles, "template", "t", nil, "templates to render, instead of the ones of the node file")
+	profileRenderCmd.Flags().IntVar(&profileRenderCmdFlags.iterations, "iterations", 10, "number of renders")
+	profileRenderCmd.Flags().StringVar(&profileRenderCmdFlags.cpuProfile, "cpuprofile", "cpu.pprof", "file to write the CPU profile to")
+	profileRenderCmd.Flags().StringVar(&profileRenderCmdFlags.memProfile, "memprofile", "mem.pprof", "file to write the memory profile to")
+
+	profileCmd.AddCommand(profileRenderCmd)
+	addCommand(profileCmd)
+}
@@ -0,0 +1,71 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+
+	"github.com/aenix-io/talm/pkg/proxy"
+	"github.com/siderolabs/crypto/x509"
+	"google.golang.org/grpc"
+
+	"github.com/siderolabs/talos/pkg/cli"
+	"github.com/siderolabs/talos/pkg/machinery/client"
+)
+
+// ProxyURL is the SOCKS5 proxy or SSH bastion to reach the Talos API through, e.g. ssh://user@bastion.
+var ProxyURL string
+
+// proxyDial is created once, so the SSH connection to the bastion is shared by all clients of the command.
+var proxyDial proxy.DialFunc
+
+// proxyDialOptions returns the gRPC dial options routing connections through the proxy, if it is set.
+func proxyDialOptions() ([]grpc.DialOption, error) {
+	if ProxyURL == "" {
+		return nil, nil
+	}
+
+	if proxyDial == nil {
+		var err error
+		proxyDial, err = proxy.New(ProxyURL)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return []grpc.DialOption{grpc.WithContextDialer(proxyDial)}, nil
+}
+
+// withClientMaintenanceProxy is GlobalArgs.WithClientMaintenance connecting through the proxy.
+func withClientMaintenanceProxy(enforceFingerprints []string, dialOptions []grpc.DialOption, action func(context.Context, *client.Client) error) error {
+	return cli.WithContext(context.Background(), func(ctx context.Context) error {
+		tlsConfig := &tls.Config{
+			InsecureSkipVerify: true,
+		}
+
+		if len(enforceFingerprints) > 0 {
+			fingerprints := make([]x509.Fingerprint, len(enforceFingerprints))
+			for i, stringFingerprint := range enforceFingerprints {
+				var err error
+				fingerprints[i], err = x509.ParseFingerprint(stringFingerprint)
+				if err != nil {
+					return fmt.Errorf("error parsing certificate fingerprint %q: %v", stringFingerprint, err)
+				}
+			}
+
+			tlsConfig.VerifyConnection = x509.MatchSPKIFingerprints(fingerprints...)
+		}
+
+		c, err := client.New(ctx, client.WithTLSConfig(tlsConfig), client.WithEndpoints(GlobalArgs.Nodes...), client.WithGRPCDialOptions(dialOptions...))
+		if err != nil {
+			return err
+		}
+		defer c.Close() //nolint:errcheck
+
+		return action(ctx, c)
+	})
+}
@@ -0,0 +1,221 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aenix-io/talm/pkg/modeline"
+	"github.com/cosi-project/runtime/pkg/safe"
+	"github.com/spf13/cobra"
+
+	"github.com/siderolabs/talos/cmd/talosctl/pkg/talos/helpers"
+	machineapi "github.com/siderolabs/talos/pkg/machinery/api/machine"
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	"github.com/siderolabs/talos/pkg/machinery/resources/cluster"
+)
+
+var pruneCmdFlags struct {
+	configFiles []string
+	reset       bool
+	yes         bool
+}
+
+// orphanNode is a cluster member not declared in any node file.
+type orphanNode struct {
+	source    string
+	hostname  string
+	addresses []string
+	kind      string
+}
+
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Find cluster members which are not declared in node files",
+	Long: `Compare cluster membership with the declared inventory and report orphaned nodes.
+
+Members are discovered with Talos cluster discovery and etcd member list.
+The inventory is built from the modelines of the node files, by default all
+the files in the nodes/ directory. Use --reset to reset the orphaned nodes.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		files := pruneCmdFlags.configFiles
+		if len(files) == 0 {
+			var err error
+			files, err = defaultNodeFiles()
+			if err != nil {
+				return err
+			}
+		}
+		if len(files) == 0 {
+			return errors.New("no node files found: please use `--file` flag to set the node files of the inventory")
+		}
+
+		declared := map[string]struct{}{}
+		endpointsFromArgs := len(GlobalArgs.Endpoints) > 0
+		for _, file := range files {
+			if err := checkWorkspaceFile(file); err != nil {
+				return err
+			}
+			modelineConfig, err := modeline.ReadAndParseModeline(file)
+			if err != nil {
+				return fmt.Errorf("modeline parsing failed for %s: %w", file, err)
+			}
+			for _, node := range modelineConfig.Nodes {
+				declared[node] = struct{}{}
+			}
+			if !endpointsFromArgs && len(GlobalArgs.Endpoints) == 0 {
+				GlobalArgs.Endpoints = modelineConfig.Endpoints
+			}
+		}
+
+		return WithClientNoNodes(func(ctx context.Context, c *client.Client) error {
+			if len(GlobalArgs.Nodes) > 0 {
+				ctx = client.WithNode(ctx, GlobalArgs.Nodes[0])
+			}
+
+			orphans, err := findOrphans(ctx, c, declared)
+			if err != nil {
+				return err
+			}
+
+			if len(orphans) == 0 {
+				fmt.Println("No orphaned nodes found.")
+				return nil
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+			fmt.Fprintln(w, "SOURCE\tHOSTNAME\tADDRESSES\tTYPE")
+			for _, orphan := range orphans {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", orphan.source, orphan.hostname, strings.Join(orphan.addresses, ","), orphan.kind)
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+
+			if !pruneCmdFlags.reset {
+				return nil
+			}
+
+			return resetOrphans(ctx, c, orphans)
+		})
+	},
+}
+
+func findOrphans(ctx context.Context, c *client.Client, declared map[string]struct{}) ([]orphanNode, error) {
+	isDeclared := func(names ...string) bool {
+		for _, name := range names {
+			if _, ok := declared[name]; ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	var orphans []orphanNode
+
+	members, err := safe.StateListAll[*cluster.Member](ctx, c.COSI)
+	if err != nil {
+		return nil, fmt.Errorf("error listing cluster members: %w", err)
+	}
+
+	// Hostnames known to discovery, to avoid reporting the same node twice from etcd
+	seen := map[string]struct{}{}
+	for it := members.Iterator(); it.Next(); {
+		spec := it.Value().TypedSpec()
+		seen[spec.Hostname] = struct{}{}
+
+		addresses := make([]string, 0, len(spec.Addresses))
+		for _, address := range spec.Addresses {
+			addresses = append(addresses, address.String())
+		}
+
+		if isDeclared(append(addresses, spec.Hostname)...) {
+			continue
+		}
+
+		orphans = append(orphans, orphanNode{
+			source:    "discovery",
+			hostname:  spec.Hostname,
+			addresses: addresses,
+			kind:      spec.MachineType.String(),
+		})
+	}
+
+	response, err := c.EtcdMemberList(ctx, &machineapi.EtcdMemberListRequest{QueryLocal: true})
+	if err != nil {
+		// Etcd is available on control plane nodes only
+		fmt.Fprintf(os.Stderr, "Warning: skipping etcd members: %s\n", err)
+		return orphans, nil
+	}
+
+	for _, message := range response.Messages {
+		for _, member := range message.Members {
+			if _, ok := seen[member.Hostname]; ok {
+				continue
+			}
+
+			var addresses []string
+			for _, peerURL := range member.PeerUrls {
+				if u, err := url.Parse(peerURL); err == nil {
+					addresses = append(addresses, u.Hostname())
+				}
+			}
+
+			if isDeclared(append(addresses, member.Hostname)...) {
+				continue
+			}
+
+			orphans = append(orphans, orphanNode{
+				source:    "etcd",
+				hostname:  member.Hostname,
+				addresses: addresses,
+				kind:      "controlplane",
+			})
+		}
+	}
+
+	return orphans, nil
+}
+
+func resetOrphans(ctx context.Context, c *client.Client, orphans []orphanNode) error {
+	for _, orphan := range orphans {
+		if orphan.source != "discovery" || len(orphan.addresses) == 0 {
+			fmt.Fprintf(os.Stderr, "Skipping %s: not reachable via Talos API, remove it with `talm etcd remove-member`\n", orphan.hostname)
+			continue
+		}
+
+		if !pruneCmdFlags.yes {
+			if !helpers.Confirm(fmt.Sprintf("Reset node %s (%s)?", orphan.hostname, orphan.addresses[0])) {
+				continue
+			}
+		}
+
+		fmt.Fprintf(os.Stderr, "Resetting node %s\n", orphan.hostname)
+
+		if err := c.ResetGeneric(client.WithNode(ctx, orphan.addresses[0]), &machineapi.ResetRequest{
+			Graceful: true,
+			Reboot:   false,
+		}); err != nil {
+			return fmt.Errorf("error resetting node %s: %w", orphan.hostname, err)
+		}
+	}
+
+	return nil
+}
+
+func init() {
+	pruneCmd.Flags().StringSliceVarP(&pruneCmdFlags.configFiles, "file", "f", nil, "specify node files of the inventory (defaults to nodes/*.yaml)")
+	pruneCmd.Flags().BoolVar(&pruneCmdFlags.reset, "reset", false, "gracefully reset the orphaned nodes")
+	pruneCmd.Flags().BoolVarP(&pruneCmdFlags.yes, "yes", "y", false, "do not ask for confirmation before reset")
+
+	addCommand(pruneCmd)
+}
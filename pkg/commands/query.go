@@ -0,0 +1,225 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aenix-io/talm/pkg/engine"
+	"github.com/aenix-io/talm/pkg/modeline"
+	"github.com/aenix-io/talm/pkg/query"
+	"github.com/cosi-project/runtime/pkg/safe"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	"github.com/siderolabs/talos/pkg/machinery/config/encoder"
+	configres "github.com/siderolabs/talos/pkg/machinery/resources/config"
+)
+
+var queryCmdFlags struct {
+	configFiles       []string
+	live              bool
+	output            string
+	talosVersion      string
+	withSecrets       string
+	kubernetesVersion string
+}
+
+// queryRow holds the results of the queries for a node.
+type queryRow struct {
+	Node    string                   `json:"node"`
+	File    string                   `json:"file"`
+	Results map[string][]interface{} `json:"results,omitempty"`
+	Error   string                   `json:"error,omitempty"`
+}
+
+var queryCmd = &cobra.Command{
+	Use:   "query <expression>...",
+	Short: "Query configs across all nodes",
+	Long: `Evaluate jq-style path expressions on the configs of all nodes and print a table keyed by node,
+to audit the consistency across the fleet, e.g.:
+
+  talm query '.machine.install.disk' '.machine.network.interfaces[].interface'
+
+By default the configs are rendered from the node files, with --live the current
+configs are read from the nodes. Node files default to nodes/*.yaml.`,
+	Args: cobra.MinimumNArgs(1),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if !cmd.Flags().Changed("talos-version") {
+			queryCmdFlags.talosVersion = Config.TemplateOptions.TalosVersion
+		}
+		if !cmd.Flags().Changed("with-secrets") {
+			queryCmdFlags.withSecrets = Config.TemplateOptions.WithSecrets
+		}
+		if !cmd.Flags().Changed("kubernetes-version") {
+			queryCmdFlags.kubernetesVersion = Config.TemplateOptions.KubernetesVersion
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if queryCmdFlags.output != "table" && queryCmdFlags.output != "json" {
+			return fmt.Errorf("invalid output format %q, must be table or json", queryCmdFlags.output)
+		}
+
+		queries := make([]*query.Query, 0, len(args))
+		for _, arg := range args {
+			q, err := query.Compile(arg)
+			if err != nil {
+				return err
+			}
+			queries = append(queries, q)
+		}
+
+		files := queryCmdFlags.configFiles
+		if len(files) == 0 {
+			var err error
+			files, err = defaultNodeFiles()
+			if err != nil {
+				return err
+			}
+			if len(files) == 0 {
+				return fmt.Errorf("no node files found, use -f to specify them")
+			}
+		}
+
+		var rows []queryRow
+		collect := func(ctx context.Context, c *client.Client) error {
+			for _, file := range files {
+				fileRows, err := queryFile(ctx, c, file, queries)
+				if err != nil {
+					return err
+				}
+				rows = append(rows, fileRows...)
+			}
+			return nil
+		}
+
+		var err error
+		if queryCmdFlags.live {
+			err = WithClientNoNodes(collect)
+		} else {
+			err = collect(cmd.Context(), nil)
+		}
+		if err != nil {
+			return err
+		}
+
+		if queryCmdFlags.output == "json" {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(rows)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		header := []string{"NODE", "FILE"}
+		for _, q := range queries {
+			header = append(header, q.String())
+		}
+		fmt.Fprintln(w, strings.Join(header, "\t"))
+		for _, row := range rows {
+			line := []string{row.Node, row.File}
+			for _, q := range queries {
+				if row.Error != "" {
+					line = append(line, "error: "+row.Error)
+					continue
+				}
+				line = append(line, query.Format(row.Results[q.String()]))
+			}
+			fmt.Fprintln(w, strings.Join(line, "\t"))
+		}
+		return w.Flush()
+	},
+}
+
+// queryFile evaluates the queries on the config of every node of the file.
+// Without a client the config is rendered from the file, which is the same for all its nodes.
+func queryFile(ctx context.Context, c *client.Client, file string, queries []*query.Query) ([]queryRow, error) {
+	modelineConfig, err := modeline.ReadAndParseModeline(file)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing modeline of %s: %w", file, err)
+	}
+
+	nodes := modelineConfig.Nodes
+	if len(nodes) == 0 {
+		nodes = []string{""}
+	}
+
+	var rendered []byte
+	if c == nil {
+		configBundle, err := engine.FullConfigProcess(ctx, engine.Options{
+			TalosVersion:      queryCmdFlags.talosVersion,
+			WithSecrets:       queryCmdFlags.withSecrets,
+			KubernetesVersion: queryCmdFlags.kubernetesVersion,
+		}, []string{"@" + file})
+		if err != nil {
+			return nil, fmt.Errorf("full config processing error of %s: %s", file, err)
+		}
+
+		rendered, err = engine.SerializeConfiguration(configBundle, configBundle.ControlPlaneCfg.Machine().Type())
+		if err != nil {
+			return nil, fmt.Errorf("error serializing configuration of %s: %s", file, err)
+		}
+	}
+
+	rows := make([]queryRow, 0, len(nodes))
+	for _, node := range nodes {
+		row := queryRow{Node: node, File: file}
+
+		data := rendered
+		if c != nil {
+			data, err = liveConfig(ctx, c, node)
+			if err != nil {
+				row.Error = err.Error()
+				rows = append(rows, row)
+				continue
+			}
+		}
+
+		// The first document is the v1alpha1 config
+		var doc interface{}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("error parsing config of %s: %w", file, err)
+		}
+
+		row.Results = map[string][]interface{}{}
+		for _, q := range queries {
+			row.Results[q.String()] = q.Eval(doc)
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// liveConfig reads the current config of the node.
+func liveConfig(ctx context.Context, c *client.Client, node string) ([]byte, error) {
+	if node == "" {
+		return nil, fmt.Errorf("no nodes in the modeline")
+	}
+
+	machineConfig, err := safe.StateGetByID[*configres.MachineConfig](client.WithNode(ctx, node), c.COSI, configres.V1Alpha1ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return machineConfig.Provider().EncodeBytes(encoder.WithComments(encoder.CommentsDisabled))
+}
+
+func init() {
+	queryCmd.Flags().StringSliceVarP(&queryCmdFlags.configFiles, "file", "f", nil, "specify node files to query (defaults to nodes/*.yaml)")
+	queryCmd.Flags().BoolVar(&queryCmdFlags.live, "live", false, "query the current configs of the nodes instead of the rendered ones")
+	queryCmd.Flags().StringVarP(&queryCmdFlags.output, "output", "o", "table", "output format: table or json")
+	queryCmd.Flags().StringVar(&queryCmdFlags.talosVersion, "talos-version", "", "the desired Talos version to generate config for (backwards compatibility, e.g. v0.8)")
+	queryCmd.Flags().StringVar(&queryCmdFlags.withSecrets, "with-secrets", "", "use a secrets file generated using 'gen secrets', - reads it from stdin")
+	queryCmd.Flags().StringVar(&queryCmdFlags.kubernetesVersion, "kubernetes-version", "", "desired kubernetes version to run")
+
+	addCommand(queryCmd)
+}
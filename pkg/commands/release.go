@@ -0,0 +1,311 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aenix-io/talm/pkg/engine"
+	"github.com/aenix-io/talm/pkg/release"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/siderolabs/talos/cmd/talosctl/pkg/talos/helpers"
+)
+
+// releasesDir is the path of the releases relative to the project root or the workspace.
+const releasesDir = ".talm/releases"
+
+// releaseStorageKubernetes stores the releases in secrets of the cluster.
+const releaseStorageKubernetes = "kubernetes"
+
+// rollbackOf is the release rolled back to by the running command.
+var rollbackOf int
+
+var releaseRollbackCmdFlags struct {
+	noApply bool
+}
+
+// releaseStorage returns the storage of releaseOptions in Chart.yaml, the local state
+// directory by default.
+func releaseStorage() (release.Storage, error) {
+	switch Config.ReleaseOptions.Storage {
+	case "", "local":
+		return release.Dir{Path: filepath.Join(stateDir(), releasesDir)}, nil
+	case releaseStorageKubernetes:
+		clientset, err := kubernetesClient(Config.ReleaseOptions.Kubeconfig)
+		if err != nil {
+			return nil, err
+		}
+		namespace := Config.ReleaseOptions.Namespace
+		if namespace == "" {
+			namespace = "kube-system"
+		}
+		name := Config.Workspace
+		if name == "" {
+			chrt, err := engine.LoadChart(Config.RootDir, Config.TemplateOptions.Extends)
+			if err != nil {
+				return nil, err
+			}
+			name = chrt.Name()
+		}
+		return release.Secrets{Client: clientset, Namespace: namespace, Name: name}, nil
+	default:
+		return nil, fmt.Errorf("unknown releaseOptions.storage %q, use local or %s", Config.ReleaseOptions.Storage, releaseStorageKubernetes)
+	}
+}
+
+// recordRelease stores the node files applied by the command as a new release.
+func recordRelease(ctx context.Context, files []release.File) error {
+	storage, err := releaseStorage()
+	if err != nil {
+		return err
+	}
+
+	r := release.Release{
+		Time:       time.Now().UTC(),
+		RollbackOf: rollbackOf,
+		Files:      files,
+	}
+	if chrt, err := engine.LoadChart(Config.RootDir, Config.TemplateOptions.Extends); err == nil {
+		r.ChartName, r.ChartVersion = chrt.Name(), chrt.Metadata.Version
+	}
+	values, err := engine.Values(engine.Options{
+		Root:          Config.RootDir,
+		Extends:       Config.TemplateOptions.Extends,
+		ValueFiles:    Config.TemplateOptions.ValueFiles,
+		Values:        Config.TemplateOptions.Values,
+		StringValues:  Config.TemplateOptions.StringValues,
+		FileValues:    Config.TemplateOptions.FileValues,
+		JsonValues:    Config.TemplateOptions.JsonValues,
+		LiteralValues: Config.TemplateOptions.LiteralValues,
+	})
+	if err == nil {
+		// Maps are encoded with sorted keys, so the hash is stable
+		if data, err := json.Marshal(values); err == nil {
+			r.ValuesHash = configHash(data)
+		}
+	}
+
+	if r.Number, err = release.Next(ctx, storage); err != nil {
+		return err
+	}
+	if err := storage.Create(ctx, r); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Recorded release %d\n", r.Number)
+	return nil
+}
+
+// releaseFile describes the node file applied to the nodes for the release.
+func releaseFile(configFile string, nodes []string, hash string) (release.File, error) {
+	content, err := os.ReadFile(configFile)
+	if err != nil {
+		return release.File{}, err
+	}
+	return release.File{
+		Path:       configFile,
+		Nodes:      append([]string(nil), nodes...),
+		ConfigHash: hash,
+		Content:    string(content),
+	}, nil
+}
+
+func parseReleaseNumber(arg string) (int, error) {
+	number, err := strconv.Atoi(arg)
+	if err != nil || number < 1 {
+		return 0, fmt.Errorf("invalid release number %q", arg)
+	}
+	return number, nil
+}
+
+// releaseStatus is a release printed by release status, without the content of the node files.
+type releaseStatus struct {
+	Release    int                 `yaml:"release"`
+	Updated    time.Time           `yaml:"updated"`
+	Chart      string              `yaml:"chart"`
+	Version    string              `yaml:"version"`
+	ValuesHash string              `yaml:"valuesHash"`
+	RollbackOf int                 `yaml:"rollbackOf,omitempty"`
+	Files      []releaseStatusFile `yaml:"files"`
+}
+
+type releaseStatusFile struct {
+	Path       string   `yaml:"path"`
+	Nodes      []string `yaml:"nodes"`
+	ConfigHash string   `yaml:"configHash"`
+}
+
+var releaseCmd = &cobra.Command{
+	Use:   "release",
+	Short: "List, compare and roll back the releases recorded by apply",
+	Long: `Every apply changing the nodes is recorded as a numbered release: the chart version, the hash
+of the values, the nodes touched and the node files applied. The releases are stored in
+` + releasesDir + `, or in secrets of the cluster with releaseOptions.storage set to kubernetes
+in Chart.yaml.`,
+}
+
+var releaseListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the releases",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		storage, err := releaseStorage()
+		if err != nil {
+			return err
+		}
+		releases, err := storage.List(cmd.Context())
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "RELEASE\tUPDATED\tCHART\tVALUES\tNODES\tDESCRIPTION")
+		for _, r := range releases {
+			description := fmt.Sprintf("applied %d file(s)", len(r.Files))
+			if r.RollbackOf > 0 {
+				description = fmt.Sprintf("rollback to %d", r.RollbackOf)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s-%s\t%.12s\t%s\t%s\n", r.Number, r.Time.Format(time.RFC3339), r.ChartName, r.ChartVersion, r.ValuesHash, strings.Join(r.Nodes(), ","), description)
+		}
+		return w.Flush()
+	},
+}
+
+var releaseStatusCmd = &cobra.Command{
+	Use:   "status [<release>]",
+	Short: "Show a release, the latest one by default",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		storage, err := releaseStorage()
+		if err != nil {
+			return err
+		}
+
+		var r release.Release
+		if len(args) == 1 {
+			number, err := parseReleaseNumber(args[0])
+			if err != nil {
+				return err
+			}
+			if r, err = storage.Get(cmd.Context(), number); err != nil {
+				return err
+			}
+		} else {
+			releases, err := storage.List(cmd.Context())
+			if err != nil {
+				return err
+			}
+			if len(releases) == 0 {
+				return fmt.Errorf("no releases recorded yet")
+			}
+			r = releases[len(releases)-1]
+		}
+
+		status := releaseStatus{Release: r.Number, Updated: r.Time, Chart: r.ChartName, Version: r.ChartVersion, ValuesHash: r.ValuesHash, RollbackOf: r.RollbackOf}
+		for _, file := range r.Files {
+			status.Files = append(status.Files, releaseStatusFile{Path: file.Path, Nodes: file.Nodes, ConfigHash: file.ConfigHash})
+		}
+
+		encoder := yaml.NewEncoder(os.Stdout)
+		encoder.SetIndent(2)
+		return encoder.Encode(status)
+	},
+}
+
+var releaseDiffCmd = &cobra.Command{
+	Use:   "diff <release> <release>",
+	Short: "Show the changes of the node files between two releases",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		storage, err := releaseStorage()
+		if err != nil {
+			return err
+		}
+
+		var releases [2]release.Release
+		for i, arg := range args {
+			number, err := parseReleaseNumber(arg)
+			if err != nil {
+				return err
+			}
+			if releases[i], err = storage.Get(cmd.Context(), number); err != nil {
+				return err
+			}
+		}
+
+		diff, err := release.Diff(releases[0], releases[1])
+		if err != nil {
+			return err
+		}
+		fmt.Print(diff)
+		return nil
+	},
+}
+
+var releaseRollbackCmd = &cobra.Command{
+	Use:   "rollback <release>",
+	Short: "Restore the node files of a release and apply them",
+	Long: `Write the node files of the release back to the project, so the repository matches the
+nodes again, and apply them like talm apply. The apply is recorded as a new release.`,
+	Args: cobra.ExactArgs(1),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		// Flags not defined for release rollback are never changed, so the defaults are taken from Chart.yaml
+		return applyCmd.PreRunE(cmd, args)
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		number, err := parseReleaseNumber(args[0])
+		if err != nil {
+			return err
+		}
+		storage, err := releaseStorage()
+		if err != nil {
+			return err
+		}
+		r, err := storage.Get(cmd.Context(), number)
+		if err != nil {
+			return err
+		}
+
+		applyCmdFlags.configFiles = nil
+		for _, file := range r.Files {
+			if err := checkWorkspaceFile(file.Path); err != nil {
+				return err
+			}
+			if err := os.MkdirAll(filepath.Dir(file.Path), 0o755); err != nil {
+				return err
+			}
+			if err := os.WriteFile(file.Path, []byte(file.Content), 0o644); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Restored %s from release %d\n", file.Path, r.Number)
+			applyCmdFlags.configFiles = append(applyCmdFlags.configFiles, file.Path)
+		}
+		if releaseRollbackCmdFlags.noApply {
+			return nil
+		}
+
+		rollbackOf = r.Number
+		return WithClientNoNodes(apply(nil))
+	},
+}
+
+func init() {
+	releaseRollbackCmd.Flags().BoolVar(&releaseRollbackCmdFlags.noApply, "no-apply", false, "only restore the node files")
+	releaseRollbackCmd.Flags().BoolVar(&applyCmdFlags.dryRun, "dry-run", false, "check how the config change will be applied in dry-run mode")
+	helpers.AddModeFlags(&applyCmdFlags.Mode, releaseRollbackCmd)
+
+	releaseCmd.AddCommand(releaseListCmd, releaseStatusCmd, releaseDiffCmd, releaseRollbackCmd)
+	addCommand(releaseCmd)
+}
@@ -0,0 +1,204 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aenix-io/talm/pkg/fileutil"
+	"github.com/aenix-io/talm/pkg/report"
+	"github.com/aenix-io/talm/pkg/schematic"
+	"github.com/cosi-project/runtime/pkg/safe"
+	humanize "github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	"github.com/siderolabs/talos/pkg/machinery/config/configloader"
+	"github.com/siderolabs/talos/pkg/machinery/resources/k8s"
+	"github.com/siderolabs/talos/pkg/machinery/resources/network"
+	"github.com/siderolabs/talos/pkg/machinery/resources/runtime"
+)
+
+// reportNodeTimeout limits the time to inventory a node, an unreachable node doesn't stall the report.
+const reportNodeTimeout = 30 * time.Second
+
+var reportCmdFlags struct {
+	configFiles []string
+	format      string
+	output      string
+}
+
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Write an inventory report of the nodes as CSV or HTML",
+	Long: `Collect the inventory of the nodes of the node files, which default to nodes/*.yaml:
+the Talos and kubelet versions, the schematic and extensions, the uptime, the disks and
+physical interfaces, and whether the node runs the config rendered from its node file.
+
+The report is written as CSV or as a standalone HTML page for asset management and audits.
+Nodes which can't be reached are reported with the error, they don't fail the command.`,
+	Args: cobra.NoArgs,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if reportCmdFlags.format != report.CSV && reportCmdFlags.format != report.HTML {
+			return fmt.Errorf("unknown report format %q, use %s or %s", reportCmdFlags.format, report.CSV, report.HTML)
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		files := reportCmdFlags.configFiles
+		if len(files) == 0 {
+			var err error
+			files, err = defaultNodeFiles()
+			if err != nil {
+				return err
+			}
+		}
+
+		var nodes []report.Node
+		nodesFromArgs := len(GlobalArgs.Nodes) > 0
+		endpointsFromArgs := len(GlobalArgs.Endpoints) > 0
+		for _, configFile := range files {
+			if err := processModelineAndUpdateGlobals(configFile, nodesFromArgs, endpointsFromArgs, false); err != nil {
+				return err
+			}
+
+			err := WithClientNoNodes(func(ctx context.Context, c *client.Client) error {
+				rendered, err := renderComparableConfig(ctx, c, configFile)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to render %s, the config state of its nodes is unknown: %s\n", configFile, err)
+				}
+				for _, node := range GlobalArgs.Nodes {
+					nodes = append(nodes, inventoryNode(ctx, c, node, configFile, rendered))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			if !nodesFromArgs {
+				GlobalArgs.Nodes = []string{}
+			}
+			if !endpointsFromArgs {
+				GlobalArgs.Endpoints = []string{}
+			}
+		}
+
+		if reportCmdFlags.output == "" {
+			return report.Write(os.Stdout, reportCmdFlags.format, nodes, time.Now())
+		}
+		var buf bytes.Buffer
+		if err := report.Write(&buf, reportCmdFlags.format, nodes, time.Now()); err != nil {
+			return err
+		}
+		return fileutil.WriteFile(reportCmdFlags.output, buf.Bytes(), 0o644)
+	},
+}
+
+// inventoryNode collects the inventory of the node, the errors are recorded in the report.
+// The config state is unknown if the node file wasn't rendered.
+func inventoryNode(ctx context.Context, c *client.Client, node, configFile string, rendered []byte) report.Node {
+	ctx, cancel := context.WithTimeout(client.WithNode(ctx, node), reportNodeTimeout)
+	defer cancel()
+
+	result := report.Node{Node: node, File: configFile, Config: report.Unknown}
+	var errs []string
+	record := func(what string, err error) {
+		errs = append(errs, fmt.Sprintf("%s: %s", what, err))
+	}
+
+	version, err := c.Version(ctx)
+	if err != nil {
+		// The other requests would fail the same way
+		result.Error = err.Error()
+		fmt.Fprintf(os.Stderr, "Warning: failed to reach node %s: %s\n", node, err)
+		return result
+	}
+	result.TalosVersion = version.Messages[0].GetVersion().GetTag()
+
+	if hostname, err := safe.StateGetByID[*network.HostnameStatus](ctx, c.COSI, network.HostnameID); err != nil {
+		record("hostname", err)
+	} else {
+		result.Hostname = hostname.TypedSpec().Hostname
+	}
+
+	if kubelet, err := safe.StateGetByID[*k8s.KubeletSpec](ctx, c.COSI, k8s.KubeletID); err != nil {
+		record("kubelet", err)
+	} else if image := kubelet.TypedSpec().Image; strings.LastIndex(image, ":") > strings.LastIndex(image, "/") {
+		result.KubeletVersion = image[strings.LastIndex(image, ":")+1:]
+	}
+
+	if extensions, err := safe.StateListAll[*runtime.ExtensionStatus](ctx, c.COSI); err != nil {
+		record("extensions", err)
+	} else {
+		for it := extensions.Iterator(); it.Next(); {
+			metadata := it.Value().TypedSpec().Metadata
+			if metadata.Name == schematic.ExtensionName {
+				result.Schematic = metadata.Version
+				continue
+			}
+			result.Extensions = append(result.Extensions, metadata.Name+" "+metadata.Version)
+		}
+	}
+
+	if stat, err := c.MachineClient.SystemStat(ctx, &emptypb.Empty{}); err != nil {
+		record("uptime", err)
+	} else if bootTime := stat.Messages[0].GetBootTime(); bootTime > 0 {
+		result.Uptime = time.Since(time.Unix(int64(bootTime), 0))
+	}
+
+	if disks, err := c.Disks(ctx); err != nil {
+		record("disks", err)
+	} else {
+		for _, d := range disks.Messages[0].GetDisks() {
+			result.Disks = append(result.Disks, strings.Join(strings.Fields(fmt.Sprintf("%s %s %s", d.DeviceName, d.Model, humanize.Bytes(d.Size))), " "))
+		}
+	}
+
+	if links, err := safe.StateListAll[*network.LinkStatus](ctx, c.COSI); err != nil {
+		record("interfaces", err)
+	} else {
+		for it := links.Iterator(); it.Next(); {
+			if spec := it.Value().TypedSpec(); spec.Physical() {
+				result.Interfaces = append(result.Interfaces, it.Value().Metadata().ID()+" "+spec.HardwareAddr.String())
+			}
+		}
+	}
+
+	if current, err := currentConfig(ctx, c, node); err != nil {
+		record("config", err)
+	} else {
+		if cfg, err := configloader.NewFromBytes(current); err == nil {
+			result.MachineType = cfg.Machine().Type().String()
+		}
+		switch {
+		case rendered == nil:
+		case bytes.Equal(current, rendered):
+			result.Config = report.InSync
+		default:
+			result.Config = report.Drifted
+		}
+	}
+
+	if len(errs) > 0 {
+		result.Error = strings.Join(errs, "; ")
+		fmt.Fprintf(os.Stderr, "Warning: incomplete inventory of node %s: %s\n", node, result.Error)
+	}
+	return result
+}
+
+func init() {
+	reportCmd.Flags().StringSliceVarP(&reportCmdFlags.configFiles, "file", "f", nil, "specify node files to report on (defaults to nodes/*.yaml)")
+	reportCmd.Flags().StringVar(&reportCmdFlags.format, "format", report.CSV, "format of the report: csv or html")
+	reportCmd.Flags().StringVarP(&reportCmdFlags.output, "output", "o", "", "write the report to the file instead of stdout")
+
+	addCommand(reportCmd)
+}
@@ -0,0 +1,124 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	clientconfig "github.com/siderolabs/talos/pkg/machinery/client/config"
+	"github.com/siderolabs/talos/pkg/machinery/role"
+)
+
+// runningCommand is the command checked by CheckRoles, the roles are checked again when the
+// client certificate is read to connect.
+var runningCommand *cobra.Command
+
+// talosconfigRoles returns the talosconfig context used by the commands and the roles of its
+// client certificate. The roles are unknown if they can't be read without a passphrase: the
+// talosconfig is missing or encrypted, or the context has no client certificate, e.g. with Omni.
+func talosconfigRoles() (string, role.Set, bool) {
+	path := GlobalArgs.Talosconfig
+	if path == "" || !fileExists(path) || isTalosconfigEncrypted(path) {
+		return "", role.Zero, false
+	}
+	cfg, err := clientconfig.Open(path)
+	if err != nil {
+		return "", role.Zero, false
+	}
+
+	contextName := GlobalArgs.CmdContext
+	if contextName == "" {
+		contextName = cfg.Context
+	}
+	configContext, ok := cfg.Contexts[contextName]
+	if !ok || configContext.Crt == "" {
+		return "", role.Zero, false
+	}
+	cert, err := decodeClientCertificate(configContext.Crt)
+	if err != nil {
+		return "", role.Zero, false
+	}
+
+	roles, ok := certificateRoles(cert.Subject.Organization)
+	return contextName, roles, ok
+}
+
+// certificateRoles parses the roles of a client certificate. Certificates of old Talos versions
+// have no roles, the nodes grant them the admin role.
+func certificateRoles(organization []string) (role.Set, bool) {
+	roles, _ := role.Parse(organization)
+	return roles, len(roles.Strings()) > 0
+}
+
+// rolesAllow reports whether the roles are allowed to run the commands requiring the role.
+// The commands requiring the reader role are allowed to all roles, most of them don't connect.
+func rolesAllow(roles role.Set, required role.Role) bool {
+	if required == role.Reader {
+		return true
+	}
+	for _, r := range grantingRoles(required) {
+		if roles.Includes(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkCommandRole denies the command if the roles of the talosconfig context don't allow it.
+func checkCommandRole(cmd *cobra.Command, contextName string, roles role.Set) error {
+	required, err := commandRole(cmd)
+	if err != nil {
+		return err
+	}
+	if rolesAllow(roles, required) {
+		return nil
+	}
+	return fmt.Errorf("%s requires the %s role, the client certificate of talosconfig context %q has %s: select a context with this role with --context or globalOptions.identities in Chart.yaml",
+		cmd.CommandPath(), required, contextName, strings.Join(roles.Strings(), ", "))
+}
+
+// CheckRoles denies the command before it runs if the client certificate of the talosconfig context
+// lacks the role the command requires, instead of the nodes rejecting it part way. Commands against
+// the maintenance service with --insecure don't use the certificate.
+func CheckRoles(cmd *cobra.Command) error {
+	// Completions don't run the command, they don't offer the denied ones
+	if strings.HasPrefix(cmd.Name(), cobra.ShellCompRequestCmd) {
+		HideDeniedCommands(cmd)
+		return nil
+	}
+	if insecure := cmd.Flags().Lookup("insecure"); insecure != nil && insecure.Value.String() == "true" {
+		return nil
+	}
+
+	runningCommand = cmd
+	contextName, roles, ok := talosconfigRoles()
+	if !ok {
+		return nil
+	}
+	return checkCommandRole(cmd, contextName, roles)
+}
+
+// HideDeniedCommands hides the commands the client certificate of the talosconfig context is not
+// allowed to run from the help and the completions.
+func HideDeniedCommands(cmd *cobra.Command) {
+	_, roles, ok := talosconfigRoles()
+	if !ok {
+		return
+	}
+
+	var hide func(c *cobra.Command)
+	hide = func(c *cobra.Command) {
+		for _, sub := range c.Commands() {
+			if required, err := commandRole(sub); err == nil && !rolesAllow(roles, required) {
+				sub.Hidden = true
+			}
+			hide(sub)
+		}
+	}
+	hide(cmd.Root())
+}
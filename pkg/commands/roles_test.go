@@ -0,0 +1,290 @@
+package commands
+
+import (
+	"encoding/base64"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	clientconfig "github.com/siderolabs/talos/pkg/machinery/client/config"
+	"github.com/siderolabs/talos/pkg/machinery/config/generate/secrets"
+	"github.com/siderolabs/talos/pkg/machinery/role"
+)
+
+// testCommands returns the commands of a root command named like talm by their path.
+func testCommands(paths ...string) map[string]*cobra.Command {
+	root := &cobra.Command{Use: "talm"}
+	commands := map[string]*cobra.Command{}
+	for _, path := range paths {
+		parent := root
+		for i, name := range strings.Fields(path) {
+			sub := commands[strings.Join(strings.Fields(path)[:i+1], " ")]
+			if sub == nil {
+				sub = &cobra.Command{Use: name, RunE: func(*cobra.Command, []string) error { return nil }}
+				sub.Flags().Bool("insecure", false, "")
+				parent.AddCommand(sub)
+				commands[strings.Join(strings.Fields(path)[:i+1], " ")] = sub
+			}
+			parent = sub
+		}
+	}
+	return commands
+}
+
+func TestRolesAllow(t *testing.T) {
+	for _, tt := range []struct {
+		roles    role.Set
+		required role.Role
+		allowed  bool
+	}{
+		{role.MakeSet(role.Reader), role.Reader, true},
+		{role.MakeSet(role.EtcdBackup), role.Reader, true},
+		{role.MakeSet(role.Reader), role.Operator, false},
+		{role.MakeSet(role.Reader), role.Admin, false},
+		{role.MakeSet(role.Reader), role.EtcdBackup, false},
+		{role.MakeSet(role.Operator), role.Operator, true},
+		{role.MakeSet(role.Operator), role.Admin, false},
+		{role.MakeSet(role.Operator), role.EtcdBackup, true},
+		{role.MakeSet(role.Admin), role.Admin, true},
+		{role.MakeSet(role.Admin), role.EtcdBackup, true},
+		{role.MakeSet(role.EtcdBackup), role.EtcdBackup, true},
+		{role.MakeSet(role.EtcdBackup), role.Operator, false},
+		{role.MakeSet(role.Reader, role.EtcdBackup), role.EtcdBackup, true},
+		{role.MakeSet(role.Reader, role.Operator), role.Admin, false},
+	} {
+		if allowed := rolesAllow(tt.roles, tt.required); allowed != tt.allowed {
+			t.Errorf("roles %s, required %s: expected allowed %t, got %t", tt.roles.Strings(), tt.required, tt.allowed, allowed)
+		}
+	}
+}
+
+func TestCommandRole(t *testing.T) {
+	commands := testCommands("apply", "backup", "get", "reboot", "template", "disks wipe", "disks list", "release rollback node",
+		"etcd snapshot", "etcd members", "etcd leave", "etcd forfeit-leadership", "etcd remove-member", "etcd defrag",
+		"etcd alarm list", "etcd alarm disarm", "image list", "image pull", "service")
+
+	defer func() { Config.GlobalOptions.CommandRoles = nil }()
+	for _, tt := range []struct {
+		path      string
+		args      []string
+		overrides map[string]string
+		expected  role.Role
+		err       string
+	}{
+		{path: "apply", expected: role.Admin},
+		{path: "backup", expected: role.EtcdBackup},
+		{path: "get", expected: role.Reader},
+		{path: "reboot", expected: role.Operator},
+		// Subcommands require the role of their parent command unless listed
+		{path: "release rollback node", expected: role.Admin},
+		{path: "disks wipe", expected: role.Admin},
+		{path: "disks list", expected: role.Reader},
+		{path: "etcd snapshot", expected: role.EtcdBackup},
+		{path: "etcd members", expected: role.Reader},
+		{path: "etcd alarm list", expected: role.Reader},
+		{path: "etcd alarm disarm", expected: role.Operator},
+		{path: "etcd defrag", expected: role.Operator},
+		{path: "etcd forfeit-leadership", expected: role.Operator},
+		{path: "etcd leave", expected: role.Operator},
+		{path: "etcd remove-member", args: []string{"1234"}, expected: role.Operator},
+		{path: "image list", expected: role.Reader},
+		{path: "image pull", args: []string{"ghcr.io/siderolabs/kubelet:v1.30.0"}, expected: role.Operator},
+		{path: "service", expected: role.Reader},
+		{path: "service", args: []string{"kubelet"}, expected: role.Reader},
+		{path: "service", args: []string{"kubelet", "status"}, expected: role.Reader},
+		{path: "service", args: []string{"kubelet", "restart"}, expected: role.Operator},
+		{path: "service", args: []string{"kubelet", "stop"}, expected: role.Operator},
+		{path: "service", args: []string{"kubelet", "start"}, expected: role.Operator},
+		{path: "template", overrides: map[string]string{"template": "os:admin"}, expected: role.Admin},
+		{path: "backup", overrides: map[string]string{"backup": "os:admin"}, expected: role.Admin},
+		{path: "etcd defrag", overrides: map[string]string{"etcd defrag": "os:admin"}, expected: role.Admin},
+		{path: "etcd members", overrides: map[string]string{"etcd": "os:operator"}, expected: role.Operator},
+		{path: "get", overrides: map[string]string{"get": "os:root"}, err: `globalOptions.commandRoles.get: unknown role "os:root"`},
+	} {
+		Config.GlobalOptions.CommandRoles = tt.overrides
+		if err := commands[tt.path].ParseFlags(tt.args); err != nil {
+			t.Fatal(err)
+		}
+		required, err := commandRole(commands[tt.path])
+		switch {
+		case tt.err != "":
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: expected error %q, got %v", tt.path, tt.err, err)
+			}
+		case err != nil:
+			t.Errorf("%s: %v", tt.path, err)
+		case required != tt.expected:
+			t.Errorf("%s: expected role %s, got %s", tt.path, tt.expected, required)
+		}
+	}
+}
+
+// adminCommands are the commands issuing the Reset, ApplyConfiguration or Upgrade RPCs, by the
+// files calling them, with the flag making them issue the RPCs.
+var adminCommands = map[string][]string{
+	"apply.go":          {"apply"},
+	"apply_atomic.go":   {"apply"},
+	"apply_sections.go": {"apply"},
+	"disks_wipe.go":     {"disks wipe"},
+	"imported_reset.go": {"reset"},
+	"maintenance.go":    {"apply"},
+	"migrate_disk.go":   {"migrate-disk"},
+	"prune.go":          {"prune --reset"},
+	"upgrade.go":        {"upgrade"},
+	// The node file is applied with apply
+	"nodes_inventory.go": {"nodes add --apply"},
+}
+
+func TestCommandRoleAdminRPCs(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(token.NewFileSet(), file, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			sel, ok := call.Fun.(*ast.SelectorExpr)
+			if ok && slices.Contains([]string{"ApplyConfiguration", "Reset", "ResetGeneric", "Upgrade", "UpgradeWithOptions"}, sel.Sel.Name) {
+				if _, ok := adminCommands[file]; !ok {
+					t.Errorf("%s calls %s, add its commands to adminCommands", file, sel.Sel.Name)
+				}
+			}
+			return true
+		})
+	}
+
+	root := &cobra.Command{Use: "talm"}
+	root.AddCommand(Commands...)
+	defer func() {
+		for _, cmd := range Commands {
+			root.RemoveCommand(cmd)
+		}
+	}()
+	Config.GlobalOptions.CommandRoles = nil
+
+	for _, paths := range adminCommands {
+		for _, path := range paths {
+			args, flag, _ := strings.Cut(path, " --")
+			cmd, _, err := root.Find(strings.Fields(args))
+			if err != nil || cmd == root {
+				t.Errorf("%s: command not found", path)
+				continue
+			}
+			if flag != "" {
+				if required, err := commandRole(cmd); err != nil || required != role.Reader {
+					t.Errorf("%s: expected role %s without --%s, got %s, %v", args, role.Reader, flag, required, err)
+				}
+				if err := cmd.Flags().Set(flag, "true"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			required, err := commandRole(cmd)
+			if flag != "" {
+				if err := cmd.Flags().Set(flag, "false"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err != nil || required != role.Admin {
+				t.Errorf("%s: expected role %s, got %s, %v", path, role.Admin, required, err)
+			}
+		}
+	}
+}
+
+func TestCheckRoles(t *testing.T) {
+	bundle, err := secrets.NewBundle(secrets.NewFixedClock(time.Now()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &clientconfig.Config{Context: "reader", Contexts: map[string]*clientconfig.Context{}}
+	for _, r := range []role.Role{role.Reader, role.EtcdBackup, role.Operator, role.Admin} {
+		cert, err := bundle.GenerateTalosAPIClientCertificate(role.MakeSet(r))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.Contexts[strings.TrimPrefix(string(r), role.Prefix)] = &clientconfig.Context{
+			Endpoints: []string{"10.0.0.1"},
+			Crt:       base64.StdEncoding.EncodeToString(cert.Crt),
+			Key:       base64.StdEncoding.EncodeToString(cert.Key),
+		}
+	}
+	cfg.Contexts["omni"] = &clientconfig.Context{Endpoints: []string{"10.0.0.1"}}
+
+	talosconfig := filepath.Join(t.TempDir(), "talosconfig")
+	if err := cfg.Save(talosconfig); err != nil {
+		t.Fatal(err)
+	}
+
+	globalArgs := GlobalArgs
+	defer func() { GlobalArgs = globalArgs }()
+	GlobalArgs.Talosconfig = talosconfig
+
+	commands := testCommands("apply", "backup", "get", "reboot", "etcd members", "etcd snapshot", "etcd defrag", "image list", "service")
+	for _, tt := range []struct {
+		context  string
+		path     string
+		args     []string
+		insecure bool
+		err      string
+	}{
+		{context: "reader", path: "get"},
+		{context: "reader", path: "reboot", err: `reboot requires the os:operator role, the client certificate of talosconfig context "reader" has os:reader`},
+		{context: "reader", path: "backup", err: "backup requires the os:etcd:backup role"},
+		{context: "reader", path: "apply", insecure: true},
+		{context: "reader", path: "etcd members"},
+		{context: "reader", path: "image list"},
+		{context: "reader", path: "service", args: []string{"kubelet"}},
+		{context: "reader", path: "service", args: []string{"kubelet", "restart"}, err: "service requires the os:operator role"},
+		{context: "reader", path: "etcd snapshot", args: []string{"db.snapshot"}, err: "etcd snapshot requires the os:etcd:backup role"},
+		{context: "etcd:backup", path: "backup"},
+		{context: "etcd:backup", path: "etcd snapshot", args: []string{"db.snapshot"}},
+		{context: "etcd:backup", path: "etcd defrag", err: "etcd defrag requires the os:operator role"},
+		{context: "operator", path: "etcd defrag"},
+		{context: "operator", path: "service", args: []string{"kubelet", "restart"}},
+		{context: "etcd:backup", path: "apply", err: "apply requires the os:admin role"},
+		{context: "operator", path: "backup"},
+		{context: "operator", path: "reboot"},
+		{context: "operator", path: "apply", err: "apply requires the os:admin role"},
+		{context: "admin", path: "apply"},
+		{context: "admin", path: "backup"},
+		// The roles of contexts without a client certificate are unknown
+		{context: "omni", path: "apply"},
+	} {
+		GlobalArgs.CmdContext = tt.context
+		cmd := commands[tt.path]
+		if err := cmd.ParseFlags(tt.args); err != nil {
+			t.Fatal(err)
+		}
+		if err := cmd.Flags().Set("insecure", map[bool]string{true: "true", false: "false"}[tt.insecure]); err != nil {
+			t.Fatal(err)
+		}
+
+		err := CheckRoles(cmd)
+		switch {
+		case tt.err != "":
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s with %s: expected error %q, got %v", tt.path, tt.context, tt.err, err)
+			}
+		case err != nil:
+			t.Errorf("%s with %s: %v", tt.path, tt.context, err)
+		}
+	}
+}
@@ -8,9 +8,17 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"os"
+	"slices"
 	"time"
 
+	"github.com/aenix-io/talm/pkg/engine"
+	"github.com/aenix-io/talm/pkg/hooks"
 	"github.com/aenix-io/talm/pkg/modeline"
+	"github.com/aenix-io/talm/pkg/plugins"
+	"github.com/aenix-io/talm/pkg/tasks"
+	"github.com/aenix-io/talm/pkg/validators"
+	"github.com/aenix-io/talm/pkg/window"
 	"github.com/spf13/cobra"
 	"google.golang.org/grpc"
 
@@ -21,39 +29,110 @@ import (
 
 var kubernetesFlag bool
 
+// Version is the talm version, it is set by main at startup.
+var Version = "dev"
+
 // GlobalArgs is the common arguments for the root command.
 var GlobalArgs global.Args
 
+// Environment selects the secrets of withSecrets keyed by environment, it defaults to the workspace.
+var Environment string
+
+// EnvironmentEnvVar is the environment variable used as a default for the --environment flag.
+const EnvironmentEnvVar = "TALM_ENVIRONMENT"
+
+// RPCTimeout limits the time of the Talos API operations performed by a command, zero means no limit.
+// The flag is --rpc-timeout, since commands like apply and wait define their own --timeout.
+var RPCTimeout time.Duration
+
 var Config struct {
-	RootDir       string
-	GlobalOptions struct {
-		Talosconfig string `yaml:"talosconfig"`
+	RootDir   string
+	Workspace string `yaml:"-"`
+	// TalmVersion is the range of talm versions the project requires, e.g. ">=0.6 <0.8"
+	TalmVersion string `yaml:"talmVersion"`
+	// TalosClientVersion is the range of the Talos client versions built into talm the project requires
+	TalosClientVersion string `yaml:"talosClientVersion"`
+	GlobalOptions      struct {
+		Talosconfig                  string   `yaml:"talosconfig"`
+		TalosconfigPassphraseCommand []string `yaml:"talosconfigPassphraseCommand"`
+		Proxy                        string   `yaml:"proxy"`
+		// Identities map roles to the talosconfig contexts used for the commands allowed to the role
+		Identities map[string]string `yaml:"identities"`
+		// CommandRoles override the roles required by the commands
+		CommandRoles map[string]string `yaml:"commandRoles"`
+		// ValidateNodes checks --nodes against the node files and the cluster members
+		ValidateNodes bool `yaml:"validateNodes"`
+		// StateBackend is the URL of the backend storing the state directory, e.g. s3://bucket/cluster
+		StateBackend string `yaml:"stateBackend"`
+		// StateRecipients are the age recipients the state is encrypted to
+		StateRecipients []string `yaml:"stateRecipients"`
 	} `yaml:"globalOptions"`
 	TemplateOptions struct {
-		Offline           bool     `yaml:"offline"`
-		ValueFiles        []string `yaml:"valueFiles"`
-		Values            []string `yaml:"values"`
-		StringValues      []string `yaml:"stringValues"`
-		FileValues        []string `yaml:"fileValues"`
-		JsonValues        []string `yaml:"jsonValues"`
-		LiteralValues     []string `yaml:"literalValues"`
-		TalosVersion      string   `yaml:"talosVersion"`
-		WithSecrets       string   `yaml:"withSecrets"`
-		KubernetesVersion string   `yaml:"kubernetesVersion"`
-		Full              bool     `yaml:"full"`
+		Offline           bool                `yaml:"offline"`
+		Extends           string              `yaml:"extends"`
+		ValueFiles        []string            `yaml:"valueFiles"`
+		Values            []string            `yaml:"values"`
+		StringValues      []string            `yaml:"stringValues"`
+		FileValues        []string            `yaml:"fileValues"`
+		JsonValues        []string            `yaml:"jsonValues"`
+		LiteralValues     []string            `yaml:"literalValues"`
+		EnvValues         []string            `yaml:"envValues"`
+		EnvAllowlist      []string            `yaml:"envAllowlist"`
+		Plugins           []plugins.Config    `yaml:"plugins"`
+		TalosVersion      string              `yaml:"talosVersion"`
+		WithSecrets       string              `yaml:"-"`
+		SecretsPaths      engine.SecretsPaths `yaml:"withSecrets"`
+		KubernetesVersion string              `yaml:"kubernetesVersion"`
+		Full              bool                `yaml:"full"`
+		// CheckDeterminism renders the templates twice and fails if the renders differ
+		CheckDeterminism bool `yaml:"checkDeterminism"`
+		// SecretValuesIdentityFile is the age identity file decrypting the !secret values
+		SecretValuesIdentityFile string `yaml:"secretValuesIdentityFile"`
+		// SecretValuesPassphraseFile is the file with the passphrase of the !secret values or their identity file
+		SecretValuesPassphraseFile string `yaml:"secretValuesPassphraseFile"`
 	} `yaml:"templateOptions"`
 	ApplyOptions struct {
 		DryRun           bool   `yaml:"preserve"`
 		Timeout          string `yaml:"timeout"`
 		TimeoutDuration  time.Duration
-		CertFingerprints []string `yaml:"certFingerprints"`
+		CertFingerprints []string            `yaml:"certFingerprints"`
+		Validators       []validators.Config `yaml:"validators"`
+		// UKISigningKeyFingerprints are the keys allowed to sign the UKI booted by SecureBoot nodes
+		UKISigningKeyFingerprints []string `yaml:"ukiSigningKeyFingerprints"`
 	} `yaml:"applyOptions"`
+	DiffOptions struct {
+		// IgnorePaths are the sections of the configs changed on the nodes, which are not compared
+		IgnorePaths []string `yaml:"ignorePaths"`
+	} `yaml:"diffOptions"`
 	UpgradeOptions struct {
 		Preserve bool `yaml:"preserve"`
 		Stage    bool `yaml:"stage"`
 		Force    bool `yaml:"force"`
+		Prepull  bool `yaml:"prepull"`
 	} `yaml:"upgradeOptions"`
-	InitOptions struct {
+	SandboxOptions struct {
+		TalmVersion string `yaml:"talmVersion"`
+		Image       string `yaml:"image"`
+		Runtime     string `yaml:"runtime"`
+	} `yaml:"sandboxOptions"`
+	ReleaseOptions struct {
+		// Storage of the releases, local or kubernetes
+		Storage    string `yaml:"storage"`
+		Namespace  string `yaml:"namespace"`
+		Kubeconfig string `yaml:"kubeconfig"`
+	} `yaml:"releaseOptions"`
+	OmniOptions struct {
+		// Cluster is the name of the Omni cluster, the chart name by default
+		Cluster           string `yaml:"cluster"`
+		TalosVersion      string `yaml:"talosVersion"`
+		KubernetesVersion string `yaml:"kubernetesVersion"`
+		// Machines map the nodes of the modelines to Omni machine IDs
+		Machines map[string]string `yaml:"machines"`
+	} `yaml:"omniOptions"`
+	Hooks              hooks.Config    `yaml:"hooks"`
+	Tasks              tasks.Config    `yaml:"tasks"`
+	MaintenanceWindows []window.Window `yaml:"maintenanceWindows"`
+	InitOptions        struct {
 		Version string
 	}
 }
@@ -64,7 +143,23 @@ const pathAutoCompleteLimit = 500
 //
 // WithClientNoNodes doesn't set any node information on the request context.
 func WithClientNoNodes(action func(context.Context, *client.Client) error, dialOptions ...grpc.DialOption) error {
-	return GlobalArgs.WithClientNoNodes(action, dialOptions...)
+	if err := checkClientCertificate(); err != nil {
+		return err
+	}
+
+	resolveEndpoints()
+
+	proxyOptions, err := proxyDialOptions()
+	if err != nil {
+		return err
+	}
+
+	// An encrypted talosconfig is decrypted in memory only
+	if isTalosconfigEncrypted(GlobalArgs.Talosconfig) {
+		return wrapClientCertError(withEncryptedTalosconfig(withTimeout(action, RPCTimeout), append(dialOptions, proxyOptions...)...))
+	}
+
+	return wrapClientCertError(GlobalArgs.WithClientNoNodes(withTimeout(action, RPCTimeout), append(dialOptions, proxyOptions...)...))
 }
 
 // WithClient builds upon WithClientNoNodes to provide set of nodes on request context based on config & flags.
@@ -90,8 +185,70 @@ func WithClient(action func(context.Context, *client.Client) error, dialOptions
 }
 
 // WithClientMaintenance wraps common code to initialize Talos client in maintenance (insecure mode).
+// Unless fingerprints are enforced explicitly, the certificates of the nodes are pinned on first use.
 func WithClientMaintenance(enforceFingerprints []string, action func(context.Context, *client.Client) error) error {
-	return GlobalArgs.WithClientMaintenance(enforceFingerprints, action)
+	proxyOptions, err := proxyDialOptions()
+	if err != nil {
+		return err
+	}
+
+	// Without explicit fingerprints the certificates are pinned on first use
+	if len(enforceFingerprints) == 0 {
+		enforceFingerprints, err = pinnedFingerprints(context.Background(), GlobalArgs.Nodes)
+		if err != nil {
+			return err
+		}
+	}
+	if len(proxyOptions) > 0 {
+		return withClientMaintenanceProxy(enforceFingerprints, proxyOptions, withTimeout(action, RPCTimeout))
+	}
+
+	return GlobalArgs.WithClientMaintenance(enforceFingerprints, withTimeout(action, RPCTimeout))
+}
+
+// withTimeout limits the context passed to the action by the given timeout.
+//
+// Context is also cancelled on Ctrl-C, so the in-flight requests are aborted cleanly.
+func withTimeout(action func(context.Context, *client.Client) error, timeout time.Duration) func(context.Context, *client.Client) error {
+	if timeout <= 0 {
+		return action
+	}
+
+	return func(ctx context.Context, c *client.Client) error {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		err := action(ctx, c)
+		if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("operation timed out after %s: %w", timeout, err)
+		}
+
+		return err
+	}
+}
+
+// printInterruptSummary reports which config files were processed before the command was interrupted.
+// The files can be listed several times, e.g. node files applied once per machine type.
+func printInterruptSummary(ctx context.Context, processed, all []string) {
+	if ctx.Err() == nil {
+		return
+	}
+
+	files, remaining := []string{}, []string{}
+	for _, file := range all {
+		if slices.Contains(files, file) {
+			continue
+		}
+		files = append(files, file)
+		if !slices.Contains(processed, file) {
+			remaining = append(remaining, file)
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Interrupted: %d of %d files processed\n", len(files)-len(remaining), len(files))
+	for _, file := range remaining {
+		fmt.Fprintf(os.Stderr, "  not processed: %s\n", file)
+	}
 }
 
 // Commands is a list of commands published by the package.
@@ -102,9 +259,13 @@ func addCommand(cmd *cobra.Command) {
 }
 
 func processModelineAndUpdateGlobals(configFile string, nodesFromArgs bool, endpointsFromArgs bool, owerwrite bool) error {
+	if err := checkWorkspaceFile(configFile); err != nil {
+		return err
+	}
+
 	modelineConfig, err := modeline.ReadAndParseModeline(configFile)
 	if err != nil {
-		fmt.Printf("Warning: modeline parsing failed: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Warning: modeline parsing failed: %v\n", err)
 		return err
 	}
 
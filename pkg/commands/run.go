@@ -0,0 +1,134 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aenix-io/talm/pkg/tasks"
+	"github.com/spf13/cobra"
+)
+
+var runCmdFlags struct {
+	dryRun bool
+}
+
+var runCmd = &cobra.Command{
+	Use:   "run [<task> [<param>=<value>...]]",
+	Short: "Run a task of Chart.yaml, or list the tasks",
+	Long: `Run the steps of a task defined in the tasks section of Chart.yaml in order, talm commands
+and external commands, with the parameters of the task:
+
+  tasks:
+    rollout:
+      description: Render, diff, apply and check a node
+      params:
+        file:           # required
+      steps:
+      - name: render
+        talm: [template, -f, "{{ .file }}", -I]
+      - name: diff
+        talm: [ci, diff, -f, "{{ .file }}"]
+        onFailure: continue
+      - name: apply
+        talm: [apply, -f, "{{ .file }}"]
+      - name: health
+        talm: [health, -f, "{{ .file }}"]
+
+  talm run rollout file=nodes/node1.yaml
+
+The talm commands run with the selected workspace and environment. External commands get the
+parameters in TALM_PARAM_<NAME> environment variables. A failing step stops the task, unless
+its onFailure is continue, and talm exits with the exit code of the step.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 {
+			return listTasks()
+		}
+
+		name := args[0]
+		task, ok := Config.Tasks[name]
+		if !ok {
+			return fmt.Errorf("task %q is not defined in Chart.yaml", name)
+		}
+
+		values := map[string]string{}
+		for _, arg := range args[1:] {
+			param, value, ok := strings.Cut(arg, "=")
+			if !ok {
+				return fmt.Errorf("invalid parameter %q, use <param>=<value>", arg)
+			}
+			values[param] = value
+		}
+		params, err := task.Resolve(name, values)
+		if err != nil {
+			return err
+		}
+
+		talm, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		var env []string
+		if Config.Workspace != "" {
+			env = append(env, WorkspaceEnvVar+"="+Config.Workspace)
+		}
+		if Environment != "" {
+			env = append(env, EnvironmentEnvVar+"="+Environment)
+		}
+
+		runner := tasks.Runner{
+			Talm:   []string{talm},
+			Env:    env,
+			Stdout: os.Stdout,
+			Stderr: os.Stderr,
+			DryRun: runCmdFlags.dryRun,
+		}
+		err = runner.Run(cmd.Context(), name, task, params)
+
+		// The exit code of the step is kept, e.g. of talm ci diff for drift
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return &ExitError{Code: exitErr.ExitCode(), Err: err}
+		}
+		return err
+	},
+}
+
+func listTasks() error {
+	names := make([]string, 0, len(Config.Tasks))
+	for name := range Config.Tasks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "TASK\tPARAMS\tDESCRIPTION")
+	for _, name := range names {
+		task := Config.Tasks[name]
+		params := make([]string, 0, len(task.Params))
+		for param, value := range task.Params {
+			if value == nil {
+				params = append(params, param)
+			} else {
+				params = append(params, param+"="+*value)
+			}
+		}
+		sort.Strings(params)
+		fmt.Fprintf(w, "%s\t%s\t%s\n", name, strings.Join(params, " "), task.Description)
+	}
+	return w.Flush()
+}
+
+func init() {
+	runCmd.Flags().BoolVar(&runCmdFlags.dryRun, "dry-run", false, "print the steps of the task without running them")
+
+	addCommand(runCmd)
+}
@@ -0,0 +1,115 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/aenix-io/talm/pkg/sandbox"
+	"github.com/spf13/cobra"
+)
+
+var sandboxRenderCmdFlags struct {
+	image   string
+	runtime string
+}
+
+var sandboxCmd = &cobra.Command{
+	Use:   "sandbox",
+	Short: "Run talm in a container with the talm version pinned by the project",
+	Long:  ``,
+}
+
+var sandboxRenderCmd = &cobra.Command{
+	Use:   "render [-- template flags]",
+	Short: "Render templates in a container with the pinned talm version",
+	Long: `Run ` + "`talm template --offline`" + ` in a container of the talm version pinned by
+sandboxOptions.talmVersion in Chart.yaml, so the output is byte-identical on every machine.
+The flags after -- are passed to talm template:
+
+  talm sandbox render -- -f nodes/node1.yaml -I
+
+The project is mounted into the container, which has no network access. The container runtime
+is detected among podman, docker and nerdctl, or set by --runtime, sandboxOptions.runtime
+or the ` + sandbox.RuntimeEnvVar + ` environment variable.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if !cmd.Flags().Changed("image") {
+			sandboxRenderCmdFlags.image = Config.SandboxOptions.Image
+		}
+		if !cmd.Flags().Changed("runtime") {
+			sandboxRenderCmdFlags.runtime = Config.SandboxOptions.Runtime
+			if sandboxRenderCmdFlags.runtime == "" {
+				sandboxRenderCmdFlags.runtime = os.Getenv(sandbox.RuntimeEnvVar)
+			}
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		runtime, err := sandbox.DetectRuntime(sandboxRenderCmdFlags.runtime, exec.LookPath)
+		if err != nil {
+			return err
+		}
+		image, err := sandbox.Image(sandboxRenderCmdFlags.image, Config.SandboxOptions.TalmVersion)
+		if err != nil {
+			return err
+		}
+
+		root, err := filepath.Abs(Config.RootDir)
+		if err != nil {
+			return err
+		}
+		dir, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+
+		env := map[string]string{}
+		if Config.Workspace != "" {
+			env[WorkspaceEnvVar] = Config.Workspace
+		}
+		if Environment != "" {
+			env[EnvironmentEnvVar] = Environment
+		}
+
+		c, err := sandbox.Command(sandbox.Options{
+			Runtime: runtime,
+			Image:   image,
+			Root:    root,
+			Dir:     dir,
+			UID:     os.Getuid(),
+			GID:     os.Getgid(),
+			Env:     env,
+			Args:    append([]string{"template", "--offline"}, args...),
+		})
+		if err != nil {
+			return err
+		}
+		c.Stdin = os.Stdin
+		c.Stdout = os.Stdout
+		c.Stderr = os.Stderr
+
+		fmt.Fprintf(os.Stderr, "Rendering with %s in %s\n", image, runtime)
+		if err := c.Run(); err != nil {
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				return fmt.Errorf("render in %s failed with exit code %d", image, exitErr.ExitCode())
+			}
+			return err
+		}
+		return nil
+	},
+}
+
+func init() {
+	sandboxRenderCmd.Flags().StringVar(&sandboxRenderCmdFlags.image, "image", "", "talm image to render with, instead of the image of the pinned talm version")
+	sandboxRenderCmd.Flags().StringVar(&sandboxRenderCmdFlags.runtime, "runtime", "", "container runtime to use: podman, docker or nerdctl")
+
+	sandboxCmd.AddCommand(sandboxRenderCmd)
+	addCommand(sandboxCmd)
+}
@@ -0,0 +1,209 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var selfUpdateCmdFlags struct {
+	version string
+	force   bool
+}
+
+var selfUpdateCmd = &cobra.Command{
+	Use:   "self-update",
+	Short: "Update talm binary to the latest release",
+	Long:  ``,
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return selfUpdate(cmd.Context())
+	},
+}
+
+func selfUpdate(ctx context.Context) error {
+	release, err := fetchRelease(ctx, selfUpdateCmdFlags.version)
+	if err != nil {
+		return err
+	}
+
+	if !selfUpdateCmdFlags.force && !isNewerVersion(Version, release.TagName) {
+		fmt.Printf("talm %s is up to date\n", Version)
+		return nil
+	}
+
+	binaryName := releaseBinaryName(runtime.GOOS, runtime.GOARCH)
+	var binaryURL, checksumsURL string
+	for _, asset := range release.Assets {
+		switch {
+		case asset.Name == binaryName:
+			binaryURL = asset.BrowserDownloadURL
+		case strings.HasSuffix(asset.Name, "checksums.txt"):
+			checksumsURL = asset.BrowserDownloadURL
+		}
+	}
+	if binaryURL == "" {
+		return fmt.Errorf("release %s has no binary for %s/%s", release.TagName, runtime.GOOS, runtime.GOARCH)
+	}
+	if checksumsURL == "" {
+		return fmt.Errorf("release %s has no checksums file", release.TagName)
+	}
+
+	checksums, err := download(ctx, checksumsURL)
+	if err != nil {
+		return err
+	}
+	expected, err := findChecksum(checksums, binaryName)
+	if err != nil {
+		return err
+	}
+
+	binary, err := download(ctx, binaryURL)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(binary)
+	if actual := hex.EncodeToString(sum[:]); actual != expected {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", binaryName, expected, actual)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	executable, err = filepath.EvalSymlinks(executable)
+	if err != nil {
+		return err
+	}
+
+	// Write new binary next to the current one and atomically replace it
+	tmpFile, err := os.CreateTemp(filepath.Dir(executable), ".talm-update-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.Write(binary); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpFile.Name(), 0o755); err != nil {
+		return err
+	}
+	if err := replaceExecutable(runtime.GOOS, tmpFile.Name(), executable); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", executable, err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Updated talm %s -> %s\n", Version, release.TagName)
+	return nil
+}
+
+// replaceExecutable moves the new binary over the executable. Windows doesn't allow replacing
+// a running executable but allows renaming it, so it is moved to <executable>.old first and
+// removed by RemoveReplacedExecutable on the next start.
+func replaceExecutable(goos, binary, executable string) error {
+	if goos != "windows" {
+		return os.Rename(binary, executable)
+	}
+
+	old := executable + ".old"
+	if err := os.Remove(old); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(executable, old); err != nil {
+		return err
+	}
+	if err := os.Rename(binary, executable); err != nil {
+		// Keep the current binary in place
+		if restoreErr := os.Rename(old, executable); restoreErr != nil {
+			return fmt.Errorf("%w, the current binary is left at %s: %w", err, old, restoreErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// RemoveReplacedExecutable removes the binary replaced by self-update on Windows, which can't
+// be removed while it runs.
+func RemoveReplacedExecutable() {
+	if runtime.GOOS != "windows" {
+		return
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return
+	}
+	if executable, err = filepath.EvalSymlinks(executable); err == nil {
+		os.Remove(executable + ".old") //nolint:errcheck
+	}
+}
+
+// releaseBinaryName returns the name of the release asset, see name_template in .goreleaser.yaml.
+func releaseBinaryName(goos, goarch string) string {
+	if goarch == "386" {
+		goarch = "i386"
+	}
+	name := fmt.Sprintf("talm-%s-%s", goos, goarch)
+	if goos == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// findChecksum looks up the sha256 sum for the file in sha256sum formatted data.
+func findChecksum(checksums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(strings.NewReader(string(checksums)))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return fields[0], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("checksum for %s not found", name)
+}
+
+func download(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error downloading %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error downloading %s: %s", url, resp.Status)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+func init() {
+	selfUpdateCmd.Flags().StringVar(&selfUpdateCmdFlags.version, "version", "", "install the specified release tag instead of the latest one")
+	selfUpdateCmd.Flags().BoolVar(&selfUpdateCmdFlags.force, "force", false, "reinstall even if the current version is up to date")
+
+	addCommand(selfUpdateCmd)
+}
@@ -0,0 +1,121 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReleaseBinaryName(t *testing.T) {
+	for _, tt := range []struct {
+		goos, goarch string
+		expected     string
+	}{
+		{"linux", "amd64", "talm-linux-amd64"},
+		{"linux", "arm64", "talm-linux-arm64"},
+		{"linux", "386", "talm-linux-i386"},
+		{"darwin", "arm64", "talm-darwin-arm64"},
+		{"windows", "amd64", "talm-windows-amd64.exe"},
+	} {
+		if name := releaseBinaryName(tt.goos, tt.goarch); name != tt.expected {
+			t.Errorf("%s/%s: expected %s, got %s", tt.goos, tt.goarch, tt.expected, name)
+		}
+	}
+}
+
+func TestFindChecksum(t *testing.T) {
+	checksums := []byte(`0f1e2d3c  talm-darwin-arm64
+a1b2c3d4  talm-linux-amd64
+e5f6a7b8 *talm-windows-amd64.exe
+deadbeef  talm-linux-amd64.tar.gz
+`)
+	for _, tt := range []struct {
+		name     string
+		expected string
+		err      string
+	}{
+		{name: "talm-linux-amd64", expected: "a1b2c3d4"},
+		// Binary mode of sha256sum
+		{name: "talm-windows-amd64.exe", expected: "e5f6a7b8"},
+		{name: "talm-linux-arm64", err: "checksum for talm-linux-arm64 not found"},
+		{name: "talm-linux", err: "not found"},
+	} {
+		sum, err := findChecksum(checksums, tt.name)
+		switch {
+		case tt.err != "":
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: expected error %q, got %q, %v", tt.name, tt.err, sum, err)
+			}
+		case err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case sum != tt.expected:
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.expected, sum)
+		}
+	}
+}
+
+func TestIsNewerVersion(t *testing.T) {
+	for _, tt := range []struct {
+		current, latest string
+		newer           bool
+	}{
+		{"v0.6.0", "v0.7.0", true},
+		{"v0.6.0", "v0.6.1", true},
+		{"0.6.0", "v0.6.0", false},
+		{"v0.7.0", "v0.6.9", false},
+		{"v0.7.0-rc.1", "v0.7.0", true},
+		{"v0.7.0", "v0.7.0-rc.1", false},
+		// Development builds are always updated
+		{"dev", "v0.6.0", true},
+		{"v0.6.0", "latest", false},
+	} {
+		if newer := isNewerVersion(tt.current, tt.latest); newer != tt.newer {
+			t.Errorf("%s -> %s: expected newer %t, got %t", tt.current, tt.latest, tt.newer, newer)
+		}
+	}
+}
+
+func TestReplaceExecutable(t *testing.T) {
+	for _, goos := range []string{"linux", "windows"} {
+		dir := t.TempDir()
+		executable, binary := filepath.Join(dir, "talm"), filepath.Join(dir, ".talm-update")
+		for file, data := range map[string]string{executable: "current", binary: "new", executable + ".old": "replaced before"} {
+			if err := os.WriteFile(file, []byte(data), 0o755); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := replaceExecutable(goos, binary, executable); err != nil {
+			t.Fatalf("%s: %v", goos, err)
+		}
+		if data, err := os.ReadFile(executable); err != nil || string(data) != "new" {
+			t.Errorf("%s: expected the new binary, got %q, %v", goos, data, err)
+		}
+		if _, err := os.Stat(binary); !os.IsNotExist(err) {
+			t.Errorf("%s: expected the new binary to be moved, got %v", goos, err)
+		}
+
+		// The running binary is renamed on Windows
+		expected := "replaced before"
+		if goos == "windows" {
+			expected = "current"
+		}
+		if data, err := os.ReadFile(executable + ".old"); err != nil || string(data) != expected {
+			t.Errorf("%s: expected %q in %s.old, got %q, %v", goos, expected, executable, data, err)
+		}
+	}
+
+	// The current binary is restored if the new one can't be moved
+	dir := t.TempDir()
+	executable := filepath.Join(dir, "talm.exe")
+	if err := os.WriteFile(executable, []byte("current"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := replaceExecutable("windows", filepath.Join(dir, "missing"), executable); err == nil {
+		t.Error("expected an error")
+	}
+	if data, err := os.ReadFile(executable); err != nil || string(data) != "current" {
+		t.Errorf("expected the current binary to be restored, got %q, %v", data, err)
+	}
+}
@@ -0,0 +1,122 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/aenix-io/talm/pkg/age"
+	"github.com/aenix-io/talm/pkg/state"
+	"github.com/spf13/cobra"
+)
+
+// StateBackendEnvVar selects the state backend if --state-backend is not set.
+const StateBackendEnvVar = "TALM_STATE_BACKEND"
+
+// talmStateDir is the state directory relative to the project root or the workspace.
+const talmStateDir = ".talm"
+
+// StateBackend is the URL of the backend storing the state directory, set by --state-backend.
+var StateBackend string
+
+// pulledState is the decrypted state read from the backend before the command ran.
+var pulledState struct {
+	backend state.Backend
+	version string
+	archive []byte
+}
+
+// PullState replaces the state directory, .talm in the project root or the workspace, with the
+// state stored in the backend of --state-backend or globalOptions.stateBackend in Chart.yaml.
+// If the backend stores no state yet, the local state is stored after the command. Local changes
+// which were not stored are kept, or the command fails if another run stored a state meanwhile.
+// The workspaces of a project are stored under the backend URL by name.
+func PullState(cmd *cobra.Command) error {
+	backendURL := StateBackend
+	if backendURL == "" {
+		backendURL = Config.GlobalOptions.StateBackend
+	}
+	// Printing the version and completions don't touch the state
+	if backendURL == "" || cmd.Name() == "version" || strings.HasPrefix(cmd.Name(), cobra.ShellCompRequestCmd) {
+		return nil
+	}
+	if Config.Workspace != "" {
+		backendURL = strings.TrimSuffix(backendURL, "/") + "/" + Config.Workspace
+	}
+
+	ctx := context.Background()
+	backend, err := state.Open(ctx, backendURL)
+	if err != nil {
+		return err
+	}
+	data, version, err := backend.Get(ctx)
+	switch {
+	case errors.Is(err, state.ErrNotFound):
+	case err != nil:
+		return fmt.Errorf("failed to read the state from %s: %w", backendURL, err)
+	default:
+		identities, err := valuesIdentities()
+		if err != nil {
+			return fmt.Errorf("failed to decrypt the state: %w", err)
+		}
+		if data, err = age.DecryptIdentities(data, identities); err != nil {
+			return fmt.Errorf("failed to decrypt the state from %s: %w", backendURL, err)
+		}
+		if err := state.Pull(data, filepath.Join(stateDir(), talmStateDir)); err != nil {
+			return err
+		}
+	}
+
+	pulledState.backend = backend
+	pulledState.version = version
+	pulledState.archive = data
+	return nil
+}
+
+// PushState stores the state directory in the backend the state was pulled from, if the command
+// changed it. The state is encrypted with age, since the known nodes can hold BMC passwords.
+// The state is not stored if another run stored a state in the meantime.
+func PushState() error {
+	if pulledState.backend == nil {
+		return nil
+	}
+
+	dir := filepath.Join(stateDir(), talmStateDir)
+	data, err := state.Archive(dir)
+	if err != nil {
+		return err
+	}
+	if pulledState.archive != nil && bytes.Equal(data, pulledState.archive) {
+		return nil
+	}
+
+	recipients, err := stateRecipients()
+	if err != nil {
+		return fmt.Errorf("failed to encrypt the state: %w", err)
+	}
+	encrypted, err := age.EncryptRecipients(data, recipients)
+	if err != nil {
+		return err
+	}
+	err = pulledState.backend.Put(context.Background(), encrypted, pulledState.version)
+	if err != nil {
+		return fmt.Errorf("failed to store the state of %s: %w", dir, err)
+	}
+	return state.MarkSynced(data, dir)
+}
+
+// stateRecipients returns the recipients the state is encrypted to: the globalOptions.stateRecipients
+// of Chart.yaml, or the recipients of the identities or the passphrase of the secret values.
+func stateRecipients() ([]age.Recipient, error) {
+	if len(Config.GlobalOptions.StateRecipients) > 0 {
+		return age.ParseRecipients([]byte(strings.Join(Config.GlobalOptions.StateRecipients, "\n")))
+	}
+	return valuesRecipients()
+}
@@ -0,0 +1,283 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aenix-io/talm/pkg/engine"
+	"github.com/aenix-io/talm/pkg/fileutil"
+	"github.com/aenix-io/talm/pkg/modeline"
+	"github.com/aenix-io/talm/pkg/support"
+	"github.com/spf13/cobra"
+
+	"github.com/siderolabs/talos/pkg/machinery/api/common"
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	"github.com/siderolabs/talos/pkg/machinery/constants"
+)
+
+var supportBundleCmdFlags struct {
+	configFiles []string
+	output      string
+	offline     bool
+	redact      []string
+	services    []string
+	logLines    int32
+}
+
+var supportCmd = &cobra.Command{
+	Use:   "support",
+	Short: "Collect information for bug reports",
+	Long:  ``,
+}
+
+var supportBundleCmd = &cobra.Command{
+	Use:   "bundle",
+	Short: "Collect a redacted support bundle to attach to bug reports",
+	Long: `Collect the project files, the configs rendered from the node files, the resources the
+templates discovered on the nodes, the versions of talm and the nodes, and the logs of the
+Talos services into a zip archive to attach to bug reports.
+
+Configs are rendered without secrets, and secrets, MAC addresses and public IP addresses are
+redacted from all files. The same address is replaced by the same pseudonym everywhere, so
+the topology stays readable. Choose the redacted data with --redact, e.g. --redact secrets
+keeps the addresses. Collection goes on when a node is unreachable, the errors are listed
+in errors.txt of the bundle. Review the bundle before sharing it.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		redactor, err := support.NewRedactor(supportBundleCmdFlags.redact)
+		if err != nil {
+			return err
+		}
+
+		files := supportBundleCmdFlags.configFiles
+		if len(files) == 0 {
+			if files, err = defaultNodeFiles(); err != nil {
+				return err
+			}
+		}
+
+		out, err := os.Create(supportBundleCmdFlags.output)
+		if err != nil {
+			return fmt.Errorf("error creating support bundle: %w", err)
+		}
+		defer out.Close() //nolint:errcheck
+
+		bundle := support.NewBundle(out, redactor)
+		if err := collectSupportBundle(cmd.Context(), bundle, files); err != nil {
+			return err
+		}
+		if err := bundle.Close(); err != nil {
+			return fmt.Errorf("error writing support bundle: %w", err)
+		}
+		if err := out.Close(); err != nil {
+			return fmt.Errorf("error writing support bundle: %w", err)
+		}
+
+		fmt.Fprintf(os.Stderr, "- talm: support bundle written to %s, %d error(s) collecting it\n", supportBundleCmdFlags.output, len(bundle.Errors()))
+		return nil
+	},
+}
+
+// collectSupportBundle adds the files to the bundle. Only errors writing the bundle are returned,
+// errors collecting a file are recorded in the bundle.
+func collectSupportBundle(ctx context.Context, bundle *support.Bundle, files []string) error {
+	info, err := json.MarshalIndent(getBuildInfo(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := bundle.Add("talm-version.json", info); err != nil {
+		return err
+	}
+
+	for _, name := range []string{"Chart.yaml", "values.yaml", "values.schema.json"} {
+		data, err := os.ReadFile(filepath.Join(Config.RootDir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			bundle.AddError("project/"+name, err)
+			continue
+		}
+		if err := bundle.Add("project/"+name, data); err != nil {
+			return err
+		}
+	}
+
+	for _, file := range files {
+		if err := collectSupportNodeFile(ctx, bundle, file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func collectSupportNodeFile(ctx context.Context, bundle *support.Bundle, file string) error {
+	name := path.Join("project", fileutil.TrimRoot(Config.RootDir, file))
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		bundle.AddError(name, err)
+		return nil
+	}
+	// Node files are patches of the machine config, their secret fields are replaced by name
+	if bundle.Redacts(support.Secrets) {
+		if redacted, err := engine.RedactSecrets(data); err == nil {
+			data = redacted
+		}
+	}
+	if err := bundle.Add(name, data); err != nil {
+		return err
+	}
+
+	modelineConfig, err := modeline.ReadAndParseModeline(file)
+	if err != nil {
+		bundle.AddError(name, err)
+		return nil
+	}
+
+	opts := engine.Options{
+		Root:              Config.RootDir,
+		Extends:           Config.TemplateOptions.Extends,
+		ValueFiles:        Config.TemplateOptions.ValueFiles,
+		Values:            Config.TemplateOptions.Values,
+		StringValues:      Config.TemplateOptions.StringValues,
+		FileValues:        Config.TemplateOptions.FileValues,
+		JsonValues:        Config.TemplateOptions.JsonValues,
+		LiteralValues:     Config.TemplateOptions.LiteralValues,
+		NodeValues:        modelineConfig.Values,
+		EnvAllowlist:      Config.TemplateOptions.EnvAllowlist,
+		Plugins:           Config.TemplateOptions.Plugins,
+		TalosVersion:      Config.TemplateOptions.TalosVersion,
+		WithoutSecrets:    true,
+		KubernetesVersion: Config.TemplateOptions.KubernetesVersion,
+		TemplateFiles:     modelineConfig.Templates,
+	}
+	rendered := strings.TrimSuffix(path.Base(file), path.Ext(file)) + ".rendered.yaml"
+
+	if supportBundleCmdFlags.offline {
+		opts.Offline = true
+		var buf bytes.Buffer
+		if err := engine.RenderNode(ctx, nil, opts, &buf); err != nil {
+			bundle.AddError(path.Join("rendered", rendered), err)
+			return nil
+		}
+		return bundle.Add(path.Join("rendered", rendered), buf.Bytes())
+	}
+
+	// Errors connecting to the nodes are collection errors, errors writing the bundle are returned
+	var writeErr error
+	GlobalArgs.Nodes = modelineConfig.Nodes
+	GlobalArgs.Endpoints = modelineConfig.Endpoints
+	err = WithClientNoNodes(func(ctx context.Context, c *client.Client) error {
+		for _, node := range modelineConfig.Nodes {
+			if writeErr = collectSupportNode(client.WithNode(ctx, node), c, bundle, node, rendered, opts); writeErr != nil {
+				return writeErr
+			}
+		}
+		return nil
+	})
+	GlobalArgs.Nodes, GlobalArgs.Endpoints = nil, nil
+	if writeErr != nil {
+		return writeErr
+	}
+	if err != nil {
+		bundle.AddError(name, err)
+	}
+	return nil
+}
+
+// collectSupportNode adds the config rendered with the facts of the node, the facts, the version
+// and the logs of the node to the bundle. Only errors writing the bundle are returned.
+func collectSupportNode(ctx context.Context, c *client.Client, bundle *support.Bundle, node, rendered string, opts engine.Options) error {
+	dir := path.Join("nodes", node)
+	add := func(name string, data []byte) error {
+		if err := bundle.Add(path.Join(dir, name), data); err != nil {
+			return fmt.Errorf("error writing support bundle: %w", err)
+		}
+		return nil
+	}
+
+	opts.TalosVersion = engine.ResolveTalosVersion(ctx, c, opts.TalosVersion)
+	recorder := support.NewRecorder(engine.NewNode(c))
+	var buf bytes.Buffer
+	if err := engine.RenderNode(ctx, recorder, opts, &buf); err != nil {
+		bundle.AddError(path.Join(dir, rendered), err)
+	} else if err := add(rendered, buf.Bytes()); err != nil {
+		return err
+	}
+
+	snapshot, err := recorder.Snapshot()
+	if err != nil {
+		bundle.AddError(path.Join(dir, "discovery.yaml"), err)
+	} else if err := add("discovery.yaml", snapshot); err != nil {
+		return err
+	}
+
+	version, err := c.Version(ctx)
+	if err != nil {
+		bundle.AddError(path.Join(dir, "version.txt"), err)
+	} else {
+		var b strings.Builder
+		for _, message := range version.GetMessages() {
+			v := message.GetVersion()
+			fmt.Fprintf(&b, "Tag: %s\nSHA: %s\nBuilt: %s\nGo version: %s\nOS/Arch: %s/%s\n", v.GetTag(), v.GetSha(), v.GetBuilt(), v.GetGoVersion(), v.GetOs(), v.GetArch())
+		}
+		if err := add("version.txt", []byte(b.String())); err != nil {
+			return err
+		}
+	}
+
+	for _, service := range supportBundleCmdFlags.services {
+		logs, err := supportLogs(ctx, c, service)
+		if err != nil {
+			bundle.AddError(path.Join(dir, "logs", service+".log"), err)
+		}
+		if len(logs) > 0 {
+			if err := add(path.Join("logs", service+".log"), logs); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// supportLogs returns the last lines of the logs of the service.
+func supportLogs(ctx context.Context, c *client.Client, service string) ([]byte, error) {
+	stream, err := c.Logs(ctx, constants.SystemContainerdNamespace, common.ContainerDriver_CONTAINERD, service, false, supportBundleCmdFlags.logLines)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	respCh, errCh := newLineSlicer(stream)
+	for data := range respCh {
+		if data.Metadata != nil && data.Metadata.Error != "" {
+			return buf.Bytes(), fmt.Errorf("%s", data.Metadata.Error)
+		}
+		buf.Write(data.Bytes)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), <-errCh
+}
+
+func init() {
+	supportBundleCmd.Flags().StringSliceVarP(&supportBundleCmdFlags.configFiles, "file", "f", nil, "node files to collect, all node files of the project or workspace by default")
+	supportBundleCmd.Flags().StringVarP(&supportBundleCmdFlags.output, "output", "o", "talm-support.zip", "path of the bundle")
+	supportBundleCmd.Flags().BoolVar(&supportBundleCmdFlags.offline, "offline", false, "render the node files offline and don't connect to the nodes")
+	supportBundleCmd.Flags().StringSliceVar(&supportBundleCmdFlags.redact, "redact", support.Kinds, "data to redact: "+strings.Join(support.Kinds, ", "))
+	supportBundleCmd.Flags().StringSliceVar(&supportBundleCmdFlags.services, "logs", []string{"machined", "apid", "kubelet", "etcd"}, "services whose logs are collected")
+	supportBundleCmd.Flags().Int32Var(&supportBundleCmdFlags.logLines, "log-lines", 1000, "number of lines of every log")
+
+	supportCmd.AddCommand(supportBundleCmd)
+	addCommand(supportCmd)
+}
@@ -0,0 +1,244 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/aenix-io/talm/pkg/age"
+	"github.com/aenix-io/talm/pkg/fileutil"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+	"google.golang.org/grpc"
+
+	"github.com/siderolabs/talos/pkg/cli"
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	clientconfig "github.com/siderolabs/talos/pkg/machinery/client/config"
+)
+
+// TalosconfigPassphraseEnvVar is the environment variable with the passphrase of an encrypted talosconfig.
+const TalosconfigPassphraseEnvVar = "TALM_TALOSCONFIG_PASSPHRASE"
+
+// talosconfigPassphraseCache keeps the passphrase for the whole command, it is asked only once.
+var talosconfigPassphraseCache []byte
+
+// talosconfigPassphrase reads the passphrase of the talosconfig from the environment, the
+// passphrase command of Chart.yaml (e.g. reading a keychain) or the terminal.
+func talosconfigPassphrase(confirm bool) ([]byte, error) {
+	if talosconfigPassphraseCache != nil {
+		return talosconfigPassphraseCache, nil
+	}
+
+	var (
+		passphrase []byte
+		err        error
+	)
+	switch command := Config.GlobalOptions.TalosconfigPassphraseCommand; {
+	case os.Getenv(TalosconfigPassphraseEnvVar) != "":
+		passphrase = []byte(os.Getenv(TalosconfigPassphraseEnvVar))
+	case len(command) > 0:
+		cmd := exec.Command(command[0], command[1:]...)
+		cmd.Stderr = os.Stderr
+		passphrase, err = cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("talosconfig passphrase command failed: %w", err)
+		}
+		passphrase = bytes.TrimRight(passphrase, "\r\n")
+	case term.IsTerminal(int(os.Stdin.Fd())):
+		passphrase, err = promptPassphrase("Talosconfig passphrase: ", confirm)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("talosconfig is encrypted: please set %s env variable or globalOptions.talosconfigPassphraseCommand in Chart.yaml", TalosconfigPassphraseEnvVar)
+	}
+
+	if len(passphrase) == 0 {
+		return nil, errors.New("talosconfig passphrase is empty")
+	}
+	talosconfigPassphraseCache = passphrase
+	return passphrase, nil
+}
+
+// readTalosconfig reads the talosconfig, decrypting it in memory if it is encrypted.
+func readTalosconfig(path string) (data []byte, encrypted bool, err error) {
+	data, err = os.ReadFile(path)
+	if err != nil {
+		return nil, false, err
+	}
+	if !age.IsEncrypted(data) {
+		return data, false, nil
+	}
+
+	passphrase, err := talosconfigPassphrase(false)
+	if err != nil {
+		return nil, true, err
+	}
+	data, err = age.Decrypt(data, passphrase)
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to decrypt %s: %w", path, err)
+	}
+	return data, true, nil
+}
+
+// openTalosconfig loads the talosconfig like clientconfig.Open, decrypting it if it is encrypted.
+func openTalosconfig(path string) (*clientconfig.Config, error) {
+	data, encrypted, err := readTalosconfig(path)
+	if !encrypted {
+		// Plain talosconfigs keep their path, so they can be saved
+		return clientconfig.Open(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return clientconfig.FromBytes(data)
+}
+
+// isTalosconfigEncrypted reports whether the talosconfig file is encrypted.
+func isTalosconfigEncrypted(path string) bool {
+	data, err := os.ReadFile(path)
+	return err == nil && age.IsEncrypted(data)
+}
+
+// saveTalosconfig writes the talosconfig back to the file, encrypting it again if it was encrypted.
+func saveTalosconfig(cfg *clientconfig.Config, path string) error {
+	if !isTalosconfigEncrypted(path) {
+		return cfg.Save(path)
+	}
+
+	data, err := cfg.Bytes()
+	if err != nil {
+		return err
+	}
+	passphrase, err := talosconfigPassphrase(false)
+	if err != nil {
+		return err
+	}
+	encrypted, err := age.Encrypt(data, passphrase)
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteFile(path, encrypted, 0o600)
+}
+
+// withEncryptedTalosconfig initializes the Talos client like GlobalArgs.WithClientNoNodes,
+// from the talosconfig decrypted in memory.
+func withEncryptedTalosconfig(action func(context.Context, *client.Client) error, dialOptions ...grpc.DialOption) error {
+	return cli.WithContext(context.Background(), func(ctx context.Context) error {
+		cfg, err := openTalosconfig(GlobalArgs.Talosconfig)
+		if err != nil {
+			return fmt.Errorf("failed to open config file %q: %w", GlobalArgs.Talosconfig, err)
+		}
+
+		opts := []client.OptionFunc{
+			client.WithConfig(cfg),
+			client.WithGRPCDialOptions(dialOptions...),
+		}
+		if GlobalArgs.CmdContext != "" {
+			opts = append(opts, client.WithContextName(GlobalArgs.CmdContext))
+		}
+		if len(GlobalArgs.Endpoints) > 0 {
+			opts = append(opts, client.WithEndpoints(GlobalArgs.Endpoints...))
+		}
+		if GlobalArgs.Cluster != "" {
+			opts = append(opts, client.WithCluster(GlobalArgs.Cluster))
+		}
+
+		c, err := client.New(ctx, opts...)
+		if err != nil {
+			return fmt.Errorf("error constructing client: %w", err)
+		}
+		//nolint:errcheck
+		defer c.Close()
+
+		return action(ctx, c)
+	})
+}
+
+var talosconfigDecryptCmdFlags struct {
+	inPlace bool
+}
+
+var talosconfigCmd = &cobra.Command{
+	Use:   "talosconfig",
+	Short: "Manage the encryption and the identities of the project talosconfig",
+	Long: `The talosconfig grants full admin access to the cluster. It can be stored encrypted with
+a passphrase in the age format and is decrypted in memory when commands run. The passphrase
+is read from the ` + TalosconfigPassphraseEnvVar + ` env variable, the command set in
+globalOptions.talosconfigPassphraseCommand of Chart.yaml (e.g. reading a keychain) or the terminal.`,
+}
+
+var talosconfigEncryptCmd = &cobra.Command{
+	Use:   "encrypt",
+	Short: "Encrypt the talosconfig in place",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := GlobalArgs.Talosconfig
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if age.IsEncrypted(data) {
+			return fmt.Errorf("%s is already encrypted", path)
+		}
+		if _, err := clientconfig.FromBytes(data); err != nil {
+			return fmt.Errorf("%s is not a valid talosconfig: %w", path, err)
+		}
+
+		passphrase, err := talosconfigPassphrase(true)
+		if err != nil {
+			return err
+		}
+		encrypted, err := age.Encrypt(data, passphrase)
+		if err != nil {
+			return err
+		}
+		if err := fileutil.WriteFile(path, encrypted, 0o600); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(os.Stderr, "Encrypted %s\n", path)
+		return nil
+	},
+}
+
+var talosconfigDecryptCmd = &cobra.Command{
+	Use:   "decrypt",
+	Short: "Print the decrypted talosconfig, or decrypt it in place",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := GlobalArgs.Talosconfig
+		data, encrypted, err := readTalosconfig(path)
+		if err != nil {
+			return err
+		}
+		if !encrypted {
+			return fmt.Errorf("%s is not encrypted", path)
+		}
+
+		if !talosconfigDecryptCmdFlags.inPlace {
+			_, err = os.Stdout.Write(data)
+			return err
+		}
+		if err := fileutil.WriteFile(path, data, 0o600); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(os.Stderr, "Decrypted %s\n", path)
+		return nil
+	},
+}
+
+func init() {
+	talosconfigDecryptCmd.Flags().BoolVar(&talosconfigDecryptCmdFlags.inPlace, "in-place", false, "write the decrypted talosconfig back to the file instead of printing it")
+
+	talosconfigCmd.AddCommand(talosconfigEncryptCmd, talosconfigDecryptCmd)
+	addCommand(talosconfigCmd)
+}
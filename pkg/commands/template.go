@@ -5,14 +5,23 @@
 package commands
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"slices"
+	"strings"
 
 	"github.com/aenix-io/talm/pkg/engine"
+	helmEngine "github.com/aenix-io/talm/pkg/engine/helm"
+	"github.com/aenix-io/talm/pkg/fileutil"
 	"github.com/aenix-io/talm/pkg/modeline"
+	"github.com/aenix-io/talm/pkg/userdata"
+	"github.com/pmezard/go-difflib/difflib"
 	"github.com/spf13/cobra"
 
 	"github.com/siderolabs/talos/pkg/machinery/client"
@@ -29,12 +38,30 @@ var templateCmdFlags struct {
 	fileValues        []string // --set-file
 	jsonValues        []string // --set-json
 	literalValues     []string // --set-literal
+	envValues         []string // --set-env
+	nodeValuesJSON    string   // --node-values
+	nodeValues        map[string]interface{}
+	nodeSets          []string // --set-node
+	nodeSetValues     map[string][]string
+	nodeSetsUsed      map[string]bool
 	talosVersion      string
 	withSecrets       string
+	withoutSecrets    bool
+	secretsManifest   string
 	full              bool
 	offline           bool
+	noCache           bool
 	kubernetesVersion string
 	inplace           bool
+	fromNode          bool
+	profileTemplates  bool
+	profile           *helmEngine.Profile
+	output            string
+	encoding          string
+	provider          string
+	outputDir         string
+	renderTarget      string
+	checkDeterminism  bool
 }
 
 var templateCmd = &cobra.Command{
@@ -49,6 +76,7 @@ var templateCmd = &cobra.Command{
 		templateCmdFlags.fileValues = append(Config.TemplateOptions.FileValues, templateCmdFlags.fileValues...)
 		templateCmdFlags.jsonValues = append(Config.TemplateOptions.JsonValues, templateCmdFlags.jsonValues...)
 		templateCmdFlags.literalValues = append(Config.TemplateOptions.LiteralValues, templateCmdFlags.literalValues...)
+		templateCmdFlags.envValues = append(Config.TemplateOptions.EnvValues, templateCmdFlags.envValues...)
 		if !cmd.Flags().Changed("talos-version") {
 			templateCmdFlags.talosVersion = Config.TemplateOptions.TalosVersion
 		}
@@ -64,9 +92,72 @@ var templateCmd = &cobra.Command{
 		if !cmd.Flags().Changed("offline") {
 			templateCmdFlags.offline = Config.TemplateOptions.Offline
 		}
+		if !cmd.Flags().Changed("check-determinism") {
+			templateCmdFlags.checkDeterminism = Config.TemplateOptions.CheckDeterminism
+		}
+		switch templateCmdFlags.output {
+		case "yaml":
+		case userDataOutput:
+			if templateCmdFlags.inplace || templateCmdFlags.withoutSecrets || templateCmdFlags.fromNode {
+				return fmt.Errorf("--output user-data can't be used with --in-place, --without-secrets or --from-node")
+			}
+			if err := userdata.Check(templateCmdFlags.encoding, templateCmdFlags.provider); err != nil {
+				return err
+			}
+			if len(templateCmdFlags.configFiles) > 1 && templateCmdFlags.outputDir == "" {
+				return fmt.Errorf("--output user-data renders one instance to stdout, use --output-dir for several node files")
+			}
+			// Instances boot from the user data alone, so it is the full config
+			templateCmdFlags.full = true
+		default:
+			return fmt.Errorf("unknown output %q, use yaml or %s", templateCmdFlags.output, userDataOutput)
+		}
+		if !cmd.Flags().Changed("render-target") {
+			// User data is applied by the instances as it is
+			templateCmdFlags.renderTarget = engine.RenderTargetTemplate
+			if templateCmdFlags.output == userDataOutput {
+				templateCmdFlags.renderTarget = engine.RenderTargetApply
+			}
+		}
+		if !slices.Contains(engine.RenderTargets, templateCmdFlags.renderTarget) {
+			return fmt.Errorf("unknown render target %q, use %s", templateCmdFlags.renderTarget, strings.Join(engine.RenderTargets, ", "))
+		}
+		if templateCmdFlags.outputDir != "" && templateCmdFlags.output != userDataOutput {
+			return fmt.Errorf("--output-dir requires --output user-data")
+		}
+		if templateCmdFlags.secretsManifest != "" && !templateCmdFlags.withoutSecrets {
+			return fmt.Errorf("--secrets-manifest requires --without-secrets")
+		}
+		if templateCmdFlags.nodeValuesJSON != "" {
+			if err := json.Unmarshal([]byte(templateCmdFlags.nodeValuesJSON), &templateCmdFlags.nodeValues); err != nil {
+				return fmt.Errorf("failed to parse --node-values: %w", err)
+			}
+		}
+		if templateCmdFlags.profileTemplates {
+			templateCmdFlags.profile = helmEngine.NewProfile()
+		}
+		templateCmdFlags.nodeSetValues = map[string][]string{}
+		templateCmdFlags.nodeSetsUsed = map[string]bool{}
+		for _, nodeSet := range templateCmdFlags.nodeSets {
+			node, value, err := parseNodeSet(nodeSet)
+			if err != nil {
+				return err
+			}
+			templateCmdFlags.nodeSetValues[node] = append(templateCmdFlags.nodeSetValues[node], value)
+		}
 		return nil
 	},
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if templateCmdFlags.fromNode {
+			if templateCmdFlags.offline || templateCmdFlags.inplace || len(templateCmdFlags.configFiles) > 0 {
+				return fmt.Errorf("--from-node can't be used with --offline, --in-place or --file")
+			}
+			if templateCmdFlags.insecure {
+				return fmt.Errorf("--from-node can't read the config using the insecure maintenance service")
+			}
+			return WithClient(templateFromNode)
+		}
+
 		templateFunc := template
 		if len(templateCmdFlags.configFiles) > 0 {
 			templateFunc = templateWithFiles
@@ -75,26 +166,50 @@ var templateCmd = &cobra.Command{
 			}
 		}
 
+		var err error
 		if templateCmdFlags.offline {
-			return templateFunc(args)(context.Background(), nil)
+			err = templateFunc(args)(context.Background(), nil)
+		} else if templateCmdFlags.insecure {
+			err = WithClientMaintenance(nil, templateFunc(args))
+		} else {
+			err = WithClient(templateFunc(args))
 		}
-		if templateCmdFlags.insecure {
-			return WithClientMaintenance(nil, templateFunc(args))
+		if err != nil {
+			return err
 		}
 
-		return WithClient(templateFunc(args))
+		if templateCmdFlags.secretsManifest != "" {
+			if err := writeSecretsManifest(templateCmdFlags.secretsManifest); err != nil {
+				return err
+			}
+		}
+
+		if templateCmdFlags.profile != nil {
+			if err := printTemplateProfile(os.Stderr, templateCmdFlags.profile); err != nil {
+				return err
+			}
+		}
+
+		for node := range templateCmdFlags.nodeSetValues {
+			if !templateCmdFlags.nodeSetsUsed[node] {
+				fmt.Fprintf(os.Stderr, "Warning: --set-node values for %s were not used, no rendered file targets this node\n", node)
+			}
+		}
+		return nil
 	},
 }
 
 func template(args []string) func(ctx context.Context, c *client.Client) error {
 	return func(ctx context.Context, c *client.Client) error {
-		output, err := generateOutput(ctx, c, args)
-		if err != nil {
+		w := bufio.NewWriter(os.Stdout)
+		if err := generateOutput(ctx, c, args, w); err != nil {
 			return err
 		}
 
-		fmt.Println(output)
-		return nil
+		if templateCmdFlags.output != userDataOutput {
+			fmt.Fprintln(w)
+		}
+		return w.Flush()
 	}
 }
 
@@ -103,8 +218,13 @@ func templateWithFiles(args []string) func(ctx context.Context, c *client.Client
 		templatesFromArgs := len(templateCmdFlags.templateFiles) > 0
 		nodesFromArgs := len(GlobalArgs.Nodes) > 0
 		endpointsFromArgs := len(GlobalArgs.Endpoints) > 0
+		nodeValuesFromArgs := templateCmdFlags.nodeValuesJSON != ""
 		firstFileProcessed := false
+		vipClaims := engine.VIPClaims{}
 		for _, configFile := range templateCmdFlags.configFiles {
+			if err := checkWorkspaceFile(configFile); err != nil {
+				return err
+			}
 			modelineConfig, err := modeline.ReadAndParseModeline(configFile)
 			if err != nil {
 				return fmt.Errorf("modeline parsing failed: %v\n", err)
@@ -122,6 +242,9 @@ func templateWithFiles(args []string) func(ctx context.Context, c *client.Client
 			if !endpointsFromArgs {
 				GlobalArgs.Endpoints = modelineConfig.Endpoints
 			}
+			if !nodeValuesFromArgs {
+				templateCmdFlags.nodeValues = modelineConfig.Values
+			}
 
 			if len(GlobalArgs.Nodes) < 1 {
 				return errors.New("nodes are not set for the command: please use `--nodes` flag or configuration file to set the nodes to run the command against")
@@ -132,23 +255,34 @@ func templateWithFiles(args []string) func(ctx context.Context, c *client.Client
 
 			template := func(args []string) func(ctx context.Context, c *client.Client) error {
 				return func(ctx context.Context, c *client.Client) error {
-					output, err := generateOutput(ctx, c, args)
-					if err != nil {
-						return err
-					}
-
 					if templateCmdFlags.inplace {
+						// Keep the output in memory, so the file is not truncated if rendering fails
+						var buf bytes.Buffer
+						if err := generateOutput(ctx, c, args, &buf); err != nil {
+							return err
+						}
+						if err := vipClaims.Add(configFile, buf.Bytes()); err != nil {
+							return err
+						}
 						fmt.Printf("- talm: file=%s, nodes=%s, endpoints=%s, templates=%s\n", configFile, GlobalArgs.Nodes, GlobalArgs.Endpoints, templateCmdFlags.templateFiles)
-						err = os.WriteFile(configFile, []byte(output), 0o644)
+						err = fileutil.WriteFile(configFile, buf.Bytes(), 0o644)
 						fmt.Fprintf(os.Stderr, "Updated.\n")
-					} else {
-						if firstFileProcessed {
-							fmt.Println("---")
-						}
-						fmt.Printf("%s", output)
+						return err
 					}
 
-					return nil
+					// Stream every document to stdout as soon as it is rendered
+					w := bufio.NewWriter(os.Stdout)
+					if firstFileProcessed && templateCmdFlags.output != userDataOutput {
+						fmt.Fprintln(w, "---")
+					}
+					var rendered bytes.Buffer
+					if err := generateOutput(ctx, c, args, io.MultiWriter(w, &rendered)); err != nil {
+						return err
+					}
+					if err := w.Flush(); err != nil {
+						return err
+					}
+					return vipClaims.Add(configFile, rendered.Bytes())
 				}
 			}
 
@@ -174,41 +308,143 @@ func templateWithFiles(args []string) func(ctx context.Context, c *client.Client
 			if !endpointsFromArgs {
 				GlobalArgs.Endpoints = []string{}
 			}
+			if !nodeValuesFromArgs {
+				templateCmdFlags.nodeValues = nil
+			}
 		}
 		return nil
 	}
 }
 
-func generateOutput(ctx context.Context, c *client.Client, args []string) (string, error) {
+// generateOutput renders the templates and writes them to w prefixed with the modeline.
+func generateOutput(ctx context.Context, c *client.Client, args []string, w io.Writer) error {
+	// Templates are stored in the modeline relative to the root with forward slashes,
+	// so node files stay the same when rendered on Windows
+	templateFiles := make([]string, 0, len(templateCmdFlags.templateFiles))
+	for _, file := range templateCmdFlags.templateFiles {
+		templateFiles = append(templateFiles, fileutil.TrimRoot(Config.RootDir, file))
+	}
+
+	// Values for the nodes of this file override the --set values
+	values := append([]string{}, templateCmdFlags.values...)
+	for _, node := range GlobalArgs.Nodes {
+		if nodeSets, ok := templateCmdFlags.nodeSetValues[node]; ok {
+			values = append(values, nodeSets...)
+			templateCmdFlags.nodeSetsUsed[node] = true
+		}
+	}
+
+	topology, err := nodeTopology(GlobalArgs.Nodes)
+	if err != nil {
+		return err
+	}
+
 	opts := engine.Options{
 		Insecure:          templateCmdFlags.insecure,
 		ValueFiles:        templateCmdFlags.valueFiles,
 		StringValues:      templateCmdFlags.stringValues,
-		Values:            templateCmdFlags.values,
+		Values:            values,
 		FileValues:        templateCmdFlags.fileValues,
 		JsonValues:        templateCmdFlags.jsonValues,
 		LiteralValues:     templateCmdFlags.literalValues,
+		NodeValues:        templateCmdFlags.nodeValues,
+		EnvValues:         templateCmdFlags.envValues,
+		EnvAllowlist:      Config.TemplateOptions.EnvAllowlist,
+		Plugins:           Config.TemplateOptions.Plugins,
 		TalosVersion:      templateCmdFlags.talosVersion,
 		WithSecrets:       templateCmdFlags.withSecrets,
+		WithoutSecrets:    templateCmdFlags.withoutSecrets,
 		Full:              templateCmdFlags.full,
 		Root:              Config.RootDir,
+		Extends:           Config.TemplateOptions.Extends,
 		Offline:           templateCmdFlags.offline,
+		NoLookupCache:     templateCmdFlags.noCache,
 		KubernetesVersion: templateCmdFlags.kubernetesVersion,
-		TemplateFiles:     templateCmdFlags.templateFiles,
+		TemplateFiles:     templateFiles,
+		Profile:           templateCmdFlags.profile,
+		Topology:          topology,
+		RenderTarget:      templateCmdFlags.renderTarget,
+		Deterministic:     templateCmdFlags.checkDeterminism,
 	}
 
-	result, err := engine.Render(ctx, c, opts)
-	if err != nil {
-		return "", fmt.Errorf("failed to render templates: %w", err)
+	// Talos reads the user data as is, so it has no modeline
+	if templateCmdFlags.output == userDataOutput {
+		var rendered bytes.Buffer
+		if err := renderTemplates(ctx, c, opts, &rendered); err != nil {
+			return fmt.Errorf("failed to render templates: %w", err)
+		}
+		return writeUserData(rendered.Bytes(), w)
 	}
 
-	modeline, err := modeline.GenerateModeline(GlobalArgs.Nodes, GlobalArgs.Endpoints, templateCmdFlags.templateFiles)
+	modelineConfig := &modeline.Config{
+		Nodes:     GlobalArgs.Nodes,
+		Endpoints: GlobalArgs.Endpoints,
+		Templates: templateFiles,
+		Values:    templateCmdFlags.nodeValues,
+	}
+	modeline, err := modelineConfig.Format()
 	if err != nil {
-		return "", fmt.Errorf("failed to generate modeline: %w", err)
+		return fmt.Errorf("failed to generate modeline: %w", err)
+	}
+
+	if _, err := fmt.Fprintln(w, modeline); err != nil {
+		return err
+	}
+
+	var rendered bytes.Buffer
+	if opts.WithoutSecrets {
+		w = io.MultiWriter(w, &rendered)
+	}
+
+	if err := renderTemplates(ctx, c, opts, w); err != nil {
+		return fmt.Errorf("failed to render templates: %w", err)
+	}
+
+	if opts.WithoutSecrets {
+		paths, err := engine.SecretPlaceholders(rendered.Bytes())
+		if err != nil {
+			return err
+		}
+		secretsManifest = append(secretsManifest, secretsManifestEntry{
+			Nodes:     GlobalArgs.Nodes,
+			Templates: templateFiles,
+			Secrets:   paths,
+		})
 	}
 
-	output := fmt.Sprintf("%s\n%s", modeline, string(result))
-	return output, nil
+	return nil
+}
+
+// renderTemplates renders the templates. Deterministic renders are done twice and fail if the
+// outputs differ, e.g. because a plugin or a lookup returns the items of a map in random order.
+func renderTemplates(ctx context.Context, c *client.Client, opts engine.Options, w io.Writer) error {
+	if !opts.Deterministic {
+		return engine.RenderTo(ctx, c, opts, w)
+	}
+
+	var first, second bytes.Buffer
+	if err := engine.RenderTo(ctx, c, opts, &first); err != nil {
+		return err
+	}
+	if err := engine.RenderTo(ctx, c, opts, &second); err != nil {
+		return err
+	}
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        difflib.SplitLines(first.String()),
+			B:        difflib.SplitLines(second.String()),
+			FromFile: "first render",
+			ToFile:   "second render",
+			Context:  3,
+		})
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("the templates rendered differently twice, the output must only depend on the templates and the values:\n%s", diff)
+	}
+
+	_, err := w.Write(first.Bytes())
+	return err
 }
 
 func init() {
@@ -222,10 +458,24 @@ func init() {
 	templateCmd.Flags().StringArrayVar(&templateCmdFlags.fileValues, "set-file", []string{}, "set values from respective files specified via the command line (can specify multiple or separate values with commas: key1=path1,key2=path2)")
 	templateCmd.Flags().StringArrayVar(&templateCmdFlags.jsonValues, "set-json", []string{}, "set JSON values on the command line (can specify multiple or separate values with commas: key1=jsonval1,key2=jsonval2)")
 	templateCmd.Flags().StringArrayVar(&templateCmdFlags.literalValues, "set-literal", []string{}, "set a literal STRING value on the command line")
+	templateCmd.Flags().StringArrayVar(&templateCmdFlags.envValues, "set-env", []string{}, "set values from environment variables on the command line (can specify multiple or separate values with commas: key1=ENV_VAR1,key2=ENV_VAR2)")
+	templateCmd.Flags().StringArrayVar(&templateCmdFlags.nodeSets, "set-node", []string{}, "set values for a single node only, like --set prefixed with the node address (can specify multiple: 192.168.1.10:key1=val1,key2=val2)")
+	templateCmd.Flags().StringVar(&templateCmdFlags.nodeValuesJSON, "node-values", "", "set per-node values as a JSON object, they are stored in the modeline and reused when re-templating the file")
 	templateCmd.Flags().StringVar(&templateCmdFlags.talosVersion, "talos-version", "", "the desired Talos version to generate config for (backwards compatibility, e.g. v0.8)")
-	templateCmd.Flags().StringVar(&templateCmdFlags.withSecrets, "with-secrets", "", "use a secrets file generated using 'gen secrets'")
+	templateCmd.Flags().StringVar(&templateCmdFlags.withSecrets, "with-secrets", "", "use a secrets file generated using 'gen secrets', - reads it from stdin")
+	templateCmd.Flags().BoolVar(&templateCmdFlags.withoutSecrets, "without-secrets", false, "render without the secrets bundle, secret fields are replaced by placeholders")
+	templateCmd.Flags().StringVar(&templateCmdFlags.secretsManifest, "secrets-manifest", "", "write the paths of the secret placeholders of every render to the file, with --without-secrets")
 	templateCmd.Flags().BoolVarP(&templateCmdFlags.full, "full", "", false, "show full resulting config, not only patch")
 	templateCmd.Flags().BoolVarP(&templateCmdFlags.offline, "offline", "", false, "disable gathering information and lookup functions")
+	templateCmd.Flags().BoolVar(&templateCmdFlags.fromNode, "from-node", false, "map the live config of the node back onto values of the chart and print the config the chart doesn't produce")
+	templateCmd.Flags().BoolVar(&templateCmdFlags.profileTemplates, "profile-templates", false, "report the time spent in every template and named template to stderr")
+	templateCmd.Flags().BoolVar(&templateCmdFlags.noCache, "no-cache", false, "query the node on every lookup call instead of reusing the results within a render")
+	templateCmd.Flags().StringVarP(&templateCmdFlags.output, "output", "o", "yaml", "output format: yaml, or user-data for the full config as user data of cloud instances")
+	templateCmd.Flags().StringVar(&templateCmdFlags.encoding, "encoding", userdata.Plain, fmt.Sprintf("encoding of the user data: %s", strings.Join(userdata.Encodings, ", ")))
+	templateCmd.Flags().StringVar(&templateCmdFlags.provider, "provider", "", fmt.Sprintf("name the user data files of --output-dir like the provider tooling expects: %s", strings.Join(userdata.Providers(), ", ")))
+	templateCmd.Flags().StringVar(&templateCmdFlags.outputDir, "output-dir", "", "write the user data of every node to a file in the directory instead of stdout")
+	templateCmd.Flags().StringVar(&templateCmdFlags.renderTarget, "render-target", engine.RenderTargetTemplate, fmt.Sprintf("the command the output is for, exposed to the templates as .RenderTarget: %s (defaults to apply for user data)", strings.Join(engine.RenderTargets, ", ")))
+	templateCmd.Flags().BoolVar(&templateCmdFlags.checkDeterminism, "check-determinism", false, "render twice with the functions depending on the time or on randomness failing, and fail if the renders differ")
 	templateCmd.Flags().StringVar(&templateCmdFlags.kubernetesVersion, "kubernetes-version", constants.DefaultKubernetesVersion, "desired kubernetes version to run")
 
 	addCommand(templateCmd)
@@ -255,3 +505,22 @@ func generateModeline(templates []string) (string, error) {
 	modeline := fmt.Sprintf(`# talm: nodes=%s, endpoints=%s, templates=%s`, string(nodesJSON), string(endpointsJSON), string(templatesJSON))
 	return modeline, nil
 }
+
+// parseNodeSet splits a --set-node value into the node address and the --set value.
+// IPv6 addresses are enclosed in brackets: [fd00::10]:key=value.
+func parseNodeSet(s string) (string, string, error) {
+	prefix, _, _ := strings.Cut(s, "=")
+	i := strings.LastIndex(prefix, ":")
+	if strings.HasPrefix(s, "[") {
+		i = strings.Index(prefix, "]:") + 1
+	}
+	if i <= 0 || i == len(prefix)-1 {
+		return "", "", fmt.Errorf("invalid --set-node value %q, expected node:key=value", s)
+	}
+
+	node := strings.TrimSuffix(strings.TrimPrefix(s[:i], "["), "]")
+	if node == "" {
+		return "", "", fmt.Errorf("invalid --set-node value %q, expected node:key=value", s)
+	}
+	return node, s[i+1:], nil
+}
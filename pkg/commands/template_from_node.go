@@ -0,0 +1,107 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aenix-io/talm/pkg/engine"
+	"gopkg.in/yaml.v3"
+
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	"github.com/siderolabs/talos/pkg/machinery/config/configloader"
+	"github.com/siderolabs/talos/pkg/machinery/config/machine"
+)
+
+// templateFromNode maps the live config of the node back onto the values of the chart, it prints
+// the recovered values and the residual config the chart doesn't produce.
+func templateFromNode(ctx context.Context, c *client.Client) error {
+	if len(GlobalArgs.Nodes) != 1 {
+		return fmt.Errorf("--from-node requires exactly one node, got %d", len(GlobalArgs.Nodes))
+	}
+	node := GlobalArgs.Nodes[0]
+
+	live, err := liveConfig(ctx, c, node)
+	if err != nil {
+		return fmt.Errorf("error reading the config of %s: %w", node, err)
+	}
+
+	templateFiles := templateCmdFlags.templateFiles
+	if len(templateFiles) == 0 {
+		cfg, err := configloader.NewFromBytes(live)
+		if err != nil {
+			return err
+		}
+		templateFile := "templates/worker.yaml"
+		if cfg.Machine().Type() == machine.TypeControlPlane || cfg.Machine().Type() == machine.TypeInit {
+			templateFile = "templates/controlplane.yaml"
+		}
+		templateFiles = []string{templateFile}
+	}
+
+	reverse, err := engine.ReverseRender(engine.Options{
+		ValueFiles:        templateCmdFlags.valueFiles,
+		StringValues:      templateCmdFlags.stringValues,
+		Values:            templateCmdFlags.values,
+		FileValues:        templateCmdFlags.fileValues,
+		JsonValues:        templateCmdFlags.jsonValues,
+		LiteralValues:     templateCmdFlags.literalValues,
+		NodeValues:        templateCmdFlags.nodeValues,
+		EnvValues:         templateCmdFlags.envValues,
+		EnvAllowlist:      Config.TemplateOptions.EnvAllowlist,
+		Plugins:           Config.TemplateOptions.Plugins,
+		TalosVersion:      templateCmdFlags.talosVersion,
+		WithSecrets:       templateCmdFlags.withSecrets,
+		Root:              Config.RootDir,
+		Extends:           Config.TemplateOptions.Extends,
+		KubernetesVersion: templateCmdFlags.kubernetesVersion,
+		TemplateFiles:     templateFiles,
+	}, live)
+	if err != nil {
+		return err
+	}
+
+	values := map[string]interface{}{}
+	for valuePath, value := range reverse.Values {
+		keys := strings.Split(valuePath, ".")
+		nested := values
+		for _, key := range keys[:len(keys)-1] {
+			next, ok := nested[key].(map[string]interface{})
+			if !ok {
+				next = map[string]interface{}{}
+				nested[key] = next
+			}
+			nested = next
+		}
+		nested[keys[len(keys)-1]] = value
+	}
+
+	fmt.Printf("# Values recovered from the config of %s rendered by %s, merge them into values.yaml\n", node, strings.Join(templateFiles, ", "))
+	encoder := yaml.NewEncoder(os.Stdout)
+	encoder.SetIndent(2)
+	if len(values) > 0 {
+		if err := encoder.Encode(values); err != nil {
+			return err
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+
+	for _, conflict := range reverse.Conflicts {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", conflict)
+	}
+
+	if len(reverse.Residual) == 0 {
+		fmt.Fprintf(os.Stderr, "The chart produces the whole config of %s\n", node)
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "\nResidual config of %s not produced by the chart, add it to the templates or keep it in the node file:\n%s",
+		node, reverse.Residual)
+	return nil
+}
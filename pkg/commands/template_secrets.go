@@ -0,0 +1,42 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// secretsManifestEntry lists the secret placeholders of a config rendered without secrets.
+type secretsManifestEntry struct {
+	Nodes     []string `yaml:"nodes"`
+	Templates []string `yaml:"templates"`
+	Secrets   []string `yaml:"secrets"`
+}
+
+// secretsManifest collects the entries of all renders of the command.
+var secretsManifest []secretsManifestEntry
+
+// writeSecretsManifest writes the secret placeholders of the renders, to substitute them
+// before the configs are applied.
+func writeSecretsManifest(file string) error {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(secretsManifest); err != nil {
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(file, buf.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("error writing secrets manifest: %w", err)
+	}
+	return nil
+}
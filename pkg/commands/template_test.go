@@ -0,0 +1,40 @@
+package commands
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseNodeSet(t *testing.T) {
+	for _, tt := range []struct {
+		value string
+		node  string
+		set   string
+		err   string
+	}{
+		{value: "192.168.1.10:floatingIP=10.0.0.5", node: "192.168.1.10", set: "floatingIP=10.0.0.5"},
+		{value: "node1:nodeLabels.zone=a", node: "node1", set: "nodeLabels.zone=a"},
+		// The value can contain colons
+		{value: "192.168.1.10:image=ghcr.io/siderolabs/installer:v1.7.6", node: "192.168.1.10", set: "image=ghcr.io/siderolabs/installer:v1.7.6"},
+		{value: "[fd00::10]:floatingIP=fd00::5", node: "fd00::10", set: "floatingIP=fd00::5"},
+		{value: "[fd00::10]:endpoint=https://[fd00::1]:6443", node: "fd00::10", set: "endpoint=https://[fd00::1]:6443"},
+		{value: "floatingIP=10.0.0.5", err: "expected node:key=value"},
+		{value: ":floatingIP=10.0.0.5", err: "expected node:key=value"},
+		{value: "192.168.1.10:=10.0.0.5", err: "expected node:key=value"},
+		{value: "[fd00::10]floatingIP=fd00::5", err: "expected node:key=value"},
+		{value: "[fd00::10]:=fd00::5", err: "expected node:key=value"},
+		{value: "[]:floatingIP=fd00::5", err: "expected node:key=value"},
+	} {
+		node, set, err := parseNodeSet(tt.value)
+		switch {
+		case tt.err != "":
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: expected error %q, got %q, %q, %v", tt.value, tt.err, node, set, err)
+			}
+		case err != nil:
+			t.Errorf("%s: %v", tt.value, err)
+		case node != tt.node || set != tt.set:
+			t.Errorf("%s: expected %q and %q, got %q and %q", tt.value, tt.node, tt.set, node, set)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/aenix-io/talm/pkg/userdata"
+
+	"github.com/siderolabs/talos/pkg/machinery/config/configloader"
+)
+
+// userDataOutput is the --output of talm template rendering the full configs as instance user data.
+const userDataOutput = "user-data"
+
+// writeUserData encodes the full config as user data and writes it to w, or with --output-dir to
+// the files of every node named by the conventions of --provider. The instances are named after
+// the hostname of the config, or the addresses of the nodes without one.
+func writeUserData(config []byte, w io.Writer) error {
+	data, err := userdata.Encode(config, templateCmdFlags.encoding)
+	if err != nil {
+		return err
+	}
+	if templateCmdFlags.outputDir == "" {
+		_, err = w.Write(data)
+		return err
+	}
+
+	names := GlobalArgs.Nodes
+	provider, err := configloader.NewFromBytes(config)
+	if err != nil {
+		return fmt.Errorf("error loading the rendered config: %w", err)
+	}
+	if hostname := provider.Machine().Network().Hostname(); hostname != "" {
+		names = []string{hostname}
+	}
+	if len(names) == 0 {
+		return errors.New("the user data files are named after the hostname or the nodes, set machine.network.hostname or --nodes")
+	}
+
+	for _, name := range names {
+		files, err := userdata.Files(templateCmdFlags.provider, name, data)
+		if err != nil {
+			return err
+		}
+		paths := make([]string, 0, len(files))
+		for path := range files {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+
+		for _, path := range paths {
+			file := filepath.Join(templateCmdFlags.outputDir, filepath.FromSlash(path))
+			if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+				return err
+			}
+			// User data holds the secrets of the cluster
+			if err := os.WriteFile(file, files[path], 0o600); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Written %s\n", file)
+		}
+	}
+	return nil
+}
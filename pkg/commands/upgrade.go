@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"github.com/aenix-io/talm/pkg/engine"
+	"github.com/aenix-io/talm/pkg/hooks"
 	"github.com/siderolabs/gen/maps"
 	"github.com/siderolabs/gen/xslices"
 	"github.com/spf13/cobra"
@@ -26,6 +27,7 @@ import (
 	"github.com/siderolabs/talos/pkg/cli"
 	"github.com/siderolabs/talos/pkg/machinery/api/machine"
 	"github.com/siderolabs/talos/pkg/machinery/client"
+	"github.com/siderolabs/talos/pkg/machinery/config"
 	"github.com/siderolabs/talos/pkg/machinery/config/configloader"
 	"github.com/siderolabs/talos/pkg/machinery/constants"
 )
@@ -36,6 +38,11 @@ var upgradeCmdFlags struct {
 	preserve          bool
 	stage             bool
 	force             bool
+	prepull           bool
+	prepullOnly       bool
+	showNotes         bool
+	skipEtcdChecks    bool
+	ignoreWindows     bool
 	insecure          bool
 	configFiles       []string // -f/--files
 	talosVersion      string
@@ -67,6 +74,9 @@ var upgradeCmd = &cobra.Command{
 		if !cmd.Flags().Changed("force") {
 			upgradeCmdFlags.force = Config.UpgradeOptions.Force
 		}
+		if !cmd.Flags().Changed("prepull") {
+			upgradeCmdFlags.prepull = Config.UpgradeOptions.Prepull
+		}
 		return nil
 	},
 
@@ -75,6 +85,9 @@ var upgradeCmd = &cobra.Command{
 			upgradeCmdFlags.wait = true
 		}
 
+		if (upgradeCmdFlags.prepull || upgradeCmdFlags.prepullOnly) && upgradeCmdFlags.insecure {
+			return fmt.Errorf("cannot pre-pull images using the insecure maintenance service")
+		}
 		if upgradeCmdFlags.wait && upgradeCmdFlags.insecure {
 			return fmt.Errorf("cannot use --wait and --insecure together")
 		}
@@ -97,35 +110,50 @@ func upgrade(args []string) func(ctx context.Context, c *client.Client) error {
 			return fmt.Errorf("invalid reboot mode: %s", upgradeCmdFlags.rebootMode)
 		}
 
-		for _, configFile := range upgradeCmdFlags.configFiles {
-			if err := processModelineAndUpdateGlobals(configFile, nodesFromArgs, endpointsFromArgs, true); err != nil {
+		// The notes are read before anything changes on the nodes
+		if upgradeCmdFlags.showNotes {
+			return showUpgradeNotes(ctx, c, nodesFromArgs, endpointsFromArgs)
+		}
+
+		if upgradeCmdFlags.prepull || upgradeCmdFlags.prepullOnly {
+			if err := prepullImages(ctx, c, nodesFromArgs, endpointsFromArgs); err != nil {
 				return err
 			}
-
-			eopts := engine.Options{
-				TalosVersion:      upgradeCmdFlags.talosVersion,
-				WithSecrets:       upgradeCmdFlags.withSecrets,
-				KubernetesVersion: upgradeCmdFlags.kubernetesVersion,
+			if upgradeCmdFlags.prepullOnly {
+				return nil
 			}
+		}
 
-			patches := []string{"@" + configFile}
-			configBundle, err := engine.FullConfigProcess(ctx, eopts, patches)
-			if err != nil {
-				return fmt.Errorf("full config processing error: %s", err)
+		if err := Config.Hooks.Check(); err != nil {
+			return err
+		}
+
+		upgradeCmdFlags.configFiles = orderConfigFiles(upgradeCmdFlags.configFiles)
+		etcdChecks := !upgradeCmdFlags.insecure && !upgradeCmdFlags.skipEtcdChecks
+		if etcdChecks && !nodesFromArgs {
+			upgradeCmdFlags.configFiles = etcdLeaderLast(ctx, c, upgradeCmdFlags.configFiles)
+		}
+		completed := []string{}
+		defer func() { printInterruptSummary(ctx, completed, upgradeCmdFlags.configFiles) }()
+		for _, configFile := range upgradeCmdFlags.configFiles {
+			if err := processModelineAndUpdateGlobals(configFile, nodesFromArgs, endpointsFromArgs, true); err != nil {
+				return err
 			}
 
-			machineType := configBundle.ControlPlaneCfg.Machine().Type()
-			result, err := engine.SerializeConfiguration(configBundle, machineType)
+			cfg, err := upgradeConfig(ctx, configFile)
 			if err != nil {
-				return fmt.Errorf("error serializing configuration: %s", err)
+				return err
 			}
 
-			config, err := configloader.NewFromBytes(result)
+			// The drift from the schematic can't be read from the maintenance service
+			driftClient := c
+			if upgradeCmdFlags.insecure {
+				driftClient = nil
+			}
+			image, err := schematicInstallerImage(ctx, driftClient, configFile, cfg.Machine().Install().Image())
 			if err != nil {
 				return err
 			}
-
-			image := config.Machine().Install().Image()
 			if image == "" {
 				return fmt.Errorf("error getting image from config")
 			}
@@ -138,8 +166,33 @@ func upgrade(args []string) func(ctx context.Context, c *client.Client) error {
 				client.WithUpgradeForce(upgradeCmdFlags.force),
 			}
 
+			// Nodes in maintenance mode run no workloads to disrupt
+			if !upgradeCmdFlags.insecure && !upgradeCmdFlags.ignoreWindows {
+				closed, next, err := maintenanceWindowClosed(GlobalArgs.Nodes, time.Now())
+				if err != nil {
+					return err
+				}
+				if closed {
+					reportPending(configFile, "upgrade to "+image, next)
+					continue
+				}
+			}
+
+			if etcdChecks && cfg.Machine().Type().IsControlPlane() {
+				if err := checkEtcdQuorum(ctx, c, GlobalArgs.Nodes); err != nil {
+					return err
+				}
+			}
+
+			if err := runHooks(ctx, hooks.Pre, "upgrade", configFile, cfg); err != nil {
+				return err
+			}
+
 			if !upgradeCmdFlags.wait {
-				return runUpgradeNoWait(opts)
+				if err := runUpgradeNoWait(opts); err != nil {
+					return err
+				}
+				return runHooks(ctx, hooks.Post, "upgrade", configFile, cfg)
 			}
 
 			common.SuppressErrors = true
@@ -159,10 +212,36 @@ func upgrade(args []string) func(ctx context.Context, c *client.Client) error {
 			if err != nil {
 				return err
 			}
+			if err := runHooks(ctx, hooks.Post, "upgrade", configFile, cfg); err != nil {
+				return err
+			}
+			completed = append(completed, configFile)
 		}
 		return nil
 	}
-	return nil
+}
+
+// upgradeConfig renders the node file to the full machine config.
+func upgradeConfig(ctx context.Context, configFile string) (config.Provider, error) {
+	eopts := engine.Options{
+		TalosVersion:      upgradeCmdFlags.talosVersion,
+		WithSecrets:       upgradeCmdFlags.withSecrets,
+		KubernetesVersion: upgradeCmdFlags.kubernetesVersion,
+	}
+
+	patches := []string{"@" + configFile}
+	configBundle, err := engine.FullConfigProcess(ctx, eopts, patches)
+	if err != nil {
+		return nil, fmt.Errorf("full config processing error: %s", err)
+	}
+
+	machineType := configBundle.ControlPlaneCfg.Machine().Type()
+	result, err := engine.SerializeConfiguration(configBundle, machineType)
+	if err != nil {
+		return nil, fmt.Errorf("error serializing configuration: %s", err)
+	}
+
+	return configloader.NewFromBytes(result)
 }
 
 func runUpgradeNoWait(opts []client.UpgradeOption) error {
@@ -238,12 +317,17 @@ func init() {
 	upgradeCmd.Flags().BoolVarP(&upgradeCmdFlags.preserve, "preserve", "p", false, "preserve data")
 	upgradeCmd.Flags().BoolVarP(&upgradeCmdFlags.stage, "stage", "", false, "stage the upgrade to perform it after a reboot")
 	upgradeCmd.Flags().BoolVarP(&upgradeCmdFlags.force, "force", "", false, "force the upgrade (skip checks on etcd health and members, might lead to data loss)")
+	upgradeCmd.Flags().BoolVar(&upgradeCmdFlags.prepull, "prepull", false, "pull the installer and kubelet images on all nodes before upgrading the first one")
+	upgradeCmd.Flags().BoolVar(&upgradeCmdFlags.skipEtcdChecks, "skip-etcd-checks", false, "do not upgrade the etcd leader last and do not check that etcd keeps its quorum")
+	upgradeCmd.Flags().BoolVar(&upgradeCmdFlags.ignoreWindows, "ignore-maintenance-windows", false, "upgrade the nodes outside their maintenance windows")
+	upgradeCmd.Flags().BoolVar(&upgradeCmdFlags.prepullOnly, "prepull-only", false, "only pull the images ahead of the upgrade and report readiness, don't upgrade")
+	upgradeCmd.Flags().BoolVar(&upgradeCmdFlags.showNotes, "show-notes", false, "only show the sections of the Talos release notes relevant to the upgrade and the config of the nodes, don't upgrade")
 	upgradeCmdFlags.addTrackActionFlags(upgradeCmd)
 
 	upgradeCmd.Flags().BoolVarP(&upgradeCmdFlags.insecure, "insecure", "i", false, "apply using the insecure (encrypted with no auth) maintenance service")
 	upgradeCmd.Flags().StringSliceVarP(&upgradeCmdFlags.configFiles, "file", "f", nil, "specify config files or patches in a YAML file (can specify multiple)")
 	upgradeCmd.Flags().StringVar(&upgradeCmdFlags.talosVersion, "talos-version", "", "the desired Talos version to generate config for (backwards compatibility, e.g. v0.8)")
-	upgradeCmd.Flags().StringVar(&upgradeCmdFlags.withSecrets, "with-secrets", "", "use a secrets file generated using 'gen secrets'")
+	upgradeCmd.Flags().StringVar(&upgradeCmdFlags.withSecrets, "with-secrets", "", "use a secrets file generated using 'gen secrets', - reads it from stdin")
 	upgradeCmd.Flags().StringVar(&upgradeCmdFlags.kubernetesVersion, "kubernetes-version", constants.DefaultKubernetesVersion, "desired kubernetes version to run")
 
 	addCommand(upgradeCmd)
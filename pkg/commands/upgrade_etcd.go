@@ -0,0 +1,148 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+
+	"github.com/aenix-io/talm/pkg/modeline"
+
+	"github.com/siderolabs/talos/pkg/machinery/api/machine"
+	"github.com/siderolabs/talos/pkg/machinery/client"
+)
+
+// etcdMemberStatus returns the status of the etcd member running on the node.
+func etcdMemberStatus(ctx context.Context, c *client.Client, node string) (*machine.EtcdMemberStatus, error) {
+	resp, err := c.EtcdStatus(client.WithNode(ctx, node))
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.GetMessages()) == 0 || resp.GetMessages()[0].GetMemberStatus() == nil {
+		return nil, fmt.Errorf("no etcd status returned by node %s", node)
+	}
+
+	status := resp.GetMessages()[0].GetMemberStatus()
+	if len(status.GetErrors()) > 0 {
+		return status, fmt.Errorf("etcd member on node %s reports errors: %v", node, status.GetErrors())
+	}
+	return status, nil
+}
+
+// etcdLeaderLast moves the node file of the etcd leader after the other control plane node files,
+// so the leadership is transferred only once during the upgrade.
+func etcdLeaderLast(ctx context.Context, c *client.Client, files []string) []string {
+	leaderFile := ""
+	lastControlPlane := -1
+	for i, file := range files {
+		if nodeFileMachineType(file) != "controlplane" {
+			continue
+		}
+		lastControlPlane = i
+
+		modelineConfig, err := modeline.ReadAndParseModeline(file)
+		if err != nil || leaderFile != "" {
+			continue
+		}
+		for _, node := range modelineConfig.Nodes {
+			status, err := etcdMemberStatus(ctx, c, node)
+			if err == nil && status.GetMemberId() == status.GetLeader() {
+				leaderFile = file
+				break
+			}
+		}
+	}
+
+	if leaderFile == "" || files[lastControlPlane] == leaderFile {
+		return files
+	}
+
+	ordered := make([]string, 0, len(files))
+	for i, file := range files {
+		if file != leaderFile {
+			ordered = append(ordered, file)
+		}
+		if i == lastControlPlane {
+			ordered = append(ordered, leaderFile)
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Node file %s runs the etcd leader, it is upgraded after the other control plane nodes\n", leaderFile)
+	return ordered
+}
+
+// checkEtcdQuorum verifies that etcd keeps its quorum while the control plane nodes are upgraded,
+// i.e. the healthy members on the other nodes form a quorum, and moves the leadership away from them.
+func checkEtcdQuorum(ctx context.Context, c *client.Client, nodes []string) error {
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	resp, err := c.EtcdMemberList(client.WithNode(ctx, nodes[0]), &machine.EtcdMemberListRequest{})
+	if err != nil {
+		return fmt.Errorf("error listing etcd members: %w", err)
+	}
+	if len(resp.GetMessages()) == 0 {
+		return fmt.Errorf("no etcd members returned by node %s", nodes[0])
+	}
+
+	upgraded := map[string]bool{}
+	for _, node := range nodes {
+		upgraded[node] = true
+	}
+
+	var voting, others, down int
+	for _, member := range resp.GetMessages()[0].GetMembers() {
+		if member.GetIsLearner() {
+			continue
+		}
+		voting++
+
+		node := member.GetHostname()
+		if len(member.GetPeerUrls()) > 0 {
+			if u, err := url.Parse(member.GetPeerUrls()[0]); err == nil {
+				node = u.Hostname()
+			}
+		}
+		if upgraded[node] || upgraded[member.GetHostname()] {
+			continue
+		}
+
+		others++
+		if _, err := etcdMemberStatus(ctx, c, node); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: etcd member %s is unhealthy: %s\n", member.GetHostname(), err)
+			down++
+		}
+	}
+
+	// Members of the upgraded nodes not found by address are assumed to be among the others
+	healthy := others - down
+	if others == voting {
+		healthy -= len(nodes)
+	}
+
+	quorum := voting/2 + 1
+	if voting < 3 {
+		fmt.Fprintf(os.Stderr, "Warning: etcd has %d members, it loses quorum while %s is upgraded\n", voting, nodes)
+	} else if healthy < quorum {
+		return fmt.Errorf("upgrading %s would take etcd below quorum: %d of %d members stay healthy, %d are required",
+			nodes, healthy, voting, quorum)
+	}
+
+	for _, node := range nodes {
+		status, err := etcdMemberStatus(ctx, c, node)
+		if err != nil || status.GetMemberId() != status.GetLeader() {
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "Transferring etcd leadership away from %s\n", node)
+		if _, err := c.EtcdForfeitLeadership(client.WithNode(ctx, node), &machine.EtcdForfeitLeadershipRequest{}); err != nil {
+			return fmt.Errorf("error transferring etcd leadership away from %s: %w", node, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,208 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aenix-io/talm/pkg/releasenotes"
+	"github.com/blang/semver/v4"
+
+	"github.com/siderolabs/talos/pkg/machinery/client"
+)
+
+const talosReleasesURL = "https://api.github.com/repos/siderolabs/talos/releases?per_page=100"
+
+// fetchTalosReleases requests the recent Talos releases with their notes from GitHub.
+func fetchTalosReleases(ctx context.Context) ([]releasenotes.Release, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, talosReleasesURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching Talos release notes: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching Talos release notes: %s", resp.Status)
+	}
+
+	var releases []struct {
+		TagName    string `json:"tag_name"`
+		HTMLURL    string `json:"html_url"`
+		Body       string `json:"body"`
+		Prerelease bool   `json:"prerelease"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&releases); err != nil {
+		return nil, fmt.Errorf("error decoding Talos release notes: %w", err)
+	}
+
+	result := make([]releasenotes.Release, 0, len(releases))
+	for _, r := range releases {
+		result = append(result, releasenotes.Release{Tag: r.TagName, URL: r.HTMLURL, Body: r.Body, Prerelease: r.Prerelease})
+	}
+	return result, nil
+}
+
+// imageVersion returns the tag of the installer image, the Talos version it installs.
+func imageVersion(image string) string {
+	image, _, _ = strings.Cut(image, "@")
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[i+1:]
+	}
+	return ""
+}
+
+// oldestNodeVersion returns the oldest Talos version running on the nodes of the context,
+// or an empty string if it can't be read, e.g. from the maintenance service.
+func oldestNodeVersion(ctx context.Context, c *client.Client) string {
+	resp, err := c.Version(ctx)
+	if err != nil || resp == nil {
+		return ""
+	}
+
+	oldest, oldestVersion := "", semver.Version{}
+	for _, message := range resp.Messages {
+		tag := message.GetVersion().GetTag()
+		v, err := semver.ParseTolerant(tag)
+		if err != nil {
+			continue
+		}
+		if oldest == "" || v.LT(oldestVersion) {
+			oldest, oldestVersion = tag, v
+		}
+	}
+	return oldest
+}
+
+// upgradeStep is an upgrade between two Talos versions shared by node files.
+type upgradeStep struct {
+	from, to string
+	files    []string
+	keys     map[string]struct{}
+}
+
+// showUpgradeNotes prints the sections of the Talos release notes relevant to the upgrade of
+// the node files: the releases between the running and the target version, and in their
+// notes the sections requiring an action or about the config set by the node files.
+func showUpgradeNotes(ctx context.Context, c *client.Client, nodesFromArgs, endpointsFromArgs bool) error {
+	steps := map[string]*upgradeStep{}
+	for _, configFile := range upgradeCmdFlags.configFiles {
+		if err := processModelineAndUpdateGlobals(configFile, nodesFromArgs, endpointsFromArgs, true); err != nil {
+			return err
+		}
+
+		cfg, err := upgradeConfig(ctx, configFile)
+		if err != nil {
+			return err
+		}
+		driftClient := c
+		if upgradeCmdFlags.insecure {
+			driftClient = nil
+		}
+		image, err := schematicInstallerImage(ctx, driftClient, configFile, cfg.Machine().Install().Image())
+		if err != nil {
+			return err
+		}
+		if image == "" {
+			return fmt.Errorf("error getting image from config")
+		}
+		to := imageVersion(image)
+		if to == "" {
+			return fmt.Errorf("%s: failed to read the Talos version of the installer image %q", configFile, image)
+		}
+
+		from := ""
+		if !upgradeCmdFlags.insecure {
+			from = oldestNodeVersion(client.WithNodes(ctx, GlobalArgs.Nodes...), c)
+		}
+
+		data, err := os.ReadFile(configFile)
+		if err != nil {
+			return err
+		}
+		keys, err := releasenotes.ConfigKeys(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", configFile, err)
+		}
+
+		id := from + " " + to
+		step, ok := steps[id]
+		if !ok {
+			step = &upgradeStep{from: from, to: to, keys: map[string]struct{}{}}
+			steps[id] = step
+		}
+		step.files = append(step.files, configFile)
+		for _, key := range keys {
+			step.keys[key] = struct{}{}
+		}
+
+		if !nodesFromArgs {
+			GlobalArgs.Nodes = []string{}
+		}
+		if !endpointsFromArgs {
+			GlobalArgs.Endpoints = []string{}
+		}
+	}
+
+	releases, err := fetchTalosReleases(ctx)
+	if err != nil {
+		return err
+	}
+
+	ids := make([]string, 0, len(steps))
+	for id := range steps {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		step := steps[id]
+		from := step.from
+		if from == "" {
+			from = "unknown version"
+		}
+		fmt.Printf("# Talos %s to %s: %s\n\n", from, step.to, strings.Join(step.files, ", "))
+
+		between, err := releasenotes.Between(releases, step.from, step.to)
+		if err != nil {
+			return fmt.Errorf("invalid Talos version %q of the installer image: %w", step.to, err)
+		}
+		if len(between) == 0 {
+			fmt.Printf("No release notes: no recent Talos release newer than %s up to %s.\n\n", from, step.to)
+			continue
+		}
+
+		keys := make([]string, 0, len(step.keys))
+		for key := range step.keys {
+			keys = append(keys, key)
+		}
+		for _, r := range between {
+			sections := releasenotes.Split(r.Body)
+			relevant := releasenotes.Filter(sections, keys)
+			fmt.Printf("## %s (%d of %d sections, all notes at %s)\n\n", r.Tag, len(relevant), len(sections), r.URL)
+			for _, section := range relevant {
+				fmt.Printf("### %s\n\n%s\n\n", section.Title, section.Body)
+			}
+		}
+	}
+
+	fmt.Fprintln(os.Stderr, "The nodes were not upgraded, run talm upgrade without --show-notes to upgrade them.")
+	return nil
+}
@@ -0,0 +1,74 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/siderolabs/talos/pkg/machinery/api/common"
+	"github.com/siderolabs/talos/pkg/machinery/client"
+)
+
+// prepullImages pulls the installer and kubelet images of every node file on its nodes
+// before the first node is upgraded, so the upgrade doesn't wait for the downloads.
+// Both images are pulled to the system namespace of containerd, where Talos runs them from.
+func prepullImages(ctx context.Context, c *client.Client, nodesFromArgs, endpointsFromArgs bool) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "NODE\tIMAGE\tSTATUS\tDURATION")
+
+	failed := 0
+	for _, configFile := range upgradeCmdFlags.configFiles {
+		if err := processModelineAndUpdateGlobals(configFile, nodesFromArgs, endpointsFromArgs, true); err != nil {
+			return err
+		}
+
+		cfg, err := upgradeConfig(ctx, configFile)
+		if err != nil {
+			return err
+		}
+
+		installerImage, err := schematicInstallerImage(ctx, nil, configFile, cfg.Machine().Install().Image())
+		if err != nil {
+			return err
+		}
+		images := []string{installerImage, cfg.Machine().Kubelet().Image()}
+
+		for _, node := range GlobalArgs.Nodes {
+			for _, image := range images {
+				if image == "" {
+					continue
+				}
+
+				fmt.Fprintf(os.Stderr, "- talm: pulling %s on %s\n", image, node)
+
+				start := time.Now()
+				status := "ready"
+				if err := c.ImagePull(client.WithNode(ctx, node), common.ContainerdNamespace_NS_SYSTEM, image); err != nil {
+					if ctx.Err() != nil {
+						return ctx.Err()
+					}
+					status = "failed: " + err.Error()
+					failed++
+				}
+
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", node, image, status, time.Since(start).Round(time.Second))
+			}
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to pre-pull %d image(s), the upgrade was not started", failed)
+	}
+
+	return nil
+}
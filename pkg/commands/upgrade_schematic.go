@@ -0,0 +1,132 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/aenix-io/talm/pkg/engine"
+	"github.com/aenix-io/talm/pkg/modeline"
+	"github.com/aenix-io/talm/pkg/schematic"
+	"github.com/cosi-project/runtime/pkg/safe"
+
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	"github.com/siderolabs/talos/pkg/machinery/resources/runtime"
+)
+
+// nodeSchematic returns the schematic of the values of the node file, including the values
+// of its modeline, or nil if the values don't define one.
+func nodeSchematic(configFile string) (*schematic.Values, error) {
+	modelineConfig, err := modeline.ReadAndParseModeline(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := engine.Values(engine.Options{
+		Root:          Config.RootDir,
+		Extends:       Config.TemplateOptions.Extends,
+		ValueFiles:    Config.TemplateOptions.ValueFiles,
+		Values:        Config.TemplateOptions.Values,
+		StringValues:  Config.TemplateOptions.StringValues,
+		FileValues:    Config.TemplateOptions.FileValues,
+		JsonValues:    Config.TemplateOptions.JsonValues,
+		LiteralValues: Config.TemplateOptions.LiteralValues,
+		NodeValues:    modelineConfig.Values,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return schematic.FromValues(values)
+}
+
+// installedSchematic reads the schematic ID, the extensions and the kernel args running on the node.
+func installedSchematic(ctx context.Context, c *client.Client, node string) (schematic.Installed, error) {
+	var installed schematic.Installed
+	nodeCtx := client.WithNode(ctx, node)
+
+	extensions, err := safe.StateListAll[*runtime.ExtensionStatus](nodeCtx, c.COSI)
+	if err != nil {
+		return installed, fmt.Errorf("error listing extensions: %w", err)
+	}
+	for it := extensions.Iterator(); it.Next(); {
+		metadata := it.Value().TypedSpec().Metadata
+		if metadata.Name == schematic.ExtensionName {
+			installed.ID = metadata.Version
+			continue
+		}
+		installed.Extensions = append(installed.Extensions, metadata.Name)
+	}
+
+	r, err := c.Read(nodeCtx, "/proc/cmdline")
+	if err != nil {
+		return installed, fmt.Errorf("error reading kernel args: %w", err)
+	}
+	//nolint:errcheck
+	defer r.Close()
+
+	cmdline, err := io.ReadAll(r)
+	if err != nil {
+		return installed, fmt.Errorf("error reading kernel args: %w", err)
+	}
+	installed.KernelArgs = strings.Fields(string(cmdline))
+
+	return installed, nil
+}
+
+// schematicInstallerImage returns the installer image for the schematic of the values of the node file.
+// The image of a node file rendered before the schematic changed is replaced, so changing the extensions
+// or kernel args in the values triggers the change of the installer image. The drift of every node
+// from the schematic is reported when c is set. Without a schematic the image is returned unchanged.
+func schematicInstallerImage(ctx context.Context, c *client.Client, configFile, image string) (string, error) {
+	desired, err := nodeSchematic(configFile)
+	if err != nil || desired == nil {
+		return image, err
+	}
+
+	if desired.Version == "" {
+		// The Talos version of the config image is kept
+		if tag := strings.LastIndex(image, ":"); tag > strings.LastIndex(image, "/") {
+			desired.Version = image[tag+1:]
+		}
+	}
+	desiredImage, err := desired.InstallerImage()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", configFile, err)
+	}
+
+	drifted := desiredImage != image
+	if c != nil {
+		for _, node := range GlobalArgs.Nodes {
+			installed, err := installedSchematic(ctx, c, node)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to check extension drift of %s: %s\n", node, err)
+				continue
+			}
+
+			drift, err := desired.Diff(installed)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(os.Stderr, "- talm: node=%s, %s\n", node, drift)
+			drifted = drifted || !drift.Empty()
+		}
+	}
+
+	if drifted {
+		if err := desired.Register(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to register the schematic at %s: %s\n", desired.Factory, err)
+		}
+	}
+	if desiredImage != image {
+		fmt.Fprintf(os.Stderr, "Installer image of %s changed to %s to match the schematic\n", configFile, desiredImage)
+	}
+
+	return desiredImage, nil
+}
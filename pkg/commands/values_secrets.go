@@ -0,0 +1,159 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aenix-io/talm/pkg/age"
+	"github.com/aenix-io/talm/pkg/engine"
+	"golang.org/x/term"
+)
+
+// ValuesPassphraseEnvVar is the environment variable with the passphrase of the secret values.
+const ValuesPassphraseEnvVar = "TALM_VALUES_PASSPHRASE"
+
+// ValuesIdentityEnvVar is the environment variable with the age identity file of the secret
+// values, or the AGE-SECRET-KEY-1 identity itself.
+const ValuesIdentityEnvVar = "TALM_AGE_IDENTITY"
+
+// valuesPassphraseCache keeps the passphrase for the whole command, it is read only once.
+var valuesPassphraseCache []byte
+
+// valuesIdentitiesCache keeps the identities for the whole command, an encrypted identity file
+// is decrypted only once.
+var valuesIdentitiesCache []age.Identity
+
+// valuesRecipientsCache are the recipients of the identities, or of the passphrase.
+var valuesRecipientsCache []age.Recipient
+
+// valuesIdentities returns the identities decrypting the !secret values: the age identities of
+// TALM_AGE_IDENTITY or of the templateOptions.secretValuesIdentityFile of Chart.yaml, which can
+// be encrypted with the passphrase, or the passphrase itself.
+func valuesIdentities() ([]age.Identity, error) {
+	if valuesIdentitiesCache != nil {
+		return valuesIdentitiesCache, nil
+	}
+
+	var (
+		identities []age.Identity
+		recipients []age.Recipient
+		err        error
+	)
+	switch env, file := os.Getenv(ValuesIdentityEnvVar), Config.TemplateOptions.SecretValuesIdentityFile; {
+	case strings.HasPrefix(strings.TrimSpace(env), "AGE-SECRET-KEY-"):
+		identities, err = age.ParseIdentities([]byte(env))
+	case env != "" || file != "":
+		if env != "" {
+			file = env
+		} else if !filepath.IsAbs(file) {
+			file = filepath.Join(Config.RootDir, file)
+		}
+		identities, err = readIdentityFile(file)
+	default:
+		passphrase, err := valuesPassphrase()
+		if err != nil {
+			return nil, err
+		}
+		identity, err := age.ScryptIdentity(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		recipient, err := age.ScryptRecipient(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		identities, recipients = []age.Identity{identity}, []age.Recipient{recipient}
+	}
+	if err == nil && recipients == nil {
+		recipients, err = age.IdentityRecipients(identities)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the age identities of the secret values: %w", err)
+	}
+
+	valuesIdentitiesCache, valuesRecipientsCache = identities, recipients
+	return identities, nil
+}
+
+// valuesRecipients returns the recipients of the identities of the secret values, or of their
+// passphrase.
+func valuesRecipients() ([]age.Recipient, error) {
+	if _, err := valuesIdentities(); err != nil {
+		return nil, err
+	}
+	return valuesRecipientsCache, nil
+}
+
+// readIdentityFile parses the identity file, an encrypted one is decrypted with the values passphrase.
+func readIdentityFile(file string) ([]age.Identity, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if age.IsEncrypted(data) {
+		passphrase, err := valuesPassphrase()
+		if err != nil {
+			return nil, err
+		}
+		if data, err = age.Decrypt(data, passphrase); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	identities, err := age.ParseIdentities(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return identities, nil
+}
+
+// valuesPassphrase reads the passphrase of the !secret values, or of their identity file, from the
+// environment, the templateOptions.secretValuesPassphraseFile of Chart.yaml or the terminal.
+func valuesPassphrase() ([]byte, error) {
+	if valuesPassphraseCache != nil {
+		return valuesPassphraseCache, nil
+	}
+
+	var (
+		passphrase []byte
+		err        error
+	)
+	switch file := Config.TemplateOptions.SecretValuesPassphraseFile; {
+	case os.Getenv(ValuesPassphraseEnvVar) != "":
+		passphrase = []byte(os.Getenv(ValuesPassphraseEnvVar))
+	case file != "":
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(Config.RootDir, file)
+		}
+		passphrase, err = os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the passphrase of the secret values: %w", err)
+		}
+		passphrase = bytes.TrimRight(passphrase, "\r\n")
+	case term.IsTerminal(int(os.Stdin.Fd())):
+		passphrase, err = promptPassphrase("Values passphrase: ", false)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("values contain %s values: please set %s or %s env variable, or templateOptions.secretValuesIdentityFile or secretValuesPassphraseFile in Chart.yaml",
+			engine.SecretTag, ValuesIdentityEnvVar, ValuesPassphraseEnvVar)
+	}
+
+	if len(passphrase) == 0 {
+		return nil, errors.New("values passphrase is empty")
+	}
+	valuesPassphraseCache = passphrase
+	return passphrase, nil
+}
+
+func init() {
+	engine.SecretValuesIdentities = valuesIdentities
+}
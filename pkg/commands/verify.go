@@ -0,0 +1,274 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"bytes"
+	stdx509 "crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/aenix-io/talm/pkg/engine"
+	"github.com/spf13/cobra"
+
+	"github.com/siderolabs/crypto/x509"
+	"github.com/siderolabs/talos/pkg/machinery/config"
+	"github.com/siderolabs/talos/pkg/machinery/config/generate/secrets"
+	"github.com/siderolabs/talos/pkg/machinery/config/machine"
+	"github.com/siderolabs/talos/pkg/machinery/constants"
+)
+
+var verifyCmdFlags struct {
+	configFiles       []string // -f/--files
+	talosVersion      string
+	withSecrets       string
+	kubernetesVersion string
+}
+
+// verifyResult is the result of a single check.
+type verifyResult struct {
+	source string
+	check  string
+	err    error
+}
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Verify that rendered configs and talosconfig match the secrets bundle",
+	Long: `Render the full config of every node file and verify that the certificates,
+keys and tokens in it are the ones from the secrets bundle, and that the client
+certificate of the current talosconfig context is signed by the cluster CA.
+
+This catches configs which mix secrets of different clusters, e.g. after copying
+node files or charts between projects.`,
+	Args: cobra.NoArgs,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if !cmd.Flags().Changed("talos-version") {
+			verifyCmdFlags.talosVersion = Config.TemplateOptions.TalosVersion
+		}
+		if !cmd.Flags().Changed("with-secrets") {
+			verifyCmdFlags.withSecrets = Config.TemplateOptions.WithSecrets
+		}
+		if !cmd.Flags().Changed("kubernetes-version") {
+			verifyCmdFlags.kubernetesVersion = Config.TemplateOptions.KubernetesVersion
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if verifyCmdFlags.withSecrets == "" {
+			return errors.New("secrets bundle is not set: please use `--with-secrets` flag")
+		}
+		secretsBundle, err := engine.LoadSecretsBundle(verifyCmdFlags.withSecrets)
+		if err != nil {
+			return fmt.Errorf("failed to load secrets bundle: %w", err)
+		}
+
+		files := verifyCmdFlags.configFiles
+		if len(files) == 0 {
+			files, err = defaultNodeFiles()
+			if err != nil {
+				return err
+			}
+		}
+
+		var results []verifyResult
+		for _, configFile := range files {
+			if err := checkWorkspaceFile(configFile); err != nil {
+				return err
+			}
+
+			opts := engine.Options{
+				TalosVersion:      verifyCmdFlags.talosVersion,
+				WithSecrets:       verifyCmdFlags.withSecrets,
+				KubernetesVersion: verifyCmdFlags.kubernetesVersion,
+			}
+
+			configBundle, err := engine.FullConfigProcess(cmd.Context(), opts, []string{"@" + configFile})
+			if err != nil {
+				return fmt.Errorf("full config processing error: %s", err)
+			}
+
+			cfg := configBundle.Worker()
+			if configBundle.ControlPlaneCfg.Machine().Type() != machine.TypeWorker {
+				cfg = configBundle.ControlPlane()
+			}
+
+			results = append(results, verifyConfig(configFile, cfg, secretsBundle)...)
+		}
+
+		if _, err := os.Stat(GlobalArgs.Talosconfig); err == nil {
+			results = append(results, verifyTalosconfig(GlobalArgs.Talosconfig, GlobalArgs.CmdContext, secretsBundle)...)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "SOURCE\tCHECK\tRESULT")
+		failed := 0
+		for _, result := range results {
+			status := "OK"
+			if result.err != nil {
+				status = "FAIL: " + result.err.Error()
+				failed++
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", result.source, result.check, status)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		if failed > 0 {
+			return fmt.Errorf("%d check(s) failed", failed)
+		}
+
+		return nil
+	},
+}
+
+// verifyConfig compares the secrets in the rendered config with the secrets bundle.
+func verifyConfig(source string, cfg config.Provider, bundle *secrets.Bundle) []verifyResult {
+	results := []verifyResult{}
+	add := func(check string, err error) {
+		results = append(results, verifyResult{source: source, check: check, err: err})
+	}
+
+	machineCfg := cfg.Machine()
+	clusterCfg := cfg.Cluster()
+
+	add("machine.ca", compareCertificateAndKey(machineCfg.Security().IssuingCA(), bundle.Certs.OS))
+	add("machine.token", compareString(machineCfg.Security().Token(), bundle.TrustdInfo.Token))
+	add("cluster.id", compareString(clusterCfg.ID(), bundle.Cluster.ID))
+	add("cluster.secret", compareString(clusterCfg.Secret(), bundle.Cluster.Secret))
+	add("cluster.token", compareString(clusterCfg.Token().ID()+"."+clusterCfg.Token().Secret(), bundle.Secrets.BootstrapToken))
+	add("cluster.ca", compareCertificateAndKey(clusterCfg.IssuingCA(), bundle.Certs.K8s))
+
+	if !machineCfg.Type().IsControlPlane() {
+		return results
+	}
+
+	add("cluster.aggregatorCA", compareCertificateAndKey(clusterCfg.AggregatorCA(), bundle.Certs.K8sAggregator))
+	add("cluster.serviceAccount", compareKey(clusterCfg.ServiceAccount(), bundle.Certs.K8sServiceAccount))
+	add("cluster.etcd.ca", compareCertificateAndKey(clusterCfg.Etcd().CA(), bundle.Certs.Etcd))
+	add("cluster.secretboxEncryptionSecret", compareString(clusterCfg.SecretboxEncryptionSecret(), bundle.Secrets.SecretboxEncryptionSecret))
+	if bundle.Secrets.AESCBCEncryptionSecret != "" || clusterCfg.AESCBCEncryptionSecret() != "" {
+		add("cluster.aescbcEncryptionSecret", compareString(clusterCfg.AESCBCEncryptionSecret(), bundle.Secrets.AESCBCEncryptionSecret))
+	}
+
+	return results
+}
+
+// verifyTalosconfig checks that the talosconfig context trusts the cluster CA
+// and its client certificate is signed by it.
+func verifyTalosconfig(path, contextName string, bundle *secrets.Bundle) []verifyResult {
+	source := filepath.Base(path)
+	fail := func(err error) []verifyResult {
+		return []verifyResult{{source: source, check: "context", err: err}}
+	}
+
+	cfg, err := openTalosconfig(path)
+	if err != nil {
+		return fail(err)
+	}
+	if contextName == "" {
+		contextName = cfg.Context
+	}
+	context, ok := cfg.Contexts[contextName]
+	if !ok {
+		return fail(fmt.Errorf("context %q is not defined", contextName))
+	}
+
+	source = fmt.Sprintf("%s (%s)", source, contextName)
+	results := []verifyResult{}
+
+	ca, err := base64.StdEncoding.DecodeString(context.CA)
+	if err == nil {
+		err = compareBytes(ca, bundle.Certs.OS.Crt)
+	}
+	results = append(results, verifyResult{source: source, check: "ca", err: err})
+
+	crt, err := base64.StdEncoding.DecodeString(context.Crt)
+	if err == nil {
+		err = verifySignedBy(crt, bundle.Certs.OS.Crt)
+	}
+	results = append(results, verifyResult{source: source, check: "crt", err: err})
+
+	return results
+}
+
+func verifySignedBy(crt, ca []byte) error {
+	cert, err := parseCertificate(crt)
+	if err != nil {
+		return err
+	}
+	caCert, err := parseCertificate(ca)
+	if err != nil {
+		return fmt.Errorf("invalid CA: %w", err)
+	}
+
+	roots := stdx509.NewCertPool()
+	roots.AddCert(caCert)
+	if _, err := cert.Verify(stdx509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []stdx509.ExtKeyUsage{stdx509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		return fmt.Errorf("not signed by the cluster CA: %w", err)
+	}
+
+	return nil
+}
+
+func parseCertificate(data []byte) (*stdx509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("failed to decode PEM certificate")
+	}
+	return stdx509.ParseCertificate(block.Bytes)
+}
+
+func compareCertificateAndKey(got, want *x509.PEMEncodedCertificateAndKey) error {
+	if got == nil {
+		return errors.New("missing")
+	}
+	if err := compareBytes(got.Crt, want.Crt); err != nil {
+		return fmt.Errorf("certificate %w", err)
+	}
+	// Keys of the CAs are present on control plane nodes only
+	if len(got.Key) > 0 {
+		if err := compareBytes(got.Key, want.Key); err != nil {
+			return fmt.Errorf("key %w", err)
+		}
+	}
+	return nil
+}
+
+func compareKey(got, want *x509.PEMEncodedKey) error {
+	if got == nil {
+		return errors.New("missing")
+	}
+	return compareBytes(got.Key, want.Key)
+}
+
+func compareBytes(got, want []byte) error {
+	if !bytes.Equal(bytes.TrimSpace(got), bytes.TrimSpace(want)) {
+		return errors.New("does not match secrets bundle")
+	}
+	return nil
+}
+
+func compareString(got, want string) error {
+	return compareBytes([]byte(got), []byte(want))
+}
+
+func init() {
+	verifyCmd.Flags().StringSliceVarP(&verifyCmdFlags.configFiles, "file", "f", nil, "specify node files to verify (defaults to nodes/*.yaml)")
+	verifyCmd.Flags().StringVar(&verifyCmdFlags.talosVersion, "talos-version", "", "the desired Talos version to generate config for (backwards compatibility, e.g. v0.8)")
+	verifyCmd.Flags().StringVar(&verifyCmdFlags.withSecrets, "with-secrets", "", "use a secrets file generated using 'gen secrets', - reads it from stdin")
+	verifyCmd.Flags().StringVar(&verifyCmdFlags.kubernetesVersion, "kubernetes-version", constants.DefaultKubernetesVersion, "desired kubernetes version to run")
+
+	addCommand(verifyCmd)
+}
@@ -0,0 +1,214 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/aenix-io/talm/pkg/generated"
+	"github.com/aenix-io/talm/pkg/versionrange"
+	"github.com/blang/semver/v4"
+	"github.com/spf13/cobra"
+
+	"github.com/siderolabs/talos/pkg/machinery/config"
+	"github.com/siderolabs/talos/pkg/machinery/version"
+)
+
+const releasesURL = "https://api.github.com/repos/aenix-io/talm/releases"
+
+// IgnoreVersionCheck turns the versions outside the ranges required by Chart.yaml into warnings.
+var IgnoreVersionCheck bool
+
+var versionCheckFlags struct {
+	check  bool
+	output string
+}
+
+// buildInfo describes the talm binary, it is printed by `talm version -o json`.
+type buildInfo struct {
+	Version   string            `json:"version"`
+	GoVersion string            `json:"goVersion"`
+	Platform  string            `json:"platform"`
+	Commit    string            `json:"commit,omitempty"`
+	BuildTime string            `json:"buildTime,omitempty"`
+	Talos     talosVersionRange `json:"talos"`
+	Charts    map[string]string `json:"charts"`
+}
+
+type talosVersionRange struct {
+	Machinery string `json:"machinery"`
+	Min       string `json:"min"`
+	Max       string `json:"max"`
+}
+
+// githubRelease is the subset of GitHub release API fields used by talm.
+type githubRelease struct {
+	TagName string `json:"tag_name"`
+	HTMLURL string `json:"html_url"`
+	Assets  []struct {
+		Name               string `json:"name"`
+		BrowserDownloadURL string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+func getBuildInfo() buildInfo {
+	info := buildInfo{
+		Version:   Version,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+		Talos: talosVersionRange{
+			Machinery: version.Tag,
+			Min:       config.TalosVersion1_0.String(),
+			Max:       version.Tag,
+		},
+		Charts: map[string]string{},
+	}
+
+	if contract, err := config.ParseContractFromVersion(version.Tag); err == nil {
+		info.Talos.Max = contract.String()
+	}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				info.Commit = s.Value
+			case "vcs.time":
+				info.BuildTime = s.Value
+			}
+		}
+	}
+
+	// Embedded charts are always versioned together with talm itself
+	for _, preset := range append([]string{"talm"}, generated.AvailablePresets...) {
+		info.Charts[preset] = strings.TrimPrefix(Version, "v")
+	}
+
+	return info
+}
+
+// fetchRelease requests the release information from GitHub, empty tag means the latest release.
+func fetchRelease(ctx context.Context, tag string) (*githubRelease, error) {
+	url := releasesURL + "/latest"
+	if tag != "" {
+		url = releasesURL + "/tags/" + tag
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching release information: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching release information: %s", resp.Status)
+	}
+
+	var release githubRelease
+	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
+		return nil, fmt.Errorf("error decoding release information: %w", err)
+	}
+
+	return &release, nil
+}
+
+// isNewerVersion returns true if latest is a newer semantic version than current.
+// Development builds are always considered outdated.
+func isNewerVersion(current, latest string) bool {
+	latestVersion, err := semver.ParseTolerant(latest)
+	if err != nil {
+		return false
+	}
+	currentVersion, err := semver.ParseTolerant(current)
+	if err != nil {
+		return true
+	}
+	return latestVersion.GT(currentVersion)
+}
+
+func checkVersion(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	release, err := fetchRelease(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	if isNewerVersion(Version, release.TagName) {
+		fmt.Printf("A new talm version is available: %s (current: %s)\n", release.TagName, Version)
+		fmt.Printf("Release notes: %s\n", release.HTMLURL)
+		fmt.Println("Run `talm self-update` to upgrade.")
+		return nil
+	}
+
+	fmt.Printf("talm %s is up to date\n", Version)
+	return nil
+}
+
+// CheckRequiredVersions ensures talm and its Talos client are in the ranges of talmVersion and
+// talosClientVersion of Chart.yaml, so the members of a team don't render the configs differently.
+// Development builds are not checked.
+func CheckRequiredVersions() error {
+	var errs []error
+	if Config.TalmVersion != "" {
+		if err := versionrange.Check("talm", Config.TalmVersion, Version); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if Config.TalosClientVersion != "" {
+		if err := versionrange.Check("Talos client", Config.TalosClientVersion, version.Tag); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+
+	err := errors.Join(errs...)
+	if IgnoreVersionCheck {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", err)
+		return nil
+	}
+	return fmt.Errorf("%w: install a talm release in the range with `talm self-update --version`, or use `--ignore-version-check`", err)
+}
+
+func init() {
+	versionCmd.Flags().BoolVar(&versionCheckFlags.check, "check", false, "check if a newer talm release is available")
+	versionCmd.Flags().StringVarP(&versionCheckFlags.output, "output", "o", "", "output format for the client version (json)")
+
+	runE := versionCmd.RunE
+	versionCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		if versionCheckFlags.check {
+			return checkVersion(cmd.Context())
+		}
+
+		switch versionCheckFlags.output {
+		case "":
+			return runE(cmd, args)
+		case "json":
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(getBuildInfo())
+		default:
+			return fmt.Errorf("unsupported output format: %s", versionCheckFlags.output)
+		}
+	}
+}
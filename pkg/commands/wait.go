@@ -0,0 +1,275 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cosi-project/runtime/pkg/resource"
+	"github.com/cosi-project/runtime/pkg/safe"
+	"github.com/cosi-project/runtime/pkg/state"
+	"github.com/spf13/cobra"
+
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	"github.com/siderolabs/talos/pkg/machinery/config/encoder"
+	configres "github.com/siderolabs/talos/pkg/machinery/resources/config"
+	"github.com/siderolabs/talos/pkg/machinery/resources/runtime"
+	"github.com/siderolabs/talos/pkg/machinery/resources/v1alpha1"
+)
+
+// waitTimeoutExitCode is the exit code of `talm wait` when a condition is not met in time, like timeout(1).
+const waitTimeoutExitCode = 124
+
+// waitRetryInterval is the delay before watching again when the watch fails, e.g. while the node reboots.
+const waitRetryInterval = 2 * time.Second
+
+var waitCmdFlags struct {
+	configFiles []string
+	conditions  []string
+	timeout     time.Duration
+	output      string
+}
+
+// waitConditions are the conditions `talm wait` can wait for. The rendered config of the node
+// file is set for the conditions requiring it.
+var waitConditions = map[string]func(ctx context.Context, c *client.Client, node string, rendered []byte) error{
+	"node-ready":     waitNodeReady,
+	"etcd-healthy":   waitEtcdHealthy,
+	"config-applied": waitConfigApplied,
+}
+
+// waitResult is the outcome of waiting for a condition on a node, printed by `talm wait -o json`.
+type waitResult struct {
+	Node      string `json:"node"`
+	File      string `json:"file,omitempty"`
+	Condition string `json:"condition"`
+	Met       bool   `json:"met"`
+	Elapsed   string `json:"elapsed"`
+	Error     string `json:"error,omitempty"`
+}
+
+var waitCmd = &cobra.Command{
+	Use:   "wait",
+	Short: "Wait for conditions on the nodes",
+	Long: `Wait until the conditions are met on all nodes, to chain apply, bootstrap and upgrade in scripts:
+
+  node-ready      the machine is running and all its services are ready
+  etcd-healthy    the etcd service of a control plane node is healthy
+  config-applied  the node runs the config rendered from its node file (requires --file)
+
+The conditions are watched through the Talos API, watches interrupted by a reboot of the node
+are restarted. The command exits with code 124 if a condition is not met before the timeout.`,
+	Args: cobra.NoArgs,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(waitCmdFlags.conditions) == 0 {
+			return fmt.Errorf("no conditions set, use --for with %s", strings.Join(waitConditionNames(), ", "))
+		}
+		for _, condition := range waitCmdFlags.conditions {
+			if _, ok := waitConditions[condition]; !ok {
+				return fmt.Errorf("unknown condition %q, use %s", condition, strings.Join(waitConditionNames(), ", "))
+			}
+			if condition == "config-applied" && len(waitCmdFlags.configFiles) == 0 {
+				return fmt.Errorf("condition config-applied requires --file")
+			}
+		}
+		if waitCmdFlags.output != "text" && waitCmdFlags.output != "json" {
+			return fmt.Errorf("unknown output format %q, use text or json", waitCmdFlags.output)
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return WithClientNoNodes(func(ctx context.Context, c *client.Client) error {
+			if waitCmdFlags.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, waitCmdFlags.timeout)
+				defer cancel()
+			}
+
+			var results []waitResult
+			if len(waitCmdFlags.configFiles) == 0 {
+				if len(GlobalArgs.Nodes) < 1 {
+					configContext := c.GetConfigContext()
+					if configContext == nil {
+						return errors.New("failed to resolve config context")
+					}
+					GlobalArgs.Nodes = configContext.Nodes
+				}
+				results = waitNodes(ctx, c, "", nil)
+			}
+
+			nodesFromArgs := len(GlobalArgs.Nodes) > 0
+			endpointsFromArgs := len(GlobalArgs.Endpoints) > 0
+			for _, configFile := range waitCmdFlags.configFiles {
+				if err := processModelineAndUpdateGlobals(configFile, nodesFromArgs, endpointsFromArgs, false); err != nil {
+					return err
+				}
+
+				rendered, err := waitRenderedConfig(ctx, c, configFile)
+				if err != nil {
+					return err
+				}
+				results = append(results, waitNodes(ctx, c, configFile, rendered)...)
+
+				if !nodesFromArgs {
+					GlobalArgs.Nodes = []string{}
+				}
+				if !endpointsFromArgs {
+					GlobalArgs.Endpoints = []string{}
+				}
+			}
+
+			return printWaitResults(results)
+		})
+	},
+}
+
+func waitConditionNames() []string {
+	names := make([]string, 0, len(waitConditions))
+	for name := range waitConditions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// waitRenderedConfig renders the node file as apply does, if a condition compares the live config with it.
+func waitRenderedConfig(ctx context.Context, c *client.Client, configFile string) ([]byte, error) {
+	needed := false
+	for _, condition := range waitCmdFlags.conditions {
+		needed = needed || condition == "config-applied"
+	}
+	if !needed {
+		return nil, nil
+	}
+
+	return renderComparableConfig(ctx, c, configFile)
+}
+
+// waitNodes waits for every condition on every node in turn, the timeout is shared by all of them.
+func waitNodes(ctx context.Context, c *client.Client, configFile string, rendered []byte) []waitResult {
+	var results []waitResult
+	for _, node := range GlobalArgs.Nodes {
+		for _, condition := range waitCmdFlags.conditions {
+			start := time.Now()
+			err := waitConditions[condition](ctx, c, node, rendered)
+
+			result := waitResult{
+				Node:      node,
+				File:      configFile,
+				Condition: condition,
+				Met:       err == nil,
+				Elapsed:   time.Since(start).Round(time.Second).String(),
+			}
+			if err != nil {
+				result.Error = err.Error()
+			}
+			if waitCmdFlags.output == "text" {
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "- talm: node=%s, %s not met after %s: %s\n", node, condition, result.Elapsed, err)
+				} else {
+					fmt.Fprintf(os.Stderr, "- talm: node=%s, %s after %s\n", node, condition, result.Elapsed)
+				}
+			}
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+func printWaitResults(results []waitResult) error {
+	if waitCmdFlags.output == "json" {
+		data, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	}
+
+	unmet := 0
+	for _, result := range results {
+		if !result.Met {
+			unmet++
+		}
+	}
+	if unmet > 0 {
+		return &ExitError{Code: waitTimeoutExitCode, Err: fmt.Errorf("%d of %d condition(s) not met", unmet, len(results))}
+	}
+	return nil
+}
+
+// watchFor watches the resource on the node until the condition is met. The watch is restarted
+// when it fails, as the connection is lost while the node reboots, until the context is done.
+func watchFor[T resource.Resource](ctx context.Context, c *client.Client, node string, ptr resource.Pointer, condition func(T) (bool, error)) error {
+	nodeCtx := client.WithNode(ctx, node)
+	for {
+		_, err := safe.StateWatchFor[T](nodeCtx, c.COSI, ptr,
+			state.WithEventTypes(state.Created, state.Updated),
+			state.WithCondition(func(r resource.Resource) (bool, error) {
+				typed, ok := r.(T)
+				if !ok {
+					return false, nil
+				}
+				return condition(typed)
+			}),
+		)
+		if err == nil {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("timed out, last error: %w", err)
+			}
+			return ctx.Err()
+		case <-time.After(waitRetryInterval):
+		}
+	}
+}
+
+func waitNodeReady(ctx context.Context, c *client.Client, node string, _ []byte) error {
+	return watchFor(ctx, c, node, runtime.NewMachineStatus().Metadata(), func(status *runtime.MachineStatus) (bool, error) {
+		spec := status.TypedSpec()
+		return spec.Stage == runtime.MachineStageRunning && spec.Status.Ready, nil
+	})
+}
+
+func waitEtcdHealthy(ctx context.Context, c *client.Client, node string, _ []byte) error {
+	return watchFor(ctx, c, node, v1alpha1.NewService("etcd").Metadata(), func(service *v1alpha1.Service) (bool, error) {
+		spec := service.TypedSpec()
+		return spec.Running && spec.Healthy, nil
+	})
+}
+
+func waitConfigApplied(ctx context.Context, c *client.Client, node string, rendered []byte) error {
+	hash := configHash(rendered)
+	return watchFor(ctx, c, node, configres.NewMachineConfig(nil).Metadata(), func(machineConfig *configres.MachineConfig) (bool, error) {
+		current, err := machineConfig.Provider().EncodeBytes(encoder.WithComments(encoder.CommentsDisabled))
+		if err != nil {
+			return false, err
+		}
+		current, err = withoutIgnoredPaths(current)
+		if err != nil {
+			return false, err
+		}
+		return configHash(current) == hash, nil
+	})
+}
+
+func init() {
+	waitCmd.Flags().StringSliceVarP(&waitCmdFlags.configFiles, "file", "f", nil, "specify node files to take the nodes from (can specify multiple)")
+	waitCmd.Flags().StringSliceVar(&waitCmdFlags.conditions, "for", nil, "conditions to wait for: node-ready, etcd-healthy, config-applied (can specify multiple)")
+	waitCmd.Flags().DurationVar(&waitCmdFlags.timeout, "timeout", 10*time.Minute, "time to wait for all conditions, zero waits forever")
+	waitCmd.Flags().StringVarP(&waitCmdFlags.output, "output", "o", "text", "output format of the results: text or json")
+
+	addCommand(waitCmd)
+}
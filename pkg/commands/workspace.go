@@ -0,0 +1,281 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/siderolabs/talos/cmd/talosctl/cmd/mgmt/gen"
+	"github.com/siderolabs/talos/pkg/machinery/config"
+	"github.com/siderolabs/talos/pkg/machinery/config/generate"
+	"github.com/siderolabs/talos/pkg/machinery/config/generate/secrets"
+)
+
+// workspacesDir is the directory relative to the project root holding one subdirectory per cluster.
+//
+// Charts, templates and values.yaml are shared by all workspaces, while talosconfig, secrets.yaml,
+// values.yaml overrides and node files are kept in the workspace directory.
+const workspacesDir = "clusters"
+
+// WorkspaceEnvVar is the environment variable used as a default for the --workspace flag.
+const WorkspaceEnvVar = "TALM_WORKSPACE"
+
+// workspaceDir returns the directory of the selected workspace, or empty string if none is selected.
+func workspaceDir() string {
+	if Config.Workspace == "" {
+		return ""
+	}
+
+	return filepath.Join(Config.RootDir, workspacesDir, Config.Workspace)
+}
+
+// stateDir returns the directory for the files written by talm, scoped to the selected workspace.
+func stateDir() string {
+	if dir := workspaceDir(); dir != "" {
+		return dir
+	}
+
+	return Config.RootDir
+}
+
+// nodeFiles returns the node files of the project or workspace directory.
+func nodeFiles(dir string) ([]string, error) {
+	return filepath.Glob(filepath.Join(dir, "nodes", "*.yaml"))
+}
+
+// defaultNodeFiles returns the node files of the selected workspace, which the commands use
+// when no node file is given.
+func defaultNodeFiles() ([]string, error) {
+	return nodeFiles(stateDir())
+}
+
+// ApplyWorkspace resolves talosconfig, secrets and values of the loaded config inside the selected workspace.
+// The secrets keyed by environment are selected by --environment or the workspace name.
+//
+// Relative paths are never resolved against the project root, so one workspace can't pick up the credentials of another.
+func ApplyWorkspace() error {
+	environment := Environment
+	if environment == "" {
+		environment = Config.Workspace
+	}
+
+	var err error
+	Config.TemplateOptions.WithSecrets, err = Config.TemplateOptions.SecretsPaths.Resolve(environment)
+	if err != nil {
+		return err
+	}
+
+	dir := workspaceDir()
+	if dir == "" {
+		return nil
+	}
+
+	if !validWorkspaceName(Config.Workspace) {
+		return fmt.Errorf("invalid workspace name %q", Config.Workspace)
+	}
+
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return fmt.Errorf("workspace %q not found in %s, use `talm workspace create %s` to create it", Config.Workspace, filepath.Join(Config.RootDir, workspacesDir), Config.Workspace)
+	}
+
+	if Config.GlobalOptions.Talosconfig == "" {
+		Config.GlobalOptions.Talosconfig = "talosconfig"
+	}
+	Config.GlobalOptions.Talosconfig = workspacePath(dir, Config.GlobalOptions.Talosconfig)
+
+	if Config.TemplateOptions.WithSecrets != "" {
+		Config.TemplateOptions.WithSecrets = workspacePath(dir, Config.TemplateOptions.WithSecrets)
+	}
+
+	// Workspace values are layered on top of the shared ones
+	values := filepath.Join(dir, "values.yaml")
+	if _, err := os.Stat(values); err == nil {
+		Config.TemplateOptions.ValueFiles = append(Config.TemplateOptions.ValueFiles, values)
+	}
+
+	return nil
+}
+
+func validWorkspaceName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
+
+func workspacePath(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+
+	return filepath.Join(dir, path)
+}
+
+// checkWorkspaceFile ensures the node file belongs to the selected workspace.
+func checkWorkspaceFile(file string) error {
+	dir := workspaceDir()
+	if dir == "" {
+		return nil
+	}
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	absFile, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+
+	rel, err := filepath.Rel(absDir, absFile)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("file %q is outside of workspace %q (%s)", file, Config.Workspace, dir)
+	}
+
+	return nil
+}
+
+var workspaceCmdFlags struct {
+	talosVersion string
+	force        bool
+}
+
+// workspaceCmd represents the `workspace` command.
+var workspaceCmd = &cobra.Command{
+	Use:   "workspace",
+	Short: "Manage multiple clusters sharing the same charts",
+	Long:  ``,
+}
+
+var workspaceListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List workspaces of the project",
+	Long:  ``,
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		entries, err := os.ReadDir(filepath.Join(Config.RootDir, workspacesDir))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "CURRENT\tNAME\tTALOSCONFIG\tSECRETS")
+
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
+			}
+
+			dir := filepath.Join(Config.RootDir, workspacesDir, entry.Name())
+			current := ""
+			if entry.Name() == Config.Workspace {
+				current = "*"
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%t\t%t\n", current, entry.Name(),
+				fileExists(filepath.Join(dir, "talosconfig")),
+				fileExists(filepath.Join(dir, "secrets.yaml")),
+			)
+		}
+
+		return w.Flush()
+	},
+}
+
+var workspaceCreateCmd = &cobra.Command{
+	Use:   "create <name>",
+	Short: "Create a workspace with its own secrets and talosconfig",
+	Long:  ``,
+	Args:  cobra.ExactArgs(1),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if !cmd.Flags().Changed("talos-version") {
+			workspaceCmdFlags.talosVersion = Config.TemplateOptions.TalosVersion
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := args[0]
+		if !validWorkspaceName(name) {
+			return fmt.Errorf("invalid workspace name %q", name)
+		}
+
+		var (
+			versionContract *config.VersionContract
+			err             error
+		)
+
+		if workspaceCmdFlags.talosVersion != "" {
+			versionContract, err = config.ParseContractFromVersion(workspaceCmdFlags.talosVersion)
+			if err != nil {
+				return fmt.Errorf("invalid talos-version: %w", err)
+			}
+		}
+
+		secretsBundle, err := secrets.NewBundle(secrets.NewFixedClock(time.Now()), versionContract)
+		if err != nil {
+			return fmt.Errorf("failed to create secrets bundle: %w", err)
+		}
+
+		genOptions := []generate.Option{generate.WithSecretsBundle(secretsBundle)}
+		if versionContract != nil {
+			genOptions = append(genOptions, generate.WithVersionContract(versionContract))
+		}
+
+		dir := filepath.Join(Config.RootDir, workspacesDir, name)
+		// Nothing is written if a file exists, so the secrets of a workspace are not replaced alone
+		if !workspaceCmdFlags.force {
+			for _, file := range []string{"secrets.yaml", "talosconfig", "values.yaml"} {
+				if path := filepath.Join(dir, file); fileExists(path) {
+					return fmt.Errorf("file %q already exists, use --force to overwrite", path)
+				}
+			}
+		}
+
+		bundleBytes, err := yaml.Marshal(secretsBundle)
+		if err != nil {
+			return err
+		}
+		if err = createFile(bundleBytes, filepath.Join(dir, "secrets.yaml"), 0o600); err != nil {
+			return err
+		}
+
+		configBundle, err := gen.GenerateConfigBundle(genOptions, name, "https://192.168.0.1:6443", "", []string{}, []string{}, []string{})
+		if err != nil {
+			return err
+		}
+		configBundle.TalosConfig().Contexts[name].Endpoints = nil
+
+		data, err := yaml.Marshal(configBundle.TalosConfig())
+		if err != nil {
+			return fmt.Errorf("failed to marshal config: %+v", err)
+		}
+		if err = createFile(data, filepath.Join(dir, "talosconfig"), 0o600); err != nil {
+			return err
+		}
+
+		return createFile([]byte("# Values overriding the project values.yaml for this cluster\n"), filepath.Join(dir, "values.yaml"), 0o644)
+	},
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func init() {
+	workspaceCreateCmd.Flags().StringVar(&workspaceCmdFlags.talosVersion, "talos-version", "", "the desired Talos version to generate config for (backwards compatibility, e.g. v0.8)")
+	workspaceCreateCmd.Flags().BoolVar(&workspaceCmdFlags.force, "force", false, "will overwrite existing files")
+
+	workspaceCmd.AddCommand(workspaceListCmd, workspaceCreateCmd)
+	addCommand(workspaceCmd)
+}
@@ -0,0 +1,75 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckWorkspaceFile(t *testing.T) {
+	config := Config
+	defer func() { Config = config }()
+	Config.RootDir = t.TempDir()
+
+	Config.Workspace = ""
+	if err := checkWorkspaceFile("../nodes/node1.yaml"); err != nil {
+		t.Errorf("expected any file to be allowed without workspace, got %v", err)
+	}
+
+	Config.Workspace = "prod-a"
+	for _, tt := range []struct {
+		file string
+		ok   bool
+	}{
+		{filepath.Join(Config.RootDir, "clusters", "prod-a", "nodes", "node1.yaml"), true},
+		{filepath.Join(Config.RootDir, "clusters", "prod-a", "nodes", "..", "values.yaml"), true},
+		{filepath.Join(Config.RootDir, "nodes", "node1.yaml"), false},
+		{filepath.Join(Config.RootDir, "clusters", "prod-b", "nodes", "node1.yaml"), false},
+		// A workspace named with the prefix of the selected one
+		{filepath.Join(Config.RootDir, "clusters", "prod-a-old", "nodes", "node1.yaml"), false},
+		{filepath.Join(Config.RootDir, "clusters", "prod-a", "nodes", "..", "..", "prod-b", "nodes", "node1.yaml"), false},
+		{filepath.Join(Config.RootDir, "clusters", "prod-a", "..node1.yaml"), true},
+	} {
+		err := checkWorkspaceFile(tt.file)
+		if tt.ok && err != nil {
+			t.Errorf("%s: %v", tt.file, err)
+		}
+		if !tt.ok && (err == nil || !strings.Contains(err.Error(), `is outside of workspace "prod-a"`)) {
+			t.Errorf("%s: expected the file to be outside of the workspace, got %v", tt.file, err)
+		}
+	}
+}
+
+func TestApplyWorkspace(t *testing.T) {
+	config := Config
+	defer func() { Config = config }()
+	Config.RootDir = t.TempDir()
+	dir := filepath.Join(Config.RootDir, "clusters", "prod-a")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "values.yaml"), []byte("endpoint: https://10.0.0.1:6443\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	Config.Workspace = "prod-a"
+	Config.GlobalOptions.Talosconfig = ""
+	Config.TemplateOptions.ValueFiles = []string{"values.yaml"}
+	if err := ApplyWorkspace(); err != nil {
+		t.Fatal(err)
+	}
+	if expected := filepath.Join(dir, "talosconfig"); Config.GlobalOptions.Talosconfig != expected {
+		t.Errorf("expected talosconfig %s, got %s", expected, Config.GlobalOptions.Talosconfig)
+	}
+	if expected := []string{"values.yaml", filepath.Join(dir, "values.yaml")}; strings.Join(Config.TemplateOptions.ValueFiles, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected values files %v, got %v", expected, Config.TemplateOptions.ValueFiles)
+	}
+
+	for _, workspace := range []string{"prod-b", "..", "prod-a/../prod-b"} {
+		Config.Workspace = workspace
+		if err := ApplyWorkspace(); err == nil {
+			t.Errorf("%s: expected an error", workspace)
+		}
+	}
+}
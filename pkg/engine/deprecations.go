@@ -0,0 +1,272 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"helm.sh/helm/v3/pkg/chart"
+)
+
+// ValueDeprecation declares a renamed or removed value in the deprecatedValues section of Chart.yaml:
+//
+//	deprecatedValues:
+//	- name: floatingIP
+//	  replacement: vip.address
+//	- name: legacyOption
+//	  message: it has no effect since Talos v1.6
+//
+// Names are dot separated paths of the values. Values without a replacement are removed.
+type ValueDeprecation struct {
+	Name        string `yaml:"name"`
+	Replacement string `yaml:"replacement,omitempty"`
+	Message     string `yaml:"message,omitempty"`
+}
+
+func (d ValueDeprecation) warning() string {
+	var warning string
+	if d.Replacement != "" {
+		warning = fmt.Sprintf("value %q is deprecated, use %q instead", d.Name, d.Replacement)
+	} else {
+		warning = fmt.Sprintf("value %q is removed", d.Name)
+	}
+	if d.Message != "" {
+		warning += ": " + d.Message
+	}
+	return warning
+}
+
+func parseDeprecations(data []byte) ([]ValueDeprecation, error) {
+	var metadata struct {
+		DeprecatedValues []ValueDeprecation `yaml:"deprecatedValues"`
+	}
+	if err := yaml.Unmarshal(data, &metadata); err != nil {
+		return nil, fmt.Errorf("failed to parse deprecatedValues of Chart.yaml: %w", err)
+	}
+
+	for _, d := range metadata.DeprecatedValues {
+		if d.Name == "" {
+			return nil, fmt.Errorf("deprecatedValues entry of Chart.yaml has no name")
+		}
+	}
+
+	return metadata.DeprecatedValues, nil
+}
+
+// ValueDeprecations returns the deprecations declared in Chart.yaml of the chart and of the preset it extends.
+// The chart declarations take precedence over the preset ones for the same value.
+func ValueDeprecations(chrt *chart.Chart, extends string) ([]ValueDeprecation, error) {
+	var deprecations []ValueDeprecation
+	for _, f := range chrt.Raw {
+		if f.Name == "Chart.yaml" {
+			parsed, err := parseDeprecations(f.Data)
+			if err != nil {
+				return nil, err
+			}
+			deprecations = append(deprecations, parsed...)
+		}
+	}
+
+	if extends == "" {
+		return deprecations, nil
+	}
+
+	files, err := presetFiles(extends)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := parseDeprecations(files["Chart.yaml"])
+	if err != nil {
+		return nil, err
+	}
+
+	declared := map[string]bool{}
+	for _, d := range deprecations {
+		declared[d.Name] = true
+	}
+	for _, d := range parsed {
+		if !declared[d.Name] {
+			deprecations = append(deprecations, d)
+		}
+	}
+
+	return deprecations, nil
+}
+
+// MigrateValues moves renamed values to their replacements and deletes removed values,
+// returning a warning for every deprecated value found. A replacement which is already
+// set is kept as is.
+func MigrateValues(values map[string]interface{}, deprecations []ValueDeprecation) []string {
+	var warnings []string
+	for _, d := range deprecations {
+		path := strings.Split(d.Name, ".")
+		value, ok := lookupValue(values, path)
+		if !ok {
+			continue
+		}
+
+		warnings = append(warnings, d.warning())
+		deleteValue(values, path)
+
+		if d.Replacement == "" {
+			continue
+		}
+		replacement := strings.Split(d.Replacement, ".")
+		if _, ok := lookupValue(values, replacement); ok {
+			warnings = append(warnings, fmt.Sprintf("value %q is ignored, %q is already set", d.Name, d.Replacement))
+			continue
+		}
+		setValue(values, replacement, value)
+	}
+
+	return warnings
+}
+
+func lookupValue(values map[string]interface{}, path []string) (interface{}, bool) {
+	for _, key := range path[:len(path)-1] {
+		nested, ok := values[key].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		values = nested
+	}
+
+	value, ok := values[path[len(path)-1]]
+	return value, ok
+}
+
+// deleteValue deletes the value and the maps left empty by the deletion.
+func deleteValue(values map[string]interface{}, path []string) {
+	if len(path) == 1 {
+		delete(values, path[0])
+		return
+	}
+
+	nested, ok := values[path[0]].(map[string]interface{})
+	if !ok {
+		return
+	}
+	deleteValue(nested, path[1:])
+	if len(nested) == 0 {
+		delete(values, path[0])
+	}
+}
+
+func setValue(values map[string]interface{}, path []string, value interface{}) {
+	for _, key := range path[:len(path)-1] {
+		nested, ok := values[key].(map[string]interface{})
+		if !ok {
+			nested = map[string]interface{}{}
+			values[key] = nested
+		}
+		values = nested
+	}
+
+	values[path[len(path)-1]] = value
+}
+
+// MigrateValuesFile applies the deprecations to the YAML values file keeping the comments,
+// the moved values keep their comments too. The data is returned unchanged if no deprecated
+// value is found.
+func MigrateValuesFile(data []byte, deprecations []ValueDeprecation) ([]byte, []string, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, err
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return data, nil, nil
+	}
+	root := doc.Content[0]
+
+	var warnings []string
+	for _, d := range deprecations {
+		key, value := removeNode(root, strings.Split(d.Name, "."))
+		if key == nil {
+			continue
+		}
+
+		warnings = append(warnings, d.warning())
+		if d.Replacement == "" {
+			continue
+		}
+
+		replacement := strings.Split(d.Replacement, ".")
+		parent := root
+		for _, name := range replacement[:len(replacement)-1] {
+			parent = mappingChild(parent, name)
+			if parent == nil {
+				break
+			}
+		}
+		if parent == nil || findKey(parent, replacement[len(replacement)-1]) >= 0 {
+			warnings = append(warnings, fmt.Sprintf("value %q is ignored, %q is already set", d.Name, d.Replacement))
+			continue
+		}
+
+		key.Value = replacement[len(replacement)-1]
+		parent.Content = append(parent.Content, key, value)
+	}
+
+	if len(warnings) == 0 {
+		return data, nil, nil
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return nil, nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	return buf.Bytes(), warnings, nil
+}
+
+func findKey(mapping *yaml.Node, name string) int {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// mappingChild returns the mapping under the key, creating it if the key is missing.
+// It returns nil if the key holds a value of another kind.
+func mappingChild(mapping *yaml.Node, name string) *yaml.Node {
+	if i := findKey(mapping, name); i >= 0 {
+		if child := mapping.Content[i+1]; child.Kind == yaml.MappingNode {
+			return child
+		}
+		return nil
+	}
+
+	child := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: name}, child)
+	return child
+}
+
+// removeNode removes the key with its value from the mapping and the mappings left empty by the removal.
+func removeNode(mapping *yaml.Node, path []string) (*yaml.Node, *yaml.Node) {
+	i := findKey(mapping, path[0])
+	if i < 0 {
+		return nil, nil
+	}
+
+	key, value := mapping.Content[i], mapping.Content[i+1]
+	if len(path) > 1 {
+		if value.Kind != yaml.MappingNode {
+			return nil, nil
+		}
+		key, value = removeNode(value, path[1:])
+		if key == nil || len(mapping.Content[i+1].Content) > 0 {
+			return key, value
+		}
+	}
+
+	mapping.Content = append(mapping.Content[:i], mapping.Content[i+2:]...)
+	return key, value
+}
@@ -0,0 +1,86 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/siderolabs/talos/pkg/machinery/api/storage"
+)
+
+// DiskToMap converts the discovered disk to the map exposed to templates as .Disks.
+func DiskToMap(d *storage.Disk) (map[string]interface{}, error) {
+	dj, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	var disk map[string]interface{}
+	if err := json.Unmarshal(dj, &disk); err != nil {
+		return nil, err
+	}
+	return disk, nil
+}
+
+// DiskSelector matches disks by the fields available to templates in .Disks.
+type DiskSelector []diskRequirement
+
+type diskRequirement struct {
+	key   string
+	value string
+	regex *regexp.Regexp
+}
+
+// ParseDiskSelector parses comma separated requirements, every requirement is either
+// `key=value` for exact match or `key=~regex` for regular expression match, e.g.
+// `model=~Samsung,size=1920383410176`.
+func ParseDiskSelector(s string) (DiskSelector, error) {
+	var selector DiskSelector
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(part, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid disk selector requirement %q, expected key=value or key=~regex", part)
+		}
+		req := diskRequirement{key: strings.TrimSpace(key), value: value}
+		if strings.HasPrefix(value, "~") {
+			re, err := regexp.Compile(strings.TrimPrefix(value, "~"))
+			if err != nil {
+				return nil, fmt.Errorf("invalid regular expression in disk selector %q: %w", part, err)
+			}
+			req.regex = re
+		}
+		selector = append(selector, req)
+	}
+	if len(selector) == 0 {
+		return nil, fmt.Errorf("disk selector is empty")
+	}
+	return selector, nil
+}
+
+// Matches returns true if the disk satisfies all requirements of the selector.
+// Fields missing from the disk are compared as empty strings.
+func (s DiskSelector) Matches(disk map[string]interface{}) bool {
+	for _, req := range s {
+		var value string
+		switch v := disk[req.key].(type) {
+		case nil:
+		case float64:
+			value = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			value = fmt.Sprint(v)
+		}
+		if req.regex != nil {
+			if !req.regex.MatchString(value) {
+				return false
+			}
+		} else if value != req.value {
+			return false
+		}
+	}
+	return true
+}
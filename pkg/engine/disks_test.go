@@ -0,0 +1,50 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/siderolabs/talos/pkg/machinery/api/storage"
+)
+
+func TestDiskSelector(t *testing.T) {
+	disk, err := DiskToMap(&storage.Disk{
+		DeviceName: "/dev/nvme0n1",
+		Model:      "SAMSUNG MZQL21T9HCJR-00A07",
+		Serial:     "S64GNE0RB00153",
+		Size:       1920383410176,
+		Type:       storage.Disk_NVME,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		selector string
+		want     bool
+		wantErr  bool
+	}{
+		{selector: "model=~SAMSUNG", want: true},
+		{selector: "model=~^Samsung", want: false},
+		{selector: "device_name=/dev/nvme0n1,size=1920383410176", want: true},
+		{selector: "serial=S64GNE0RB00153, device_name=~sda", want: false},
+		{selector: "system_disk=", want: true},
+		{selector: "model", wantErr: true},
+		{selector: "model=~[", wantErr: true},
+		{selector: "", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.selector, func(t *testing.T) {
+			selector, err := ParseDiskSelector(tc.selector)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("ParseDiskSelector() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+			if got := selector.Matches(disk); got != tc.want {
+				t.Errorf("Matches() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
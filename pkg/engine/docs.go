@@ -0,0 +1,322 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/chart"
+)
+
+// ValueDoc documents a value consumed by the chart.
+type ValueDoc struct {
+	// Path is dot separated, items of lists are marked with [], like vlans[].vlanId
+	Path        string   `json:"path"`
+	Type        string   `json:"type,omitempty"`
+	Default     string   `json:"default,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Enum        []string `json:"enum,omitempty"`
+	// Templates are the templates referencing the value or one of its parents
+	Templates []string `json:"templates,omitempty"`
+}
+
+var (
+	// valueKeyLine matches a key of values.yaml, its indent and the key
+	valueKeyLine = regexp.MustCompile(`^(\s*)([A-Za-z0-9_-]+):(\s|$)`)
+	// commentedKeyLine matches a commented out top-level key, the example of an optional value
+	commentedKeyLine = regexp.MustCompile(`^#\s?([A-Za-z0-9_-]+):(\s|$)`)
+	// valuesReference matches a reference to the values in a template
+	valuesReference = regexp.MustCompile(`\.Values((?:\.[A-Za-z0-9_]+)+)`)
+	identifierKey   = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+)
+
+// jsonSchema is the subset of JSON schema describing values.
+type jsonSchema struct {
+	Type        interface{}            `json:"type"`
+	Description string                 `json:"description"`
+	Enum        []interface{}          `json:"enum"`
+	Properties  map[string]*jsonSchema `json:"properties"`
+	Items       *jsonSchema            `json:"items"`
+}
+
+// ValuesDocs documents the values consumed by the chart. The values are collected
+// from values.yaml, including the commented out examples of optional values, from the schema
+// and from the templates referencing them. Descriptions are the comments of values.yaml
+// preceding the values, or the descriptions of the schema.
+func ValuesDocs(chrt *chart.Chart) ([]ValueDoc, error) {
+	docs := map[string]*ValueDoc{}
+	doc := func(path string) *ValueDoc {
+		if docs[path] == nil {
+			docs[path] = &ValueDoc{Path: path}
+		}
+		return docs[path]
+	}
+
+	skip := map[string]bool{"global": true}
+	for _, dependency := range chrt.Dependencies() {
+		skip[dependency.Name()] = true
+	}
+
+	// Values set in values.yaml with their defaults
+	var walk func(values map[string]interface{}, prefix string)
+	walk = func(values map[string]interface{}, prefix string) {
+		for key, value := range values {
+			if prefix == "" && skip[key] {
+				continue
+			}
+			valuePath := strings.TrimPrefix(prefix+"."+key, ".")
+			if nested, ok := value.(map[string]interface{}); ok && len(nested) > 0 && identifierKeys(nested) {
+				walk(nested, valuePath)
+				continue
+			}
+			d := doc(valuePath)
+			d.Type = valueType(value)
+			defaultValue, err := json.Marshal(value)
+			if err != nil {
+				continue
+			}
+			d.Default = string(defaultValue)
+		}
+	}
+	walk(chrt.Values, "")
+
+	// Values described by the schema
+	if len(chrt.Schema) > 0 {
+		var schema jsonSchema
+		if err := json.Unmarshal(chrt.Schema, &schema); err != nil {
+			return nil, fmt.Errorf("error parsing values.schema.json: %w", err)
+		}
+		var walkSchema func(schema *jsonSchema, prefix string)
+		walkSchema = func(schema *jsonSchema, prefix string) {
+			for key, property := range schema.Properties {
+				if property == nil {
+					continue
+				}
+				valuePath := strings.TrimPrefix(prefix+"."+key, ".")
+				d := doc(valuePath)
+				if schemaType := property.typeName(); schemaType != "" {
+					d.Type = schemaType
+				}
+				if d.Description == "" {
+					d.Description = property.Description
+				}
+				for _, value := range property.Enum {
+					d.Enum = append(d.Enum, fmt.Sprint(value))
+				}
+				walkSchema(property, valuePath)
+				if property.Items != nil {
+					walkSchema(property.Items, valuePath+"[]")
+				}
+			}
+		}
+		walkSchema(&schema, "")
+	}
+
+	// Values referenced by the templates only
+	var references func(c *chart.Chart)
+	references = func(c *chart.Chart) {
+		for _, template := range c.Templates {
+			for _, match := range valuesReference.FindAllStringSubmatch(string(template.Data), -1) {
+				valuePath := strings.TrimPrefix(match[1], ".")
+				if skip[strings.Split(valuePath, ".")[0]] || documented(docs, valuePath) {
+					continue
+				}
+				doc(valuePath)
+			}
+		}
+		for _, dependency := range c.Dependencies() {
+			references(dependency)
+		}
+	}
+	references(chrt)
+
+	// Comments of values.yaml override the schema descriptions
+	for _, file := range chrt.Raw {
+		if file.Name != "values.yaml" {
+			continue
+		}
+		for valuePath, description := range valueComments(string(file.Data)) {
+			if d, ok := docs[valuePath]; ok {
+				d.Description = description
+			}
+		}
+	}
+
+	result := make([]ValueDoc, 0, len(docs))
+	for _, d := range docs {
+		keys := strings.Split(strings.ReplaceAll(d.Path, "[]", ""), ".")
+		seen := map[string]bool{}
+		for _, reference := range valueReferences(chrt, keys) {
+			file := reference[:strings.LastIndex(reference, ":")]
+			if !seen[file] {
+				seen[file] = true
+				d.Templates = append(d.Templates, file)
+			}
+		}
+		sort.Strings(d.Templates)
+		result = append(result, *d)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+
+	return result, nil
+}
+
+// documented reports whether the value or one of its children is documented.
+func documented(docs map[string]*ValueDoc, valuePath string) bool {
+	for documentedPath := range docs {
+		documentedPath = strings.ReplaceAll(documentedPath, "[]", "")
+		if documentedPath == valuePath || strings.HasPrefix(documentedPath, valuePath+".") || strings.HasPrefix(valuePath, documentedPath+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func identifierKeys(values map[string]interface{}) bool {
+	for key := range values {
+		if !identifierKey.MatchString(key) {
+			return false
+		}
+	}
+	return true
+}
+
+func valueType(value interface{}) string {
+	switch value.(type) {
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case int, int64, float64:
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return ""
+	}
+}
+
+func (s *jsonSchema) typeName() string {
+	var types []string
+	switch t := s.Type.(type) {
+	case string:
+		types = []string{t}
+	case []interface{}:
+		for _, item := range t {
+			types = append(types, fmt.Sprint(item))
+		}
+	}
+	if len(types) == 1 && types[0] == "array" && s.Items != nil {
+		if items := s.Items.typeName(); items != "" {
+			return "array of " + items
+		}
+	}
+	return strings.Join(types, " or ")
+}
+
+// valueComments returns the comments preceding the keys of values.yaml, keyed by value path.
+// Comments of commented out top-level keys, the examples of optional values, are included,
+// the commented out example itself is skipped. The helm-docs "-- " marker is removed.
+func valueComments(values string) map[string]string {
+	comments := map[string]string{}
+
+	type key struct {
+		indent int
+		name   string
+	}
+	var (
+		stack     []key
+		pending   []string
+		inExample bool
+	)
+	for _, line := range strings.Split(values, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			pending, inExample = nil, false
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "#") {
+			if match := commentedKeyLine.FindStringSubmatch(line); match != nil && len(pending) > 0 {
+				comments[match[1]] = strings.Join(pending, " ")
+				pending, inExample = nil, true
+				continue
+			}
+			// Lines of the commented out example are indented or list items
+			if inExample && (strings.HasPrefix(line, "#  ") || strings.HasPrefix(line, "# -")) {
+				continue
+			}
+			inExample = false
+			text := strings.TrimSpace(strings.TrimPrefix(trimmed, "#"))
+			text = strings.TrimPrefix(text, "-- ")
+			pending = append(pending, text)
+			continue
+		}
+		inExample = false
+
+		match := valueKeyLine.FindStringSubmatch(line)
+		if match == nil {
+			pending = nil
+			continue
+		}
+		indent := len(match[1])
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+		stack = append(stack, key{indent: indent, name: match[2]})
+
+		if len(pending) > 0 {
+			names := make([]string, 0, len(stack))
+			for _, k := range stack {
+				names = append(names, k.name)
+			}
+			comments[path.Join(names...)] = strings.Join(pending, " ")
+			pending = nil
+		}
+	}
+
+	for valuePath, comment := range comments {
+		comments[strings.ReplaceAll(valuePath, "/", ".")] = strings.TrimSuffix(comment, ":")
+		if strings.Contains(valuePath, "/") {
+			delete(comments, valuePath)
+		}
+	}
+	return comments
+}
+
+// ValuesMarkdown formats the documentation of the values as a Markdown table.
+func ValuesMarkdown(chartName string, docs []ValueDoc) string {
+	escape := func(s string) string {
+		return strings.ReplaceAll(s, "|", `\|`)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Values of %s\n\n", chartName)
+	b.WriteString("| Value | Type | Default | Description | Used by |\n")
+	b.WriteString("|-------|------|---------|-------------|---------|\n")
+	for _, d := range docs {
+		defaultValue := ""
+		if d.Default != "" {
+			defaultValue = "`" + escape(d.Default) + "`"
+		}
+		description := escape(d.Description)
+		if len(d.Enum) > 0 {
+			enum := make([]string, 0, len(d.Enum))
+			for _, value := range d.Enum {
+				enum = append(enum, "`"+escape(value)+"`")
+			}
+			description = strings.TrimSpace(description + " One of " + strings.Join(enum, ", ") + ".")
+		}
+		templates := make([]string, 0, len(d.Templates))
+		for _, template := range d.Templates {
+			templates = append(templates, "`"+template+"`")
+		}
+		fmt.Fprintf(&b, "| `%s` | %s | %s | %s | %s |\n", d.Path, escape(d.Type), defaultValue, description, strings.Join(templates, ", "))
+	}
+	return b.String()
+}
@@ -0,0 +1,79 @@
+package engine
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValueComments(t *testing.T) {
+	values := `# Cluster endpoint
+endpoint: "https://192.168.100.10:6443"
+nvidia:
+  # -- Version of the driver
+  driverVersion: "550"
+  plain: true
+# Bond the uplinks:
+# bond:
+#   mode: 802.3ad
+#   # comment of the example
+#   members: []
+
+# VLANs on top of the default interface:
+# vlans:
+# - vlanId: 100
+`
+	expected := map[string]string{
+		"endpoint":             "Cluster endpoint",
+		"nvidia.driverVersion": "Version of the driver",
+		"bond":                 "Bond the uplinks",
+		"vlans":                "VLANs on top of the default interface",
+	}
+	if comments := valueComments(values); !reflect.DeepEqual(comments, expected) {
+		t.Errorf("expected comments %v, got %v", expected, comments)
+	}
+}
+
+func TestValuesDocs(t *testing.T) {
+	chrt, err := LoadChart("../../charts/generic", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs, err := ValuesDocs(chrt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byPath := map[string]ValueDoc{}
+	for _, d := range docs {
+		byPath[d.Path] = d
+	}
+
+	endpoint := byPath["endpoint"]
+	if endpoint.Type != "string" || endpoint.Default != `"https://192.168.100.10:6443"` || !contains(endpoint.Templates, "templates/_helpers.tpl") {
+		t.Errorf("unexpected endpoint documentation %+v", endpoint)
+	}
+	bond := byPath["bond"]
+	if !strings.HasPrefix(bond.Description, "Bond the uplinks") || bond.Type != "object" {
+		t.Errorf("expected bond to be documented from the values comments, got %+v", bond)
+	}
+	if mode := byPath["bond.mode"]; !contains(mode.Enum, "802.3ad") {
+		t.Errorf("expected bond.mode enum from the schema, got %+v", mode)
+	}
+	if _, ok := byPath["vlans[].vlanId"]; !ok {
+		t.Errorf("expected items of vlans to be documented")
+	}
+	if _, ok := byPath["floatingIP"]; !ok {
+		t.Errorf("expected floatingIP referenced by the library templates to be documented")
+	}
+	for path := range byPath {
+		if strings.HasPrefix(path, "talm.") || strings.HasPrefix(path, "global") {
+			t.Errorf("unexpected value of a dependency %s", path)
+		}
+	}
+
+	markdown := ValuesMarkdown(chrt.Name(), docs)
+	if !strings.Contains(markdown, "| `endpoint` | string | `\"https://192.168.100.10:6443\"` |") {
+		t.Errorf("unexpected markdown:\n%s", markdown)
+	}
+}
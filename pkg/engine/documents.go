@@ -0,0 +1,235 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/aenix-io/talm/pkg/yamltools"
+	"gopkg.in/yaml.v3"
+
+	"github.com/siderolabs/talos/pkg/machinery/config/configloader"
+)
+
+// document is a YAML document of a machine config, identified like Talos does by
+// apiVersion, kind and name. The v1alpha1 config and its patches have no kind.
+type document struct {
+	id   string
+	node *yaml.Node
+}
+
+const machineConfigID = "v1alpha1"
+
+// decodeDocuments splits a multi-document YAML into its documents.
+func decodeDocuments(data []byte) ([]document, error) {
+	var docs []document
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var node yaml.Node
+		err := decoder.Decode(&node)
+		if errors.Is(err, io.EOF) {
+			return docs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(node.Content) == 0 {
+			continue
+		}
+		docs = append(docs, document{id: documentID(&node), node: &node})
+	}
+}
+
+// documentID returns apiVersion/kind/name of the document, the name only for named documents.
+// Documents without a kind are parts of the v1alpha1 config, documents which are not mappings,
+// like JSON patches, have no ID.
+func documentID(node *yaml.Node) string {
+	root := node.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return ""
+	}
+	fields := map[string]string{}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if value := root.Content[i+1]; value.Kind == yaml.ScalarNode {
+			fields[root.Content[i].Value] = value.Value
+		}
+	}
+	if fields["kind"] == "" {
+		return machineConfigID
+	}
+	id := fields["apiVersion"] + "/" + fields["kind"]
+	if name, ok := fields["name"]; ok {
+		id += "/" + name
+	}
+	return id
+}
+
+// registered reports whether the Talos machinery knows the kind of the document. The documents
+// of unknown kinds, introduced by Talos versions newer than talm, can't be loaded as patches.
+func registered(doc document) bool {
+	if doc.id == machineConfigID || doc.id == "" {
+		return true
+	}
+	data, err := encodeDocuments([]document{doc})
+	if err != nil {
+		return true
+	}
+	_, err = configloader.NewFromBytes(data)
+	return err == nil || !strings.Contains(err.Error(), "not registered")
+}
+
+// splitPatches separates the documents of unknown kinds from the patches, the rest is loaded
+// by the Talos machinery. Documents of unknown kinds with the same ID are merged together,
+// in the order of the patches. Patches prefixed with @ are read from files, like configpatcher does.
+func splitPatches(patches []string) ([]string, []document, error) {
+	var (
+		known   []string
+		unknown []document
+	)
+	for _, patch := range patches {
+		contents := []byte(patch)
+		if strings.HasPrefix(patch, "@") {
+			var err error
+			contents, err = os.ReadFile(patch[1:])
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+
+		docs, err := decodeDocuments(contents)
+		if err != nil {
+			// Invalid patches are reported by configpatcher
+			known = append(known, patch)
+			continue
+		}
+
+		var knownDocs []document
+		for _, doc := range docs {
+			if registered(doc) {
+				knownDocs = append(knownDocs, doc)
+			} else {
+				unknown = mergeDocument(unknown, doc)
+			}
+		}
+		if len(knownDocs) == len(docs) {
+			known = append(known, patch)
+			continue
+		}
+		if len(knownDocs) > 0 {
+			data, err := encodeDocuments(knownDocs)
+			if err != nil {
+				return nil, nil, err
+			}
+			known = append(known, string(data))
+		}
+	}
+	return known, unknown, nil
+}
+
+// mergeDocument merges the document into the document with the same ID, or appends it.
+// Mappings are merged, lists are appended and other values are replaced, as Talos merges configs.
+func mergeDocument(docs []document, doc document) []document {
+	for _, existing := range docs {
+		if existing.id == doc.id {
+			mergeNodes(existing.node.Content[0], doc.node.Content[0])
+			return docs
+		}
+	}
+	return append(docs, doc)
+}
+
+func mergeNodes(dst, src *yaml.Node) {
+	switch {
+	case dst.Kind == yaml.MappingNode && src.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(src.Content); i += 2 {
+			key, value := src.Content[i], src.Content[i+1]
+			merged := false
+			for j := 0; j+1 < len(dst.Content); j += 2 {
+				if dst.Content[j].Value == key.Value {
+					mergeNodes(dst.Content[j+1], value)
+					merged = true
+					break
+				}
+			}
+			if !merged {
+				dst.Content = append(dst.Content, key, value)
+			}
+		}
+	case dst.Kind == yaml.SequenceNode && src.Kind == yaml.SequenceNode:
+		dst.Content = append(dst.Content, src.Content...)
+	default:
+		*dst = *src
+	}
+}
+
+// diffDocuments returns the changes of the documents of modified from the documents of original
+// with the same ID, documents missing in original are returned as they are.
+func diffDocuments(original, modified []byte) ([]document, error) {
+	origDocs, err := decodeDocuments(original)
+	if err != nil {
+		return nil, err
+	}
+	modDocs, err := decodeDocuments(modified)
+	if err != nil {
+		return nil, err
+	}
+
+	var diff []document
+	for _, modDoc := range modDocs {
+		var origDoc *document
+		for i := range origDocs {
+			if origDocs[i].id == modDoc.id {
+				origDoc = &origDocs[i]
+				break
+			}
+		}
+		if origDoc == nil {
+			diff = append(diff, modDoc)
+			continue
+		}
+
+		origData, err := encodeDocuments([]document{*origDoc})
+		if err != nil {
+			return nil, err
+		}
+		modData, err := encodeDocuments([]document{modDoc})
+		if err != nil {
+			return nil, err
+		}
+		changes, err := yamltools.DiffYAMLs(origData, modData)
+		if err != nil {
+			return nil, err
+		}
+		if len(changes) == 0 {
+			continue
+		}
+		var node yaml.Node
+		if err := yaml.Unmarshal(changes, &node); err != nil {
+			return nil, err
+		}
+		diff = append(diff, document{id: modDoc.id, node: &node})
+	}
+	return diff, nil
+}
+
+// encodeDocuments writes the documents as a multi-document YAML.
+func encodeDocuments(docs []document) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeDocuments(&buf, docs); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeDocuments(w io.Writer, docs []document) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	for _, doc := range docs {
+		if err := encoder.Encode(doc.node); err != nil {
+			return err
+		}
+	}
+	return encoder.Close()
+}
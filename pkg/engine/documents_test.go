@@ -0,0 +1,146 @@
+package engine
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/siderolabs/talos/pkg/machinery/config/machine"
+)
+
+func TestSplitPatches(t *testing.T) {
+	patches := []string{
+		`machine:
+  type: worker
+---
+apiVersion: v1alpha1
+kind: KmsgLogConfig
+name: remote
+url: udp://10.0.0.1:514
+---
+apiVersion: v1alpha1
+kind: FutureConfig
+name: a
+settings:
+  list: [x]
+  value: one
+`,
+		`apiVersion: v1alpha1
+kind: FutureConfig
+name: a
+settings:
+  list: [y]
+  value: two
+---
+apiVersion: v1alpha1
+kind: FutureConfig
+name: b
+`,
+		`- op: remove
+  path: /machine/install
+`,
+	}
+
+	known, unknown, err := splitPatches(patches)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(known) != 2 {
+		t.Fatalf("expected 2 known patches, got %d: %q", len(known), known)
+	}
+	if !strings.Contains(known[0], "KmsgLogConfig") || strings.Contains(known[0], "FutureConfig") {
+		t.Errorf("unexpected known patch:\n%s", known[0])
+	}
+	if known[1] != patches[2] {
+		t.Errorf("JSON patch changed:\n%s", known[1])
+	}
+
+	if len(unknown) != 2 || unknown[0].id != "v1alpha1/FutureConfig/a" || unknown[1].id != "v1alpha1/FutureConfig/b" {
+		t.Fatalf("unexpected unknown documents: %+v", unknown)
+	}
+	merged, err := encodeDocuments(unknown[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `apiVersion: v1alpha1
+kind: FutureConfig
+name: a
+settings:
+  list: [x, y]
+  value: two
+`
+	if string(merged) != expected {
+		t.Errorf("expected merged document:\n%s\ngot:\n%s", expected, merged)
+	}
+}
+
+func TestDiffDocuments(t *testing.T) {
+	original := `version: v1alpha1
+machine:
+  type: worker
+`
+	modified := `version: v1alpha1
+machine:
+  type: worker
+  install:
+    disk: /dev/sda
+---
+apiVersion: v1alpha1
+kind: KmsgLogConfig
+name: remote
+url: udp://10.0.0.1:514
+`
+
+	diff, err := diffDocuments([]byte(original), []byte(modified))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := encodeDocuments(diff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `machine:
+  install:
+    disk: /dev/sda
+---
+apiVersion: v1alpha1
+kind: KmsgLogConfig
+name: remote
+url: udp://10.0.0.1:514
+`
+	if string(out) != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, out)
+	}
+}
+
+func TestSerializeUnknownDocuments(t *testing.T) {
+	patch := `machine:
+  type: worker
+cluster:
+  clusterName: test
+  controlPlane:
+    endpoint: https://10.0.0.1:6443
+---
+apiVersion: v1alpha1
+kind: FutureConfig
+name: a
+`
+
+	configBundle, err := FullConfigProcess(context.Background(), Options{}, []string{patch})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := SerializeConfiguration(configBundle, machine.TypeWorker)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	docs, err := decodeDocuments(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 2 || docs[0].id != machineConfigID || docs[1].id != "v1alpha1/FutureConfig/a" {
+		t.Errorf("unexpected documents:\n%s", out)
+	}
+}
@@ -5,8 +5,9 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
-	"path/filepath"
+	"path"
 	"reflect"
 	"strconv"
 	"strings"
@@ -15,11 +16,12 @@ import (
 	"gopkg.in/yaml.v3"
 
 	helmEngine "github.com/aenix-io/talm/pkg/engine/helm"
+	"github.com/aenix-io/talm/pkg/fileutil"
+	"github.com/aenix-io/talm/pkg/plugins"
 	"github.com/aenix-io/talm/pkg/yamltools"
 	"github.com/cosi-project/runtime/pkg/resource"
 	"github.com/cosi-project/runtime/pkg/resource/meta"
 	"github.com/hashicorp/go-multierror"
-	"helm.sh/helm/v3/pkg/chart/loader"
 	"helm.sh/helm/v3/pkg/strvals"
 
 	"github.com/siderolabs/talos/cmd/talosctl/pkg/talos/helpers"
@@ -30,11 +32,14 @@ import (
 	"github.com/siderolabs/talos/pkg/machinery/config/configpatcher"
 	"github.com/siderolabs/talos/pkg/machinery/config/encoder"
 	"github.com/siderolabs/talos/pkg/machinery/config/generate"
-	"github.com/siderolabs/talos/pkg/machinery/config/generate/secrets"
 	"github.com/siderolabs/talos/pkg/machinery/config/machine"
 	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
 )
 
+// notesFile is the template with the next steps printed after init and apply.
+const notesFile = "NOTES.txt"
+
 // Options encapsulates all parameters necessary for rendering.
 type Options struct {
 	Insecure          bool
@@ -44,24 +49,62 @@ type Options struct {
 	FileValues        []string
 	JsonValues        []string
 	LiteralValues     []string
+	NodeValues        map[string]interface{}
+	EnvValues         []string
+	EnvAllowlist      []string
+	Plugins           []plugins.Config
 	TalosVersion      string
 	WithSecrets       string
+	WithoutSecrets    bool
 	Full              bool
 	Root              string
+	Extends           string
 	Offline           bool
+	NoLookupCache     bool
 	KubernetesVersion string
 	TemplateFiles     []string
 	ClusterName       string
 	Endpoint          string
+	// Topology is the failure domain of the rendered node
+	Topology Topology
+	// Profile, if set, accumulates the time spent in the templates
+	Profile *helmEngine.Profile
+	// RenderTarget is the command the rendered config is for, exposed as .RenderTarget
+	RenderTarget string
+	// Deterministic fails the template functions depending on the time or on randomness
+	Deterministic bool
+	// sentinel renders replace a value by a sentinel, which the assertions of the templates reject
+	sentinel bool
+}
+
+// Render targets, the templates can leave out what only helps a human reading the node files.
+const (
+	RenderTargetTemplate = "template"
+	RenderTargetApply    = "apply"
+	RenderTargetUpgrade  = "upgrade"
+)
+
+// RenderTargets lists the render targets, the first one is the default.
+var RenderTargets = []string{RenderTargetTemplate, RenderTargetApply, RenderTargetUpgrade}
+
+// ConfigBundle is the config bundle with the documents of kinds unknown to the Talos machinery,
+// which are passed through as they are.
+type ConfigBundle struct {
+	*bundle.Bundle
+	documents []document
 }
 
 // FullConfigProcess handles the full process of creating and updating the Bundle.
-func FullConfigProcess(ctx context.Context, opts Options, patches []string) (*bundle.Bundle, error) {
+func FullConfigProcess(ctx context.Context, opts Options, patches []string) (*ConfigBundle, error) {
 	configBundle, err := InitializeConfigBundle(opts)
 	if err != nil {
 		return nil, fmt.Errorf("initial config bundle error: %w", err)
 	}
 
+	patches, documents, err := splitPatches(patches)
+	if err != nil {
+		return nil, err
+	}
 	loadedPatches, err := configpatcher.LoadPatches(patches)
 	if err != nil {
 		return nil, err
@@ -100,7 +143,7 @@ func FullConfigProcess(ctx context.Context, opts Options, patches []string) (*bu
 		return nil, fmt.Errorf("apply updated patches error: %w", err)
 	}
 
-	return configBundle, nil
+	return &ConfigBundle{Bundle: configBundle, documents: documents}, nil
 }
 
 // Function to initialize configuration settings
@@ -116,7 +159,7 @@ func InitializeConfigBundle(opts Options) (*bundle.Bundle, error) {
 	}
 
 	if opts.WithSecrets != "" {
-		secretsBundle, err := secrets.LoadBundle(opts.WithSecrets)
+		secretsBundle, err := LoadSecretsBundle(opts.WithSecrets)
 		if err != nil {
 			return nil, fmt.Errorf("failed to load secrets bundle: %w", err)
 		}
@@ -139,87 +182,230 @@ func InitializeConfigBundle(opts Options) (*bundle.Bundle, error) {
 }
 
 // Function for serializing the configuration
-func SerializeConfiguration(configBundle *bundle.Bundle, machineType machine.Type) ([]byte, error) {
-	return configBundle.Serialize(encoder.CommentsDisabled, machineType)
+func SerializeConfiguration(configBundle *ConfigBundle, machineType machine.Type) ([]byte, error) {
+	result, err := configBundle.Serialize(encoder.CommentsDisabled, machineType)
+	if err != nil || len(configBundle.documents) == 0 {
+		return result, err
+	}
+	documents, err := encodeDocuments(configBundle.documents)
+	if err != nil {
+		return nil, err
+	}
+	return append(append(result, "---\n"...), documents...), nil
 }
 
 // Render executes the rendering of templates based on the provided options.
 func Render(ctx context.Context, c *client.Client, opts Options) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := RenderTo(ctx, c, opts, buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// RenderTo executes the rendering of templates and streams the resulting
+// document to w as soon as it is ready, without keeping a copy in memory.
+func RenderTo(ctx context.Context, c *client.Client, opts Options, w io.Writer) error {
+	var node Node
 
 	// Gather facts and enable lookup options
 	if !opts.Offline {
 		if err := helpers.FailIfMultiNodes(ctx, "talm template"); err != nil {
-			return nil, err
+			return err
 		}
 
-		response, err := c.Disks(ctx)
+		opts.TalosVersion = ResolveTalosVersion(ctx, c, opts.TalosVersion)
+		node = NewNode(c)
+	}
+
+	return RenderNode(ctx, node, opts, w)
+}
+
+// RenderNode renders the templates with the facts gathered from the node.
+// Without a node .Disks and .Hardware are empty and lookups return empty results, as in offline mode.
+func RenderNode(ctx context.Context, node Node, opts Options, w io.Writer) error {
+	helmEngine.Disks = map[string]interface{}{}
+	helmEngine.Hardware = map[string]interface{}{}
+	helmEngine.LookupFunc = func(string, string, string) (map[string]interface{}, error) {
+		return map[string]interface{}{}, nil
+	}
+	helmEngine.CheckManifestURLFunc = func(string, string) error { return nil }
+
+	if node != nil {
+		disks, err := node.Disks(ctx)
 		if err != nil {
-			if response == nil {
-				return nil, fmt.Errorf("error getting disks: %w", err)
-			}
+			return fmt.Errorf("error getting disks: %w", err)
 		}
-		for _, m := range response.Messages {
-			for _, d := range m.Disks {
-				dj, err := json.Marshal(d)
-				if err != nil {
-					return nil, err
-				}
-				var disk map[string]interface{}
-				err = json.Unmarshal(dj, &disk)
-				if err != nil {
-					return nil, err
-				}
-				helmEngine.Disks[d.DeviceName] = disk
+
+		for _, d := range disks {
+			disk, err := DiskToMap(d)
+			if err != nil {
+				return err
 			}
+			helmEngine.Disks[d.DeviceName] = disk
 		}
 
-		helmEngine.LookupFunc = newLookupFunction(ctx, c)
+		helmEngine.Hardware = Hardware(ctx, node)
+
+		helmEngine.LookupFunc = func(kind string, namespace string, id string) (map[string]interface{}, error) {
+			return node.Lookup(ctx, kind, namespace, id)
+		}
+		if !opts.NoLookupCache {
+			helmEngine.LookupFunc = newCachedLookupFunction(helmEngine.LookupFunc)
+		}
+		helmEngine.CheckManifestURLFunc = func(url, checksum string) error {
+			return checkManifestURL(ctx, url, checksum)
+		}
 	}
 
+	chrt, out, err := renderChart(opts, nil)
+	if err != nil {
+		return err
+	}
+
+	configPatches := []string{}
+	for _, templateFile := range opts.TemplateFiles {
+		// Rendered templates are keyed by slash separated paths on every OS
+		requestedTemplate := path.Join(chrt.Name(), fileutil.TrimRoot(opts.Root, templateFile))
+		configPatch, ok := out[requestedTemplate]
+		if !ok {
+			return fmt.Errorf("template %s not found", templateFile)
+		}
+		configPatches = append(configPatches, configPatch)
+	}
+
+	return applyPatchesAndRenderConfig(ctx, opts, configPatches, chrt, w)
+}
+
+// renderChart loads the chart of the project and renders all of its templates,
+// extra is added to the top-level objects next to .Values.
+func renderChart(opts Options, extra map[string]interface{}) (*chart.Chart, map[string]string, error) {
 	chartPath, err := os.Getwd()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	if opts.Root != "" {
 		chartPath = opts.Root
 	}
 
-	chrt, err := loader.LoadDir(chartPath)
+	chrt, err := LoadChart(chartPath, opts.Extends)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	values, err := loadValues(opts)
+	values, err := chartValues(chrt, opts)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	if err := chartutil.ValidateAgainstSchema(chrt, values); err != nil {
+		return nil, nil, fmt.Errorf("values don't meet the specifications of the schema(s) in the following chart(s):\n%w", err)
 	}
 
+	out, err := renderChartValues(chartPath, chrt, values, opts, extra)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return chrt, out, nil
+}
+
+// renderChartValues renders all templates of the loaded chart with the values. Next to
+// .Template.Name and .Template.BasePath of Helm, the templates see .RenderTarget.
+func renderChartValues(chartPath string, chrt *chart.Chart, values chartutil.Values, opts Options, extra map[string]interface{}) (map[string]string, error) {
+	renderTarget := opts.RenderTarget
+	if renderTarget == "" {
+		renderTarget = RenderTargetTemplate
+	}
 	rootValues := map[string]interface{}{
-		"Values": mergeMaps(chrt.Values, values),
+		"Values":       values,
+		"Node":         map[string]interface{}{"Topology": opts.Topology.Map()},
+		"RenderTarget": renderTarget,
+	}
+	for k, v := range extra {
+		rootValues[k] = v
 	}
 
-	eng := helmEngine.Engine{}
-	out, err := eng.Render(chrt, rootValues)
+	pluginFuncs, err := plugins.Load(chartPath, opts.Plugins, opts.EnvAllowlist, helmEngine.FuncMap())
 	if err != nil {
 		return nil, err
 	}
 
-	configPatches := []string{}
-	for _, templateFile := range opts.TemplateFiles {
-		requestedTemplate := filepath.Join(chrt.Name(), templateFile)
-		configPatch, ok := out[requestedTemplate]
-		if !ok {
-			return nil, fmt.Errorf("template %s not found", templateFile)
-		}
-		configPatches = append(configPatches, configPatch)
+	eng := helmEngine.Engine{
+		EnvAllowlist:   opts.EnvAllowlist,
+		ExtraFuncs:     pluginFuncs,
+		Profile:        opts.Profile,
+		Deterministic:  opts.Deterministic,
+		SkipAssertions: opts.sentinel,
+	}
+	return eng.Render(chrt, rootValues)
+}
+
+// RenderNotes renders templates/NOTES.txt of the chart with the values of the project,
+// it returns an empty string if the chart has no notes. The notes get the name of
+// the talm command as .Command to tailor the next steps, like other templates
+// without a node they see empty .Disks and lookups.
+func RenderNotes(opts Options, command string) (string, error) {
+	helmEngine.Disks = map[string]interface{}{}
+	helmEngine.Hardware = map[string]interface{}{}
+	helmEngine.LookupFunc = func(string, string, string) (map[string]interface{}, error) {
+		return map[string]interface{}{}, nil
 	}
+	helmEngine.CheckManifestURLFunc = func(string, string) error { return nil }
 
-	finalConfig, err := applyPatchesAndRenderConfig(ctx, opts, configPatches, chrt)
+	chrt, out, err := renderChart(opts, map[string]interface{}{"Command": command})
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(out[path.Join(chrt.Name(), "templates", notesFile)]), nil
+}
+
+// Values returns the values of the project merged with the defaults of the chart,
+// as the templates see them.
+func Values(opts Options) (chartutil.Values, error) {
+	chartPath, err := os.Getwd()
 	if err != nil {
 		return nil, err
 	}
+	if opts.Root != "" {
+		chartPath = opts.Root
+	}
 
-	return finalConfig, nil
+	chrt, err := LoadChart(chartPath, opts.Extends)
+	if err != nil {
+		return nil, err
+	}
+	return chartValues(chrt, opts)
+}
+
+// chartValues merges user supplied values with the defaults of the chart and its
+// dependencies. Like in Helm, the "global" section is propagated to every
+// subchart and the section named after a subchart becomes its .Values,
+// so `--set subchart.key=value` addresses the subchart values.
+// Deprecated values declared in Chart.yaml are migrated both in the chart
+// values and in the user supplied ones, the secret values of both are decrypted.
+func chartValues(chrt *chart.Chart, opts Options) (chartutil.Values, error) {
+	if err := decryptChartValues(chrt); err != nil {
+		return nil, err
+	}
+	values, err := loadValues(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	deprecations, err := ValueDeprecations(chrt, opts.Extends)
+	if err != nil {
+		return nil, err
+	}
+	for _, warning := range MigrateValues(chrt.Values, deprecations) {
+		fmt.Fprintf(os.Stderr, "Warning: values.yaml: %s, run `talm migrate-values` to update it\n", warning)
+	}
+	for _, warning := range MigrateValues(values, deprecations) {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+
+	return chartutil.CoalesceValues(chrt, values)
 }
 
 // Imported from Helm
@@ -235,12 +421,19 @@ func loadValues(opts Options) (map[string]interface{}, error) {
 		if err != nil {
 			return nil, fmt.Errorf("failed to read values file %s: %w", filePath, err)
 		}
+		bytes, err = decryptSecretValues(bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt values file %s: %w", filePath, err)
+		}
 		if err := yaml.Unmarshal(bytes, &currentMap); err != nil {
 			return nil, fmt.Errorf("failed to unmarshal values from file %s: %w", filePath, err)
 		}
 		base = mergeMaps(base, currentMap)
 	}
 
+	// Per-node values from the modeline override the values files
+	base = mergeMaps(base, opts.NodeValues)
+
 	// Parse and merge values from --set-json
 	for _, value := range opts.JsonValues {
 		currentMap := make(map[string]interface{})
@@ -275,6 +468,21 @@ func loadValues(opts Options) (map[string]interface{}, error) {
 		}
 	}
 
+	// Parse and merge values from --set-env
+	for _, value := range opts.EnvValues {
+		reader := func(rs []rune) (interface{}, error) {
+			name := string(rs)
+			envValue, ok := os.LookupEnv(name)
+			if !ok {
+				return nil, fmt.Errorf("environment variable %s is not set", name)
+			}
+			return envValue, nil
+		}
+		if err := strvals.ParseIntoFile(value, base, reader); err != nil {
+			return nil, fmt.Errorf("failed to parse set-env value '%s': %w", value, err)
+		}
+	}
+
 	// Parse and merge values from --set-literal
 	for _, value := range opts.LiteralValues {
 		if err := strvals.ParseInto(value, base); err != nil {
@@ -306,22 +514,23 @@ func mergeMaps(a, b map[string]interface{}) map[string]interface{} {
 	return out
 }
 
-func applyPatchesAndRenderConfig(ctx context.Context, opts Options, configPatches []string, chrt *chart.Chart) ([]byte, error) {
+func applyPatchesAndRenderConfig(ctx context.Context, opts Options, configPatches []string, chrt *chart.Chart, w io.Writer) error {
 	// Generate options for the configuration based on the provided flags
 	genOptions := []generate.Option{}
 
 	if opts.TalosVersion != "" {
 		versionContract, err := config.ParseContractFromVersion(opts.TalosVersion)
 		if err != nil {
-			return nil, fmt.Errorf("invalid talos-version: %w", err)
+			return fmt.Errorf("invalid talos-version: %w", err)
 		}
 		genOptions = append(genOptions, generate.WithVersionContract(versionContract))
 	}
 
-	if opts.WithSecrets != "" {
-		secretsBundle, err := secrets.LoadBundle(opts.WithSecrets)
+	// Without secrets the generated secrets are replaced by placeholders in the output
+	if opts.WithSecrets != "" && !opts.WithoutSecrets {
+		secretsBundle, err := LoadSecretsBundle(opts.WithSecrets)
 		if err != nil {
-			return nil, fmt.Errorf("failed to load secrets bundle: %w", err)
+			return fmt.Errorf("failed to load secrets bundle: %w", err)
 		}
 		genOptions = append(genOptions, generate.WithSecretsBundle(secretsBundle))
 	}
@@ -339,17 +548,22 @@ func applyPatchesAndRenderConfig(ctx context.Context, opts Options, configPatche
 	// Load and apply patches to discover the machine type
 	configBundle, err := bundle.NewBundle(configBundleOpts...)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	patches, err := configpatcher.LoadPatches(configPatches)
+	// Documents of kinds unknown to the Talos machinery are merged by talm
+	knownPatches, documents, err := splitPatches(configPatches)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	patches, err := configpatcher.LoadPatches(knownPatches)
+	if err != nil {
+		return err
 	}
 
 	err = configBundle.ApplyPatches(patches, true, true)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	machineType := configBundle.ControlPlaneCfg.Machine().Type()
 	clusterName := configBundle.ControlPlaneCfg.Cluster().Name()
@@ -372,91 +586,86 @@ func applyPatchesAndRenderConfig(ctx context.Context, opts Options, configPatche
 	}
 	configBundle, err = bundle.NewBundle(configBundleOpts...)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	var configOrigin, configFull []byte
 	if !opts.Full {
 		configOrigin, err = configBundle.Serialize(encoder.CommentsDisabled, machineType)
 		if err != nil {
-			return nil, err
+			return err
 		}
 
 		// Overwrite some fields to preserve them for diff
-		var config map[string]interface{}
+		var config yaml.Node
 		if err := yaml.Unmarshal(configOrigin, &config); err != nil {
-			return nil, err
-		}
-		if machine, ok := config["machine"].(map[string]interface{}); ok {
-			machine["type"] = "unknown"
-		}
-		if cluster, ok := config["cluster"].(map[string]interface{}); ok {
-			cluster["clusterName"] = ""
-			controlPlane, ok := cluster["controlPlane"].(map[string]interface{})
-			if !ok {
-				controlPlane = map[string]interface{}{}
-				cluster["controlPlane"] = controlPlane
-			}
-			controlPlane["endpoint"] = ""
+			return err
 		}
+		yamltools.SetScalar(&config, "unknown", "machine", "type")
+		yamltools.SetScalar(&config, "", "cluster", "clusterName")
+		yamltools.SetScalar(&config, "", "cluster", "controlPlane", "endpoint")
 		configOrigin, err = yaml.Marshal(&config)
 		if err != nil {
-			return nil, err
+			return err
 		}
 	}
 	err = configBundle.ApplyPatches(patches, true, true)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	configFull, err = configBundle.Serialize(encoder.CommentsDisabled, machineType)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	var target []byte
+	// Every document is compared with the document of the defaults with the same ID
+	var target []document
 	if opts.Full {
-		target = configFull
+		target, err = decodeDocuments(configFull)
 	} else {
-		target, err = yamltools.DiffYAMLs(configOrigin, configFull)
-		if err != nil {
-			return nil, err
-		}
+		target, err = diffDocuments(configOrigin, configFull)
 	}
-
-	var targetNode yaml.Node
-	if err := yaml.Unmarshal(target, &targetNode); err != nil {
-		return nil, err
+	if err != nil {
+		return err
 	}
-
-	// Copy comments from source configuration to the final output
-	for _, configPatch := range configPatches {
-		var sourceNode yaml.Node
-		if err := yaml.Unmarshal([]byte(configPatch), &sourceNode); err != nil {
-			return nil, err
+	if opts.WithoutSecrets {
+		for _, doc := range target {
+			if doc.id == machineConfigID {
+				redactSecrets(doc.node)
+			}
 		}
-		dstPaths := make(map[string]*yaml.Node)
-		yamltools.CopyComments(&sourceNode, &targetNode, "", dstPaths)
-		yamltools.ApplyComments(&targetNode, "", dstPaths)
 	}
 
-	buf := &bytes.Buffer{}
-	encoder := yaml.NewEncoder(buf)
-	encoder.SetIndent(2)
-	if err := encoder.Encode(&targetNode); err != nil {
-		return nil, err
+	// Copy comments from source configuration to the final output,
+	// documents of unknown kinds keep their comments
+	for _, configPatch := range configPatches {
+		sourceDocs, err := decodeDocuments([]byte(configPatch))
+		if err != nil {
+			return err
+		}
+		for _, sourceDoc := range sourceDocs {
+			for _, targetDoc := range target {
+				if targetDoc.id != sourceDoc.id {
+					continue
+				}
+				dstPaths := make(map[string]*yaml.Node)
+				yamltools.CopyComments(sourceDoc.node, targetDoc.node, "", dstPaths)
+				yamltools.ApplyComments(targetDoc.node, "", dstPaths)
+			}
+		}
 	}
-	encoder.Close()
 
-	return buf.Bytes(), nil
+	return writeDocuments(w, append(target, documents...))
 }
 
 func readUnexportedField(field reflect.Value) any {
 	return reflect.NewAt(field.Type(), unsafe.Pointer(field.UnsafeAddr())).Elem().Interface()
 }
 
-// builds resource with metadata, spec and stringSpec fields
-func extractResourceData(r resource.Resource) (map[string]interface{}, error) {
+// ResourceData builds the resource with metadata and spec fields, as the lookup function
+// returns it to the templates
+func ResourceData(r resource.Resource) (map[string]interface{}, error) {
 	// extract metadata
 	o, _ := resource.MarshalYAML(r)
 	m, _ := yaml.Marshal(o)
@@ -501,7 +710,7 @@ func newLookupFunction(ctx context.Context, c *client.Client) func(resource stri
 				return nil
 			}
 
-			res, err := extractResourceData(r)
+			res, err := ResourceData(r)
 			if err != nil {
 				return nil
 			}
@@ -0,0 +1,334 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	helmEngine "github.com/aenix-io/talm/pkg/engine/helm"
+	"helm.sh/helm/v3/pkg/chart"
+)
+
+// benchmarkRender renders the node files one after another separated by "---" like
+// talm template -f does, either keeping the whole output in memory or streaming it.
+func benchmarkRender(b *testing.B, files int) {
+	opts := make([]Options, files)
+	for n := range opts {
+		template := "templates/controlplane.yaml"
+		if n%2 == 1 {
+			template = "templates/worker.yaml"
+		}
+		opts[n] = Options{
+			Offline:           true,
+			Root:              "../../charts/generic",
+			KubernetesVersion: "v1.30.0",
+			TemplateFiles:     []string{template},
+		}
+	}
+
+	b.Run("buffered", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var buf bytes.Buffer
+			for n := range opts {
+				if n > 0 {
+					fmt.Fprintln(&buf, "---")
+				}
+				out, err := Render(context.Background(), nil, opts[n])
+				if err != nil {
+					b.Fatal(err)
+				}
+				buf.Write(out)
+			}
+			if _, err := buf.WriteTo(io.Discard); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("streaming", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for n := range opts {
+				w := bufio.NewWriter(io.Discard)
+				if n > 0 {
+					fmt.Fprintln(w, "---")
+				}
+				if err := RenderTo(context.Background(), nil, opts[n], w); err != nil {
+					b.Fatal(err)
+				}
+				if err := w.Flush(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
+
+func BenchmarkRenderSingleNode(b *testing.B) { benchmarkRender(b, 1) }
+func BenchmarkRender10Nodes(b *testing.B)    { benchmarkRender(b, 10) }
+
+func TestChartValuesScoping(t *testing.T) {
+	child := &chart.Chart{
+		Metadata: &chart.Metadata{Name: "network", APIVersion: chart.APIVersionV2},
+		Values:   map[string]interface{}{"mtu": 1500, "bond": "none"},
+		Templates: []*chart.File{
+			{Name: "templates/network.yaml", Data: []byte(`{{ .Values.global.vip }} {{ .Values.mtu }} {{ .Values.bond }}`)},
+		},
+	}
+	parent := &chart.Chart{
+		Metadata: &chart.Metadata{Name: "cluster", APIVersion: chart.APIVersionV2},
+		Values: map[string]interface{}{
+			"global":  map[string]interface{}{"vip": "10.0.0.1"},
+			"network": map[string]interface{}{"bond": "lacp"},
+		},
+		Templates: []*chart.File{
+			{Name: "templates/cluster.yaml", Data: []byte(`{{ .Values.global.vip }} {{ .Values.network.mtu }}`)},
+		},
+	}
+	parent.AddDependency(child)
+
+	values, err := chartValues(parent, Options{Values: []string{"network.mtu=9000"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := helmEngine.Render(parent, map[string]interface{}{"Values": values})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := map[string]string{
+		"cluster/templates/cluster.yaml":                "10.0.0.1 9000",
+		"cluster/charts/network/templates/network.yaml": "10.0.0.1 9000 lacp",
+	}
+	for name, want := range expect {
+		if got := out[name]; got != want {
+			t.Errorf("%s: expected %q, got %q", name, want, got)
+		}
+	}
+}
+
+func TestRenderIncludes(t *testing.T) {
+	render := func(helpers string, profile *helmEngine.Profile) (map[string]string, error) {
+		chrt := &chart.Chart{
+			Metadata: &chart.Metadata{Name: "includes", APIVersion: chart.APIVersionV2},
+			Templates: []*chart.File{
+				{Name: "templates/_helpers.tpl", Data: []byte(helpers)},
+				{Name: "templates/config.yaml", Data: []byte(`{{ include "a" . }}`)},
+			},
+		}
+		return helmEngine.Engine{Profile: profile}.Render(chrt, map[string]interface{}{"Values": map[string]interface{}{}})
+	}
+
+	_, err := render(`{{ define "a" }}{{ include "b" . }}{{ end }}{{ define "b" }}{{ include "a" . }}{{ end }}`, nil)
+	if err == nil || !strings.Contains(err.Error(), `template "a" is included recursively more than 1000 times: a -> b -> a`) {
+		t.Fatalf("expected the include cycle to be reported, got %v", err)
+	}
+	if len(err.Error()) > 500 {
+		t.Errorf("expected the include cycle to be reported once, got %d bytes", len(err.Error()))
+	}
+
+	profile := helmEngine.NewProfile()
+	out, err := render(`{{ define "a" }}{{ include "b" . }}{{ include "b" . }}{{ end }}{{ define "b" }}b{{ end }}`, profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out["includes/templates/config.yaml"] != "bb" {
+		t.Errorf("unexpected output %q", out["includes/templates/config.yaml"])
+	}
+	calls := map[string]int{}
+	for _, entry := range profile.Entries() {
+		calls[entry.Name] = entry.Calls
+		if entry.Self > entry.Total {
+			t.Errorf("%s: self time %s exceeds total time %s", entry.Name, entry.Self, entry.Total)
+		}
+	}
+	if want := map[string]int{"includes/templates/config.yaml": 1, "a": 1, "b": 2}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("profiled calls = %v, want %v", calls, want)
+	}
+}
+
+func TestMigrateValues(t *testing.T) {
+	deprecations, err := parseDeprecations([]byte(`
+name: cozystack
+deprecatedValues:
+- name: floatingIP
+  replacement: vip.address
+- name: legacy.option
+  message: it has no effect
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := map[string]interface{}{
+		"floatingIP": "10.0.0.1",
+		"legacy":     map[string]interface{}{"option": true},
+		"vip":        map[string]interface{}{"interface": "eth0"},
+	}
+	warnings := MigrateValues(values, deprecations)
+	if len(warnings) != 2 {
+		t.Errorf("expected 2 warnings, got %q", warnings)
+	}
+	want := map[string]interface{}{
+		"vip": map[string]interface{}{"interface": "eth0", "address": "10.0.0.1"},
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("unexpected values %v", values)
+	}
+
+	data := []byte(`# Cluster endpoint
+endpoint: https://10.0.0.1:6443
+# Shared IP of control plane nodes
+floatingIP: 10.0.0.1
+legacy:
+  option: true
+`)
+	migrated, warnings, err := MigrateValuesFile(data, deprecations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 2 {
+		t.Errorf("expected 2 warnings, got %q", warnings)
+	}
+	wantFile := `# Cluster endpoint
+endpoint: https://10.0.0.1:6443
+vip:
+  # Shared IP of control plane nodes
+  address: 10.0.0.1
+`
+	if string(migrated) != wantFile {
+		t.Errorf("unexpected migrated file:\n%s", migrated)
+	}
+}
+
+func TestRenderNotes(t *testing.T) {
+	opts := Options{Root: "../../charts/generic", Values: []string{"endpoint=https://10.0.0.1:6443"}}
+
+	notes, err := RenderNotes(opts, "init")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(notes, "talm apply") || !strings.Contains(notes, "https://10.0.0.1:6443") {
+		t.Errorf("unexpected init notes:\n%s", notes)
+	}
+
+	notes, err = RenderNotes(opts, "apply")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(notes, "talm apply") || !strings.Contains(notes, "talm kubeconfig") {
+		t.Errorf("unexpected apply notes:\n%s", notes)
+	}
+
+	// Charts without NOTES.txt have no notes
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "Chart.yaml"), []byte("apiVersion: v2\nname: nonotes\nversion: 0.1.0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	notes, err = RenderNotes(Options{Root: root}, "init")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notes != "" {
+		t.Errorf("expected no notes, got:\n%s", notes)
+	}
+}
+
+func TestRenderTarget(t *testing.T) {
+	chrt := &chart.Chart{
+		Metadata: &chart.Metadata{Name: "target", APIVersion: chart.APIVersionV2},
+		Templates: []*chart.File{
+			{Name: "templates/_helpers.tpl", Data: []byte(`{{ define "comment" }}{{ if eq .RenderTarget "template" }}# rendered from {{ .Template.Name }}{{ end }}{{ end }}`)},
+			{Name: "templates/worker.yaml", Data: []byte(`{{ include "comment" . }} {{ .Template.BasePath }} {{ .RenderTarget }}`)},
+		},
+	}
+
+	for target, expected := range map[string]string{
+		"":                   "# rendered from target/templates/worker.yaml target/templates template",
+		RenderTargetTemplate: "# rendered from target/templates/worker.yaml target/templates template",
+		RenderTargetApply:    " target/templates apply",
+	} {
+		out, err := renderChartValues(".", chrt, map[string]interface{}{}, Options{RenderTarget: target}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := out["target/templates/worker.yaml"]; got != expected {
+			t.Errorf("target %q: expected %q, got %q", target, expected, got)
+		}
+	}
+}
+
+func TestRenderDeterministic(t *testing.T) {
+	render := func(template string, deterministic bool) (string, error) {
+		chrt := &chart.Chart{
+			Metadata:  &chart.Metadata{Name: "deterministic", APIVersion: chart.APIVersionV2},
+			Templates: []*chart.File{{Name: "templates/config.yaml", Data: []byte(template)}},
+		}
+		values := map[string]interface{}{"labels": map[string]interface{}{"zone": "a", "rack": "1", "row": "2", "pdu": "3"}}
+		out, err := renderChartValues(".", chrt, values, Options{Deterministic: deterministic}, nil)
+		return out["deterministic/templates/config.yaml"], err
+	}
+
+	for i := 0; i < 10; i++ {
+		out, err := render(`{{ keys .Values.labels | join "," }} {{ values .Values.labels | join "," }}`, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != "pdu,rack,row,zone 3,1,2,a" {
+			t.Fatalf("expected the keys and values in the order of the keys, got %q", out)
+		}
+	}
+
+	if _, err := render(`{{ now }} {{ randAlphaNum 8 }}`, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, template := range []string{`{{ now | date "2006" }}`, `{{ randAlphaNum 8 }}`, `{{ genCA "ca" 365 }}`} {
+		if _, err := render(template, true); err == nil || !strings.Contains(err.Error(), "the render must be deterministic") {
+			t.Errorf("%s: expected a deterministic render to fail, got %v", template, err)
+		}
+	}
+}
+
+func TestRenderAssertions(t *testing.T) {
+	render := func(template string, values map[string]interface{}) (string, error) {
+		chrt := &chart.Chart{
+			Metadata:  &chart.Metadata{Name: "assertions", APIVersion: chart.APIVersionV2},
+			Templates: []*chart.File{{Name: "templates/config.yaml", Data: []byte(template)}},
+		}
+		out, err := renderChartValues(".", chrt, values, Options{}, nil)
+		return out["assertions/templates/config.yaml"], err
+	}
+
+	for _, tt := range []struct {
+		template string
+		values   map[string]interface{}
+		expected string
+	}{
+		{`{{ .Values.podSubnets | assertCIDR "podSubnets" | join "," }}`, map[string]interface{}{"podSubnets": []interface{}{"10.244.0.0/16", "fd00::/64"}}, "10.244.0.0/16,fd00::/64"},
+		{`{{ .Values.podSubnets | assertCIDR "podSubnets" }}`, map[string]interface{}{"podSubnets": []interface{}{"10.244.0.0/16", "10.0.0/8"}}, `podSubnets[1] "10.0.0/8" is not a CIDR like 10.244.0.0/16`},
+		{`{{ .Values.floatingIP | assertCIDR "floatingIP" }}`, map[string]interface{}{}, "floatingIP is required"},
+		{`{{ .Values.kubernetesVersion | assertSemver "kubernetesVersion" ">=1.29" }}`, map[string]interface{}{"kubernetesVersion": "v1.30.0"}, "v1.30.0"},
+		{`{{ .Values.kubernetesVersion | assertSemver "kubernetesVersion" ">=1.29" }}`, map[string]interface{}{"kubernetesVersion": "v1.28.3"}, `kubernetesVersion v1.28.3 is outside the range ">=1.29"`},
+		{`{{ .Values.kubernetesVersion | assertSemver "kubernetesVersion" }}`, map[string]interface{}{"kubernetesVersion": "latest"}, `kubernetesVersion "latest" is not a version like v1.30.0`},
+	} {
+		out, err := render(tt.template, tt.values)
+		if err != nil {
+			out = err.Error()
+			if !strings.Contains(out, "execution error at (assertions/templates/config.yaml:1:") {
+				t.Errorf("%s: expected the location of the assertion in %q", tt.template, out)
+			}
+		}
+		if !strings.Contains(out, tt.expected) {
+			t.Errorf("%s: expected %q, got %q", tt.template, tt.expected, out)
+		}
+	}
+}
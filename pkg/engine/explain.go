@@ -0,0 +1,237 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	helmEngine "github.com/aenix-io/talm/pkg/engine/helm"
+	"github.com/mitchellh/copystructure"
+	"gopkg.in/yaml.v3"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+// explainSentinel replaces string values to find the rendered fields they end up in.
+const explainSentinel = "talm-explain-sentinel"
+
+// Explanation describes how a value is used by the templates of the chart.
+type Explanation struct {
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+	// References are the template lines referencing the value or one of its parents, as file:line
+	References []string `json:"references"`
+	// Fields are the rendered config fields affected by the value, keyed by template
+	Fields map[string][]string `json:"fields"`
+	// Changed are the templates with a different output which is not a YAML document, e.g. NOTES.txt
+	Changed []string `json:"changed,omitempty"`
+}
+
+// Explain finds the templates consuming the value at the dot separated path of the values,
+// like bond.mode, and the rendered fields it affects. The chart is rendered twice offline,
+// with the value and with a sentinel in its place, the fields which differ are affected.
+func Explain(opts Options, valuePath string) (*Explanation, error) {
+	keys := strings.Split(valuePath, ".")
+	for _, key := range keys {
+		if key == "" {
+			return nil, fmt.Errorf("invalid value path %q", valuePath)
+		}
+	}
+
+	helmEngine.Disks = map[string]interface{}{}
+	helmEngine.Hardware = map[string]interface{}{}
+	helmEngine.LookupFunc = func(string, string, string) (map[string]interface{}, error) {
+		return map[string]interface{}{}, nil
+	}
+
+	chartPath, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	if opts.Root != "" {
+		chartPath = opts.Root
+	}
+
+	chrt, err := LoadChart(chartPath, opts.Extends)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := chartValues(chrt, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	explanation := &Explanation{
+		Path:       valuePath,
+		References: valueReferences(chrt, keys),
+		Fields:     map[string][]string{},
+	}
+
+	// The sentinel is set on a copy, it is not validated against the schema
+	copied, err := copystructure.Copy(map[string]interface{}(values))
+	if err != nil {
+		return nil, err
+	}
+	sentinelValues := chartutil.Values(copied.(map[string]interface{}))
+
+	explanation.Value, err = setSentinel(sentinelValues, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	original, err := renderChartValues(chartPath, chrt, values, opts, nil)
+	if err != nil {
+		return nil, err
+	}
+	sentinelOpts := opts
+	sentinelOpts.sentinel = true
+	changed, err := renderChartValues(chartPath, chrt, sentinelValues, sentinelOpts, nil)
+	if err != nil {
+		return nil, fmt.Errorf("rendering with a sentinel in place of %s failed, the value is probably parsed by the templates: %w", valuePath, err)
+	}
+
+	for name, content := range original {
+		if changed[name] == content {
+			continue
+		}
+		file := strings.TrimPrefix(name, chrt.Name()+"/")
+
+		var before, after map[string]interface{}
+		if ext := path.Ext(file); ext != ".yaml" && ext != ".yml" ||
+			yaml.Unmarshal([]byte(content), &before) != nil || yaml.Unmarshal([]byte(changed[name]), &after) != nil {
+			explanation.Changed = append(explanation.Changed, file)
+			continue
+		}
+
+		var fields []string
+		diffFields("", before, after, &fields)
+		if len(fields) == 0 {
+			explanation.Changed = append(explanation.Changed, file)
+			continue
+		}
+		explanation.Fields[file] = fields
+	}
+	sort.Strings(explanation.Changed)
+
+	return explanation, nil
+}
+
+// setSentinel replaces the value at the path with a sentinel of the same type and returns the value.
+func setSentinel(values map[string]interface{}, keys []string) (interface{}, error) {
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := values[key].(map[string]interface{})
+		if !ok {
+			if values[key] != nil {
+				return nil, fmt.Errorf("value %s is not a map", key)
+			}
+			next = map[string]interface{}{}
+			values[key] = next
+		}
+		values = next
+	}
+
+	key := keys[len(keys)-1]
+	value := values[key]
+	switch v := value.(type) {
+	case nil, string:
+		values[key] = explainSentinel
+	case bool:
+		values[key] = !v
+	case int, int64, float64:
+		values[key] = 4242
+		if reflect.DeepEqual(v, 4242) || reflect.DeepEqual(v, int64(4242)) || reflect.DeepEqual(v, float64(4242)) {
+			values[key] = 4243
+		}
+	case []interface{}:
+		values[key] = []interface{}{explainSentinel}
+		if len(v) > 0 {
+			if _, ok := v[0].(map[string]interface{}); ok {
+				return nil, fmt.Errorf("value %s is a list of maps, explain the value of an item instead", strings.Join(keys, "."))
+			}
+		}
+	case map[string]interface{}:
+		children := make([]string, 0, len(v))
+		for child := range v {
+			children = append(children, strings.Join(keys, ".")+"."+child)
+		}
+		sort.Strings(children)
+		return nil, fmt.Errorf("value %s is a map, explain one of its keys: %s", strings.Join(keys, "."), strings.Join(children, ", "))
+	default:
+		return nil, fmt.Errorf("unsupported value %s of type %T", strings.Join(keys, "."), value)
+	}
+
+	return value, nil
+}
+
+// diffFields collects the paths of the fields which differ between the documents.
+func diffFields(prefix string, before, after interface{}, fields *[]string) {
+	beforeMap, beforeIsMap := before.(map[string]interface{})
+	afterMap, afterIsMap := after.(map[string]interface{})
+	if beforeIsMap && afterIsMap {
+		keys := map[string]bool{}
+		for key := range beforeMap {
+			keys[key] = true
+		}
+		for key := range afterMap {
+			keys[key] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for key := range keys {
+			sorted = append(sorted, key)
+		}
+		sort.Strings(sorted)
+
+		for _, key := range sorted {
+			field := key
+			if prefix != "" {
+				field = prefix + "." + key
+			}
+			diffFields(field, beforeMap[key], afterMap[key], fields)
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(before, after) {
+		*fields = append(*fields, prefix)
+	}
+}
+
+// valueReferences returns the lines of the templates of the chart and its dependencies
+// referencing the value or one of its parents, like .Values.bond for bond.mode.
+func valueReferences(chrt *chart.Chart, keys []string) []string {
+	// The longest path is matched first
+	var alternatives []string
+	for i := len(keys); i > 0; i-- {
+		alternatives = append(alternatives, regexp.QuoteMeta(strings.Join(keys[:i], ".")))
+	}
+	pattern := regexp.MustCompile(`\.Values\.(` + strings.Join(alternatives, "|") + `)\b`)
+
+	var references []string
+	var walk func(c *chart.Chart, prefix string)
+	walk = func(c *chart.Chart, prefix string) {
+		for _, template := range c.Templates {
+			for i, line := range strings.Split(string(template.Data), "\n") {
+				for _, match := range pattern.FindAllStringSubmatchIndex(line, -1) {
+					// A parent followed by another key references a sibling of the value
+					end := match[1]
+					if end < len(line) && line[end] == '.' && match[3]-match[2] < len(strings.Join(keys, ".")) {
+						continue
+					}
+					references = append(references, fmt.Sprintf("%s:%d", path.Join(prefix, template.Name), i+1))
+					break
+				}
+			}
+		}
+		for _, dependency := range c.Dependencies() {
+			walk(dependency, path.Join(prefix, "charts", dependency.Name()))
+		}
+	}
+	walk(chrt, "")
+
+	return references
+}
@@ -0,0 +1,63 @@
+package engine
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	opts := Options{Root: "../../charts/generic"}
+
+	explanation, err := Explain(opts, "endpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if explanation.Value != "https://192.168.100.10:6443" {
+		t.Errorf("unexpected value %v", explanation.Value)
+	}
+	for _, file := range []string{"templates/controlplane.yaml", "templates/worker.yaml"} {
+		if !contains(explanation.Fields[file], "cluster.controlPlane.endpoint") {
+			t.Errorf("expected cluster.controlPlane.endpoint in fields of %s, got %v", file, explanation.Fields[file])
+		}
+	}
+	if !reflect.DeepEqual(explanation.Changed, []string{"templates/NOTES.txt"}) {
+		t.Errorf("expected NOTES.txt to change, got %v", explanation.Changed)
+	}
+	if !hasPrefix(explanation.References, "templates/_helpers.tpl:") || !hasPrefix(explanation.References, "charts/talm/templates/_helpers.tpl:") {
+		t.Errorf("expected references in the helpers of the chart and the library, got %v", explanation.References)
+	}
+
+	explanation, err = Explain(opts, "bond.mode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if explanation.Value != nil || !contains(explanation.Fields["templates/worker.yaml"], "machine.network.interfaces") || len(explanation.References) == 0 {
+		t.Errorf("setting bond.mode must configure the bond interface, got %+v", explanation)
+	}
+
+	if _, err := Explain(opts, "podSubnets"); err != nil {
+		t.Errorf("expected list value to be explained, got %v", err)
+	}
+	if _, err := Explain(Options{Root: "../../charts/generic", JsonValues: []string{`{"bond":{"mode":"802.3ad"}}`}}, "bond"); err == nil || !strings.Contains(err.Error(), "bond.mode") {
+		t.Errorf("expected map error listing the keys, got %v", err)
+	}
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
+
+func hasPrefix(items []string, prefix string) bool {
+	for _, i := range items {
+		if strings.HasPrefix(i, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package engine
+
+import (
+	"context"
+	"sort"
+)
+
+// Hardware returns the size of the node exposed to templates as .Hardware: cpus is the number
+// of hardware threads of the processors and memoryMiB the size of the memory modules. Facts the
+// node doesn't report, e.g. the memory modules of some virtual machines, are left out, so are the
+// facts of nodes failing to list the hardware resources.
+func Hardware(ctx context.Context, node Node) map[string]interface{} {
+	hardware := map[string]interface{}{}
+
+	// Errors leave the facts out, the templates fall back to their defaults
+	processors, _ := node.Lookup(ctx, "cpus", "hardware", "")
+	cpus := 0
+	for _, spec := range listSpecs(processors) {
+		// Sockets without a processor report no cores
+		threads := specInt(spec, "threadCount")
+		if threads == 0 {
+			threads = specInt(spec, "coreCount")
+		}
+		cpus += threads
+	}
+	if cpus > 0 {
+		hardware["cpus"] = cpus
+	}
+
+	modules, _ := node.Lookup(ctx, "memorymodules", "hardware", "")
+	memory := 0
+	for _, spec := range listSpecs(modules) {
+		memory += specInt(spec, "sizeMiB")
+	}
+	if memory > 0 {
+		hardware["memoryMiB"] = memory
+	}
+
+	return hardware
+}
+
+// listSpecs returns the specs of the resources of a lookup of all resources of a kind.
+func listSpecs(list map[string]interface{}) []map[string]interface{} {
+	items, _ := list["items"].(map[string]interface{})
+	keys := make([]string, 0, len(items))
+	for key := range items {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	specs := make([]map[string]interface{}, 0, len(keys))
+	for _, key := range keys {
+		item, _ := items[key].(map[string]interface{})
+		if spec, ok := item["spec"].(map[string]interface{}); ok {
+			specs = append(specs, spec)
+		}
+	}
+	return specs
+}
+
+func specInt(spec map[string]interface{}, key string) int {
+	switch v := spec[key].(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case uint64:
+		return int(v)
+	case float64:
+		return int(v)
+	}
+	return 0
+}
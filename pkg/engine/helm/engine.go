@@ -18,25 +18,42 @@ package engine
 
 import (
 	"fmt"
+	"io"
 	"log"
+	"os"
 	"path"
 	"path/filepath"
 	"regexp"
 	"sort"
 	"strings"
+	"sync"
 	"text/template"
+	"time"
 
 	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
 
 	"helm.sh/helm/v3/pkg/chart"
 	"helm.sh/helm/v3/pkg/chartutil"
 )
 
 var Disks map[string]interface{} = map[string]interface{}{}
+
+// Hardware is the size of the node, exposed to templates as .Hardware.
+var Hardware map[string]interface{} = map[string]interface{}{}
 var LookupFunc func(resource string, namespace string, name string) (map[string]interface{}, error) = func(string, string, string) (map[string]interface{}, error) {
 	return map[string]interface{}{}, nil
 }
 
+// CheckManifestURLFunc validates the URL of a manifest fetched by the nodes and its sha256
+// checksum if set, offline it checks nothing.
+var CheckManifestURLFunc func(url, checksum string) error = func(string, string) error {
+	return nil
+}
+
+// WarningWriter receives the messages of the "warn" template function.
+var WarningWriter io.Writer = os.Stderr
+
 // Engine is an implementation of the Helm rendering implementation for templates.
 type Engine struct {
 	// If strict is enabled, template rendering will fail if a template references
@@ -46,6 +63,75 @@ type Engine struct {
 	LintMode bool
 	// EnableDNS tells the engine to allow DNS lookups when rendering templates
 	EnableDNS bool
+	// EnvAllowlist is a list of environment variable names (or path.Match
+	// patterns) which can be read by the "env" template function
+	EnvAllowlist []string
+	// ExtraFuncs are additional template functions, e.g. provided by plugins.
+	// They can't override the engine-specific functions like include or lookup.
+	ExtraFuncs template.FuncMap
+	// Profile, if set, accumulates the time spent in the templates and the named
+	// templates they include
+	Profile *Profile
+	// Deterministic fails the functions depending on the time or on randomness, like
+	// now or randAlphaNum, so the output only depends on the templates and the values
+	Deterministic bool
+	// SkipAssertions makes assertCIDR and assertSemver return the values unchecked
+	SkipAssertions bool
+}
+
+// Profile is the time spent rendering the templates and the named templates, by name.
+type Profile struct {
+	mu      sync.Mutex
+	entries map[string]*ProfileEntry
+}
+
+// ProfileEntry is the time spent in a template. Total includes the nested includes,
+// Self excludes them.
+type ProfileEntry struct {
+	Name  string
+	Calls int
+	Total time.Duration
+	Self  time.Duration
+}
+
+// NewProfile returns an empty profile.
+func NewProfile() *Profile {
+	return &Profile{entries: map[string]*ProfileEntry{}}
+}
+
+func (p *Profile) add(name string, total, self time.Duration) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	entry, ok := p.entries[name]
+	if !ok {
+		entry = &ProfileEntry{Name: name}
+		p.entries[name] = entry
+	}
+	entry.Calls++
+	entry.Total += total
+	entry.Self += self
+}
+
+// Entries returns the entries of the profile, the slowest first by self time.
+func (p *Profile) Entries() []ProfileEntry {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	entries := make([]ProfileEntry, 0, len(p.entries))
+	for _, entry := range p.entries {
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Self != entries[j].Self {
+			return entries[i].Self > entries[j].Self
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	return entries
 }
 
 // Render takes a chart, optional values, and value overrides, and attempts to render the Go templates.
@@ -86,6 +172,10 @@ type renderable struct {
 	vals chartutil.Values
 	// namespace prefix to the templates of the current chart
 	basePath string
+	// leftDelim and rightDelim are the template action delimiters of the
+	// current chart, empty values mean the default "{{" and "}}".
+	leftDelim  string
+	rightDelim string
 }
 
 const warnStartDelim = "HELM_ERR_START"
@@ -98,28 +188,93 @@ func warnWrap(warn string) string {
 	return warnStartDelim + warn + warnEndDelim
 }
 
+// includeState tracks the chain of nested includes of a render, to report recursive includes
+// and to profile the time spent in the named templates.
+type includeState struct {
+	counts  map[string]int
+	frames  []includeFrame
+	profile *Profile
+	// leftDelim and rightDelim are the delimiters of the chart of the template being
+	// rendered, 'tpl' parses its text with them.
+	leftDelim  string
+	rightDelim string
+}
+
+type includeFrame struct {
+	name   string
+	start  time.Time
+	nested time.Duration
+}
+
+func newIncludeState(profile *Profile) *includeState {
+	return &includeState{counts: map[string]int{}, profile: profile}
+}
+
+func (s *includeState) enter(name string) error {
+	s.counts[name]++
+	s.frames = append(s.frames, includeFrame{name: name, start: time.Now()})
+	if s.counts[name] > recursionMaxNums {
+		chain := make([]string, len(s.frames))
+		for i, frame := range s.frames {
+			chain[i] = frame.name
+		}
+		s.leave()
+		return &includeCycleError{chain: chain}
+	}
+	return nil
+}
+
+func (s *includeState) leave() {
+	frame := s.frames[len(s.frames)-1]
+	s.frames = s.frames[:len(s.frames)-1]
+	s.counts[frame.name]--
+
+	elapsed := time.Since(frame.start)
+	if len(s.frames) > 0 {
+		s.frames[len(s.frames)-1].nested += elapsed
+	}
+	s.profile.add(frame.name, elapsed, elapsed-frame.nested)
+}
+
+// includeCycleError reports includes nested more than recursionMaxNums times. The enclosing
+// includes return it as it is, so the error names the cycle once instead of every level.
+type includeCycleError struct {
+	chain []string
+}
+
+func (e *includeCycleError) Error() string {
+	name := e.chain[len(e.chain)-1]
+	cycle := e.chain
+	for i := len(e.chain) - 2; i >= 0; i-- {
+		if e.chain[i] == name {
+			cycle = e.chain[i:]
+			break
+		}
+	}
+	return fmt.Sprintf("template %q is included recursively more than %d times: %s", name, recursionMaxNums, strings.Join(cycle, " -> "))
+}
+
 // 'include' needs to be defined in the scope of a 'tpl' template as
 // well as regular file-loaded templates.
-func includeFun(t *template.Template, includedNames map[string]int) func(string, interface{}) (string, error) {
+func includeFun(t *template.Template, state *includeState) func(string, interface{}) (string, error) {
 	return func(name string, data interface{}) (string, error) {
 		var buf strings.Builder
-		if v, ok := includedNames[name]; ok {
-			if v > recursionMaxNums {
-				return "", errors.Wrapf(fmt.Errorf("unable to execute template"), "rendering template has a nested reference name: %s", name)
-			}
-			includedNames[name]++
-		} else {
-			includedNames[name] = 1
+		if err := state.enter(name); err != nil {
+			return "", err
 		}
 		err := t.ExecuteTemplate(&buf, name, data)
-		includedNames[name]--
+		state.leave()
+		var cycle *includeCycleError
+		if errors.As(err, &cycle) {
+			return "", cycle
+		}
 		return buf.String(), err
 	}
 }
 
 // As does 'tpl', so that nested calls to 'tpl' see the templates
 // defined by their enclosing contexts.
-func tplFun(parent *template.Template, includedNames map[string]int, strict bool) func(string, interface{}) (string, error) {
+func tplFun(parent *template.Template, state *includeState, strict bool) func(string, interface{}) (string, error) {
 	return func(tpl string, vals interface{}) (string, error) {
 		t, err := parent.Clone()
 		if err != nil {
@@ -138,8 +293,8 @@ func tplFun(parent *template.Template, includedNames map[string]int, strict bool
 		// Re-inject 'include' so that it can close over our clone of t;
 		// this lets any 'define's inside tpl be 'include'd.
 		t.Funcs(template.FuncMap{
-			"include": includeFun(t, includedNames),
-			"tpl":     tplFun(t, includedNames, strict),
+			"include": includeFun(t, state),
+			"tpl":     tplFun(t, state, strict),
 		})
 
 		// We need a .New template, as template text which is just blanks
@@ -148,7 +303,7 @@ func tplFun(parent *template.Template, includedNames map[string]int, strict bool
 		// https://pkg.go.dev/text/template#Template.Parse
 		// Use the parent's name for lack of a better way to identify the tpl
 		// text string. (Maybe we could use a hash appended to the name?)
-		t, err = t.New(parent.Name()).Parse(tpl)
+		t, err = t.New(parent.Name()).Delims(state.leftDelim, state.rightDelim).Parse(tpl)
 		if err != nil {
 			return "", errors.Wrapf(err, "cannot parse template %q", tpl)
 		}
@@ -164,13 +319,26 @@ func tplFun(parent *template.Template, includedNames map[string]int, strict bool
 }
 
 // initFunMap creates the Engine's FuncMap and adds context-specific functions.
-func (e Engine) initFunMap(t *template.Template) {
+func (e Engine) initFunMap(t *template.Template, state *includeState) {
 	funcMap := funcMap()
-	includedNames := make(map[string]int)
+
+	for k, v := range e.ExtraFuncs {
+		funcMap[k] = v
+	}
+
+	if e.Deterministic {
+		for _, name := range nondeterministicFuncs {
+			funcMap[name] = nondeterministicFunc(name)
+		}
+	}
+	if e.SkipAssertions {
+		funcMap["assertCIDR"] = func(_ string, value interface{}) interface{} { return value }
+		funcMap["assertSemver"] = func(_ string, args ...interface{}) interface{} { return args[len(args)-1] }
+	}
 
 	// Add the template-rendering functions here so we can close over t.
-	funcMap["include"] = includeFun(t, includedNames)
-	funcMap["tpl"] = tplFun(t, includedNames, e.Strict)
+	funcMap["include"] = includeFun(t, state)
+	funcMap["tpl"] = tplFun(t, state, e.Strict)
 
 	// Add the `required` function here so we can use lintMode
 	funcMap["required"] = func(warn string, val interface{}) (interface{}, error) {
@@ -204,10 +372,37 @@ func (e Engine) initFunMap(t *template.Template) {
 		return "", errors.New(warnWrap(msg))
 	}
 
+	// Report the message of "warn" once per render, templates often include the same helpers
+	warned := map[string]bool{}
+	funcMap["warn"] = func(msg string) string {
+		if e.LintMode {
+			log.Printf("[INFO] Warning: %s", msg)
+			return ""
+		}
+		if !warned[msg] {
+			warned[msg] = true
+			fmt.Fprintf(WarningWriter, "Warning: %s\n", msg)
+		}
+		return ""
+	}
+
+	// Provide the "env" function limited to the allowed environment variables
+	funcMap["env"] = func(name string) (string, error) {
+		for _, pattern := range e.EnvAllowlist {
+			if ok, _ := path.Match(pattern, name); ok {
+				return os.Getenv(name), nil
+			}
+		}
+		return "", errors.Errorf("environment variable %q is not allowed, add it to templateOptions.envAllowlist in Chart.yaml", name)
+	}
+
 	// If we are not linting and have a cluster connection, provide a Kubernetes-backed
 	// implementation.
 	if !e.LintMode {
 		funcMap["lookup"] = LookupFunc
+		funcMap["checkManifestURL"] = func(url, checksum string) (string, error) {
+			return "", CheckManifestURLFunc(url, checksum)
+		}
 	}
 
 	// When DNS lookups are not enabled override the sprig function and return
@@ -244,7 +439,8 @@ func (e Engine) render(tpls map[string]renderable) (rendered map[string]string,
 		t.Option("missingkey=zero")
 	}
 
-	e.initFunMap(t)
+	state := newIncludeState(e.Profile)
+	e.initFunMap(t, state)
 
 	// We want to parse the templates in a predictable order. The order favors
 	// higher-level (in file system) templates over deeply nested templates.
@@ -252,7 +448,7 @@ func (e Engine) render(tpls map[string]renderable) (rendered map[string]string,
 
 	for _, filename := range keys {
 		r := tpls[filename]
-		if _, err := t.New(filename).Parse(r.tpl); err != nil {
+		if _, err := t.New(filename).Delims(r.leftDelim, r.rightDelim).Parse(r.tpl); err != nil {
 			return map[string]string{}, cleanupParseError(filename, err)
 		}
 	}
@@ -268,7 +464,12 @@ func (e Engine) render(tpls map[string]renderable) (rendered map[string]string,
 		vals := tpls[filename].vals
 		vals["Template"] = chartutil.Values{"Name": filename, "BasePath": tpls[filename].basePath}
 		var buf strings.Builder
-		if err := t.ExecuteTemplate(&buf, filename, vals); err != nil {
+		state.leftDelim, state.rightDelim = tpls[filename].leftDelim, tpls[filename].rightDelim
+		// Template files are never included, entering them only profiles them
+		_ = state.enter(filename)
+		err := t.ExecuteTemplate(&buf, filename, vals)
+		state.leave()
+		if err != nil {
 			return map[string]string{}, cleanupExecError(filename, err)
 		}
 
@@ -367,9 +568,13 @@ func recAllTpls(c *chart.Chart, templates map[string]renderable, vals chartutil.
 		"Files":        newFiles(c.Files),
 		"Release":      vals["Release"],
 		"Capabilities": vals["Capabilities"],
+		"Command":      vals["Command"],
+		"Node":         vals["Node"],
+		"RenderTarget": vals["RenderTarget"],
 		"Values":       make(chartutil.Values),
 		"Subcharts":    subCharts,
 		"Disks":        Disks,
+		"Hardware":     Hardware,
 	}
 
 	// If there is a {{.Values.ThisChart}} in the parent metadata,
@@ -384,6 +589,7 @@ func recAllTpls(c *chart.Chart, templates map[string]renderable, vals chartutil.
 		subCharts[child.Name()] = recAllTpls(child, templates, next)
 	}
 
+	leftDelim, rightDelim := chartDelimiters(c)
 	newParentID := c.ChartFullPath()
 	for _, t := range c.Templates {
 		if t == nil {
@@ -393,15 +599,51 @@ func recAllTpls(c *chart.Chart, templates map[string]renderable, vals chartutil.
 			continue
 		}
 		templates[path.Join(newParentID, t.Name)] = renderable{
-			tpl:      string(t.Data),
-			vals:     next,
-			basePath: path.Join(newParentID, "templates"),
+			tpl:        string(t.Data),
+			vals:       next,
+			basePath:   path.Join(newParentID, "templates"),
+			leftDelim:  leftDelim,
+			rightDelim: rightDelim,
 		}
 	}
 
 	return next
 }
 
+// chartDelimiters returns the template delimiters configured for the chart in
+// Chart.yaml:
+//
+//	templateOptions:
+//	  delimiters: ["[[", "]]"]
+//
+// Delimiters apply only to the templates of that chart, so the charts using
+// different delimiters can still include each other's named templates.
+func chartDelimiters(c *chart.Chart) (string, string) {
+	for _, f := range c.Raw {
+		if f == nil || f.Name != chartutil.ChartfileName {
+			continue
+		}
+		var chartfile struct {
+			TemplateOptions struct {
+				Delimiters []string `json:"delimiters"`
+			} `json:"templateOptions"`
+		}
+		if err := yaml.Unmarshal(f.Data, &chartfile); err != nil {
+			return "", ""
+		}
+		delims := chartfile.TemplateOptions.Delimiters
+		if len(delims) == 0 {
+			return "", ""
+		}
+		if len(delims) != 2 || delims[0] == "" || delims[1] == "" {
+			log.Printf("[WARNING] Chart %s: templateOptions.delimiters must contain exactly two non-empty values, using defaults", c.Name())
+			return "", ""
+		}
+		return delims[0], delims[1]
+	}
+	return "", ""
+}
+
 // isTemplateValid returns true if the template is valid for the chart type
 func isTemplateValid(ch *chart.Chart, templateName string) bool {
 	if isLibraryChart(ch) {
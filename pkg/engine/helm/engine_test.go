@@ -18,18 +18,13 @@ package engine
 
 import (
 	"fmt"
+	"net"
 	"path"
 	"strings"
 	"sync"
 	"testing"
 	"text/template"
 
-	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/runtime/schema"
-	"k8s.io/client-go/dynamic"
-	"k8s.io/client-go/dynamic/fake"
-
 	"helm.sh/helm/v3/pkg/chart"
 	"helm.sh/helm/v3/pkg/chartutil"
 )
@@ -211,6 +206,10 @@ func TestRenderInternals(t *testing.T) {
 }
 
 func TestRenderWithDNS(t *testing.T) {
+	if _, err := net.LookupHost("helm.sh"); err != nil {
+		t.Skipf("DNS is not available: %s", err)
+	}
+
 	c := &chart.Chart{
 		Metadata: &chart.Metadata{
 			Name:    "moby",
@@ -246,100 +245,53 @@ func TestRenderWithDNS(t *testing.T) {
 	}
 }
 
-type kindProps struct {
-	shouldErr  error
-	gvr        schema.GroupVersionResource
-	namespaced bool
-}
-
-type testClientProvider struct {
-	t       *testing.T
-	scheme  map[string]kindProps
-	objects []runtime.Object
-}
-
-func (p *testClientProvider) GetClientFor(apiVersion, kind string) (dynamic.NamespaceableResourceInterface, bool, error) {
-	props := p.scheme[path.Join(apiVersion, kind)]
-	if props.shouldErr != nil {
-		return nil, false, props.shouldErr
-	}
-	return fake.NewSimpleDynamicClient(runtime.NewScheme(), p.objects...).Resource(props.gvr), props.namespaced, nil
-}
-
-var _ ClientProvider = &testClientProvider{}
+// testLookup replaces LookupFunc with a lookup of the resources, keyed by resource, namespace and id,
+// for the duration of the test.
+func testLookup(t *testing.T, resources map[string]map[string]interface{}, err error) {
+	lookup := LookupFunc
+	t.Cleanup(func() { LookupFunc = lookup })
 
-// makeUnstructured is a convenience function for single-line creation of Unstructured objects.
-func makeUnstructured(apiVersion, kind, name, namespace string) *unstructured.Unstructured {
-	ret := &unstructured.Unstructured{Object: map[string]interface{}{
-		"apiVersion": apiVersion,
-		"kind":       kind,
-		"metadata": map[string]interface{}{
-			"name": name,
-		},
-	}}
-	if namespace != "" {
-		ret.Object["metadata"].(map[string]interface{})["namespace"] = namespace
+	LookupFunc = func(resource, namespace, id string) (map[string]interface{}, error) {
+		if err != nil {
+			return nil, err
+		}
+		if obj, ok := resources[path.Join(resource, namespace, id)]; ok {
+			return obj, nil
+		}
+		return map[string]interface{}{}, nil
 	}
-	return ret
 }
 
-func TestRenderWithClientProvider(t *testing.T) {
-	provider := &testClientProvider{
-		t: t,
-		scheme: map[string]kindProps{
-			"v1/Namespace": {
-				gvr: schema.GroupVersionResource{
-					Version:  "v1",
-					Resource: "namespaces",
-				},
-			},
-			"v1/Pod": {
-				gvr: schema.GroupVersionResource{
-					Version:  "v1",
-					Resource: "pods",
-				},
-				namespaced: true,
-			},
+func TestRenderWithLookupFunc(t *testing.T) {
+	testLookup(t, map[string]map[string]interface{}{
+		"hostname": {"spec": map[string]interface{}{"hostname": "talos-abc"}},
+		"links/network/eth0": {
+			"spec": map[string]interface{}{"hardwareAddr": "00:11:22:33:44:55"},
 		},
-		objects: []runtime.Object{
-			makeUnstructured("v1", "Namespace", "default", ""),
-			makeUnstructured("v1", "Pod", "pod1", "default"),
-			makeUnstructured("v1", "Pod", "pod2", "ns1"),
-			makeUnstructured("v1", "Pod", "pod3", "ns1"),
+		"links/network": {
+			"items": []interface{}{map[string]interface{}{}, map[string]interface{}{}},
 		},
-	}
+	}, nil)
 
 	type testCase struct {
 		template string
 		output   string
 	}
 	cases := map[string]testCase{
-		"ns-single": {
-			template: `{{ (lookup "v1" "Namespace" "" "default").metadata.name }}`,
-			output:   "default",
-		},
-		"ns-list": {
-			template: `{{ (lookup "v1" "Namespace" "" "").items | len }}`,
-			output:   "1",
-		},
-		"ns-missing": {
-			template: `{{ (lookup "v1" "Namespace" "" "absent") }}`,
-			output:   "map[]",
+		"single": {
+			template: `{{ (lookup "hostname" "" "").spec.hostname }}`,
+			output:   "talos-abc",
 		},
-		"pod-single": {
-			template: `{{ (lookup "v1" "Pod" "default" "pod1").metadata.name }}`,
-			output:   "pod1",
+		"namespaced": {
+			template: `{{ (lookup "links" "network" "eth0").spec.hardwareAddr }}`,
+			output:   "00:11:22:33:44:55",
 		},
-		"pod-list": {
-			template: `{{ (lookup "v1" "Pod" "ns1" "").items | len }}`,
+		"list": {
+			template: `{{ (lookup "links" "network" "").items | len }}`,
 			output:   "2",
 		},
-		"pod-all": {
-			template: `{{ (lookup "v1" "Pod" "" "").items | len }}`,
-			output:   "3",
-		},
-		"pod-missing": {
-			template: `{{ (lookup "v1" "Pod" "" "ns2") }}`,
+		"missing": {
+			template: `{{ (lookup "links" "network" "absent") }}`,
 			output:   "map[]",
 		},
 	}
@@ -368,7 +320,7 @@ func TestRenderWithClientProvider(t *testing.T) {
 		t.Fatalf("Failed to coalesce values: %s", err)
 	}
 
-	out, err := RenderWithClientProvider(c, v, provider)
+	out, err := Render(c, v)
 	if err != nil {
 		t.Errorf("Failed to render templates: %s", err)
 	}
@@ -383,14 +335,16 @@ func TestRenderWithClientProvider(t *testing.T) {
 	}
 }
 
-func TestRenderWithClientProvider_error(t *testing.T) {
+func TestRenderWithLookupFunc_error(t *testing.T) {
+	testLookup(t, nil, fmt.Errorf("kaboom"))
+
 	c := &chart.Chart{
 		Metadata: &chart.Metadata{
 			Name:    "moby",
 			Version: "1.2.3",
 		},
 		Templates: []*chart.File{
-			{Name: "templates/error", Data: []byte(`{{ lookup "v1" "Error" "" "" }}`)},
+			{Name: "templates/error", Data: []byte(`{{ lookup "error" "" "" }}`)},
 		},
 		Values: map[string]interface{}{},
 	}
@@ -404,17 +358,9 @@ func TestRenderWithClientProvider_error(t *testing.T) {
 		t.Fatalf("Failed to coalesce values: %s", err)
 	}
 
-	provider := &testClientProvider{
-		t: t,
-		scheme: map[string]kindProps{
-			"v1/Error": {
-				shouldErr: fmt.Errorf("kaboom"),
-			},
-		},
-	}
-	_, err = RenderWithClientProvider(c, v, provider)
+	_, err = Render(c, v)
 	if err == nil || !strings.Contains(err.Error(), "kaboom") {
-		t.Errorf("Expected error from client provider when rendering, got %q", err)
+		t.Errorf("Expected error from lookup when rendering, got %q", err)
 	}
 }
 
@@ -801,6 +747,60 @@ func TestRenderBuiltinValues(t *testing.T) {
 
 }
 
+func TestRenderChartDelimiters(t *testing.T) {
+	for _, tt := range []struct {
+		chartfile string
+		template  string
+		expect    string
+	}{
+		{
+			chartfile: "name: cluster\ntemplateOptions:\n  delimiters: [\"[[\", \"]]\"]\n",
+			template:  `[[ include "talm.hostname" . ]] {{ .Values.name }}`,
+			expect:    "node-1 {{ .Values.name }}",
+		},
+		// tpl parses the values with the delimiters of the chart
+		{
+			chartfile: "name: cluster\ntemplateOptions:\n  delimiters: [\"[[\", \"]]\"]\n",
+			template:  `[[ tpl .Values.hostname . ]]`,
+			expect:    "node-1 {{ .Values.name }}",
+		},
+		// Invalid delimiters fall back to the defaults
+		{
+			chartfile: "name: cluster\ntemplateOptions:\n  delimiters: [\"[[\"]\n",
+			template:  `{{ include "talm.hostname" . }} [[ .Values.name ]]`,
+			expect:    "node-1 [[ .Values.name ]]",
+		},
+		{
+			chartfile: "name: cluster\n",
+			template:  `{{ include "talm.hostname" . }}`,
+			expect:    "node-1",
+		},
+	} {
+		c := &chart.Chart{
+			Metadata: &chart.Metadata{Name: "cluster"},
+			Raw:      []*chart.File{{Name: chartutil.ChartfileName, Data: []byte(tt.chartfile)}},
+			Templates: []*chart.File{
+				{Name: "templates/controlplane.yaml", Data: []byte(tt.template)},
+			},
+		}
+		// The library keeps the default delimiters
+		c.AddDependency(&chart.Chart{
+			Metadata: &chart.Metadata{Name: "talm", Type: "library"},
+			Templates: []*chart.File{
+				{Name: "templates/_helpers.tpl", Data: []byte(`{{- define "talm.hostname" }}{{ .Values.name }}{{ end }}`)},
+			},
+		})
+
+		out, err := Render(c, chartutil.Values{"Values": map[string]interface{}{"name": "node-1", "hostname": "[[ .Values.name ]] {{ .Values.name }}"}})
+		if err != nil {
+			t.Fatalf("%q: %v", tt.chartfile, err)
+		}
+		if got := out["cluster/templates/controlplane.yaml"]; got != tt.expect {
+			t.Errorf("%q: expected %q, got %q", tt.chartfile, tt.expect, got)
+		}
+	}
+}
+
 func TestAlterFuncMap_include(t *testing.T) {
 	c := &chart.Chart{
 		Metadata: &chart.Metadata{Name: "conrad"},
@@ -1010,11 +1010,11 @@ func TestRenderRecursionLimit(t *testing.T) {
 			"Name": "TestRelease",
 		},
 	}
-	expectErr := "rendering template has a nested reference name: recursion: unable to execute template"
+	expectErr := fmt.Sprintf("template \"recursion\" is included recursively more than %d times: recursion -> recursion", recursionMaxNums)
 
 	_, err := Render(c, v)
 	if err == nil || !strings.HasSuffix(err.Error(), expectErr) {
-		t.Errorf("Expected err with suffix: %s", expectErr)
+		t.Errorf("Expected err with suffix: %s, got %v", expectErr, err)
 	}
 
 	// calling the same function many times is ok
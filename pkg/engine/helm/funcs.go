@@ -18,12 +18,21 @@ package engine
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"sort"
 	"strings"
 	"text/template"
 
 	"github.com/BurntSushi/toml"
 	"github.com/Masterminds/sprig/v3"
+	"github.com/aenix-io/talm/pkg/schematic"
+	"github.com/aenix-io/talm/pkg/versionrange"
+	"github.com/blang/semver/v4"
 	"sigs.k8s.io/yaml"
 )
 
@@ -55,6 +64,24 @@ func funcMap() template.FuncMap {
 		"fromJson":      fromJSON,
 		"fromJsonArray": fromJSONArray,
 
+		// Installer image of the image factory for the schematic section of the values
+		"installerImage": installerImage,
+		"cidrContains":   cidrContains,
+
+		// Deterministic per-node values derived from e.g. the hostname or the MAC address
+		"stableHash":       stableHash,
+		"derivePort":       derivePort,
+		"deriveIPFromCIDR": deriveIPFromCIDR,
+
+		// Assertions of the contracts of the values, failing with the path of the value
+		"assertCIDR":   assertCIDR,
+		"assertSemver": assertSemver,
+
+		// The keys and values of maps in the order of the keys, so the output doesn't change
+		// from one render to the next
+		"keys":   sortedKeys,
+		"values": sortedValues,
+
 		// This is a placeholder for the "include" function, which is
 		// late-bound to a template. By declaring it here, we preserve the
 		// integrity of the linter.
@@ -66,6 +93,9 @@ func funcMap() template.FuncMap {
 		"lookup": func(string, string, string, string) (map[string]interface{}, error) {
 			return map[string]interface{}{}, nil
 		},
+		// Provide a placeholder for the "checkManifestURL" function, which checks the
+		// manifests only when rendering online.
+		"checkManifestURL": func(string, string) (string, error) { return "", nil },
 	}
 
 	for k, v := range extra {
@@ -75,6 +105,234 @@ func funcMap() template.FuncMap {
 	return f
 }
 
+// nondeterministicFuncs are the functions whose result depends on the time or on randomness,
+// they fail deterministic renders.
+var nondeterministicFuncs = []string{
+	"now", "ago",
+	"randAlphaNum", "randAlpha", "randAscii", "randNumeric", "randBytes", "randInt", "shuffle", "uuidv4",
+	"bcrypt", "htpasswd", "encryptAES",
+	"genPrivateKey", "genCA", "genCAWithKey", "genSelfSignedCert", "genSelfSignedCertWithKey", "genSignedCert", "genSignedCertWithKey",
+}
+
+func nondeterministicFunc(name string) func(...interface{}) (string, error) {
+	return func(...interface{}) (string, error) {
+		return "", fmt.Errorf("%s depends on the time or on randomness and the render must be deterministic, derive the value with stableHash or set it in the values", name)
+	}
+}
+
+// sortedKeys returns the keys of the maps, sorted, like the keys function of sprig.
+func sortedKeys(dicts ...map[string]interface{}) []string {
+	var keys []string
+	for _, dict := range dicts {
+		for key := range dict {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sortedValues returns the values of the map in the order of their keys.
+func sortedValues(dict map[string]interface{}) []interface{} {
+	values := make([]interface{}, 0, len(dict))
+	for _, key := range sortedKeys(dict) {
+		values = append(values, dict[key])
+	}
+	return values
+}
+
+// FuncMap returns the names of the builtin template functions, engine-specific
+// functions are included as placeholders.
+func FuncMap() template.FuncMap {
+	f := funcMap()
+	for _, name := range []string{"env", "fail", "warn"} {
+		f[name] = func() string { return "not implemented" }
+	}
+	return f
+}
+
+// installerImage returns the installer image built by the image factory with the extensions
+// and kernel args of the schematic section of the values, or an empty string without them.
+// Without schematic.version the Talos version of the image of the values is used.
+func installerImage(values map[string]interface{}) (string, error) {
+	s, err := schematic.FromValues(values)
+	if err != nil || s == nil {
+		return "", err
+	}
+	if image, ok := values["image"].(string); ok && s.Version == "" {
+		if tag := strings.LastIndex(image, ":"); tag > strings.LastIndex(image, "/") {
+			s.Version = image[tag+1:]
+		}
+	}
+	return s.InstallerImage()
+}
+
+// cidrContains reports whether the address, with or without a prefix length, is within the CIDR.
+func cidrContains(cidr, address string) bool {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return false
+	}
+	addr, err := netip.ParseAddr(strings.SplitN(address, "/", 2)[0])
+	if err != nil {
+		return false
+	}
+	return prefix.Contains(addr)
+}
+
+// assertCIDR fails the render unless the value, or every item of a list, is a CIDR like
+// 10.244.0.0/16, the path names the value in the error. It returns the value, e.g.
+// {{ .Values.podSubnets | assertCIDR "podSubnets" | toYaml }}. A missing value fails like required.
+func assertCIDR(path string, value interface{}) (interface{}, error) {
+	check := func(path string, item interface{}) error {
+		s, ok := item.(string)
+		switch {
+		case item == nil || ok && s == "":
+			return errors.New(warnWrap(fmt.Sprintf("%s is required, set a CIDR like 10.244.0.0/16", path)))
+		case !ok:
+			return errors.New(warnWrap(fmt.Sprintf("%s %v is not a CIDR like 10.244.0.0/16", path, item)))
+		}
+		if _, err := netip.ParsePrefix(s); err != nil {
+			return errors.New(warnWrap(fmt.Sprintf("%s %q is not a CIDR like 10.244.0.0/16", path, s)))
+		}
+		return nil
+	}
+
+	switch items := value.(type) {
+	case []interface{}:
+		for i, item := range items {
+			if err := check(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+				return nil, err
+			}
+		}
+	case []string:
+		for i, item := range items {
+			if err := check(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		if err := check(path, value); err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
+}
+
+// assertSemver fails the render unless the value is a semantic version like v1.30.0, within the
+// range if one is given before the value, e.g. {{ .Values.kubernetesVersion | assertSemver
+// "kubernetesVersion" ">=1.29" }}. The path names the value in the error, it returns the value.
+func assertSemver(path string, args ...interface{}) (interface{}, error) {
+	if len(args) == 0 || len(args) > 2 {
+		return nil, fmt.Errorf("assertSemver takes the path, an optional range and the value, got %d arguments", len(args)+1)
+	}
+	value := args[len(args)-1]
+
+	s, ok := value.(string)
+	switch {
+	case value == nil || ok && s == "":
+		return nil, errors.New(warnWrap(fmt.Sprintf("%s is required, set a version like v1.30.0", path)))
+	case !ok:
+		return nil, errors.New(warnWrap(fmt.Sprintf("%s %v is not a version like v1.30.0", path, value)))
+	}
+	version, err := semver.ParseTolerant(s)
+	if err != nil {
+		return nil, errors.New(warnWrap(fmt.Sprintf("%s %q is not a version like v1.30.0", path, s)))
+	}
+
+	if len(args) == 2 {
+		constraint := fmt.Sprint(args[0])
+		r, err := versionrange.Parse(constraint)
+		if err != nil {
+			return nil, err
+		}
+		if !r(version) {
+			return nil, errors.New(warnWrap(fmt.Sprintf("%s %s is outside the range %q", path, s, constraint)))
+		}
+	}
+	return value, nil
+}
+
+// stableHash returns a non-negative integer derived from the keys, the same in every talm
+// version and on every machine, e.g. {{ mod (stableHash .hostname) 100 }}.
+func stableHash(keys ...interface{}) int64 {
+	h := sha256.New()
+	for i, key := range keys {
+		if i > 0 {
+			h.Write([]byte{0})
+		}
+		fmt.Fprint(h, key)
+	}
+	return int64(binary.BigEndian.Uint64(h.Sum(nil)) >> 1)
+}
+
+// derivePort returns a port in the range of size ports from base derived from the keys, base and
+// size are integers or numbers of the values, e.g. {{ derivePort 30000 1000 .hostname }}.
+func derivePort(base, size interface{}, keys ...interface{}) (int, error) {
+	from, ok := wholeNumber(base)
+	count, ok2 := wholeNumber(size)
+	if !ok || !ok2 || from < 1 || count < 1 || from+count-1 > 65535 {
+		return 0, fmt.Errorf("derivePort: invalid range of %v ports from %v", size, base)
+	}
+	return int(from + stableHash(keys...)%count), nil
+}
+
+// wholeNumber converts the integers of templates and the whole numbers decoded from the values.
+func wholeNumber(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case int:
+		return int64(v), true
+	case int64:
+		return v, true
+	case float64:
+		return int64(v), v == float64(int64(v))
+	}
+	return 0, false
+}
+
+// deriveIPFromCIDR returns an address of the CIDR derived from the keys. The network and broadcast
+// addresses of IPv4 subnets and the subnet-router anycast address of IPv6 subnets are never returned.
+// Different keys can derive the same address, the smaller the subnet the likelier.
+func deriveIPFromCIDR(cidr string, keys ...interface{}) (string, error) {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return "", fmt.Errorf("deriveIPFromCIDR: %w", err)
+	}
+	prefix = prefix.Masked()
+	addr := prefix.Addr()
+
+	// Offsets are limited to 63 bits, larger IPv6 subnets use their lower part
+	bits := addr.BitLen() - prefix.Bits()
+	if bits > 63 {
+		bits = 63
+	}
+	size := uint64(1) << bits
+	hash := uint64(stableHash(keys...))
+
+	var offset uint64
+	switch {
+	case bits == 0:
+		return addr.String(), nil
+	case addr.Is4() && bits == 1:
+		// Both addresses of a point-to-point link are usable (RFC 3021)
+		offset = hash % size
+	case addr.Is4():
+		offset = 1 + hash%(size-2)
+	default:
+		offset = 1 + hash%(size-1)
+	}
+
+	// The host bits of the masked address are zero, so the offset is added without carry
+	if addr.Is4() {
+		b := addr.As4()
+		binary.BigEndian.PutUint32(b[:], binary.BigEndian.Uint32(b[:])+uint32(offset))
+		return netip.AddrFrom4(b).String(), nil
+	}
+	b := addr.As16()
+	binary.BigEndian.PutUint64(b[8:], binary.BigEndian.Uint64(b[8:])+offset)
+	return netip.AddrFrom16(b).String(), nil
+}
+
 // toYAML takes an interface, marshals it to yaml, and returns a string. It will
 // always return a string, even on marshal error (empty string).
 //
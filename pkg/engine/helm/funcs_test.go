@@ -25,7 +25,6 @@ import (
 )
 
 func TestFuncs(t *testing.T) {
-	//TODO write tests for failure cases
 	tests := []struct {
 		tpl, expect string
 		vars        interface{}
@@ -94,6 +93,38 @@ func TestFuncs(t *testing.T) {
 		tpl:    `{{ fromYamlArray . }}`,
 		expect: `[error unmarshaling JSON: while decoding JSON: json: cannot unmarshal object into Go value of type []interface {}]`,
 		vars:   `hello: world`,
+	}, {
+		tpl:    `{{ cidrContains "192.168.100.0/24" "192.168.100.10" }} {{ cidrContains "192.168.100.0/24" "192.168.100.10/32" }} {{ cidrContains "192.168.100.0/24" "10.0.0.1" }}`,
+		expect: `true true false`,
+	}, {
+		tpl:    `{{ cidrContains "fd00::/64" "fd00::10" }} {{ cidrContains "invalid" "fd00::10" }}`,
+		expect: `true false`,
+	}, {
+		tpl:    `{{ stableHash "node1" }} {{ stableHash "node1" "9c:6b:00:47:06:6c" }} {{ mod (stableHash "node1") 100 }}`,
+		expect: `7280483922446626708 1680109122195824153 8`,
+	}, {
+		tpl:    `{{ derivePort 30000 1000 "node1" }} {{ derivePort 30000 1000 "node2" }} {{ derivePort .base 10 "node1" }}`,
+		expect: `30708 30130 40008`,
+		vars:   map[string]interface{}{"base": float64(40000)},
+	}, {
+		tpl:    `{{ deriveIPFromCIDR "10.0.0.0/24" "node1" }} {{ deriveIPFromCIDR "10.0.0.0/31" "node1" }} {{ deriveIPFromCIDR "10.0.0.5/32" "node1" }}`,
+		expect: `10.0.0.251 10.0.0.0 10.0.0.5`,
+	}, {
+		tpl:    `{{ deriveIPFromCIDR "fd00::/64" "node1" }} {{ deriveIPFromCIDR "fd00::/120" "node1" }}`,
+		expect: `fd00::6509:798d:c65f:af95 fd00::e0`,
+	}, {
+		tpl:    `{{ stableHash }} {{ stableHash "a" "b" }} {{ stableHash "ab" }} {{ stableHash 1 }} {{ stableHash "1" }}`,
+		expect: `8203414616412130826 3231676703916841193 9063230908846710674 3874038210105081456 3874038210105081456`,
+	}, {
+		tpl:    `{{ derivePort 65535 1 "node1" }} {{ derivePort 1 1 "node1" }}`,
+		expect: `65535 1`,
+	}, {
+		tpl:    `{{ .cidrs | assertCIDR "podSubnets" | toYaml }} {{ assertCIDR "serviceSubnet" "fd00::/108" }}`,
+		expect: "- 10.244.0.0/16\n- fd00:10:244::/56 fd00::/108",
+		vars:   map[string]interface{}{"cidrs": []interface{}{"10.244.0.0/16", "fd00:10:244::/56"}},
+	}, {
+		tpl:    `{{ assertSemver "kubernetesVersion" "v1.30.0" }} {{ "1.30.1" | assertSemver "kubernetesVersion" ">=1.29" }}`,
+		expect: `v1.30.0 1.30.1`,
 	}, {
 		// This should never result in a network lookup. Regression for #7955
 		tpl:    `{{ lookup "v1" "Namespace" "" "unlikelynamespace99999999" }}`,
@@ -109,6 +140,66 @@ func TestFuncs(t *testing.T) {
 	}
 }
 
+func TestFuncErrors(t *testing.T) {
+	tests := []struct {
+		tpl, expect string
+		vars        interface{}
+	}{{
+		tpl:    `{{ derivePort 0 1000 "node1" }}`,
+		expect: `derivePort: invalid range of 1000 ports from 0`,
+	}, {
+		tpl:    `{{ derivePort 30000 0 "node1" }}`,
+		expect: `derivePort: invalid range of 0 ports from 30000`,
+	}, {
+		tpl:    `{{ derivePort 65000 1000 "node1" }}`,
+		expect: `derivePort: invalid range of 1000 ports from 65000`,
+	}, {
+		tpl:    `{{ derivePort .base 10 "node1" }}`,
+		expect: `derivePort: invalid range of 10 ports from 30000.5`,
+		vars:   map[string]interface{}{"base": 30000.5},
+	}, {
+		tpl:    `{{ derivePort "30000" 10 "node1" }}`,
+		expect: `derivePort: invalid range of 10 ports from 30000`,
+	}, {
+		tpl:    `{{ deriveIPFromCIDR "10.0.0.0" "node1" }}`,
+		expect: `deriveIPFromCIDR: netip.ParsePrefix("10.0.0.0"): no '/'`,
+	}, {
+		tpl:    `{{ deriveIPFromCIDR "10.0.0.0/33" "node1" }}`,
+		expect: `deriveIPFromCIDR: netip.ParsePrefix("10.0.0.0/33"): prefix length out of range`,
+	}, {
+		tpl:    `{{ assertCIDR "podSubnets" .missing }}`,
+		expect: `podSubnets is required, set a CIDR like 10.244.0.0/16`,
+		vars:   map[string]interface{}{},
+	}, {
+		tpl:    `{{ .cidrs | assertCIDR "podSubnets" }}`,
+		expect: `podSubnets[1] "10.244.0.0" is not a CIDR like 10.244.0.0/16`,
+		vars:   map[string]interface{}{"cidrs": []interface{}{"10.0.0.0/8", "10.244.0.0"}},
+	}, {
+		tpl:    `{{ assertCIDR "podSubnets" 16 }}`,
+		expect: `podSubnets 16 is not a CIDR like 10.244.0.0/16`,
+	}, {
+		tpl:    `{{ assertSemver "kubernetesVersion" "" }}`,
+		expect: `kubernetesVersion is required, set a version like v1.30.0`,
+	}, {
+		tpl:    `{{ assertSemver "kubernetesVersion" "latest" }}`,
+		expect: `kubernetesVersion "latest" is not a version like v1.30.0`,
+	}, {
+		tpl:    `{{ "v1.28.3" | assertSemver "kubernetesVersion" ">=1.29" }}`,
+		expect: `kubernetesVersion v1.28.3 is outside the range ">=1.29"`,
+	}, {
+		tpl:    `{{ assertSemver "kubernetesVersion" }}`,
+		expect: `assertSemver takes the path, an optional range and the value, got 1 arguments`,
+	}}
+
+	for _, tt := range tests {
+		var b strings.Builder
+		err := template.Must(template.New("test").Funcs(funcMap()).Parse(tt.tpl)).Execute(&b, tt.vars)
+		if assert.Error(t, err, tt.tpl) {
+			assert.Contains(t, err.Error(), tt.expect, tt.tpl)
+		}
+	}
+}
+
 // This test to check a function provided by sprig is due to a change in a
 // dependency of sprig. mergo in v0.3.9 changed the way it merges and only does
 // public fields (i.e. those starting with a capital letter). This test, from
@@ -0,0 +1,79 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aenix-io/talm/pkg/generated"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+)
+
+// LoadChart loads the chart from dir. If the chart extends a compiled-in preset,
+// the preset files are used as a base layer and the local files override
+// the preset files with the same path.
+func LoadChart(dir string, extends string) (*chart.Chart, error) {
+	local, err := loader.LoadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	if extends == "" {
+		return local, nil
+	}
+
+	files, err := presetFiles(extends)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range local.Raw {
+		files[f.Name] = f.Data
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buffered := make([]*loader.BufferedFile, 0, len(names))
+	for _, name := range names {
+		buffered = append(buffered, &loader.BufferedFile{Name: name, Data: files[name]})
+	}
+
+	return loader.LoadFiles(buffered)
+}
+
+// presetFiles returns the files of the preset together with the talm library chart,
+// with paths relative to the chart root.
+func presetFiles(preset string) (map[string][]byte, error) {
+	found := false
+	for _, p := range generated.AvailablePresets {
+		if p == preset {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("unknown preset %q to extend, valid presets are: %s", preset, generated.AvailablePresets)
+	}
+
+	files := map[string][]byte{}
+	for path, content := range generated.PresetFiles {
+		name, rel, _ := strings.Cut(path, "/")
+
+		switch {
+		case name == preset && rel == "Chart.yaml":
+			files[rel] = []byte(fmt.Sprintf(content, preset, "0.1.0"))
+		case name == preset:
+			files[rel] = []byte(content)
+		case name == "talm" && rel == "Chart.yaml":
+			files["charts/talm/"+rel] = []byte(fmt.Sprintf(content, "talm", "0.1.0"))
+		case name == "talm":
+			files["charts/talm/"+rel] = []byte(content)
+		}
+	}
+
+	return files, nil
+}
@@ -0,0 +1,54 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadChartExtends(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"Chart.yaml":            "apiVersion: v2\nname: local\nversion: 0.2.0\n",
+		"values.yaml":           "endpoint: https://10.0.0.1:6443\n",
+		"templates/worker.yaml": "# local worker\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	chrt, err := LoadChart(dir, "generic")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if chrt.Name() != "local" {
+		t.Errorf("expected local Chart.yaml to override the preset one, got name %q", chrt.Name())
+	}
+	if chrt.Values["endpoint"] != "https://10.0.0.1:6443" {
+		t.Errorf("expected local values, got %v", chrt.Values["endpoint"])
+	}
+
+	templates := map[string]string{}
+	for _, tpl := range chrt.Templates {
+		templates[tpl.Name] = string(tpl.Data)
+	}
+	if templates["templates/worker.yaml"] != "# local worker\n" {
+		t.Errorf("expected local worker template, got %q", templates["templates/worker.yaml"])
+	}
+	if _, ok := templates["templates/controlplane.yaml"]; !ok {
+		t.Errorf("expected controlplane template inherited from the preset")
+	}
+	if len(chrt.Dependencies()) != 1 || chrt.Dependencies()[0].Name() != "talm" {
+		t.Errorf("expected talm library chart inherited from the preset")
+	}
+
+	if _, err := LoadChart(dir, "unknown"); err == nil {
+		t.Errorf("expected error for unknown preset")
+	}
+}
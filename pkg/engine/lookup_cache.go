@@ -0,0 +1,39 @@
+package engine
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/mitchellh/copystructure"
+)
+
+type lookupFunc = func(resource string, namespace string, id string) (map[string]interface{}, error)
+
+// newCachedLookupFunction memoizes the results of lookup for the duration of a single render,
+// so helpers querying the same resource repeatedly hit the node only once.
+//
+// Every call returns a copy of the cached result, templates are free to modify it.
+func newCachedLookupFunction(lookup lookupFunc) lookupFunc {
+	var mu sync.Mutex
+	cache := map[string]map[string]interface{}{}
+
+	return func(resource string, namespace string, id string) (map[string]interface{}, error) {
+		key := strings.Join([]string{resource, namespace, id}, "\x00")
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		res, ok := cache[key]
+		if !ok {
+			var err error
+			res, err = lookup(resource, namespace, id)
+			if err != nil {
+				// Errors are not cached, they are usually transient
+				return res, err
+			}
+			cache[key] = res
+		}
+
+		return copystructure.Must(copystructure.Copy(res)).(map[string]interface{}), nil
+	}
+}
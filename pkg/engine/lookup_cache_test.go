@@ -0,0 +1,53 @@
+package engine
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCachedLookupFunction(t *testing.T) {
+	calls := 0
+	fail := true
+	lookup := newCachedLookupFunction(func(resource, namespace, id string) (map[string]interface{}, error) {
+		calls++
+		if resource == "routes" && fail {
+			fail = false
+			return nil, errors.New("unavailable")
+		}
+		return map[string]interface{}{"kind": resource, "spec": map[string]interface{}{"id": id}}, nil
+	})
+
+	for i := 0; i < 3; i++ {
+		res, err := lookup("links", "network", "eth0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Modifications made by templates must not leak into the cache
+		res["spec"].(map[string]interface{})["id"] = "changed"
+	}
+	if calls != 1 {
+		t.Errorf("expected 1 call, got %d", calls)
+	}
+
+	res, _ := lookup("links", "network", "eth0")
+	if id := res["spec"].(map[string]interface{})["id"]; id != "eth0" {
+		t.Errorf("cached result was modified: %v", id)
+	}
+
+	if _, err := lookup("links", "network", "eth1"); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 calls, got %d", calls)
+	}
+
+	if _, err := lookup("routes", "network", ""); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := lookup("routes", "network", ""); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 4 {
+		t.Errorf("expected errors not to be cached, got %d calls", calls)
+	}
+}
@@ -0,0 +1,70 @@
+package engine
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// manifestHTTPClient checks the manifest URLs, the nodes fetch them on bootstrap.
+var manifestHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// manifestChecks memoizes the results of the checks for the renders of a command,
+// node files of the same cluster mostly share the manifests.
+var manifestChecks sync.Map
+
+type manifestCheck struct {
+	err error
+}
+
+// checkManifestURL ensures the manifest is reachable with a HEAD request, or with the checksum
+// that its content has the sha256 checksum.
+func checkManifestURL(ctx context.Context, url, checksum string) error {
+	key := url + "\x00" + strings.ToLower(checksum)
+	if result, ok := manifestChecks.Load(key); ok {
+		return result.(manifestCheck).err
+	}
+
+	err := fetchManifest(ctx, url, checksum)
+	manifestChecks.Store(key, manifestCheck{err: err})
+	return err
+}
+
+func fetchManifest(ctx context.Context, url, checksum string) error {
+	method := http.MethodHead
+	if checksum != "" {
+		method = http.MethodGet
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return fmt.Errorf("invalid manifest URL %s: %w", url, err)
+	}
+	resp, err := manifestHTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("manifest %s is not reachable: %w", url, err)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("manifest %s is not reachable: %s", url, resp.Status)
+	}
+	if checksum == "" {
+		return nil
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, resp.Body); err != nil {
+		return fmt.Errorf("error reading manifest %s: %w", url, err)
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); !strings.EqualFold(sum, checksum) {
+		return fmt.Errorf("manifest %s has the sha256 checksum %s, expected %s", url, sum, checksum)
+	}
+	return nil
+}
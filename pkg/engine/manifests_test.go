@@ -0,0 +1,84 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aenix-io/talm/pkg/enginetest"
+)
+
+func TestRenderManifests(t *testing.T) {
+	const manifest = "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: ccm\n"
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/ccm.yaml" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(manifest)) //nolint:errcheck
+	}))
+	defer server.Close()
+
+	render := func(node Node, values string) (string, error) {
+		var buf bytes.Buffer
+		err := RenderNode(context.Background(), node, Options{
+			Root:              "../../charts/generic",
+			KubernetesVersion: "v1.30.0",
+			TemplateFiles:     []string{"templates/controlplane.yaml"},
+			JsonValues:        []string{values},
+		}, &buf)
+		return buf.String(), err
+	}
+
+	// sha256 of the manifest
+	const checksum = "fc1347adfbec00906284937ad0cd232458cb086a3c3db1fff54e7a7400f83c62"
+	values := `{"manifests":{"extra":["` + server.URL + `/ccm.yaml",{"url":"` + server.URL + `/ccm.yaml","sha256":"` + checksum + `"}],` +
+		`"inline":[{"name":"ns","contents":"apiVersion: v1\nkind: Namespace\nmetadata:\n  name: test\n"}],` +
+		`"staticPods":[{"metadata":{"name":"nginx"},"spec":{"containers":[{"name":"nginx","image":"nginx"}]}}]}}`
+	out, err := render(enginetest.NewNode(), values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"extraManifests:\n    - " + server.URL + "/ccm.yaml\n",
+		"inlineManifests:\n    - name: ns\n",
+		"pods:\n    - apiVersion: v1\n      kind: Pod\n",
+		"name: nginx\n",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in output:\n%s", expected, out)
+		}
+	}
+	if requests != 2 {
+		t.Errorf("expected a HEAD and a GET request, got %d requests", requests)
+	}
+
+	// Unreachable URLs and changed contents fail the render, offline nothing is checked
+	for _, failing := range []string{
+		`{"manifests":{"extra":["` + server.URL + `/missing.yaml"]}}`,
+		`{"manifests":{"extra":[{"url":"` + server.URL + `/ccm.yaml","sha256":"` + strings.Repeat("0", 64) + `"}]}}`,
+	} {
+		if _, err := render(enginetest.NewNode(), failing); err == nil {
+			t.Errorf("expected an error for %s", failing)
+		}
+		if _, err := render(nil, failing); err != nil {
+			t.Errorf("expected no check offline, got %v", err)
+		}
+	}
+
+	for _, invalid := range []string{
+		`{"manifests":{"extra":["ftp://example.com/ccm.yaml"]}}`,
+		`{"manifests":{"inline":[{"name":"ns"}]}}`,
+		`{"manifests":{"inline":[{"name":"ns","contents":"a"},{"name":"ns","contents":"b"}]}}`,
+		`{"manifests":{"staticPods":[{"metadata":{"name":"nginx"}}]}}`,
+	} {
+		if _, err := render(nil, invalid); err == nil {
+			t.Errorf("expected an error for %s", invalid)
+		}
+	}
+}
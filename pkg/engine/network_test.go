@@ -0,0 +1,419 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	helmEngine "github.com/aenix-io/talm/pkg/engine/helm"
+	"github.com/aenix-io/talm/pkg/enginetest"
+)
+
+func TestRenderBondDiscovery(t *testing.T) {
+	node := enginetest.NewNode().
+		WithResources("links",
+			enginetest.Link("eth0", "aa:bb:cc:00:00:01", "ixgbe", "0000:01:00.0"),
+			enginetest.Link("eth1", "aa:bb:cc:00:00:02", "ixgbe", "0000:01:00.1"),
+			enginetest.Link("eth2", "aa:bb:cc:00:00:03", "e1000e", "0000:02:00.0"),
+		).
+		WithResources("routes", enginetest.DefaultRoute("eth0", "10.0.0.1")).
+		WithResources("addresses", enginetest.Address("eth0", "10.0.0.5/24"))
+
+	opts := Options{
+		Root:              "../../charts/generic",
+		KubernetesVersion: "v1.30.0",
+		TemplateFiles:     []string{"templates/worker.yaml"},
+		Values:            []string{"bond.mode=802.3ad"},
+		JsonValues:        []string{`{"vlans":[{"vlanId":100,"addresses":["10.100.0.5/24"]}]}`},
+	}
+
+	var buf bytes.Buffer
+	if err := RenderNode(context.Background(), node, opts, &buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	for _, expected := range []string{"interface: bond0", `busPath: "0000:01:00.0"`, `busPath: "0000:01:00.1"`, "10.0.0.5/24", "gateway: 10.0.0.1", "vlanId: 100"} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in output:\n%s", expected, out)
+		}
+	}
+	if strings.Contains(out, `busPath: "0000:02:00.0"`) {
+		t.Errorf("link with another driver must not be a bond member:\n%s", out)
+	}
+
+	opts.Values = []string{"bond.lacpRate=medium"}
+	if err := RenderNode(context.Background(), node, opts, &buf); err == nil {
+		t.Errorf("expected schema validation error")
+	}
+}
+
+func TestRenderCertSANs(t *testing.T) {
+	node := enginetest.NewNode().
+		WithResources("nodeaddress", enginetest.NodeAddress("10.0.0.5/24", "10.0.0.10/32", "fd00::5/64"))
+
+	opts := Options{
+		Root:              "../../charts/generic",
+		KubernetesVersion: "v1.30.0",
+		TemplateFiles:     []string{"templates/controlplane.yaml"},
+		Values:            []string{"endpoint=https://api.example.com:6443", "floatingIP=10.0.0.10", "advertisedSubnets={10.0.0.0/24}"},
+		JsonValues:        []string{`{"certSANs":["10.0.0.5","k8s.example.com"]}`},
+	}
+
+	var buf bytes.Buffer
+	if err := RenderNode(context.Background(), node, opts, &buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	expected := "certSANs:\n    - 127.0.0.1\n    - api.example.com\n    - 10.0.0.10\n    - 10.0.0.5\n    - fd00::5\n    - k8s.example.com\n"
+	if !strings.Contains(out, expected) {
+		t.Errorf("expected deduplicated certSANs %q in output:\n%s", expected, out)
+	}
+	if !strings.Contains(out, "apiServer:\n    certSANs:") {
+		t.Errorf("expected apiServer certSANs in output:\n%s", out)
+	}
+}
+
+func TestRenderSchematicInstallerImage(t *testing.T) {
+	opts := Options{
+		Root:              "../../charts/generic",
+		KubernetesVersion: "v1.30.0",
+		TemplateFiles:     []string{"templates/worker.yaml"},
+		JsonValues:        []string{`{"schematic":{"version":"v1.7.1","extensions":["siderolabs/qemu-guest-agent"]}}`},
+	}
+
+	var buf bytes.Buffer
+	if err := RenderNode(context.Background(), enginetest.NewNode(), opts, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "image: factory.talos.dev/installer/ce4c980550dd2ab1b17bbf2b08801c7eb59418eafe8f279833297925d67c7515:v1.7.1"
+	if !strings.Contains(buf.String(), expected) {
+		t.Errorf("expected %q in output:\n%s", expected, buf.String())
+	}
+}
+
+func TestRenderVIPs(t *testing.T) {
+	node := enginetest.NewNode().
+		WithResources("links", enginetest.Link("eth0", "aa:bb:cc:00:00:01", "ixgbe", "0000:01:00.0")).
+		WithResources("routes", enginetest.DefaultRoute("eth0", "10.0.0.1")).
+		WithResources("addresses", enginetest.Address("eth0", "10.0.0.5/24"), enginetest.Address("eth0", "10.0.0.10/32"))
+
+	opts := Options{
+		Root:              "../../charts/generic",
+		KubernetesVersion: "v1.30.0",
+		TemplateFiles:     []string{"templates/controlplane.yaml"},
+		Values:            []string{"floatingIP=10.0.0.10", "advertisedSubnets={10.0.0.0/24,10.100.0.0/24}"},
+		JsonValues: []string{`{"vlans":[{"vlanId":100,"addresses":["10.100.0.5/24"]}],` +
+			`"vips":[{"ip":"10.100.0.10","vlanId":100},{"ip":"10.0.0.11","interface":"eth1"}]}`},
+	}
+
+	var buf bytes.Buffer
+	if err := RenderNode(context.Background(), node, opts, &buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	for _, expected := range []string{
+		"addresses:\n          - 10.0.0.5/24\n",
+		"vlanId: 100\n            vip:\n              ip: 10.100.0.10\n",
+		"        vip:\n          ip: 10.0.0.10\n",
+		"- interface: eth1\n        vip:\n          ip: 10.0.0.11\n",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in output:\n%s", expected, out)
+		}
+	}
+
+	for name, vips := range map[string]string{
+		"outside advertisedSubnets": `{"vips":[{"ip":"192.168.1.10","interface":"eth1"}]}`,
+		"same link":                 `{"vips":[{"ip":"10.0.0.11"}]}`,
+		"same VIP":                  `{"vips":[{"ip":"10.0.0.10","interface":"eth1"}]}`,
+		"unknown VLAN":              `{"vips":[{"ip":"10.0.0.11","vlanId":200}]}`,
+	} {
+		opts.JsonValues = []string{vips}
+		if err := RenderNode(context.Background(), node, opts, &buf); err == nil {
+			t.Errorf("%s: expected a VIP conflict error", name)
+		}
+	}
+}
+
+func TestRenderCNI(t *testing.T) {
+	var warnings bytes.Buffer
+	helmEngine.WarningWriter = &warnings
+	defer func() { helmEngine.WarningWriter = os.Stderr }()
+
+	render := func(values string) (string, error) {
+		warnings.Reset()
+		var buf bytes.Buffer
+		err := RenderNode(context.Background(), enginetest.NewNode(), Options{
+			Root:              "../../charts/generic",
+			KubernetesVersion: "v1.30.0",
+			TemplateFiles:     []string{"templates/controlplane.yaml"},
+			JsonValues:        []string{values},
+		}, &buf)
+		return buf.String(), err
+	}
+
+	for _, tc := range []struct {
+		values   string
+		expected []string
+		absent   []string
+		warning  string
+	}{
+		{values: `{}`, absent: []string{"cni:", "proxy:"}},
+		{
+			values:   `{"cni":{"name":"cilium"}}`,
+			expected: []string{"cni:\n      name: none\n", "proxy:\n    disabled: true\n"},
+			warning:  "cni.name is cilium but cni.urls is empty",
+		},
+		{
+			values:   `{"cni":{"name":"cilium","kubeProxy":true,"external":true}}`,
+			expected: []string{"cni:\n      name: none\n"},
+			absent:   []string{"proxy:"},
+		},
+		{
+			values:   `{"cni":{"name":"calico","urls":["https://example.com/calico.yaml"]}}`,
+			expected: []string{"cni:\n      name: custom\n      urls:\n        - https://example.com/calico.yaml\n"},
+			absent:   []string{"proxy:"},
+		},
+		{values: `{"cni":{"name":"none"}}`, warning: "cni.name is none and no CNI is configured"},
+		{values: `{"cni":{"kubeProxy":false}}`, expected: []string{"proxy:\n    disabled: true\n"}, warning: "flannel relies on kube-proxy"},
+	} {
+		out, err := render(tc.values)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.values, err)
+		}
+		for _, expected := range tc.expected {
+			if !strings.Contains(out, expected) {
+				t.Errorf("%s: expected %q in output:\n%s", tc.values, expected, out)
+			}
+		}
+		for _, absent := range tc.absent {
+			if strings.Contains(out, absent) {
+				t.Errorf("%s: unexpected %q in output:\n%s", tc.values, absent, out)
+			}
+		}
+		if tc.warning == "" && warnings.Len() > 0 || !strings.Contains(warnings.String(), tc.warning) || strings.Count(warnings.String(), "Warning:") > 1 {
+			t.Errorf("%s: expected warning %q once, got %q", tc.values, tc.warning, warnings.String())
+		}
+	}
+
+	if _, err := render(`{"cni":{"name":"custom"}}`); err == nil || !strings.Contains(err.Error(), "cni.urls is empty") {
+		t.Errorf("expected custom CNI without urls to fail, got %v", err)
+	}
+}
+
+func TestRenderNetworkChecks(t *testing.T) {
+	var warnings bytes.Buffer
+	helmEngine.WarningWriter = &warnings
+	defer func() { helmEngine.WarningWriter = os.Stderr }()
+
+	node := func(gateway string, addresses ...string) *enginetest.Node {
+		n := enginetest.NewNode().
+			WithResources("routes", enginetest.DefaultRoute("eth0", gateway)).
+			WithResources("nodeaddress", enginetest.NodeAddress(addresses...))
+		for _, address := range addresses {
+			n.WithResources("addresses", enginetest.Address("eth0", address))
+		}
+		return n
+	}
+
+	for _, tc := range []struct {
+		name    string
+		node    *enginetest.Node
+		values  string
+		warning string
+	}{
+		{name: "offline", node: enginetest.NewNode(), values: `{"floatingIP":"192.168.100.10"}`},
+		{name: "valid", node: node("10.0.0.1", "10.0.0.5/24", "10.0.0.10/32"), values: `{"advertisedSubnets":["10.0.0.0/24"],"floatingIP":"10.0.0.10"}`},
+		{name: "subnets", node: node("10.0.0.1", "10.0.0.5/24"), values: `{}`, warning: "advertisedSubnets 192.168.100.0/24 contain none of the node addresses 10.0.0.5/24"},
+		{name: "gateway", node: node("10.1.0.1", "10.0.0.5/24"), values: `{"advertisedSubnets":["10.0.0.0/24"]}`, warning: "default gateway 10.1.0.1 is not within the subnets of the addresses 10.0.0.5/24"},
+		{name: "bond gateway", node: node("10.0.0.1", "10.0.0.5/24"), values: `{"advertisedSubnets":["10.0.0.0/24"],"bond":{"addresses":["10.0.0.5/24"],"gateway":"10.2.0.1"}}`, warning: "default gateway 10.2.0.1"},
+		{name: "floating IP", node: node("10.0.0.1", "10.0.0.5/24"), values: `{"advertisedSubnets":["10.0.0.0/24"],"floatingIP":"10.0.0.5"}`, warning: "floatingIP 10.0.0.5 is the address 10.0.0.5/24 of eth0"},
+	} {
+		warnings.Reset()
+		var buf bytes.Buffer
+		err := RenderNode(context.Background(), tc.node, Options{
+			Root:              "../../charts/generic",
+			KubernetesVersion: "v1.30.0",
+			TemplateFiles:     []string{"templates/controlplane.yaml"},
+			JsonValues:        []string{tc.values},
+		}, &buf)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if tc.warning == "" && warnings.Len() > 0 || !strings.Contains(warnings.String(), tc.warning) || strings.Count(warnings.String(), "Warning:") > 1 {
+			t.Errorf("%s: expected warning %q once, got %q", tc.name, tc.warning, warnings.String())
+		}
+	}
+}
+
+func TestRenderNodeMetadata(t *testing.T) {
+	render := func(template, values string) (string, error) {
+		var buf bytes.Buffer
+		err := RenderNode(context.Background(), enginetest.NewNode(), Options{
+			Root:              "../../charts/generic",
+			KubernetesVersion: "v1.30.0",
+			TemplateFiles:     []string{template},
+			JsonValues:        []string{values},
+		}, &buf)
+		return buf.String(), err
+	}
+
+	values := `{"nodeLabels":{"zone":"zone-a"},"nodeGroups":{"worker":{"nodeLabels":{"zone":"zone-b","role":"storage"},"nodeTaints":{"dedicated":"storage:NoSchedule"}}}}`
+	out, err := render("templates/worker.yaml", values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "nodeLabels:\n    role: storage\n    zone: zone-a\n  nodeTaints:\n    dedicated: storage:NoSchedule\n"
+	if !strings.Contains(out, expected) {
+		t.Errorf("expected %q in output:\n%s", expected, out)
+	}
+
+	out, err = render("templates/controlplane.yaml", values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "nodeLabels:\n    zone: zone-a\n") || strings.Contains(out, "nodeTaints:") {
+		t.Errorf("expected only the common labels on the control plane:\n%s", out)
+	}
+
+	if _, err := render("templates/worker.yaml", `{"nodeTaints":{"dedicated":"storage"}}`); err == nil {
+		t.Error("expected a taint without effect to fail the schema validation")
+	}
+}
+
+func TestRenderKubeletResources(t *testing.T) {
+	render := func(node *enginetest.Node, values ...string) (string, error) {
+		var buf bytes.Buffer
+		err := RenderNode(context.Background(), node, Options{
+			Root:              "../../charts/cozystack",
+			KubernetesVersion: "v1.30.0",
+			TemplateFiles:     []string{"templates/worker.yaml"},
+			Values:            values,
+		}, &buf)
+		return buf.String(), err
+	}
+
+	node := enginetest.NewNode().
+		WithResources("cpus", enginetest.Processor("CPU0", 8, 16), enginetest.Processor("CPU1", 8, 16)).
+		WithResources("memorymodules", enginetest.MemoryModule("DIMM0", 32768), enginetest.MemoryModule("DIMM1", 32768))
+	if hardware := Hardware(context.Background(), node); hardware["cpus"] != 32 || hardware["memoryMiB"] != 65536 {
+		t.Errorf("Hardware() = %v", hardware)
+	}
+
+	out, err := render(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"maxPods: 250\n", "kubeReserved:\n        cpu: 500m\n", "systemReserved:\n        cpu: 500m\n"} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected the medium size class %q in output:\n%s", expected, out)
+		}
+	}
+
+	out, err = render(node, "sizeClass=small")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "maxPods: 110") {
+		t.Errorf("expected the small size class in output:\n%s", out)
+	}
+
+	out, err = render(enginetest.NewNode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "maxPods: 512") || strings.Contains(out, "kubeReserved") {
+		t.Errorf("expected the default maxPods without hardware in output:\n%s", out)
+	}
+
+	if _, err := render(node, "sizeClass=huge"); err == nil {
+		t.Error("expected an unknown size class to fail the schema validation")
+	}
+}
+
+func TestRenderTopology(t *testing.T) {
+	render := func(topology Topology, values ...string) (string, error) {
+		var buf bytes.Buffer
+		err := RenderNode(context.Background(), enginetest.NewNode(), Options{
+			Root:              "../../charts/cozystack",
+			KubernetesVersion: "v1.30.0",
+			TemplateFiles:     []string{"templates/controlplane.yaml"},
+			Values:            values,
+			Topology:          topology,
+		}, &buf)
+		return buf.String(), err
+	}
+
+	out, err := render(Topology{Region: "eu", Zone: "zone-b", Rack: "r12"}, "zones.zone-b.advertisedSubnets={192.168.101.0/24}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"topology.kubernetes.io/region: eu\n",
+		"topology.kubernetes.io/zone: zone-b\n",
+		"topology.talm.dev/rack: r12\n",
+		"validSubnets:\n        - 192.168.101.0/24\n",
+		"advertisedSubnets:\n      - 192.168.101.0/24\n",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in output:\n%s", expected, out)
+		}
+	}
+
+	// Nodes without a topology and zones without subnets use advertisedSubnets
+	for _, topology := range []Topology{{}, {Zone: "zone-a"}} {
+		out, err = render(topology, "zones.zone-b.advertisedSubnets={192.168.101.0/24}")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(out, "192.168.101.0/24") || !strings.Contains(out, "advertisedSubnets:\n      - 192.168.100.0/24\n") {
+			t.Errorf("expected advertisedSubnets for topology %v in output:\n%s", topology, out)
+		}
+	}
+}
+
+func TestRenderSecureBoot(t *testing.T) {
+	render := func(secureBoot string) (string, error) {
+		var buf bytes.Buffer
+		err := RenderNode(context.Background(), enginetest.NewNode(), Options{
+			Root:              "../../charts/cozystack",
+			KubernetesVersion: "v1.30.0",
+			TemplateFiles:     []string{"templates/controlplane.yaml"},
+			JsonValues:        []string{`{"secureBoot":` + secureBoot + `}`},
+		}, &buf)
+		return buf.String(), err
+	}
+
+	// The Talos version of the image of the values is kept
+	out, err := render(`{"enabled":true,"tpmDiskEncryption":true}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"image: factory.talos.dev/installer-secureboot/376567988ad370138ad8b2698212367b8edcb69b5fd68c80be1f2ec7d603b4ba:v1.7.1\n",
+		"systemDiskEncryption:\n    state:\n      provider: luks2\n      keys:\n        - slot: 0\n          tpm: {}\n",
+		"    ephemeral:\n      provider: luks2\n",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in output:\n%s", expected, out)
+		}
+	}
+
+	out, err = render(`{"enabled":false}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out, "installer-secureboot") || strings.Contains(out, "systemDiskEncryption") {
+		t.Errorf("expected no SecureBoot settings in output:\n%s", out)
+	}
+
+	if _, err := render(`{"tpmDiskEncryption":true}`); err == nil {
+		t.Error("expected an error for TPM disk encryption without SecureBoot")
+	}
+}
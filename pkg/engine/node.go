@@ -0,0 +1,45 @@
+package engine
+
+import (
+	"context"
+
+	"github.com/siderolabs/talos/pkg/machinery/api/storage"
+	"github.com/siderolabs/talos/pkg/machinery/client"
+)
+
+// Node provides the facts about the node used by the templates: the disks exposed
+// as .Disks and the resources returned by the lookup function.
+//
+// The Talos client is wrapped with NewNode, tests can use the fake node of the enginetest package.
+type Node interface {
+	Disks(ctx context.Context) ([]*storage.Disk, error)
+	Lookup(ctx context.Context, kind, namespace, id string) (map[string]interface{}, error)
+}
+
+type talosNode struct {
+	c *client.Client
+}
+
+// NewNode returns the node the client is connected to.
+func NewNode(c *client.Client) Node {
+	return talosNode{c: c}
+}
+
+func (n talosNode) Disks(ctx context.Context) ([]*storage.Disk, error) {
+	response, err := n.c.Disks(ctx)
+	if err != nil && response == nil {
+		return nil, err
+	}
+
+	// Partial responses are used, nodes which failed are skipped
+	var disks []*storage.Disk
+	for _, m := range response.Messages {
+		disks = append(disks, m.Disks...)
+	}
+
+	return disks, nil
+}
+
+func (n talosNode) Lookup(ctx context.Context, kind, namespace, id string) (map[string]interface{}, error) {
+	return newLookupFunction(ctx, n.c)(kind, namespace, id)
+}
@@ -0,0 +1,204 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/strvals"
+)
+
+// ValueSource is a value the templates see with the source which set it last.
+type ValueSource struct {
+	Path   string      `json:"path"`
+	Value  interface{} `json:"value,omitempty"`
+	Source string      `json:"source"`
+	// Secret values are encrypted in their source, their value is left out
+	Secret bool `json:"secret,omitempty"`
+}
+
+// valueLayer is a source of values, the layers override each other in the order loadValues merges them.
+type valueLayer struct {
+	source  string
+	values  map[string]interface{}
+	secrets []string
+}
+
+// ValueSources returns the values the templates see, sorted by path, with their sources:
+// values.yaml of the chart, the values files, the values of the modeline and the --set flags.
+// Maps are walked down to their values, lists are values as a whole. Secret values are not
+// decrypted, so no passphrase is needed.
+func ValueSources(opts Options) ([]ValueSource, error) {
+	chartPath, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	if opts.Root != "" {
+		chartPath = opts.Root
+	}
+	chrt, err := LoadChart(chartPath, opts.Extends)
+	if err != nil {
+		return nil, err
+	}
+
+	chartLayer := valueLayer{source: chartutil.ValuesfileName, values: chrt.Values}
+	for _, f := range chrt.Raw {
+		if f.Name == chartutil.ValuesfileName {
+			if chartLayer.secrets, err = secretValuePaths(f.Data); err != nil {
+				return nil, fmt.Errorf("%s: %w", f.Name, err)
+			}
+		}
+	}
+	layers := []valueLayer{chartLayer}
+
+	for _, filePath := range opts.ValueFiles {
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read values file %s: %w", filePath, err)
+		}
+		layer := valueLayer{source: filepath.ToSlash(filePath), values: map[string]interface{}{}}
+		if err := yaml.Unmarshal(data, &layer.values); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal values from file %s: %w", filePath, err)
+		}
+		if layer.secrets, err = secretValuePaths(data); err != nil {
+			return nil, fmt.Errorf("%s: %w", filePath, err)
+		}
+		layers = append(layers, layer)
+	}
+	layers = append(layers, valueLayer{source: "modeline", values: opts.NodeValues})
+
+	// The flags are parsed one at a time to tell them apart
+	parse := func(source string, values []string, parse func(value string, layer map[string]interface{}) error) error {
+		for _, value := range values {
+			layer := valueLayer{source: source + " " + value, values: map[string]interface{}{}}
+			if err := parse(value, layer.values); err != nil {
+				return fmt.Errorf("failed to parse %s value '%s': %w", source, value, err)
+			}
+			layers = append(layers, layer)
+		}
+		return nil
+	}
+	flags := []struct {
+		source string
+		values []string
+		parse  func(value string, layer map[string]interface{}) error
+	}{
+		{"--set-json", opts.JsonValues, func(value string, layer map[string]interface{}) error {
+			return json.Unmarshal([]byte(value), &layer)
+		}},
+		{"--set", opts.Values, strvals.ParseInto},
+		{"--set-string", opts.StringValues, strvals.ParseIntoString},
+		{"--set-file", opts.FileValues, func(value string, layer map[string]interface{}) error {
+			content, err := os.ReadFile(value)
+			if err != nil {
+				return err
+			}
+			return strvals.ParseInto(fmt.Sprintf("%s=%s", value, content), layer)
+		}},
+		{"--set-env", opts.EnvValues, func(value string, layer map[string]interface{}) error {
+			return strvals.ParseIntoFile(value, layer, func(rs []rune) (interface{}, error) {
+				return os.Getenv(string(rs)), nil
+			})
+		}},
+		{"--set-literal", opts.LiteralValues, strvals.ParseInto},
+	}
+	for _, flag := range flags {
+		if err := parse(flag.source, flag.values, flag.parse); err != nil {
+			return nil, err
+		}
+	}
+
+	return mergeValueLayers(layers), nil
+}
+
+// mergeValueLayers merges the values of the layers, a value replaces the values below its path
+// and the value its path is below of. Null values remove the value, like Helm does.
+func mergeValueLayers(layers []valueLayer) []ValueSource {
+	merged := map[string]ValueSource{}
+	for _, layer := range layers {
+		walkValues("", layer.values, func(path string, value interface{}) {
+			// An empty map is merged into the values below its path
+			if m, ok := value.(map[string]interface{}); ok && len(m) == 0 {
+				for existing := range merged {
+					if strings.HasPrefix(existing, path+".") {
+						return
+					}
+				}
+			}
+			for existing := range merged {
+				if existing == path || strings.HasPrefix(existing, path+".") || strings.HasPrefix(path, existing+".") {
+					delete(merged, existing)
+				}
+			}
+			if value == nil {
+				return
+			}
+			source := ValueSource{Path: path, Value: value, Source: layer.source}
+			for _, secret := range layer.secrets {
+				if secret == path || strings.HasPrefix(secret, path+"[") {
+					source.Value, source.Secret = nil, true
+				}
+			}
+			merged[path] = source
+		})
+	}
+
+	sources := make([]ValueSource, 0, len(merged))
+	for _, source := range merged {
+		sources = append(sources, source)
+	}
+	sort.Slice(sources, func(i, j int) bool { return sources[i].Path < sources[j].Path })
+	return sources
+}
+
+// walkValues calls f with the dot separated path of every value which is not a non-empty map.
+func walkValues(prefix string, values map[string]interface{}, f func(path string, value interface{})) {
+	for key, value := range values {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		if m, ok := value.(map[string]interface{}); ok && len(m) > 0 {
+			walkValues(path, m, f)
+			continue
+		}
+		f(path, value)
+	}
+}
+
+// secretValuePaths returns the paths of the values tagged with SecretTag, like .bmc.users[0].password
+// without the leading dot.
+func secretValuePaths(data []byte) ([]string, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	var walk func(node *yaml.Node, path string)
+	walk = func(node *yaml.Node, path string) {
+		switch {
+		case node.Kind == yaml.ScalarNode && node.Tag == SecretTag:
+			paths = append(paths, strings.TrimPrefix(path, "."))
+		case node.Kind == yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				walk(node.Content[i+1], path+"."+node.Content[i].Value)
+			}
+		default:
+			for i, child := range node.Content {
+				childPath := path
+				if node.Kind == yaml.SequenceNode {
+					childPath = fmt.Sprintf("%s[%d]", path, i)
+				}
+				walk(child, childPath)
+			}
+		}
+	}
+	walk(&root, "")
+	return paths, nil
+}
@@ -0,0 +1,83 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeValueLayers(t *testing.T) {
+	layers := []valueLayer{
+		{
+			source: "values.yaml",
+			values: map[string]interface{}{
+				"endpoint":    "https://192.168.100.10:6443",
+				"podSubnets":  []interface{}{"10.244.0.0/16"},
+				"bond":        map[string]interface{}{"mode": "802.3ad", "interfaces": []interface{}{"eth0"}},
+				"labels":      map[string]interface{}{},
+				"bmc":         map[string]interface{}{"password": "ENC[...]"},
+				"certSANs":    []interface{}{},
+				"removedHere": "value",
+			},
+			secrets: []string{"bmc.password"},
+		},
+		{
+			source: "values-prod.yaml",
+			values: map[string]interface{}{
+				"bond":        map[string]interface{}{},
+				"labels":      map[string]interface{}{"zone": "a"},
+				"removedHere": nil,
+			},
+		},
+		{
+			source: "--set bond=none",
+			values: map[string]interface{}{"bond": "none"},
+		},
+	}
+
+	expected := []ValueSource{
+		{Path: "bmc.password", Source: "values.yaml", Secret: true},
+		{Path: "bond", Value: "none", Source: "--set bond=none"},
+		{Path: "certSANs", Value: []interface{}{}, Source: "values.yaml"},
+		{Path: "endpoint", Value: "https://192.168.100.10:6443", Source: "values.yaml"},
+		{Path: "labels.zone", Value: "a", Source: "values-prod.yaml"},
+		{Path: "podSubnets", Value: []interface{}{"10.244.0.0/16"}, Source: "values.yaml"},
+	}
+	if sources := mergeValueLayers(layers); !reflect.DeepEqual(sources, expected) {
+		t.Errorf("unexpected sources:\n%+v\nexpected:\n%+v", sources, expected)
+	}
+}
+
+func TestSecretValuePaths(t *testing.T) {
+	data := []byte(`bmc:
+  users:
+  - name: admin
+    password: !secret ENC[AES256_GCM,data:...]
+token: !secret ENC[AES256_GCM,data:...]
+endpoint: https://192.168.100.10:6443
+`)
+	paths, err := secretValuePaths(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"bmc.users[0].password", "token"}; !reflect.DeepEqual(paths, expected) {
+		t.Errorf("expected %v, got %v", expected, paths)
+	}
+}
+
+func TestValueSources(t *testing.T) {
+	sources, err := ValueSources(Options{
+		Root:       "../../charts/generic",
+		NodeValues: map[string]interface{}{"endpoint": "https://10.0.0.1:6443"},
+		Values:     []string{"podSubnets={10.0.0.0/8}"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bySource := map[string]string{}
+	for _, source := range sources {
+		bySource[source.Path] = source.Source
+	}
+	if bySource["endpoint"] != "modeline" || bySource["podSubnets"] != "--set podSubnets={10.0.0.0/8}" || bySource["serviceSubnets"] != "values.yaml" {
+		t.Errorf("unexpected sources %v", bySource)
+	}
+}
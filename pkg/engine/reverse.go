@@ -0,0 +1,379 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	helmEngine "github.com/aenix-io/talm/pkg/engine/helm"
+	"github.com/aenix-io/talm/pkg/yamltools"
+	"github.com/mitchellh/copystructure"
+	"github.com/siderolabs/talos/pkg/machinery/config"
+	"github.com/siderolabs/talos/pkg/machinery/config/bundle"
+	"github.com/siderolabs/talos/pkg/machinery/config/encoder"
+	"github.com/siderolabs/talos/pkg/machinery/config/generate"
+	"github.com/siderolabs/talos/pkg/machinery/config/machine"
+	"gopkg.in/yaml.v3"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+// Reverse is a live machine config mapped back onto the values of the chart.
+type Reverse struct {
+	// Values are the recovered values, keyed by dot separated path
+	Values map[string]interface{}
+	// Conflicts describe values rendered into several fields with different live values,
+	// the value of the first field is recovered
+	Conflicts []string
+	// Residual is the part of the live config which differs from the Talos defaults
+	// and is not produced by the template, empty if the template covers everything
+	Residual []byte
+}
+
+// ReverseRender maps the live machine config of a node back onto the values of the chart
+// rendered by the first template of the options. For every string and list value of the chart
+// the template is rendered offline with a sentinel in place of the value, the fields containing
+// the sentinel are matched against the live config to recover the value.
+func ReverseRender(opts Options, live []byte) (*Reverse, error) {
+	if len(opts.TemplateFiles) == 0 {
+		return nil, fmt.Errorf("a template is required to map the config onto values")
+	}
+
+	helmEngine.Disks = map[string]interface{}{}
+	helmEngine.LookupFunc = func(string, string, string) (map[string]interface{}, error) {
+		return map[string]interface{}{}, nil
+	}
+
+	chartPath, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	if opts.Root != "" {
+		chartPath = opts.Root
+	}
+
+	chrt, err := LoadChart(chartPath, opts.Extends)
+	if err != nil {
+		return nil, err
+	}
+	values, err := chartValues(chrt, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var liveDoc yaml.Node
+	if err := yaml.NewDecoder(bytes.NewReader(live)).Decode(&liveDoc); err != nil {
+		return nil, fmt.Errorf("invalid live config: %w", err)
+	}
+	var liveConfig interface{}
+	if err := liveDoc.Decode(&liveConfig); err != nil {
+		return nil, err
+	}
+
+	templateName := path.Join(chrt.Name(), strings.TrimPrefix(path.Clean(opts.TemplateFiles[0]), "/"))
+	renderTemplate := func(values chartutil.Values, opts Options) (interface{}, error) {
+		out, err := renderChartValues(chartPath, chrt, values, opts, nil)
+		if err != nil {
+			return nil, err
+		}
+		content, ok := out[templateName]
+		if !ok {
+			return nil, fmt.Errorf("template %s not found", opts.TemplateFiles[0])
+		}
+		var doc interface{}
+		if err := yaml.Unmarshal([]byte(content), &doc); err != nil {
+			return nil, err
+		}
+		return doc, nil
+	}
+
+	if _, err := renderTemplate(values, opts); err != nil {
+		return nil, err
+	}
+
+	skip := map[string]bool{"global": true}
+	for _, dependency := range chrt.Dependencies() {
+		skip[dependency.Name()] = true
+	}
+	var candidates [][]string
+	collectValuePaths(values, nil, skip, &candidates)
+
+	sentinelOpts := opts
+	sentinelOpts.sentinel = true
+	reverse := &Reverse{}
+	recovered := map[string]interface{}{}
+	for _, keys := range candidates {
+		copied, err := copystructure.Copy(map[string]interface{}(values))
+		if err != nil {
+			return nil, err
+		}
+		sentinelValues := chartutil.Values(copied.(map[string]interface{}))
+		if _, err := setSentinel(sentinelValues, keys); err != nil {
+			continue
+		}
+
+		// Values parsed by the templates can't hold a sentinel
+		doc, err := renderTemplate(sentinelValues, sentinelOpts)
+		if err != nil {
+			continue
+		}
+
+		valuePath := strings.Join(keys, ".")
+		_, isList := sentinelValue(sentinelValues, keys).([]interface{})
+		var found []fieldValue
+		findSentinel(doc, liveConfig, "", isList, &found)
+		for _, field := range found {
+			if existing, ok := recovered[valuePath]; ok {
+				if fmt.Sprint(existing) != fmt.Sprint(field.value) {
+					reverse.Conflicts = append(reverse.Conflicts, fmt.Sprintf("%s: %s is %v, another field is %v", valuePath, field.path, field.value, existing))
+				}
+				continue
+			}
+			recovered[valuePath] = field.value
+		}
+	}
+	reverse.Values = recovered
+
+	// The fields produced by the template with the recovered values are covered
+	coveredValues, err := copystructure.Copy(map[string]interface{}(values))
+	if err != nil {
+		return nil, err
+	}
+	for valuePath, value := range recovered {
+		setValue(coveredValues.(map[string]interface{}), strings.Split(valuePath, "."), value)
+	}
+	covered, err := renderTemplate(chartutil.Values(coveredValues.(map[string]interface{})), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	reverse.Residual, err = residualConfig(opts, &liveDoc, covered)
+	if err != nil {
+		return nil, err
+	}
+
+	return reverse, nil
+}
+
+// collectValuePaths collects the paths of the string and list values, the values which can be recovered.
+func collectValuePaths(values map[string]interface{}, prefix []string, skip map[string]bool, paths *[][]string) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if len(prefix) == 0 && skip[key] {
+			continue
+		}
+		keyPath := append(append([]string(nil), prefix...), key)
+		switch v := values[key].(type) {
+		case map[string]interface{}:
+			collectValuePaths(v, keyPath, skip, paths)
+		case string:
+			*paths = append(*paths, keyPath)
+		case []interface{}:
+			scalars := true
+			for _, item := range v {
+				if _, ok := item.(map[string]interface{}); ok {
+					scalars = false
+				}
+			}
+			if scalars {
+				*paths = append(*paths, keyPath)
+			}
+		}
+	}
+}
+
+// sentinelValue returns the value at the path of keys.
+func sentinelValue(values map[string]interface{}, keys []string) interface{} {
+	for _, key := range keys[:len(keys)-1] {
+		values, _ = values[key].(map[string]interface{})
+	}
+	return values[keys[len(keys)-1]]
+}
+
+type fieldValue struct {
+	path  string
+	value interface{}
+}
+
+// findSentinel walks the document rendered with a sentinel along the live config and collects
+// the live values of the fields holding the sentinel: strings embedding it, or lists made of it
+// for list values.
+func findSentinel(rendered, live interface{}, fieldPath string, list bool, found *[]fieldValue) {
+	switch r := rendered.(type) {
+	case map[string]interface{}:
+		l, ok := live.(map[string]interface{})
+		if !ok {
+			return
+		}
+		keys := make([]string, 0, len(r))
+		for key := range r {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			field := key
+			if fieldPath != "" {
+				field = fieldPath + "." + key
+			}
+			findSentinel(r[key], l[key], field, list, found)
+		}
+	case []interface{}:
+		l, ok := live.([]interface{})
+		if !ok {
+			return
+		}
+		if list && len(r) == 1 && r[0] == explainSentinel {
+			*found = append(*found, fieldValue{path: fieldPath, value: l})
+			return
+		}
+		for i := range r {
+			if i < len(l) {
+				findSentinel(r[i], l[i], fmt.Sprintf("%s[%d]", fieldPath, i), list, found)
+			}
+		}
+	case string:
+		l, ok := live.(string)
+		if list || !ok || !strings.Contains(r, explainSentinel) {
+			return
+		}
+		parts := strings.Split(r, explainSentinel)
+		for i := range parts {
+			parts[i] = regexp.QuoteMeta(parts[i])
+		}
+		match := regexp.MustCompile("^" + strings.Join(parts, "(.+?)") + "$").FindStringSubmatch(l)
+		if match == nil {
+			return
+		}
+		// Every occurrence of the sentinel must hold the same value
+		for _, group := range match[2:] {
+			if group != match[1] {
+				return
+			}
+		}
+		*found = append(*found, fieldValue{path: fieldPath, value: match[1]})
+	}
+}
+
+// residualConfig returns the fields of the live config which differ from the Talos defaults
+// and are not covered by the rendered template.
+func residualConfig(opts Options, liveDoc *yaml.Node, covered interface{}) ([]byte, error) {
+	var liveProps struct {
+		Machine struct {
+			Type string `yaml:"type"`
+		} `yaml:"machine"`
+		Cluster struct {
+			ClusterName  string `yaml:"clusterName"`
+			ControlPlane struct {
+				Endpoint string `yaml:"endpoint"`
+			} `yaml:"controlPlane"`
+		} `yaml:"cluster"`
+	}
+	if err := liveDoc.Decode(&liveProps); err != nil {
+		return nil, err
+	}
+
+	machineType, err := machine.ParseType(liveProps.Machine.Type)
+	if err != nil || machineType == machine.TypeUnknown {
+		machineType = machine.TypeWorker
+	}
+
+	genOptions := []generate.Option{}
+	if opts.TalosVersion != "" {
+		versionContract, err := config.ParseContractFromVersion(opts.TalosVersion)
+		if err != nil {
+			return nil, fmt.Errorf("invalid talos-version: %w", err)
+		}
+		genOptions = append(genOptions, generate.WithVersionContract(versionContract))
+	}
+	if opts.WithSecrets != "" {
+		secretsBundle, err := LoadSecretsBundle(opts.WithSecrets)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load secrets bundle: %w", err)
+		}
+		genOptions = append(genOptions, generate.WithSecretsBundle(secretsBundle))
+	}
+
+	configBundle, err := bundle.NewBundle(
+		bundle.WithInputOptions(&bundle.InputOptions{
+			ClusterName: liveProps.Cluster.ClusterName,
+			Endpoint:    liveProps.Cluster.ControlPlane.Endpoint,
+			KubeVersion: strings.TrimPrefix(opts.KubernetesVersion, "v"),
+			GenOptions:  genOptions,
+		}),
+		bundle.WithVerbose(false),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defaults, err := configBundle.Serialize(encoder.CommentsDisabled, machineType)
+	if err != nil {
+		return nil, err
+	}
+
+	live, err := yaml.Marshal(liveDoc)
+	if err != nil {
+		return nil, err
+	}
+	diff, err := yamltools.DiffYAMLs(defaults, live)
+	if err != nil {
+		return nil, err
+	}
+
+	var residual yaml.Node
+	if err := yaml.Unmarshal(diff, &residual); err != nil {
+		return nil, err
+	}
+	if len(residual.Content) == 0 {
+		return nil, nil
+	}
+	for _, keys := range secretPaths {
+		yamltools.DeletePath(&residual, keys...)
+	}
+	removeCovered(residual.Content[0], covered)
+	if len(residual.Content[0].Content) == 0 {
+		return nil, nil
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&residual); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// removeCovered removes the fields of the mapping node which are set by the rendered document.
+func removeCovered(node *yaml.Node, covered interface{}) {
+	coveredMap, ok := covered.(map[string]interface{})
+	if !ok || node.Kind != yaml.MappingNode {
+		return
+	}
+
+	content := node.Content[:0]
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if c, ok := coveredMap[key.Value]; ok {
+			if _, isMap := c.(map[string]interface{}); !isMap || value.Kind != yaml.MappingNode {
+				continue
+			}
+			removeCovered(value, c)
+			if len(value.Content) == 0 {
+				continue
+			}
+		}
+		content = append(content, key, value)
+	}
+	node.Content = content
+}
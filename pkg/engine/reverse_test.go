@@ -0,0 +1,60 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReverseRender(t *testing.T) {
+	opts := Options{
+		Root:              "../../charts/generic",
+		KubernetesVersion: "v1.30.0",
+		TemplateFiles:     []string{"templates/controlplane.yaml"},
+		Full:              true,
+		Values:            []string{"endpoint=https://10.0.0.10:6443", "advertisedSubnets={10.0.0.0/24}"},
+		JsonValues:        []string{`{"podSubnets":["10.200.0.0/16"]}`},
+	}
+
+	var live bytes.Buffer
+	if err := RenderNode(context.Background(), nil, opts, &live); err != nil {
+		t.Fatal(err)
+	}
+	// A field set outside of the chart
+	config := strings.Replace(live.String(), "machine:\n", "machine:\n  sysctls:\n    vm.max_map_count: \"262144\"\n", 1)
+
+	reverse, err := ReverseRender(Options{
+		Root:              "../../charts/generic",
+		KubernetesVersion: "v1.30.0",
+		TemplateFiles:     []string{"templates/controlplane.yaml"},
+	}, []byte(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, want := range map[string]interface{}{
+		"endpoint":          "https://10.0.0.10:6443",
+		"advertisedSubnets": []interface{}{"10.0.0.0/24"},
+		"podSubnets":        []interface{}{"10.200.0.0/16"},
+		"serviceSubnets":    []interface{}{"10.96.0.0/16"},
+	} {
+		if got := reverse.Values[path]; !reflect.DeepEqual(got, want) {
+			t.Errorf("value %s = %v, want %v", path, got, want)
+		}
+	}
+	if len(reverse.Conflicts) > 0 {
+		t.Errorf("unexpected conflicts: %v", reverse.Conflicts)
+	}
+
+	residual := string(reverse.Residual)
+	if !strings.Contains(residual, "vm.max_map_count") {
+		t.Errorf("expected sysctls in the residual config:\n%s", residual)
+	}
+	for _, covered := range []string{"podSubnets", "endpoint", "token", "certSANs"} {
+		if strings.Contains(residual, covered) {
+			t.Errorf("unexpected %s in the residual config:\n%s", covered, residual)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/siderolabs/talos/pkg/machinery/config/generate/secrets"
+	"golang.org/x/term"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultEnvironment is the key of SecretsPaths used when no environment is selected.
+const defaultEnvironment = "default"
+
+// secretPaths are the fields of the machine config generated from the secrets bundle.
+var secretPaths = [][]string{
+	{"machine", "token"},
+	{"machine", "ca"},
+	{"machine", "acceptedCAs"},
+	{"cluster", "id"},
+	{"cluster", "secret"},
+	{"cluster", "token"},
+	{"cluster", "secretboxEncryptionSecret"},
+	{"cluster", "aescbcEncryptionSecret"},
+	{"cluster", "ca"},
+	{"cluster", "acceptedCAs"},
+	{"cluster", "aggregatorCA"},
+	{"cluster", "serviceAccount"},
+	{"cluster", "etcd", "ca"},
+}
+
+// StdinPath is the path of the secrets bundle read from the standard input, e.g. decrypted with
+// sops in a pipeline.
+const StdinPath = "-"
+
+// stdin is the secrets bundle read from the standard input, it is read once and shared by
+// every rendered file.
+var stdin struct {
+	once sync.Once
+	data []byte
+	err  error
+}
+
+// LoadSecretsBundle loads the secrets bundle from the path, or from the standard input if the
+// path is StdinPath. The standard input must not be a terminal, so a pipeline never waits for
+// a secrets bundle typed in.
+func LoadSecretsBundle(path string) (*secrets.Bundle, error) {
+	if path != StdinPath {
+		return secrets.LoadBundle(path)
+	}
+
+	stdin.once.Do(func() {
+		if term.IsTerminal(int(os.Stdin.Fd())) {
+			stdin.err = errors.New("the secrets bundle is read from the standard input, but it is a terminal: pipe the secrets bundle to talm")
+			return
+		}
+		stdin.data, stdin.err = io.ReadAll(os.Stdin)
+		if stdin.err == nil && len(bytes.TrimSpace(stdin.data)) == 0 {
+			stdin.err = errors.New("the secrets bundle read from the standard input is empty")
+		}
+	})
+	if stdin.err != nil {
+		return nil, stdin.err
+	}
+
+	bundle := &secrets.Bundle{
+		Clock: secrets.NewClock(),
+	}
+	if err := yaml.Unmarshal(stdin.data, bundle); err != nil {
+		return nil, fmt.Errorf("error parsing the secrets bundle read from the standard input: %w", err)
+	}
+	return bundle, nil
+}
+
+// SecretsPaths is the withSecrets option of Chart.yaml. It is either a single path
+// of the secrets bundle, or a map of paths keyed by environment, so one chart tree
+// can serve several clusters whose secrets differ:
+//
+//	withSecrets:
+//	  prod: secrets/prod.yaml
+//	  staging: secrets/staging.yaml
+type SecretsPaths struct {
+	Path         string
+	Environments map[string]string
+}
+
+// UnmarshalYAML accepts a path or a map of paths.
+func (s *SecretsPaths) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.MappingNode {
+		return node.Decode(&s.Environments)
+	}
+	return node.Decode(&s.Path)
+}
+
+// Resolve returns the path of the secrets bundle for the environment.
+// A single path is used by every environment, a map without the environment
+// falls back to the "default" key.
+func (s SecretsPaths) Resolve(environment string) (string, error) {
+	if s.Environments == nil {
+		return s.Path, nil
+	}
+
+	if environment == "" {
+		environment = defaultEnvironment
+	}
+	if path, ok := s.Environments[environment]; ok {
+		return path, nil
+	}
+	if path, ok := s.Environments[defaultEnvironment]; ok {
+		return path, nil
+	}
+
+	environments := make([]string, 0, len(s.Environments))
+	for env := range s.Environments {
+		environments = append(environments, env)
+	}
+	sort.Strings(environments)
+
+	if environment == defaultEnvironment {
+		return "", fmt.Errorf("withSecrets is keyed by environment, select one of %s with --environment", strings.Join(environments, ", "))
+	}
+	return "", fmt.Errorf("withSecrets has no secrets for environment %q, available: %s", environment, strings.Join(environments, ", "))
+}
+
+// secretPlaceholderPattern matches the placeholders of the secret fields rendered without secrets.
+var secretPlaceholderPattern = regexp.MustCompile(`^<secret:([^>]+)>$`)
+
+// redactSecrets replaces the values of the secret fields of the machine config with placeholders
+// naming the field, so the config rendered without the secrets bundle is deterministic.
+func redactSecrets(doc *yaml.Node) {
+	for _, keys := range secretPaths {
+		node := doc.Content[0]
+		for _, key := range keys {
+			i := -1
+			if node.Kind == yaml.MappingNode {
+				i = findKey(node, key)
+			}
+			if i < 0 {
+				node = nil
+				break
+			}
+			node = node.Content[i+1]
+		}
+		if node != nil {
+			redactNode(node, strings.Join(keys, "."))
+		}
+	}
+}
+
+func redactNode(node *yaml.Node, path string) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		// Empty fields, like the CA keys of workers, stay empty
+		if node.Value == "" {
+			return
+		}
+		node.Value = "<secret:" + path + ">"
+		node.Tag = "!!str"
+		node.Style = 0
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			redactNode(node.Content[i+1], path+"."+node.Content[i].Value)
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			redactNode(item, fmt.Sprintf("%s[%d]", path, i))
+		}
+	}
+}
+
+// RedactSecrets replaces the secret fields of the machine config documents with placeholders,
+// e.g. to share node files and configs. Documents of other kinds are kept as they are.
+func RedactSecrets(data []byte) ([]byte, error) {
+	docs, err := decodeDocuments(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range docs {
+		if doc.id == machineConfigID {
+			redactSecrets(doc.node)
+		}
+	}
+	return encodeDocuments(docs)
+}
+
+// SecretPlaceholders returns the paths of the secret fields replaced by placeholders in the
+// config rendered without secrets, they must be substituted before the config is applied.
+func SecretPlaceholders(rendered []byte) ([]string, error) {
+	docs, err := decodeDocuments(rendered)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	var walk func(node *yaml.Node)
+	walk = func(node *yaml.Node) {
+		if node.Kind == yaml.ScalarNode {
+			if match := secretPlaceholderPattern.FindStringSubmatch(node.Value); match != nil {
+				paths = append(paths, match[1])
+			}
+		}
+		for _, child := range node.Content {
+			walk(child)
+		}
+	}
+	for _, doc := range docs {
+		walk(doc.node)
+	}
+	return paths, nil
+}
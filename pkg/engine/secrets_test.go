@@ -0,0 +1,136 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/siderolabs/talos/pkg/machinery/config"
+	"github.com/siderolabs/talos/pkg/machinery/config/generate/secrets"
+	"gopkg.in/yaml.v3"
+)
+
+func TestSecretsPathsResolve(t *testing.T) {
+	var single, keyed, withDefault SecretsPaths
+	for doc, s := range map[string]*SecretsPaths{
+		`secrets.yaml`: &single,
+		"prod: secrets/prod.yaml\nstaging: secrets/staging.yaml": &keyed,
+		"default: secrets.yaml\nprod: secrets/prod.yaml":         &withDefault,
+	} {
+		if err := yaml.Unmarshal([]byte(doc), s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		paths       SecretsPaths
+		environment string
+		want        string
+	}{
+		{single, "", "secrets.yaml"},
+		{single, "prod", "secrets.yaml"},
+		{keyed, "staging", "secrets/staging.yaml"},
+		{withDefault, "", "secrets.yaml"},
+		{withDefault, "dev", "secrets.yaml"},
+		{withDefault, "prod", "secrets/prod.yaml"},
+	} {
+		got, err := tc.paths.Resolve(tc.environment)
+		if err != nil {
+			t.Errorf("%q: %v", tc.environment, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%q: expected %q, got %q", tc.environment, tc.want, got)
+		}
+	}
+
+	for _, environment := range []string{"", "dev"} {
+		if _, err := keyed.Resolve(environment); err == nil {
+			t.Errorf("%q: expected error", environment)
+		}
+	}
+}
+
+func TestRedactSecrets(t *testing.T) {
+	config := `machine:
+  token: abc.def
+  ca:
+    crt: Y3J0
+    key: ""
+  install:
+    disk: /dev/sda
+cluster:
+  acceptedCAs:
+    - crt: Y3J0
+---
+apiVersion: v1alpha1
+kind: FutureConfig
+token: kept
+`
+	out, err := RedactSecrets([]byte(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `machine:
+  token: <secret:machine.token>
+  ca:
+    crt: <secret:machine.ca.crt>
+    key: ""
+  install:
+    disk: /dev/sda
+cluster:
+  acceptedCAs:
+    - crt: <secret:cluster.acceptedCAs[0].crt>
+---
+apiVersion: v1alpha1
+kind: FutureConfig
+token: kept
+`
+	if string(out) != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, out)
+	}
+
+	paths, err := SecretPlaceholders(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(paths, ",") != "machine.token,machine.ca.crt,cluster.acceptedCAs[0].crt" {
+		t.Errorf("unexpected placeholders %v", paths)
+	}
+}
+
+func TestLoadSecretsBundleFromStdin(t *testing.T) {
+	bundle, err := secrets.NewBundle(secrets.NewClock(), config.TalosVersionCurrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := yaml.Marshal(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "secrets.yaml")
+	if err := os.WriteFile(file, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close() //nolint:errcheck
+	saved := os.Stdin
+	os.Stdin = f
+	defer func() { os.Stdin = saved }()
+
+	// The standard input is read once and shared by every rendered file
+	for i := 0; i < 2; i++ {
+		loaded, err := LoadSecretsBundle(StdinPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loaded.Secrets.BootstrapToken != bundle.Secrets.BootstrapToken || loaded.Clock == nil {
+			t.Errorf("read %d: secrets bundle read from stdin differs from the written one", i)
+		}
+	}
+}
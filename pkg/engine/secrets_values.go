@@ -0,0 +1,104 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/aenix-io/talm/pkg/age"
+	"gopkg.in/yaml.v3"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+// SecretTag marks the values encrypted with age, e.g. `token: !secret |` followed by an armored
+// age file, which are decrypted when the values are loaded. The files are encrypted to the
+// recipients of age identities, or with a passphrase, which costs a scrypt run per value.
+const SecretTag = "!secret"
+
+// SecretValuesIdentities returns the identities decrypting the values tagged with SecretTag, it is
+// only called if the values contain one. The commands set it, by default secret values are rejected.
+var SecretValuesIdentities = func() ([]age.Identity, error) {
+	return nil, errors.New("no age identity or passphrase is set for the secret values")
+}
+
+// decryptSecretValues replaces the scalars tagged with SecretTag in the YAML document by their
+// decrypted content, other documents are returned as they are.
+func decryptSecretValues(data []byte) ([]byte, error) {
+	if !bytes.Contains(data, []byte(SecretTag)) {
+		return data, nil
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+
+	var identities []age.Identity
+	decrypted := false
+	var walk func(node *yaml.Node, path string) error
+	walk = func(node *yaml.Node, path string) error {
+		switch {
+		case node.Kind == yaml.ScalarNode && node.Tag == SecretTag:
+			if identities == nil {
+				var err error
+				if identities, err = SecretValuesIdentities(); err != nil {
+					return err
+				}
+			}
+			plaintext, err := age.DecryptIdentities([]byte(node.Value), identities)
+			if err != nil {
+				return fmt.Errorf("error decrypting %s: %w", path, err)
+			}
+			node.Value = string(plaintext)
+			node.Tag = "!!str"
+			node.Style = 0
+			decrypted = true
+		case node.Kind == yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if err := walk(node.Content[i+1], path+"."+node.Content[i].Value); err != nil {
+					return err
+				}
+			}
+		default:
+			for i, child := range node.Content {
+				childPath := path
+				if node.Kind == yaml.SequenceNode {
+					childPath = fmt.Sprintf("%s[%d]", path, i)
+				}
+				if err := walk(child, childPath); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk(&root, ""); err != nil {
+		return nil, err
+	}
+	if !decrypted {
+		return data, nil
+	}
+
+	return yaml.Marshal(&root)
+}
+
+// decryptChartValues decrypts the secret values of values.yaml of the chart.
+func decryptChartValues(chrt *chart.Chart) error {
+	for _, f := range chrt.Raw {
+		if f.Name != chartutil.ValuesfileName || !bytes.Contains(f.Data, []byte(SecretTag)) {
+			continue
+		}
+
+		data, err := decryptSecretValues(f.Data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+		values, err := chartutil.ReadValues(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+		chrt.Values = values
+	}
+	return nil
+}
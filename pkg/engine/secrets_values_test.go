@@ -0,0 +1,61 @@
+package engine
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aenix-io/talm/pkg/age"
+	"gopkg.in/yaml.v3"
+)
+
+func TestDecryptSecretValues(t *testing.T) {
+	age.WorkFactor = 10
+	encrypted, err := age.Encrypt([]byte("s3cr3t\ntoken"), []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	indented := "        " + strings.ReplaceAll(strings.TrimSpace(string(encrypted)), "\n", "\n        ")
+
+	data := []byte("# comment\nendpoint: https://1.2.3.4:6443\nbmc:\n  users:\n    - name: admin\n      password: " +
+		SecretTag + " |\n" + indented + "\n")
+
+	defer func(identities func() ([]age.Identity, error)) { SecretValuesIdentities = identities }(SecretValuesIdentities)
+	calls := 0
+	SecretValuesIdentities = func() ([]age.Identity, error) {
+		calls++
+		identity, err := age.ScryptIdentity([]byte("passphrase"))
+		return []age.Identity{identity}, err
+	}
+
+	decrypted, err := decryptSecretValues(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values struct {
+		Endpoint string
+		BMC      struct {
+			Users []struct{ Name, Password string }
+		}
+	}
+	if err := yaml.Unmarshal(decrypted, &values); err != nil {
+		t.Fatal(err)
+	}
+	if values.Endpoint != "https://1.2.3.4:6443" || len(values.BMC.Users) != 1 || values.BMC.Users[0].Password != "s3cr3t\ntoken" {
+		t.Errorf("unexpected values %+v from:\n%s", values, decrypted)
+	}
+
+	// The passphrase is not needed without secret values
+	plain := []byte("endpoint: https://1.2.3.4:6443\n")
+	if decrypted, err := decryptSecretValues(plain); err != nil || string(decrypted) != string(plain) || calls != 1 {
+		t.Errorf("expected the values unchanged, got %q, %v after %d calls", decrypted, err, calls)
+	}
+
+	SecretValuesIdentities = func() ([]age.Identity, error) {
+		identity, err := age.ScryptIdentity([]byte("wrong"))
+		return []age.Identity{identity}, err
+	}
+	if _, err := decryptSecretValues(data); !errors.Is(err, age.ErrWrongIdentity) || !strings.Contains(err.Error(), ".bmc.users[0].password") {
+		t.Errorf("expected wrong identity error with the path, got %v", err)
+	}
+}
@@ -0,0 +1,20 @@
+package engine
+
+// Topology is the failure domain of a node, exposed to templates as .Node.Topology with the
+// keys region, zone and rack. Unset levels are left out.
+type Topology struct {
+	Region string `yaml:"region,omitempty"`
+	Zone   string `yaml:"zone,omitempty"`
+	Rack   string `yaml:"rack,omitempty"`
+}
+
+// Map returns the levels of the topology set for the node.
+func (t Topology) Map() map[string]interface{} {
+	m := map[string]interface{}{}
+	for key, value := range map[string]string{"region": t.Region, "zone": t.Zone, "rack": t.Rack} {
+		if value != "" {
+			m[key] = value
+		}
+	}
+	return m
+}
@@ -0,0 +1,56 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	"github.com/siderolabs/talos/pkg/machinery/config"
+)
+
+// ResolveTalosVersion returns the Talos version to render the config for.
+//
+// When the version is not configured, the version of the node in the context is used,
+// so the config matches the contract of the running Talos. Otherwise a warning is
+// printed if the configured version and the node version differ in major or minor.
+// If the node version can't be detected, the configured version is returned as is.
+func ResolveTalosVersion(ctx context.Context, c *client.Client, configured string) string {
+	resp, err := c.Version(ctx)
+	if err != nil || resp == nil || len(resp.Messages) == 0 {
+		fmt.Fprintf(os.Stderr, "Warning: failed to detect Talos version of the node: %v\n", err)
+		return configured
+	}
+
+	detected := resp.Messages[0].GetVersion().GetTag()
+	if configured == "" {
+		return detected
+	}
+
+	for _, message := range resp.Messages {
+		nodeVersion := message.GetVersion().GetTag()
+		if VersionSkew(configured, nodeVersion) {
+			node := message.GetMetadata().GetHostname()
+			if node == "" {
+				node = "the node"
+			}
+			fmt.Fprintf(os.Stderr, "Warning: talosVersion %s differs from Talos %s running on %s, the config may be invalid\n", configured, nodeVersion, node)
+		}
+	}
+
+	return configured
+}
+
+// VersionSkew reports whether the versions have different version contracts.
+func VersionSkew(a, b string) bool {
+	contractA, err := config.ParseContractFromVersion(a)
+	if err != nil {
+		return false
+	}
+	contractB, err := config.ParseContractFromVersion(b)
+	if err != nil {
+		return false
+	}
+
+	return contractA.Major != contractB.Major || contractA.Minor != contractB.Minor
+}
@@ -0,0 +1,19 @@
+package engine
+
+import "testing"
+
+func TestVersionSkew(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		want bool
+	}{
+		{"v1.7.1", "v1.7.4", false},
+		{"v1.7", "v1.7.0-beta.1", false},
+		{"v1.6.7", "v1.7.1", true},
+		{"v1.7.1", "unknown", false},
+	} {
+		if got := VersionSkew(tc.a, tc.b); got != tc.want {
+			t.Errorf("VersionSkew(%q, %q) = %v, want %v", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"gopkg.in/yaml.v3"
+)
+
+// vipConfig is the part of the machine config declaring VIPs.
+type vipConfig struct {
+	Machine struct {
+		Network struct {
+			Interfaces []struct {
+				Interface string    `yaml:"interface"`
+				VIP       *vipSpec  `yaml:"vip"`
+				VLANs     []vipVLAN `yaml:"vlans"`
+			} `yaml:"interfaces"`
+		} `yaml:"network"`
+	} `yaml:"machine"`
+}
+
+type vipSpec struct {
+	IP string `yaml:"ip"`
+}
+
+type vipVLAN struct {
+	VLANID int      `yaml:"vlanId"`
+	VIP    *vipSpec `yaml:"vip"`
+}
+
+type vipClaim struct {
+	file string
+	link string
+}
+
+// VIPClaims collects the VIPs of rendered node files, to detect a VIP claimed on different
+// links by different node files. Nodes sharing a VIP must declare it on the same link.
+// Interfaces matched by device selectors, like the default interface, are the same link
+// on every node.
+type VIPClaims map[string]vipClaim
+
+// Add records the VIPs of the rendered config of the node file, it returns an error if
+// another node file claims one of them on another link.
+func (claims VIPClaims) Add(file string, config []byte) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(config))
+	for {
+		var doc vipConfig
+		err := decoder.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		var typeErr *yaml.TypeError
+		if errors.As(err, &typeErr) {
+			// Documents other than the machine config may not match the structure
+			continue
+		}
+		if err != nil {
+			// Invalid configs are reported when they are loaded
+			return nil
+		}
+
+		for _, iface := range doc.Machine.Network.Interfaces {
+			link := "the interface matched by device selectors"
+			if iface.Interface != "" {
+				link = "interface " + iface.Interface
+			}
+			if iface.VIP != nil && iface.VIP.IP != "" {
+				if err := claims.claim(iface.VIP.IP, file, link); err != nil {
+					return err
+				}
+			}
+			for _, vlan := range iface.VLANs {
+				if vlan.VIP != nil && vlan.VIP.IP != "" {
+					if err := claims.claim(vlan.VIP.IP, file, fmt.Sprintf("VLAN %d of %s", vlan.VLANID, link)); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+}
+
+func (claims VIPClaims) claim(ip, file, link string) error {
+	if claim, ok := claims[ip]; ok && claim.link != link {
+		return fmt.Errorf("VIP %s is claimed on %s by %s and on %s by %s", ip, claim.link, claim.file, link, file)
+	}
+	if _, ok := claims[ip]; !ok {
+		claims[ip] = vipClaim{file: file, link: link}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVIPClaims(t *testing.T) {
+	node := func(iface string) []byte {
+		return []byte(`version: v1alpha1
+machine:
+  network:
+    interfaces:
+      - deviceSelector:
+          busPath: "0000:01:00.0"
+        vip:
+          ip: 10.0.0.10
+` + iface + `---
+apiVersion: v1alpha1
+kind: ExtensionServiceConfig
+name: example
+`)
+	}
+
+	claims := VIPClaims{}
+	if err := claims.Add("nodes/node1.yaml", node("")); err != nil {
+		t.Fatal(err)
+	}
+	// The default interface of another node shares the VIP
+	if err := claims.Add("nodes/node2.yaml", node(`      - interface: eth1
+        vlans:
+          - vlanId: 100
+            vip:
+              ip: 10.100.0.10
+`)); err != nil {
+		t.Fatal(err)
+	}
+
+	err := claims.Add("nodes/node3.yaml", node(`      - interface: eth1
+        vip:
+          ip: 10.100.0.10
+`))
+	if err == nil || !strings.Contains(err.Error(), "VIP 10.100.0.10 is claimed on VLAN 100 of interface eth1 by nodes/node2.yaml and on interface eth1 by nodes/node3.yaml") {
+		t.Errorf("expected a VIP conflict, got %v", err)
+	}
+}
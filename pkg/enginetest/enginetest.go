@@ -0,0 +1,96 @@
+// Package enginetest provides a fake Talos node and fixtures of discovered resources,
+// so charts can be rendered in Go tests without a live node:
+//
+//	node := enginetest.NewNode().
+//		WithDisks(enginetest.Disk("/dev/sda", "SAMSUNG MZ7LH960", 960197124096)).
+//		WithResources("links", enginetest.Link("eth0", "aa:bb:cc:00:00:01", "ixgbe", "0000:01:00.0")).
+//		WithResources("routes", enginetest.DefaultRoute("eth0", "10.0.0.1")).
+//		WithResources("addresses", enginetest.Address("eth0", "10.0.0.5/24"))
+//
+//	err := engine.RenderNode(ctx, node, opts, &buf)
+package enginetest
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/siderolabs/talos/pkg/machinery/api/storage"
+)
+
+// Node is a fake node implementing engine.Node.
+type Node struct {
+	mu        sync.Mutex
+	disks     []*storage.Disk
+	resources map[string][]map[string]interface{}
+	lookups   []string
+}
+
+// NewNode returns a node without disks and resources.
+func NewNode() *Node {
+	return &Node{resources: map[string][]map[string]interface{}{}}
+}
+
+// WithDisks adds the disks to the node.
+func (n *Node) WithDisks(disks ...*storage.Disk) *Node {
+	n.disks = append(n.disks, disks...)
+	return n
+}
+
+// WithResources adds the resources returned by the lookup of the kind, e.g. "links" or "routes".
+func (n *Node) WithResources(kind string, resources ...map[string]interface{}) *Node {
+	n.resources[kind] = append(n.resources[kind], resources...)
+	return n
+}
+
+// Disks returns the disks of the node.
+func (n *Node) Disks(ctx context.Context) ([]*storage.Disk, error) {
+	return n.disks, nil
+}
+
+// Lookup returns the resource of the kind with the id, or the list of all resources of the kind
+// if the id is empty, in the same format as the lookup of a live node. The namespace is matched
+// only if it is set.
+func (n *Node) Lookup(ctx context.Context, kind, namespace, id string) (map[string]interface{}, error) {
+	n.mu.Lock()
+	n.lookups = append(n.lookups, strings.Join([]string{kind, namespace, id}, "/"))
+	n.mu.Unlock()
+
+	var found []map[string]interface{}
+	for _, r := range n.resources[kind] {
+		metadata, _ := r["metadata"].(map[string]interface{})
+		if namespace != "" && metadata["namespace"] != namespace {
+			continue
+		}
+		if id != "" && metadata["id"] != id {
+			continue
+		}
+		found = append(found, r)
+	}
+
+	if len(found) == 0 {
+		return map[string]interface{}{}, nil
+	}
+	if id != "" && len(found) == 1 {
+		return found[0], nil
+	}
+
+	items := map[string]interface{}{}
+	for i, r := range found {
+		items["_"+strconv.Itoa(i)] = r
+	}
+	return map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "List",
+		"items":      items,
+	}, nil
+}
+
+// Lookups returns the lookups made by the templates as kind/namespace/id.
+func (n *Node) Lookups() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return append([]string(nil), n.lookups...)
+}
@@ -0,0 +1,140 @@
+package enginetest_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aenix-io/talm/pkg/engine"
+	"github.com/aenix-io/talm/pkg/enginetest"
+)
+
+func TestRenderNode(t *testing.T) {
+	node := enginetest.NewNode().
+		WithDisks(enginetest.Disk("/dev/nvme0n1", "SAMSUNG MZQL2960", 960197124096)).
+		WithResources("hostname", enginetest.Hostname("cp-1")).
+		WithResources("machinetype", enginetest.MachineType("controlplane")).
+		WithResources("links", enginetest.Link("eth0", "aa:bb:cc:00:00:01", "ixgbe", "0000:01:00.0")).
+		WithResources("routes", enginetest.DefaultRoute("eth0", "10.0.0.1")).
+		WithResources("addresses", enginetest.Address("eth0", "10.0.0.5/24"))
+
+	opts := engine.Options{
+		Root:              "../../charts/generic",
+		TalosVersion:      "v1.7",
+		KubernetesVersion: "v1.30.0",
+		TemplateFiles:     []string{"templates/controlplane.yaml"},
+	}
+
+	var buf bytes.Buffer
+	if err := engine.RenderNode(context.Background(), node, opts, &buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	for _, expected := range []string{"disk: /dev/nvme0n1", "hostname: cp-1", "10.0.0.5/24", "gateway: 10.0.0.1", "# /dev/nvme0n1:"} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in output:\n%s", expected, out)
+		}
+	}
+
+	if len(node.Lookups()) == 0 {
+		t.Error("no lookups recorded")
+	}
+}
+
+func TestLookup(t *testing.T) {
+	node := enginetest.NewNode().WithResources("links",
+		enginetest.Link("eth0", "aa:bb:cc:00:00:01", "ixgbe", "0000:01:00.0"),
+		enginetest.Link("eth1", "aa:bb:cc:00:00:02", "ixgbe", "0000:01:00.1"),
+	)
+
+	link, err := node.Lookup(context.Background(), "links", "", "eth1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link["spec"].(map[string]interface{})["hardwareAddr"] != "aa:bb:cc:00:00:02" {
+		t.Errorf("unexpected link %v", link)
+	}
+
+	list, err := node.Lookup(context.Background(), "links", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if items := list["items"].(map[string]interface{}); len(items) != 2 {
+		t.Errorf("unexpected list %v", list)
+	}
+
+	missing, err := node.Lookup(context.Background(), "links", "network", "eth2")
+	if err != nil || len(missing) != 0 {
+		t.Errorf("expected empty result, got %v, %v", missing, err)
+	}
+}
+
+func TestRenderGPUWorker(t *testing.T) {
+	node := enginetest.NewNode().
+		WithDisks(enginetest.Disk("/dev/nvme0n1", "SAMSUNG MZQL2960", 960197124096)).
+		WithResources("hostname", enginetest.Hostname("gpu-1")).
+		WithResources("machinetype", enginetest.MachineType("worker")).
+		WithResources("links", enginetest.Link("eth0", "aa:bb:cc:00:00:01", "ixgbe", "0000:01:00.0")).
+		WithResources("routes", enginetest.DefaultRoute("eth0", "192.168.100.1")).
+		WithResources("addresses", enginetest.Address("eth0", "192.168.100.21/24")).
+		WithResources("cpus", enginetest.Processor("CPU0", 16, 32)).
+		WithResources("memorymodules", enginetest.MemoryModule("DIMM0", 65536))
+
+	render := func(template string, values ...string) (string, error) {
+		opts := engine.Options{
+			Root:              "../../charts/gpu-worker",
+			TalosVersion:      "v1.7",
+			KubernetesVersion: "v1.30.0",
+			TemplateFiles:     []string{template},
+			Values:            values,
+		}
+		var buf bytes.Buffer
+		err := engine.RenderNode(context.Background(), node, opts, &buf)
+		return buf.String(), err
+	}
+
+	worker, err := render("templates/worker.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		// The image of the schematic with siderolabs/nonfree-kmod-nvidia and siderolabs/nvidia-container-toolkit
+		"image: factory.talos.dev/installer/",
+		":v1.7.6",
+		"- name: nvidia_uvm",
+		"nvidia.com/gpu.present: \"true\"",
+		"maxPods: 250",
+		"hostname: gpu-1",
+		"disk: /dev/nvme0n1",
+	} {
+		if !strings.Contains(worker, expected) {
+			t.Errorf("expected %q in the worker config:\n%s", expected, worker)
+		}
+	}
+
+	controlPlane, err := render("templates/controlplane.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"name: nvidia-runtime-class", "kind: RuntimeClass", "handler: nvidia", "advertisedSubnets:"} {
+		if !strings.Contains(controlPlane, expected) {
+			t.Errorf("expected %q in the control plane config:\n%s", expected, controlPlane)
+		}
+	}
+	if strings.Contains(controlPlane, "nvidia_uvm") || strings.Contains(controlPlane, "nvidia.com/gpu.present") {
+		t.Errorf("expected no GPU settings in the control plane config:\n%s", controlPlane)
+	}
+
+	if _, err := render("templates/worker.yaml", "schematic.extensions={siderolabs/iscsi-tools}"); err == nil || !strings.Contains(err.Error(), "must include siderolabs/nonfree-kmod-nvidia") {
+		t.Errorf("expected an error without the NVIDIA extensions, got %v", err)
+	}
+	if _, err := render("templates/worker.yaml", "schematic=null"); err == nil || !strings.Contains(err.Error(), "the GPU workers need the NVIDIA extensions") {
+		t.Errorf("expected an error without an installer image, got %v", err)
+	}
+	custom, err := render("templates/worker.yaml", "schematic=null", "image=registry.example.com/installer:v1.7.6")
+	if err != nil || !strings.Contains(custom, "image: registry.example.com/installer:v1.7.6") {
+		t.Errorf("expected the custom image without schematic, got %v:\n%s", err, custom)
+	}
+}
@@ -0,0 +1,108 @@
+package enginetest
+
+import (
+	"strings"
+
+	"github.com/siderolabs/talos/pkg/machinery/api/storage"
+)
+
+// Disk returns a non-removable SSD.
+func Disk(deviceName, model string, size uint64) *storage.Disk {
+	return &storage.Disk{
+		DeviceName: deviceName,
+		Model:      model,
+		Size:       size,
+		Type:       storage.Disk_SSD,
+	}
+}
+
+// Resource returns a resource in the format of the lookup of a live node.
+func Resource(namespace, resourceType, id string, spec interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"namespace": namespace,
+			"type":      resourceType,
+			"id":        id,
+		},
+		"spec": spec,
+	}
+}
+
+// Link returns the status of a physical link which is up.
+func Link(name, hardwareAddr, driver, busPath string) map[string]interface{} {
+	return Resource("network", "LinkStatuses.net.talos.dev", name, map[string]interface{}{
+		"index":            1,
+		"type":             "ether",
+		"kind":             "",
+		"hardwareAddr":     hardwareAddr,
+		"driver":           driver,
+		"busPath":          busPath,
+		"operationalState": "up",
+		"linkState":        true,
+	})
+}
+
+// DefaultRoute returns the IPv4 default route via the gateway.
+func DefaultRoute(outLinkName, gateway string) map[string]interface{} {
+	return Resource("network", "RouteStatuses.net.talos.dev", "inet4/"+gateway+"//1024", map[string]interface{}{
+		"family":      "inet4",
+		"dst":         "",
+		"gateway":     gateway,
+		"outLinkName": outLinkName,
+		"table":       "main",
+		"priority":    1024,
+		"scope":       "global",
+	})
+}
+
+// Address returns the global address in CIDR notation assigned to the link.
+func Address(linkName, address string) map[string]interface{} {
+	family := "inet4"
+	if strings.Contains(address, ":") {
+		family = "inet6"
+	}
+
+	return Resource("network", "AddressStatuses.net.talos.dev", linkName+"/"+address, map[string]interface{}{
+		"address":  address,
+		"linkName": linkName,
+		"family":   family,
+		"scope":    "global",
+	})
+}
+
+// NodeAddress returns the default node addresses in CIDR notation.
+func NodeAddress(addresses ...string) map[string]interface{} {
+	return Resource("network", "NodeAddresses.net.talos.dev", "default", map[string]interface{}{
+		"addresses": addresses,
+	})
+}
+
+// Hostname returns the hostname status of the node.
+func Hostname(hostname string) map[string]interface{} {
+	return Resource("network", "HostnameStatuses.net.talos.dev", "hostname", map[string]interface{}{
+		"hostname":   hostname,
+		"domainname": "",
+	})
+}
+
+// MachineType returns the machine type of the node, e.g. "controlplane" or "worker".
+func MachineType(machineType string) map[string]interface{} {
+	return Resource("config", "MachineTypes.config.talos.dev", "machine-type", machineType)
+}
+
+// Processor returns a processor in the socket with the number of cores and threads.
+func Processor(socket string, cores, threads int) map[string]interface{} {
+	return Resource("hardware", "Processors.hardware.talos.dev", socket, map[string]interface{}{
+		"socket":      socket,
+		"coreCount":   cores,
+		"threadCount": threads,
+	})
+}
+
+// MemoryModule returns a memory module in the slot with the size in MiB.
+func MemoryModule(slot string, sizeMiB int) map[string]interface{} {
+	return Resource("hardware", "MemoryModules.hardware.talos.dev", slot, map[string]interface{}{
+		"deviceLocator": slot,
+		"sizeMiB":       sizeMiB,
+	})
+}
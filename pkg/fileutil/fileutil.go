@@ -0,0 +1,127 @@
+// Package fileutil handles paths and files in a way that works the same on Windows and Unix.
+//
+// Node files, modelines and Chart.yaml are shared between operators through git,
+// so paths stored in them always use forward slashes regardless of the OS they were written on.
+package fileutil
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ToSlash replaces backslashes with forward slashes on every OS, unlike filepath.ToSlash.
+func ToSlash(p string) string {
+	return strings.ReplaceAll(p, `\`, "/")
+}
+
+// IsAbs reports whether the path is absolute on Unix or Windows,
+// including paths with a drive letter (C:\dir) and UNC paths (\\host\share).
+func IsAbs(p string) bool {
+	p = ToSlash(p)
+	return strings.HasPrefix(p, "/") || hasDriveLetter(p) && len(p) > 2 && p[2] == '/'
+}
+
+func hasDriveLetter(p string) bool {
+	if len(p) < 2 || p[1] != ':' {
+		return false
+	}
+	c := p[0]
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// TrimRoot returns the slash separated path of p relative to root.
+//
+// Absolute paths inside the root are made relative to it, other paths are only cleaned.
+// Windows paths are compared case-insensitively.
+func TrimRoot(root, p string) string {
+	if IsAbs(p) {
+		if !IsAbs(root) {
+			if abs, err := filepath.Abs(root); err == nil {
+				root = abs
+			}
+		}
+
+		slashRoot := strings.TrimSuffix(path.Clean(ToSlash(root)), "/") + "/"
+		slashPath := path.Clean(ToSlash(p))
+		if len(slashPath) > len(slashRoot) {
+			prefix := slashPath[:len(slashRoot)]
+			if prefix == slashRoot || hasDriveLetter(slashRoot) && strings.EqualFold(prefix, slashRoot) {
+				return slashPath[len(slashRoot):]
+			}
+		}
+	}
+
+	return path.Clean(ToSlash(p))
+}
+
+// UsesCRLF reports whether the lines of data end with CRLF.
+func UsesCRLF(data []byte) bool {
+	i := bytes.IndexByte(data, '\n')
+	return i > 0 && data[i-1] == '\r'
+}
+
+// ToCRLF converts LF line endings to CRLF, leaving existing CRLF line endings as is.
+func ToCRLF(data []byte) []byte {
+	var buf bytes.Buffer
+	buf.Grow(len(data) + bytes.Count(data, []byte("\n")))
+	for i, c := range data {
+		if c == '\n' && (i == 0 || data[i-1] != '\r') {
+			buf.WriteByte('\r')
+		}
+		buf.WriteByte(c)
+	}
+	return buf.Bytes()
+}
+
+// renameAttempts and renameDelay bound the retries when the target file is locked,
+// e.g. by an editor or an antivirus scanner on Windows.
+const (
+	renameAttempts = 10
+	renameDelay    = 100 * time.Millisecond
+)
+
+// WriteFile replaces the file atomically: the data is written to a temporary file
+// in the same directory, which is renamed over the target.
+//
+// If the existing file uses CRLF line endings, as git does on Windows with core.autocrlf,
+// they are kept, so rewriting a file doesn't show up as a change of every line.
+func WriteFile(name string, data []byte, perm os.FileMode) error {
+	if existing, err := os.ReadFile(name); err == nil && UsesCRLF(existing) && !UsesCRLF(data) {
+		data = ToCRLF(data)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) //nolint:errcheck
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close() //nolint:errcheck
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		err = os.Rename(tmp.Name(), name)
+		if err == nil || !isLocked(err) || attempt == renameAttempts {
+			break
+		}
+		time.Sleep(renameDelay)
+	}
+	if err != nil && isLocked(err) {
+		return fmt.Errorf("failed to replace %s, the file is locked by another process: %w", name, err)
+	}
+
+	return err
+}
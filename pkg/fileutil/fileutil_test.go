@@ -0,0 +1,82 @@
+package fileutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsAbs(t *testing.T) {
+	for p, want := range map[string]bool{
+		"/etc/talm":                   true,
+		`C:\Users\admin\cluster`:      true,
+		"c:/cluster":                  true,
+		`\\server\share\cluster`:      true,
+		"C:cluster":                   false,
+		"templates/controlplane.yaml": false,
+		`templates\controlplane.yaml`: false,
+		"":                            false,
+	} {
+		if got := IsAbs(p); got != want {
+			t.Errorf("IsAbs(%q) = %v, want %v", p, got, want)
+		}
+	}
+}
+
+func TestTrimRoot(t *testing.T) {
+	for _, tc := range []struct {
+		root, path, want string
+	}{
+		{".", "templates/controlplane.yaml", "templates/controlplane.yaml"},
+		{".", `templates\controlplane.yaml`, "templates/controlplane.yaml"},
+		{".", `.\templates\worker.yaml`, "templates/worker.yaml"},
+		{`C:\Users\admin\cluster`, `C:\Users\admin\cluster\templates\worker.yaml`, "templates/worker.yaml"},
+		{`C:\Users\admin\cluster\`, `c:\users\Admin\cluster\templates\worker.yaml`, "templates/worker.yaml"},
+		{`C:\Users\admin\cluster`, `D:\cluster\templates\worker.yaml`, "D:/cluster/templates/worker.yaml"},
+		{`C:\Users\admin\cluster`, `C:\Users\admin\cluster2\worker.yaml`, "C:/Users/admin/cluster2/worker.yaml"},
+		{"/home/admin/cluster", "/home/admin/cluster/templates/worker.yaml", "templates/worker.yaml"},
+		{"/home/admin/cluster", "/home/admin/Cluster/templates/worker.yaml", "/home/admin/Cluster/templates/worker.yaml"},
+	} {
+		if got := TrimRoot(tc.root, tc.path); got != tc.want {
+			t.Errorf("TrimRoot(%q, %q) = %q, want %q", tc.root, tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestToCRLF(t *testing.T) {
+	got := string(ToCRLF([]byte("a\nb\r\nc\n")))
+	if want := "a\r\nb\r\nc\r\n"; got != want {
+		t.Errorf("ToCRLF() = %q, want %q", got, want)
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "node.yaml")
+
+	if err := WriteFile(name, []byte("a: 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte("a: 1\r\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Line endings of the existing file are kept
+	if err := WriteFile(name, []byte("a: 2\nb: 3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "a: 2\r\nb: 3\r\n" {
+		t.Errorf("unexpected content %q", data)
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("temporary file is left behind: %v", entries)
+	}
+}
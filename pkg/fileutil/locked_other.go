@@ -0,0 +1,8 @@
+//go:build !windows
+
+package fileutil
+
+// isLocked is always false, files are not locked against renames on Unix.
+func isLocked(error) bool {
+	return false
+}
@@ -0,0 +1,15 @@
+//go:build windows
+
+package fileutil
+
+import (
+	"errors"
+	"syscall"
+)
+
+// errorSharingViolation is ERROR_SHARING_VIOLATION, returned when the file is open by another process.
+const errorSharingViolation syscall.Errno = 32
+
+func isLocked(err error) bool {
+	return errors.Is(err, errorSharingViolation) || errors.Is(err, syscall.ERROR_ACCESS_DENIED)
+}
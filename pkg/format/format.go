@@ -0,0 +1,247 @@
+// Package format normalizes the style of chart templates and values files without changing
+// their meaning: the parse tree of a template and the data of a values file are compared
+// before and after formatting.
+package format
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template/parse"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Template formats a Go template: the actions get a single space inside the delimiters and
+// around pipes, and runs of blanks inside actions are collapsed. The text outside the actions
+// is rendered as it is, so it is kept, and so are string literals, comments and the indentation
+// of multi-line actions.
+func Template(name string, src []byte) ([]byte, error) {
+	out := formatActions(src)
+
+	before, err := parseTree(name, src)
+	if err != nil {
+		return nil, err
+	}
+	after, err := parseTree(name, out)
+	if err != nil {
+		return nil, fmt.Errorf("%s: formatting broke the template: %w", name, err)
+	}
+	if before != after {
+		return nil, fmt.Errorf("%s: formatting changed the template, it is left as is", name)
+	}
+	return out, nil
+}
+
+// Values formats a YAML values file: line endings, trailing blanks and the final newline are
+// normalized. Comments and the layout of the documents are kept.
+func Values(name string, src []byte) ([]byte, error) {
+	out := normalizeText(normalizeLineEndings(src))
+
+	before, err := decodeAll(src)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	after, err := decodeAll(out)
+	if err != nil {
+		return nil, fmt.Errorf("%s: formatting broke the values: %w", name, err)
+	}
+	if before != after {
+		return nil, fmt.Errorf("%s: formatting changed the values, it is left as is", name)
+	}
+	return out, nil
+}
+
+func normalizeLineEndings(src []byte) []byte {
+	return bytes.ReplaceAll(src, []byte("\r\n"), []byte("\n"))
+}
+
+var trailingBlanks = regexp.MustCompile(`(?m)[ \t]+$`)
+
+// normalizeText removes the trailing blanks of the lines and ends the text with a single newline.
+func normalizeText(src []byte) []byte {
+	out := trailingBlanks.ReplaceAll(src, nil)
+	out = bytes.TrimRight(out, "\n")
+	if len(out) == 0 {
+		return out
+	}
+	return append(out, '\n')
+}
+
+// formatActions rewrites the actions of the template, the text between them is copied.
+func formatActions(src []byte) []byte {
+	var out bytes.Buffer
+	for {
+		start := bytes.Index(src, []byte("{{"))
+		if start < 0 {
+			out.Write(src)
+			return out.Bytes()
+		}
+		out.Write(src[:start])
+		src = src[start:]
+
+		end := actionEnd(src)
+		if end < 0 {
+			// Unterminated, reported by the parser
+			out.Write(src)
+			return out.Bytes()
+		}
+		out.WriteString(formatAction(string(src[:end])))
+		src = src[end:]
+	}
+}
+
+// actionEnd returns the length of the action at the start of src, skipping the delimiters in
+// string literals and comments, or -1 if it is not terminated.
+func actionEnd(src []byte) int {
+	i := 2
+	if bytes.HasPrefix(src[i:], []byte("- /*")) || bytes.HasPrefix(src[i:], []byte("/*")) {
+		end := bytes.Index(src[i:], []byte("*/"))
+		if end < 0 {
+			return -1
+		}
+		i += end + 2
+	}
+	for i < len(src) {
+		switch c := src[i]; c {
+		case '"', '\'', '`':
+			j := i + 1
+			for j < len(src) && src[j] != c {
+				if src[j] == '\\' && c != '`' {
+					j++
+				}
+				j++
+			}
+			i = j + 1
+		case '}':
+			if i+1 < len(src) && src[i+1] == '}' {
+				return i + 2
+			}
+			i++
+		default:
+			i++
+		}
+	}
+	return -1
+}
+
+// formatAction normalizes the blanks of an action outside its string literals.
+func formatAction(action string) string {
+	body := action[2 : len(action)-2]
+	open, close := "{{", "}}"
+	if strings.HasPrefix(body, "- ") || strings.HasPrefix(body, "-\t") || strings.HasPrefix(body, "-\n") {
+		open, body = "{{-", body[1:]
+	}
+	if strings.HasSuffix(body, " -") || strings.HasSuffix(body, "\t-") || strings.HasSuffix(body, "\n-") {
+		close, body = "-}}", body[:len(body)-1]
+	}
+
+	trimmed := strings.TrimSpace(body)
+	if trimmed == "" || strings.HasPrefix(trimmed, "/*") {
+		// The blanks around comments are fixed by the template syntax
+		return action
+	}
+
+	var out strings.Builder
+	lineStart := false
+	for i := 0; i < len(trimmed); i++ {
+		c := trimmed[i]
+		switch {
+		case c == '"' || c == '\'' || c == '`':
+			j := i + 1
+			for j < len(trimmed) && trimmed[j] != c {
+				if trimmed[j] == '\\' && c != '`' {
+					j++
+				}
+				j++
+			}
+			if j >= len(trimmed) {
+				j = len(trimmed) - 1
+			}
+			out.WriteString(trimmed[i : j+1])
+			i = j
+			lineStart = false
+		case c == '\n':
+			// Keep the indentation of the lines of multi-line actions
+			s := strings.TrimRight(out.String(), " \t")
+			out.Reset()
+			out.WriteString(s)
+			out.WriteByte('\n')
+			lineStart = true
+		case c == ' ' || c == '\t':
+			j := i
+			for j < len(trimmed) && (trimmed[j] == ' ' || trimmed[j] == '\t') {
+				j++
+			}
+			if lineStart {
+				out.WriteString(trimmed[i:j])
+			} else if j < len(trimmed) && trimmed[j] != '\n' {
+				out.WriteByte(' ')
+			}
+			i = j - 1
+		case c == '|' && !lineStart:
+			s := strings.TrimRight(out.String(), " \t")
+			out.Reset()
+			out.WriteString(s)
+			if !strings.HasSuffix(s, "\n") && s != "" {
+				out.WriteByte(' ')
+			}
+			out.WriteByte('|')
+			if i+1 < len(trimmed) && trimmed[i+1] != ' ' && trimmed[i+1] != '\t' && trimmed[i+1] != '\n' {
+				out.WriteByte(' ')
+			}
+			lineStart = false
+		default:
+			out.WriteByte(c)
+			lineStart = false
+		}
+	}
+
+	return open + " " + out.String() + " " + close
+}
+
+// parseTree returns the canonical form of the parse trees of the template and its defines.
+func parseTree(name string, src []byte) (string, error) {
+	tree := parse.New(name)
+	tree.Mode = parse.SkipFuncCheck | parse.ParseComments
+	treeSet := map[string]*parse.Tree{}
+	if _, err := tree.Parse(string(src), "{{", "}}", treeSet); err != nil {
+		return "", err
+	}
+
+	var names []string
+	for n := range treeSet {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	var out strings.Builder
+	for _, n := range names {
+		if treeSet[n].Root == nil {
+			continue
+		}
+		fmt.Fprintf(&out, "%s: %s\n", n, treeSet[n].Root.String())
+	}
+	return out.String(), nil
+}
+
+func decodeAll(src []byte) (string, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(src))
+	var docs []interface{}
+	for {
+		var doc interface{}
+		err := decoder.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		docs = append(docs, doc)
+	}
+	return fmt.Sprintf("%#v", docs), nil
+}
@@ -0,0 +1,81 @@
+package format
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTemplate(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "spacing",
+			src:  "a: {{.Values.a}}\nb: {{-   .Values.b   -}}\nc: {{ .Values.c|quote }}\n",
+			want: "a: {{ .Values.a }}\nb: {{- .Values.b -}}\nc: {{ .Values.c | quote }}\n",
+		},
+		{
+			name: "nindent",
+			src:  "labels:\n  {{-  toYaml .Values.labels |nindent 2}}\n",
+			want: "labels:\n  {{- toYaml .Values.labels | nindent 2 }}\n",
+		},
+		{
+			name: "strings",
+			src:  "a: {{ printf \"{{ %s|%s }}\"   .a .b }}\nb: {{ 'x' }}\n",
+			want: "a: {{ printf \"{{ %s|%s }}\" .a .b }}\nb: {{ 'x' }}\n",
+		},
+		{
+			name: "multi-line",
+			src:  "{{- $a := dict\n  \"x\"  1   \n  \"y\" (list 1 2) }}\n",
+			want: "{{- $a := dict\n  \"x\" 1\n  \"y\" (list 1 2) }}\n",
+		},
+		{
+			name: "comments",
+			src:  "{{/* keep  this */}}\n{{- /* and  this */ -}}\n",
+			want: "{{/* keep  this */}}\n{{- /* and  this */ -}}\n",
+		},
+		{
+			name: "text",
+			src:  "key: value   \n\n\n{{ define \"x\" }}y{{end}}",
+			want: "key: value   \n\n\n{{ define \"x\" }}y{{ end }}",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Template(tt.name, []byte(tt.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Template() = %q, want %q", got, tt.want)
+			}
+			again, err := Template(tt.name, got)
+			if err != nil || string(again) != string(got) {
+				t.Errorf("Template() is not idempotent: %q, %v", again, err)
+			}
+		})
+	}
+
+	if _, err := Template("broken", []byte("{{ if .a }}")); err == nil {
+		t.Error("expected an error for an unterminated if")
+	}
+}
+
+func TestValues(t *testing.T) {
+	got, err := Values("values.yaml", []byte("# comment   \r\na:\n  b: 1  \n  c: [1, 2]\n\n\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# comment\na:\n  b: 1\n  c: [1, 2]\n"
+	if string(got) != want {
+		t.Errorf("Values() = %q, want %q", got, want)
+	}
+
+	// Trailing blanks of block scalars are data
+	_, err = Values("values.yaml", []byte("a: |\n  x  \n"))
+	if err == nil || !strings.Contains(err.Error(), "changed the values") {
+		t.Errorf("Values() error = %v, want changed the values", err)
+	}
+}
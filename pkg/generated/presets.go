@@ -15,6 +15,8 @@ templateOptions:
   fileValues: []
   jsonValues: []
   literalValues: []
+  envValues: []
+  envAllowlist: []
   talosVersion: "v1.7"
   withSecrets: "secrets.yaml"
   kubernetesVersion: ""
@@ -27,16 +29,38 @@ upgradeOptions:
   preserve: false
   stage: false
   force: false
+`,
+	"cozystack/templates/NOTES.txt": `Next steps:
+{{- if eq .Command "init" }}
+
+- Gather the information of a booted node and save its config:
+     talm -n <node-ip> -e <node-ip> template -t templates/controlplane.yaml -i > nodes/node1.yaml
+
+- Apply the config to the node running in maintenance mode:
+     talm apply -f nodes/node1.yaml -i
+{{- end }}
+
+- Bootstrap etcd on the first control plane node, only once for the cluster:
+     talm bootstrap -f nodes/node1.yaml
+
+- Retrieve the kubeconfig, the API server is available at {{ .Values.endpoint }}:
+     talm kubeconfig -f nodes/node1.yaml
 `,
 	"cozystack/templates/_helpers.tpl": `{{- define "talos.config" }}
 machine:
   type: {{ .MachineType }}
+  certSANs: {{ include "talm.cert_sans" . }}
+  {{- include "talm.node_metadata" . | nindent 2 }}
   kubelet:
     nodeIP:
       validSubnets:
-        {{- toYaml .Values.advertisedSubnets | nindent 8 }}
+        {{- include "talm.advertised_subnets" . | nindent 8 }}
     extraConfig:
+      {{- with include "talm.kubelet.resources" . }}
+      {{- . | nindent 6 }}
+      {{- else }}
       maxPods: 512
+      {{- end }}
   kernel:
     modules:
     - name: openvswitch
@@ -53,37 +77,34 @@ machine:
     path: /etc/cri/conf.d/20-customization.part
     op: create
   install:
-    {{- with .Values.image }}
+    {{- with include "talm.installer_image" . }}
     image: {{ . }}
     {{- end }}
     {{- (include "talm.discovered.disks_info" .) | nindent 4 }}
     disk: {{ include "talm.discovered.system_disk_name" . | quote }}
+  {{- with include "talm.secureboot.disk_encryption" . }}
+  {{- . | nindent 2 }}
+  {{- end }}
+  {{- with include "talm.static_pods" . }}
+  {{- . | nindent 2 }}
+  {{- end }}
   network:
     hostname: {{ include "talm.discovered.hostname" . | quote }}
     nameservers: {{ include "talm.discovered.default_resolvers" . }}
     {{- (include "talm.discovered.physical_links_info" .) | nindent 4 }}
     interfaces:
-    - deviceSelector:
-        {{- include "talm.discovered.default_link_selector_by_gateway" . | nindent 8 }}
-      addresses: {{ include "talm.discovered.default_addresses_by_gateway" . }}
-      routes:
-        - network: 0.0.0.0/0
-          gateway: {{ include "talm.discovered.default_gateway" . }}
-      {{- with .Values.floatingIP }}
-      vip:
-        ip: {{ . }}
-      {{- end }}
+    {{- include "talm.network.default_interface" . | nindent 4 }}
+    {{- include "talm.network.check_discovered" . }}
 
 
 cluster:
   network:
-    cni:
-      name: none
+    {{- include "talm.cni" . | nindent 4 }}
     dnsDomain: {{ .Values.clusterDomain }}
     podSubnets:
-      {{- toYaml .Values.podSubnets | nindent 6 }}
+      {{- .Values.podSubnets | assertCIDR "podSubnets" | toYaml | nindent 6 }}
     serviceSubnets:
-      {{- toYaml .Values.serviceSubnets | nindent 6 }}
+      {{- .Values.serviceSubnets | assertCIDR "serviceSubnets" | toYaml | nindent 6 }}
   clusterName: "{{ .Chart.Name }}"
   controlPlane:
     endpoint: "{{ .Values.endpoint }}"
@@ -96,15 +117,19 @@ cluster:
     extraArgs:
       bind-address: 0.0.0.0
   apiServer:
-    certSANs:
-    - 127.0.0.1
+    certSANs: {{ include "talm.cert_sans" . }}
+  {{- if include "talm.kube_proxy_disabled" . }}
   proxy:
     disabled: true
+  {{- end }}
   discovery:
     enabled: false
+  {{- with include "talm.manifests" . }}
+  {{- . | nindent 2 }}
+  {{- end }}
   etcd:
     advertisedSubnets:
-      {{- toYaml .Values.advertisedSubnets | nindent 6 }}
+      {{- include "talm.advertised_subnets" . | nindent 6 }}
   {{- end }}
 {{- end }}
 `,
@@ -113,6 +138,193 @@ cluster:
 `,
 	"cozystack/templates/worker.yaml": `{{- $_ := set . "MachineType" "worker" -}}
 {{- include "talos.config" . }}
+`,
+	"cozystack/values.schema.json": `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "type": "object",
+  "properties": {
+    "bond": {
+      "description": "Bond the uplinks into a single interface instead of configuring the default link",
+      "type": "object",
+      "additionalProperties": false,
+      "properties": {
+        "interface": {"type": "string", "description": "Bond interface name, bond0 by default"},
+        "mode": {
+          "type": "string",
+          "enum": ["balance-rr", "active-backup", "balance-xor", "broadcast", "802.3ad", "balance-tlb", "balance-alb"]
+        },
+        "xmitHashPolicy": {"type": "string", "enum": ["layer2", "layer3+4", "layer2+3", "encap2+3", "encap3+4"]},
+        "lacpRate": {"type": "string", "enum": ["slow", "fast"]},
+        "miimon": {"type": "integer", "minimum": 0},
+        "mtu": {"type": "integer", "minimum": 68},
+        "members": {
+          "description": "MAC addresses or device selectors of the members, discovered if empty",
+          "type": "array",
+          "items": {
+            "oneOf": [
+              {"type": "string", "pattern": "^([0-9A-Fa-f]{2}:){5}[0-9A-Fa-f]{2}$"},
+              {"type": "object"}
+            ]
+          }
+        },
+        "memberDriver": {"type": "string", "description": "Use the physical links with this kernel driver as members"},
+        "addresses": {"type": "array", "items": {"type": "string"}},
+        "gateway": {"type": "string"}
+      }
+    },
+    "vlans": {
+      "description": "VLANs attached to the default interface",
+      "type": "array",
+      "items": {
+        "type": "object",
+        "required": ["vlanId"],
+        "additionalProperties": false,
+        "properties": {
+          "vlanId": {"type": "integer", "minimum": 1, "maximum": 4094},
+          "addresses": {"type": "array", "items": {"type": "string"}},
+          "dhcp": {"type": "boolean"},
+          "mtu": {"type": "integer", "minimum": 68},
+          "routes": {"type": "array", "items": {"type": "object"}},
+          "vip": {"type": "string"}
+        }
+      }
+    },
+    "vips": {
+      "description": "VIPs shared by the nodes, on the default interface unless another interface is set, on its VLAN if vlanId is set",
+      "type": "array",
+      "items": {
+        "type": "object",
+        "required": ["ip"],
+        "additionalProperties": false,
+        "properties": {
+          "ip": {"type": "string"},
+          "interface": {"type": "string"},
+          "vlanId": {"type": "integer", "minimum": 1, "maximum": 4094}
+        }
+      }
+    },
+    "cni": {
+      "description": "CNI of the cluster and kube-proxy",
+      "type": "object",
+      "additionalProperties": false,
+      "properties": {
+        "name": {"type": "string", "enum": ["flannel", "cilium", "calico", "custom", "none"], "description": "flannel by default"},
+        "urls": {"type": "array", "items": {"type": "string"}, "description": "Manifests of the CNI installed by Talos"},
+        "kubeProxy": {"type": "boolean", "description": "Run kube-proxy, disabled by default for cilium"},
+        "external": {"type": "boolean", "description": "The CNI is installed after bootstrap, e.g. with Helm"}
+      }
+    },
+    "nodeLabels": {
+      "description": "Kubernetes labels of the nodes",
+      "type": "object",
+      "additionalProperties": {"type": ["string", "boolean", "number", "null"]}
+    },
+    "nodeAnnotations": {
+      "description": "Kubernetes annotations of the nodes, set by talm nodes sync-labels --fix",
+      "type": "object",
+      "additionalProperties": {"type": ["string", "boolean", "number", "null"]}
+    },
+    "nodeTaints": {
+      "description": "Kubernetes taints of the nodes, value:effect like in machine.nodeTaints",
+      "type": "object",
+      "additionalProperties": {"type": "string", "pattern": ":(NoSchedule|PreferNoSchedule|NoExecute)$"}
+    },
+    "nodeGroups": {
+      "description": "Default node labels, annotations and taints by machine type",
+      "type": "object",
+      "additionalProperties": {
+        "type": "object",
+        "additionalProperties": false,
+        "properties": {
+          "nodeLabels": {"type": "object"},
+          "nodeAnnotations": {"type": "object"},
+          "nodeTaints": {"type": "object"}
+        }
+      }
+    },
+    "sizeClass": {
+      "description": "Size class of the nodes setting the kubelet resources, discovered from the hardware when empty",
+      "type": "string",
+      "enum": ["", "small", "medium", "large"]
+    },
+    "zones": {
+      "description": "Settings of the zones of .Node.Topology, set in .talm/nodes.yaml",
+      "type": "object",
+      "additionalProperties": {
+        "type": "object",
+        "additionalProperties": false,
+        "properties": {
+          "advertisedSubnets": {"type": "array", "items": {"type": "string"}, "description": "Subnets of the nodes of the zone, advertisedSubnets by default"}
+        }
+      }
+    },
+    "certSANs": {
+      "description": "Extra names and addresses of the Talos and Kubernetes API certificates",
+      "type": "array",
+      "items": {"type": "string"}
+    },
+    "schematic": {
+      "description": "System extensions and kernel args of the installer image built by the image factory",
+      "type": "object",
+      "additionalProperties": false,
+      "properties": {
+        "factory": {"type": "string", "description": "Image factory host, factory.talos.dev by default"},
+        "version": {"type": "string", "description": "Talos version of the installer image, e.g. v1.7.1"},
+        "extensions": {"type": "array", "items": {"type": "string"}, "description": "Official extensions, e.g. siderolabs/iscsi-tools"},
+        "extraKernelArgs": {"type": "array", "items": {"type": "string"}}
+      }
+    },
+    "manifests": {
+      "description": "Manifests applied by Talos on bootstrap and static pods of the nodes",
+      "type": "object",
+      "additionalProperties": false,
+      "properties": {
+        "extra": {
+          "description": "URLs of the manifests fetched by the nodes, checked when rendering online",
+          "type": "array",
+          "items": {
+            "oneOf": [
+              {"type": "string"},
+              {
+                "type": "object",
+                "required": ["url"],
+                "additionalProperties": false,
+                "properties": {
+                  "url": {"type": "string"},
+                  "sha256": {"type": "string", "pattern": "^[0-9a-fA-F]{64}$", "description": "Checksum of the content of the URL"}
+                }
+              }
+            ]
+          }
+        },
+        "inline": {
+          "description": "Manifests embedded in the config, from contents or a file of the chart",
+          "type": "array",
+          "items": {
+            "type": "object",
+            "required": ["name"],
+            "additionalProperties": false,
+            "properties": {
+              "name": {"type": "string"},
+              "contents": {"type": "string"},
+              "file": {"type": "string"}
+            }
+          }
+        },
+        "staticPods": {"type": "array", "items": {"type": "object"}, "description": "Pod manifests run by the kubelet of the nodes"}
+      }
+    },
+    "secureBoot": {
+      "description": "SecureBoot installer image of the image factory and TPM disk encryption",
+      "type": "object",
+      "additionalProperties": false,
+      "properties": {
+        "enabled": {"type": "boolean", "description": "Install the SecureBoot UKI of the installer-secureboot image"},
+        "tpmDiskEncryption": {"type": "boolean", "description": "Encrypt the STATE and EPHEMERAL partitions with keys sealed by the TPM, requires enabled"}
+      }
+    }
+  }
+}
 `,
 	"cozystack/values.yaml": `endpoint: "https://192.168.100.10:6443"
 clusterDomain: cozy.local
@@ -124,6 +336,96 @@ serviceSubnets:
 - 10.96.0.0/16
 advertisedSubnets:
 - 192.168.100.0/24
+# Cozystack installs its CNI and replaces kube-proxy after bootstrap
+cni:
+  name: none
+  kubeProxy: false
+  external: true
+# Size class of the nodes setting maxPods, kubeReserved and systemReserved of the kubelet:
+# small (less than 8 CPUs or 16GiB), medium (less than 32 CPUs or 128GiB) or large. Discovered
+# from the CPUs and memory of the node by default, maxPods is 512 when the hardware is unknown.
+# Set it per node with values in the modeline:
+# sizeClass: medium
+# Kubernetes labels, annotations and taints of the nodes. The values of the group named after
+# the machine type are defaults, set them per node with values in the modeline. Annotations are
+# not part of the machine config, ` + "`" + `talm nodes sync-labels --fix` + "`" + ` sets them and fixes the drift:
+# nodeLabels:
+#   topology.kubernetes.io/zone: zone-a
+# nodeAnnotations: {}
+# nodeTaints:
+#   dedicated: storage:NoSchedule
+# nodeGroups:
+#   worker:
+#     nodeLabels:
+#       node-role.kubernetes.io/worker: ""
+# Subnets of the nodes by zone, for clusters spanning racks or sites: the kubelet and etcd of
+# the nodes of a zone set in .talm/nodes.yaml advertise the subnets of the zone:
+# zones:
+#   zone-a:
+#     advertisedSubnets: [192.168.100.0/24]
+#   zone-b:
+#     advertisedSubnets: [192.168.101.0/24]
+# Extra names and addresses of the certificates of the Talos and Kubernetes APIs,
+# the endpoint host, the floating IP and the addresses of the node are added automatically:
+# certSANs:
+# - api.example.com
+# System extensions and kernel args of the installer image built by the image factory, it replaces the image above,
+# set them per node with values in the modeline. ` + "`" + `talm upgrade` + "`" + ` reports the drift of the nodes:
+# schematic:
+#   version: v1.7.1
+#   extensions:
+#   - siderolabs/iscsi-tools
+#   extraKernelArgs:
+#   - net.ifnames=0
+# SecureBoot: install the signed UKI of the installer-secureboot image of the image factory, boot
+# the nodes from its SecureBoot ISO, which enrolls the keys when the UEFI firmware is in setup mode.
+# tpmDiskEncryption encrypts the STATE and EPHEMERAL partitions with keys sealed by the TPM.
+# ` + "`" + `talm apply` + "`" + ` checks the nodes booted in the mode of the installer image:
+# secureBoot:
+#   enabled: true
+#   tpmDiskEncryption: true
+# Manifests applied by Talos on bootstrap, e.g. the cloud controller manager. extra are fetched
+# by the nodes, talm checks the URLs when rendering online and, with sha256, that their content
+# didn't change. inline are embedded in the config from contents or a file of the chart.
+# staticPods are run by the kubelet of the nodes, set them per node with values in the modeline:
+# manifests:
+#   extra:
+#   - https://raw.githubusercontent.com/example/ccm/v1.0.0/deploy.yaml
+#   - url: https://example.com/manifests/rbac.yaml
+#     sha256: 2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae
+#   inline:
+#   - name: namespaces
+#     file: manifests/namespaces.yaml
+#   staticPods:
+#   - metadata:
+#       name: nginx
+#     spec:
+#       containers:
+#       - name: nginx
+#         image: nginx
+# Bond the uplinks instead of configuring the default link directly:
+# bond:
+#   interface: bond0
+#   mode: 802.3ad
+#   xmitHashPolicy: layer3+4
+#   lacpRate: fast
+#   miimon: 100
+#   # MAC addresses of the members, when empty the members are discovered:
+#   # links already in the bond or physical links with the same driver
+#   # as the default link (or memberDriver if set)
+#   members: []
+#   memberDriver: ""
+# VLANs on top of the default interface:
+# vlans:
+# - vlanId: 100
+#   addresses: [192.168.200.10/24]
+# More VIPs, on the default interface unless another interface is set, on its VLAN
+# if vlanId is set. VIPs must be within advertisedSubnets, one VIP per interface or VLAN:
+# vips:
+# - ip: 192.168.100.11
+#   interface: eth1
+# - ip: 192.168.100.12
+#   vlanId: 100
 `,
 	"generic/Chart.yaml": `apiVersion: v2
 name: %s
@@ -139,6 +441,8 @@ templateOptions:
   fileValues: []
   jsonValues: []
   literalValues: []
+  envValues: []
+  envAllowlist: []
   talosVersion: ""
   withSecrets: "secrets.yaml"
   kubernetesVersion: ""
@@ -151,46 +455,75 @@ upgradeOptions:
   preserve: false
   stage: false
   force: false
+`,
+	"generic/templates/NOTES.txt": `Next steps:
+{{- if eq .Command "init" }}
+
+- Gather the information of a booted node and save its config:
+     talm -n <node-ip> -e <node-ip> template -t templates/controlplane.yaml -i > nodes/node1.yaml
+
+- Apply the config to the node running in maintenance mode:
+     talm apply -f nodes/node1.yaml -i
+{{- end }}
+
+- Bootstrap etcd on the first control plane node, only once for the cluster:
+     talm bootstrap -f nodes/node1.yaml
+
+- Retrieve the kubeconfig, the API server is available at {{ .Values.endpoint }}:
+     talm kubeconfig -f nodes/node1.yaml
 `,
 	"generic/templates/_helpers.tpl": `{{- define "talos.config" }}
 machine:
   type: {{ .MachineType }}
+  certSANs: {{ include "talm.cert_sans" . }}
+  {{- include "talm.node_metadata" . | nindent 2 }}
   kubelet:
     nodeIP:
       validSubnets:
-        {{- toYaml .Values.advertisedSubnets | nindent 8 }}
+        {{- include "talm.advertised_subnets" . | nindent 8 }}
   install:
+    {{- with include "talm.installer_image" . }}
+    image: {{ . }}
+    {{- end }}
     {{- (include "talm.discovered.disks_info" .) | nindent 4 }}
     disk: {{ include "talm.discovered.system_disk_name" . | quote }}
+  {{- with include "talm.secureboot.disk_encryption" . }}
+  {{- . | nindent 2 }}
+  {{- end }}
+  {{- with include "talm.static_pods" . }}
+  {{- . | nindent 2 }}
+  {{- end }}
   network:
     hostname: {{ include "talm.discovered.hostname" . | quote }}
     nameservers: {{ include "talm.discovered.default_resolvers" . }}
     {{- (include "talm.discovered.physical_links_info" .) | nindent 4 }}
     interfaces:
-    - deviceSelector:
-        {{- include "talm.discovered.default_link_selector_by_gateway" . | nindent 8 }}
-      addresses: {{ include "talm.discovered.default_addresses_by_gateway" . }}
-      routes:
-        - network: 0.0.0.0/0
-          gateway: {{ include "talm.discovered.default_gateway" . }}
-      {{- with .Values.floatingIP }}
-      vip:
-        ip: {{ . }}
-      {{- end }}
+    {{- include "talm.network.default_interface" . | nindent 4 }}
+    {{- include "talm.network.check_discovered" . }}
 
 cluster:
   network:
+    {{- include "talm.cni" . | nindent 4 }}
     podSubnets:
-      {{- toYaml .Values.podSubnets | nindent 6 }}
+      {{- .Values.podSubnets | assertCIDR "podSubnets" | toYaml | nindent 6 }}
     serviceSubnets:
-      {{- toYaml .Values.serviceSubnets | nindent 6 }}
+      {{- .Values.serviceSubnets | assertCIDR "serviceSubnets" | toYaml | nindent 6 }}
   clusterName: "{{ .Chart.Name }}"
   controlPlane:
     endpoint: "{{ .Values.endpoint }}"
   {{- if eq .MachineType "controlplane" }}
+  apiServer:
+    certSANs: {{ include "talm.cert_sans" . }}
+  {{- if include "talm.kube_proxy_disabled" . }}
+  proxy:
+    disabled: true
+  {{- end }}
+  {{- with include "talm.manifests" . }}
+  {{- . | nindent 2 }}
+  {{- end }}
   etcd:
     advertisedSubnets:
-      {{- toYaml .Values.advertisedSubnets | nindent 6 }}
+      {{- include "talm.advertised_subnets" . | nindent 6 }}
   {{- end }}
 {{- end }}
 `,
@@ -199,6 +532,188 @@ cluster:
 `,
 	"generic/templates/worker.yaml": `{{- $_ := set . "MachineType" "worker" -}}
 {{- include "talos.config" . }}
+`,
+	"generic/values.schema.json": `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "type": "object",
+  "properties": {
+    "bond": {
+      "description": "Bond the uplinks into a single interface instead of configuring the default link",
+      "type": "object",
+      "additionalProperties": false,
+      "properties": {
+        "interface": {"type": "string", "description": "Bond interface name, bond0 by default"},
+        "mode": {
+          "type": "string",
+          "enum": ["balance-rr", "active-backup", "balance-xor", "broadcast", "802.3ad", "balance-tlb", "balance-alb"]
+        },
+        "xmitHashPolicy": {"type": "string", "enum": ["layer2", "layer3+4", "layer2+3", "encap2+3", "encap3+4"]},
+        "lacpRate": {"type": "string", "enum": ["slow", "fast"]},
+        "miimon": {"type": "integer", "minimum": 0},
+        "mtu": {"type": "integer", "minimum": 68},
+        "members": {
+          "description": "MAC addresses or device selectors of the members, discovered if empty",
+          "type": "array",
+          "items": {
+            "oneOf": [
+              {"type": "string", "pattern": "^([0-9A-Fa-f]{2}:){5}[0-9A-Fa-f]{2}$"},
+              {"type": "object"}
+            ]
+          }
+        },
+        "memberDriver": {"type": "string", "description": "Use the physical links with this kernel driver as members"},
+        "addresses": {"type": "array", "items": {"type": "string"}},
+        "gateway": {"type": "string"}
+      }
+    },
+    "vlans": {
+      "description": "VLANs attached to the default interface",
+      "type": "array",
+      "items": {
+        "type": "object",
+        "required": ["vlanId"],
+        "additionalProperties": false,
+        "properties": {
+          "vlanId": {"type": "integer", "minimum": 1, "maximum": 4094},
+          "addresses": {"type": "array", "items": {"type": "string"}},
+          "dhcp": {"type": "boolean"},
+          "mtu": {"type": "integer", "minimum": 68},
+          "routes": {"type": "array", "items": {"type": "object"}},
+          "vip": {"type": "string"}
+        }
+      }
+    },
+    "vips": {
+      "description": "VIPs shared by the nodes, on the default interface unless another interface is set, on its VLAN if vlanId is set",
+      "type": "array",
+      "items": {
+        "type": "object",
+        "required": ["ip"],
+        "additionalProperties": false,
+        "properties": {
+          "ip": {"type": "string"},
+          "interface": {"type": "string"},
+          "vlanId": {"type": "integer", "minimum": 1, "maximum": 4094}
+        }
+      }
+    },
+    "cni": {
+      "description": "CNI of the cluster and kube-proxy",
+      "type": "object",
+      "additionalProperties": false,
+      "properties": {
+        "name": {"type": "string", "enum": ["flannel", "cilium", "calico", "custom", "none"], "description": "flannel by default"},
+        "urls": {"type": "array", "items": {"type": "string"}, "description": "Manifests of the CNI installed by Talos"},
+        "kubeProxy": {"type": "boolean", "description": "Run kube-proxy, disabled by default for cilium"},
+        "external": {"type": "boolean", "description": "The CNI is installed after bootstrap, e.g. with Helm"}
+      }
+    },
+    "nodeLabels": {
+      "description": "Kubernetes labels of the nodes",
+      "type": "object",
+      "additionalProperties": {"type": ["string", "boolean", "number", "null"]}
+    },
+    "nodeAnnotations": {
+      "description": "Kubernetes annotations of the nodes, set by talm nodes sync-labels --fix",
+      "type": "object",
+      "additionalProperties": {"type": ["string", "boolean", "number", "null"]}
+    },
+    "nodeTaints": {
+      "description": "Kubernetes taints of the nodes, value:effect like in machine.nodeTaints",
+      "type": "object",
+      "additionalProperties": {"type": "string", "pattern": ":(NoSchedule|PreferNoSchedule|NoExecute)$"}
+    },
+    "nodeGroups": {
+      "description": "Default node labels, annotations and taints by machine type",
+      "type": "object",
+      "additionalProperties": {
+        "type": "object",
+        "additionalProperties": false,
+        "properties": {
+          "nodeLabels": {"type": "object"},
+          "nodeAnnotations": {"type": "object"},
+          "nodeTaints": {"type": "object"}
+        }
+      }
+    },
+    "zones": {
+      "description": "Settings of the zones of .Node.Topology, set in .talm/nodes.yaml",
+      "type": "object",
+      "additionalProperties": {
+        "type": "object",
+        "additionalProperties": false,
+        "properties": {
+          "advertisedSubnets": {"type": "array", "items": {"type": "string"}, "description": "Subnets of the nodes of the zone, advertisedSubnets by default"}
+        }
+      }
+    },
+    "certSANs": {
+      "description": "Extra names and addresses of the Talos and Kubernetes API certificates",
+      "type": "array",
+      "items": {"type": "string"}
+    },
+    "schematic": {
+      "description": "System extensions and kernel args of the installer image built by the image factory",
+      "type": "object",
+      "additionalProperties": false,
+      "properties": {
+        "factory": {"type": "string", "description": "Image factory host, factory.talos.dev by default"},
+        "version": {"type": "string", "description": "Talos version of the installer image, e.g. v1.7.1"},
+        "extensions": {"type": "array", "items": {"type": "string"}, "description": "Official extensions, e.g. siderolabs/iscsi-tools"},
+        "extraKernelArgs": {"type": "array", "items": {"type": "string"}}
+      }
+    },
+    "manifests": {
+      "description": "Manifests applied by Talos on bootstrap and static pods of the nodes",
+      "type": "object",
+      "additionalProperties": false,
+      "properties": {
+        "extra": {
+          "description": "URLs of the manifests fetched by the nodes, checked when rendering online",
+          "type": "array",
+          "items": {
+            "oneOf": [
+              {"type": "string"},
+              {
+                "type": "object",
+                "required": ["url"],
+                "additionalProperties": false,
+                "properties": {
+                  "url": {"type": "string"},
+                  "sha256": {"type": "string", "pattern": "^[0-9a-fA-F]{64}$", "description": "Checksum of the content of the URL"}
+                }
+              }
+            ]
+          }
+        },
+        "inline": {
+          "description": "Manifests embedded in the config, from contents or a file of the chart",
+          "type": "array",
+          "items": {
+            "type": "object",
+            "required": ["name"],
+            "additionalProperties": false,
+            "properties": {
+              "name": {"type": "string"},
+              "contents": {"type": "string"},
+              "file": {"type": "string"}
+            }
+          }
+        },
+        "staticPods": {"type": "array", "items": {"type": "object"}, "description": "Pod manifests run by the kubelet of the nodes"}
+      }
+    },
+    "secureBoot": {
+      "description": "SecureBoot installer image of the image factory and TPM disk encryption",
+      "type": "object",
+      "additionalProperties": false,
+      "properties": {
+        "enabled": {"type": "boolean", "description": "Install the SecureBoot UKI of the installer-secureboot image"},
+        "tpmDiskEncryption": {"type": "boolean", "description": "Encrypt the STATE and EPHEMERAL partitions with keys sealed by the TPM, requires enabled"}
+      }
+    }
+  }
+}
 `,
 	"generic/values.yaml": `endpoint: "https://192.168.100.10:6443"
 podSubnets:
@@ -207,12 +722,629 @@ serviceSubnets:
 - 10.96.0.0/16
 advertisedSubnets:
 - 192.168.100.0/24
+# CNI of the cluster: flannel (the Talos default), cilium, calico, custom or none.
+# Talos installs the manifests in urls, without them install the CNI after bootstrap
+# and set external to true. kube-proxy is disabled for cilium unless kubeProxy is set:
+# cni:
+#   name: cilium
+#   kubeProxy: false
+#   external: true
+#   urls: []
+# Kubernetes labels, annotations and taints of the nodes. The values of the group named after
+# the machine type are defaults, set them per node with values in the modeline. Annotations are
+# not part of the machine config, ` + "`" + `talm nodes sync-labels --fix` + "`" + ` sets them and fixes the drift:
+# nodeLabels:
+#   topology.kubernetes.io/zone: zone-a
+# nodeAnnotations: {}
+# nodeTaints:
+#   dedicated: storage:NoSchedule
+# nodeGroups:
+#   worker:
+#     nodeLabels:
+#       node-role.kubernetes.io/worker: ""
+# Subnets of the nodes by zone, for clusters spanning racks or sites: the kubelet and etcd of
+# the nodes of a zone set in .talm/nodes.yaml advertise the subnets of the zone:
+# zones:
+#   zone-a:
+#     advertisedSubnets: [192.168.100.0/24]
+#   zone-b:
+#     advertisedSubnets: [192.168.101.0/24]
+# Extra names and addresses of the certificates of the Talos and Kubernetes APIs,
+# the endpoint host, the floating IP and the addresses of the node are added automatically:
+# certSANs:
+# - api.example.com
+# System extensions and kernel args of the installer image built by the image factory,
+# set them per node with values in the modeline. ` + "`" + `talm upgrade` + "`" + ` reports the drift of the nodes:
+# schematic:
+#   version: v1.7.1
+#   extensions:
+#   - siderolabs/iscsi-tools
+#   extraKernelArgs:
+#   - net.ifnames=0
+# SecureBoot: install the signed UKI of the installer-secureboot image of the image factory, boot
+# the nodes from its SecureBoot ISO, which enrolls the keys when the UEFI firmware is in setup mode.
+# tpmDiskEncryption encrypts the STATE and EPHEMERAL partitions with keys sealed by the TPM.
+# ` + "`" + `talm apply` + "`" + ` checks the nodes booted in the mode of the installer image:
+# secureBoot:
+#   enabled: true
+#   tpmDiskEncryption: true
+# Manifests applied by Talos on bootstrap, e.g. the cloud controller manager. extra are fetched
+# by the nodes, talm checks the URLs when rendering online and, with sha256, that their content
+# didn't change. inline are embedded in the config from contents or a file of the chart.
+# staticPods are run by the kubelet of the nodes, set them per node with values in the modeline:
+# manifests:
+#   extra:
+#   - https://raw.githubusercontent.com/example/ccm/v1.0.0/deploy.yaml
+#   - url: https://example.com/manifests/rbac.yaml
+#     sha256: 2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae
+#   inline:
+#   - name: namespaces
+#     file: manifests/namespaces.yaml
+#   staticPods:
+#   - metadata:
+#       name: nginx
+#     spec:
+#       containers:
+#       - name: nginx
+#         image: nginx
+# Bond the uplinks instead of configuring the default link directly:
+# bond:
+#   interface: bond0
+#   mode: 802.3ad
+#   xmitHashPolicy: layer3+4
+#   lacpRate: fast
+#   miimon: 100
+#   # MAC addresses of the members, when empty the members are discovered:
+#   # links already in the bond or physical links with the same driver
+#   # as the default link (or memberDriver if set)
+#   members: []
+#   memberDriver: ""
+# VLANs on top of the default interface:
+# vlans:
+# - vlanId: 100
+#   addresses: [192.168.200.10/24]
+# More VIPs, on the default interface unless another interface is set, on its VLAN
+# if vlanId is set. VIPs must be within advertisedSubnets, one VIP per interface or VLAN:
+# vips:
+# - ip: 192.168.100.11
+#   interface: eth1
+# - ip: 192.168.100.12
+#   vlanId: 100
+`,
+	"gpu-worker/Chart.yaml": `apiVersion: v2
+name: %s
+type: application
+version: %s
+globalOptions:
+  talosconfig: "talosconfig"
+templateOptions:
+  offline: false
+  valueFiles: []
+  values: []
+  stringValues: []
+  fileValues: []
+  jsonValues: []
+  literalValues: []
+  envValues: []
+  envAllowlist: []
+  talosVersion: "v1.7"
+  withSecrets: "secrets.yaml"
+  kubernetesVersion: ""
+  full: false
+applyOptions:
+  preserve: false
+  timeout: "1m"
+  certFingerprints: []
+upgradeOptions:
+  preserve: false
+  stage: false
+  force: false
+`,
+	"gpu-worker/templates/NOTES.txt": `Next steps:
+{{- if eq .Command "init" }}
+
+- Gather the information of a booted node and save its config:
+     talm -n <node-ip> -e <node-ip> template -t templates/controlplane.yaml -i > nodes/node1.yaml
+
+- Apply the config to the node running in maintenance mode:
+     talm apply -f nodes/node1.yaml -i
+{{- end }}
+
+- Bootstrap etcd on the first control plane node, only once for the cluster:
+     talm bootstrap -f nodes/node1.yaml
+
+- Retrieve the kubeconfig, the API server is available at {{ .Values.endpoint }}:
+     talm kubeconfig -f nodes/node1.yaml
+`,
+	"gpu-worker/templates/_helpers.tpl": `{{- /*
+The installer image of the workers must include the NVIDIA extensions: the schematic of the
+values builds it with the image factory, without schematic image is a custom installer image.
+*/}}
+{{- define "gpu.check_extensions" }}
+{{- if eq .MachineType "worker" }}
+{{- if installerImage .Values }}
+{{- $extensions := (.Values.schematic | default dict).extensions | default list }}
+{{- range list "siderolabs/nonfree-kmod-nvidia" "siderolabs/nvidia-container-toolkit" }}
+{{- if not (has . $extensions) }}
+{{- fail (printf "schematic.extensions of the GPU workers must include %s" .) }}
+{{- end }}
+{{- end }}
+{{- else if not .Values.image }}
+{{- fail "the GPU workers need the NVIDIA extensions, add them to schematic.extensions or set image to an installer image including them" }}
+{{- end }}
+{{- end }}
+{{- end }}
+
+{{- /*
+Manifests of the values with the RuntimeClass of the nvidia runtime handler.
+*/}}
+{{- define "gpu.manifests" }}
+{{- $manifests := deepCopy (.Values.manifests | default dict) }}
+{{- with .Values.nvidia.runtimeClass }}
+{{- $runtimeClass := dict "apiVersion" "node.k8s.io/v1" "kind" "RuntimeClass" "metadata" (dict "name" .) "handler" "nvidia" }}
+{{- $_ := set $manifests "inline" (append ($manifests.inline | default list) (dict "name" "nvidia-runtime-class" "contents" (toYaml $runtimeClass))) }}
+{{- end }}
+{{- include "talm.manifests" (merge (dict "Values" (merge (dict "manifests" $manifests) .Values)) .) }}
+{{- end }}
+
+{{- define "talos.config" }}
+{{- include "gpu.check_extensions" . }}
+machine:
+  type: {{ .MachineType }}
+  certSANs: {{ include "talm.cert_sans" . }}
+  {{- include "talm.node_metadata" . | nindent 2 }}
+  kubelet:
+    nodeIP:
+      validSubnets:
+        {{- include "talm.advertised_subnets" . | nindent 8 }}
+    {{- $extraConfig := include "talm.kubelet.resources" . | fromYaml }}
+    {{- if eq .MachineType "worker" }}
+    {{- $extraConfig = merge (dict) (.Values.nvidia.kubeletExtraConfig | default dict) $extraConfig }}
+    {{- end }}
+    {{- with $extraConfig }}
+    extraConfig:
+      {{- toYaml . | nindent 6 }}
+    {{- end }}
+  {{- if eq .MachineType "worker" }}
+  kernel:
+    modules:
+    {{- range .Values.nvidia.kernelModules }}
+    - name: {{ . }}
+    {{- end }}
+  sysctls:
+    net.core.bpf_jit_harden: 1
+  {{- if .Values.nvidia.defaultRuntime }}
+  files:
+  - content: |
+      [plugins]
+        [plugins."io.containerd.grpc.v1.cri"]
+          [plugins."io.containerd.grpc.v1.cri".containerd]
+            default_runtime_name = "nvidia"
+    path: /etc/cri/conf.d/20-customization.part
+    op: create
+  {{- end }}
+  {{- end }}
+  install:
+    {{- with include "talm.installer_image" . }}
+    image: {{ . }}
+    {{- end }}
+    {{- (include "talm.discovered.disks_info" .) | nindent 4 }}
+    disk: {{ include "talm.discovered.system_disk_name" . | quote }}
+  {{- with include "talm.secureboot.disk_encryption" . }}
+  {{- . | nindent 2 }}
+  {{- end }}
+  {{- with include "talm.static_pods" . }}
+  {{- . | nindent 2 }}
+  {{- end }}
+  network:
+    hostname: {{ include "talm.discovered.hostname" . | quote }}
+    nameservers: {{ include "talm.discovered.default_resolvers" . }}
+    {{- (include "talm.discovered.physical_links_info" .) | nindent 4 }}
+    interfaces:
+    {{- include "talm.network.default_interface" . | nindent 4 }}
+    {{- include "talm.network.check_discovered" . }}
+
+cluster:
+  network:
+    {{- include "talm.cni" . | nindent 4 }}
+    podSubnets:
+      {{- .Values.podSubnets | assertCIDR "podSubnets" | toYaml | nindent 6 }}
+    serviceSubnets:
+      {{- .Values.serviceSubnets | assertCIDR "serviceSubnets" | toYaml | nindent 6 }}
+  clusterName: "{{ .Chart.Name }}"
+  controlPlane:
+    endpoint: "{{ .Values.endpoint }}"
+  {{- if eq .MachineType "controlplane" }}
+  apiServer:
+    certSANs: {{ include "talm.cert_sans" . }}
+  {{- if include "talm.kube_proxy_disabled" . }}
+  proxy:
+    disabled: true
+  {{- end }}
+  {{- with include "gpu.manifests" . }}
+  {{- . | nindent 2 }}
+  {{- end }}
+  etcd:
+    advertisedSubnets:
+      {{- include "talm.advertised_subnets" . | nindent 6 }}
+  {{- end }}
+{{- end }}
+`,
+	"gpu-worker/templates/controlplane.yaml": `{{- $_ := set . "MachineType" "controlplane" -}}
+{{- include "talos.config" . }}
+`,
+	"gpu-worker/templates/worker.yaml": `{{- $_ := set . "MachineType" "worker" -}}
+{{- include "talos.config" . }}
+`,
+	"gpu-worker/values.schema.json": `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "type": "object",
+  "properties": {
+    "bond": {
+      "description": "Bond the uplinks into a single interface instead of configuring the default link",
+      "type": "object",
+      "additionalProperties": false,
+      "properties": {
+        "interface": {"type": "string", "description": "Bond interface name, bond0 by default"},
+        "mode": {
+          "type": "string",
+          "enum": ["balance-rr", "active-backup", "balance-xor", "broadcast", "802.3ad", "balance-tlb", "balance-alb"]
+        },
+        "xmitHashPolicy": {"type": "string", "enum": ["layer2", "layer3+4", "layer2+3", "encap2+3", "encap3+4"]},
+        "lacpRate": {"type": "string", "enum": ["slow", "fast"]},
+        "miimon": {"type": "integer", "minimum": 0},
+        "mtu": {"type": "integer", "minimum": 68},
+        "members": {
+          "description": "MAC addresses or device selectors of the members, discovered if empty",
+          "type": "array",
+          "items": {
+            "oneOf": [
+              {"type": "string", "pattern": "^([0-9A-Fa-f]{2}:){5}[0-9A-Fa-f]{2}$"},
+              {"type": "object"}
+            ]
+          }
+        },
+        "memberDriver": {"type": "string", "description": "Use the physical links with this kernel driver as members"},
+        "addresses": {"type": "array", "items": {"type": "string"}},
+        "gateway": {"type": "string"}
+      }
+    },
+    "vlans": {
+      "description": "VLANs attached to the default interface",
+      "type": "array",
+      "items": {
+        "type": "object",
+        "required": ["vlanId"],
+        "additionalProperties": false,
+        "properties": {
+          "vlanId": {"type": "integer", "minimum": 1, "maximum": 4094},
+          "addresses": {"type": "array", "items": {"type": "string"}},
+          "dhcp": {"type": "boolean"},
+          "mtu": {"type": "integer", "minimum": 68},
+          "routes": {"type": "array", "items": {"type": "object"}},
+          "vip": {"type": "string"}
+        }
+      }
+    },
+    "vips": {
+      "description": "VIPs shared by the nodes, on the default interface unless another interface is set, on its VLAN if vlanId is set",
+      "type": "array",
+      "items": {
+        "type": "object",
+        "required": ["ip"],
+        "additionalProperties": false,
+        "properties": {
+          "ip": {"type": "string"},
+          "interface": {"type": "string"},
+          "vlanId": {"type": "integer", "minimum": 1, "maximum": 4094}
+        }
+      }
+    },
+    "cni": {
+      "description": "CNI of the cluster and kube-proxy",
+      "type": "object",
+      "additionalProperties": false,
+      "properties": {
+        "name": {"type": "string", "enum": ["flannel", "cilium", "calico", "custom", "none"], "description": "flannel by default"},
+        "urls": {"type": "array", "items": {"type": "string"}, "description": "Manifests of the CNI installed by Talos"},
+        "kubeProxy": {"type": "boolean", "description": "Run kube-proxy, disabled by default for cilium"},
+        "external": {"type": "boolean", "description": "The CNI is installed after bootstrap, e.g. with Helm"}
+      }
+    },
+    "nodeLabels": {
+      "description": "Kubernetes labels of the nodes",
+      "type": "object",
+      "additionalProperties": {"type": ["string", "boolean", "number", "null"]}
+    },
+    "nodeAnnotations": {
+      "description": "Kubernetes annotations of the nodes, set by talm nodes sync-labels --fix",
+      "type": "object",
+      "additionalProperties": {"type": ["string", "boolean", "number", "null"]}
+    },
+    "nodeTaints": {
+      "description": "Kubernetes taints of the nodes, value:effect like in machine.nodeTaints",
+      "type": "object",
+      "additionalProperties": {"type": "string", "pattern": ":(NoSchedule|PreferNoSchedule|NoExecute)$"}
+    },
+    "nodeGroups": {
+      "description": "Default node labels, annotations and taints by machine type",
+      "type": "object",
+      "additionalProperties": {
+        "type": "object",
+        "additionalProperties": false,
+        "properties": {
+          "nodeLabels": {"type": "object"},
+          "nodeAnnotations": {"type": "object"},
+          "nodeTaints": {"type": "object"}
+        }
+      }
+    },
+    "sizeClass": {
+      "description": "Size class of the nodes setting the kubelet resources, discovered from the hardware when empty",
+      "type": "string",
+      "enum": ["", "small", "medium", "large"]
+    },
+    "zones": {
+      "description": "Settings of the zones of .Node.Topology, set in .talm/nodes.yaml",
+      "type": "object",
+      "additionalProperties": {
+        "type": "object",
+        "additionalProperties": false,
+        "properties": {
+          "advertisedSubnets": {"type": "array", "items": {"type": "string"}, "description": "Subnets of the nodes of the zone, advertisedSubnets by default"}
+        }
+      }
+    },
+    "certSANs": {
+      "description": "Extra names and addresses of the Talos and Kubernetes API certificates",
+      "type": "array",
+      "items": {"type": "string"}
+    },
+    "schematic": {
+      "description": "System extensions and kernel args of the installer image built by the image factory",
+      "type": "object",
+      "additionalProperties": false,
+      "properties": {
+        "factory": {"type": "string", "description": "Image factory host, factory.talos.dev by default"},
+        "version": {"type": "string", "description": "Talos version of the installer image, e.g. v1.7.1"},
+        "extensions": {"type": "array", "items": {"type": "string"}, "description": "Official extensions, e.g. siderolabs/iscsi-tools"},
+        "extraKernelArgs": {"type": "array", "items": {"type": "string"}}
+      }
+    },
+    "manifests": {
+      "description": "Manifests applied by Talos on bootstrap and static pods of the nodes",
+      "type": "object",
+      "additionalProperties": false,
+      "properties": {
+        "extra": {
+          "description": "URLs of the manifests fetched by the nodes, checked when rendering online",
+          "type": "array",
+          "items": {
+            "oneOf": [
+              {"type": "string"},
+              {
+                "type": "object",
+                "required": ["url"],
+                "additionalProperties": false,
+                "properties": {
+                  "url": {"type": "string"},
+                  "sha256": {"type": "string", "pattern": "^[0-9a-fA-F]{64}$", "description": "Checksum of the content of the URL"}
+                }
+              }
+            ]
+          }
+        },
+        "inline": {
+          "description": "Manifests embedded in the config, from contents or a file of the chart",
+          "type": "array",
+          "items": {
+            "type": "object",
+            "required": ["name"],
+            "additionalProperties": false,
+            "properties": {
+              "name": {"type": "string"},
+              "contents": {"type": "string"},
+              "file": {"type": "string"}
+            }
+          }
+        },
+        "staticPods": {"type": "array", "items": {"type": "object"}, "description": "Pod manifests run by the kubelet of the nodes"}
+      }
+    },
+    "image": {"type": "string", "description": "Installer image including the NVIDIA extensions used without schematic"},
+    "nvidia": {
+      "description": "NVIDIA drivers and container runtime of the GPU workers",
+      "type": "object",
+      "additionalProperties": false,
+      "properties": {
+        "kernelModules": {"type": "array", "items": {"type": "string"}, "description": "Kernel modules loaded on the GPU workers"},
+        "defaultRuntime": {"type": "boolean", "description": "Make nvidia the default containerd runtime"},
+        "runtimeClass": {"type": "string", "description": "Name of the RuntimeClass of the nvidia runtime handler, none when empty"},
+        "kubeletExtraConfig": {"type": "object", "description": "Extra kubelet configuration of the GPU workers"}
+      }
+    },
+    "secureBoot": {
+      "description": "SecureBoot installer image of the image factory and TPM disk encryption",
+      "type": "object",
+      "additionalProperties": false,
+      "properties": {
+        "enabled": {"type": "boolean", "description": "Install the SecureBoot UKI of the installer-secureboot image"},
+        "tpmDiskEncryption": {"type": "boolean", "description": "Encrypt the STATE and EPHEMERAL partitions with keys sealed by the TPM, requires enabled"}
+      }
+    }
+  }
+}
+`,
+	"gpu-worker/values.yaml": `endpoint: "https://192.168.100.10:6443"
+podSubnets:
+- 10.244.0.0/16
+serviceSubnets:
+- 10.96.0.0/16
+advertisedSubnets:
+- 192.168.100.0/24
+# CNI of the cluster: flannel (the Talos default), cilium, calico, custom or none.
+# Talos installs the manifests in urls, without them install the CNI after bootstrap
+# and set external to true. kube-proxy is disabled for cilium unless kubeProxy is set:
+# cni:
+#   name: cilium
+#   kubeProxy: false
+#   external: true
+#   urls: []
+# Size class of the nodes setting maxPods, kubeReserved and systemReserved of the kubelet:
+# small (less than 8 CPUs or 16GiB), medium (less than 32 CPUs or 128GiB) or large. Discovered
+# from the CPUs and memory of the node by default, the kubelet defaults are kept when the hardware
+# is unknown. Set it per node with values in the modeline:
+# sizeClass: medium
+# Kubernetes labels, annotations and taints of the nodes. The values of the group named after
+# the machine type are defaults, set them per node with values in the modeline. Annotations are
+# not part of the machine config, ` + "`" + `talm nodes sync-labels --fix` + "`" + ` sets them and fixes the drift:
+# nodeLabels:
+#   topology.kubernetes.io/zone: zone-a
+# nodeAnnotations: {}
+# nodeTaints:
+#   dedicated: storage:NoSchedule
+nodeGroups:
+  worker:
+    nodeLabels:
+      nvidia.com/gpu.present: "true"
+# Subnets of the nodes by zone, for clusters spanning racks or sites: the kubelet and etcd of
+# the nodes of a zone set in .talm/nodes.yaml advertise the subnets of the zone:
+# zones:
+#   zone-a:
+#     advertisedSubnets: [192.168.100.0/24]
+#   zone-b:
+#     advertisedSubnets: [192.168.101.0/24]
+# Extra names and addresses of the certificates of the Talos and Kubernetes APIs,
+# the endpoint host, the floating IP and the addresses of the node are added automatically:
+# certSANs:
+# - api.example.com
+# System extensions and kernel args of the installer image built by the image factory,
+# set them per node with values in the modeline. ` + "`" + `talm upgrade` + "`" + ` reports the drift of the nodes.
+# The GPU workers need the NVIDIA extensions, the control plane nodes can leave them out with
+# their own schematic.extensions:
+schematic:
+  version: v1.7.6
+  extensions:
+  - siderolabs/nonfree-kmod-nvidia
+  - siderolabs/nvidia-container-toolkit
+#   extraKernelArgs:
+#   - net.ifnames=0
+# -- Installer image including the nonfree-kmod-nvidia and nvidia-container-toolkit system
+# extensions used without schematic, e.g. an image of a private registry
+image: ""
+nvidia:
+  # -- Kernel modules loaded on the GPU workers
+  kernelModules:
+  - nvidia
+  - nvidia_uvm
+  - nvidia_drm
+  - nvidia_modeset
+  # -- Make nvidia the default containerd runtime, otherwise pods have to
+  # request it using the runtimeClassName
+  defaultRuntime: false
+  # -- Name of the RuntimeClass created for the nvidia runtime handler,
+  # set to empty string to skip creation
+  runtimeClass: nvidia
+  # -- Extra kubelet configuration for the GPU workers
+  kubeletExtraConfig: {}
+# SecureBoot: install the signed UKI of the installer-secureboot image of the image factory, boot
+# the nodes from its SecureBoot ISO, which enrolls the keys when the UEFI firmware is in setup mode.
+# tpmDiskEncryption encrypts the STATE and EPHEMERAL partitions with keys sealed by the TPM.
+# ` + "`" + `talm apply` + "`" + ` checks the nodes booted in the mode of the installer image:
+# secureBoot:
+#   enabled: true
+#   tpmDiskEncryption: true
+# Manifests applied by Talos on bootstrap, e.g. the cloud controller manager. extra are fetched
+# by the nodes, talm checks the URLs when rendering online and, with sha256, that their content
+# didn't change. inline are embedded in the config from contents or a file of the chart.
+# staticPods are run by the kubelet of the nodes, set them per node with values in the modeline:
+# manifests:
+#   extra:
+#   - https://raw.githubusercontent.com/example/ccm/v1.0.0/deploy.yaml
+#   - url: https://example.com/manifests/rbac.yaml
+#     sha256: 2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae
+#   inline:
+#   - name: namespaces
+#     file: manifests/namespaces.yaml
+#   staticPods:
+#   - metadata:
+#       name: nginx
+#     spec:
+#       containers:
+#       - name: nginx
+#         image: nginx
+# Bond the uplinks instead of configuring the default link directly:
+# bond:
+#   interface: bond0
+#   mode: 802.3ad
+#   xmitHashPolicy: layer3+4
+#   lacpRate: fast
+#   miimon: 100
+#   # MAC addresses of the members, when empty the members are discovered:
+#   # links already in the bond or physical links with the same driver
+#   # as the default link (or memberDriver if set)
+#   members: []
+#   memberDriver: ""
+# VLANs on top of the default interface:
+# vlans:
+# - vlanId: 100
+#   addresses: [192.168.200.10/24]
+# More VIPs, on the default interface unless another interface is set, on its VLAN
+# if vlanId is set. VIPs must be within advertisedSubnets, one VIP per interface or VLAN:
+# vips:
+# - ip: 192.168.100.11
+#   interface: eth1
+# - ip: 192.168.100.12
+#   vlanId: 100
 `,
 	"talm/Chart.yaml": `apiVersion: v2
 type: library
 name: %s
 version: %s
 description: A library Talm chart for Talos Linux
+`,
+	"talm/templates/_cni.tpl": `{{- define "talm.cni" }}
+{{- $cni := .Values.cni | default dict }}
+{{- $name := $cni.name | default "flannel" }}
+{{- $urls := $cni.urls | default list }}
+{{- if eq $name "flannel" }}
+{{- if $urls }}
+{{- fail "cni.urls is set but cni.name is flannel, set cni.name to cilium, calico or custom" }}
+{{- end }}
+{{- else if $urls }}
+cni:
+  name: custom
+  urls:
+    {{- toYaml $urls | nindent 4 }}
+{{- else if eq $name "custom" }}
+{{- fail "cni.name is custom but cni.urls is empty, set the manifests of the CNI" }}
+{{- else if or (eq $name "cilium") (eq $name "calico") (eq $name "none") }}
+{{- if not $cni.external }}
+{{- if eq $name "none" }}
+{{- warn "cni.name is none and no CNI is configured, the nodes stay NotReady until one is installed: set cni.external to true if it is installed after bootstrap" }}
+{{- else }}
+{{- warn (printf "cni.name is %s but cni.urls is empty, Talos installs no CNI: install %s after bootstrap and set cni.external to true, or set its manifests in cni.urls" $name $name) }}
+{{- end }}
+{{- end }}
+cni:
+  name: none
+{{- else }}
+{{- fail (printf "unknown cni.name %s, use flannel, cilium, calico, custom or none" $name) }}
+{{- end }}
+{{- end }}
+
+{{- define "talm.kube_proxy_disabled" }}
+{{- $cni := .Values.cni | default dict }}
+{{- $name := $cni.name | default "flannel" }}
+{{- $kubeProxy := ne $name "cilium" }}
+{{- if hasKey $cni "kubeProxy" }}
+{{- $kubeProxy = $cni.kubeProxy }}
+{{- end }}
+{{- if and (not $kubeProxy) (eq $name "flannel") }}
+{{- warn "cni.kubeProxy is false but flannel relies on kube-proxy for services" }}
+{{- end }}
+{{- if not $kubeProxy }}true{{ end }}
+{{- end }}
 `,
 	"talm/templates/_helpers.tpl": `{{- define "talm.discovered.system_disk_name" }}
 {{- $disk := "" }}
@@ -276,10 +1408,14 @@ description: A library Talm chart for Talos Linux
 {{- $family = .spec.family }}
 {{- end }}
 {{- end }}
+{{- $vips := list }}
+{{- range include "talm.network.vips" . | fromJsonArray }}
+{{- $vips = append $vips .ip }}
+{{- end }}
 {{- $addresses := list }}
 {{- range (lookup "addresses" "" "").items }}
 {{- if and (eq .spec.linkName $linkName) (eq .spec.family $family) (not (eq .spec.scope "host")) }}
-{{- if not (hasPrefix (printf "%s/" $.Values.floatingIP) .spec.address) }}
+{{- if not (has (first (splitList "/" .spec.address)) $vips) }}
 {{- $addresses = append $addresses .spec.address }}
 {{- end }}
 {{- end }}
@@ -287,6 +1423,31 @@ description: A library Talm chart for Talos Linux
 {{- toJson $addresses }}
 {{- end }}
 
+{{- define "talm.cert_sans" }}
+{{- $sans := list "127.0.0.1" }}
+{{- with $.Values.endpoint }}
+{{- with (urlParse .).hostname }}
+{{- $sans = append $sans . }}
+{{- end }}
+{{- end }}
+{{- with $.Values.floatingIP }}
+{{- $sans = append $sans . }}
+{{- end }}
+{{- with (include "talm.discovered.default_addresses" $) }}
+{{- range fromJsonArray . }}
+{{- $sans = append $sans (first (splitList "/" .)) }}
+{{- end }}
+{{- end }}
+{{- range $.Values.certSANs }}
+{{- $sans = append $sans . }}
+{{- end }}
+{{- toJson (uniq $sans) }}
+{{- end }}
+
+{{- define "talm.installer_image" }}
+{{- installerImage $.Values | default ($.Values.image | default "") }}
+{{- end }}
+
 {{- define "talm.discovered.physical_links_info" }}
 # -- Discovered interfaces:
 {{- range (lookup "links" "" "").items }}
@@ -363,10 +1524,480 @@ driver: {{ .spec.driver }}
 {{- toJson .spec.dnsServers }}
 {{- end }}
 {{- end }}
+`,
+	"talm/templates/_manifests.tpl": `{{- /*
+Manifests applied by Talos on bootstrap, e.g. the cloud controller manager, from
+.Values.manifests: extra are URLs fetched by the nodes, given as strings or as {url, sha256},
+inline are {name, contents} or {name, file} with a file of the chart. When rendering online
+the URLs are checked with a HEAD request, or with sha256 that their content didn't change.
+*/}}
+{{- define "talm.manifests" }}
+{{- $manifests := .Values.manifests | default dict }}
+{{- $urls := list }}
+{{- range $manifests.extra }}
+{{- $manifest := . }}
+{{- if kindIs "string" . }}
+{{- $manifest = dict "url" . }}
+{{- end }}
+{{- if not (regexMatch "^https?://[^/]+" ($manifest.url | default "")) }}
+{{- fail (printf "manifests.extra URL %q is not an http(s) URL" ($manifest.url | default "")) }}
+{{- end }}
+{{- with $manifest.sha256 }}
+{{- if not (regexMatch "^[0-9a-fA-F]{64}$" .) }}
+{{- fail (printf "manifests.extra sha256 of %s is not a hex encoded sha256 checksum" $manifest.url) }}
+{{- end }}
+{{- end }}
+{{- checkManifestURL $manifest.url ($manifest.sha256 | default "") }}
+{{- $urls = append $urls $manifest.url }}
+{{- end }}
+{{- with $urls }}
+extraManifests:
+  {{- toYaml . | nindent 2 }}
+{{- end }}
+{{- $inline := list }}
+{{- $names := dict }}
+{{- range $manifests.inline }}
+{{- if not .name }}
+{{- fail "every manifest of manifests.inline needs a name" }}
+{{- end }}
+{{- if hasKey $names .name }}
+{{- fail (printf "manifests.inline has two manifests named %s" .name) }}
+{{- end }}
+{{- $_ := set $names .name true }}
+{{- $contents := .contents | default "" }}
+{{- if .file }}
+{{- $contents = $.Files.Get .file }}
+{{- if not $contents }}
+{{- fail (printf "file %s of manifests.inline %s is missing or empty" .file .name) }}
+{{- end }}
+{{- end }}
+{{- if not $contents }}
+{{- fail (printf "manifests.inline %s needs contents or a file" .name) }}
+{{- end }}
+{{- $inline = append $inline (dict "name" .name "contents" $contents) }}
+{{- end }}
+{{- with $inline }}
+inlineManifests:
+  {{- toYaml . | nindent 2 }}
+{{- end }}
+{{- end }}
+
+{{- /*
+Static pods run by the kubelet of the node from .Values.manifests.staticPods, Pod manifests
+defaulting to apiVersion v1 and kind Pod.
+*/}}
+{{- define "talm.static_pods" }}
+{{- $manifests := .Values.manifests | default dict }}
+{{- $pods := list }}
+{{- range $manifests.staticPods }}
+{{- $name := dig "metadata" "name" "" . }}
+{{- if not $name }}
+{{- fail "every pod of manifests.staticPods needs metadata.name" }}
+{{- end }}
+{{- if not (dig "spec" "containers" list .) }}
+{{- fail (printf "static pod %s has no containers" $name) }}
+{{- end }}
+{{- $pods = append $pods (merge (deepCopy .) (dict "apiVersion" "v1" "kind" "Pod")) }}
+{{- end }}
+{{- with $pods }}
+pods:
+  {{- toYaml . | nindent 2 }}
+{{- end }}
+{{- end }}
+`,
+	"talm/templates/_network.tpl": `{{- define "talm.discovered.default_link_by_gateway" }}
+{{- range (lookup "routes" "" "").items }}
+{{- if and (eq .spec.dst "") (not (eq .spec.gateway "")) }}
+{{- toJson (lookup "links" "" .spec.outLinkName) }}
+{{- break }}
+{{- end }}
+{{- end }}
+{{- end }}
+
+{{- /*
+Device selectors of the bond members:
+- .Values.bond.members: MAC addresses or device selectors set explicitly
+- .Values.bond.memberDriver: physical links using the driver
+- otherwise links already enslaved to the default link if it is a bond,
+  or physical links using the same driver as the default link
+*/}}
+{{- define "talm.network.bond_member_selectors" }}
+{{- $bond := .Values.bond }}
+{{- $selectors := list }}
+{{- range $bond.members }}
+{{- if kindIs "string" . }}
+{{- $selectors = append $selectors (dict "hardwareAddr" (lower .)) }}
+{{- else }}
+{{- $selectors = append $selectors . }}
+{{- end }}
+{{- end }}
+{{- if not $selectors }}
+{{- $default := dict }}
+{{- with (include "talm.discovered.default_link_by_gateway" .) }}
+{{- $default = fromJson . }}
+{{- end }}
+{{- $driver := $bond.memberDriver | default (dig "spec" "driver" "" $default) }}
+{{- $bondIndex := "" }}
+{{- if and (not $bond.memberDriver) (eq (dig "spec" "kind" "" $default) "bond") }}
+{{- $bondIndex = dig "spec" "index" "" $default | toString }}
+{{- end }}
+{{- range (lookup "links" "" "").items }}
+{{- if .spec.busPath }}
+{{- if $bondIndex }}
+{{- if eq (toString (.spec.masterIndex | default "")) $bondIndex }}
+{{- $selectors = append $selectors (dict "busPath" .spec.busPath) }}
+{{- end }}
+{{- else if and $driver (eq .spec.driver $driver) }}
+{{- $selectors = append $selectors (dict "busPath" .spec.busPath) }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- toJson $selectors }}
+{{- end }}
+
+{{- /*
+VIPs of the node as a JSON list of {ip, interface, vlanId}: .Values.floatingIP on the default
+interface, the vip of .Values.vlans and .Values.vips, which are on the default interface unless
+they name another interface, on its VLAN if vlanId is set. Rendering fails when a VIP is not
+within .Values.advertisedSubnets, is claimed twice, or when an interface or a VLAN gets two
+VIPs, as Talos supports a single VIP per link.
+*/}}
+{{- define "talm.network.vips" }}
+{{- $defaultName := "" }}
+{{- if .Values.bond }}
+{{- $defaultName = .Values.bond.interface | default "bond0" }}
+{{- end }}
+{{- $vips := list }}
+{{- with .Values.floatingIP }}
+{{- $vips = append $vips (dict "ip" . "interface" "" "vlanId" 0) }}
+{{- end }}
+{{- range $vlan := .Values.vlans }}
+{{- with $vlan.vip }}
+{{- $vips = append $vips (dict "ip" . "interface" "" "vlanId" (int $vlan.vlanId)) }}
+{{- end }}
+{{- end }}
+{{- range .Values.vips }}
+{{- $interface := .interface | default "" }}
+{{- if eq $interface $defaultName }}
+{{- $interface = "" }}
+{{- end }}
+{{- $vips = append $vips (dict "ip" .ip "interface" $interface "vlanId" (int (.vlanId | default 0))) }}
+{{- end }}
+{{- $links := dict }}
+{{- $ips := dict }}
+{{- range $vip := $vips }}
+{{- $link := "the default interface" }}
+{{- if $vip.interface }}
+{{- $link = printf "interface %s" $vip.interface }}
+{{- end }}
+{{- if $vip.vlanId }}
+{{- $link = printf "VLAN %d of %s" $vip.vlanId $link }}
+{{- end }}
+{{- if hasKey $ips $vip.ip }}
+{{- fail (printf "VIP %s is claimed by both %s and %s" $vip.ip (get $ips $vip.ip) $link) }}
+{{- end }}
+{{- if hasKey $links $link }}
+{{- fail (printf "VIPs %s and %s both claim %s, Talos supports a single VIP per link" (get $links $link) $vip.ip $link) }}
+{{- end }}
+{{- $_ := set $links $link $vip.ip }}
+{{- $_ := set $ips $vip.ip $link }}
+{{- if $.Values.advertisedSubnets }}
+{{- $within := false }}
+{{- range $.Values.advertisedSubnets }}
+{{- if cidrContains . $vip.ip }}
+{{- $within = true }}
+{{- end }}
+{{- end }}
+{{- if not $within }}
+{{- fail (printf "VIP %s of %s is not within advertisedSubnets %s" $vip.ip $link (join ", " $.Values.advertisedSubnets)) }}
+{{- end }}
+{{- end }}
+{{- if and $vip.vlanId (not $vip.interface) }}
+{{- $configured := false }}
+{{- range $.Values.vlans }}
+{{- if eq (int .vlanId) $vip.vlanId }}
+{{- $configured = true }}
+{{- end }}
+{{- end }}
+{{- if not $configured }}
+{{- fail (printf "VIP %s claims VLAN %d which is not configured in vlans" $vip.ip $vip.vlanId) }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- toJson $vips }}
+{{- end }}
+
+{{- /*
+Interfaces other than the default one carrying VIPs from .Values.vips.
+*/}}
+{{- define "talm.network.vip_interfaces" }}
+{{- $vips := include "talm.network.vips" . | fromJsonArray }}
+{{- $names := list }}
+{{- range $vips }}
+{{- if .interface }}
+{{- $names = append $names .interface }}
+{{- end }}
+{{- end }}
+{{- range $name := uniq $names }}
+- interface: {{ $name }}
+  {{- range $vips }}
+  {{- if and (eq .interface $name) (not .vlanId) }}
+  vip:
+    ip: {{ .ip }}
+  {{- end }}
+  {{- end }}
+  {{- $vlans := list }}
+  {{- range $vips }}
+  {{- if and (eq .interface $name) .vlanId }}
+  {{- $vlans = append $vlans . }}
+  {{- end }}
+  {{- end }}
+  {{- with $vlans }}
+  vlans:
+    {{- range . }}
+    - vlanId: {{ .vlanId }}
+      vip:
+        ip: {{ .ip }}
+    {{- end }}
+  {{- end }}
+{{- end }}
+{{- end }}
+
+{{- /*
+Default network interface of the node: the link with the default gateway, or
+a bond of the uplinks when .Values.bond is set. VLANs from .Values.vlans are
+attached to it.
+*/}}
+{{- define "talm.network.default_interface" }}
+{{- if .Values.bond }}
+{{- $bond := .Values.bond }}
+- interface: {{ $bond.interface | default "bond0" }}
+  bond:
+    mode: {{ $bond.mode | default "802.3ad" }}
+    {{- if eq ($bond.mode | default "802.3ad") "802.3ad" }}
+    xmitHashPolicy: {{ $bond.xmitHashPolicy | default "layer3+4" }}
+    lacpRate: {{ $bond.lacpRate | default "fast" }}
+    {{- else if $bond.xmitHashPolicy }}
+    xmitHashPolicy: {{ $bond.xmitHashPolicy }}
+    {{- end }}
+    miimon: {{ $bond.miimon | default 100 }}
+    deviceSelectors: {{ include "talm.network.bond_member_selectors" . }}
+  {{- with $bond.mtu }}
+  mtu: {{ . }}
+  {{- end }}
+  addresses: {{ if $bond.addresses }}{{ toJson $bond.addresses }}{{ else }}{{ include "talm.discovered.default_addresses_by_gateway" . }}{{ end }}
+  routes:
+    - network: 0.0.0.0/0
+      gateway: {{ $bond.gateway | default (include "talm.discovered.default_gateway" .) }}
+{{- else }}
+- deviceSelector:
+    {{- include "talm.discovered.default_link_selector_by_gateway" . | nindent 4 }}
+  addresses: {{ include "talm.discovered.default_addresses_by_gateway" . }}
+  routes:
+    - network: 0.0.0.0/0
+      gateway: {{ include "talm.discovered.default_gateway" . }}
+{{- end }}
+{{- $vips := include "talm.network.vips" . | fromJsonArray }}
+{{- range $vips }}
+{{- if and (not .interface) (not .vlanId) }}
+  vip:
+    ip: {{ .ip }}
+{{- end }}
+{{- end }}
+{{- with .Values.vlans }}
+  vlans:
+    {{- range $vlan := . }}
+    - vlanId: {{ .vlanId }}
+      {{- with .addresses }}
+      addresses: {{ toJson . }}
+      {{- end }}
+      {{- if hasKey . "dhcp" }}
+      dhcp: {{ .dhcp }}
+      {{- end }}
+      {{- with .mtu }}
+      mtu: {{ . }}
+      {{- end }}
+      {{- with .routes }}
+      routes:
+        {{- toYaml . | nindent 8 }}
+      {{- end }}
+      {{- range $vips }}
+      {{- if and (not .interface) (eq (int .vlanId) (int $vlan.vlanId)) }}
+      vip:
+        ip: {{ .ip }}
+      {{- end }}
+      {{- end }}
+    {{- end }}
+{{- end }}
+{{- include "talm.network.vip_interfaces" . }}
+{{- end }}
+
+{{- /*
+Warnings about network values not matching the addresses discovered on the node, rendering
+offline discovers none and checks nothing: advertisedSubnets containing none of the node
+addresses, a default gateway outside the subnets of the addresses of the default interface,
+and a floatingIP which is an address of the node itself rather than its VIP.
+*/}}
+{{- define "talm.network.check_discovered" }}
+{{- $nodeAddresses := list }}
+{{- with (include "talm.discovered.default_addresses" .) }}
+{{- $nodeAddresses = fromJsonArray . }}
+{{- end }}
+{{- $subnets := include "talm.advertised_subnets" . | fromYamlArray }}
+{{- if and $nodeAddresses $subnets }}
+{{- $advertised := false }}
+{{- range $address := $nodeAddresses }}
+{{- range $subnets }}
+{{- if cidrContains . $address }}
+{{- $advertised = true }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- if not $advertised }}
+{{- warn (printf "advertisedSubnets %s contain none of the node addresses %s, the kubelet and etcd have no address to advertise" (join ", " $subnets) (join ", " $nodeAddresses)) }}
+{{- end }}
+{{- end }}
+{{- $bond := .Values.bond | default dict }}
+{{- $gateway := $bond.gateway | default (include "talm.discovered.default_gateway" .) }}
+{{- $addresses := $bond.addresses | default (include "talm.discovered.default_addresses_by_gateway" . | fromJsonArray) }}
+{{- if and $gateway $addresses (not (hasPrefix "fe80:" $gateway)) }}
+{{- $reachable := false }}
+{{- range $addresses }}
+{{- if cidrContains . $gateway }}
+{{- $reachable = true }}
+{{- end }}
+{{- end }}
+{{- if not $reachable }}
+{{- warn (printf "default gateway %s is not within the subnets of the addresses %s of the default interface, the node has no default route" $gateway (join ", " $addresses)) }}
+{{- end }}
+{{- end }}
+{{- with .Values.floatingIP }}
+{{- $floatingIP := . }}
+{{- range (lookup "addresses" "" "").items }}
+{{- $parts := splitList "/" .spec.address }}
+{{- if and (eq (first $parts) $floatingIP) (not (has (last $parts) (list "32" "128"))) }}
+{{- warn (printf "floatingIP %s is the address %s of %s on the node, set it to a free address of advertisedSubnets" $floatingIP .spec.address .spec.linkName) }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- end }}
+`,
+	"talm/templates/_nodes.tpl": `{{- define "talm.node_metadata" }}
+{{- $groups := .Values.nodeGroups | default dict }}
+{{- $group := get $groups .MachineType | default dict }}
+{{- $labels := merge (dict) (.Values.nodeLabels | default dict) ($group.nodeLabels | default dict) (include "talm.topology.labels" . | fromJson) }}
+{{- $taints := merge (dict) (.Values.nodeTaints | default dict) ($group.nodeTaints | default dict) }}
+{{- with $labels }}
+nodeLabels:
+  {{- toYaml . | nindent 2 }}
+{{- end }}
+{{- with $taints }}
+nodeTaints:
+  {{- toYaml . | nindent 2 }}
+{{- end }}
+{{- end }}
+`,
+	"talm/templates/_resources.tpl": `{{- define "talm.resources.size_class" }}
+{{- if .Values.sizeClass }}
+{{- .Values.sizeClass }}
+{{- else if and .Hardware.cpus .Hardware.memoryMiB }}
+{{- if or (lt (int .Hardware.cpus) 8) (lt (int .Hardware.memoryMiB) 16384) }}
+{{- "small" }}
+{{- else if or (lt (int .Hardware.cpus) 32) (lt (int .Hardware.memoryMiB) 131072) }}
+{{- "medium" }}
+{{- else }}
+{{- "large" }}
+{{- end }}
+{{- end }}
+{{- end }}
+
+{{- define "talm.resources.sizing" }}
+{{- $classes := dict
+  "small" (dict
+    "maxPods" 110
+    "kubeReserved" (dict "cpu" "200m" "memory" "1Gi" "ephemeral-storage" "1Gi")
+    "systemReserved" (dict "cpu" "200m" "memory" "512Mi" "ephemeral-storage" "1Gi"))
+  "medium" (dict
+    "maxPods" 250
+    "kubeReserved" (dict "cpu" "500m" "memory" "2Gi" "ephemeral-storage" "2Gi")
+    "systemReserved" (dict "cpu" "500m" "memory" "1Gi" "ephemeral-storage" "2Gi"))
+  "large" (dict
+    "maxPods" 512
+    "kubeReserved" (dict "cpu" "1" "memory" "4Gi" "ephemeral-storage" "4Gi")
+    "systemReserved" (dict "cpu" "1" "memory" "2Gi" "ephemeral-storage" "4Gi")) }}
+{{- $class := include "talm.resources.size_class" . }}
+{{- if $class }}
+{{- if not (hasKey $classes $class) }}
+{{- fail (printf "unknown sizeClass %q, use small, medium or large" $class) }}
+{{- end }}
+{{- toJson (get $classes $class) }}
+{{- end }}
+{{- end }}
+
+{{- define "talm.kubelet.resources" }}
+{{- with include "talm.resources.sizing" . }}
+{{- $sizing := fromJson . }}
+maxPods: {{ $sizing.maxPods }}
+kubeReserved:
+  {{- toYaml $sizing.kubeReserved | nindent 2 }}
+systemReserved:
+  {{- toYaml $sizing.systemReserved | nindent 2 }}
+{{- end }}
+{{- end }}
+`,
+	"talm/templates/_secureboot.tpl": `{{- define "talm.secureboot" }}
+{{- toJson (.Values.secureBoot | default dict) }}
+{{- end }}
+
+{{- define "talm.secureboot.disk_encryption" }}
+{{- $secureBoot := include "talm.secureboot" . | fromJson }}
+{{- if $secureBoot.tpmDiskEncryption }}
+{{- if not $secureBoot.enabled }}
+{{- fail "secureBoot.tpmDiskEncryption requires secureBoot.enabled, the TPM seals the keys to the SecureBoot state" }}
+{{- end }}
+systemDiskEncryption:
+  {{- range list "state" "ephemeral" }}
+  {{ . }}:
+    provider: luks2
+    keys:
+    - slot: 0
+      tpm: {}
+  {{- end }}
+{{- end }}
+{{- end }}
+`,
+	"talm/templates/_topology.tpl": `{{- define "talm.topology" }}
+{{- $node := .Node | default dict }}
+{{- toJson ($node.Topology | default dict) }}
+{{- end }}
+
+{{- define "talm.topology.labels" }}
+{{- $topology := include "talm.topology" . | fromJson }}
+{{- $labels := dict }}
+{{- with $topology.region }}
+{{- $_ := set $labels "topology.kubernetes.io/region" . }}
+{{- end }}
+{{- with $topology.zone }}
+{{- $_ := set $labels "topology.kubernetes.io/zone" . }}
+{{- end }}
+{{- with $topology.rack }}
+{{- $_ := set $labels "topology.talm.dev/rack" . }}
+{{- end }}
+{{- toJson $labels }}
+{{- end }}
+
+{{- define "talm.advertised_subnets" }}
+{{- $topology := include "talm.topology" . | fromJson }}
+{{- $zones := .Values.zones | default dict }}
+{{- $zone := get $zones ($topology.zone | default "") | default dict }}
+{{- toYaml ($zone.advertisedSubnets | default .Values.advertisedSubnets) }}
+{{- end }}
 `,
 }
 
 var AvailablePresets = []string{
 	"generic",
 	"cozystack",
+	"gpu-worker",
 }
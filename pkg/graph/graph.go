@@ -0,0 +1,258 @@
+// Package graph builds a graph of chart structure and node assignments,
+// and writes it in DOT or Mermaid format.
+package graph
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/chart"
+)
+
+// Kind is a kind of graph node.
+type Kind string
+
+const (
+	KindChart    Kind = "chart"
+	KindTemplate Kind = "template"
+	KindHelper   Kind = "helper"
+	KindValues   Kind = "values"
+	KindNode     Kind = "node"
+)
+
+// Node is a vertex of the graph.
+type Node struct {
+	ID    string
+	Label string
+	Kind  Kind
+	// Unused is set for templates not assigned to any node and helpers never included.
+	Unused bool
+}
+
+// Edge is a directed edge of the graph.
+type Edge struct {
+	From  string
+	To    string
+	Label string
+}
+
+// Graph is a directed graph of chart files and nodes.
+type Graph struct {
+	nodes map[string]*Node
+	edges []Edge
+}
+
+// New returns an empty graph.
+func New() *Graph {
+	return &Graph{nodes: map[string]*Node{}}
+}
+
+// AddNode adds a node, nodes with the same ID are added only once.
+func (g *Graph) AddNode(id, label string, kind Kind) {
+	if _, ok := g.nodes[id]; ok {
+		return
+	}
+	g.nodes[id] = &Node{ID: id, Label: label, Kind: kind}
+}
+
+// AddEdge adds an edge between nodes.
+func (g *Graph) AddEdge(from, to, label string) {
+	g.edges = append(g.edges, Edge{From: from, To: to, Label: label})
+}
+
+// Nodes returns the nodes sorted by ID.
+func (g *Graph) Nodes() []*Node {
+	nodes := make([]*Node, 0, len(g.nodes))
+	for _, node := range g.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	return nodes
+}
+
+// Edges returns the edges in the order they were added.
+func (g *Graph) Edges() []Edge {
+	return g.edges
+}
+
+var (
+	defineRe  = regexp.MustCompile(`define\s+"([^"]+)"`)
+	includeRe = regexp.MustCompile(`(?:include|template)\s+"([^"]+)"`)
+)
+
+// TemplateID returns the graph ID of a template file of the chart.
+func TemplateID(name string) string {
+	return "template:" + name
+}
+
+// AddChart adds the chart, its subcharts, templates and helper definitions with the includes between them.
+//
+// Template names are relative to the project root, like in the modelines.
+func (g *Graph) AddChart(chrt *chart.Chart) {
+	defined := map[string]struct{}{}
+	includes := map[string][]string{}
+	g.addChart(chrt, "", "", defined, includes)
+
+	for _, from := range sortedKeys(includes) {
+		for _, name := range includes[from] {
+			to := "helper:" + name
+			if _, ok := defined[name]; !ok {
+				g.AddNode(to, name, KindHelper)
+			}
+			g.AddEdge(from, to, "include")
+		}
+	}
+}
+
+func (g *Graph) addChart(chrt *chart.Chart, dir, parent string, defined map[string]struct{}, includes map[string][]string) {
+	chartID := "chart:" + chrt.Name()
+	g.AddNode(chartID, chrt.Name(), KindChart)
+	if parent != "" {
+		g.AddEdge(parent, chartID, "depends")
+	}
+
+	for _, tpl := range chrt.Templates {
+		name := path.Join(dir, tpl.Name)
+		id := TemplateID(name)
+		g.AddNode(id, name, KindTemplate)
+		g.AddEdge(chartID, id, "")
+
+		// Includes inside a define block belong to the helper, the rest to the template itself
+		data := string(tpl.Data)
+		owners := []string{id}
+		bounds := []int{0}
+		for _, match := range defineRe.FindAllStringSubmatchIndex(data, -1) {
+			name := data[match[2]:match[3]]
+			helperID := "helper:" + name
+			defined[name] = struct{}{}
+			g.AddNode(helperID, name, KindHelper)
+			g.AddEdge(id, helperID, "defines")
+			owners = append(owners, helperID)
+			bounds = append(bounds, match[1])
+		}
+		bounds = append(bounds, len(data))
+
+		for i, owner := range owners {
+			seen := map[string]struct{}{}
+			for _, match := range includeRe.FindAllStringSubmatch(data[bounds[i]:bounds[i+1]], -1) {
+				if _, ok := seen[match[1]]; ok {
+					continue
+				}
+				seen[match[1]] = struct{}{}
+				includes[owner] = append(includes[owner], match[1])
+			}
+		}
+	}
+
+	for _, dep := range chrt.Dependencies() {
+		g.addChart(dep, path.Join(dir, "charts", dep.Name()), chartID, defined, includes)
+	}
+}
+
+// MarkUnused marks templates which are not partials and have no incoming edges
+// from nodes, and helpers which are never included.
+func (g *Graph) MarkUnused() {
+	used := map[string]struct{}{}
+	for _, edge := range g.edges {
+		if edge.Label == "include" || g.nodes[edge.From] != nil && g.nodes[edge.From].Kind == KindNode {
+			used[edge.To] = struct{}{}
+		}
+	}
+
+	for id, node := range g.nodes {
+		switch node.Kind {
+		case KindTemplate:
+			if strings.HasPrefix(path.Base(node.Label), "_") || path.Ext(node.Label) == ".txt" {
+				continue
+			}
+		case KindHelper:
+		default:
+			continue
+		}
+		if _, ok := used[id]; !ok {
+			node.Unused = true
+		}
+	}
+}
+
+// WriteDOT writes the graph in Graphviz DOT format.
+func (g *Graph) WriteDOT(w io.Writer) error {
+	shapes := map[Kind]string{
+		KindChart:    "folder",
+		KindTemplate: "note",
+		KindHelper:   "component",
+		KindValues:   "cylinder",
+		KindNode:     "box3d",
+	}
+
+	var b strings.Builder
+	b.WriteString("digraph talm {\n")
+	b.WriteString("  rankdir=LR;\n")
+	for _, node := range g.Nodes() {
+		style := ""
+		if node.Unused {
+			style = `, style=dashed, color=gray`
+		}
+		fmt.Fprintf(&b, "  %q [label=%q, shape=%s%s];\n", node.ID, node.Label, shapes[node.Kind], style)
+	}
+	for _, edge := range g.edges {
+		if edge.Label != "" {
+			fmt.Fprintf(&b, "  %q -> %q [label=%q];\n", edge.From, edge.To, edge.Label)
+		} else {
+			fmt.Fprintf(&b, "  %q -> %q;\n", edge.From, edge.To)
+		}
+	}
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteMermaid writes the graph as a Mermaid flowchart.
+func (g *Graph) WriteMermaid(w io.Writer) error {
+	shapes := map[Kind][2]string{
+		KindChart:    {"[", "]"},
+		KindTemplate: {"[/", "/]"},
+		KindHelper:   {"([", "])"},
+		KindValues:   {"[(", ")]"},
+		KindNode:     {"{{", "}}"},
+	}
+
+	ids := map[string]string{}
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+	for i, node := range g.Nodes() {
+		ids[node.ID] = fmt.Sprintf("n%d", i)
+		shape := shapes[node.Kind]
+		fmt.Fprintf(&b, "  %s%s\"%s\"%s\n", ids[node.ID], shape[0], strings.NewReplacer(`"`, "#quot;", "\n", "<br/>").Replace(node.Label), shape[1])
+	}
+	for _, edge := range g.edges {
+		if edge.Label != "" {
+			fmt.Fprintf(&b, "  %s -->|%s| %s\n", ids[edge.From], edge.Label, ids[edge.To])
+		} else {
+			fmt.Fprintf(&b, "  %s --> %s\n", ids[edge.From], ids[edge.To])
+		}
+	}
+	b.WriteString("  classDef unused stroke-dasharray: 5 5,color:gray\n")
+	for _, node := range g.Nodes() {
+		if node.Unused {
+			fmt.Fprintf(&b, "  class %s unused\n", ids[node.ID])
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
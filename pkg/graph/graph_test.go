@@ -0,0 +1,74 @@
+package graph
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/chart"
+)
+
+func TestAddChart(t *testing.T) {
+	library := &chart.Chart{
+		Metadata: &chart.Metadata{Name: "talm"},
+		Templates: []*chart.File{
+			{Name: "templates/_helpers.tpl", Data: []byte(`{{- define "talm.hostname" }}{{ include "talm.name" . }}{{ end }}
+{{- define "talm.name" }}node{{ end }}
+{{- define "talm.unused" }}{{ end }}`)},
+		},
+	}
+	root := &chart.Chart{
+		Metadata: &chart.Metadata{Name: "cluster"},
+		Templates: []*chart.File{
+			{Name: "templates/controlplane.yaml", Data: []byte(`hostname: {{ include "talm.hostname" . }}`)},
+			{Name: "templates/worker.yaml", Data: []byte(`hostname: {{ include "talm.hostname" . }}`)},
+		},
+	}
+	root.AddDependency(library)
+
+	g := New()
+	g.AddChart(root)
+	g.AddNode("node:nodes/cp1.yaml", "nodes/cp1.yaml", KindNode)
+	g.AddEdge("node:nodes/cp1.yaml", TemplateID("templates/controlplane.yaml"), "")
+	g.MarkUnused()
+
+	unused := map[string]bool{}
+	for _, node := range g.Nodes() {
+		unused[node.ID] = node.Unused
+	}
+
+	for id, want := range map[string]bool{
+		"template:templates/controlplane.yaml":        false,
+		"template:templates/worker.yaml":              true,
+		"template:charts/talm/templates/_helpers.tpl": false,
+		"helper:talm.hostname":                        false,
+		"helper:talm.name":                            false,
+		"helper:talm.unused":                          true,
+		"chart:talm":                                  false,
+	} {
+		got, ok := unused[id]
+		if !ok {
+			t.Errorf("node %s not found", id)
+			continue
+		}
+		if got != want {
+			t.Errorf("node %s: unused = %v, want %v", id, got, want)
+		}
+	}
+
+	var dot bytes.Buffer
+	if err := g.WriteDOT(&dot); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(dot.String(), `"helper:talm.hostname" -> "helper:talm.name" [label="include"];`) {
+		t.Errorf("include of a helper is not attributed to the helper:\n%s", dot.String())
+	}
+
+	var mermaid bytes.Buffer
+	if err := g.WriteMermaid(&mermaid); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(mermaid.String(), "flowchart LR\n") {
+		t.Errorf("unexpected mermaid output:\n%s", mermaid.String())
+	}
+}
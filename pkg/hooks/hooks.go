@@ -0,0 +1,229 @@
+// Package hooks runs commands before and after a node file is applied or upgraded,
+// e.g. to drain a node before the upgrade and uncordon it afterwards, and orders
+// the node files by machine type.
+//
+// Hooks and the order are configured in Chart.yaml:
+//
+//	hooks:
+//	  order: [controlplane, worker]
+//	  pre:
+//	  - name: drain
+//	    command: ["sh", "-c", "kubectl drain --ignore-daemonsets --delete-emptydir-data $TALM_HOSTNAME"]
+//	    commands: [upgrade]
+//	    machineTypes: [worker]
+//	    timeout: 5m
+//	  post:
+//	  - name: uncordon
+//	    command: ["sh", "-c", "kubectl uncordon $TALM_HOSTNAME"]
+//	    commands: [upgrade]
+//	    onFailure: continue
+//
+// A command gets the stage, the talm command, the file, its nodes, machine type and hostname
+// in the TALM_HOOK, TALM_COMMAND, TALM_FILE, TALM_NODES, TALM_MACHINE_TYPE and TALM_HOSTNAME
+// environment variables. A failing hook aborts the run unless its onFailure is continue.
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultTimeout limits a hook run if no timeout is configured.
+const defaultTimeout = 5 * time.Minute
+
+// Stages of the hooks.
+const (
+	Pre  = "pre"
+	Post = "post"
+)
+
+// Failure policies of the hooks.
+const (
+	Abort    = "abort"
+	Continue = "continue"
+)
+
+// Config is the hooks section of Chart.yaml.
+type Config struct {
+	// Order lists the machine types in the order their node files are processed,
+	// node files of other machine types are processed last.
+	Order []string `yaml:"order"`
+	Pre   []Hook   `yaml:"pre"`
+	Post  []Hook   `yaml:"post"`
+}
+
+// Hook describes a command run before or after a node file is processed.
+type Hook struct {
+	Name    string   `yaml:"name"`
+	Command []string `yaml:"command"`
+	// Commands limits the hook to talm commands, e.g. apply or upgrade, all by default.
+	Commands []string `yaml:"commands"`
+	// MachineTypes limits the hook to node files of machine types, all by default.
+	MachineTypes []string `yaml:"machineTypes"`
+	Timeout      string   `yaml:"timeout"`
+	// OnFailure is abort (default) or continue.
+	OnFailure string `yaml:"onFailure"`
+}
+
+// Request describes the node file the hooks run for.
+type Request struct {
+	Command     string
+	File        string
+	Nodes       []string
+	MachineType string
+	Hostname    string
+}
+
+// HookError is returned when a hook with the abort policy fails.
+type HookError struct {
+	Hook   string
+	Stage  string
+	Reason string
+}
+
+func (e *HookError) Error() string {
+	return fmt.Sprintf("%s hook %s failed: %s", e.Stage, e.Hook, e.Reason)
+}
+
+// Check validates the config of the hooks without running them.
+func (c Config) Check() error {
+	for stage, hooks := range map[string][]Hook{Pre: c.Pre, Post: c.Post} {
+		for _, hook := range hooks {
+			if hook.Name == "" {
+				return fmt.Errorf("%s hook has no name", stage)
+			}
+			if len(hook.Command) == 0 {
+				return fmt.Errorf("%s hook %s: command is not set", stage, hook.Name)
+			}
+			if hook.OnFailure != "" && hook.OnFailure != Abort && hook.OnFailure != Continue {
+				return fmt.Errorf("%s hook %s: invalid onFailure %q, must be %s or %s", stage, hook.Name, hook.OnFailure, Abort, Continue)
+			}
+			if hook.Timeout != "" {
+				if _, err := time.ParseDuration(hook.Timeout); err != nil {
+					return fmt.Errorf("%s hook %s: invalid timeout: %w", stage, hook.Name, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Run runs the hooks of the stage matching the request in order, the output of the hooks
+// is written to out. It returns a HookError from the first failing hook with the abort policy,
+// failures of the other hooks are reported to out.
+func (c Config) Run(ctx context.Context, stage string, req Request, out io.Writer) error {
+	if err := c.Check(); err != nil {
+		return err
+	}
+
+	hooks := c.Pre
+	if stage == Post {
+		hooks = c.Post
+	}
+
+	for _, hook := range hooks {
+		if !matches(hook.Commands, req.Command) || !matches(hook.MachineTypes, req.MachineType) {
+			continue
+		}
+
+		timeout := defaultTimeout
+		if hook.Timeout != "" {
+			timeout, _ = time.ParseDuration(hook.Timeout)
+		}
+
+		fmt.Fprintf(out, "- talm: %s hook %s, file=%s\n", stage, hook.Name, req.File)
+
+		runCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := runCommand(runCtx, hook, stage, req, out)
+		if err != nil && errors.Is(runCtx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("timed out after %s", timeout)
+		}
+		cancel()
+
+		if err == nil {
+			continue
+		}
+		if hook.OnFailure == Continue {
+			fmt.Fprintf(out, "Warning: %s hook %s failed: %s, continuing\n", stage, hook.Name, err)
+			continue
+		}
+		return &HookError{Hook: hook.Name, Stage: stage, Reason: err.Error()}
+	}
+
+	return nil
+}
+
+func runCommand(ctx context.Context, hook Hook, stage string, req Request, out io.Writer) error {
+	cmd := exec.CommandContext(ctx, hook.Command[0], hook.Command[1:]...)
+	cmd.Env = append(os.Environ(),
+		"TALM_HOOK="+stage,
+		"TALM_COMMAND="+req.Command,
+		"TALM_FILE="+req.File,
+		"TALM_NODES="+strings.Join(req.Nodes, ","),
+		"TALM_MACHINE_TYPE="+req.MachineType,
+		"TALM_HOSTNAME="+req.Hostname,
+	)
+
+	var stderr bytes.Buffer
+	cmd.Stdout = out
+	cmd.Stderr = io.MultiWriter(out, &stderr)
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if reason := strings.TrimSpace(stderr.String()); reason != "" {
+			return errors.New(lastLine(reason))
+		}
+	}
+	return err
+}
+
+// SortFiles orders the files by the machine types of the order, keeping the order of the files
+// with the same machine type. Files of machine types not in the order are moved to the end.
+func (c Config) SortFiles(files []string, machineTypes map[string]string) []string {
+	if len(c.Order) == 0 {
+		return files
+	}
+
+	rank := func(file string) int {
+		for i, machineType := range c.Order {
+			if machineTypes[file] == machineType {
+				return i
+			}
+		}
+		return len(c.Order)
+	}
+
+	sorted := append([]string(nil), files...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return rank(sorted[i]) < rank(sorted[j])
+	})
+	return sorted
+}
+
+func matches(filter []string, value string) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	for _, item := range filter {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func lastLine(s string) string {
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		return s[i+1:]
+	}
+	return s
+}
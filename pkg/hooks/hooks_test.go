@@ -0,0 +1,97 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+
+	cfg := Config{
+		Pre: []Hook{
+			{Name: "drain", Command: []string{"sh", "-c", `echo "drain $TALM_HOSTNAME $TALM_MACHINE_TYPE $TALM_COMMAND"`}, MachineTypes: []string{"worker"}},
+			{Name: "upgrade-only", Command: []string{"sh", "-c", "echo upgrade-only"}, Commands: []string{"upgrade"}},
+		},
+		Post: []Hook{
+			{Name: "flaky", Command: []string{"sh", "-c", "echo broken >&2; exit 1"}, OnFailure: Continue},
+			{Name: "check", Command: []string{"sh", "-c", `echo "not ready $TALM_NODES" >&2; exit 3`}},
+			{Name: "never", Command: []string{"sh", "-c", "echo never"}},
+		},
+	}
+	req := Request{Command: "apply", File: "nodes/w1.yaml", Nodes: []string{"10.0.0.1", "10.0.0.2"}, MachineType: "worker", Hostname: "w1"}
+
+	var out bytes.Buffer
+	if err := cfg.Run(context.Background(), Pre, req, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "drain w1 worker apply") || strings.Contains(out.String(), "upgrade-only") {
+		t.Errorf("unexpected output of pre hooks:\n%s", out.String())
+	}
+
+	out.Reset()
+	req.MachineType = "controlplane"
+	if err := cfg.Run(context.Background(), Pre, req, &out); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "drain") {
+		t.Errorf("hook must not run for other machine types:\n%s", out.String())
+	}
+
+	out.Reset()
+	err := cfg.Run(context.Background(), Post, req, &out)
+	var hookErr *HookError
+	if !errors.As(err, &hookErr) || hookErr.Hook != "check" || hookErr.Reason != "not ready 10.0.0.1,10.0.0.2" {
+		t.Errorf("expected failure of the check hook, got %v", err)
+	}
+	if !strings.Contains(out.String(), "Warning: post hook flaky failed: broken, continuing") || strings.Contains(out.String(), "never") {
+		t.Errorf("unexpected output of post hooks:\n%s", out.String())
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sleep")
+	}
+
+	cfg := Config{Pre: []Hook{{Name: "slow", Command: []string{"sleep", "10"}, Timeout: "100ms"}}}
+	err := cfg.Run(context.Background(), Pre, Request{}, &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "timed out after 100ms") {
+		t.Errorf("expected timeout, got %v", err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	for _, cfg := range []Config{
+		{Pre: []Hook{{Command: []string{"true"}}}},
+		{Post: []Hook{{Name: "empty"}}},
+		{Pre: []Hook{{Name: "policy", Command: []string{"true"}, OnFailure: "ignore"}}},
+		{Pre: []Hook{{Name: "timeout", Command: []string{"true"}, Timeout: "soon"}}},
+	} {
+		if err := cfg.Check(); err == nil {
+			t.Errorf("expected error for %+v", cfg)
+		}
+	}
+}
+
+func TestSortFiles(t *testing.T) {
+	files := []string{"w1.yaml", "cp1.yaml", "other.yaml", "w2.yaml", "cp2.yaml"}
+	machineTypes := map[string]string{"w1.yaml": "worker", "w2.yaml": "worker", "cp1.yaml": "controlplane", "cp2.yaml": "controlplane"}
+
+	sorted := Config{Order: []string{"controlplane", "worker"}}.SortFiles(files, machineTypes)
+	expected := []string{"cp1.yaml", "cp2.yaml", "w1.yaml", "w2.yaml", "other.yaml"}
+	if !reflect.DeepEqual(sorted, expected) {
+		t.Errorf("expected %v, got %v", expected, sorted)
+	}
+
+	if sorted := (Config{}).SortFiles(files, machineTypes); !reflect.DeepEqual(sorted, files) {
+		t.Errorf("files must keep their order without order, got %v", sorted)
+	}
+}
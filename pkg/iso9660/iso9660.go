@@ -0,0 +1,196 @@
+// Package iso9660 writes minimal ISO 9660 images with a flat list of files.
+//
+// It is used to build Talos configuration media: Talos booted with
+// talos.config=metal-iso reads config.yaml from a volume labeled metal-iso.
+package iso9660
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+const sectorSize = 2048
+
+// First sectors are reserved for the system area.
+const (
+	pvdSector        = 16
+	terminatorSector = 17
+	lPathTableSector = 18
+	mPathTableSector = 19
+	rootDirSector    = 20
+	firstFileSector  = 21
+)
+
+// padSectors are appended to the image like mkisofs does, to avoid read-ahead errors on some drives.
+const padSectors = 150
+
+// Write writes an ISO 9660 image with the given volume label and files to w.
+//
+// File names are stored as uppercase level 2 identifiers ("CONFIG.YAML;1"),
+// which Linux shows in lowercase when mounted with default options.
+func Write(w io.Writer, label string, files map[string][]byte, modTime time.Time) error {
+	if len(label) > 32 {
+		return fmt.Errorf("volume label %q is longer than 32 characters", label)
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		if err := validateName(name); err != nil {
+			return err
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Root directory records
+	var dir bytes.Buffer
+	dir.Write(dirRecord([]byte{0}, rootDirSector, sectorSize, true, modTime))
+	dir.Write(dirRecord([]byte{1}, rootDirSector, sectorSize, true, modTime))
+
+	sector := uint32(firstFileSector)
+	for _, name := range names {
+		id := []byte(strings.ToUpper(name) + ";1")
+		dir.Write(dirRecord(id, sector, uint32(len(files[name])), false, modTime))
+		sector += sectors(len(files[name]))
+	}
+	if dir.Len() > sectorSize {
+		return fmt.Errorf("too many files for a single directory sector")
+	}
+
+	totalSectors := sector + padSectors
+
+	image := make([]byte, firstFileSector*sectorSize)
+	copy(image[pvdSector*sectorSize:], primaryVolumeDescriptor(label, totalSectors, modTime))
+	copy(image[terminatorSector*sectorSize:], append([]byte{255}, []byte("CD001\x01")...))
+	copy(image[lPathTableSector*sectorSize:], pathTable(binary.LittleEndian))
+	copy(image[mPathTableSector*sectorSize:], pathTable(binary.BigEndian))
+	copy(image[rootDirSector*sectorSize:], dir.Bytes())
+
+	if _, err := w.Write(image); err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		data := files[name]
+		padded := make([]byte, int(sectors(len(data)))*sectorSize)
+		copy(padded, data)
+		if _, err := w.Write(padded); err != nil {
+			return err
+		}
+	}
+
+	_, err := w.Write(make([]byte, padSectors*sectorSize))
+	return err
+}
+
+func validateName(name string) error {
+	base, ext, _ := strings.Cut(name, ".")
+	if base == "" || len(name) > 30 || strings.Contains(ext, ".") {
+		return fmt.Errorf("file name %q is not a valid ISO 9660 level 2 name", name)
+	}
+
+	for _, r := range strings.ToUpper(base + ext) {
+		if !(r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_') {
+			return fmt.Errorf("file name %q contains invalid character %q", name, r)
+		}
+	}
+
+	return nil
+}
+
+func sectors(size int) uint32 {
+	n := uint32((size + sectorSize - 1) / sectorSize)
+	if n == 0 {
+		// Keep an extent for empty files as well
+		n = 1
+	}
+	return n
+}
+
+func bothEndian32(v uint32) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint32(b, v)
+	binary.BigEndian.PutUint32(b[4:], v)
+	return b
+}
+
+func bothEndian16(v uint16) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint16(b, v)
+	binary.BigEndian.PutUint16(b[2:], v)
+	return b
+}
+
+func dirRecord(id []byte, extent, size uint32, isDir bool, t time.Time) []byte {
+	length := 33 + len(id)
+	if length%2 == 1 {
+		length++
+	}
+
+	r := make([]byte, length)
+	r[0] = byte(length)
+	copy(r[2:], bothEndian32(extent))
+	copy(r[10:], bothEndian32(size))
+
+	t = t.UTC()
+	copy(r[18:], []byte{byte(t.Year() - 1900), byte(t.Month()), byte(t.Day()), byte(t.Hour()), byte(t.Minute()), byte(t.Second()), 0})
+
+	if isDir {
+		r[25] = 2
+	}
+	copy(r[28:], bothEndian16(1))
+	r[32] = byte(len(id))
+	copy(r[33:], id)
+
+	return r
+}
+
+func pathTable(order binary.ByteOrder) []byte {
+	t := make([]byte, 10)
+	t[0] = 1
+	order.PutUint32(t[2:], rootDirSector)
+	order.PutUint16(t[6:], 1)
+	return t
+}
+
+func decDateTime(t time.Time) []byte {
+	return append([]byte(t.UTC().Format("20060102150405")+"00"), 0)
+}
+
+func padded(s string, n int) []byte {
+	return []byte(s + strings.Repeat(" ", n-len(s)))
+}
+
+func primaryVolumeDescriptor(label string, totalSectors uint32, t time.Time) []byte {
+	d := make([]byte, sectorSize)
+	d[0] = 1
+	copy(d[1:], "CD001")
+	d[6] = 1
+	copy(d[8:], padded("LINUX", 32))
+	copy(d[40:], padded(label, 32))
+	copy(d[80:], bothEndian32(totalSectors))
+	copy(d[120:], bothEndian16(1))
+	copy(d[124:], bothEndian16(1))
+	copy(d[128:], bothEndian16(sectorSize))
+	copy(d[132:], bothEndian32(10))
+	binary.LittleEndian.PutUint32(d[140:], lPathTableSector)
+	binary.BigEndian.PutUint32(d[148:], mPathTableSector)
+	copy(d[156:], dirRecord([]byte{0}, rootDirSector, sectorSize, true, t))
+	copy(d[190:], padded("", 128))
+	copy(d[318:], padded("", 128))
+	copy(d[446:], padded("", 128))
+	copy(d[574:], padded("TALM", 128))
+	copy(d[702:], padded("", 37*3))
+	copy(d[813:], decDateTime(t))
+	copy(d[830:], decDateTime(t))
+	copy(d[847:], append([]byte(strings.Repeat("0", 16)), 0))
+	copy(d[864:], decDateTime(t))
+	d[881] = 1
+
+	return d
+}
@@ -0,0 +1,54 @@
+package iso9660
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestWrite(t *testing.T) {
+	config := []byte("version: v1alpha1\n")
+
+	var buf bytes.Buffer
+	if err := Write(&buf, "metal-iso", map[string][]byte{"config.yaml": config}, time.Unix(0, 0)); err != nil {
+		t.Fatal(err)
+	}
+	image := buf.Bytes()
+
+	if len(image)%sectorSize != 0 {
+		t.Fatalf("image size %d is not a multiple of sector size", len(image))
+	}
+
+	pvd := image[pvdSector*sectorSize:]
+	if string(pvd[1:6]) != "CD001" {
+		t.Fatalf("primary volume descriptor not found")
+	}
+	if label := string(bytes.TrimRight(pvd[40:72], " ")); label != "metal-iso" {
+		t.Errorf("expected label metal-iso, got %q", label)
+	}
+	if size := binary.LittleEndian.Uint32(pvd[80:]); int(size)*sectorSize != len(image) {
+		t.Errorf("volume space size %d does not match image size %d", size, len(image))
+	}
+
+	// Third record of the root directory, after "." and ".."
+	dir := image[rootDirSector*sectorSize:]
+	record := dir[dir[0]+dir[dir[0]]:]
+	if name := string(record[33 : 33+record[32]]); name != "CONFIG.YAML;1" {
+		t.Fatalf("unexpected file name %q", name)
+	}
+
+	extent := binary.LittleEndian.Uint32(record[2:])
+	size := binary.LittleEndian.Uint32(record[10:])
+	if data := image[int(extent)*sectorSize : int(extent)*sectorSize+int(size)]; !bytes.Equal(data, config) {
+		t.Errorf("unexpected file data %q", data)
+	}
+}
+
+func TestWriteInvalidName(t *testing.T) {
+	for _, name := range []string{"", "a.b.c", "config-1.yaml", ".yaml"} {
+		if err := Write(&bytes.Buffer{}, "metal-iso", map[string][]byte{name: nil}, time.Now()); err == nil {
+			t.Errorf("expected error for %q", name)
+		}
+	}
+}
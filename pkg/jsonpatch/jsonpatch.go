@@ -0,0 +1,134 @@
+// Package jsonpatch describes the difference of two documents as a JSON Patch (RFC 6902), so
+// tools consuming the diffs of machine configs can reason about the changed fields instead of
+// parsing unified diffs of YAML.
+package jsonpatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Operation is an operation of a JSON Patch, the value is set for add and replace.
+type Operation struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value any    `json:"-"`
+}
+
+// MarshalJSON encodes the operation, with the value even if it is null for add and replace.
+func (o Operation) MarshalJSON() ([]byte, error) {
+	if o.Op == "remove" {
+		return json.Marshal(struct {
+			Op   string `json:"op"`
+			Path string `json:"path"`
+		}{o.Op, o.Path})
+	}
+	return json.Marshal(struct {
+		Op    string `json:"op"`
+		Path  string `json:"path"`
+		Value any    `json:"value"`
+	}{o.Op, o.Path, o.Value})
+}
+
+// Documents decodes the YAML documents of the stream as JSON values, e.g. numbers become float64.
+func Documents(data []byte) ([]any, error) {
+	documents := []any{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var document any
+		err := decoder.Decode(&document)
+		if errors.Is(err, io.EOF) {
+			return documents, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if document == nil {
+			continue
+		}
+
+		encoded, err := json.Marshal(document)
+		if err != nil {
+			return nil, fmt.Errorf("document %d is not representable as JSON: %w", len(documents), err)
+		}
+		var value any
+		if err := json.Unmarshal(encoded, &value); err != nil {
+			return nil, err
+		}
+		documents = append(documents, value)
+	}
+}
+
+// Diff returns the operations turning the JSON value from into to. Objects are compared key by
+// key, arrays element by element, the elements missing or added at their end are removed or added.
+func Diff(from, to any) []Operation {
+	return diff("", from, to, nil)
+}
+
+func diff(path string, from, to any, ops []Operation) []Operation {
+	switch from := from.(type) {
+	case map[string]any:
+		to, ok := to.(map[string]any)
+		if !ok {
+			break
+		}
+		for _, key := range sortedKeys(from) {
+			if _, ok := to[key]; !ok {
+				ops = append(ops, Operation{Op: "remove", Path: path + "/" + escape(key)})
+			}
+		}
+		for _, key := range sortedKeys(to) {
+			if value, ok := from[key]; ok {
+				ops = diff(path+"/"+escape(key), value, to[key], ops)
+			} else {
+				ops = append(ops, Operation{Op: "add", Path: path + "/" + escape(key), Value: to[key]})
+			}
+		}
+		return ops
+	case []any:
+		to, ok := to.([]any)
+		if !ok {
+			break
+		}
+		common := min(len(from), len(to))
+		for i := 0; i < common; i++ {
+			ops = diff(path+"/"+strconv.Itoa(i), from[i], to[i], ops)
+		}
+		// Removed from the end, so the indexes of the remaining elements don't move
+		for i := len(from) - 1; i >= common; i-- {
+			ops = append(ops, Operation{Op: "remove", Path: path + "/" + strconv.Itoa(i)})
+		}
+		for i := common; i < len(to); i++ {
+			ops = append(ops, Operation{Op: "add", Path: path + "/" + strconv.Itoa(i), Value: to[i]})
+		}
+		return ops
+	}
+
+	if !reflect.DeepEqual(from, to) {
+		ops = append(ops, Operation{Op: "replace", Path: path, Value: to})
+	}
+	return ops
+}
+
+// escape escapes the key as a reference token of a JSON pointer (RFC 6901).
+func escape(key string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,84 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	from, err := Documents([]byte(`version: v1alpha1
+machine:
+    type: worker
+    nodeLabels:
+        zone: a
+        node.kubernetes.io/exclude: "true"
+    certSANs:
+        - 10.0.0.1
+        - 10.0.0.2
+        - 10.0.0.3
+    kubelet:
+        image: kubelet:v1.30.0
+---
+apiVersion: v1alpha1
+kind: ExtensionServiceConfig
+name: nut
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := Documents([]byte(`version: v1alpha1
+machine:
+    type: worker
+    nodeLabels:
+        zone: b
+        rack: r1
+    certSANs:
+        - 10.0.0.1
+        - 10.0.0.4
+    kubelet: null
+---
+apiVersion: v1alpha1
+kind: ExtensionServiceConfig
+name: nut
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(Diff(from, to))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `[{"op":"replace","path":"/0/machine/certSANs/1","value":"10.0.0.4"},` +
+		`{"op":"remove","path":"/0/machine/certSANs/2"},` +
+		`{"op":"replace","path":"/0/machine/kubelet","value":null},` +
+		`{"op":"remove","path":"/0/machine/nodeLabels/node.kubernetes.io~1exclude"},` +
+		`{"op":"add","path":"/0/machine/nodeLabels/rack","value":"r1"},` +
+		`{"op":"replace","path":"/0/machine/nodeLabels/zone","value":"b"}]`
+	if string(data) != expected {
+		t.Errorf("unexpected patch:\n%s\nexpected:\n%s", data, expected)
+	}
+}
+
+func TestDiffEqual(t *testing.T) {
+	documents, err := Documents([]byte("machine:\n    type: worker\n    certSANs: [a, b]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ops := Diff(documents, documents); len(ops) != 0 {
+		t.Errorf("expected no operations, got %v", ops)
+	}
+}
+
+func TestDiffDocuments(t *testing.T) {
+	data, err := json.Marshal(Diff([]any{"a", "b", "c"}, []any{"a", map[string]any{"b": 1.0}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `[{"op":"replace","path":"/1","value":{"b":1}},{"op":"remove","path":"/2"}]`
+	if string(data) != expected {
+		t.Errorf("unexpected patch:\n%s\nexpected:\n%s", data, expected)
+	}
+}
@@ -0,0 +1,74 @@
+package modeline
+
+import (
+	"strings"
+)
+
+// machineTypePrefix marks a document of a node file for the nodes of a machine type,
+// e.g. "# talm: machine-type=worker" in the comments heading the document.
+const machineTypePrefix = prefix + "machine-type="
+
+// splitDocuments splits a multi-document YAML at the document separators, keeping the text
+// of the documents as it is.
+func splitDocuments(data []byte) []string {
+	var (
+		docs    []string
+		current strings.Builder
+	)
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		if strings.TrimSpace(line) == "---" {
+			docs = append(docs, current.String())
+			current.Reset()
+			continue
+		}
+		current.WriteString(line)
+	}
+	return append(docs, current.String())
+}
+
+// documentMachineType returns the machine type the document is marked for, or an empty string.
+// The marker is looked up in the comments before the first line of YAML of the document.
+func documentMachineType(doc string) string {
+	for _, line := range strings.Split(doc, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, machineTypePrefix) {
+			return strings.TrimSpace(strings.TrimPrefix(line, machineTypePrefix))
+		}
+		if line != "" && !strings.HasPrefix(line, "#") {
+			return ""
+		}
+	}
+	return ""
+}
+
+// MachineTypes returns the machine types the documents of the multi-document YAML are marked for,
+// in the order they appear. Node files rendered from a single template have none.
+func MachineTypes(data []byte) []string {
+	var types []string
+	for _, doc := range splitDocuments(data) {
+		machineType := documentMachineType(doc)
+		if machineType == "" {
+			continue
+		}
+		known := false
+		for _, t := range types {
+			known = known || t == machineType
+		}
+		if !known {
+			types = append(types, machineType)
+		}
+	}
+	return types
+}
+
+// FilterMachineType returns the documents of the multi-document YAML for the nodes of the machine
+// type: the documents marked for it and the documents marked for no machine type.
+func FilterMachineType(data []byte, machineType string) []byte {
+	var docs []string
+	for _, doc := range splitDocuments(data) {
+		if t := documentMachineType(doc); t == "" || t == machineType {
+			docs = append(docs, doc)
+		}
+	}
+	return []byte(strings.Join(docs, "---\n"))
+}
@@ -0,0 +1,60 @@
+package modeline
+
+import (
+	"reflect"
+	"testing"
+)
+
+const machineTypesFile = `# talm: nodes=["10.0.0.1","10.0.0.2"], endpoints=["10.0.0.1"], templates=["templates/cluster.yaml"]
+cluster:
+  clusterName: test
+---
+# talm: machine-type=controlplane
+machine:
+  type: controlplane
+---
+# Workers run the storage
+# talm: machine-type=worker
+machine:
+  type: worker
+---
+apiVersion: v1alpha1
+kind: KmsgLogConfig
+name: remote-log
+url: udp://10.0.0.100:6001/
+`
+
+func TestMachineTypes(t *testing.T) {
+	if types := MachineTypes([]byte(machineTypesFile)); !reflect.DeepEqual(types, []string{"controlplane", "worker"}) {
+		t.Errorf("unexpected machine types %v", types)
+	}
+	if types := MachineTypes([]byte("machine:\n  type: worker\n")); len(types) != 0 {
+		t.Errorf("expected no machine types, got %v", types)
+	}
+}
+
+func TestFilterMachineType(t *testing.T) {
+	expected := `# talm: nodes=["10.0.0.1","10.0.0.2"], endpoints=["10.0.0.1"], templates=["templates/cluster.yaml"]
+cluster:
+  clusterName: test
+---
+# Workers run the storage
+# talm: machine-type=worker
+machine:
+  type: worker
+---
+apiVersion: v1alpha1
+kind: KmsgLogConfig
+name: remote-log
+url: udp://10.0.0.100:6001/
+`
+	if result := string(FilterMachineType([]byte(machineTypesFile), "worker")); result != expected {
+		t.Errorf("unexpected documents for workers:\n%s\nexpected:\n%s", result, expected)
+	}
+
+	// A marker below the first line of YAML is a comment of the document, not a marker
+	data := "machine:\n  # talm: machine-type=worker\n  type: controlplane\n"
+	if result := string(FilterMachineType([]byte(data), "controlplane")); result != data {
+		t.Errorf("unexpected documents:\n%s", result)
+	}
+}
@@ -13,41 +13,63 @@ type Config struct {
 	Nodes     []string
 	Endpoints []string
 	Templates []string
+	// Values are per-node values overrides used at render time
+	Values map[string]interface{}
 }
 
+const prefix = "# talm: "
+
 // ParseModeline parses a modeline string and populates the Config structure
 func ParseModeline(line string) (*Config, error) {
 	config := &Config{}
 	trimLine := strings.TrimSpace(line)
-	prefix := "# talm: "
-	if strings.HasPrefix(trimLine, prefix) {
-		content := strings.TrimPrefix(trimLine, prefix)
-		parts := strings.Split(content, ", ")
-		for _, part := range parts {
-			keyVal := strings.SplitN(strings.TrimSpace(part), "=", 2)
-			if len(keyVal) != 2 {
-				return nil, fmt.Errorf("invalid format of modeline part: %s", part)
-			}
-			key := keyVal[0]
-			val := keyVal[1]
-			var arr []string
-			if err := json.Unmarshal([]byte(val), &arr); err != nil {
-				return nil, fmt.Errorf("error parsing JSON array for key %s, value %s, error: %v", key, val, err)
-			}
-			// Assign values to Config fields based on known keys
-			switch key {
-			case "nodes":
-				config.Nodes = arr
-			case "endpoints":
-				config.Endpoints = arr
-			case "templates":
-				config.Templates = arr
-				// Ignore unknown keys
+	if !strings.HasPrefix(trimLine, prefix) {
+		return nil, fmt.Errorf("modeline prefix not found")
+	}
+
+	content := strings.TrimPrefix(trimLine, prefix)
+	for content != "" {
+		keyVal := strings.SplitN(content, "=", 2)
+		if len(keyVal) != 2 {
+			return nil, fmt.Errorf("invalid format of modeline part: %s", content)
+		}
+		key := strings.TrimSpace(keyVal[0])
+
+		// Values are JSON documents which may contain commas, so they are decoded one at a time
+		decoder := json.NewDecoder(strings.NewReader(keyVal[1]))
+		var val json.RawMessage
+		if err := decoder.Decode(&val); err != nil {
+			return nil, fmt.Errorf("error parsing JSON value for key %s, value %s, error: %v", key, keyVal[1], err)
+		}
+		content = strings.TrimSpace(keyVal[1][decoder.InputOffset():])
+		if content != "" {
+			if !strings.HasPrefix(content, ",") {
+				return nil, fmt.Errorf("invalid format of modeline part: %s", content)
 			}
+			content = strings.TrimSpace(strings.TrimPrefix(content, ","))
+		}
+
+		// Assign values to Config fields based on known keys
+		var target interface{}
+		switch key {
+		case "nodes":
+			target = &config.Nodes
+		case "endpoints":
+			target = &config.Endpoints
+		case "templates":
+			target = &config.Templates
+		case "values":
+			target = &config.Values
+		default:
+			// Ignore unknown keys
+			continue
+		}
+		if err := json.Unmarshal(val, target); err != nil {
+			return nil, fmt.Errorf("error parsing JSON for key %s, value %s, error: %v", key, val, err)
 		}
-		return config, nil
 	}
-	return nil, fmt.Errorf("modeline prefix not found")
+
+	return config, nil
 }
 
 // ReadAndParseModeline reads the first line from a file and parses the modeline.
@@ -73,25 +95,31 @@ func ReadAndParseModeline(filePath string) (*Config, error) {
 
 // GenerateModeline creates a modeline string using JSON formatting for values
 func GenerateModeline(nodes []string, endpoints []string, templates []string) (string, error) {
-	// Convert Nodes to JSON
-	nodesJSON, err := json.Marshal(nodes)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal nodes: %v", err)
+	config := &Config{
+		Nodes:     nodes,
+		Endpoints: endpoints,
+		Templates: templates,
 	}
+	return config.Format()
+}
 
-	// Convert Endpoints to JSON
-	endpointsJSON, err := json.Marshal(endpoints)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal endpoints: %v", err)
+// Format formats the config as a modeline, values are omitted when empty.
+func (c *Config) Format() (string, error) {
+	keys := []string{"nodes", "endpoints", "templates"}
+	values := []interface{}{c.Nodes, c.Endpoints, c.Templates}
+	if len(c.Values) > 0 {
+		keys = append(keys, "values")
+		values = append(values, c.Values)
 	}
 
-	// Convert Templates to JSON
-	templatesJSON, err := json.Marshal(templates)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal templates: %v", err)
+	fields := make([]string, 0, len(keys))
+	for i, key := range keys {
+		data, err := json.Marshal(values[i])
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal %s: %v", key, err)
+		}
+		fields = append(fields, key+"="+string(data))
 	}
 
-	// Form the final modeline string
-	modeline := fmt.Sprintf(`# talm: nodes=%s, endpoints=%s, templates=%s`, string(nodesJSON), string(endpointsJSON), string(templatesJSON))
-	return modeline, nil
+	return prefix + strings.Join(fields, ", "), nil
 }
@@ -31,6 +31,25 @@ func TestParseModeline(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "modeline with values",
+			line: `# talm: nodes=["192.168.100.2"], endpoints=["192.168.100.2"], templates=["templates/worker.yaml"], values={"floatingIP": "192.168.100.10", "vlans": [{"vlanId": 100}]}`,
+			want: &Config{
+				Nodes:     []string{"192.168.100.2"},
+				Endpoints: []string{"192.168.100.2"},
+				Templates: []string{"templates/worker.yaml"},
+				Values: map[string]interface{}{
+					"floatingIP": "192.168.100.10",
+					"vlans":      []interface{}{map[string]interface{}{"vlanId": float64(100)}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name:    "modeline with trailing garbage",
+			line:    `# talm: nodes=["192.168.100.2"] endpoints=["192.168.100.2"]`,
+			wantErr: true,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -46,3 +65,30 @@ func TestParseModeline(t *testing.T) {
 		})
 	}
 }
+
+func TestFormatModeline(t *testing.T) {
+	config := &Config{
+		Nodes:     []string{"192.168.100.2"},
+		Endpoints: []string{"192.168.100.2"},
+		Templates: []string{"templates/controlplane.yaml"},
+		Values:    map[string]interface{}{"floatingIP": "192.168.100.10"},
+	}
+
+	line, err := config.Format()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# talm: nodes=["192.168.100.2"], endpoints=["192.168.100.2"], templates=["templates/controlplane.yaml"], values={"floatingIP":"192.168.100.10"}`
+	if line != want {
+		t.Errorf("Format() got = %s, want %s", line, want)
+	}
+
+	got, err := ParseModeline(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, config) {
+		t.Errorf("ParseModeline(Format()) got = %v, want %v", got, config)
+	}
+}
@@ -0,0 +1,232 @@
+// Package nodemeta describes the Kubernetes labels, annotations and taints of the nodes set in
+// the chart values and detects the drift of the live nodes from them.
+package nodemeta
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Annotations listing the keys managed on the node: Talos records the labels and taints of
+// the machine config, talm the annotations it sets, so removed keys are removed from the node.
+const (
+	OwnedLabels      = "talos.dev/owned-labels"
+	OwnedTaints      = "talos.dev/owned-taints"
+	OwnedAnnotations = "talm.dev/owned-annotations"
+)
+
+// Metadata is the desired metadata of a node. Taints are written like in machine.nodeTaints,
+// the value and the effect separated by a colon, e.g. "storage:NoSchedule".
+type Metadata struct {
+	Labels      map[string]string
+	Annotations map[string]string
+	Taints      map[string]string
+}
+
+// FromValues reads the nodeLabels, nodeAnnotations and nodeTaints of the chart values. The values
+// of the group named after the machine type in nodeGroups are defaults, the top-level ones,
+// including the values of the modeline of the node, override them.
+func FromValues(values map[string]interface{}, machineType string) (Metadata, error) {
+	var group map[string]interface{}
+	if groups, ok := values["nodeGroups"].(map[string]interface{}); ok {
+		group, _ = groups[machineType].(map[string]interface{})
+	}
+
+	var (
+		m   Metadata
+		err error
+	)
+	if m.Labels, err = stringMap(values, group, "nodeLabels"); err != nil {
+		return m, err
+	}
+	if m.Annotations, err = stringMap(values, group, "nodeAnnotations"); err != nil {
+		return m, err
+	}
+	if m.Taints, err = stringMap(values, group, "nodeTaints"); err != nil {
+		return m, err
+	}
+	for key, taint := range m.Taints {
+		if _, _, err := ParseTaint(taint); err != nil {
+			return m, fmt.Errorf("nodeTaints.%s: %w", key, err)
+		}
+	}
+	return m, nil
+}
+
+func stringMap(values, group map[string]interface{}, key string) (map[string]string, error) {
+	result := map[string]string{}
+	for _, section := range []map[string]interface{}{group, values} {
+		if section == nil || section[key] == nil {
+			continue
+		}
+		items, ok := section[key].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s must be a map", key)
+		}
+		for k, v := range items {
+			switch v := v.(type) {
+			case map[string]interface{}, []interface{}:
+				return nil, fmt.Errorf("%s.%s must be a string", key, k)
+			case nil:
+				result[k] = ""
+			default:
+				result[k] = fmt.Sprint(v)
+			}
+		}
+	}
+	return result, nil
+}
+
+// ParseTaint splits a taint of machine.nodeTaints into its value and effect.
+func ParseTaint(taint string) (value string, effect corev1.TaintEffect, err error) {
+	i := strings.LastIndex(taint, ":")
+	if i < 0 {
+		return "", "", fmt.Errorf("taint %q must be value:effect", taint)
+	}
+	value, effect = taint[:i], corev1.TaintEffect(taint[i+1:])
+	switch effect {
+	case corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+		return value, effect, nil
+	}
+	return "", "", fmt.Errorf("unknown effect %q of taint %q, use NoSchedule, PreferNoSchedule or NoExecute", effect, taint)
+}
+
+// Change is a difference between the desired metadata and the live node.
+type Change struct {
+	// Kind is label, annotation or taint
+	Kind string
+	Key  string
+	Want string
+	Have string
+	// Missing is set if the key is not set on the node
+	Missing bool
+	// Removed is set if the key is owned but no longer set in the values
+	Removed bool
+}
+
+func (c Change) String() string {
+	switch {
+	case c.Missing:
+		return fmt.Sprintf("%s %s is missing, want %q", c.Kind, c.Key, c.Want)
+	case c.Removed:
+		return fmt.Sprintf("%s %s=%q is no longer set in the values", c.Kind, c.Key, c.Have)
+	default:
+		return fmt.Sprintf("%s %s is %q, want %q", c.Kind, c.Key, c.Have, c.Want)
+	}
+}
+
+// Diff returns the changes bringing the node to the desired metadata, sorted by kind and key.
+// Keys not set in the values are only removed if they are owned, i.e. they were set from
+// the values before.
+func Diff(want Metadata, node *corev1.Node) []Change {
+	haveTaints := map[string]string{}
+	for _, taint := range node.Spec.Taints {
+		haveTaints[taint.Key] = taint.Value + ":" + string(taint.Effect)
+	}
+
+	var changes []Change
+	changes = append(changes, diffMap("label", want.Labels, node.Labels, owned(node, OwnedLabels))...)
+	changes = append(changes, diffMap("annotation", want.Annotations, node.Annotations, owned(node, OwnedAnnotations))...)
+	changes = append(changes, diffMap("taint", want.Taints, haveTaints, owned(node, OwnedTaints))...)
+	return changes
+}
+
+func diffMap(kind string, want, have map[string]string, owned []string) []Change {
+	var changes []Change
+	for _, key := range sortedKeys(want) {
+		value, ok := have[key]
+		switch {
+		case !ok:
+			changes = append(changes, Change{Kind: kind, Key: key, Want: want[key], Missing: true})
+		case value != want[key]:
+			changes = append(changes, Change{Kind: kind, Key: key, Want: want[key], Have: value})
+		}
+	}
+	for _, key := range owned {
+		if _, ok := want[key]; ok {
+			continue
+		}
+		if value, ok := have[key]; ok {
+			changes = append(changes, Change{Kind: kind, Key: key, Have: value, Removed: true})
+		}
+	}
+	return changes
+}
+
+// Apply changes the node to the desired metadata and records the owned annotations.
+// Labels and taints are owned by Talos, they are applied with the machine config as well.
+func Apply(want Metadata, node *corev1.Node) error {
+	for _, change := range Diff(want, node) {
+		switch change.Kind {
+		case "label":
+			node.Labels = applyChange(node.Labels, change.Key, want.Labels)
+		case "annotation":
+			node.Annotations = applyChange(node.Annotations, change.Key, want.Annotations)
+		case "taint":
+			taints := node.Spec.Taints[:0]
+			for _, taint := range node.Spec.Taints {
+				if taint.Key != change.Key {
+					taints = append(taints, taint)
+				}
+			}
+			if taint, ok := want.Taints[change.Key]; ok {
+				value, effect, err := ParseTaint(taint)
+				if err != nil {
+					return err
+				}
+				taints = append(taints, corev1.Taint{Key: change.Key, Value: value, Effect: effect})
+			}
+			node.Spec.Taints = taints
+		}
+	}
+
+	owned, err := json.Marshal(sortedKeys(want.Annotations))
+	if err != nil {
+		return err
+	}
+	if len(want.Annotations) == 0 {
+		delete(node.Annotations, OwnedAnnotations)
+		return nil
+	}
+	if node.Annotations == nil {
+		node.Annotations = map[string]string{}
+	}
+	node.Annotations[OwnedAnnotations] = string(owned)
+	return nil
+}
+
+func applyChange(have map[string]string, key string, want map[string]string) map[string]string {
+	value, ok := want[key]
+	if !ok {
+		delete(have, key)
+		return have
+	}
+	if have == nil {
+		have = map[string]string{}
+	}
+	have[key] = value
+	return have
+}
+
+// owned returns the keys listed in the ownership annotation of the node.
+func owned(node *corev1.Node, annotation string) []string {
+	var keys []string
+	if err := json.Unmarshal([]byte(node.Annotations[annotation]), &keys); err != nil {
+		return nil
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
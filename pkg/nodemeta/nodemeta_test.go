@@ -0,0 +1,110 @@
+package nodemeta
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestFromValues(t *testing.T) {
+	values := map[string]interface{}{
+		"nodeLabels": map[string]interface{}{
+			"topology.kubernetes.io/zone": "zone-a",
+			"storage":                     true,
+		},
+		"nodeGroups": map[string]interface{}{
+			"worker": map[string]interface{}{
+				"nodeLabels": map[string]interface{}{
+					"topology.kubernetes.io/zone":    "zone-b",
+					"node-role.kubernetes.io/worker": nil,
+				},
+				"nodeTaints": map[string]interface{}{
+					"dedicated": "storage:NoSchedule",
+				},
+			},
+		},
+	}
+
+	worker, err := FromValues(values, "worker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Metadata{
+		Labels: map[string]string{
+			"topology.kubernetes.io/zone":    "zone-a",
+			"storage":                        "true",
+			"node-role.kubernetes.io/worker": "",
+		},
+		Annotations: map[string]string{},
+		Taints:      map[string]string{"dedicated": "storage:NoSchedule"},
+	}
+	if !reflect.DeepEqual(worker, want) {
+		t.Errorf("worker metadata = %v, want %v", worker, want)
+	}
+
+	controlplane, err := FromValues(values, "controlplane")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(controlplane.Labels) != 2 || len(controlplane.Taints) != 0 {
+		t.Errorf("controlplane metadata = %v, the worker group must not apply", controlplane)
+	}
+
+	values["nodeTaints"] = map[string]interface{}{"dedicated": "storage"}
+	if _, err := FromValues(values, "worker"); err == nil {
+		t.Error("expected an error for a taint without effect")
+	}
+}
+
+func TestDiffAndApply(t *testing.T) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels: map[string]string{
+				"zone":   "zone-b",
+				"old":    "true",
+				"manual": "true",
+			},
+			Annotations: map[string]string{
+				OwnedLabels:      `["old","zone"]`,
+				OwnedAnnotations: `["note"]`,
+				"note":           "stale",
+			},
+		},
+		Spec: corev1.NodeSpec{
+			Taints: []corev1.Taint{{Key: "dedicated", Value: "db", Effect: corev1.TaintEffectNoSchedule}},
+		},
+	}
+	want := Metadata{
+		Labels:      map[string]string{"zone": "zone-a", "rack": ""},
+		Annotations: map[string]string{"owner": "team-a"},
+		Taints:      map[string]string{"dedicated": "storage:NoSchedule"},
+	}
+
+	changes := Diff(want, node)
+	wantChanges := []Change{
+		{Kind: "label", Key: "rack", Missing: true},
+		{Kind: "label", Key: "zone", Want: "zone-a", Have: "zone-b"},
+		{Kind: "label", Key: "old", Have: "true", Removed: true},
+		{Kind: "annotation", Key: "owner", Want: "team-a", Missing: true},
+		{Kind: "annotation", Key: "note", Have: "stale", Removed: true},
+		{Kind: "taint", Key: "dedicated", Want: "storage:NoSchedule", Have: "db:NoSchedule"},
+	}
+	if !reflect.DeepEqual(changes, wantChanges) {
+		t.Errorf("Diff() = %v, want %v", changes, wantChanges)
+	}
+
+	if err := Apply(want, node); err != nil {
+		t.Fatal(err)
+	}
+	if changes := Diff(want, node); len(changes) != 0 {
+		t.Errorf("Diff() after Apply() = %v, want no changes", changes)
+	}
+	if node.Labels["manual"] != "true" {
+		t.Error("labels not owned must be kept")
+	}
+	if node.Annotations[OwnedAnnotations] != `["owner"]` {
+		t.Errorf("owned annotations = %s", node.Annotations[OwnedAnnotations])
+	}
+}
@@ -0,0 +1,178 @@
+// Package omni builds Sidero Omni cluster templates from the node files of a project, for
+// clusters whose machines are managed by Omni instead of through their Talos API.
+package omni
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Cluster describes the cluster document of the template.
+type Cluster struct {
+	Name              string
+	KubernetesVersion string
+	TalosVersion      string
+}
+
+// Machine is a machine of the cluster with the node file applied to it as a config patch.
+type Machine struct {
+	// ID is the Omni machine ID, the SMBIOS UUID of the machine
+	ID string
+	// ControlPlane reports whether the machine joins the control plane
+	ControlPlane bool
+	// PatchName names the patch, e.g. after the node file
+	PatchName string
+	Patch     map[string]interface{}
+}
+
+type clusterDoc struct {
+	Kind       string `yaml:"kind"`
+	Name       string `yaml:"name"`
+	Kubernetes struct {
+		Version string `yaml:"version"`
+	} `yaml:"kubernetes"`
+	Talos struct {
+		Version string `yaml:"version"`
+	} `yaml:"talos"`
+}
+
+type machineSetDoc struct {
+	Kind     string   `yaml:"kind"`
+	Machines []string `yaml:"machines"`
+}
+
+type machineDoc struct {
+	Kind    string     `yaml:"kind"`
+	Name    string     `yaml:"name"`
+	Patches []patchDoc `yaml:"patches,omitempty"`
+}
+
+type patchDoc struct {
+	Name   string                 `yaml:"name"`
+	Inline map[string]interface{} `yaml:"inline"`
+}
+
+// managedFields are the fields of the machine config set by Omni: the machine type follows the
+// machine set of the machine and the cluster name and endpoint are those of the Omni cluster.
+var managedFields = [][]string{
+	{"machine", "type"},
+	{"cluster", "clusterName"},
+	{"cluster", "controlPlane", "endpoint"},
+}
+
+// ParsePatch reads a node file as a config patch for Omni and reports whether it is a control
+// plane config. The fields managed by Omni are removed, and so are the comments like the
+// modeline. Node files with several documents are rejected, the patches of Omni hold the
+// machine config only.
+func ParsePatch(data []byte) (map[string]interface{}, bool, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	var patch map[string]interface{}
+	if err := decoder.Decode(&patch); err != nil {
+		return nil, false, err
+	}
+	var extra interface{}
+	if err := decoder.Decode(&extra); !errors.Is(err, io.EOF) {
+		if err != nil {
+			return nil, false, err
+		}
+		return nil, false, errors.New("node files with several documents are not supported by Omni patches")
+	}
+
+	controlPlane := false
+	if machine, ok := patch["machine"].(map[string]interface{}); ok {
+		switch machine["type"] {
+		case "controlplane", "init":
+			controlPlane = true
+		}
+	}
+	for _, path := range managedFields {
+		deleteField(patch, path)
+	}
+	return patch, controlPlane, nil
+}
+
+// deleteField deletes the field at the path and the maps left empty.
+func deleteField(m map[string]interface{}, path []string) {
+	if len(path) == 1 {
+		delete(m, path[0])
+		return
+	}
+	child, ok := m[path[0]].(map[string]interface{})
+	if !ok {
+		return
+	}
+	deleteField(child, path[1:])
+	if len(child) == 0 {
+		delete(m, path[0])
+	}
+}
+
+// Template returns the cluster template of the cluster and its machines: the cluster, the
+// control plane and workers machine sets and one document per machine with its patch. The
+// machines are sorted by ID, so the template is stable.
+func Template(cluster Cluster, machines []Machine) ([]byte, error) {
+	if cluster.Name == "" {
+		return nil, errors.New("cluster name is not set")
+	}
+	if cluster.KubernetesVersion == "" || cluster.TalosVersion == "" {
+		return nil, errors.New("the Kubernetes and Talos versions of the cluster are required by Omni")
+	}
+
+	sorted := append([]Machine(nil), machines...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+
+	c := clusterDoc{Kind: "Cluster", Name: cluster.Name}
+	c.Kubernetes.Version = cluster.KubernetesVersion
+	c.Talos.Version = cluster.TalosVersion
+	controlPlane := machineSetDoc{Kind: "ControlPlane", Machines: []string{}}
+	workers := machineSetDoc{Kind: "Workers", Machines: []string{}}
+	var machineDocs []interface{}
+
+	seen := map[string]bool{}
+	for _, m := range sorted {
+		if m.ID == "" {
+			return nil, fmt.Errorf("machine of patch %s has no ID", m.PatchName)
+		}
+		if seen[m.ID] {
+			return nil, fmt.Errorf("machine %s is listed more than once", m.ID)
+		}
+		seen[m.ID] = true
+
+		if m.ControlPlane {
+			controlPlane.Machines = append(controlPlane.Machines, m.ID)
+		} else {
+			workers.Machines = append(workers.Machines, m.ID)
+		}
+		doc := &machineDoc{Kind: "Machine", Name: m.ID}
+		if len(m.Patch) > 0 {
+			doc.Patches = []patchDoc{{Name: m.PatchName, Inline: m.Patch}}
+		}
+		machineDocs = append(machineDocs, doc)
+	}
+	if len(controlPlane.Machines) == 0 {
+		return nil, errors.New("the cluster has no control plane machines")
+	}
+	docs := []interface{}{&c, &controlPlane}
+	if len(workers.Machines) > 0 {
+		docs = append(docs, &workers)
+	}
+	docs = append(docs, machineDocs...)
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	for _, doc := range docs {
+		if err := encoder.Encode(doc); err != nil {
+			return nil, err
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
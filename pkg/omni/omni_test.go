@@ -0,0 +1,80 @@
+package omni
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParsePatch(t *testing.T) {
+	patch, controlPlane, err := ParsePatch([]byte("# talm: nodes=[\"a\"]\nmachine:\n  type: controlplane\n  network:\n    hostname: cp1\ncluster:\n  clusterName: prod\n  controlPlane:\n    endpoint: https://10.0.0.10:6443\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !controlPlane {
+		t.Error("expected a control plane patch")
+	}
+	machine := patch["machine"].(map[string]interface{})
+	if _, ok := machine["type"]; ok {
+		t.Errorf("machine.type is kept: %v", patch)
+	}
+	if _, ok := patch["cluster"]; ok {
+		t.Errorf("the cluster fields managed by Omni are kept: %v", patch)
+	}
+
+	patch, controlPlane, err = ParsePatch([]byte("machine:\n  type: worker\n"))
+	if err != nil || controlPlane || len(patch) != 0 {
+		t.Errorf("ParsePatch() = %v, %v, %v, want an empty worker patch", patch, controlPlane, err)
+	}
+
+	if _, _, err := ParsePatch([]byte("machine: {}\n---\nkind: HostnameConfig\n")); err == nil {
+		t.Error("expected an error for several documents")
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	machines := []Machine{
+		{ID: "uuid-w1", PatchName: "talm-w1", Patch: map[string]interface{}{"machine": map[string]interface{}{"network": map[string]interface{}{"hostname": "w1"}}}},
+		{ID: "uuid-cp1", ControlPlane: true, PatchName: "talm-cp1"},
+	}
+	got, err := Template(Cluster{Name: "prod", KubernetesVersion: "v1.30.0", TalosVersion: "v1.7.1"}, machines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `kind: Cluster
+name: prod
+kubernetes:
+  version: v1.30.0
+talos:
+  version: v1.7.1
+---
+kind: ControlPlane
+machines:
+  - uuid-cp1
+---
+kind: Workers
+machines:
+  - uuid-w1
+---
+kind: Machine
+name: uuid-cp1
+---
+kind: Machine
+name: uuid-w1
+patches:
+  - name: talm-w1
+    inline:
+      machine:
+        network:
+          hostname: w1
+`
+	if string(got) != want {
+		t.Errorf("Template() =\n%s\nwant\n%s", got, want)
+	}
+
+	if _, err := Template(Cluster{Name: "prod", KubernetesVersion: "v1.30.0", TalosVersion: "v1.7.1"}, machines[:1]); err == nil || !strings.Contains(err.Error(), "control plane") {
+		t.Errorf("Template() error = %v, want no control plane machines", err)
+	}
+	if _, err := Template(Cluster{Name: "prod", KubernetesVersion: "v1.30.0", TalosVersion: "v1.7.1"}, append(machines, machines[1])); err == nil {
+		t.Error("expected an error for a machine listed twice")
+	}
+}
@@ -0,0 +1,119 @@
+package plugins
+
+import (
+	"fmt"
+	"sort"
+
+	"go.starlark.net/starlark"
+)
+
+// toStarlark converts template values to Starlark values.
+func toStarlark(v interface{}) (starlark.Value, error) {
+	switch v := v.(type) {
+	case nil:
+		return starlark.None, nil
+	case bool:
+		return starlark.Bool(v), nil
+	case string:
+		return starlark.String(v), nil
+	case int:
+		return starlark.MakeInt(v), nil
+	case int64:
+		return starlark.MakeInt64(v), nil
+	case uint64:
+		return starlark.MakeUint64(v), nil
+	case float64:
+		return starlark.Float(v), nil
+	case []interface{}:
+		items := make([]starlark.Value, 0, len(v))
+		for _, item := range v {
+			sv, err := toStarlark(item)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, sv)
+		}
+		return starlark.NewList(items), nil
+	case []string:
+		items := make([]starlark.Value, 0, len(v))
+		for _, item := range v {
+			items = append(items, starlark.String(item))
+		}
+		return starlark.NewList(items), nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		dict := starlark.NewDict(len(v))
+		for _, k := range keys {
+			sv, err := toStarlark(v[k])
+			if err != nil {
+				return nil, err
+			}
+			if err := dict.SetKey(starlark.String(k), sv); err != nil {
+				return nil, err
+			}
+		}
+		return dict, nil
+	default:
+		return nil, fmt.Errorf("unsupported argument type %T", v)
+	}
+}
+
+// fromStarlark converts Starlark values to values usable in templates.
+func fromStarlark(v starlark.Value) (interface{}, error) {
+	switch v := v.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(v), nil
+	case starlark.String:
+		return string(v), nil
+	case starlark.Int:
+		i, ok := v.Int64()
+		if !ok {
+			return nil, fmt.Errorf("integer %s is out of range", v)
+		}
+		return i, nil
+	case starlark.Float:
+		return float64(v), nil
+	case *starlark.List:
+		return iterableToSlice(v)
+	case starlark.Tuple:
+		return iterableToSlice(v)
+	case *starlark.Dict:
+		m := make(map[string]interface{}, v.Len())
+		for _, item := range v.Items() {
+			k, ok := item[0].(starlark.String)
+			if !ok {
+				return nil, fmt.Errorf("dict keys must be strings, got %s", item[0].Type())
+			}
+			gv, err := fromStarlark(item[1])
+			if err != nil {
+				return nil, err
+			}
+			m[string(k)] = gv
+		}
+		return m, nil
+	default:
+		return nil, fmt.Errorf("unsupported result type %s", v.Type())
+	}
+}
+
+func iterableToSlice(v starlark.Iterable) ([]interface{}, error) {
+	result := []interface{}{}
+	iter := v.Iterate()
+	defer iter.Done()
+
+	var item starlark.Value
+	for iter.Next(&item) {
+		gv, err := fromStarlark(item)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, gv)
+	}
+	return result, nil
+}
@@ -0,0 +1,229 @@
+// Package plugins loads Starlark scripts shipped with charts and exposes their
+// functions as template functions.
+//
+// Plugins run in a sandbox: they have no access to the filesystem, network,
+// environment or clock unless the corresponding capability is granted in
+// Chart.yaml:
+//
+//	templateOptions:
+//	  plugins:
+//	  - name: ipam
+//	    path: plugins/ipam.star
+//	    capabilities: ["network"]
+//
+// The env capability only reads the environment variables of templateOptions.envAllowlist,
+// like the env template function.
+//
+// Every top-level function of the script whose name does not start with an
+// underscore becomes a template function with the same name.
+package plugins
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkjson"
+)
+
+// Capabilities which can be granted to a plugin.
+const (
+	// CapabilityPrint allows print() to write to stderr, otherwise the output is discarded.
+	CapabilityPrint = "print"
+	// CapabilityNetwork provides http_get(url) builtin.
+	CapabilityNetwork = "network"
+	// CapabilityEnv provides getenv(name) builtin reading the allowed environment variables.
+	CapabilityEnv = "env"
+	// CapabilityTime provides now() builtin returning the unix time in seconds.
+	CapabilityTime = "time"
+)
+
+// maxExecutionSteps limits the work a single plugin call can do.
+const maxExecutionSteps = 10_000_000
+
+// maxResponseSize limits the body of a response read by http_get.
+const maxResponseSize = 10 << 20
+
+// Config describes a plugin in Chart.yaml.
+type Config struct {
+	Name         string   `yaml:"name"`
+	Path         string   `yaml:"path"`
+	Capabilities []string `yaml:"capabilities"`
+}
+
+// Load executes plugin scripts relative to the chart root and returns their
+// exported functions. envAllowlist contains the environment variable names (or
+// path.Match patterns) getenv can read. reserved contains the names of the builtin
+// template functions, which plugins are not allowed to override.
+func Load(root string, configs []Config, envAllowlist []string, reserved template.FuncMap) (template.FuncMap, error) {
+	funcs := template.FuncMap{}
+	owners := map[string]string{}
+
+	for _, cfg := range configs {
+		predeclared, err := builtins(cfg, envAllowlist)
+		if err != nil {
+			return nil, err
+		}
+
+		if !filepath.IsLocal(cfg.Path) {
+			return nil, fmt.Errorf("plugin %s: path %q is outside the chart", cfg.Name, cfg.Path)
+		}
+		src, err := os.ReadFile(filepath.Join(root, cfg.Path))
+		if err != nil {
+			return nil, fmt.Errorf("plugin %s: %w", cfg.Name, err)
+		}
+
+		thread := newThread(cfg)
+		globals, err := starlark.ExecFile(thread, cfg.Path, src, predeclared)
+		if err != nil {
+			return nil, fmt.Errorf("plugin %s: %w", cfg.Name, err)
+		}
+
+		names := globals.Keys()
+		sort.Strings(names)
+		for _, name := range names {
+			fn, ok := globals[name].(starlark.Callable)
+			if !ok || strings.HasPrefix(name, "_") {
+				continue
+			}
+			if _, ok := reserved[name]; ok {
+				return nil, fmt.Errorf("plugin %s: function %s conflicts with builtin template function", cfg.Name, name)
+			}
+			if owner, ok := owners[name]; ok {
+				return nil, fmt.Errorf("plugin %s: function %s is already defined by plugin %s", cfg.Name, name, owner)
+			}
+			owners[name] = cfg.Name
+			funcs[name] = wrap(cfg, fn)
+		}
+	}
+
+	return funcs, nil
+}
+
+func newThread(cfg Config) *starlark.Thread {
+	thread := &starlark.Thread{
+		Name: cfg.Name,
+		Load: func(*starlark.Thread, string) (starlark.StringDict, error) {
+			return nil, fmt.Errorf("load() is not supported in plugins")
+		},
+		Print: func(*starlark.Thread, string) {},
+	}
+	if hasCapability(cfg, CapabilityPrint) {
+		thread.Print = func(_ *starlark.Thread, msg string) {
+			fmt.Fprintf(os.Stderr, "[plugin %s] %s\n", cfg.Name, msg)
+		}
+	}
+	thread.SetMaxExecutionSteps(maxExecutionSteps)
+	return thread
+}
+
+// wrap adapts the Starlark function to the template function signature.
+func wrap(cfg Config, fn starlark.Callable) func(...interface{}) (interface{}, error) {
+	return func(args ...interface{}) (interface{}, error) {
+		sargs := make(starlark.Tuple, 0, len(args))
+		for _, arg := range args {
+			v, err := toStarlark(arg)
+			if err != nil {
+				return nil, fmt.Errorf("plugin %s: %s: %w", cfg.Name, fn.Name(), err)
+			}
+			sargs = append(sargs, v)
+		}
+
+		result, err := starlark.Call(newThread(cfg), fn, sargs, nil)
+		if err != nil {
+			return nil, fmt.Errorf("plugin %s: %w", cfg.Name, err)
+		}
+
+		return fromStarlark(result)
+	}
+}
+
+func hasCapability(cfg Config, capability string) bool {
+	for _, c := range cfg.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+func builtins(cfg Config, envAllowlist []string) (starlark.StringDict, error) {
+	predeclared := starlark.StringDict{
+		"json": starlarkjson.Module,
+	}
+
+	for _, capability := range cfg.Capabilities {
+		switch capability {
+		case CapabilityPrint:
+		case CapabilityNetwork:
+			predeclared["http_get"] = starlark.NewBuiltin("http_get", httpGet)
+		case CapabilityEnv:
+			predeclared["getenv"] = starlark.NewBuiltin("getenv", getenv(envAllowlist))
+		case CapabilityTime:
+			predeclared["now"] = starlark.NewBuiltin("now", now)
+		default:
+			return nil, fmt.Errorf("plugin %s: unknown capability %q", cfg.Name, capability)
+		}
+	}
+
+	return predeclared, nil
+}
+
+func httpGet(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var url string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "url", &url); err != nil {
+		return nil, err
+	}
+
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxResponseSize {
+		return nil, fmt.Errorf("%s: response is larger than %d bytes", url, maxResponseSize)
+	}
+
+	return starlark.String(body), nil
+}
+
+// getenv returns the getenv builtin reading the environment variables of the allowlist.
+func getenv(envAllowlist []string) func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+	return func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var name string
+		if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name); err != nil {
+			return nil, err
+		}
+		for _, pattern := range envAllowlist {
+			if ok, _ := path.Match(pattern, name); ok {
+				return starlark.String(os.Getenv(name)), nil
+			}
+		}
+		return nil, fmt.Errorf("environment variable %q is not allowed, add it to templateOptions.envAllowlist in Chart.yaml", name)
+	}
+}
+
+func now(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs); err != nil {
+		return nil, err
+	}
+	return starlark.MakeInt64(time.Now().Unix()), nil
+}
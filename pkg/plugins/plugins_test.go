@@ -0,0 +1,157 @@
+package plugins
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func writePlugin(t *testing.T, dir, name, src string) string {
+	t.Helper()
+	path := name + ".star"
+	if err := os.WriteFile(filepath.Join(dir, path), []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := writePlugin(t, dir, "naming", `
+def node_name(rack, index):
+    return "%s-%d" % (rack, index)
+
+def labels(values):
+    return {"rack": values["rack"], "zones": [z.upper() for z in values["zones"]]}
+
+def _private():
+    pass
+`)
+
+	funcs, err := Load(dir, []Config{{Name: "naming", Path: path}}, nil, template.FuncMap{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := funcs["_private"]; ok {
+		t.Errorf("private function is exported")
+	}
+
+	nodeName := funcs["node_name"].(func(...interface{}) (interface{}, error))
+	got, err := nodeName("r1", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "r1-3" {
+		t.Errorf("node_name() = %v, want r1-3", got)
+	}
+
+	labels := funcs["labels"].(func(...interface{}) (interface{}, error))
+	got, err = labels(map[string]interface{}{"rack": "r1", "zones": []interface{}{"a", "b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"rack": "r1", "zones": []interface{}{"A", "B"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("labels() = %v, want %v", got, want)
+	}
+}
+
+func TestLoadSandbox(t *testing.T) {
+	dir := t.TempDir()
+
+	testCases := []struct {
+		name string
+		src  string
+		cfg  Config
+	}{
+		{
+			name: "builtin conflict",
+			src:  "def include(name):\n    return name\n",
+		},
+		{
+			name: "network without capability",
+			src:  "x = http_get('http://example.com')\n",
+		},
+		{
+			name: "unknown capability",
+			src:  "x = 1\n",
+			cfg:  Config{Capabilities: []string{"filesystem"}},
+		},
+		{
+			name: "path outside the chart",
+			src:  "x = 1\n",
+			cfg:  Config{Path: "../test.star"},
+		},
+		{
+			name: "env not allowed",
+			src:  "x = getenv('HOME')\n",
+			cfg:  Config{Capabilities: []string{"env"}},
+		},
+		{
+			name: "load is not supported",
+			src:  "load('other.star', 'x')\n",
+		},
+		{
+			name: "infinite loop",
+			src:  "def f():\n    for i in range(1000000000):\n        pass\nf()\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := tc.cfg
+			cfg.Name = "test"
+			path := writePlugin(t, dir, "test", tc.src)
+			if cfg.Path == "" {
+				cfg.Path = path
+			}
+
+			if _, err := Load(dir, []Config{cfg}, []string{"TALM_*"}, template.FuncMap{"include": nil}); err == nil {
+				t.Errorf("Load() expected error")
+			}
+		})
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/large" {
+			w.Write(make([]byte, maxResponseSize+1)) //nolint:errcheck
+			return
+		}
+		w.Write([]byte(`{"subnet": "10.0.0.0/24"}`)) //nolint:errcheck
+	}))
+	defer server.Close()
+	t.Setenv("TALM_SITE", "fra1")
+
+	dir := t.TempDir()
+	path := writePlugin(t, dir, "caps", `
+def subnet(path):
+    return json.decode(http_get("`+server.URL+`" + path))["subnet"]
+
+def site():
+    return getenv("TALM_SITE")
+`)
+	funcs, err := Load(dir, []Config{{Name: "caps", Path: path, Capabilities: []string{"network", "env"}}}, []string{"TALM_*"}, template.FuncMap{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subnet := funcs["subnet"].(func(...interface{}) (interface{}, error))
+	if got, err := subnet("/"); err != nil || got != "10.0.0.0/24" {
+		t.Errorf("subnet() = %v, %v", got, err)
+	}
+	if _, err := subnet("/large"); err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Errorf("expected an error reading a large response, got %v", err)
+	}
+	site := funcs["site"].(func(...interface{}) (interface{}, error))
+	if got, err := site(); err != nil || got != "fra1" {
+		t.Errorf("site() = %v, %v", got, err)
+	}
+}
@@ -0,0 +1,251 @@
+// Package preview renders the node files of a project and its workspaces as a small web site:
+// the config rendered for every node file, its diff from the committed file and the sources of
+// the values the templates saw, with a search over all of them.
+package preview
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aenix-io/talm/pkg/engine"
+)
+
+// Node is a node file rendered from its modeline. Diff is empty if the rendered config is the
+// committed one, the error tells why the file couldn't be rendered.
+type Node struct {
+	File      string               `json:"file"`
+	Nodes     []string             `json:"nodes,omitempty"`
+	Templates []string             `json:"templates,omitempty"`
+	Config    string               `json:"config,omitempty"`
+	Diff      string               `json:"diff,omitempty"`
+	Values    []engine.ValueSource `json:"values,omitempty"`
+	Error     string               `json:"error,omitempty"`
+}
+
+// Environment is the project or one of its workspaces, the name is empty for the project.
+type Environment struct {
+	Name  string `json:"name"`
+	Nodes []Node `json:"nodes"`
+	Error string `json:"error,omitempty"`
+}
+
+var htmlPreview = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Talos configs</title>
+<style>
+body { font-family: sans-serif; font-size: 14px; margin: 0; display: flex; }
+nav { width: 280px; height: 100vh; overflow-y: auto; position: sticky; top: 0; padding: 8px; background: #f5f5f5; box-sizing: border-box; }
+nav input { width: 100%; box-sizing: border-box; margin-bottom: 8px; }
+nav h3 { margin: 12px 0 4px; }
+nav ul { list-style: none; padding: 0; margin: 0; }
+main { flex: 1; padding: 8px 16px; min-width: 0; }
+section { border-bottom: 1px solid #ccc; padding-bottom: 16px; }
+pre { background: #fafafa; border: 1px solid #ddd; padding: 8px; overflow-x: auto; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; font-family: monospace; }
+th { background: #eee; }
+.badge { font-size: 11px; padding: 0 4px; border-radius: 3px; color: #fff; background: #b60; }
+.alert { color: #b00; }
+.alert .badge { background: #b00; }
+.add { color: #070; }
+.del { color: #b00; }
+.hunk { color: #07a; }
+.secret { color: #888; font-style: italic; }
+</style>
+</head>
+<body>
+<nav>
+<input id="search" type="search" placeholder="Search configs and values">
+{{- range .Environments }}
+<h3>{{ .Name }}</h3>
+{{- if .Error }}<p class="alert">{{ .Error }}</p>{{ end }}
+<ul>
+{{- range .Nodes }}
+<li><a href="#{{ .ID }}">{{ .File }}</a>{{ if .Error }} <span class="alert"><span class="badge">error</span></span>{{ else if .Diff }} <span class="badge">drifted</span>{{ end }}</li>
+{{- end }}
+</ul>
+{{- end }}
+</nav>
+<main>
+<p>Generated at {{ .Generated }}, {{ .Count }} node files, {{ .Drifted }} drifted.</p>
+{{- range .Environments }}
+{{- $environment := .Name }}
+{{- range .Nodes }}
+<section id="{{ .ID }}">
+<h2>{{ $environment }}: {{ .File }}</h2>
+{{- if .Nodes }}
+<p>Nodes: {{ range $i, $node := .Nodes }}{{ if $i }}, {{ end }}{{ $node }}{{ end }}. Templates: {{ range $i, $template := .Templates }}{{ if $i }}, {{ end }}{{ $template }}{{ end }}.</p>
+{{- end }}
+{{- if .Error }}
+<p class="alert">{{ .Error }}</p>
+{{- else }}
+{{- if .Diff }}
+<h3>Diff</h3>
+<pre>{{ range .Diff }}<span{{ with .Class }} class="{{ . }}"{{ end }}>{{ .Text }}</span>
+{{ end }}</pre>
+{{- else }}
+<p>The rendered config is the committed one.</p>
+{{- end }}
+<h3>Config</h3>
+<pre>{{ .Config }}</pre>
+{{- end }}
+{{- if .Values }}
+<h3>Values</h3>
+<table>
+<tr><th>path</th><th>value</th><th>source</th></tr>
+{{- range .Values }}
+<tr class="value"><td>{{ .Path }}</td>{{ if .Secret }}<td class="secret">secret</td>{{ else }}<td>{{ .Value }}</td>{{ end }}<td>{{ .Source }}</td></tr>
+{{- end }}
+</table>
+{{- end }}
+</section>
+{{- end }}
+{{- end }}
+</main>
+<script>
+const search = document.getElementById("search");
+search.addEventListener("input", () => {
+  const query = search.value.toLowerCase();
+  document.querySelectorAll("section").forEach(section => {
+    const match = section.textContent.toLowerCase().includes(query);
+    section.hidden = !match;
+    document.querySelector('a[href="#' + section.id + '"]').parentElement.hidden = !match;
+    section.querySelectorAll("tr.value").forEach(row => {
+      row.hidden = query !== "" && !row.textContent.toLowerCase().includes(query);
+    });
+  });
+});
+</script>
+</body>
+</html>
+`))
+
+// htmlEnvironment is an environment of the HTML page, the names and IDs are set for the links.
+type htmlEnvironment struct {
+	Name  string
+	Error string
+	Nodes []htmlNode
+}
+
+type htmlNode struct {
+	ID        string
+	File      string
+	Nodes     []string
+	Templates []string
+	Config    string
+	Diff      []htmlLine
+	Values    []htmlValue
+	Error     string
+}
+
+// htmlLine is a line of a diff, the class colors the added and removed lines.
+type htmlLine struct {
+	Text  string
+	Class string
+}
+
+type htmlValue struct {
+	Path   string
+	Value  string
+	Source string
+	Secret bool
+}
+
+// WriteHTML writes the environments as a standalone HTML page.
+func WriteHTML(w io.Writer, environments []Environment, generated time.Time) error {
+	count, drifted := 0, 0
+	var htmlEnvironments []htmlEnvironment
+	for i, environment := range environments {
+		e := htmlEnvironment{Name: environment.Name, Error: environment.Error}
+		if e.Name == "" {
+			e.Name = "project"
+		}
+		for j, node := range environment.Nodes {
+			n := htmlNode{
+				ID:        fmt.Sprintf("node-%d-%d", i, j),
+				File:      node.File,
+				Nodes:     node.Nodes,
+				Templates: node.Templates,
+				Config:    node.Config,
+				Diff:      diffLines(node.Diff),
+				Error:     node.Error,
+			}
+			for _, value := range node.Values {
+				v := htmlValue{Path: value.Path, Source: value.Source, Secret: value.Secret}
+				if !value.Secret {
+					data, err := json.Marshal(value.Value)
+					if err != nil {
+						return fmt.Errorf("%s: %w", value.Path, err)
+					}
+					v.Value = string(data)
+				}
+				n.Values = append(n.Values, v)
+			}
+			count++
+			if node.Diff != "" {
+				drifted++
+			}
+			e.Nodes = append(e.Nodes, n)
+		}
+		htmlEnvironments = append(htmlEnvironments, e)
+	}
+
+	return htmlPreview.Execute(w, map[string]any{
+		"Environments": htmlEnvironments,
+		"Count":        count,
+		"Drifted":      drifted,
+		"Generated":    generated.UTC().Format(time.RFC3339),
+	})
+}
+
+func diffLines(diff string) []htmlLine {
+	if diff == "" {
+		return nil
+	}
+	var lines []htmlLine
+	for _, line := range strings.Split(strings.TrimSuffix(diff, "\n"), "\n") {
+		l := htmlLine{Text: line}
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+		case strings.HasPrefix(line, "+"):
+			l.Class = "add"
+		case strings.HasPrefix(line, "-"):
+			l.Class = "del"
+		case strings.HasPrefix(line, "@@"):
+			l.Class = "hunk"
+		}
+		lines = append(lines, l)
+	}
+	return lines
+}
+
+// Handler serves the page of the environments, they are loaded again on every request so
+// reloading the page shows the changes of the templates and values.
+func Handler(load func() ([]Environment, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		environments, err := load()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var page bytes.Buffer
+		if err := WriteHTML(&page, environments, time.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(page.Bytes()) //nolint:errcheck
+	})
+}
@@ -0,0 +1,90 @@
+package preview
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aenix-io/talm/pkg/engine"
+)
+
+var environments = []Environment{
+	{
+		Nodes: []Node{
+			{
+				File:      "nodes/cp1.yaml",
+				Nodes:     []string{"10.0.0.1"},
+				Templates: []string{"templates/controlplane.yaml"},
+				Config:    "machine:\n  type: controlplane\n",
+				Diff:      "--- nodes/cp1.yaml\n+++ nodes/cp1.yaml (rendered)\n@@ -1,2 +1,2 @@\n-  type: worker\n+  type: controlplane\n",
+				Values: []engine.ValueSource{
+					{Path: "endpoint", Value: "https://10.0.0.1:6443", Source: "modeline"},
+					{Path: "token", Source: "values.yaml", Secret: true},
+				},
+			},
+		},
+	},
+	{
+		Name:  "prod",
+		Nodes: []Node{{File: "clusters/prod/nodes/w1.yaml", Error: "template: <missing>"}},
+	},
+}
+
+func TestWriteHTML(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteHTML(&buf, environments, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"Generated at 2024-05-01T12:00:00Z, 2 node files, 1 drifted.",
+		`<li><a href="#node-0-0">nodes/cp1.yaml</a> <span class="badge">drifted</span></li>`,
+		`<h2>project: nodes/cp1.yaml</h2>`,
+		`<span class="del">-  type: worker</span>`,
+		`<span class="add">&#43;  type: controlplane</span>`,
+		`<tr class="value"><td>endpoint</td><td>&#34;https://10.0.0.1:6443&#34;</td><td>modeline</td></tr>`,
+		`<tr class="value"><td>token</td><td class="secret">secret</td><td>values.yaml</td></tr>`,
+		`<h2>prod: clusters/prod/nodes/w1.yaml</h2>`,
+		`<p class="alert">template: &lt;missing&gt;</p>`,
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("expected %q in the page:\n%s", expected, buf.String())
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	loads := 0
+	server := httptest.NewServer(Handler(func() ([]Environment, error) {
+		loads++
+		if loads > 1 {
+			return nil, errors.New("no nodes")
+		}
+		return environments, nil
+	}))
+	defer server.Close()
+
+	for _, expected := range []int{http.StatusOK, http.StatusInternalServerError} {
+		resp, err := http.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != expected {
+			t.Errorf("expected status %d, got %d", expected, resp.StatusCode)
+		}
+	}
+
+	resp, err := http.Get(server.URL + "/favicon.ico")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound || loads != 2 {
+		t.Errorf("expected other paths not to be served, got %d after %d loads", resp.StatusCode, loads)
+	}
+}
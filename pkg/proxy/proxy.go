@@ -0,0 +1,175 @@
+// Package proxy dials Talos API endpoints through a SOCKS5 proxy or an SSH bastion host.
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/net/proxy"
+)
+
+// DialFunc dials the address through the proxy.
+type DialFunc func(ctx context.Context, addr string) (net.Conn, error)
+
+// defaultSSHPort is used when the bastion URL has no port.
+const defaultSSHPort = "22"
+
+// New returns the dial function for the proxy URL:
+//
+//	socks5://[user:password@]host:port
+//	ssh://[user@]host[:port]
+//
+// SSH authenticates with the keys of the SSH agent and the unencrypted default identity files,
+// the host key of the bastion is verified against ~/.ssh/known_hosts.
+func New(rawURL string) (DialFunc, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL %q: %w", rawURL, err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy URL %q: host is missing", rawURL)
+	}
+
+	switch u.Scheme {
+	case "socks5", "socks5h":
+		return socks5Dialer(u)
+	case "ssh":
+		return sshDialer(u), nil
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q, valid schemes are: socks5, ssh", u.Scheme)
+	}
+}
+
+func socks5Dialer(u *url.URL) (DialFunc, error) {
+	var auth *proxy.Auth
+	if u.User != nil {
+		password, _ := u.User.Password()
+		auth = &proxy.Auth{User: u.User.Username(), Password: password}
+	}
+
+	dialer, err := proxy.SOCKS5("tcp", u.Host, auth, &net.Dialer{})
+	if err != nil {
+		return nil, err
+	}
+
+	contextDialer, ok := dialer.(proxy.ContextDialer)
+	if !ok {
+		return nil, errors.New("SOCKS5 dialer doesn't support contexts")
+	}
+
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		return contextDialer.DialContext(ctx, "tcp", addr)
+	}, nil
+}
+
+// sshDialer connects to the bastion on the first dial, the connection is shared by all dials.
+func sshDialer(u *url.URL) DialFunc {
+	var (
+		mu     sync.Mutex
+		client *ssh.Client
+	)
+
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if client == nil {
+			var err error
+			client, err = dialSSH(ctx, u)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		conn, err := client.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to dial %s through %s: %w", addr, u.Host, err)
+		}
+
+		return conn, nil
+	}
+}
+
+func dialSSH(ctx context.Context, u *url.URL) (*ssh.Client, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+
+	hostKeyCallback, err := knownhosts.New(filepath.Join(home, ".ssh", "known_hosts"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load known hosts: %w", err)
+	}
+
+	user := u.User.Username()
+	if user == "" {
+		user = os.Getenv("USER")
+	}
+
+	config := &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeysCallback(signers(home))},
+		HostKeyCallback: hostKeyCallback,
+	}
+
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), defaultSSHPort)
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to bastion %s: %w", addr, err)
+	}
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		conn.Close() //nolint:errcheck
+		return nil, fmt.Errorf("failed to connect to bastion %s: %w", addr, err)
+	}
+
+	return ssh.NewClient(sshConn, chans, reqs), nil
+}
+
+// signers returns the keys of the SSH agent followed by the unencrypted default identity files.
+func signers(home string) func() ([]ssh.Signer, error) {
+	return func() ([]ssh.Signer, error) {
+		var result []ssh.Signer
+
+		if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+			if conn, err := net.Dial("unix", socket); err == nil {
+				if agentSigners, err := agent.NewClient(conn).Signers(); err == nil {
+					result = append(result, agentSigners...)
+				}
+			}
+		}
+
+		for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+			data, err := os.ReadFile(filepath.Join(home, ".ssh", name))
+			if err != nil {
+				continue
+			}
+			signer, err := ssh.ParsePrivateKey(data)
+			if err != nil {
+				continue
+			}
+			result = append(result, signer)
+		}
+
+		if len(result) == 0 {
+			return nil, errors.New("no SSH keys found in the agent or ~/.ssh")
+		}
+
+		return result, nil
+	}
+}
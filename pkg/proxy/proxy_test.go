@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+)
+
+func TestNewInvalid(t *testing.T) {
+	for _, rawURL := range []string{"http://proxy:3128", "bastion", "ssh://", "socks5://%zz"} {
+		if _, err := New(rawURL); err == nil {
+			t.Errorf("expected error for %q", rawURL)
+		}
+	}
+}
+
+// serveSOCKS5 accepts a single no-auth CONNECT request and connects it to the target.
+func serveSOCKS5(t *testing.T, l net.Listener) {
+	conn, err := l.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close() //nolint:errcheck
+
+	buf := make([]byte, 262)
+	// Greeting: version, number of methods, methods
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+		t.Error(err)
+		return
+	}
+	conn.Write([]byte{5, 0}) //nolint:errcheck
+
+	// Request: version, command, reserved, IPv4 address type, address, port
+	if _, err := io.ReadFull(conn, buf[:10]); err != nil {
+		t.Error(err)
+		return
+	}
+	addr := net.JoinHostPort(net.IP(buf[4:8]).String(), strconv.Itoa(int(binary.BigEndian.Uint16(buf[8:10]))))
+
+	target, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer target.Close() //nolint:errcheck
+
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0}) //nolint:errcheck
+
+	go io.Copy(target, conn) //nolint:errcheck
+	io.Copy(conn, target)    //nolint:errcheck
+}
+
+func TestSOCKS5(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close() //nolint:errcheck
+
+	go func() {
+		conn, err := target.Accept()
+		if err != nil {
+			return
+		}
+		conn.Write([]byte("talos")) //nolint:errcheck
+		conn.Close()                //nolint:errcheck
+	}()
+
+	socks, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socks.Close() //nolint:errcheck
+	go serveSOCKS5(t, socks)
+
+	dial, err := New("socks5://" + socks.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := dial(context.Background(), target.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close() //nolint:errcheck
+
+	data, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "talos" {
+		t.Errorf("unexpected data %q", data)
+	}
+}
@@ -0,0 +1,205 @@
+// Package query evaluates jq-style path expressions on decoded YAML or JSON documents.
+//
+// The supported subset selects values without transforming them:
+//
+//	.                    the whole document
+//	.machine.install     fields, also written as .["machine"] or ."key.with.dots"
+//	.cluster.network.podSubnets[0]
+//	.machine.network.interfaces[].interface
+//
+// Iterating with [] over arrays or objects yields several results,
+// a missing field or index yields null like in jq.
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type stepKind int
+
+const (
+	stepField stepKind = iota
+	stepIndex
+	stepIterate
+)
+
+type step struct {
+	kind  stepKind
+	field string
+	index int
+}
+
+// Query is a compiled path expression.
+type Query struct {
+	expr  string
+	steps []step
+}
+
+// Compile parses the expression.
+func Compile(expr string) (*Query, error) {
+	q := &Query{expr: expr}
+	s := strings.TrimSpace(expr)
+	if !strings.HasPrefix(s, ".") {
+		return nil, fmt.Errorf("query %q: must start with '.'", expr)
+	}
+
+	for i := 0; i < len(s); {
+		switch {
+		case s[i] == '.' && i+1 < len(s) && s[i+1] == '"':
+			field, n, err := parseQuoted(s[i+1:])
+			if err != nil {
+				return nil, fmt.Errorf("query %q: %w", expr, err)
+			}
+			q.steps = append(q.steps, step{kind: stepField, field: field})
+			i += 1 + n
+		case s[i] == '.':
+			j := i + 1
+			for j < len(s) && isIdentChar(s[j]) {
+				j++
+			}
+			if j > i+1 {
+				q.steps = append(q.steps, step{kind: stepField, field: s[i+1 : j]})
+			} else if j < len(s) && s[j] != '[' {
+				return nil, fmt.Errorf("query %q: unexpected %q at %d", expr, s[j], j)
+			}
+			i = j
+		case s[i] == '[':
+			end := strings.IndexByte(s[i:], ']')
+			if strings.HasPrefix(s[i+1:], `"`) {
+				field, n, err := parseQuoted(s[i+1:])
+				if err != nil {
+					return nil, fmt.Errorf("query %q: %w", expr, err)
+				}
+				if i+1+n >= len(s) || s[i+1+n] != ']' {
+					return nil, fmt.Errorf("query %q: missing ']' at %d", expr, i+1+n)
+				}
+				q.steps = append(q.steps, step{kind: stepField, field: field})
+				i += n + 2
+				continue
+			}
+			if end < 0 {
+				return nil, fmt.Errorf("query %q: missing ']'", expr)
+			}
+			inner := strings.TrimSpace(s[i+1 : i+end])
+			if inner == "" {
+				q.steps = append(q.steps, step{kind: stepIterate})
+			} else {
+				index, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, fmt.Errorf("query %q: invalid index %q", expr, inner)
+				}
+				q.steps = append(q.steps, step{kind: stepIndex, index: index})
+			}
+			i += end + 1
+		default:
+			return nil, fmt.Errorf("query %q: unexpected %q at %d", expr, s[i], i)
+		}
+	}
+
+	return q, nil
+}
+
+// String returns the source expression.
+func (q *Query) String() string {
+	return q.expr
+}
+
+// Eval returns the values selected by the query in the document.
+func (q *Query) Eval(doc interface{}) []interface{} {
+	values := []interface{}{doc}
+	for _, st := range q.steps {
+		var next []interface{}
+		for _, v := range values {
+			next = append(next, st.apply(v)...)
+		}
+		values = next
+	}
+	return values
+}
+
+func (st step) apply(v interface{}) []interface{} {
+	switch st.kind {
+	case stepField:
+		if m, ok := v.(map[string]interface{}); ok {
+			return []interface{}{m[st.field]}
+		}
+		return []interface{}{nil}
+	case stepIndex:
+		if a, ok := v.([]interface{}); ok {
+			index := st.index
+			if index < 0 {
+				index += len(a)
+			}
+			if index >= 0 && index < len(a) {
+				return []interface{}{a[index]}
+			}
+		}
+		return []interface{}{nil}
+	default:
+		switch t := v.(type) {
+		case []interface{}:
+			return t
+		case map[string]interface{}:
+			keys := make([]string, 0, len(t))
+			for k := range t {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			values := make([]interface{}, 0, len(t))
+			for _, k := range keys {
+				values = append(values, t[k])
+			}
+			return values
+		}
+		return nil
+	}
+}
+
+// Format renders the results of a query as a single line: scalars as they are,
+// objects and arrays as compact JSON, several results separated by commas.
+func Format(values []interface{}) string {
+	parts := make([]string, 0, len(values))
+	for _, v := range values {
+		switch t := v.(type) {
+		case nil:
+			parts = append(parts, "null")
+		case string:
+			parts = append(parts, t)
+		case map[string]interface{}, []interface{}:
+			data, err := json.Marshal(t)
+			if err != nil {
+				parts = append(parts, fmt.Sprint(t))
+				continue
+			}
+			parts = append(parts, string(data))
+		default:
+			parts = append(parts, fmt.Sprint(t))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '-' || c == '$' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
+
+// parseQuoted parses the JSON string at the start of s and returns its length.
+func parseQuoted(s string) (string, int, error) {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			var field string
+			if err := json.Unmarshal([]byte(s[:i+1]), &field); err != nil {
+				return "", 0, err
+			}
+			return field, i + 1, nil
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string")
+}
@@ -0,0 +1,61 @@
+package query
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+const config = `
+machine:
+  type: controlplane
+  install:
+    disk: /dev/nvme0n1
+  network:
+    interfaces:
+    - interface: eth0
+      addresses: [10.0.0.1/24]
+    - interface: eth1
+  nodeLabels:
+    topology.kubernetes.io/zone: a
+cluster:
+  network:
+    podSubnets: [10.244.0.0/16]
+`
+
+func TestEval(t *testing.T) {
+	var doc interface{}
+	if err := yaml.Unmarshal([]byte(config), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	for expr, want := range map[string]string{
+		".machine.install.disk":                              "/dev/nvme0n1",
+		".machine.network.interfaces[].interface":            "eth0,eth1",
+		".machine.network.interfaces[0].addresses":           `["10.0.0.1/24"]`,
+		".machine.network.interfaces[-1].addresses":          "null",
+		`.machine.nodeLabels."topology.kubernetes.io/zone"`:  "a",
+		`.machine.nodeLabels["topology.kubernetes.io/zone"]`: "a",
+		".cluster.network.podSubnets[5]":                     "null",
+		".machine.kubelet.image":                             "null",
+		".machine.install":                                   `{"disk":"/dev/nvme0n1"}`,
+		".machine.install[]":                                 "/dev/nvme0n1",
+	} {
+		q, err := Compile(expr)
+		if err != nil {
+			t.Errorf("%s: %v", expr, err)
+			continue
+		}
+		if got := Format(q.Eval(doc)); got != want {
+			t.Errorf("%s: expected %q, got %q", expr, want, got)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, expr := range []string{"machine", ".machine[", ".machine[x]", `."unterminated`, ".a b"} {
+		if _, err := Compile(expr); err == nil {
+			t.Errorf("%s: expected error", expr)
+		}
+	}
+}
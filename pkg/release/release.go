@@ -0,0 +1,311 @@
+// Package release records the applies of the node files as numbered releases, like Helm
+// releases, to list them, compare them and roll back to one of them.
+package release
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pmezard/go-difflib/difflib"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ErrNotFound is returned when the release doesn't exist.
+var ErrNotFound = errors.New("release not found")
+
+// Release is a successful apply of node files.
+type Release struct {
+	Number       int       `json:"number"`
+	Time         time.Time `json:"time"`
+	ChartName    string    `json:"chartName,omitempty"`
+	ChartVersion string    `json:"chartVersion,omitempty"`
+	// ValuesHash is the hash of the values of the chart the node files were applied with
+	ValuesHash string `json:"valuesHash,omitempty"`
+	// RollbackOf is the number of the release rolled back to
+	RollbackOf int    `json:"rollbackOf,omitempty"`
+	Files      []File `json:"files"`
+}
+
+// File is a node file applied in a release.
+type File struct {
+	Path  string   `json:"path"`
+	Nodes []string `json:"nodes"`
+	// ConfigHash is the hash of the rendered config applied to the nodes
+	ConfigHash string `json:"configHash"`
+	// Content is the node file, it is written back on rollback
+	Content string `json:"content"`
+}
+
+// Nodes returns the nodes touched by the release.
+func (r Release) Nodes() []string {
+	var nodes []string
+	seen := map[string]bool{}
+	for _, file := range r.Files {
+		for _, node := range file.Nodes {
+			if !seen[node] {
+				seen[node] = true
+				nodes = append(nodes, node)
+			}
+		}
+	}
+	return nodes
+}
+
+// Storage stores the releases.
+type Storage interface {
+	// List returns the releases sorted by number.
+	List(ctx context.Context) ([]Release, error)
+	// Get returns the release, or ErrNotFound.
+	Get(ctx context.Context, number int) (Release, error)
+	// Create stores a new release.
+	Create(ctx context.Context, release Release) error
+}
+
+// Next returns the number of the next release.
+func Next(ctx context.Context, s Storage) (int, error) {
+	releases, err := s.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if len(releases) == 0 {
+		return 1, nil
+	}
+	return releases[len(releases)-1].Number + 1, nil
+}
+
+// Diff returns the unified diff of the node files of the releases.
+func Diff(a, b Release) (string, error) {
+	contents := func(r Release) map[string]string {
+		m := make(map[string]string, len(r.Files))
+		for _, file := range r.Files {
+			m[file.Path] = file.Content
+		}
+		return m
+	}
+	from, to := contents(a), contents(b)
+
+	var paths []string
+	for path := range from {
+		paths = append(paths, path)
+	}
+	for path := range to {
+		if _, ok := from[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	var out strings.Builder
+	for _, path := range paths {
+		diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        difflib.SplitLines(from[path]),
+			B:        difflib.SplitLines(to[path]),
+			FromFile: fmt.Sprintf("%s (release %d)", path, a.Number),
+			ToFile:   fmt.Sprintf("%s (release %d)", path, b.Number),
+			Context:  3,
+		})
+		if err != nil {
+			return "", err
+		}
+		out.WriteString(diff)
+	}
+	return out.String(), nil
+}
+
+// Dir stores the releases as JSON files in a directory.
+type Dir struct {
+	Path string
+}
+
+func (d Dir) file(number int) string {
+	return filepath.Join(d.Path, strconv.Itoa(number)+".json")
+}
+
+// List returns the releases sorted by number.
+func (d Dir) List(ctx context.Context) ([]Release, error) {
+	entries, err := os.ReadDir(d.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var releases []Release
+	for _, entry := range entries {
+		number, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), ".json"))
+		if err != nil || entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		release, err := d.Get(ctx, number)
+		if err != nil {
+			return nil, err
+		}
+		releases = append(releases, release)
+	}
+	sort.Slice(releases, func(i, j int) bool { return releases[i].Number < releases[j].Number })
+	return releases, nil
+}
+
+// Get returns the release, or ErrNotFound.
+func (d Dir) Get(_ context.Context, number int) (Release, error) {
+	var release Release
+	data, err := os.ReadFile(d.file(number))
+	if errors.Is(err, fs.ErrNotExist) {
+		return release, fmt.Errorf("%w: %d", ErrNotFound, number)
+	}
+	if err != nil {
+		return release, err
+	}
+	if err := json.Unmarshal(data, &release); err != nil {
+		return release, fmt.Errorf("error parsing release %d: %w", number, err)
+	}
+	return release, nil
+}
+
+// Create stores a new release.
+func (d Dir) Create(_ context.Context, release Release) error {
+	data, err := json.MarshalIndent(release, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(d.Path, 0o755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(d.file(release.Number), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("release %d already exists", release.Number)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close() //nolint:errcheck
+		return err
+	}
+	return file.Close()
+}
+
+// Labels of the secrets storing the releases.
+const (
+	OwnerLabel = "owner"
+	NameLabel  = "name"
+)
+
+// secretKey is the key of the gzipped release in the secret.
+const secretKey = "release"
+
+// Secrets stores the releases in Kubernetes secrets named talm.release.<name>.v<number>,
+// like Helm stores its releases.
+type Secrets struct {
+	Client    kubernetes.Interface
+	Namespace string
+	// Name distinguishes the clusters managed in the same Kubernetes cluster
+	Name string
+}
+
+func (s Secrets) secretName(number int) string {
+	return fmt.Sprintf("talm.release.%s.v%d", s.Name, number)
+}
+
+// List returns the releases sorted by number.
+func (s Secrets) List(ctx context.Context) ([]Release, error) {
+	secrets, err := s.Client.CoreV1().Secrets(s.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=talm,%s=%s", OwnerLabel, NameLabel, s.Name),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing releases: %w", err)
+	}
+
+	releases := make([]Release, 0, len(secrets.Items))
+	for i := range secrets.Items {
+		release, err := decodeSecret(&secrets.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		releases = append(releases, release)
+	}
+	sort.Slice(releases, func(i, j int) bool { return releases[i].Number < releases[j].Number })
+	return releases, nil
+}
+
+// Get returns the release, or ErrNotFound.
+func (s Secrets) Get(ctx context.Context, number int) (Release, error) {
+	secret, err := s.Client.CoreV1().Secrets(s.Namespace).Get(ctx, s.secretName(number), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return Release{}, fmt.Errorf("%w: %d", ErrNotFound, number)
+	}
+	if err != nil {
+		return Release{}, fmt.Errorf("error reading release %d: %w", number, err)
+	}
+	return decodeSecret(secret)
+}
+
+// Create stores a new release.
+func (s Secrets) Create(ctx context.Context, release Release) error {
+	data, err := json.Marshal(release)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      s.secretName(release.Number),
+			Namespace: s.Namespace,
+			Labels: map[string]string{
+				OwnerLabel: "talm",
+				NameLabel:  s.Name,
+				"version":  strconv.Itoa(release.Number),
+			},
+		},
+		Type: "talm.dev/release.v1",
+		Data: map[string][]byte{secretKey: buf.Bytes()},
+	}
+	if _, err := s.Client.CoreV1().Secrets(s.Namespace).Create(ctx, secret, metav1.CreateOptions{}); err != nil {
+		if apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("release %d already exists", release.Number)
+		}
+		return fmt.Errorf("error storing release %d: %w", release.Number, err)
+	}
+	return nil
+}
+
+func decodeSecret(secret *corev1.Secret) (Release, error) {
+	var release Release
+	r, err := gzip.NewReader(bytes.NewReader(secret.Data[secretKey]))
+	if err != nil {
+		return release, fmt.Errorf("error reading secret %s: %w", secret.Name, err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return release, fmt.Errorf("error reading secret %s: %w", secret.Name, err)
+	}
+	if err := json.Unmarshal(data, &release); err != nil {
+		return release, fmt.Errorf("error parsing secret %s: %w", secret.Name, err)
+	}
+	return release, nil
+}
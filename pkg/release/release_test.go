@@ -0,0 +1,100 @@
+package release
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testStorage(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	if number, err := Next(ctx, s); err != nil || number != 1 {
+		t.Fatalf("Next() = %d, %v, want 1", number, err)
+	}
+
+	first := Release{
+		Number:       1,
+		Time:         time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+		ChartVersion: "0.1.0",
+		Files: []File{
+			{Path: "nodes/node1.yaml", Nodes: []string{"10.0.0.1"}, ConfigHash: "a", Content: "machine:\n  type: controlplane\n"},
+		},
+	}
+	second := first
+	second.Number = 2
+	second.RollbackOf = 1
+	for _, release := range []Release{second, first} {
+		if err := s.Create(ctx, release); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Create(ctx, first); err == nil {
+		t.Error("expected an error creating an existing release")
+	}
+
+	releases, err := s.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(releases, []Release{first, second}) {
+		t.Errorf("List() = %v", releases)
+	}
+	if number, err := Next(ctx, s); err != nil || number != 3 {
+		t.Errorf("Next() = %d, %v, want 3", number, err)
+	}
+
+	if release, err := s.Get(ctx, 2); err != nil || !reflect.DeepEqual(release, second) {
+		t.Errorf("Get(2) = %v, %v", release, err)
+	}
+	if _, err := s.Get(ctx, 5); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(5) error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestDir(t *testing.T) {
+	testStorage(t, Dir{Path: t.TempDir()})
+}
+
+func TestSecrets(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	testStorage(t, Secrets{Client: client, Namespace: "kube-system", Name: "prod"})
+
+	// Releases of other clusters are not listed
+	other := Secrets{Client: client, Namespace: "kube-system", Name: "staging"}
+	if releases, err := other.List(context.Background()); err != nil || len(releases) != 0 {
+		t.Errorf("List() of another cluster = %v, %v", releases, err)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a := Release{Number: 3, Files: []File{
+		{Path: "nodes/node1.yaml", Content: "machine:\n  type: worker\n  install:\n    disk: /dev/sda\n"},
+		{Path: "nodes/node2.yaml", Content: "machine:\n  type: worker\n"},
+	}}
+	b := Release{Number: 5, Files: []File{
+		{Path: "nodes/node1.yaml", Content: "machine:\n  type: worker\n  install:\n    disk: /dev/nvme0n1\n"},
+		{Path: "nodes/node2.yaml", Content: "machine:\n  type: worker\n"},
+	}}
+
+	diff, err := Diff(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"--- nodes/node1.yaml (release 3)", "+++ nodes/node1.yaml (release 5)", "-    disk: /dev/sda", "+    disk: /dev/nvme0n1"} {
+		if !strings.Contains(diff, want) {
+			t.Errorf("diff misses %q:\n%s", want, diff)
+		}
+	}
+	if strings.Contains(diff, "node2") {
+		t.Errorf("unchanged files must not be in the diff:\n%s", diff)
+	}
+	if nodes := (Release{Files: []File{{Nodes: []string{"a", "b"}}, {Nodes: []string{"b", "c"}}}}).Nodes(); !reflect.DeepEqual(nodes, []string{"a", "b", "c"}) {
+		t.Errorf("Nodes() = %v", nodes)
+	}
+}
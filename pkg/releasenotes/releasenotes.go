@@ -0,0 +1,179 @@
+// Package releasenotes selects the sections of the Talos release notes relevant to an upgrade:
+// the releases between the running and the target version, and in their notes the sections
+// about the config the chart actually sets or requiring an action.
+package releasenotes
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/blang/semver/v4"
+	"gopkg.in/yaml.v3"
+)
+
+// Release is a Talos release with its notes in Markdown.
+type Release struct {
+	Tag        string
+	URL        string
+	Body       string
+	Prerelease bool
+}
+
+// Section is a section of the notes of a release, under a Markdown heading.
+type Section struct {
+	Title string
+	Body  string
+}
+
+// actionTitle matches the titles of the sections which are always relevant.
+var actionTitle = regexp.MustCompile(`(?i)urgent|breaking|deprecat|removed|required|action|upgrade notes`)
+
+var heading = regexp.MustCompile(`^#{2,4}\s+(.*)$`)
+
+// Between returns the releases newer than from up to and including to, oldest first.
+// Prereleases are skipped unless to is one. An unknown from selects the release to only.
+func Between(releases []Release, from, to string) ([]Release, error) {
+	target, err := semver.ParseTolerant(to)
+	if err != nil {
+		return nil, err
+	}
+	current, err := semver.ParseTolerant(from)
+	known := err == nil
+
+	type versioned struct {
+		release Release
+		version semver.Version
+	}
+	var selected []versioned
+	for _, r := range releases {
+		v, err := semver.ParseTolerant(r.Tag)
+		if err != nil {
+			continue
+		}
+		if r.Prerelease && !v.Equals(target) {
+			continue
+		}
+		if (known && v.GT(current) && v.LTE(target)) || (!known && v.Equals(target)) {
+			selected = append(selected, versioned{r, v})
+		}
+	}
+	sort.Slice(selected, func(i, j int) bool { return selected[i].version.LT(selected[j].version) })
+
+	result := make([]Release, 0, len(selected))
+	for _, s := range selected {
+		result = append(result, s.release)
+	}
+	return result, nil
+}
+
+// Split returns the sections of the notes, the text before the first heading is left out.
+func Split(body string) []Section {
+	var (
+		sections []Section
+		current  *Section
+		text     strings.Builder
+	)
+	flush := func() {
+		if current != nil {
+			current.Body = strings.TrimSpace(text.String())
+			sections = append(sections, *current)
+		}
+		text.Reset()
+	}
+	for _, line := range strings.Split(strings.ReplaceAll(body, "\r\n", "\n"), "\n") {
+		if m := heading.FindStringSubmatch(line); m != nil {
+			flush()
+			current = &Section{Title: strings.TrimSpace(m[1])}
+			continue
+		}
+		text.WriteString(line)
+		text.WriteByte('\n')
+	}
+	flush()
+	return sections
+}
+
+// ConfigKeys returns the dotted paths of the keys set by the machine config documents, e.g.
+// machine.kubelet.extraConfig. The items of lists share the path of the list.
+func ConfigKeys(data []byte) ([]string, error) {
+	keys := map[string]struct{}{}
+	var walk func(prefix string, v interface{})
+	walk = func(prefix string, v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for k, child := range v {
+				path := k
+				if prefix != "" {
+					path = prefix + "." + k
+				}
+				keys[path] = struct{}{}
+				walk(path, child)
+			}
+		case []interface{}:
+			for _, item := range v {
+				walk(prefix, item)
+			}
+		}
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var doc interface{}
+		err := decoder.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		walk("", doc)
+	}
+
+	result := make([]string, 0, len(keys))
+	for k := range keys {
+		result = append(result, k)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// Filter returns the sections requiring an action, and the sections mentioning the keys: their
+// paths without the document root, like kubelet.extraConfig, or their distinctive names, like
+// advertisedSubnets. Short and generic names like disk or name don't select a section.
+func Filter(sections []Section, keys []string) []Section {
+	terms := map[string]struct{}{}
+	for _, key := range keys {
+		parts := strings.Split(key, ".")
+		if len(parts) >= 3 {
+			terms[strings.Join(parts[len(parts)-2:], ".")] = struct{}{}
+		}
+		name := parts[len(parts)-1]
+		if len(name) >= 8 || (len(name) >= 6 && strings.ToLower(name) != name) {
+			terms[name] = struct{}{}
+		}
+	}
+	patterns := make([]*regexp.Regexp, 0, len(terms))
+	for term := range terms {
+		patterns = append(patterns, regexp.MustCompile(`(?i)(^|[^\w.])`+regexp.QuoteMeta(term)+`($|[^\w])`))
+	}
+
+	var result []Section
+	for _, section := range sections {
+		if actionTitle.MatchString(section.Title) {
+			result = append(result, section)
+			continue
+		}
+		text := section.Title + "\n" + section.Body
+		for _, pattern := range patterns {
+			if pattern.MatchString(text) {
+				result = append(result, section)
+				break
+			}
+		}
+	}
+	return result
+}
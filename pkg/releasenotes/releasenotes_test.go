@@ -0,0 +1,86 @@
+package releasenotes
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBetween(t *testing.T) {
+	releases := []Release{{Tag: "v1.7.1"}, {Tag: "v1.6.7"}, {Tag: "v1.7.0"}, {Tag: "v1.8.0-alpha.0", Prerelease: true}, {Tag: "v1.6.2"}, {Tag: "latest"}}
+	tags := func(releases []Release) []string {
+		var result []string
+		for _, r := range releases {
+			result = append(result, r.Tag)
+		}
+		return result
+	}
+
+	for _, tt := range []struct {
+		from, to string
+		want     []string
+	}{
+		{"v1.6.2", "v1.7.1", []string{"v1.6.7", "v1.7.0", "v1.7.1"}},
+		{"v1.7.1", "v1.7.1", nil},
+		{"v1.7.1", "v1.8.0-alpha.0", []string{"v1.8.0-alpha.0"}},
+		{"", "v1.7.0", []string{"v1.7.0"}},
+	} {
+		got, err := Between(releases, tt.from, tt.to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(tags(got), tt.want) {
+			t.Errorf("Between(%q, %q) = %v, want %v", tt.from, tt.to, tags(got), tt.want)
+		}
+	}
+
+	if _, err := Between(releases, "v1.6.0", "latest"); err == nil {
+		t.Error("expected an error for an invalid target version")
+	}
+}
+
+func TestFilter(t *testing.T) {
+	notes := `## [Talos 1.7.0](https://github.com/siderolabs/talos/releases/tag/v1.7.0)
+
+Intro.
+
+### Urgent Upgrade Notes
+
+Read this.
+
+### KubeSpan
+
+KubeSpan now filters endpoints.
+
+### Kubelet
+
+The kubelet.extraConfig now accepts more fields.
+
+### Disk Encryption
+
+The disk encryption supports TPM.
+
+### Network Device Selectors
+
+Device selectors match the driver.
+`
+	sections := Split(notes)
+	if len(sections) != 6 || sections[1].Title != "Urgent Upgrade Notes" || sections[1].Body != "Read this." {
+		t.Fatalf("Split() = %v", sections)
+	}
+
+	keys, err := ConfigKeys([]byte("machine:\n  kubelet:\n    extraConfig:\n      maxPods: 512\n  network:\n    kubespan:\n      enabled: true\n  install:\n    disk: /dev/sda\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(keys, []string{"machine", "machine.install", "machine.install.disk", "machine.kubelet", "machine.kubelet.extraConfig", "machine.kubelet.extraConfig.maxPods", "machine.network", "machine.network.kubespan", "machine.network.kubespan.enabled"}) {
+		t.Errorf("ConfigKeys() = %v", keys)
+	}
+
+	var titles []string
+	for _, section := range Filter(sections, keys) {
+		titles = append(titles, section.Title)
+	}
+	if want := []string{"Urgent Upgrade Notes", "KubeSpan", "Kubelet"}; !reflect.DeepEqual(titles, want) {
+		t.Errorf("Filter() = %v, want %v", titles, want)
+	}
+}
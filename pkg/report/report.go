@@ -0,0 +1,138 @@
+// Package report writes the inventory of the nodes of a cluster as CSV and HTML artifacts for
+// asset management and audits: the software running on every node, its hardware and whether
+// it runs the config of its node file.
+package report
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+)
+
+// Config states of the nodes.
+const (
+	InSync  = "in-sync"
+	Drifted = "drifted"
+	Unknown = "unknown"
+)
+
+// Formats of the report.
+const (
+	CSV  = "csv"
+	HTML = "html"
+)
+
+// Node is the inventory of a node, a row of the report. The fields which couldn't be read are
+// left empty, the error tells why.
+type Node struct {
+	Node           string
+	File           string
+	Hostname       string
+	MachineType    string
+	TalosVersion   string
+	KubeletVersion string
+	Schematic      string
+	Extensions     []string
+	Uptime         time.Duration
+	Disks          []string
+	Interfaces     []string
+	Config         string
+	Error          string
+}
+
+var columns = []string{
+	"node", "file", "hostname", "machine type", "talos version", "kubelet version", "schematic",
+	"extensions", "uptime", "disks", "interfaces", "config", "error",
+}
+
+func (n Node) row() []string {
+	uptime := ""
+	if n.Uptime > 0 {
+		uptime = n.Uptime.Round(time.Minute).String()
+	}
+	return []string{
+		n.Node, n.File, n.Hostname, n.MachineType, n.TalosVersion, n.KubeletVersion, n.Schematic,
+		strings.Join(n.Extensions, "; "), uptime, strings.Join(n.Disks, "; "), strings.Join(n.Interfaces, "; "), n.Config, n.Error,
+	}
+}
+
+// Write writes the report of the nodes in the format.
+func Write(w io.Writer, format string, nodes []Node, generated time.Time) error {
+	switch format {
+	case CSV:
+		return WriteCSV(w, nodes)
+	case HTML:
+		return WriteHTML(w, nodes, generated)
+	}
+	return fmt.Errorf("unknown report format %q, use %s or %s", format, CSV, HTML)
+}
+
+// WriteCSV writes the report as CSV with a header, the lists are separated by semicolons.
+func WriteCSV(w io.Writer, nodes []Node) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(columns); err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		if err := writer.Write(node.row()); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+var htmlReport = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Talos nodes</title>
+<style>
+body { font-family: sans-serif; font-size: 14px; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+th { background: #eee; }
+.alert { color: #b00; }
+</style>
+</head>
+<body>
+<h1>Talos nodes</h1>
+<p>Generated at {{ .Generated }}, {{ len .Rows }} nodes.</p>
+<table>
+<tr>{{ range .Columns }}<th>{{ . }}</th>{{ end }}</tr>
+{{- range .Rows }}
+<tr>{{ range . }}<td{{ if .Alert }} class="alert"{{ end }}>{{ range $i, $line := .Lines }}{{ if $i }}<br>{{ end }}{{ $line }}{{ end }}</td>{{ end }}</tr>
+{{- end }}
+</table>
+</body>
+</html>
+`))
+
+// htmlCell is a cell of the HTML report, the items of the lists are put on separate lines.
+type htmlCell struct {
+	Lines []string
+	Alert bool
+}
+
+// WriteHTML writes the report as a standalone HTML page, drifted configs and errors are highlighted.
+func WriteHTML(w io.Writer, nodes []Node, generated time.Time) error {
+	rows := make([][]htmlCell, 0, len(nodes))
+	for _, node := range nodes {
+		var cells []htmlCell
+		for i, value := range node.row() {
+			cells = append(cells, htmlCell{
+				Lines: strings.Split(value, "; "),
+				Alert: value != "" && (columns[i] == "error" || columns[i] == "config" && value == Drifted),
+			})
+		}
+		rows = append(rows, cells)
+	}
+	return htmlReport.Execute(w, map[string]any{
+		"Columns":   columns,
+		"Rows":      rows,
+		"Generated": generated.UTC().Format(time.RFC3339),
+	})
+}
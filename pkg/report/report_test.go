@@ -0,0 +1,69 @@
+package report
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+var nodes = []Node{
+	{
+		Node:           "10.0.0.1",
+		File:           "nodes/cp1.yaml",
+		Hostname:       "cp1",
+		MachineType:    "controlplane",
+		TalosVersion:   "v1.7.1",
+		KubeletVersion: "v1.30.0",
+		Schematic:      "376567988ad370138ad8b2698212367b8edcb69b5fd68c80be1f2ec7d603b4ba",
+		Extensions:     []string{"iscsi-tools", "qemu-guest-agent"},
+		Uptime:         26*time.Hour + 3*time.Minute + 10*time.Second,
+		Disks:          []string{"/dev/sda QEMU HARDDISK 11 GB"},
+		Interfaces:     []string{"eth0 aa:bb:cc:00:00:01"},
+		Config:         Drifted,
+	},
+	{
+		Node:   "10.0.0.2",
+		File:   "nodes/w1.yaml",
+		Config: Unknown,
+		Error:  "rpc error: <unavailable>",
+	},
+}
+
+func TestWriteCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, CSV, nodes, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `node,file,hostname,machine type,talos version,kubelet version,schematic,extensions,uptime,disks,interfaces,config,error
+10.0.0.1,nodes/cp1.yaml,cp1,controlplane,v1.7.1,v1.30.0,376567988ad370138ad8b2698212367b8edcb69b5fd68c80be1f2ec7d603b4ba,iscsi-tools; qemu-guest-agent,26h3m0s,/dev/sda QEMU HARDDISK 11 GB,eth0 aa:bb:cc:00:00:01,drifted,
+10.0.0.2,nodes/w1.yaml,,,,,,,,,,unknown,rpc error: <unavailable>
+`
+	if buf.String() != expected {
+		t.Errorf("unexpected CSV:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+}
+
+func TestWriteHTML(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, HTML, nodes, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"Generated at 2024-05-01T12:00:00Z, 2 nodes.",
+		"<td>iscsi-tools<br>qemu-guest-agent</td>",
+		`<td class="alert">drifted</td>`,
+		`<td class="alert">rpc error: &lt;unavailable&gt;</td>`,
+		"<td>unknown</td>",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("expected %q in the report:\n%s", expected, buf.String())
+		}
+	}
+
+	if err := Write(&buf, "pdf", nodes, time.Now()); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,121 @@
+// Package sandbox runs talm in a container with a pinned talm version, so renders are
+// byte-identical on every machine regardless of the locally installed talm.
+package sandbox
+
+import (
+	"fmt"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DefaultImage is the repository of the talm images, tagged by talm version.
+const DefaultImage = "ghcr.io/aenix-io/talm"
+
+// ProjectDir is the mount point of the project in the container.
+const ProjectDir = "/project"
+
+// RuntimeEnvVar overrides the detected container runtime.
+const RuntimeEnvVar = "TALM_CONTAINER_RUNTIME"
+
+// Runtimes are the supported container runtimes in the order of detection.
+var Runtimes = []string{"podman", "docker", "nerdctl"}
+
+// DetectRuntime returns the container runtime to use: the preferred one if set, otherwise
+// the first supported runtime found by lookPath.
+func DetectRuntime(preferred string, lookPath func(string) (string, error)) (string, error) {
+	if preferred != "" {
+		if _, err := lookPath(preferred); err != nil {
+			return "", fmt.Errorf("container runtime %s not found: %w", preferred, err)
+		}
+		return preferred, nil
+	}
+	for _, runtime := range Runtimes {
+		if _, err := lookPath(runtime); err == nil {
+			return runtime, nil
+		}
+	}
+	return "", fmt.Errorf("no container runtime found, install one of %s", strings.Join(Runtimes, ", "))
+}
+
+// Image returns the talm image of the version, the image is used as is if set.
+func Image(image, version string) (string, error) {
+	if image != "" {
+		return image, nil
+	}
+	if version == "" || version == "dev" {
+		return "", fmt.Errorf("talm version is not pinned, set sandboxOptions.talmVersion in Chart.yaml or --image")
+	}
+	return DefaultImage + ":v" + strings.TrimPrefix(version, "v"), nil
+}
+
+// Options describe a talm run in a container.
+type Options struct {
+	Runtime string
+	Image   string
+	// Root is the absolute path of the project, it is mounted read-write.
+	Root string
+	// Dir is the absolute working directory, it must be inside Root.
+	Dir string
+	// UID and GID own the files written in the project, negative values keep the image user.
+	UID, GID int
+	Env      map[string]string
+	// Args are the talm arguments.
+	Args []string
+}
+
+// RunArgs returns the arguments of the container runtime to run talm. The container has no
+// network, talm reads only the mounted project.
+func RunArgs(opts Options) ([]string, error) {
+	rel, err := filepath.Rel(opts.Root, opts.Dir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, fmt.Errorf("working directory %s is outside of the project %s", opts.Dir, opts.Root)
+	}
+
+	args := []string{
+		"run", "--rm", "--interactive",
+		"--network", "none",
+		"--mount", fmt.Sprintf("type=bind,source=%s,target=%s", opts.Root, ProjectDir),
+		"--workdir", path.Join(ProjectDir, filepath.ToSlash(rel)),
+	}
+
+	if opts.UID >= 0 && opts.GID >= 0 {
+		if opts.Runtime == "podman" {
+			// Rootless podman maps the user into the container
+			args = append(args, "--userns", "keep-id")
+		} else {
+			args = append(args, "--user", fmt.Sprintf("%d:%d", opts.UID, opts.GID))
+		}
+	}
+	if opts.Runtime == "podman" {
+		// The project is not relabeled, it may be shared with other containers
+		args = append(args, "--security-opt", "label=disable")
+	}
+
+	for _, name := range sortedKeys(opts.Env) {
+		args = append(args, "--env", name+"="+opts.Env[name])
+	}
+
+	args = append(args, opts.Image, "--root", ProjectDir)
+	return append(args, opts.Args...), nil
+}
+
+// Command returns the command running talm in a container.
+func Command(opts Options) (*exec.Cmd, error) {
+	args, err := RunArgs(opts)
+	if err != nil {
+		return nil, err
+	}
+	return exec.Command(opts.Runtime, args...), nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,116 @@
+package sandbox
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDetectRuntime(t *testing.T) {
+	lookPath := func(installed ...string) func(string) (string, error) {
+		return func(name string) (string, error) {
+			for _, runtime := range installed {
+				if runtime == name {
+					return "/usr/bin/" + name, nil
+				}
+			}
+			return "", errors.New("not found")
+		}
+	}
+
+	tests := []struct {
+		preferred string
+		installed []string
+		expected  string
+		err       bool
+	}{
+		{installed: []string{"docker", "podman"}, expected: "podman"},
+		{installed: []string{"nerdctl"}, expected: "nerdctl"},
+		{preferred: "docker", installed: []string{"docker", "podman"}, expected: "docker"},
+		{preferred: "docker", installed: []string{"podman"}, err: true},
+		{err: true},
+	}
+	for _, tt := range tests {
+		runtime, err := DetectRuntime(tt.preferred, lookPath(tt.installed...))
+		if (err != nil) != tt.err {
+			t.Errorf("DetectRuntime(%q, %v) error = %v", tt.preferred, tt.installed, err)
+			continue
+		}
+		if runtime != tt.expected {
+			t.Errorf("DetectRuntime(%q, %v) = %q, expected %q", tt.preferred, tt.installed, runtime, tt.expected)
+		}
+	}
+}
+
+func TestImage(t *testing.T) {
+	if image, err := Image("", "1.2.3"); err != nil || image != "ghcr.io/aenix-io/talm:v1.2.3" {
+		t.Errorf("unexpected image %q: %v", image, err)
+	}
+	if image, err := Image("", "v1.2.3"); err != nil || image != "ghcr.io/aenix-io/talm:v1.2.3" {
+		t.Errorf("unexpected image %q: %v", image, err)
+	}
+	if image, err := Image("registry.local/talm:1.2.3", "dev"); err != nil || image != "registry.local/talm:1.2.3" {
+		t.Errorf("unexpected image %q: %v", image, err)
+	}
+	if _, err := Image("", "dev"); err == nil {
+		t.Error("expected an error for an unpinned version")
+	}
+}
+
+func TestRunArgs(t *testing.T) {
+	opts := Options{
+		Runtime: "docker",
+		Image:   "ghcr.io/aenix-io/talm:v1.2.3",
+		Root:    "/home/user/cluster",
+		Dir:     "/home/user/cluster/nodes",
+		UID:     1000,
+		GID:     1000,
+		Env:     map[string]string{"TALM_WORKSPACE": "prod", "TALM_ENVIRONMENT": "prod"},
+		Args:    []string{"template", "--offline", "-f", "node1.yaml"},
+	}
+
+	args, err := RunArgs(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"run", "--rm", "--interactive",
+		"--network", "none",
+		"--mount", "type=bind,source=/home/user/cluster,target=/project",
+		"--workdir", "/project/nodes",
+		"--user", "1000:1000",
+		"--env", "TALM_ENVIRONMENT=prod",
+		"--env", "TALM_WORKSPACE=prod",
+		"ghcr.io/aenix-io/talm:v1.2.3", "--root", "/project",
+		"template", "--offline", "-f", "node1.yaml",
+	}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("expected:\n%q\ngot:\n%q", expected, args)
+	}
+
+	opts.Runtime = "podman"
+	opts.Dir = opts.Root
+	opts.Env = nil
+	args, err = RunArgs(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{
+		"run", "--rm", "--interactive",
+		"--network", "none",
+		"--mount", "type=bind,source=/home/user/cluster,target=/project",
+		"--workdir", "/project",
+		"--userns", "keep-id",
+		"--security-opt", "label=disable",
+		"ghcr.io/aenix-io/talm:v1.2.3", "--root", "/project",
+		"template", "--offline", "-f", "node1.yaml",
+	}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("expected:\n%q\ngot:\n%q", expected, args)
+	}
+
+	opts.Dir = "/home/user"
+	if _, err := RunArgs(opts); err == nil {
+		t.Error("expected an error for a working directory outside of the project")
+	}
+}
@@ -0,0 +1,329 @@
+// Package schematic describes the system extensions and kernel args of the Talos installer image
+// built by the image factory (https://factory.talos.dev) and detects the drift of the nodes from it.
+//
+// With SecureBoot the factory builds the installer-secureboot image instead, installing a signed
+// UKI (unified kernel image) with the kernel args of the schematic.
+package schematic
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultFactory is the image factory used when the values don't set one.
+const DefaultFactory = "factory.talos.dev"
+
+// ExtensionName is the name of the extension the image factory adds to its images,
+// its version is the schematic ID.
+const ExtensionName = "schematic"
+
+// Schematic is the customization of the installer image, it is marshaled like in the image
+// factory, so its ID is the one the factory computes.
+type Schematic struct {
+	Customization Customization `yaml:"customization"`
+}
+
+// Customization holds the extra kernel args and the system extensions of the image.
+type Customization struct {
+	ExtraKernelArgs  []string         `yaml:"extraKernelArgs,omitempty"`
+	SystemExtensions SystemExtensions `yaml:"systemExtensions,omitempty"`
+}
+
+// SystemExtensions lists the official system extensions, like siderolabs/iscsi-tools.
+type SystemExtensions struct {
+	OfficialExtensions []string `yaml:"officialExtensions,omitempty"`
+}
+
+// Installer image repositories of the image factory, by boot mode.
+const (
+	installerRepository           = "installer"
+	secureBootInstallerRepository = "installer-secureboot"
+)
+
+// Values is the schematic section of the chart values.
+type Values struct {
+	Factory         string
+	Version         string
+	Extensions      []string
+	ExtraKernelArgs []string
+	// SecureBoot selects the SecureBoot installer image, it is secureBoot.enabled of the values
+	SecureBoot bool
+}
+
+// FromValues reads the schematic and secureBoot sections of the chart values, it returns nil without
+// extensions, kernel args and SecureBoot.
+func FromValues(values map[string]interface{}) (*Values, error) {
+	v := &Values{Factory: DefaultFactory}
+	if secureBoot, ok := values["secureBoot"].(map[string]interface{}); ok {
+		v.SecureBoot, _ = secureBoot["enabled"].(bool)
+	}
+
+	section, ok := values["schematic"].(map[string]interface{})
+	if !ok || section == nil {
+		if v.SecureBoot {
+			return v, nil
+		}
+		return nil, nil
+	}
+
+	if factory, ok := section["factory"].(string); ok && factory != "" {
+		v.Factory = strings.TrimSuffix(factory, "/")
+	}
+	if version, ok := section["version"].(string); ok {
+		v.Version = version
+	}
+
+	var err error
+	if v.Extensions, err = stringList(section, "extensions"); err != nil {
+		return nil, err
+	}
+	if v.ExtraKernelArgs, err = stringList(section, "extraKernelArgs"); err != nil {
+		return nil, err
+	}
+	if len(v.Extensions) == 0 && len(v.ExtraKernelArgs) == 0 && !v.SecureBoot {
+		return nil, nil
+	}
+
+	return v, nil
+}
+
+func stringList(section map[string]interface{}, key string) ([]string, error) {
+	if section[key] == nil {
+		return nil, nil
+	}
+	items, ok := section[key].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("schematic.%s must be a list", key)
+	}
+	list := make([]string, 0, len(items))
+	for _, item := range items {
+		s, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("schematic.%s must be a list of strings", key)
+		}
+		list = append(list, s)
+	}
+	return list, nil
+}
+
+// Schematic returns the schematic of the values, the extensions are sorted like in the image factory UI.
+func (v *Values) Schematic() Schematic {
+	extensions := append([]string(nil), v.Extensions...)
+	sort.Strings(extensions)
+
+	return Schematic{Customization: Customization{
+		ExtraKernelArgs:  v.ExtraKernelArgs,
+		SystemExtensions: SystemExtensions{OfficialExtensions: extensions},
+	}}
+}
+
+// InstallerImage returns the installer image of the schematic built by the image factory,
+// the SecureBoot one if enabled.
+func (v *Values) InstallerImage() (string, error) {
+	if v.Version == "" {
+		return "", fmt.Errorf("schematic.version is required to build the installer image")
+	}
+	s := v.Schematic()
+	id, err := s.ID()
+	if err != nil {
+		return "", err
+	}
+	repository := installerRepository
+	if v.SecureBoot {
+		repository = secureBootInstallerRepository
+	}
+	return fmt.Sprintf("%s/%s/%s:%s", v.Factory, repository, id, v.Version), nil
+}
+
+// ID returns the ID of the schematic, the SHA256 of its YAML representation.
+func (s Schematic) ID() (string, error) {
+	data, err := yaml.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// Register uploads the schematic to the image factory, which builds images only for known
+// schematics. Registering a schematic again is a no-op.
+func (v *Values) Register(ctx context.Context) error {
+	s := v.Schematic()
+	data, err := yaml.Marshal(s)
+	if err != nil {
+		return err
+	}
+	id, err := s.ID()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://"+v.Factory+"/schematics", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("image factory %s returned %s: %s", v.Factory, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var registered struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(body, &registered); err != nil {
+		return fmt.Errorf("invalid response of image factory %s: %w", v.Factory, err)
+	}
+	if registered.ID != id {
+		return fmt.Errorf("image factory %s registered schematic %s instead of %s", v.Factory, registered.ID, id)
+	}
+	return nil
+}
+
+// ParseInstallerImage splits an installer image of the image factory, SecureBoot or not, into the
+// factory, the schematic ID and the version, ok is false for other images.
+func ParseInstallerImage(image string) (factory, id, version string, ok bool) {
+	tag := strings.LastIndex(image, ":")
+	if tag < strings.LastIndex(image, "/") {
+		return "", "", "", false
+	}
+	repository, version := image[:tag], image[tag+1:]
+	factory, id, found := strings.Cut(repository, "/"+installerRepository+"/")
+	if !found {
+		factory, id, found = strings.Cut(repository, "/"+secureBootInstallerRepository+"/")
+	}
+	if !found || len(id) != sha256.Size*2 || strings.Contains(id, "/") {
+		return "", "", "", false
+	}
+	if _, err := hex.DecodeString(id); err != nil {
+		return "", "", "", false
+	}
+	return factory, id, version, true
+}
+
+// SecureBootImage reports whether the installer image installs a SecureBoot UKI: the SecureBoot
+// images of the image factory and of imager builds, e.g. registry.local/installer-secureboot:v1.7.1.
+func SecureBootImage(image string) bool {
+	image, _, _ = strings.Cut(image, "@")
+	if tag := strings.LastIndex(image, ":"); tag > strings.LastIndex(image, "/") {
+		image = image[:tag]
+	}
+	for _, segment := range strings.Split(image, "/") {
+		if segment == secureBootInstallerRepository {
+			return true
+		}
+	}
+	return false
+}
+
+// Installed is the schematic running on a node.
+type Installed struct {
+	// ID is the version of the schematic extension, empty for images not built by the factory
+	ID string
+	// Extensions are the names of the installed extensions, without the schematic one
+	Extensions []string
+	// KernelArgs are the args of the running kernel
+	KernelArgs []string
+}
+
+// Drift is the difference between the desired schematic and the installed one.
+type Drift struct {
+	DesiredID         string
+	InstalledID       string
+	MissingExtensions []string
+	ExtraExtensions   []string
+	MissingKernelArgs []string
+}
+
+// Empty reports whether the node runs the desired schematic.
+func (d Drift) Empty() bool {
+	return d.DesiredID == d.InstalledID && len(d.MissingExtensions) == 0 &&
+		len(d.ExtraExtensions) == 0 && len(d.MissingKernelArgs) == 0
+}
+
+// String describes the drift in one line.
+func (d Drift) String() string {
+	if d.Empty() {
+		return "no extension drift"
+	}
+
+	var parts []string
+	if d.DesiredID != d.InstalledID {
+		installed := d.InstalledID
+		if installed == "" {
+			installed = "none"
+		}
+		parts = append(parts, fmt.Sprintf("schematic %s, desired %s", installed, d.DesiredID))
+	}
+	if len(d.MissingExtensions) > 0 {
+		parts = append(parts, "missing extensions: "+strings.Join(d.MissingExtensions, ", "))
+	}
+	if len(d.ExtraExtensions) > 0 {
+		parts = append(parts, "unexpected extensions: "+strings.Join(d.ExtraExtensions, ", "))
+	}
+	if len(d.MissingKernelArgs) > 0 {
+		parts = append(parts, "missing kernel args: "+strings.Join(d.MissingKernelArgs, " "))
+	}
+	return "extension drift: " + strings.Join(parts, "; ")
+}
+
+// Diff compares the desired schematic with the one installed on a node. Installed extensions
+// are named without the siderolabs/ prefix of the official extensions.
+func (v *Values) Diff(installed Installed) (Drift, error) {
+	s := v.Schematic()
+	id, err := s.ID()
+	if err != nil {
+		return Drift{}, err
+	}
+	drift := Drift{DesiredID: id, InstalledID: installed.ID}
+
+	desired := map[string]bool{}
+	for _, extension := range v.Extensions {
+		name := extension[strings.LastIndex(extension, "/")+1:]
+		desired[name] = true
+		if !contains(installed.Extensions, name) {
+			drift.MissingExtensions = append(drift.MissingExtensions, extension)
+		}
+	}
+	for _, name := range installed.Extensions {
+		if !desired[name] {
+			drift.ExtraExtensions = append(drift.ExtraExtensions, name)
+		}
+	}
+	for _, arg := range v.ExtraKernelArgs {
+		if !contains(installed.KernelArgs, arg) {
+			drift.MissingKernelArgs = append(drift.MissingKernelArgs, arg)
+		}
+	}
+
+	sort.Strings(drift.MissingExtensions)
+	sort.Strings(drift.ExtraExtensions)
+	return drift, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
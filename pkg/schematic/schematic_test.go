@@ -0,0 +1,178 @@
+package schematic
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestID(t *testing.T) {
+	tests := []struct {
+		name       string
+		extensions []string
+		want       string
+	}{
+		{
+			name: "vanilla",
+			want: "376567988ad370138ad8b2698212367b8edcb69b5fd68c80be1f2ec7d603b4ba",
+		},
+		{
+			name:       "single extension",
+			extensions: []string{"siderolabs/qemu-guest-agent"},
+			want:       "ce4c980550dd2ab1b17bbf2b08801c7eb59418eafe8f279833297925d67c7515",
+		},
+		{
+			name:       "extensions are sorted",
+			extensions: []string{"siderolabs/util-linux-tools", "siderolabs/iscsi-tools"},
+			want:       "613e1592b2da41ae5e265e8789429f22e121aab91cb4deb6bc3c0b6262961245",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &Values{Extensions: tt.extensions}
+			got, err := v.Schematic().ID()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("ID() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFromValues(t *testing.T) {
+	v, err := FromValues(map[string]interface{}{
+		"schematic": map[string]interface{}{
+			"version":         "v1.7.1",
+			"extensions":      []interface{}{"siderolabs/qemu-guest-agent"},
+			"extraKernelArgs": []interface{}{"net.ifnames=0"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	image, err := v.InstallerImage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	factory, id, version, ok := ParseInstallerImage(image)
+	if !ok || factory != DefaultFactory || version != "v1.7.1" || len(id) != 64 {
+		t.Errorf("unexpected installer image %s", image)
+	}
+
+	for _, values := range []map[string]interface{}{
+		{},
+		{"schematic": map[string]interface{}{"version": "v1.7.1"}},
+	} {
+		if v, err := FromValues(values); err != nil || v != nil {
+			t.Errorf("FromValues(%v) = %v, %v, want no schematic", values, v, err)
+		}
+	}
+
+	if _, err := FromValues(map[string]interface{}{"schematic": map[string]interface{}{"extensions": "siderolabs/zfs"}}); err == nil {
+		t.Error("expected an error for extensions which are not a list")
+	}
+	if _, err := (&Values{Extensions: []string{"siderolabs/zfs"}}).InstallerImage(); err == nil {
+		t.Error("expected an error without version")
+	}
+}
+
+func TestParseInstallerImage(t *testing.T) {
+	id := "376567988ad370138ad8b2698212367b8edcb69b5fd68c80be1f2ec7d603b4ba"
+	for image, ok := range map[string]bool{
+		"factory.talos.dev/installer/" + id + ":v1.7.1":            true,
+		"registry.local:5000/installer/" + id + ":v1.7.1":          true,
+		"factory.talos.dev/installer-secureboot/" + id + ":v1.7.1": true,
+		"ghcr.io/siderolabs/installer:v1.7.1":                      false,
+		"factory.talos.dev/installer/" + id:                        false,
+		"factory.talos.dev/installer/not-a-schematic:v1.7.1":       false,
+	} {
+		if _, _, _, got := ParseInstallerImage(image); got != ok {
+			t.Errorf("ParseInstallerImage(%s) = %v, want %v", image, got, ok)
+		}
+	}
+}
+
+func TestSecureBoot(t *testing.T) {
+	v, err := FromValues(map[string]interface{}{
+		"secureBoot": map[string]interface{}{"enabled": true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v == nil || !v.SecureBoot || v.Factory != DefaultFactory {
+		t.Fatalf("FromValues() = %+v, want a SecureBoot schematic", v)
+	}
+
+	v.Version = "v1.7.1"
+	image, err := v.InstallerImage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "factory.talos.dev/installer-secureboot/376567988ad370138ad8b2698212367b8edcb69b5fd68c80be1f2ec7d603b4ba:v1.7.1"
+	if image != want {
+		t.Errorf("InstallerImage() = %s, want %s", image, want)
+	}
+
+	for image, secureBoot := range map[string]bool{
+		want: true,
+		"registry.local:5000/installer-secureboot:v1.7.1":                                                     true,
+		"registry.local/installer-secureboot@sha256:0123":                                                     true,
+		"factory.talos.dev/installer/376567988ad370138ad8b2698212367b8edcb69b5fd68c80be1f2ec7d603b4ba:v1.7.1": false,
+		"ghcr.io/siderolabs/installer:v1.7.1":                                                                 false,
+		"registry.local/installer-secureboot-custom:v1.7.1":                                                   false,
+		"registry.local:5000/installer:installer-secureboot":                                                  false,
+	} {
+		if got := SecureBootImage(image); got != secureBoot {
+			t.Errorf("SecureBootImage(%s) = %v, want %v", image, got, secureBoot)
+		}
+	}
+
+	if v, err := FromValues(map[string]interface{}{"secureBoot": map[string]interface{}{"enabled": false}}); err != nil || v != nil {
+		t.Errorf("FromValues() = %v, %v, want no schematic without SecureBoot", v, err)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	v := &Values{
+		Extensions:      []string{"siderolabs/iscsi-tools", "siderolabs/util-linux-tools"},
+		ExtraKernelArgs: []string{"net.ifnames=0"},
+	}
+	s := v.Schematic()
+	id, err := s.ID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	drift, err := v.Diff(Installed{
+		ID:         id,
+		Extensions: []string{"iscsi-tools", "util-linux-tools"},
+		KernelArgs: []string{"talos.platform=metal", "net.ifnames=0"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !drift.Empty() {
+		t.Errorf("expected no drift, got %s", drift)
+	}
+
+	drift, err = v.Diff(Installed{
+		ID:         "376567988ad370138ad8b2698212367b8edcb69b5fd68c80be1f2ec7d603b4ba",
+		Extensions: []string{"iscsi-tools", "zfs"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Drift{
+		DesiredID:         id,
+		InstalledID:       "376567988ad370138ad8b2698212367b8edcb69b5fd68c80be1f2ec7d603b4ba",
+		MissingExtensions: []string{"siderolabs/util-linux-tools"},
+		ExtraExtensions:   []string{"zfs"},
+		MissingKernelArgs: []string{"net.ifnames=0"},
+	}
+	if !reflect.DeepEqual(drift, want) {
+		t.Errorf("Diff() = %+v, want %+v", drift, want)
+	}
+}
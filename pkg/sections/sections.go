@@ -0,0 +1,188 @@
+// Package sections applies selected sections of a rendered machine config, like machine.files
+// or machine.registries, on top of the config running on a node, leaving the rest of it as is,
+// or removes them from configs before they are compared.
+package sections
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/aenix-io/talm/pkg/yamltools"
+	"gopkg.in/yaml.v3"
+)
+
+var sectionPath = regexp.MustCompile(`^(machine|cluster)(\.[A-Za-z0-9]+)+$`)
+
+// Parse validates the sections and splits them into paths of keys, e.g. machine.registries.
+func Parse(sections []string) ([][]string, error) {
+	var paths [][]string
+	for _, section := range sections {
+		section = strings.TrimSpace(section)
+		if !sectionPath.MatchString(section) {
+			return nil, fmt.Errorf("invalid section %q, use dotted paths of the machine config like machine.files", section)
+		}
+		paths = append(paths, strings.Split(section, "."))
+	}
+	if len(paths) == 0 {
+		return nil, errors.New("no sections given")
+	}
+	return paths, nil
+}
+
+// Extract returns the patch of the rendered config holding only the sections, the sections
+// missing from the rendered config are listed as removed.
+func Extract(rendered []byte, paths [][]string) (patch []byte, removed []string, err error) {
+	docs, index, err := decode(rendered)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	for _, path := range paths {
+		value := lookup(docs[index], path)
+		if value == nil {
+			removed = append(removed, strings.Join(path, "."))
+			continue
+		}
+		set(root, path, value)
+	}
+	if len(root.Content) == 0 {
+		return nil, removed, nil
+	}
+
+	patch, err = encode([]*yaml.Node{{Kind: yaml.DocumentNode, Content: []*yaml.Node{root}}})
+	return patch, removed, err
+}
+
+// Replace returns the current config with the sections replaced by the ones of the rendered
+// config, the sections missing from the rendered config are removed. Only the v1alpha1
+// document is changed, the other documents of the current config are kept.
+func Replace(current, rendered []byte, paths [][]string) ([]byte, error) {
+	currentDocs, currentIndex, err := decode(current)
+	if err != nil {
+		return nil, fmt.Errorf("current config: %w", err)
+	}
+	renderedDocs, renderedIndex, err := decode(rendered)
+	if err != nil {
+		return nil, fmt.Errorf("rendered config: %w", err)
+	}
+
+	doc := currentDocs[currentIndex]
+	for _, path := range paths {
+		value := lookup(renderedDocs[renderedIndex], path)
+		if value == nil {
+			yamltools.DeletePath(doc, path...)
+			continue
+		}
+		set(doc.Content[0], path, value)
+	}
+
+	return encode(currentDocs)
+}
+
+// Remove returns the config without the sections, the other documents of the config are kept.
+func Remove(config []byte, paths [][]string) ([]byte, error) {
+	docs, index, err := decode(config)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		yamltools.DeletePath(docs[index], path...)
+	}
+	return encode(docs)
+}
+
+// decode reads the documents of the config and returns the index of the v1alpha1 document.
+func decode(data []byte) ([]*yaml.Node, int, error) {
+	var docs []*yaml.Node
+	index := -1
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		doc := &yaml.Node{}
+		err := decoder.Decode(doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(doc.Content) == 0 {
+			continue
+		}
+		if version := lookup(doc, []string{"version"}); version != nil && version.Value == "v1alpha1" && index < 0 {
+			index = len(docs)
+		}
+		docs = append(docs, doc)
+	}
+	if index < 0 {
+		return nil, 0, errors.New("no v1alpha1 machine config document")
+	}
+	return docs, index, nil
+}
+
+func encode(docs []*yaml.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(4)
+	for _, doc := range docs {
+		if err := encoder.Encode(doc); err != nil {
+			return nil, err
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// lookup returns the value at the path of keys in the mapping document, or nil.
+func lookup(node *yaml.Node, path []string) *yaml.Node {
+	if node.Kind == yaml.DocumentNode {
+		node = node.Content[0]
+	}
+	for _, key := range path {
+		if node.Kind != yaml.MappingNode {
+			return nil
+		}
+		var child *yaml.Node
+		for j := 0; j+1 < len(node.Content); j += 2 {
+			if node.Content[j].Value == key {
+				child = node.Content[j+1]
+				break
+			}
+		}
+		if child == nil {
+			return nil
+		}
+		node = child
+	}
+	return node
+}
+
+// set sets the value at the path of keys in the mapping, creating the missing mappings on the way.
+func set(node *yaml.Node, path []string, value *yaml.Node) {
+	for i, key := range path {
+		j := 0
+		for ; j+1 < len(node.Content); j += 2 {
+			if node.Content[j].Value == key {
+				break
+			}
+		}
+		if j+1 >= len(node.Content) {
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, &yaml.Node{Kind: yaml.MappingNode})
+		}
+
+		if i == len(path)-1 {
+			node.Content[j+1] = value
+			return
+		}
+		if node.Content[j+1].Kind != yaml.MappingNode {
+			node.Content[j+1] = &yaml.Node{Kind: yaml.MappingNode}
+		}
+		node = node.Content[j+1]
+	}
+}
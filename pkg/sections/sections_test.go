@@ -0,0 +1,138 @@
+package sections
+
+import (
+	"strings"
+	"testing"
+)
+
+const current = `version: v1alpha1
+machine:
+    type: worker
+    files:
+        - path: /var/etc/old
+          content: old
+          op: create
+    registries:
+        mirrors:
+            docker.io:
+                endpoints:
+                    - https://old.example.com
+    network:
+        hostname: node1
+cluster:
+    clusterName: test
+---
+apiVersion: v1alpha1
+kind: ExtensionServiceConfig
+name: nut-client
+`
+
+const rendered = `version: v1alpha1
+machine:
+    type: worker
+    # registry mirrors
+    registries:
+        mirrors:
+            docker.io:
+                endpoints:
+                    - https://mirror.example.com
+    kubelet:
+        extraArgs:
+            rotate-server-certificates: "true"
+    network:
+        hostname: node1-renamed
+cluster:
+    clusterName: test
+`
+
+func TestParse(t *testing.T) {
+	paths, err := Parse([]string{"machine.registries", " machine.files "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 2 || strings.Join(paths[1], "/") != "machine/files" {
+		t.Errorf("Parse() = %v", paths)
+	}
+
+	for _, sections := range [][]string{nil, {"machine"}, {"version"}, {"machine..files"}, {"machine.files[0]"}} {
+		if _, err := Parse(sections); err == nil {
+			t.Errorf("expected an error for sections %v", sections)
+		}
+	}
+}
+
+func TestExtract(t *testing.T) {
+	paths, _ := Parse([]string{"machine.registries", "machine.files", "machine.kubelet.extraArgs"})
+	patch, removed, err := Extract([]byte(rendered), paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `machine:
+    registries:
+        mirrors:
+            docker.io:
+                endpoints:
+                    - https://mirror.example.com
+    kubelet:
+        extraArgs:
+            rotate-server-certificates: "true"
+`
+	if string(patch) != expected {
+		t.Errorf("Extract() = \n%s\nwant\n%s", patch, expected)
+	}
+	if strings.Join(removed, ",") != "machine.files" {
+		t.Errorf("removed = %v, want machine.files", removed)
+	}
+}
+
+func TestReplace(t *testing.T) {
+	paths, _ := Parse([]string{"machine.registries", "machine.files", "machine.kubelet.extraArgs"})
+	result, err := Replace([]byte(current), []byte(rendered), paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := string(result)
+	for _, expected := range []string{
+		"https://mirror.example.com",
+		"kubelet:\n        extraArgs:\n            rotate-server-certificates: \"true\"\n",
+		// The sections which are not selected are kept
+		"hostname: node1\n",
+		"kind: ExtensionServiceConfig\n",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in result:\n%s", expected, out)
+		}
+	}
+	for _, unexpected := range []string{"old.example.com", "/var/etc/old", "node1-renamed"} {
+		if strings.Contains(out, unexpected) {
+			t.Errorf("unexpected %q in result:\n%s", unexpected, out)
+		}
+	}
+
+	if _, err := Replace([]byte("kind: ExtensionServiceConfig\n"), []byte(rendered), paths); err == nil {
+		t.Error("expected an error without v1alpha1 document")
+	}
+}
+
+func TestRemove(t *testing.T) {
+	paths, _ := Parse([]string{"machine.files", "machine.registries.mirrors", "machine.kubelet"})
+	result, err := Remove([]byte(current), paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := string(result)
+	for _, expected := range []string{"hostname: node1\n", "clusterName: test\n", "kind: ExtensionServiceConfig\n"} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in result:\n%s", expected, out)
+		}
+	}
+	// The mappings left empty are removed with the sections
+	for _, unexpected := range []string{"files:", "registries:", "old.example.com"} {
+		if strings.Contains(out, unexpected) {
+			t.Errorf("unexpected %q in result:\n%s", unexpected, out)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package state
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// syncedName is the file of the state directory recording the archive last pulled into or
+// pushed from it, it is not archived.
+const syncedName = ".synced"
+
+// ErrModified is returned when the state directory was changed since it was last pulled or
+// pushed, and the stored state was replaced in the meantime too.
+var ErrModified = errors.New("the local state was changed since it was last stored, and another run stored a state in the meantime")
+
+// Archive returns the files of the directory as a gzipped tarball. The archive of the same
+// files is the same, the modification times are left out, so an unchanged state is not stored again.
+func Archive(dir string) ([]byte, error) {
+	data, _, err := archive(dir)
+	return data, err
+}
+
+// archive returns the archive of the directory and the number of files in it.
+func archive(dir string) ([]byte, int, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	files := 0
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if name == syncedName {
+			return nil
+		}
+		files++
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		header := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     filepath.ToSlash(name),
+			Mode:     int64(info.Mode().Perm()),
+			Size:     int64(len(data)),
+			ModTime:  time.Unix(0, 0),
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err = tw.Write(data)
+		return err
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, 0, err
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, 0, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, 0, err
+	}
+	return buf.Bytes(), files, nil
+}
+
+// Pull replaces the files of the directory with the files of the archive, unless they were
+// changed since they were last pulled or pushed. If the archive is still the one they were
+// pulled from, e.g. the changes failed to be pushed, the changed files are kept to be pushed
+// again, otherwise ErrModified is returned. A directory never pulled is changed if it has files.
+func Pull(data []byte, dir string) error {
+	local, files, err := archive(dir)
+	if err != nil {
+		return err
+	}
+	synced, ok := readSynced(dir)
+	modified := ok && synced.files != hash(local) || !ok && files > 0
+
+	switch {
+	case !modified:
+		return Extract(data, dir)
+	case ok && synced.archive == hash(data):
+		return nil
+	default:
+		return fmt.Errorf("%w: move %s away to use the stored state", ErrModified, dir)
+	}
+}
+
+// MarkSynced records the archive of the directory as stored.
+func MarkSynced(data []byte, dir string) error {
+	return writeSynced(dir, hash(data), hash(data))
+}
+
+// synced are the hashes of the archive of the files and of the archive stored when they were
+// last pulled or pushed. They differ if the stored archive was written by another talm version.
+type synced struct {
+	files, archive string
+}
+
+func readSynced(dir string) (synced, bool) {
+	data, err := os.ReadFile(filepath.Join(dir, syncedName))
+	if err != nil {
+		return synced{}, false
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 {
+		return synced{}, false
+	}
+	return synced{files: fields[0], archive: fields[1]}, true
+}
+
+func writeSynced(dir, files, archive string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, syncedName), []byte(files+" "+archive+"\n"), 0o600)
+}
+
+// Extract replaces the files of the directory with the files of the archive. The archive is
+// extracted next to the directory first, which is only replaced once the whole archive was read.
+func Extract(data []byte, dir string) error {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("invalid state archive: %w", err)
+	}
+	tr := tar.NewReader(gz)
+
+	parent, base := filepath.Split(filepath.Clean(dir))
+	if parent == "" {
+		parent = "."
+	}
+	if err := os.MkdirAll(parent, 0o755); err != nil {
+		return err
+	}
+	staging, err := os.MkdirTemp(parent, "."+base+"-pull-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging) //nolint:errcheck
+
+	if err := extract(tr, staging); err != nil {
+		return err
+	}
+	// The gzip checksum is verified at its end
+	if _, err := io.Copy(io.Discard, gz); err != nil {
+		return fmt.Errorf("invalid state archive: %w", err)
+	}
+	files, err := Archive(staging)
+	if err != nil {
+		return err
+	}
+	if err := writeSynced(staging, hash(files), hash(data)); err != nil {
+		return err
+	}
+
+	// The directory is moved away before the extracted one takes its place, and back on failure
+	previous := staging + "-previous"
+	if err := os.Rename(dir, previous); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := os.Rename(staging, dir); err != nil {
+		if restoreErr := os.Rename(previous, dir); restoreErr != nil && !errors.Is(restoreErr, fs.ErrNotExist) {
+			return fmt.Errorf("%w, the previous state is kept in %s", err, previous)
+		}
+		return err
+	}
+	return os.RemoveAll(previous)
+}
+
+func extract(tr *tar.Reader, dir string) error {
+	if err := os.Chmod(dir, 0o755); err != nil {
+		return err
+	}
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid state archive: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := filepath.FromSlash(header.Name)
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("invalid state archive: file %q outside the state directory", header.Name)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, fs.FileMode(header.Mode).Perm())
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(file, tr)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"golang.org/x/oauth2/google"
+)
+
+// GCS stores the archive in a Google Cloud Storage bucket, the version is the generation of the
+// archive. The credentials are the application default credentials, STORAGE_EMULATOR_HOST selects
+// an emulator.
+type GCS struct {
+	Bucket   string
+	Key      string
+	Endpoint string
+	Client   *http.Client
+}
+
+func newGCS(ctx context.Context, bucket, key string) (*GCS, error) {
+	if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+		return &GCS{Bucket: bucket, Key: key, Endpoint: "http://" + host, Client: http.DefaultClient}, nil
+	}
+	client, err := google.DefaultClient(ctx, "https://www.googleapis.com/auth/devstorage.read_write")
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the Google Cloud credentials: %w", err)
+	}
+	return &GCS{Bucket: bucket, Key: key, Endpoint: "https://storage.googleapis.com", Client: client}, nil
+}
+
+func (g *GCS) Get(ctx context.Context) ([]byte, string, error) {
+	u := fmt.Sprintf("%s/storage/v1/b/%s/o/%s?alt=media", g.Endpoint, url.PathEscape(g.Bucket), url.PathEscape(g.Key))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	return get(g.Client, req, "X-Goog-Generation")
+}
+
+func (g *GCS) Put(ctx context.Context, data []byte, version string) error {
+	// Generation 0 matches only a missing object
+	if version == "" {
+		version = "0"
+	}
+	query := url.Values{"uploadType": {"media"}, "name": {g.Key}, "ifGenerationMatch": {version}}
+	u := fmt.Sprintf("%s/upload/storage/v1/b/%s/o?%s", g.Endpoint, url.PathEscape(g.Bucket), query.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	return put(g.Client, req)
+}
@@ -0,0 +1,112 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/config"
+)
+
+// S3 stores the archive in an S3 bucket, the version is the ETag of the archive. The credentials
+// and the region are resolved like the AWS CLI does, AWS_ENDPOINT_URL_S3 or AWS_ENDPOINT_URL
+// select an S3 compatible storage, like MinIO, addressed with path-style URLs.
+type S3 struct {
+	Bucket      string
+	Key         string
+	Region      string
+	Endpoint    string
+	Credentials aws.CredentialsProvider
+	Client      *http.Client
+}
+
+func newS3(ctx context.Context, bucket, key string) (*S3, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the AWS config: %w", err)
+	}
+	region := cfg.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_S3")
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+	return &S3{
+		Bucket:      bucket,
+		Key:         key,
+		Region:      region,
+		Endpoint:    endpoint,
+		Credentials: cfg.Credentials,
+		Client:      http.DefaultClient,
+	}, nil
+}
+
+func (s *S3) url() (string, error) {
+	if s.Endpoint == "" {
+		return (&url.URL{Scheme: "https", Host: fmt.Sprintf("%s.s3.%s.amazonaws.com", s.Bucket, s.Region), Path: "/" + s.Key}).String(), nil
+	}
+	u, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid S3 endpoint %q: %w", s.Endpoint, err)
+	}
+	u.Path = u.Path + "/" + s.Bucket + "/" + s.Key
+	return u.String(), nil
+}
+
+func (s *S3) request(ctx context.Context, method string, data []byte) (*http.Request, error) {
+	u, err := s.url()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// sign signs the request with the credentials, after the headers are set.
+func (s *S3) sign(ctx context.Context, req *http.Request, data []byte) error {
+	credentials, err := s.Credentials.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve the AWS credentials: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	payloadHash := hex.EncodeToString(sum[:])
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	return v4.NewSigner().SignHTTP(ctx, credentials, req, payloadHash, "s3", s.Region, time.Now())
+}
+
+func (s *S3) Get(ctx context.Context) ([]byte, string, error) {
+	req, err := s.request(ctx, http.MethodGet, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := s.sign(ctx, req, nil); err != nil {
+		return nil, "", err
+	}
+	return get(s.Client, req, "ETag")
+}
+
+func (s *S3) Put(ctx context.Context, data []byte, version string) error {
+	req, err := s.request(ctx, http.MethodPut, data)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	conditional(req, version)
+	if err := s.sign(ctx, req, data); err != nil {
+		return err
+	}
+	return put(s.Client, req)
+}
@@ -0,0 +1,188 @@
+// Package state stores the state directory of a project, the applied configs, the known nodes
+// and the releases, in a shared backend, so CI runners without a checkout of the state and
+// the members of a team work with the same state. The state is stored as a single archive,
+// which is only replaced if nobody replaced it since it was read. The backends store the
+// archive as they get it, the commands encrypt it since the known nodes can hold BMC passwords.
+package state
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ArchiveName is the name of the encrypted archive of the state in the backends storing objects.
+const ArchiveName = "state.tar.gz.age"
+
+var (
+	// ErrNotFound is returned when the backend stores no state yet.
+	ErrNotFound = errors.New("state not found")
+	// ErrConflict is returned when the state was replaced since it was read.
+	ErrConflict = errors.New("the state was changed by another run since it was read, run talm again")
+)
+
+// Backend stores the archive of the state.
+type Backend interface {
+	// Get returns the archive and its version, or ErrNotFound.
+	Get(ctx context.Context) ([]byte, string, error)
+	// Put stores the archive if the stored one is still at the version, an empty version
+	// if there was none, or returns ErrConflict.
+	Put(ctx context.Context, data []byte, version string) error
+}
+
+// Open returns the backend of the URL: s3://bucket/prefix, gs://bucket/prefix, an http(s) URL
+// or file:///directory. The archive is stored as state.tar.gz.age under the URL.
+func Open(ctx context.Context, rawURL string) (Backend, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid state backend %q: %w", rawURL, err)
+	}
+	key := strings.Trim(u.Path, "/")
+	if key == "" {
+		key = ArchiveName
+	} else {
+		key += "/" + ArchiveName
+	}
+
+	switch u.Scheme {
+	case "s3":
+		return newS3(ctx, u.Host, key)
+	case "gs":
+		return newGCS(ctx, u.Host, key)
+	case "http", "https":
+		return HTTP{URL: strings.TrimSuffix(rawURL, "/") + "/" + ArchiveName, Client: http.DefaultClient}, nil
+	case "file":
+		return File{Path: filepath.Join(filepath.FromSlash(u.Host+u.Path), ArchiveName)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported state backend %q, use s3://, gs://, http(s):// or file://", rawURL)
+	}
+}
+
+// File stores the archive in a file, e.g. on a shared file system. The version is the hash of the archive.
+type File struct {
+	Path string
+}
+
+func (f File) Get(ctx context.Context) ([]byte, string, error) {
+	data, err := os.ReadFile(f.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, "", ErrNotFound
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return data, hash(data), nil
+}
+
+func (f File) Put(ctx context.Context, data []byte, version string) error {
+	current, err := os.ReadFile(f.Path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		if version != "" {
+			return ErrConflict
+		}
+	case err != nil:
+		return err
+	case hash(current) != version:
+		return ErrConflict
+	}
+
+	if err := os.MkdirAll(filepath.Dir(f.Path), 0o755); err != nil {
+		return err
+	}
+	tmp := f.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.Path)
+}
+
+func hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// HTTP stores the archive at the URL with GET and PUT requests, like a WebDAV server does, the version is the ETag of the
+// archive. Credentials in the URL are sent with basic authentication.
+type HTTP struct {
+	URL    string
+	Client *http.Client
+}
+
+func (h HTTP) Get(ctx context.Context) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.URL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	return get(h.Client, req, "ETag")
+}
+
+func (h HTTP) Put(ctx context.Context, data []byte, version string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, h.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	conditional(req, version)
+	return put(h.Client, req)
+}
+
+// conditional makes the request replace only the archive at the version.
+func conditional(req *http.Request, version string) {
+	if version == "" {
+		req.Header.Set("If-None-Match", "*")
+	} else {
+		req.Header.Set("If-Match", version)
+	}
+}
+
+// get returns the body of the response and the version in the header.
+func get(client *http.Client, req *http.Request, versionHeader string) ([]byte, string, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, "", ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", responseError(req, resp)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, resp.Header.Get(versionHeader), nil
+}
+
+func put(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	switch {
+	case resp.StatusCode == http.StatusPreconditionFailed, resp.StatusCode == http.StatusConflict:
+		return ErrConflict
+	case resp.StatusCode/100 != 2:
+		return responseError(req, resp)
+	}
+	return nil
+}
+
+func responseError(req *http.Request, resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Redacted(), resp.Status, strings.TrimSpace(string(body)))
+}
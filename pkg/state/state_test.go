@@ -0,0 +1,313 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestArchive(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "releases"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"applied.json":    `{"10.0.0.1":{}}`,
+		"releases/1.json": `{"number":1}`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := Archive(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := Archive(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, again) {
+		t.Error("expected the same archive of the same files")
+	}
+
+	target := filepath.Join(t.TempDir(), "state")
+	if err := os.MkdirAll(target, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(target, "stale.json"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := Extract(data, target); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		data, err := os.ReadFile(filepath.Join(target, name))
+		if err != nil || string(data) != content {
+			t.Errorf("unexpected %s: %q, %v", name, data, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(target, "stale.json")); !os.IsNotExist(err) {
+		t.Error("expected the files missing in the archive to be removed")
+	}
+
+	// A missing directory is an empty state
+	if _, err := Archive(filepath.Join(dir, "missing")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExtractCorrupted(t *testing.T) {
+	src := t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "applied.json"), bytes.Repeat([]byte(`{"10.0.0.1":{}}`), 1000), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	data, err := Archive(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := filepath.Join(t.TempDir(), "state")
+	if err := os.MkdirAll(target, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(target, "nodes.yaml"), []byte("local"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	corrupted := append([]byte(nil), data...)
+	corrupted[len(corrupted)-5] ^= 1
+	for name, archive := range map[string][]byte{"truncated": data[:len(data)/2], "corrupted": corrupted, "garbage": []byte("state")} {
+		if err := Extract(archive, target); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+		if data, err := os.ReadFile(filepath.Join(target, "nodes.yaml")); err != nil || string(data) != "local" {
+			t.Errorf("%s: expected the local state to be kept, got %q, %v", name, data, err)
+		}
+	}
+	entries, err := os.ReadDir(filepath.Dir(target))
+	if err != nil || len(entries) != 1 {
+		t.Errorf("expected the extracted files to be removed, got %v, %v", entries, err)
+	}
+}
+
+func TestPull(t *testing.T) {
+	archiveOf := func(files map[string]string) []byte {
+		dir := t.TempDir()
+		for name, content := range files {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		data, err := Archive(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	read := func(dir, name string) string {
+		data, _ := os.ReadFile(filepath.Join(dir, name))
+		return string(data)
+	}
+	first := archiveOf(map[string]string{"applied.json": "first"})
+	second := archiveOf(map[string]string{"applied.json": "second"})
+
+	dir := filepath.Join(t.TempDir(), ".talm")
+	if err := Pull(first, dir); err != nil {
+		t.Fatal(err)
+	}
+	if read(dir, "applied.json") != "first" {
+		t.Fatalf("unexpected state %q", read(dir, "applied.json"))
+	}
+	if data, err := Archive(dir); err != nil || !bytes.Equal(data, first) {
+		t.Errorf("expected the archive not to contain the synced marker, %v", err)
+	}
+
+	// Unchanged files are replaced
+	if err := Pull(second, dir); err != nil || read(dir, "applied.json") != "second" {
+		t.Fatalf("expected the state to be replaced, got %q, %v", read(dir, "applied.json"), err)
+	}
+
+	// Changed files are kept while the stored state is the one they were pulled from
+	if err := os.WriteFile(filepath.Join(dir, "applied.json"), []byte("local"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := Pull(second, dir); err != nil || read(dir, "applied.json") != "local" {
+		t.Errorf("expected the local changes to be kept, got %q, %v", read(dir, "applied.json"), err)
+	}
+	if err := Pull(first, dir); !errors.Is(err, ErrModified) || read(dir, "applied.json") != "local" {
+		t.Errorf("expected the local changes not to be overwritten, got %q, %v", read(dir, "applied.json"), err)
+	}
+
+	// Once pushed, the files are unchanged again
+	local, err := Archive(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := MarkSynced(local, dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := Pull(first, dir); err != nil || read(dir, "applied.json") != "first" {
+		t.Errorf("expected the state to be replaced, got %q, %v", read(dir, "applied.json"), err)
+	}
+
+	// A state which was never pulled is not overwritten
+	fresh := t.TempDir()
+	if err := os.WriteFile(filepath.Join(fresh, "nodes.yaml"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := Pull(first, fresh); !errors.Is(err, ErrModified) {
+		t.Errorf("expected the local state not to be overwritten, got %v", err)
+	}
+}
+
+// testBackend checks the conditional updates of the backend.
+func testBackend(t *testing.T, b Backend) {
+	ctx := context.Background()
+	if _, _, err := b.Get(ctx); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected no state, got %v", err)
+	}
+	if err := b.Put(ctx, []byte("first"), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Put(ctx, []byte("concurrent"), ""); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected a conflict creating the state twice, got %v", err)
+	}
+
+	data, version, err := b.Get(ctx)
+	if err != nil || string(data) != "first" {
+		t.Fatalf("unexpected state %q, %v", data, err)
+	}
+	if err := b.Put(ctx, []byte("second"), version); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Put(ctx, []byte("third"), version); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected a conflict replacing a changed state, got %v", err)
+	}
+	if data, _, _ := b.Get(ctx); string(data) != "second" {
+		t.Errorf("unexpected state %q", data)
+	}
+}
+
+func TestFile(t *testing.T) {
+	b, err := Open(context.Background(), "file://"+filepath.ToSlash(t.TempDir())+"/cluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBackend(t, b)
+}
+
+// objectServer serves an object with the ETag of its generation, it checks the conditions of PUT.
+type objectServer struct {
+	mu         sync.Mutex
+	path       string
+	data       []byte
+	generation int
+}
+
+func (s *objectServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.URL.Path != s.path {
+		http.NotFound(w, r)
+		return
+	}
+	etag := strconv.Quote(strconv.Itoa(s.generation))
+	switch r.Method {
+	case http.MethodGet:
+		if s.generation == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write(s.data) //nolint:errcheck
+	case http.MethodPut:
+		if match := r.Header.Get("If-Match"); match != "" && (s.generation == 0 || match != etag) ||
+			r.Header.Get("If-None-Match") == "*" && s.generation != 0 {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		s.data, _ = io.ReadAll(r.Body)
+		s.generation++
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	server := httptest.NewServer(&objectServer{path: "/state/cluster/state.tar.gz.age"})
+	defer server.Close()
+
+	b, err := Open(context.Background(), server.URL+"/state/cluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBackend(t, b)
+}
+
+func TestS3(t *testing.T) {
+	var authorization string
+	objects := &objectServer{path: "/talm/clusters/prod/state.tar.gz.age"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		objects.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_REGION", "eu-central-1")
+	t.Setenv("AWS_ENDPOINT_URL_S3", server.URL)
+
+	b, err := Open(context.Background(), "s3://talm/clusters/prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBackend(t, b)
+	if !strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") || !strings.Contains(authorization, "/eu-central-1/s3/") {
+		t.Errorf("unexpected authorization %q", authorization)
+	}
+}
+
+func TestGCS(t *testing.T) {
+	var generation int
+	var data []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/storage/v1/b/talm/o/prod/state.tar.gz.age":
+			if generation == 0 {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("X-Goog-Generation", strconv.Itoa(generation))
+			w.Write(data) //nolint:errcheck
+		case r.Method == http.MethodPost && r.URL.Path == "/upload/storage/v1/b/talm/o" && r.URL.Query().Get("name") == "prod/state.tar.gz.age":
+			if r.URL.Query().Get("ifGenerationMatch") != strconv.Itoa(generation) {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				return
+			}
+			data, _ = io.ReadAll(r.Body)
+			generation++
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	t.Setenv("STORAGE_EMULATOR_HOST", strings.TrimPrefix(server.URL, "http://"))
+	b, err := Open(context.Background(), "gs://talm/prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBackend(t, b)
+}
@@ -0,0 +1,83 @@
+package support
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Bundle is a zip archive of files redacted as they are added.
+type Bundle struct {
+	zip      *zip.Writer
+	redactor *Redactor
+	now      time.Time
+	errors   []string
+}
+
+// NewBundle writes the bundle to w.
+func NewBundle(w io.Writer, redactor *Redactor) *Bundle {
+	return &Bundle{zip: zip.NewWriter(w), redactor: redactor, now: time.Now()}
+}
+
+// Add adds the redacted file to the bundle, the name is redacted too as it may contain addresses.
+func (b *Bundle) Add(name string, data []byte) error {
+	f, err := b.zip.CreateHeader(&zip.FileHeader{Name: string(b.redactor.Redact([]byte(name))), Method: zip.Deflate, Modified: b.now})
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b.redactor.Redact(data))
+	return err
+}
+
+// Redacts reports whether the kind of data is redacted from the files of the bundle.
+func (b *Bundle) Redacts(kind string) bool {
+	return b.redactor.Enabled(kind)
+}
+
+// AddError records an error collecting a file, the collection goes on. Errors are written
+// to errors.txt when the bundle is closed.
+func (b *Bundle) AddError(name string, err error) {
+	b.errors = append(b.errors, fmt.Sprintf("%s: %s", b.redactor.Redact([]byte(name)), err))
+}
+
+// Errors returns the recorded errors.
+func (b *Bundle) Errors() []string {
+	return b.errors
+}
+
+// Close writes the errors and the redaction summary, and completes the archive.
+func (b *Bundle) Close() error {
+	if len(b.errors) > 0 {
+		if err := b.Add("errors.txt", []byte(strings.Join(b.errors, "\n")+"\n")); err != nil {
+			return err
+		}
+	}
+
+	summary := b.redactor.Summary()
+	kinds := make([]string, 0, len(summary))
+	for kind := range summary {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	var redacted strings.Builder
+	if len(kinds) == 0 {
+		redacted.WriteString("nothing redacted\n")
+	}
+	for _, kind := range kinds {
+		fmt.Fprintf(&redacted, "%s: %d replacement(s)\n", kind, summary[kind])
+	}
+	// The summary itself is not redacted
+	f, err := b.zip.CreateHeader(&zip.FileHeader{Name: "redacted.txt", Method: zip.Deflate, Modified: b.now})
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(f, redacted.String()); err != nil {
+		return err
+	}
+
+	return b.zip.Close()
+}
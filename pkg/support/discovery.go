@@ -0,0 +1,80 @@
+package support
+
+import (
+	"context"
+	"sync"
+
+	"github.com/aenix-io/talm/pkg/engine"
+	"gopkg.in/yaml.v3"
+
+	"github.com/siderolabs/talos/pkg/machinery/api/storage"
+)
+
+// Lookup is a resource looked up by the templates.
+type Lookup struct {
+	Kind      string                 `yaml:"kind"`
+	Namespace string                 `yaml:"namespace,omitempty"`
+	ID        string                 `yaml:"id,omitempty"`
+	Result    map[string]interface{} `yaml:"result,omitempty"`
+	Error     string                 `yaml:"error,omitempty"`
+}
+
+// Recorder is a node recording the facts discovered by the templates, to reproduce the render
+// without access to the node.
+type Recorder struct {
+	node engine.Node
+
+	mu      sync.Mutex
+	disks   []map[string]interface{}
+	lookups []Lookup
+}
+
+// NewRecorder records the facts discovered on the node.
+func NewRecorder(node engine.Node) *Recorder {
+	return &Recorder{node: node}
+}
+
+// Disks returns the disks of the node.
+func (r *Recorder) Disks(ctx context.Context) ([]*storage.Disk, error) {
+	disks, err := r.node.Disks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, d := range disks {
+		disk, err := engine.DiskToMap(d)
+		if err != nil {
+			return nil, err
+		}
+		r.disks = append(r.disks, disk)
+	}
+	return disks, nil
+}
+
+// Lookup returns the resources of the node.
+func (r *Recorder) Lookup(ctx context.Context, kind, namespace, id string) (map[string]interface{}, error) {
+	result, err := r.node.Lookup(ctx, kind, namespace, id)
+
+	lookup := Lookup{Kind: kind, Namespace: namespace, ID: id, Result: result}
+	if err != nil {
+		lookup.Error = err.Error()
+	}
+	r.mu.Lock()
+	r.lookups = append(r.lookups, lookup)
+	r.mu.Unlock()
+
+	return result, err
+}
+
+// Snapshot returns the recorded disks and lookups as YAML.
+func (r *Recorder) Snapshot() ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return yaml.Marshal(struct {
+		Disks   []map[string]interface{} `yaml:"disks"`
+		Lookups []Lookup                 `yaml:"lookups"`
+	}{r.disks, r.lookups})
+}
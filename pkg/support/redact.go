@@ -0,0 +1,179 @@
+// Package support collects the rendered configs, discovered resources, versions and logs of
+// a project into a bundle attached to bug reports, with secrets and identifying addresses
+// redacted.
+package support
+
+import (
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Kinds of redacted data.
+const (
+	Secrets   = "secrets"
+	MACs      = "macs"
+	PublicIPs = "public-ips"
+)
+
+// Kinds are the kinds of redacted data, all of them are redacted by default.
+var Kinds = []string{Secrets, MACs, PublicIPs}
+
+var (
+	macPattern  = regexp.MustCompile(`(?i)\b[0-9a-f]{2}(?::[0-9a-f]{2}){5}\b`)
+	ipv4Pattern = regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`)
+	// ipv6Pattern is loose, the candidates are parsed
+	ipv6Pattern = regexp.MustCompile(`(?i)\b[0-9a-f]{1,4}(?::[0-9a-f]{0,4}){2,7}\b`)
+	// secretLinePattern matches the values of sensitive keys in YAML, logs and environment dumps
+	secretLinePattern = regexp.MustCompile(`(?i)\b([a-z0-9_]*(?:token|secret|password|passphrase|key))(["']?[ \t]*[:=][ \t]*)("[^"]+"|'[^']+'|[^\s,}]+)`)
+	privateKeyPattern = regexp.MustCompile(`(?s)-----BEGIN [A-Z ]*PRIVATE KEY-----.*?-----END [A-Z ]*PRIVATE KEY-----`)
+)
+
+// macPseudonymPrefix is the prefix of the locally administered addresses replacing MAC addresses.
+const macPseudonymPrefix = "02:00:00:"
+
+// nonPublic are the prefixes of the addresses which don't identify a site, the replacements
+// are taken from the benchmarking and documentation ranges so they are not redacted again.
+var nonPublic = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("255.255.255.255/32"),
+	netip.MustParsePrefix("2001:db8::/32"),
+}
+
+// Redactor replaces secrets, MAC addresses and public IP addresses. The same address is always
+// replaced by the same pseudonym, so the topology stays readable across the files of a bundle.
+type Redactor struct {
+	kinds map[string]bool
+
+	mu      sync.Mutex
+	macs    map[string]string
+	ipv4s   map[string]string
+	ipv6s   map[string]string
+	changes map[string]int
+}
+
+// NewRedactor returns a redactor of the kinds of data.
+func NewRedactor(kinds []string) (*Redactor, error) {
+	r := &Redactor{
+		kinds:   map[string]bool{},
+		macs:    map[string]string{},
+		ipv4s:   map[string]string{},
+		ipv6s:   map[string]string{},
+		changes: map[string]int{},
+	}
+	for _, kind := range kinds {
+		switch kind {
+		case Secrets, MACs, PublicIPs:
+			r.kinds[kind] = true
+		default:
+			return nil, fmt.Errorf("unknown kind of redacted data %q, use %s", kind, strings.Join(Kinds, ", "))
+		}
+	}
+	return r, nil
+}
+
+// Enabled reports whether the kind of data is redacted.
+func (r *Redactor) Enabled(kind string) bool {
+	return r.kinds[kind]
+}
+
+// Redact returns the data with the enabled kinds of data replaced.
+func (r *Redactor) Redact(data []byte) []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	text := string(data)
+	if r.kinds[Secrets] {
+		text = privateKeyPattern.ReplaceAllStringFunc(text, func(string) string {
+			r.changes[Secrets]++
+			return "<redacted private key>"
+		})
+		text = secretLinePattern.ReplaceAllStringFunc(text, func(match string) string {
+			parts := secretLinePattern.FindStringSubmatch(match)
+			value := strings.Trim(parts[3], `"'`)
+			// Placeholders of configs rendered without secrets and empty values are kept
+			if value == "" || strings.HasPrefix(value, "<") || value == "null" {
+				return match
+			}
+			r.changes[Secrets]++
+			if quote := parts[3][:1]; quote == `"` || quote == "'" {
+				return parts[1] + parts[2] + quote + "<redacted>" + quote
+			}
+			return parts[1] + parts[2] + "<redacted>"
+		})
+	}
+	if r.kinds[MACs] {
+		text = macPattern.ReplaceAllStringFunc(text, func(mac string) string {
+			if strings.HasPrefix(mac, macPseudonymPrefix) {
+				return mac
+			}
+			r.changes[MACs]++
+			return pseudonym(r.macs, strings.ToLower(mac), func(n int) string {
+				return fmt.Sprintf(macPseudonymPrefix+"%02x:%02x:%02x", n>>16&0xff, n>>8&0xff, n&0xff)
+			})
+		})
+	}
+	if r.kinds[PublicIPs] {
+		text = ipv4Pattern.ReplaceAllStringFunc(text, func(candidate string) string {
+			addr, err := netip.ParseAddr(candidate)
+			if err != nil || !public(addr) {
+				return candidate
+			}
+			r.changes[PublicIPs]++
+			return pseudonym(r.ipv4s, addr.String(), func(n int) string {
+				return netip.AddrFrom4([4]byte{198, 18 + byte(n>>16&1), byte(n >> 8), byte(n)}).String()
+			})
+		})
+		text = ipv6Pattern.ReplaceAllStringFunc(text, func(candidate string) string {
+			addr, err := netip.ParseAddr(candidate)
+			if err != nil || !addr.Is6() || !public(addr) {
+				return candidate
+			}
+			r.changes[PublicIPs]++
+			return pseudonym(r.ipv6s, addr.String(), func(n int) string {
+				return fmt.Sprintf("2001:db8::%x", n)
+			})
+		})
+	}
+	return []byte(text)
+}
+
+// Summary returns the number of replacements by kind of data.
+func (r *Redactor) Summary() map[string]int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	summary := map[string]int{}
+	for kind := range r.kinds {
+		summary[kind] = r.changes[kind]
+	}
+	return summary
+}
+
+func pseudonym(known map[string]string, value string, format func(n int) string) string {
+	if replacement, ok := known[value]; ok {
+		return replacement
+	}
+	replacement := format(len(known) + 1)
+	known[value] = replacement
+	return replacement
+}
+
+func public(addr netip.Addr) bool {
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range nonPublic {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
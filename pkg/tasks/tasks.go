@@ -0,0 +1,239 @@
+// Package tasks runs named pipelines of talm commands and external commands with parameters,
+// e.g. to render, diff, apply and check a node in one go instead of Makefile glue.
+//
+// Tasks are configured in Chart.yaml:
+//
+//	tasks:
+//	  rollout:
+//	    description: Render, diff, apply and check a node
+//	    params:
+//	      file:           # required
+//	      mode: auto
+//	    steps:
+//	    - name: render
+//	      talm: [template, -f, "{{ .file }}", -I]
+//	    - name: diff
+//	      talm: [ci, diff, -f, "{{ .file }}"]
+//	      onFailure: continue
+//	    - name: apply
+//	      talm: [apply, -f, "{{ .file }}", --mode, "{{ .mode }}"]
+//	    - name: notify
+//	      command: [sh, -c, "echo $TALM_PARAM_FILE applied"]
+//	      timeout: 1m
+//
+// The arguments of the steps are Go templates of the parameters. Parameters without a default
+// are required. External commands also get the parameters in TALM_PARAM_<NAME> environment
+// variables. A failing step aborts the task unless its onFailure is continue.
+package tasks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Failure policies of the steps.
+const (
+	Abort    = "abort"
+	Continue = "continue"
+)
+
+// Config is the tasks section of Chart.yaml, by task name.
+type Config map[string]Task
+
+// Task is a named pipeline of steps.
+type Task struct {
+	Description string `yaml:"description"`
+	// Params are the parameters of the task with their defaults, null for required parameters
+	Params map[string]*string `yaml:"params"`
+	Steps  []Step             `yaml:"steps"`
+}
+
+// Step runs a talm command or an external command.
+type Step struct {
+	Name string `yaml:"name"`
+	// Talm are the arguments of a talm command, e.g. [apply, -f, nodes/node1.yaml]
+	Talm []string `yaml:"talm"`
+	// Command is an external command with its arguments
+	Command []string `yaml:"command"`
+	// Timeout limits the step, no limit by default
+	Timeout string `yaml:"timeout"`
+	// OnFailure is abort (default) or continue.
+	OnFailure string `yaml:"onFailure"`
+}
+
+// StepError is returned when a step with the abort policy fails, it wraps the error of the
+// command, e.g. an *exec.ExitError with the exit code.
+type StepError struct {
+	Task string
+	Step string
+	Err  error
+}
+
+func (e *StepError) Error() string {
+	return fmt.Sprintf("task %s: step %s failed: %s", e.Task, e.Step, e.Err)
+}
+
+func (e *StepError) Unwrap() error {
+	return e.Err
+}
+
+var paramName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Check validates the config of the task without running it.
+func (t Task) Check(name string) error {
+	for param := range t.Params {
+		if !paramName.MatchString(param) {
+			return fmt.Errorf("task %s: invalid parameter name %q", name, param)
+		}
+	}
+	if len(t.Steps) == 0 {
+		return fmt.Errorf("task %s has no steps", name)
+	}
+	for i, step := range t.Steps {
+		if step.Name == "" {
+			return fmt.Errorf("task %s: step %d has no name", name, i+1)
+		}
+		if (len(step.Talm) == 0) == (len(step.Command) == 0) {
+			return fmt.Errorf("task %s: step %s must set either talm or command", name, step.Name)
+		}
+		if step.OnFailure != "" && step.OnFailure != Abort && step.OnFailure != Continue {
+			return fmt.Errorf("task %s: step %s: invalid onFailure %q, must be %s or %s", name, step.Name, step.OnFailure, Abort, Continue)
+		}
+		if step.Timeout != "" {
+			if _, err := time.ParseDuration(step.Timeout); err != nil {
+				return fmt.Errorf("task %s: step %s: invalid timeout: %w", name, step.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Resolve returns the parameters of the task: the defaults overridden by the values.
+func (t Task) Resolve(name string, values map[string]string) (map[string]string, error) {
+	params := map[string]string{}
+	for param, value := range values {
+		if _, ok := t.Params[param]; !ok {
+			return nil, fmt.Errorf("task %s has no parameter %q", name, param)
+		}
+		params[param] = value
+	}
+
+	var missing []string
+	for param, value := range t.Params {
+		if _, ok := params[param]; ok {
+			continue
+		}
+		if value == nil {
+			missing = append(missing, param)
+			continue
+		}
+		params[param] = *value
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("task %s: missing required parameters %s", name, strings.Join(missing, ", "))
+	}
+	return params, nil
+}
+
+// Expand returns the arguments of the step with the parameters substituted.
+func (s Step) Expand(params map[string]string) ([]string, error) {
+	args := s.Command
+	if len(s.Talm) > 0 {
+		args = s.Talm
+	}
+
+	expanded := make([]string, 0, len(args))
+	for _, arg := range args {
+		tmpl, err := template.New(s.Name).Option("missingkey=error").Parse(arg)
+		if err != nil {
+			return nil, fmt.Errorf("step %s: %w", s.Name, err)
+		}
+		var out strings.Builder
+		if err := tmpl.Execute(&out, params); err != nil {
+			return nil, fmt.Errorf("step %s: %w", s.Name, err)
+		}
+		expanded = append(expanded, out.String())
+	}
+	return expanded, nil
+}
+
+// Runner runs the steps of tasks.
+type Runner struct {
+	// Talm is the command running talm, e.g. the path of the running binary
+	Talm []string
+	// Env is added to the environment of the steps
+	Env    []string
+	Stdout io.Writer
+	Stderr io.Writer
+	// DryRun prints the steps without running them
+	DryRun bool
+}
+
+// Run runs the steps of the task in order with the parameters.
+func (r Runner) Run(ctx context.Context, name string, task Task, params map[string]string) error {
+	if err := task.Check(name); err != nil {
+		return err
+	}
+
+	env := append(os.Environ(), r.Env...)
+	for param, value := range params {
+		env = append(env, "TALM_PARAM_"+strings.ToUpper(param)+"="+value)
+	}
+
+	for _, step := range task.Steps {
+		args, err := step.Expand(params)
+		if err != nil {
+			return fmt.Errorf("task %s: %w", name, err)
+		}
+		if len(step.Talm) > 0 {
+			args = append(append([]string(nil), r.Talm...), args...)
+		}
+
+		fmt.Fprintf(r.Stderr, "- talm: task %s, step %s: %s\n", name, step.Name, strings.Join(args, " "))
+		if r.DryRun {
+			continue
+		}
+
+		err = r.runStep(ctx, step, args, env)
+		if err == nil {
+			continue
+		}
+		if step.OnFailure == Continue {
+			fmt.Fprintf(r.Stderr, "Warning: task %s: step %s failed: %s, continuing\n", name, step.Name, err)
+			continue
+		}
+		return &StepError{Task: name, Step: step.Name, Err: err}
+	}
+	return nil
+}
+
+func (r Runner) runStep(ctx context.Context, step Step, args, env []string) error {
+	if step.Timeout != "" {
+		timeout, _ := time.ParseDuration(step.Timeout)
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = r.Stdout
+	cmd.Stderr = r.Stderr
+
+	err := cmd.Run()
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("timed out after %s", step.Timeout)
+	}
+	return err
+}
@@ -0,0 +1,92 @@
+package tasks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func ptr(s string) *string {
+	return &s
+}
+
+func TestResolve(t *testing.T) {
+	task := Task{Params: map[string]*string{"file": nil, "mode": ptr("auto")}}
+
+	params, err := task.Resolve("rollout", map[string]string{"file": "nodes/node1.yaml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params["file"] != "nodes/node1.yaml" || params["mode"] != "auto" {
+		t.Errorf("Resolve() = %v", params)
+	}
+
+	if _, err := task.Resolve("rollout", nil); err == nil || !strings.Contains(err.Error(), "missing required parameters file") {
+		t.Errorf("Resolve() error = %v, want missing file", err)
+	}
+	if _, err := task.Resolve("rollout", map[string]string{"file": "a", "nodes": "b"}); err == nil {
+		t.Error("expected an error for an unknown parameter")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	for _, task := range []Task{
+		{},
+		{Steps: []Step{{Talm: []string{"health"}}}},
+		{Steps: []Step{{Name: "a"}}},
+		{Steps: []Step{{Name: "a", Talm: []string{"health"}, Command: []string{"true"}}}},
+		{Steps: []Step{{Name: "a", Talm: []string{"health"}, OnFailure: "retry"}}},
+		{Steps: []Step{{Name: "a", Talm: []string{"health"}, Timeout: "soon"}}},
+		{Params: map[string]*string{"node-file": nil}, Steps: []Step{{Name: "a", Talm: []string{"health"}}}},
+	} {
+		if err := task.Check("t"); err == nil {
+			t.Errorf("expected an error for task %+v", task)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	task := Task{
+		Params: map[string]*string{"file": nil},
+		Steps: []Step{
+			{Name: "render", Talm: []string{"template", "-f", "{{ .file }}"}},
+			{Name: "check", Command: []string{"sh", "-c", "echo checking $TALM_PARAM_FILE; exit 2"}, OnFailure: Continue},
+			{Name: "apply", Talm: []string{"apply", "-f", "{{ .file }}"}},
+			{Name: "fail", Command: []string{"sh", "-c", "exit 3"}},
+			{Name: "never", Command: []string{"echo", "never"}},
+		},
+	}
+
+	var stdout, stderr bytes.Buffer
+	runner := Runner{Talm: []string{"echo", "talm"}, Stdout: &stdout, Stderr: &stderr}
+	err := runner.Run(context.Background(), "rollout", task, map[string]string{"file": "nodes/n1.yaml"})
+
+	var stepErr *StepError
+	var exitErr *exec.ExitError
+	if !errors.As(err, &stepErr) || stepErr.Step != "fail" || !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+		t.Fatalf("Run() error = %v, want step fail with exit code 3", err)
+	}
+	if want := "talm template -f nodes/n1.yaml\nchecking nodes/n1.yaml\ntalm apply -f nodes/n1.yaml\n"; stdout.String() != want {
+		t.Errorf("stdout = %q, want %q", stdout.String(), want)
+	}
+	if !strings.Contains(stderr.String(), "step check failed") {
+		t.Errorf("expected the warning of the check step in stderr:\n%s", stderr.String())
+	}
+
+	stdout.Reset()
+	runner.DryRun = true
+	if err := runner.Run(context.Background(), "rollout", task, map[string]string{"file": "nodes/n1.yaml"}); err != nil || stdout.Len() != 0 {
+		t.Errorf("dry run = %v, stdout %q", err, stdout.String())
+	}
+
+	if err := runner.Run(context.Background(), "rollout", task, nil); err == nil || !strings.Contains(err.Error(), "map has no entry for key") {
+		t.Errorf("Run() error = %v, want a missing parameter", err)
+	}
+}
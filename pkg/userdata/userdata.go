@@ -0,0 +1,119 @@
+// Package userdata packs rendered machine configs as user data of cloud instances: Talos reads
+// its config from the user data, encoded as the API or the tooling of the cloud expects, and
+// lays the files out as the provider tooling reads them, e.g. the NoCloud seed directories.
+package userdata
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Encodings of the user data.
+const (
+	Plain      = "plain"
+	Base64     = "base64"
+	Gzip       = "gzip"
+	GzipBase64 = "gzip+base64"
+)
+
+// Encodings lists the supported encodings.
+var Encodings = []string{Plain, Base64, Gzip, GzipBase64}
+
+// layouts maps the providers to the files of the user data of an instance, relative to the
+// output directory, and the extra files of the layout.
+var layouts = map[string]func(name string) (userData string, extra map[string]string){
+	"aws": func(name string) (string, map[string]string) {
+		return name + ".user-data", nil
+	},
+	"azure": func(name string) (string, map[string]string) {
+		return name + ".custom-data", nil
+	},
+	"gcp": func(name string) (string, map[string]string) {
+		return name + ".user-data", nil
+	},
+	"nocloud": func(name string) (string, map[string]string) {
+		return path.Join(name, "user-data"), map[string]string{
+			path.Join(name, "meta-data"): fmt.Sprintf("instance-id: %s\nlocal-hostname: %s\n", name, name),
+		}
+	},
+	"openstack": func(name string) (string, map[string]string) {
+		return path.Join(name, "openstack", "latest", "user_data"), nil
+	},
+}
+
+// Providers lists the providers with a file layout.
+func Providers() []string {
+	providers := make([]string, 0, len(layouts))
+	for provider := range layouts {
+		providers = append(providers, provider)
+	}
+	sort.Strings(providers)
+	return providers
+}
+
+// Check validates the encoding and the provider, an empty provider is valid.
+func Check(encoding, provider string) error {
+	valid := false
+	for _, e := range Encodings {
+		valid = valid || e == encoding
+	}
+	if !valid {
+		return fmt.Errorf("unknown user data encoding %q, use %s", encoding, strings.Join(Encodings, ", "))
+	}
+	if _, ok := layouts[provider]; provider != "" && !ok {
+		return fmt.Errorf("unknown provider %q, use %s", provider, strings.Join(Providers(), ", "))
+	}
+	return nil
+}
+
+// Encode encodes the config as user data.
+func Encode(config []byte, encoding string) ([]byte, error) {
+	switch encoding {
+	case Plain:
+		return config, nil
+	case Base64:
+		return []byte(base64.StdEncoding.EncodeToString(config) + "\n"), nil
+	case Gzip, GzipBase64:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(config); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		if encoding == GzipBase64 {
+			return []byte(base64.StdEncoding.EncodeToString(buf.Bytes()) + "\n"), nil
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, fmt.Errorf("unknown user data encoding %q", encoding)
+}
+
+// Files returns the files of the user data of the instance named name, by path relative to
+// the output directory. Without a provider the file is named <name>.user-data.
+func Files(provider, name string, userData []byte) (map[string][]byte, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return nil, fmt.Errorf("invalid instance name %q", name)
+	}
+
+	layout, ok := layouts[provider]
+	if !ok && provider != "" {
+		return nil, fmt.Errorf("unknown provider %q, use %s", provider, strings.Join(Providers(), ", "))
+	}
+	if !ok {
+		layout = layouts["aws"]
+	}
+
+	file, extra := layout(name)
+	files := map[string][]byte{file: userData}
+	for p, content := range extra {
+		files[p] = []byte(content)
+	}
+	return files, nil
+}
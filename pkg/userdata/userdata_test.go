@@ -0,0 +1,82 @@
+package userdata
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	config := []byte("version: v1alpha1\nmachine:\n    type: worker\n")
+
+	for _, encoding := range Encodings {
+		data, err := Encode(config, encoding)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if encoding == Base64 || encoding == GzipBase64 {
+			if data, err = base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data))); err != nil {
+				t.Fatalf("%s: %v", encoding, err)
+			}
+		}
+		if encoding == Gzip || encoding == GzipBase64 {
+			r, err := gzip.NewReader(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("%s: %v", encoding, err)
+			}
+			if data, err = io.ReadAll(r); err != nil {
+				t.Fatalf("%s: %v", encoding, err)
+			}
+		}
+		if !bytes.Equal(data, config) {
+			t.Errorf("%s: decoded %q, want %q", encoding, data, config)
+		}
+	}
+
+	if _, err := Encode(config, "zstd"); err == nil {
+		t.Error("expected an error for an unknown encoding")
+	}
+}
+
+func TestFiles(t *testing.T) {
+	for provider, expected := range map[string][]string{
+		"":          {"node1.user-data"},
+		"aws":       {"node1.user-data"},
+		"azure":     {"node1.custom-data"},
+		"nocloud":   {"node1/user-data", "node1/meta-data"},
+		"openstack": {"node1/openstack/latest/user_data"},
+	} {
+		files, err := Files(provider, "node1", []byte("data"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != len(expected) {
+			t.Errorf("%s: files %v, want %v", provider, files, expected)
+		}
+		for _, file := range expected {
+			if _, ok := files[file]; !ok {
+				t.Errorf("%s: missing file %s in %v", provider, file, files)
+			}
+		}
+	}
+
+	files, _ := Files("nocloud", "node1", []byte("data"))
+	if string(files["node1/meta-data"]) != "instance-id: node1\nlocal-hostname: node1\n" {
+		t.Errorf("unexpected meta-data %q", files["node1/meta-data"])
+	}
+
+	for _, name := range []string{"", "..", "a/b"} {
+		if _, err := Files("", name, nil); err == nil {
+			t.Errorf("expected an error for instance name %q", name)
+		}
+	}
+	if err := Check(Plain, "digitalocean"); err == nil {
+		t.Error("expected an error for an unknown provider")
+	}
+	if err := Check("zip", ""); err == nil {
+		t.Error("expected an error for an unknown encoding")
+	}
+}
@@ -0,0 +1,194 @@
+// Package validators runs external validators which review rendered configs before
+// they are applied and can veto the apply.
+//
+// Validators are commands or HTTP endpoints configured in Chart.yaml:
+//
+//	applyOptions:
+//	  validators:
+//	  - name: security-review
+//	    url: https://review.example.com/talos
+//	  - name: policy
+//	    command: ["conftest", "test", "-"]
+//	    timeout: 30s
+//
+// A command receives the config on stdin and the file and nodes in the TALM_FILE and
+// TALM_NODES environment variables, it vetoes the apply by exiting with a non-zero code.
+// An HTTP endpoint receives a POST request with the Request as JSON, it vetoes the apply
+// by responding with a non-2xx status or with a Response which is not allowed.
+package validators
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// defaultTimeout limits a validator run if no timeout is configured.
+const defaultTimeout = time.Minute
+
+// maxResponseSize limits the response read from HTTP validators.
+const maxResponseSize = 1 << 20
+
+// Config describes a validator in Chart.yaml, exactly one of Command and URL must be set.
+type Config struct {
+	Name    string            `yaml:"name"`
+	Command []string          `yaml:"command"`
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+	Timeout string            `yaml:"timeout"`
+}
+
+// Request is the config under review sent to the validators.
+type Request struct {
+	File   string   `json:"file"`
+	Nodes  []string `json:"nodes"`
+	DryRun bool     `json:"dryRun"`
+	Config string   `json:"config"`
+}
+
+// Response is the optional verdict returned by HTTP validators.
+type Response struct {
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason"`
+}
+
+// VetoError is returned when a validator rejects the config.
+type VetoError struct {
+	Validator string
+	Reason    string
+}
+
+func (e *VetoError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("validator %s rejected the config", e.Validator)
+	}
+	return fmt.Sprintf("validator %s rejected the config: %s", e.Validator, e.Reason)
+}
+
+// Check validates the configs of the validators without running them.
+func Check(configs []Config) error {
+	for _, cfg := range configs {
+		if cfg.Name == "" {
+			return errors.New("validator has no name")
+		}
+		if (len(cfg.Command) == 0) == (cfg.URL == "") {
+			return fmt.Errorf("validator %s: exactly one of command and url must be set", cfg.Name)
+		}
+		if cfg.Timeout != "" {
+			if _, err := time.ParseDuration(cfg.Timeout); err != nil {
+				return fmt.Errorf("validator %s: invalid timeout: %w", cfg.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Run runs the validators in order and returns a VetoError from the first one rejecting the config.
+// Validators which fail to run reject the config too.
+func Run(ctx context.Context, configs []Config, req Request) error {
+	if err := Check(configs); err != nil {
+		return err
+	}
+
+	for _, cfg := range configs {
+		timeout := defaultTimeout
+		if cfg.Timeout != "" {
+			timeout, _ = time.ParseDuration(cfg.Timeout)
+		}
+
+		runCtx, cancel := context.WithTimeout(ctx, timeout)
+		var err error
+		if cfg.URL != "" {
+			err = runHTTP(runCtx, cfg, req)
+		} else {
+			err = runCommand(runCtx, cfg, req)
+		}
+		cancel()
+
+		if err != nil {
+			var veto *VetoError
+			if errors.As(err, &veto) {
+				return err
+			}
+			return &VetoError{Validator: cfg.Name, Reason: err.Error()}
+		}
+	}
+
+	return nil
+}
+
+func runCommand(ctx context.Context, cfg Config, req Request) error {
+	cmd := exec.CommandContext(ctx, cfg.Command[0], cfg.Command[1:]...)
+	cmd.Stdin = strings.NewReader(req.Config)
+	cmd.Env = append(os.Environ(), "TALM_FILE="+req.File, "TALM_NODES="+strings.Join(req.Nodes, ","))
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		reason := strings.TrimSpace(output.String())
+		if reason == "" {
+			reason = exitErr.Error()
+		}
+		return &VetoError{Validator: cfg.Name, Reason: reason}
+	}
+
+	return err
+}
+
+func runHTTP(ctx context.Context, cfg Config, req Request) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	for name, value := range cfg.Headers {
+		httpReq.Header.Set(name, os.ExpandEnv(value))
+	}
+
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &VetoError{Validator: cfg.Name, Reason: fmt.Sprintf("%s: %s", resp.Status, strings.TrimSpace(string(data)))}
+	}
+
+	// An empty response allows the config
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+
+	var verdict Response
+	if err := json.Unmarshal(data, &verdict); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+	if !verdict.Allowed {
+		return &VetoError{Validator: cfg.Name, Reason: verdict.Reason}
+	}
+
+	return nil
+}
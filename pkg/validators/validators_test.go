@@ -0,0 +1,79 @@
+package validators
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestRunHTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		allowed := !strings.Contains(req.Config, "debug: true")
+		json.NewEncoder(w).Encode(Response{Allowed: allowed, Reason: "debug is not allowed"}) //nolint:errcheck
+	}))
+	defer server.Close()
+
+	t.Setenv("REVIEW_TOKEN", "token")
+	configs := []Config{{Name: "review", URL: server.URL, Headers: map[string]string{"Authorization": "Bearer ${REVIEW_TOKEN}"}}}
+
+	if err := Run(context.Background(), configs, Request{File: "nodes/node1.yaml", Config: "machine:\n  type: worker\n"}); err != nil {
+		t.Errorf("expected config to be allowed, got %v", err)
+	}
+
+	err := Run(context.Background(), configs, Request{File: "nodes/node1.yaml", Config: "debug: true\n"})
+	var veto *VetoError
+	if !errors.As(err, &veto) || veto.Reason != "debug is not allowed" {
+		t.Errorf("expected veto, got %v", err)
+	}
+
+	configs[0].Headers = nil
+	if err := Run(context.Background(), configs, Request{}); !errors.As(err, &veto) || !strings.Contains(veto.Reason, "401") {
+		t.Errorf("expected veto on error status, got %v", err)
+	}
+}
+
+func TestRunCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+
+	configs := []Config{{Name: "grep", Command: []string{"sh", "-c", `if grep -q debug; then echo "debug in $TALM_FILE"; exit 1; fi`}}}
+
+	if err := Run(context.Background(), configs, Request{File: "node1.yaml", Config: "machine: {}\n"}); err != nil {
+		t.Errorf("expected config to be allowed, got %v", err)
+	}
+
+	err := Run(context.Background(), configs, Request{File: "node1.yaml", Config: "debug: true\n"})
+	var veto *VetoError
+	if !errors.As(err, &veto) || veto.Reason != "debug in node1.yaml" {
+		t.Errorf("expected veto, got %v", err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	for _, configs := range [][]Config{
+		{{Command: []string{"true"}}},
+		{{Name: "both", Command: []string{"true"}, URL: "http://localhost"}},
+		{{Name: "none"}},
+		{{Name: "timeout", URL: "http://localhost", Timeout: "soon"}},
+	} {
+		if err := Check(configs); err == nil {
+			t.Errorf("expected error for %+v", configs)
+		}
+	}
+}
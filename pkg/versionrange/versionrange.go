@@ -0,0 +1,57 @@
+// Package versionrange checks the versions of the tools against the ranges a project pins,
+// like ">=0.6 <0.8", so every member of a team renders the configs with compatible tools.
+package versionrange
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/blang/semver/v4"
+)
+
+// Parse parses a range in the syntax of semver.ParseRange, e.g. ">=0.6.0 <0.8.0 || >=1.0.0".
+// Partial versions are completed with zeros, so ">=0.6 <0.8" is the same range.
+func Parse(constraint string) (semver.Range, error) {
+	fields := strings.Fields(constraint)
+	for i, field := range fields {
+		if field == "||" {
+			continue
+		}
+		version := strings.TrimLeft(field, "<>=!")
+		operator := field[:len(field)-len(version)]
+		version = strings.TrimPrefix(version, "v")
+
+		core, suffix := version, ""
+		if j := strings.IndexAny(version, "-+"); j >= 0 {
+			core, suffix = version[:j], version[j:]
+		}
+		for strings.Count(core, ".") < 2 {
+			core += ".0"
+		}
+		fields[i] = operator + core + suffix
+	}
+
+	r, err := semver.ParseRange(strings.Join(fields, " "))
+	if err != nil {
+		return nil, fmt.Errorf("invalid version range %q: %w", constraint, err)
+	}
+	return r, nil
+}
+
+// Check returns an error if the version of the tool is outside the range. Versions which are
+// not semantic versions, like development builds, are not checked.
+func Check(tool, constraint, version string) error {
+	r, err := Parse(constraint)
+	if err != nil {
+		return err
+	}
+
+	v, err := semver.ParseTolerant(version)
+	if err != nil {
+		return nil
+	}
+	if !r(v) {
+		return fmt.Errorf("%s %s is outside the range %q required by the project", tool, version, constraint)
+	}
+	return nil
+}
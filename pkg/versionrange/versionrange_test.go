@@ -0,0 +1,33 @@
+package versionrange
+
+import (
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	for _, test := range []struct {
+		constraint string
+		version    string
+		valid      bool
+	}{
+		{">=0.6 <0.8", "v0.6.0", true},
+		{">=0.6 <0.8", "0.7.12", true},
+		{">=0.6 <0.8", "v0.8.0", false},
+		{">=0.6 <0.8", "v0.5.9", false},
+		{">=v1.7", "v1.7.1", true},
+		{">=1.7.0", "v1.6.4", false},
+		{"<0.6 || >=1", "v1.2.0", true},
+		{"<0.6 || >=1", "v0.7.0", false},
+		{"=0.6.1-rc.1", "v0.6.1-rc.1", true},
+		{">=0.6 <0.8", "dev", true},
+	} {
+		err := Check("talm", test.constraint, test.version)
+		if (err == nil) != test.valid {
+			t.Errorf("%s in %q: expected valid %v, got %v", test.version, test.constraint, test.valid, err)
+		}
+	}
+
+	if err := Check("talm", ">=zero", "v0.6.0"); err == nil {
+		t.Error("expected an error for an invalid range")
+	}
+}
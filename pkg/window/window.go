@@ -0,0 +1,219 @@
+// Package window schedules disruptive operations into maintenance windows defined by
+// cron expressions and durations.
+package window
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxDuration bounds the duration of a window, windows are searched minute by minute.
+const maxDuration = 7 * 24 * time.Hour
+
+// searchHorizon bounds the search of the next opening of the windows.
+const searchHorizon = 366 * 24 * time.Hour
+
+// Window is a maintenance window, opening at the times of the schedule for the duration.
+type Window struct {
+	// Schedule is a cron expression with five fields: minute, hour, day of month, month and day of week.
+	Schedule string `yaml:"schedule"`
+	// Duration is how long the window stays open, like 4h.
+	Duration string `yaml:"duration"`
+	// Timezone is the IANA time zone of the schedule, the local time zone by default.
+	Timezone string `yaml:"timezone,omitempty"`
+}
+
+// schedule is a parsed window.
+type schedule struct {
+	minutes, hours, days, months, weekdays map[int]bool
+	// anyDay and anyWeekday are set for *, cron matches either field when both are restricted
+	anyDay, anyWeekday bool
+	duration           time.Duration
+	location           *time.Location
+}
+
+// Open reports whether one of the windows is open at the time, and otherwise the next time
+// one of them opens. No windows means no restriction.
+func Open(windows []Window, now time.Time) (bool, time.Time, error) {
+	if len(windows) == 0 {
+		return true, time.Time{}, nil
+	}
+
+	schedules := make([]*schedule, 0, len(windows))
+	for _, w := range windows {
+		s, err := parse(w)
+		if err != nil {
+			return false, time.Time{}, err
+		}
+		if s.open(now) {
+			return true, time.Time{}, nil
+		}
+		schedules = append(schedules, s)
+	}
+
+	var next time.Time
+	for _, s := range schedules {
+		if t, ok := s.next(now); ok && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+	return false, next, nil
+}
+
+// Validate checks the schedules, durations and time zones of the windows.
+func Validate(windows []Window) error {
+	for _, w := range windows {
+		if _, err := parse(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parse(w Window) (*schedule, error) {
+	fields := strings.Fields(w.Schedule)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("maintenance window %q: expected 5 fields: minute, hour, day of month, month and day of week", w.Schedule)
+	}
+
+	duration, err := time.ParseDuration(w.Duration)
+	if err != nil {
+		return nil, fmt.Errorf("maintenance window %q: invalid duration: %w", w.Schedule, err)
+	}
+	if duration < time.Minute || duration > maxDuration {
+		return nil, fmt.Errorf("maintenance window %q: duration must be between 1m and %s", w.Schedule, maxDuration)
+	}
+
+	location := time.Local
+	if w.Timezone != "" {
+		if location, err = time.LoadLocation(w.Timezone); err != nil {
+			return nil, fmt.Errorf("maintenance window %q: %w", w.Schedule, err)
+		}
+	}
+
+	s := &schedule{
+		duration:   duration,
+		location:   location,
+		anyDay:     fields[2] == "*",
+		anyWeekday: fields[4] == "*",
+	}
+	for _, field := range []struct {
+		set      *map[int]bool
+		value    string
+		min, max int
+		names    []string
+	}{
+		{&s.minutes, fields[0], 0, 59, nil},
+		{&s.hours, fields[1], 0, 23, nil},
+		{&s.days, fields[2], 1, 31, nil},
+		{&s.months, fields[3], 1, 12, []string{"", "jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}},
+		{&s.weekdays, fields[4], 0, 7, []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat", "sun"}},
+	} {
+		set, err := parseField(field.value, field.min, field.max, field.names)
+		if err != nil {
+			return nil, fmt.Errorf("maintenance window %q: %w", w.Schedule, err)
+		}
+		*field.set = set
+	}
+	// Sunday is 0 or 7
+	if s.weekdays[7] {
+		s.weekdays[0] = true
+	}
+
+	return s, nil
+}
+
+// parseField parses a comma separated list of *, values and ranges, with optional steps.
+func parseField(field string, min, max int, names []string) (map[int]bool, error) {
+	set := map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid step in %q", part)
+			}
+			part = part[:i]
+		}
+
+		low, high := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if low, err = parseValue(bounds[0], min, max, names); err != nil {
+				return nil, err
+			}
+			high = low
+			if len(bounds) == 2 {
+				if high, err = parseValue(bounds[1], min, max, names); err != nil {
+					return nil, err
+				}
+			} else if step > 1 {
+				high = max
+			}
+			if high < low {
+				return nil, fmt.Errorf("invalid range %q", part)
+			}
+		}
+
+		for v := low; v <= high; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+func parseValue(value string, min, max int, names []string) (int, error) {
+	for i, name := range names {
+		if name != "" && strings.EqualFold(value, name) {
+			return i, nil
+		}
+	}
+	v, err := strconv.Atoi(value)
+	if err != nil || v < min || v > max {
+		return 0, fmt.Errorf("invalid value %q, expected %d-%d", value, min, max)
+	}
+	return v, nil
+}
+
+// matches reports whether the window opens at the minute.
+func (s *schedule) matches(t time.Time) bool {
+	if !s.minutes[t.Minute()] || !s.hours[t.Hour()] || !s.months[int(t.Month())] {
+		return false
+	}
+	day, weekday := s.days[t.Day()], s.weekdays[int(t.Weekday())]
+	switch {
+	case s.anyDay && s.anyWeekday:
+		return true
+	case s.anyDay:
+		return weekday
+	case s.anyWeekday:
+		return day
+	default:
+		return day || weekday
+	}
+}
+
+// open reports whether the window opened within its duration before the time.
+func (s *schedule) open(now time.Time) bool {
+	now = now.In(s.location).Truncate(time.Minute)
+	for t := now; now.Sub(t) < s.duration; t = t.Add(-time.Minute) {
+		if s.matches(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// next returns the next time the window opens after the time.
+func (s *schedule) next(now time.Time) (time.Time, bool) {
+	start := now.In(s.location).Truncate(time.Minute).Add(time.Minute)
+	for t := start; t.Sub(start) < searchHorizon; t = t.Add(time.Minute) {
+		if s.matches(t) {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,91 @@
+package window
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOpen(t *testing.T) {
+	windows := []Window{
+		{Schedule: "0 2 * * sat,sun", Duration: "4h", Timezone: "UTC"},
+		{Schedule: "30 22 15 * *", Duration: "90m", Timezone: "UTC"},
+	}
+
+	for _, tc := range []struct {
+		now  string
+		open bool
+		next string
+	}{
+		// Saturday
+		{now: "2024-06-01T02:00:00Z", open: true},
+		{now: "2024-06-01T05:59:00Z", open: true},
+		{now: "2024-06-01T06:00:00Z", next: "2024-06-02T02:00:00Z"},
+		// Friday, the second window crosses midnight
+		{now: "2024-05-31T12:00:00Z", next: "2024-06-01T02:00:00Z"},
+		{now: "2024-07-15T23:59:00Z", open: true},
+		{now: "2024-07-16T00:00:00Z", next: "2024-07-20T02:00:00Z"},
+	} {
+		now, err := time.Parse(time.RFC3339, tc.now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		open, next, err := Open(windows, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if open != tc.open {
+			t.Errorf("%s: expected open %v, got %v", tc.now, tc.open, open)
+		}
+		if !tc.open && next.UTC().Format(time.RFC3339) != tc.next {
+			t.Errorf("%s: expected next window at %s, got %s", tc.now, tc.next, next.UTC().Format(time.RFC3339))
+		}
+	}
+
+	if open, _, err := Open(nil, time.Now()); err != nil || !open {
+		t.Errorf("expected no windows to be always open, got %v, %v", open, err)
+	}
+}
+
+func TestOpenTimezone(t *testing.T) {
+	windows := []Window{{Schedule: "0 2 * * *", Duration: "1h", Timezone: "Europe/Berlin"}}
+	// 02:30 in Berlin in summer
+	now := time.Date(2024, 6, 1, 0, 30, 0, 0, time.UTC)
+	if open, _, err := Open(windows, now); err != nil || !open {
+		t.Errorf("expected window open in the time zone of the schedule, got %v, %v", open, err)
+	}
+}
+
+func TestParseField(t *testing.T) {
+	set, err := parseField("*/15,7-9,mon", 0, 59, []string{"sun", "mon"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []int{0, 1, 7, 8, 9, 15, 30, 45} {
+		if !set[v] {
+			t.Errorf("expected %d in set", v)
+		}
+	}
+	if len(set) != 8 {
+		t.Errorf("unexpected set %v", set)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		window Window
+		err    string
+	}{
+		{Window{Schedule: "0 2 * *", Duration: "1h"}, "expected 5 fields"},
+		{Window{Schedule: "0 25 * * *", Duration: "1h"}, "invalid value"},
+		{Window{Schedule: "0 2 * * *", Duration: "soon"}, "invalid duration"},
+		{Window{Schedule: "0 2 * * *", Duration: "30d"}, "invalid duration"},
+		{Window{Schedule: "0 2 * * *", Duration: "200h"}, "duration must be"},
+		{Window{Schedule: "0 2 * * *", Duration: "1h", Timezone: "Mars/Olympus"}, "unknown time zone"},
+		{Window{Schedule: "5-1 2 * * *", Duration: "1h"}, "invalid range"},
+	} {
+		if err := Validate([]Window{tc.window}); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%+v: expected error %q, got %v", tc.window, tc.err, err)
+		}
+	}
+}
@@ -4,40 +4,167 @@ package yamltools
 
 import (
 	"bytes"
+	"strconv"
 	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
 // CopyComments updates the comments in dstNode considering the structure of whitespace.
+//
+// Comments are collected by path of the node: mapping values are addressed by their keys,
+// sequence items by their index. Aliases and merge keys are followed, so comments attached
+// to the anchored nodes are retained for every place they are used in.
 func CopyComments(srcNode, dstNode *yaml.Node, path string, dstPaths map[string]*yaml.Node) {
 	if srcNode.HeadComment != "" || srcNode.LineComment != "" || srcNode.FootComment != "" {
-		dstPaths[path] = srcNode
-	}
-
-	for i := 0; i < len(srcNode.Content); i++ {
-		newPath := path + "/" + srcNode.Content[i].Value
-		if srcNode.Kind == yaml.SequenceNode {
-			newPath = path + "/" + string(i)
+		if _, ok := dstPaths[commentPath(srcNode, path)]; !ok {
+			dstPaths[commentPath(srcNode, path)] = srcNode
 		}
-		CopyComments(srcNode.Content[i], dstNode, newPath, dstPaths)
 	}
+
+	walkChildren(srcNode, path, func(child *yaml.Node, childPath string) {
+		CopyComments(child, dstNode, childPath, dstPaths)
+	})
 }
 
 // ApplyComments applies the copied comments to the target document.
 func ApplyComments(dstNode *yaml.Node, path string, dstPaths map[string]*yaml.Node) {
-	if srcNode, ok := dstPaths[path]; ok {
+	if srcNode, ok := dstPaths[commentPath(dstNode, path)]; ok {
 		dstNode.HeadComment = mergeComments(dstNode.HeadComment, srcNode.HeadComment)
 		dstNode.LineComment = mergeComments(dstNode.LineComment, srcNode.LineComment)
 		dstNode.FootComment = mergeComments(dstNode.FootComment, srcNode.FootComment)
 	}
 
-	for i := 0; i < len(dstNode.Content); i++ {
-		newPath := path + "/" + dstNode.Content[i].Value
-		if dstNode.Kind == yaml.SequenceNode {
-			newPath = path + "/" + string(i)
+	walkChildren(dstNode, path, func(child *yaml.Node, childPath string) {
+		ApplyComments(child, childPath, dstPaths)
+	})
+}
+
+// commentPath distinguishes the document from its root node sharing the same path.
+func commentPath(node *yaml.Node, path string) string {
+	if node.Kind == yaml.DocumentNode {
+		return path + "#document"
+	}
+	return path
+}
+
+// walkChildren calls fn for every child of the node with its path.
+func walkChildren(node *yaml.Node, path string, fn func(child *yaml.Node, childPath string)) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			fn(child, path)
+		}
+	case yaml.AliasNode:
+		if node.Alias != nil {
+			walkChildren(node.Alias, path, fn)
+		}
+	case yaml.SequenceNode:
+		for i, child := range node.Content {
+			fn(child, path+"/"+strconv.Itoa(i))
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+
+			// Merge keys bring the keys of the anchored mappings to this level
+			if key.Value == "<<" && key.Tag == "!!merge" {
+				walkMerged(value, path, fn)
+				continue
+			}
+
+			fn(key, path+"/"+key.Value+"#key")
+			fn(value, path+"/"+key.Value)
+		}
+	}
+}
+
+func walkMerged(node *yaml.Node, path string, fn func(child *yaml.Node, childPath string)) {
+	switch node.Kind {
+	case yaml.AliasNode:
+		if node.Alias != nil {
+			walkChildren(node.Alias, path, fn)
+		}
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			walkMerged(item, path, fn)
+		}
+	case yaml.MappingNode:
+		walkChildren(node, path, fn)
+	}
+}
+
+// SetScalar sets the value of the scalar at the path of keys in the mapping document,
+// creating the missing mappings on the way.
+func SetScalar(node *yaml.Node, value string, keys ...string) {
+	if node.Kind == yaml.DocumentNode {
+		if len(node.Content) == 0 {
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.MappingNode})
+		}
+		node = node.Content[0]
+	}
+
+	for i, key := range keys {
+		var child *yaml.Node
+		for j := 0; j+1 < len(node.Content); j += 2 {
+			if node.Content[j].Value == key {
+				child = node.Content[j+1]
+				break
+			}
+		}
+
+		last := i == len(keys)-1
+		if child == nil {
+			child = &yaml.Node{Kind: yaml.MappingNode}
+			if last {
+				child = &yaml.Node{Kind: yaml.ScalarNode}
+			}
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, child)
+		}
+
+		if last {
+			child.Kind = yaml.ScalarNode
+			child.Tag = "!!str"
+			child.Value = value
+			child.Content = nil
+			return
+		}
+
+		if child.Kind != yaml.MappingNode {
+			// Only mappings can hold further keys
+			return
+		}
+		node = child
+	}
+}
+
+// DeletePath removes the key at the path of keys in the mapping document together with
+// the mappings left empty on the way. Missing keys are ignored.
+func DeletePath(node *yaml.Node, keys ...string) {
+	if node.Kind == yaml.DocumentNode {
+		if len(node.Content) == 0 {
+			return
+		}
+		node = node.Content[0]
+	}
+	if node.Kind != yaml.MappingNode || len(keys) == 0 {
+		return
+	}
+
+	for j := 0; j+1 < len(node.Content); j += 2 {
+		if node.Content[j].Value != keys[0] {
+			continue
+		}
+
+		child := node.Content[j+1]
+		if len(keys) > 1 {
+			DeletePath(child, keys[1:]...)
+			if child.Kind != yaml.MappingNode || len(child.Content) > 0 {
+				return
+			}
 		}
-		ApplyComments(dstNode.Content[i], newPath, dstPaths)
+		node.Content = append(node.Content[:j], node.Content[j+2:]...)
+		return
 	}
 }
 
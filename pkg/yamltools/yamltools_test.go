@@ -0,0 +1,118 @@
+package yamltools
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestCopyComments(t *testing.T) {
+	source := `machine:
+  kubelet:
+    extraArgs: &args
+      # rotate certs
+      rotate-server-certificates: "true"
+  install:
+    disk: /dev/sda # system disk
+  network:
+    interfaces:
+      - interface: eth0 # uplink
+      - interface: eth1 # unused
+  nodeLabels:
+    <<: *args
+cluster:
+  apiServer:
+    extraArgs: *args
+`
+	target := `machine:
+  kubelet:
+    extraArgs:
+      rotate-server-certificates: "true"
+  install:
+    disk: /dev/sda
+  network:
+    interfaces:
+      - interface: eth0
+      - interface: eth1
+  nodeLabels:
+    rotate-server-certificates: "true"
+cluster:
+  apiServer:
+    extraArgs:
+      rotate-server-certificates: "true"
+`
+
+	var sourceNode, targetNode yaml.Node
+	if err := yaml.Unmarshal([]byte(source), &sourceNode); err != nil {
+		t.Fatal(err)
+	}
+	if err := yaml.Unmarshal([]byte(target), &targetNode); err != nil {
+		t.Fatal(err)
+	}
+
+	dstPaths := map[string]*yaml.Node{}
+	CopyComments(&sourceNode, &targetNode, "", dstPaths)
+	ApplyComments(&targetNode, "", dstPaths)
+
+	out, err := yaml.Marshal(&targetNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for comment, count := range map[string]int{
+		"# rotate certs": 3,
+		"# system disk":  1,
+		"# uplink":       1,
+		"# unused":       1,
+	} {
+		if got := strings.Count(string(out), comment); got != count {
+			t.Errorf("expected %q %d times, got %d in:\n%s", comment, count, got, out)
+		}
+	}
+
+	if !strings.Contains(string(out), "disk: /dev/sda # system disk") {
+		t.Errorf("comment is not attached to its node:\n%s", out)
+	}
+}
+
+func TestSetScalar(t *testing.T) {
+	var node yaml.Node
+	if err := yaml.Unmarshal([]byte("machine:\n  type: worker\ncluster: {}\n"), &node); err != nil {
+		t.Fatal(err)
+	}
+
+	SetScalar(&node, "unknown", "machine", "type")
+	SetScalar(&node, "", "cluster", "controlPlane", "endpoint")
+
+	out, err := yaml.Marshal(&node)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "machine:\n    type: unknown\ncluster: {controlPlane: {endpoint: \"\"}}\n"
+	if string(out) != expected {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+}
+
+func TestDeletePath(t *testing.T) {
+	var node yaml.Node
+	if err := yaml.Unmarshal([]byte("cluster:\n  network:\n    podSubnets: [10.0.0.0/16]\n  clusterName: test\nmachine:\n  type: worker\n"), &node); err != nil {
+		t.Fatal(err)
+	}
+
+	DeletePath(&node, "cluster", "network", "podSubnets")
+	DeletePath(&node, "machine", "type")
+	DeletePath(&node, "machine", "missing")
+
+	out, err := yaml.Marshal(&node)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "cluster:\n    clusterName: test\n"
+	if string(out) != expected {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+}